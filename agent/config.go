@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// flagEnv is the environment variable behind each flag that has one; a set
+// variable outranks --config.
+var flagEnv = map[string]string{
+	"rpc":                      "RPC_URL",
+	"rpc-bearer-token":         "RPC_BEARER_TOKEN",
+	"rpc-basic-auth":           "RPC_BASIC_AUTH",
+	"private-key":              "AGENT_PK",
+	"etherscan-api-key":        "ETHERSCAN_API_KEY",
+	"defender-api-key":         "DEFENDER_API_KEY",
+	"defender-api-secret":      "DEFENDER_API_SECRET",
+	"agent":                    "AGENT_ADDRESS",
+	"api-token":                "API_TOKEN",
+	"webhook-secret":           "WEBHOOK_SECRET",
+	"telegram-bot-token":       "TELEGRAM_BOT_TOKEN",
+	"slack-webhook-url":        "SLACK_WEBHOOK_URL",
+	"slack-alerts-webhook-url": "SLACK_ALERTS_WEBHOOK_URL",
+	"pagerduty-routing-key":    "PAGERDUTY_ROUTING_KEY",
+}
+
+// secretFlags can't be written inline in a --config file, only by reference
+// as <key>_file (private_key has the private_key_file flag already), and
+// are redacted by config mode.
+var secretFlags = map[string]bool{
+	"private-key":              true,
+	"rpc-bearer-token":         true,
+	"rpc-basic-auth":           true,
+	"etherscan-api-key":        true,
+	"defender-api-key":         true,
+	"defender-api-secret":      true,
+	"api-token":                true,
+	"webhook-secret":           true,
+	"telegram-bot-token":       true,
+	"slack-webhook-url":        true,
+	"slack-alerts-webhook-url": true,
+	"pagerduty-routing-key":    true,
+}
+
+// flagAliases maps a flag's other names to it. A --config file may use
+// either; config mode lists only the flag itself.
+var flagAliases = map[string]string{
+	"format": "output",
+}
+
+// configFile is a parsed --config file: flag names to their values, several
+// for a list.
+type configFile map[string][]string
+
+// loadConfigFile reads a flat YAML (.yaml, .yml) or TOML (.toml) file.
+func loadConfigFile(path string) (configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read --config: %w", err)
+	}
+	var cfg configFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		cfg, err = parseYAMLConfig(data)
+	case ".toml":
+		cfg, err = parseTOMLConfig(data)
+	default:
+		return nil, fmt.Errorf("--config %s: want a .yaml, .yml or .toml file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("--config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// parseYAMLConfig reads top-level "key: value" pairs. A list is either
+// [a, b] or "key:" followed by indented "- item" lines; nothing else nests.
+func parseYAMLConfig(data []byte) (configFile, error) {
+	cfg := configFile{}
+	var list string // key whose "- item" lines follow
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(stripComment(sc.Text()), " \t")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if list == "" || !strings.HasPrefix(trimmed, "- ") {
+				return nil, fmt.Errorf("line %d: nested keys are not supported", n)
+			}
+			v, err := configScalar(strings.TrimSpace(trimmed[2:]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			cfg[list] = append(cfg[list], v)
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: want key: value", n)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if _, dup := cfg[key]; dup {
+			return nil, fmt.Errorf("line %d: %s given twice", n, key)
+		}
+		list = ""
+		if value == "" {
+			list, cfg[key] = key, nil
+			continue
+		}
+		vs, err := configValues(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		cfg[key] = vs
+	}
+	return cfg, sc.Err()
+}
+
+// parseTOMLConfig reads top-level "key = value" pairs, values being strings,
+// numbers, booleans or one-line arrays. Tables aren't supported.
+func parseTOMLConfig(data []byte) (configFile, error) {
+	cfg := configFile{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(sc.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: tables are not supported", n)
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: want key = value", n)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		if _, dup := cfg[key]; dup {
+			return nil, fmt.Errorf("line %d: %s given twice", n, key)
+		}
+		vs, err := configValues(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		cfg[key] = vs
+	}
+	return cfg, sc.Err()
+}
+
+// stripComment drops a # comment that isn't inside quotes.
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// configValues parses a scalar or a [a, b] list.
+func configValues(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "[") {
+		v, err := configScalar(value)
+		return []string{v}, err
+	}
+	if !strings.HasSuffix(value, "]") {
+		return nil, fmt.Errorf("unterminated list %s", value)
+	}
+	var vs []string
+	for _, item := range strings.Split(value[1:len(value)-1], ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		v, err := configScalar(item)
+		if err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+	}
+	return vs, nil
+}
+
+// configScalar unquotes a "double" or 'single' quoted string; anything else
+// is taken as written.
+func configScalar(s string) (string, error) {
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("bad string %s", s)
+		}
+		return v, nil
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return s[1 : len(s)-1], nil
+	}
+	return s, nil
+}
+
+// Where a flag's effective value came from, for config mode.
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// applyConfigFile sets the flags of fs that cfg names, keys being flag
+// names with - or _, unless the command line or the flag's environment
+// variable already gave them. A secret's <key>_file is read from disk. It
+// returns where each flag's value came from.
+func applyConfigFile(fs *flag.FlagSet, cfg configFile, getenv func(string) string) (map[string]string, error) {
+	sources := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		sources[f.Name] = sourceDefault
+		if env, ok := flagEnv[f.Name]; ok && getenv(env) != "" {
+			sources[f.Name] = sourceEnv
+		}
+	})
+	fs.Visit(func(f *flag.Flag) {
+		sources[f.Name] = sourceFlag
+		if name, ok := flagAliases[f.Name]; ok {
+			sources[name] = sourceFlag
+		}
+	})
+
+	keys := make([]string, 0, len(cfg))
+	for k := range cfg {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name, values := strings.ReplaceAll(key, "_", "-"), cfg[key]
+		if alias, ok := flagAliases[name]; ok {
+			name = alias
+		}
+		if base := strings.TrimSuffix(name, "-file"); base != name && secretFlags[base] && fs.Lookup(name) == nil {
+			// A secret by reference: the file holds the value.
+			if len(values) != 1 {
+				return nil, fmt.Errorf("--config: %s takes one path", key)
+			}
+			data, err := os.ReadFile(values[0])
+			if err != nil {
+				return nil, fmt.Errorf("--config: %s: %w", key, err)
+			}
+			name, values = base, []string{strings.TrimSpace(string(data))}
+		} else if secretFlags[name] {
+			return nil, fmt.Errorf("--config: %s must not be inline; point %s_file at a file holding it", key, strings.ReplaceAll(name, "-", "_"))
+		}
+		f := fs.Lookup(name)
+		if f == nil || name == "config" {
+			return nil, fmt.Errorf("--config: unknown key %s", key)
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("--config: %s has no value", key)
+		}
+		if _, repeatable := f.Value.(*stringsFlag); !repeatable && len(values) > 1 {
+			return nil, fmt.Errorf("--config: %s takes one value", key)
+		}
+		if sources[name] != sourceDefault {
+			continue // the command line or the environment wins
+		}
+		for _, v := range values {
+			if err := fs.Set(name, v); err != nil {
+				return nil, fmt.Errorf("--config: %s: %w", key, err)
+			}
+		}
+		sources[name] = sourceFile
+	}
+	return sources, nil
+}
+
+// printConfig is config mode: every flag's effective value as a YAML
+// --config file would give it, with where it came from. Secrets are
+// redacted.
+func printConfig(w io.Writer, fs *flag.FlagSet, sources map[string]string) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, alias := flagAliases[f.Name]; alias || f.Name == "config" {
+			return
+		}
+		value := strconv.Quote(f.Value.String())
+		if list, ok := f.Value.(*stringsFlag); ok {
+			items := make([]string, len(*list.p))
+			for i, v := range *list.p {
+				items[i] = strconv.Quote(v)
+			}
+			value = "[" + strings.Join(items, ", ") + "]"
+		}
+		if secretFlags[f.Name] && f.Value.String() != "" {
+			value = `"<redacted>"`
+		}
+		fmt.Fprintf(w, "%s: %s # %s\n", strings.ReplaceAll(f.Name, "-", "_"), value, sources[f.Name])
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigFiles(t *testing.T) {
+	want := configFile{
+		"rpc":        {"wss://node.example/ws"},
+		"chain_id":   {"1"},
+		"rpc_header": {"X-Api-Key=abc", "X-Team=ops"},
+		"dry-run":    {"true"},
+	}
+	yaml := `---
+# agent settings
+rpc: "wss://node.example/ws" # primary
+chain_id: 1
+rpc_header:
+  - X-Api-Key=abc
+  - 'X-Team=ops'
+dry-run: true
+`
+	got, err := parseYAMLConfig([]byte(yaml))
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("yaml: got %v, %v; want %v", got, err, want)
+	}
+	toml := `# agent settings
+rpc = "wss://node.example/ws"
+chain_id = 1
+rpc_header = ["X-Api-Key=abc", 'X-Team=ops']
+dry-run = true
+`
+	got, err = parseTOMLConfig([]byte(toml))
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("toml: got %v, %v; want %v", got, err, want)
+	}
+
+	for name, bad := range map[string]func() error{
+		"yaml nesting": func() error { _, err := parseYAMLConfig([]byte("gas:\n  max: 1\n")); return err },
+		"yaml twice":   func() error { _, err := parseYAMLConfig([]byte("rpc: a\nrpc: b\n")); return err },
+		"toml table":   func() error { _, err := parseTOMLConfig([]byte("[gas]\nmax = 1\n")); return err },
+	} {
+		if bad() == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
+
+func testFlagSet() (*flag.FlagSet, *string, *uint64, *[]string) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	rpc := fs.String("rpc", "", "")
+	chain := fs.Uint64("chain-id", 0, "")
+	keys := &[]string{}
+	fs.Var(&stringsFlag{p: keys}, "private-key", "")
+	fs.Bool("dry-run", false, "")
+	fs.String("defender-api-secret", "", "")
+	return fs, rpc, chain, keys
+}
+
+func TestApplyConfigFilePrecedence(t *testing.T) {
+	fs, rpc, chain, _ := testFlagSet()
+	if err := fs.Parse([]string{"--chain-id", "5"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := configFile{"rpc": {"http://file"}, "chain_id": {"1"}, "dry_run": {"true"}}
+	env := map[string]string{}
+	sources, err := applyConfigFile(fs, cfg, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *rpc != "http://file" || sources["rpc"] != sourceFile {
+		t.Errorf("rpc = %q from %s, want the file's", *rpc, sources["rpc"])
+	}
+	if *chain != 5 || sources["chain-id"] != sourceFlag {
+		t.Errorf("chain-id = %d from %s, want the flag's 5", *chain, sources["chain-id"])
+	}
+
+	// A set RPC_URL outranks the file.
+	fs, rpc, _, _ = testFlagSet()
+	*rpc = "http://env"
+	env["RPC_URL"] = "http://env"
+	if sources, err = applyConfigFile(fs, cfg, func(k string) string { return env[k] }); err != nil {
+		t.Fatal(err)
+	}
+	if *rpc != "http://env" || sources["rpc"] != sourceEnv {
+		t.Errorf("rpc = %q from %s, want the environment's", *rpc, sources["rpc"])
+	}
+}
+
+func TestApplyConfigFileErrors(t *testing.T) {
+	getenv := func(string) string { return "" }
+	for name, cfg := range map[string]configFile{
+		"unknown key":    {"rcp": {"x"}},
+		"inline secret":  {"private_key": {"0xabc"}},
+		"list to scalar": {"rpc": {"a", "b"}},
+		"bad value":      {"chain_id": {"one"}},
+		"config itself":  {"config": {"other.yaml"}},
+	} {
+		fs, _, _, _ := testFlagSet()
+		fs.String("config", "", "")
+		if _, err := applyConfigFile(fs, cfg, getenv); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
+
+func TestConfigSecretByReferenceRedacted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fs, _, _, _ := testFlagSet()
+	sources, err := applyConfigFile(fs, configFile{"defender_api_secret_file": {path}}, func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := fs.Lookup("defender-api-secret").Value.String(); v != "s3cret" {
+		t.Errorf("secret = %q, want the file's contents", v)
+	}
+	var out bytes.Buffer
+	printConfig(&out, fs, sources)
+	if strings.Contains(out.String(), "s3cret") || !strings.Contains(out.String(), `defender_api_secret: "<redacted>" # file`) {
+		t.Errorf("config print leaks or misses the secret:\n%s", out.String())
+	}
+}
+
+// --format is an alias of --output: a file may use either name, the command
+// line's alias still wins, and config mode lists only --output.
+func TestConfigOutputAlias(t *testing.T) {
+	newFlags := func() (*flag.FlagSet, *string) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		var format string
+		fs.StringVar(&format, "output", "text", "")
+		fs.StringVar(&format, "format", "text", "")
+		return fs, &format
+	}
+	fs, format := newFlags()
+	sources, err := applyConfigFile(fs, configFile{"format": {"json"}}, func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *format != "json" || sources["output"] != sourceFile {
+		t.Errorf("output = %q from %s, want the file's json", *format, sources["output"])
+	}
+	var out bytes.Buffer
+	printConfig(&out, fs, sources)
+	if out.String() != "output: \"json\" # file\n" {
+		t.Errorf("config print:\n%s", out.String())
+	}
+
+	fs, format = newFlags()
+	if err := fs.Parse([]string{"--format", "csv"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applyConfigFile(fs, configFile{"output": {"json"}}, func(string) string { return "" }); err != nil {
+		t.Fatal(err)
+	}
+	if *format != "csv" {
+		t.Errorf("output = %q, want the command line's csv", *format)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// bigFlag adapts a *big.Int destination to flag.Value so wei amounts can be
+// passed verbatim without overflowing uint64.
+type bigFlag struct{ p **big.Int }
+
+func (f bigFlag) String() string {
+	if f.p == nil || *f.p == nil {
+		return ""
+	}
+	return (*f.p).String()
+}
+
+func (f bigFlag) Set(s string) error {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok || v.Sign() < 0 {
+		return fmt.Errorf("invalid wei amount %q", s)
+	}
+	*f.p = v
+	return nil
+}
+
+// stringsFlag collects a repeatable string flag. The first value given on the
+// command line replaces the default (e.g. one taken from the environment).
+type stringsFlag struct {
+	p       *[]string
+	changed bool
+}
+
+func (f *stringsFlag) String() string {
+	if f == nil || f.p == nil {
+		return ""
+	}
+	return strings.Join(*f.p, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	if !f.changed {
+		*f.p = nil
+		f.changed = true
+	}
+	*f.p = append(*f.p, s)
+	return nil
+}
+
+// gweiFlag is like bigFlag but takes a (possibly fractional) gwei amount and stores wei.
+type gweiFlag struct{ p **big.Int }
+
+func (f gweiFlag) String() string {
+	if f.p == nil || *f.p == nil {
+		return ""
+	}
+	return new(big.Rat).SetFrac(*f.p, big.NewInt(params.GWei)).FloatString(9)
+}
+
+func (f gweiFlag) Set(s string) error {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || r.Sign() < 0 {
+		return fmt.Errorf("invalid gwei amount %q", s)
+	}
+	r.Mul(r, new(big.Rat).SetInt64(params.GWei))
+	*f.p = new(big.Int).Quo(r.Num(), r.Denom())
+	return nil
+}
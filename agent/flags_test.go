@@ -0,0 +1,19 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestStringsFlagReplacesDefault(t *testing.T) {
+	vals := []string{"from-env"}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&stringsFlag{p: &vals}, "k", "")
+	if err := fs.Parse([]string{"-k", "a", "-k", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(vals, ",") != "a,b" {
+		t.Fatalf("vals = %v, want [a b]", vals)
+	}
+}
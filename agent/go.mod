@@ -1,8 +1,11 @@
 module twap-agent
 
-go 1.19
+go 1.21
 
-require github.com/ethereum/go-ethereum v1.11.5
+require (
+	github.com/ethereum/go-ethereum v1.11.5
+	golang.org/x/crypto v0.22.0
+)
 
 require (
 	github.com/StackExchange/wmi v1.2.1 // indirect
@@ -19,7 +22,6 @@ require (
 	github.com/stretchr/testify v1.8.2 // indirect
 	github.com/tklauser/go-sysconf v0.3.12 // indirect
 	github.com/tklauser/numcpus v0.6.1 // indirect
-	golang.org/x/crypto v0.22.0 // indirect
 	golang.org/x/exp v0.0.0-20231110203233-9a3e6036ecaa // indirect
 	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.22.0 // indirect
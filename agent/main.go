@@ -2,411 +2,259 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
-	"math/big"
+	"log/slog"
 	"os"
-	"strings"
-	"time"
+	"os/signal"
+	"syscall"
 
-	"github.com/ethereum/go-ethereum"
-	"github.com/ethereum/go-ethereum/accounts/abi"
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
-	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"twap-agent/twapagent"
 )
 
-type Strategy struct {
-	TokenIn              common.Address
-	TokenOut             common.Address
-	Adapter              common.Address
-	PriceOracle          common.Address
-	TotalAmountIn        *big.Int
-	SliceAmountIn        *big.Int
-	StartTime            *big.Int
-	EndTime              *big.Int
-	MaxSlippageBps       uint16
-	MaxPriceDeviationBps uint16
-}
-
 func main() {
-	var (
-		rpcURL      string
-		contractHex string
-		privHex     string
-		chainID     uint64
-		abiPath     string
-		mode        string
-	)
+	cfg := twapagent.DefaultConfig()
+	var configPath string
 
 	// args & env
-	flag.StringVar(&rpcURL, "rpc", os.Getenv("RPC_URL"), "WebSocket RPC URL (ws:// or wss://)")
-	flag.StringVar(&contractHex, "contract", "", "Twap contract address")
-	defaultAgentPK := os.Getenv("AGENT_PK")
-	flag.StringVar(&privHex, "private-key", defaultAgentPK, "Agent private key hex (env AGENT_PK)")
-	flag.Uint64Var(&chainID, "chain-id", 0, "Chain ID")
-	flag.StringVar(&abiPath, "abi", "out/Twap.sol/Twap.json", "Path to Twap.json artifact")
-	flag.StringVar(&mode, "mode", "preflight", "Mode: preflight|bot")
+	flag.StringVar(&configPath, "config", "", "YAML (.yaml, .yml) or TOML (.toml) file of flag settings, keys named like the flags (chain_id or chain-id); command-line flags and environment variables take precedence")
+	flag.StringVar(&cfg.RPC, "rpc", os.Getenv("RPC_URL"), "RPC URL; bot mode subscribes over ws:// or wss:// and polls over http(s)://")
+	flag.Var(&stringsFlag{p: &cfg.RPCAuth.Headers}, "rpc-header", "Extra header for --rpc and --tx-rpc as key=value; repeatable")
+	flag.StringVar(&cfg.RPCAuth.BearerToken, "rpc-bearer-token", os.Getenv("RPC_BEARER_TOKEN"), "Send Authorization: Bearer <token> to --rpc and --tx-rpc (env RPC_BEARER_TOKEN)")
+	flag.StringVar(&cfg.RPCAuth.BasicAuth, "rpc-basic-auth", os.Getenv("RPC_BASIC_AUTH"), "Send HTTP basic auth user:password to --rpc and --tx-rpc (env RPC_BASIC_AUTH)")
+	flag.StringVar(&cfg.TxRPC, "tx-rpc", "", "RPC URL for gas queries, nonces and submissions (defaults to --rpc)")
+	flag.Var(&stringsFlag{p: &cfg.Contracts}, "contract", "Twap contract address or ENS name; repeat in bot mode to run several vaults")
+	flag.StringVar(&cfg.Factory.Address, "factory", "", "In bot mode, also run the vaults this factory (address or ENS name) creates, as its --factory-event announces them")
+	flag.StringVar(&cfg.Factory.Event, "factory-event", "", `The factory's creation event, e.g. "VaultCreated(address indexed vault, address owner)"`)
+	flag.StringVar(&cfg.Factory.VaultArg, "factory-vault-arg", "", "The --factory-event argument holding the vault (default: its first address)")
+	flag.Uint64Var(&cfg.Factory.FromBlock, "factory-from-block", 0, "Find the factory's vaults from this block on, e.g. its deployment block")
+	flag.StringVar(&cfg.Factory.OnlyTokenIn, "only-token-in", "", "Only run the factory's vaults selling this token (address or symbol)")
+	flag.Var(&stringsFlag{p: &cfg.Factory.OnlyStatus}, "only-status", "Only run the factory's vaults whose order has this status (Open or PartialFilled); repeatable")
+	if pk := os.Getenv("AGENT_PK"); pk != "" {
+		cfg.Signer.Keys.Hex = []string{pk}
+	}
+	flag.Var(&stringsFlag{p: &cfg.Signer.Keys.Hex}, "private-key", "Agent private key hex (env AGENT_PK); repeat for several agents")
+	flag.Var(&stringsFlag{p: &cfg.Signer.Keys.File}, "private-key-file", "File holding an agent private key hex; repeat for several agents")
+	flag.StringVar(&cfg.Signer.Keys.Keystore, "keystore", "", "Agent key as a go-ethereum UTC JSON keystore file")
+	flag.StringVar(&cfg.Signer.Keys.PasswordFile, "keystore-password-file", "", "File holding the keystore password (prompted for if unset)")
+	flag.StringVar(&cfg.Signer.Keys.Mnemonic, "mnemonic-file", "", "Derive the agent key from the BIP-39 mnemonic in this file")
+	flag.StringVar(&cfg.Signer.Keys.MnemonicPassphrase, "mnemonic-passphrase-file", "", "File holding the optional BIP-39 passphrase")
+	flag.StringVar(&cfg.Signer.Keys.HDPath, "hd-path", cfg.Signer.Keys.HDPath, "BIP-32 derivation path used with --mnemonic-file")
+	flag.StringVar(&cfg.Signer.KMSKeyID, "kms-key-id", "", "Sign with this AWS KMS secp256k1 key (id, alias or ARN) instead of a local key")
+	flag.StringVar(&cfg.Signer.KMSRegion, "kms-region", "", "AWS region of the KMS key (default from the ARN or AWS_REGION)")
+	flag.StringVar(&cfg.Signer.RemoteURL, "remote-signer-url", "", "Sign via a remote signer's eth_signTransaction (web3signer, clef) instead of a local key")
+	flag.StringVar(&cfg.Signer.From, "from", "", "Agent address for --remote-signer-url (default: the signer's only account); gas estimate sender for --unsigned-out and caller in simulate mode (default: the contract's agent)")
+	flag.Uint64Var(&cfg.ChainID, "chain-id", 0, "Chain ID")
+	flag.StringVar(&cfg.ABIPath, "abi", "", "Use this ABI (Foundry artifact or ABI JSON) instead of the embedded one")
+	flag.StringVar(&cfg.ABISource, "abi-source", "", "Fetch the contract's verified ABI instead: etherscan|sourcify")
+	flag.StringVar(&cfg.EtherscanAPIKey, "etherscan-api-key", os.Getenv("ETHERSCAN_API_KEY"), "Etherscan API key for --abi-source etherscan (env ETHERSCAN_API_KEY)")
+	flag.StringVar(&cfg.ABICacheDir, "abi-cache-dir", cfg.ABICacheDir, "Where --abi-source keeps fetched ABIs")
+	flag.BoolVar(&cfg.ABIRefresh, "abi-refresh", false, "Fetch the ABI again even if it is cached")
+	flag.StringVar(&cfg.Mode, "mode", "preflight", "Mode: preflight|bot|once|execute|watch|report|propose|cancel|deposit|withdraw|deploy|validate|events|replay|simulate|schedule|devnet|config")
+	flag.StringVar(&cfg.ReceiptsFile, "receipts-file", cfg.ReceiptsFile, "File where mined executeSlice receipts are recorded for gas accounting")
+	flag.StringVar(&cfg.StateFile, "state-file", "", "In bot mode, keep the last handled block, pending txs and retry state here; on start backfill the contract logs emitted since and reconcile the pending txs")
+	flag.StringVar(&cfg.Tx.TxType, "tx-type", cfg.Tx.TxType, "Transaction pricing: legacy|dynamic|auto")
+	flag.Var(gweiFlag{&cfg.Tx.PriorityFee}, "priority-fee-gwei", "Priority fee (tip) in gwei, added on top of the base fee, or of the suggested gas price on a chain without one")
+	flag.Var(gweiFlag{&cfg.Tx.FeeCap}, "fee-cap-gwei", "Max fee per gas in gwei; with --priority-fee-gwei overrides suggested pricing entirely")
+	flag.Var(bigFlag{&cfg.Tx.MaxGasPrice}, "max-gas-price", "Defer slices while the gas price exceeds this many wei")
+	flag.Var(bigFlag{&cfg.Tx.MaxFeePerGas}, "max-fee-per-gas", "Defer slices while the EIP-1559 maxFeePerGas exceeds this many wei")
+	flag.Uint64Var(&cfg.Tx.CeilingGrace, "gas-ceiling-grace", cfg.Tx.CeilingGrace, "Ignore the gas ceiling once a slice is overdue by more than this many seconds")
+	flag.Uint64Var(&cfg.Tx.BumpAfter, "bump-after", cfg.Tx.BumpAfter, "Replace a pending tx with a higher-fee copy after this many seconds (0 disables)")
+	flag.Float64Var(&cfg.Tx.BumpPercent, "bump-percent", cfg.Tx.BumpPercent, "Fee increase per replacement, in percent")
+	flag.IntVar(&cfg.Tx.MaxBumps, "max-bumps", cfg.Tx.MaxBumps, "Maximum number of fee bumps per transaction")
+	flag.Uint64Var(&cfg.Tx.GasLimit, "gas-limit", 0, "Fixed gas limit for executeSlice (0 = estimate)")
+	flag.Uint64Var(&cfg.Tx.GasBufferPercent, "gas-buffer-percent", cfg.Tx.GasBufferPercent, "Extra gas added on top of the estimate, in percent")
+	flag.BoolVar(&cfg.Tx.SkipSimulation, "skip-simulation", false, "Submit executeSlice without simulating it via eth_call first")
+	flag.DurationVar(&cfg.Tx.WaitTimeout, "wait-timeout", cfg.Tx.WaitTimeout, "Stop waiting for a receipt after this long (0 waits forever)")
+	flag.DurationVar(&cfg.Tx.ReceiptPollInterval, "receipt-poll-interval", cfg.Tx.ReceiptPollInterval, "How often to poll for a receipt")
+	flag.Uint64Var(&cfg.Tx.Confirmations, "confirmations", 0, "Act on a receipt, and in bot mode on contract events, only once this many blocks have built on its block (0 = at once)")
+	flag.StringVar(&cfg.PrivateRPC, "private-rpc", "", "Send signed txs to this private relay RPC instead of the public mempool")
+	flag.Uint64Var(&cfg.PrivateFallbackBlocks, "private-fallback-blocks", 0, "Re-broadcast publicly if a private tx isn't included within this many blocks (0 = never)")
+	flag.StringVar(&cfg.DefenderAPIKey, "defender-api-key", os.Getenv("DEFENDER_API_KEY"), "Send executeSlice through this OpenZeppelin Defender Relayer API key instead of signing locally (env DEFENDER_API_KEY)")
+	flag.StringVar(&cfg.DefenderAPISecret, "defender-api-secret", os.Getenv("DEFENDER_API_SECRET"), "Secret for --defender-api-key (env DEFENDER_API_SECRET)")
+	flag.DurationVar(&cfg.Tx.TxDeadline, "tx-deadline", 0, "Cancel a pending executeSlice tx after this long and re-evaluate (0 disables)")
+	flag.StringVar(&cfg.Safe.Address, "safe-address", "", "Safe that calls the vault (propose mode)")
+	flag.StringVar(&cfg.Safe.ServiceURL, "safe-service-url", "", "Safe Transaction Service base URL, e.g. https://safe-transaction-mainnet.safe.global (propose mode)")
+	flag.StringVar(&cfg.Safe.Call, "propose-call", cfg.Safe.Call, "Call to propose to the Safe: executeSlice (next due slice) or cancel")
+	flag.StringVar(&cfg.Tx.UnsignedOut, "unsigned-out", "", "Write the next executeSlice call as unsigned JSON to this file (\"-\" = stdout) instead of signing; preflight and bot modes")
+	flag.Uint64Var(&cfg.Tx.LeadTimeSeconds, "lead-time-seconds", 0, "Submit a slice up to this many seconds before it is due when the next block is expected to reach its schedule (0 = only once a block has)")
+	flag.DurationVar(&cfg.Tx.ProgressInterval, "progress-interval", cfg.Tx.ProgressInterval, "In bot mode, log the order's progress and ETA this often besides after each fill (0 = only after fills)")
+	flag.StringVar(&cfg.OracleABI, "oracle-abi", cfg.OracleABI, "How to read the oracle for the price deviation check: ioracle (getPrice), chainlink (AggregatorV3 latestRoundData), or a JSON ABI file with either")
+	flag.StringVar(&cfg.OracleAddress, "oracle-address", "", "Read prices from this oracle instead of the strategy's priceOracle, e.g. the Chainlink feed behind it")
+	flag.StringVar(&cfg.Tx.Quoter.Kind, "adapter-kind", "", "Venue to quote the expected amountOut of a slice from: uniswap-v2 (router getAmountsOut), uniswap-v3-quoter (QuoterV2) or generic (getAmountOut(address,address,uint256)); unset = no quote")
+	flag.StringVar(&cfg.QuoteAddress, "quote-address", "", "Contract to ask for quotes instead of the strategy's adapter, e.g. the router or quoter it swaps through")
+	flag.UintVar(&cfg.UniswapV3Fee, "uniswap-v3-fee", cfg.UniswapV3Fee, "Pool fee tier quoted with --adapter-kind uniswap-v3-quoter, in hundredths of a bip")
+	flag.StringVar(&cfg.EthUSDFeed, "eth-usd-feed", "", "Chainlink ETH/USD feed to value gas costs in dollars (preflight, bot receipts and the TWAP summary)")
+	flag.Var(&stringsFlag{p: &cfg.TokenUSDFeeds}, "token-usd-feed", "TOKEN=FEED: Chainlink USD feed of tokenIn or tokenOut, to show slices and fills in dollars; repeatable")
+	flag.StringVar(&cfg.FeedRegistry, "feed-registry", "", "Chainlink Feed Registry to find the USD feed of ETH and of tokens without --eth-usd-feed or --token-usd-feed")
+	flag.DurationVar(&cfg.USDMaxAge, "usd-max-age", cfg.USDMaxAge, "Mark dollar figures \"(stale)\" when their feed's answer is older than this (0 = never)")
+	flag.BoolVar(&cfg.Tx.SkipOnDeviation, "skip-on-deviation", false, "In bot, once and execute modes, hold a slice back while the oracle's deviation from the reference price exceeds maxPriceDeviationBps")
+	flag.DurationVar(&cfg.Tx.MaxOracleAge, "max-oracle-age", 0, "Halt submissions while the Chainlink feed's updatedAt is older than this, resuming once it updates; needs --oracle-abi chainlink (0 disables)")
+	flag.UintVar(&cfg.Tx.HaltDeviationBps, "halt-deviation-bps", 0, "Halt submissions while the oracle deviates from the reference price by more than this many bps, resuming once it is back within (0 disables)")
+	flag.BoolVar(&cfg.Tx.StartPaused, "start-paused", false, "In bot mode, start with submissions paused until SIGUSR2 (SIGUSR1 pauses again)")
+	flag.DurationVar(&cfg.Tx.ShutdownGrace, "shutdown-grace", cfg.Tx.ShutdownGrace, "On SIGINT/SIGTERM, wait this long for a submitted executeSlice tx to mine before exiting (0 = only print its hash and nonce)")
+	flag.BoolVar(&cfg.Tx.DryRun, "dry-run", false, "In bot, once and execute modes, simulate, estimate and print each executeSlice tx instead of signing and sending it; no key needed (uses --from, or the contract's agent)")
+	flag.BoolVar(&cfg.Tx.Catchup, "catchup", false, "In bot mode, submit every overdue slice in the same pass instead of one per block")
+	flag.BoolVar(&cfg.Tx.CatchupParallel, "catchup-parallel", false, "With --catchup, submit the overdue slices at once with consecutive nonces instead of waiting for each receipt")
+	flag.Int64Var(&cfg.Tx.MaxScanSlices, "max-scan-slices", cfg.Tx.MaxScanSlices, "Read sliceDone for at most this many slices when preflight, --unsigned-out or propose mode looks for the next slice (0 = no limit)")
+	flag.BoolVar(&cfg.Tx.Force, "force", false, "With --unsigned-out (preflight), propose mode or --slice, use the slice even if it is not yet eligible; in withdraw mode, sweep an order that is still active")
+	flag.StringVar(&cfg.WithdrawTo, "to", "", "Recipient of withdraw mode's sweeps (default: the owner)")
+	flag.StringVar(&cfg.Deploy.Artifact, "artifact", cfg.Deploy.Artifact, "Foundry artifact with the Twap ABI and bytecode (deploy mode)")
+	flag.StringVar(&cfg.Deploy.TokenIn, "token-in", "", "Token the new vault sells (deploy and validate modes)")
+	flag.StringVar(&cfg.Deploy.TokenOut, "token-out", "", "Token the new vault buys (deploy and validate modes)")
+	flag.StringVar(&cfg.Deploy.Adapter, "adapter", "", "DEX adapter of the new vault (deploy and validate modes)")
+	flag.StringVar(&cfg.Deploy.Oracle, "oracle", "", "Price oracle of the new vault (deploy and validate modes)")
+	flag.StringVar(&cfg.Deploy.Agent, "agent", os.Getenv("AGENT_ADDRESS"), "Agent allowed to execute the new vault's slices (deploy and validate modes; env AGENT_ADDRESS)")
+	flag.Var(bigFlag{&cfg.Deploy.TotalAmount}, "total-amount", "Total tokenIn to sell, in base units (deploy and validate modes)")
+	flag.Var(bigFlag{&cfg.Deploy.SliceAmount}, "slice-amount", "tokenIn per slice, in base units (deploy and validate modes)")
+	flag.StringVar(&cfg.Deploy.Start, "start", "", "Start of the TWAP window, RFC3339 or unix seconds (deploy and validate modes)")
+	flag.StringVar(&cfg.Deploy.End, "end", "", "End of the TWAP window, RFC3339 or unix seconds (deploy and validate modes)")
+	flag.UintVar(&cfg.Deploy.MaxSlippageBps, "max-slippage-bps", cfg.Deploy.MaxSlippageBps, fmt.Sprintf("Max slippage vs the oracle quote, at most %d (deploy and validate modes)", twapagent.SlippageBpsLimit))
+	flag.UintVar(&cfg.Deploy.MaxDeviationBps, "max-deviation-bps", cfg.Deploy.MaxDeviationBps, fmt.Sprintf("Max oracle price deviation from the reference, at most %d (deploy and validate modes)", twapagent.DeviationBpsLimit))
+	flag.BoolVar(&cfg.Deploy.AndDeposit, "and-deposit", false, "After deploy mode configures the vault, fund it as deposit mode does")
+	flag.BoolVar(&cfg.Deploy.Yes, "yes", false, "Don't ask for confirmation before deploying")
+	flag.Int64Var(&cfg.Events.FromBlock, "from-block", cfg.Events.FromBlock, "First block events mode reads (default: the first block at the strategy's startTime)")
+	flag.Int64Var(&cfg.Events.ToBlock, "to-block", cfg.Events.ToBlock, "Last block events mode reads (default: latest)")
+	flag.Uint64Var(&cfg.Events.ChunkBlocks, "logs-chunk-blocks", cfg.Events.ChunkBlocks, "Blocks per eth_getLogs request in events, replay and report modes; halved when the provider refuses a range")
+	flag.BoolVar(&cfg.Events.Follow, "follow", false, "In events mode, keep printing new events after the backfill")
+	flag.StringVar(&cfg.Format, "output", cfg.Format, "Output format of preflight (text|json), replay and schedule (text|csv|json) and report (text, or csv|json to list the fills)")
+	flag.StringVar(&cfg.Format, "format", cfg.Format, "Alias of --output")
+	flag.StringVar(&cfg.Out, "out", cfg.Out, "File replay, schedule and report modes write to (\"-\" = stdout)")
+	flag.BoolVar(&cfg.RawAmounts, "raw-amounts", false, "Print token amounts as raw integers instead of in whole tokens with their symbol")
+	flag.Int64Var(&cfg.ScheduleSlices, "schedule-slices", cfg.ScheduleSlices, "Slices schedule mode lists, from the first one not done (0 = all)")
+	flag.StringVar(&cfg.EventsOut, "events-out", "", "In bot, once and execute modes, append one JSON object per decision, tx and event to this file (\"-\" = stdout, with the usual output moved to stderr)")
+	flag.Int64Var(&cfg.Slice, "slice", cfg.Slice, "Execute this slice instead of the next one (execute and once modes; bot mode runs it before starting)")
+	flag.DurationVar(&cfg.Tx.ResubmitAfter, "resubmit-after", cfg.Tx.ResubmitAfter, "Retry a submitted slice whose tx was never seen mined after this long")
+	flag.IntVar(&cfg.Retry.MaxFailuresPerSlice, "max-failures-per-slice", cfg.Retry.MaxFailuresPerSlice, "Stop attempting a slice after this many failed txs (0 = never)")
+	flag.DurationVar(&cfg.Retry.BaseBackoff, "retry-backoff", cfg.Retry.BaseBackoff, "Initial wait before retrying a failed slice; doubles per failure")
+	flag.DurationVar(&cfg.Retry.MaxBackoff, "retry-backoff-max", cfg.Retry.MaxBackoff, "Upper bound for the per-slice retry backoff")
+	flag.IntVar(&cfg.Retry.BreakerThreshold, "breaker-threshold", cfg.Retry.BreakerThreshold, "Pause all submissions after this many consecutive failed txs (0 disables)")
+	flag.DurationVar(&cfg.Retry.BreakerCooldown, "breaker-cooldown", 0, "Automatically resume after the breaker trips (0 = wait for SIGHUP)")
+	flag.Var(bigFlag{&cfg.Balance.MinWei}, "min-balance-wei", "Warn when the agent's ETH balance drops below this many wei")
+	flag.Uint64Var(&cfg.Balance.EveryBlocks, "balance-check-blocks", cfg.Balance.EveryBlocks, "Re-read the agent's ETH balance every this many blocks (0 = only before sends)")
+	flag.BoolVar(&cfg.Feed.Poll, "poll", false, "Poll for new blocks and logs instead of subscribing (default for http(s) --rpc)")
+	flag.DurationVar(&cfg.Feed.PollInterval, "poll-interval", cfg.Feed.PollInterval, "How often to poll for a new block with --poll")
+	flag.StringVar(&cfg.Driver.Driver, "driver", cfg.Driver.Driver, "What makes bot mode evaluate the vault: timer (sleep until the next slice is due) or blocks (every new head)")
+	flag.DurationVar(&cfg.Driver.LeadTime, "lead-time", 0, "With --driver timer, wake up this long before each slice is scheduled")
+	flag.DurationVar(&cfg.Feed.MaxReconnectWait, "max-reconnect-wait", cfg.Feed.MaxReconnectWait, "Longest pause between websocket reconnect attempts")
+	flag.BoolVar(&cfg.Feed.AllEvents, "all-events", false, "In bot mode, subscribe to every contract log instead of only the handled events, to log them all (for debugging)")
+	flag.Float64Var(&cfg.RPCRPS, "rpc-rps", 0, "Cap RPC reads at this many requests per second (0 = unlimited); rate-limited reads are retried either way")
+	flag.DurationVar(&cfg.CallTimeout, "call-timeout", cfg.CallTimeout, "Give up on a single RPC read after this long and retry it (0 = no limit)")
+	flag.StringVar(&cfg.Multicall, "multicall", cfg.Multicall, "Read per-block vault state through Multicall3: auto (if deployed)|on|off")
+	flag.BoolVar(&cfg.End.ExitOnComplete, "exit-on-complete", false, fmt.Sprintf("Exit bot mode once the order is over: %d when filled, %d when cancelled, %d when expired", twapagent.ExitFilled, twapagent.ExitCancelled, twapagent.ExitExpired))
+	flag.DurationVar(&cfg.End.ExpiryGrace, "expiry-grace", cfg.End.ExpiryGrace, "Consider an order with open slices expired this long after its endTime")
+	flag.DurationVar(&cfg.RefreshStrategy, "refresh-strategy-interval", cfg.RefreshStrategy, "Re-read the cached strategy this often in bot mode (0 = only after a reconfiguration event)")
+	flag.StringVar(&cfg.AnvilControl, "anvil-control", cfg.AnvilControl, "Dev node RPC (anvil, hardhat; usually the same as --rpc) whose clock bot mode moves to each slice with evm_setNextBlockTimestamp/evm_mine instead of waiting")
+	flag.BoolVar(&cfg.IKnowWhatImDoing, "i-know-what-im-doing", cfg.IKnowWhatImDoing, "Allow --anvil-control on a chain id other than 31337 or 1337")
+	flag.StringVar(&cfg.API.Addr, "api-addr", "", "Serve the bot's status as JSON on this address, e.g. 127.0.0.1:8080: GET /, /status, /slices, /fills (prefixed with /<vault>/ for one of several)")
+	flag.StringVar(&cfg.API.Token, "api-token", os.Getenv("API_TOKEN"), "Accept POST /pause and /resume on --api-addr with Authorization: Bearer <token> (env API_TOKEN)")
+	flag.Var(&stringsFlag{p: &cfg.Notify.Webhooks.URLs}, "webhook-url", "POST a JSON notification here on each fill, failed tx, halt, pause, run of RPC failures and the order's end (bot mode); repeatable")
+	flag.StringVar(&cfg.Notify.Webhooks.Secret, "webhook-secret", os.Getenv("WEBHOOK_SECRET"), "Sign webhook bodies with HMAC-SHA256 under this key, sent as X-Twap-Signature: sha256=<hex> (env WEBHOOK_SECRET)")
+	flag.IntVar(&cfg.Notify.Webhooks.MaxAttempts, "webhook-max-attempts", cfg.Notify.Webhooks.MaxAttempts, "Tries per webhook delivery, backing off from 1s and doubling, before it is dropped")
+	flag.StringVar(&cfg.Notify.Telegram.BotToken, "telegram-bot-token", os.Getenv("TELEGRAM_BOT_TOKEN"), "Send fills, the order's end and alerts to Telegram as this bot (env TELEGRAM_BOT_TOKEN; bot mode)")
+	flag.StringVar(&cfg.Notify.Telegram.ChatID, "telegram-chat-id", cfg.Notify.Telegram.ChatID, "Telegram chat, group or @channel the messages go to")
+	flag.StringVar(&cfg.Notify.Slack.WebhookURL, "slack-webhook-url", os.Getenv("SLACK_WEBHOOK_URL"), "Post fills, failures, halts and the order's end to this Slack incoming webhook (env SLACK_WEBHOOK_URL; bot mode)")
+	flag.StringVar(&cfg.Notify.Slack.AlertsWebhookURL, "slack-alerts-webhook-url", os.Getenv("SLACK_ALERTS_WEBHOOK_URL"), "Post failures, halts and an unfilled order's end here instead, leaving --slack-webhook-url the routine fills (env SLACK_ALERTS_WEBHOOK_URL)")
+	flag.StringVar(&cfg.Notify.PagerDuty.RoutingKey, "pagerduty-routing-key", os.Getenv("PAGERDUTY_ROUTING_KEY"), "Page through this PagerDuty Events API v2 routing key when a slice stays unexecuted past --page-after (env PAGERDUTY_ROUTING_KEY; bot mode)")
+	flag.DurationVar(&cfg.Notify.PageAfter, "page-after", cfg.Notify.PageAfter, "Alert, and page, when a due slice is still unexecuted after this long (0 = never)")
+	flag.StringVar(&cfg.Notify.ExplorerTxURL, "explorer-tx-url", cfg.Notify.ExplorerTxURL, "Tx link in notifications, {tx} standing for the hash (default: the chain's Etherscan-family explorer, if known)")
+	flag.StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "Log records at this level and above: debug (every block)|info (decisions, submissions, receipts)|warn|error")
+	flag.StringVar(&cfg.Log.Format, "log-format", cfg.Log.Format, "Log record format on stderr: text|json (json carries chainId and contract on every record)")
+	flag.DurationVar(&cfg.Devnet.BlockPeriod, "devnet-block-period", cfg.Devnet.BlockPeriod, "Wall-clock time between devnet blocks, each 12s of chain time (devnet mode)")
 	flag.Parse()
 
-	if rpcURL == "" || contractHex == "" {
-		log.Fatal("rpc and contract are required")
-	}
-
-	ctx := context.Background()
-	client, err := ethclient.DialContext(ctx, rpcURL)
-	if err != nil {
-		log.Fatalf("dial rpc: %v", err)
-	}
-	defer client.Close()
-
-	abiJSON, err := os.ReadFile(abiPath)
-	if err != nil {
-		log.Fatalf("read abi: %v", err)
-	}
-	var artifact struct{ ABI any }
-	if err := json.Unmarshal(abiJSON, &artifact); err != nil {
-		log.Fatalf("unmarshal abi artifact: %v", err)
-	}
-	abiBytes, err := json.Marshal(artifact.ABI)
-	if err != nil {
-		log.Fatalf("marshal abi: %v", err)
-	}
-	cABI, err := abi.JSON(strings.NewReader(string(abiBytes)))
-	if err != nil {
-		log.Fatalf("parse abi: %v", err)
-	}
-
-	addr := common.HexToAddress(contractHex)
-	bound := bind.NewBoundContract(addr, cABI, client, client, client)
-
-	// Read chain ID if not provided
-	// if chainID == 0 {
-	// 	id, err := client.ChainID(ctx)
-	// 	if err != nil {
-	// 		log.Fatalf("chain id: %v", err)
-	// 	}
-	// 	chainID = id.Uint64()
-	// }
-
-	var runErr error
-	switch mode {
-	case "preflight":
-		runErr = preflight(ctx, addr, cABI, client)
-	case "bot":
-		runErr = bot(ctx, addr, cABI, bound, client, privHex, chainID)
-	default:
-		runErr = fmt.Errorf("unknown mode: %s", mode)
-	}
-	if runErr != nil {
-		log.Fatal(runErr)
-	}
-}
-
-// callView packs, executes a static call and unpacks outputs.
-func callView(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, method string, args ...interface{}) ([]interface{}, error) {
-	data, err := cABI.Pack(method, args...)
-	if err != nil {
-		return nil, fmt.Errorf("pack %s: %w", method, err)
-	}
-	res, err := client.CallContract(ctx, ethereum.CallMsg{To: &addr, Data: data}, nil)
-	if err != nil {
-		return nil, fmt.Errorf("call %s: %w", method, err)
-	}
-	outs, err := cABI.Unpack(method, res)
-	if err != nil {
-		return nil, fmt.Errorf("unpack %s: %w", method, err)
-	}
-	return outs, nil
-}
-
-func readStrategy(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client) (Strategy, error) {
-	outs, err := callView(ctx, addr, cABI, client, "strategy")
-	if err != nil {
-		return Strategy{}, err
-	}
-	if len(outs) != 10 {
-		return Strategy{}, fmt.Errorf("unexpected strategy() outputs: got %d", len(outs))
-	}
-	return Strategy{
-		TokenIn:              outs[0].(common.Address),
-		TokenOut:             outs[1].(common.Address),
-		Adapter:              outs[2].(common.Address),
-		PriceOracle:          outs[3].(common.Address),
-		TotalAmountIn:        outs[4].(*big.Int),
-		SliceAmountIn:        outs[5].(*big.Int),
-		StartTime:            outs[6].(*big.Int),
-		EndTime:              outs[7].(*big.Int),
-		MaxSlippageBps:       outs[8].(uint16),
-		MaxPriceDeviationBps: outs[9].(uint16),
-	}, nil
-}
-
-func readFilled(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client) (*big.Int, error) {
-	outs, err := callView(ctx, addr, cABI, client, "filledAmountIn")
-	if err != nil {
-		return nil, err
-	}
-	return outs[0].(*big.Int), nil
-}
-
-func readTotalSlices(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client) (*big.Int, error) {
-	outs, err := callView(ctx, addr, cABI, client, "totalSlices")
-	if err != nil {
-		return nil, err
-	}
-	return outs[0].(*big.Int), nil
-}
-
-func readSliceDone(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, i *big.Int) (bool, error) {
-	outs, err := callView(ctx, addr, cABI, client, "sliceDone", i)
-	if err != nil {
-		return false, err
-	}
-	return outs[0].(bool), nil
-}
-
-func preflight(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client) error {
-	// Get on-chain data and print
-	s, err := readStrategy(ctx, addr, cABI, client)
-	if err != nil {
-		return fmt.Errorf("read strategy: %w", err)
-	}
-	filled, err := readFilled(ctx, addr, cABI, client)
-	if err != nil {
-		return fmt.Errorf("read filled: %w", err)
-	}
-	totalSlices, err := readTotalSlices(ctx, addr, cABI, client)
-	if err != nil {
-		return fmt.Errorf("read totalSlices: %w", err)
-	}
-
-	header, err := client.HeaderByNumber(ctx, nil)
-	if err != nil {
-		return fmt.Errorf("header: %w", err)
-	}
-	now := new(big.Int).SetUint64(header.Time)
-
-	N := new(big.Int).Set(totalSlices)
-	var next int64 = -1
-	if N.Sign() > 0 {
-		interval := new(big.Int).Div(new(big.Int).Sub(s.EndTime, s.StartTime), N)
-		for i := int64(0); i < N.Int64(); i++ {
-			done, err := readSliceDone(ctx, addr, cABI, client, big.NewInt(i))
-			if err != nil {
-				return fmt.Errorf("sliceDone(%d): %w", i, err)
-			}
-			if done {
-				continue
-			}
-			scheduled := new(big.Int).Add(s.StartTime, new(big.Int).Mul(interval, big.NewInt(i)))
-			if now.Cmp(scheduled) >= 0 {
-				next = i
-				break
-			}
-		}
-	}
-
-	fmt.Printf("Preflight:\n")
-	fmt.Printf("- blockTime: %s (%s)\n", now, time.Unix(int64(now.Uint64()), 0).UTC().Format(time.RFC3339))
-	fmt.Printf("- totalAmountIn: %s\n", s.TotalAmountIn)
-	fmt.Printf("- sliceAmountIn: %s\n", s.SliceAmountIn)
-	fmt.Printf("- window: %s -> %s\n", s.StartTime, s.EndTime)
-	fmt.Printf("- filledAmountIn: %s\n", filled)
-	fmt.Printf("- totalSlices: %s\n", N)
-	if next >= 0 {
-		fmt.Printf("- nextEligibleSlice: %d\n", next)
-	} else {
-		fmt.Printf("- nextEligibleSlice: none (by schedule or all done)\n")
-	}
-	return nil
-}
-
-func execute(ctx context.Context, bound *bind.BoundContract, client *ethclient.Client, privHex string, chainID uint64, sliceId int64) {
-	if privHex == "" {
-		log.Fatal("private key is required for bot mode")
-	}
-	privHex = strings.TrimPrefix(privHex, "0x")
-	key, err := crypto.HexToECDSA(privHex)
-	if err != nil {
-		log.Fatalf("parse key: %v", err)
-	}
-
-	// Prepare transactor
-	if chainID == 0 {
-		id, err := client.ChainID(ctx)
-		if err != nil {
-			log.Fatalf("chain id: %v", err)
+	var fileCfg configFile
+	if configPath != "" {
+		var err error
+		if fileCfg, err = loadConfigFile(configPath); err != nil {
+			log.Fatal(err)
 		}
-		chainID = id.Uint64()
 	}
-	auth, err := bind.NewKeyedTransactorWithChainID(key, new(big.Int).SetUint64(chainID))
-	if err != nil {
-		log.Fatalf("transactor: %v", err)
-	}
-	auth.Context = ctx
-
-	// Determine nonce and gas settings ahead of submission, and print them.
-	nonce, err := client.PendingNonceAt(ctx, auth.From)
+	sources, err := applyConfigFile(flag.CommandLine, fileCfg, os.Getenv)
 	if err != nil {
-		log.Printf("pending nonce error (will let sender handle): %v", err)
-	} else {
-		auth.Nonce = new(big.Int).SetUint64(nonce)
+		log.Fatal(err)
 	}
-
-	// Legacy gas pricing only (force type-0 transactions)
-	gp, err := client.SuggestGasPrice(ctx)
-	if err != nil {
-		log.Printf("suggest gas price error: %v", err)
-	} else {
-		auth.GasPrice = new(big.Int).Set(gp)
-	}
-	if auth.Nonce != nil && auth.GasPrice != nil {
-		fmt.Printf("Planning tx: nonce=%d, gasPrice=%s wei\n", auth.Nonce.Uint64(), auth.GasPrice.String())
-	} else if auth.GasPrice != nil {
-		fmt.Printf("Planning tx: gasPrice=%s wei\n", auth.GasPrice.String())
-	}
-
-	// Submit
-	tx, err := bound.Transact(auth, "executeSlice", big.NewInt(sliceId))
-	if err != nil {
-		log.Printf("executeSlice(%d) error: %v", sliceId, err)
-		return
-	}
-	fmt.Printf("Submitted tx %s for slice %d\n", tx.Hash().Hex(), sliceId)
-
-	// Wait for mining
-	receipt, err := bind.WaitMined(ctx, client, tx)
-	if err != nil {
-		log.Printf("wait mined error: %v", err)
+	if cfg.Mode == "config" {
+		printConfig(os.Stdout, flag.CommandLine, sources)
 		return
 	}
-	if receipt.Status != types.ReceiptStatusSuccessful {
-		log.Printf("tx failed: %s", tx.Hash().Hex())
-		return
-	}
-	fmt.Printf("Mined in block %d\n", receipt.BlockNumber.Uint64())
-}
-
-func readStatus(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client) (uint8, error) {
-	outs, err := callView(ctx, addr, cABI, client, "status")
-	if err != nil {
-		return 0, err
-	}
-	return outs[0].(uint8), nil
-}
 
-func bot(ctx context.Context, addr common.Address, cABI abi.ABI, bound *bind.BoundContract, client *ethclient.Client, privHex string, chainID uint64) error {
-	if privHex == "" {
-		return fmt.Errorf("private key is required for bot mode")
+	if cfg.Mode == "devnet" {
+		ctx, cancel := withSignalCancel(context.Background())
+		code := twapagent.ExitCode(ctx, twapagent.RunDevnet(ctx, cfg))
+		cancel()
+		os.Exit(code)
 	}
-
-	// Event subscription (WS only)
-	logsCh := make(chan types.Log, 128)
-	sub, err := client.SubscribeFilterLogs(ctx, ethereum.FilterQuery{Addresses: []common.Address{addr}}, logsCh)
+	agent, err := twapagent.New(cfg)
 	if err != nil {
-		return fmt.Errorf("log subscribe failed: %w", err)
+		log.Fatal(err)
 	}
-	log.Printf("subscribed to contract logs")
-
-	// Header subscription (WS only)
-	heads := make(chan *types.Header, 32)
-	headSub, err := client.SubscribeNewHead(ctx, heads)
-	if err != nil {
-		return fmt.Errorf("header subscribe failed: %w", err)
+	// The log package's lines, and ExitCode's, go through the agent's logger.
+	slog.SetDefault(agent.Logger())
+	ctx := context.Background()
+	switch cfg.Mode {
+	case "bot", "once", "execute", "watch", "events":
+		// The long-running modes wind down on SIGINT/SIGTERM; the rest die.
+		var cancel context.CancelFunc
+		ctx, cancel = withSignalCancel(ctx)
+		defer cancel()
+	}
+	if cfg.Mode == "bot" {
+		stop := handleOperatorSignals(agent)
+		defer stop()
+	}
+	code := twapagent.ExitCode(ctx, agent.RunMode(ctx))
+	agent.Close()
+	if code != 0 {
+		os.Exit(code)
 	}
-	log.Printf("subscribed to new heads")
+}
 
-	terminalLogged := false
-	for {
+// withSignalCancel returns a ctx canceled by the first SIGINT or SIGTERM.
+// The next one gets the default behaviour, so a second Ctrl-C exits at once.
+func withSignalCancel(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(sigs)
 		select {
-		case err := <-headSub.Err():
-			return fmt.Errorf("header sub error: %w", err)
-		case err := <-sub.Err():
-			return fmt.Errorf("log sub error: %w", err)
-		case h := <-heads:
-			handleBlock(ctx, addr, cABI, bound, client, privHex, chainID, h.Number)
-		case lg := <-logsCh:
-			if len(lg.Topics) == 0 {
-				continue
-			}
-			ev, err := cABI.EventByID(lg.Topics[0])
-			if err != nil {
-				continue
-			}
-			switch ev.Name {
-			case "Fill":
-				var out struct{ SliceId, AmountIn, AmountOut, Fee *big.Int }
-				if err := cABI.UnpackIntoInterface(&out, "Fill", lg.Data); err == nil {
-					fmt.Printf("[Event] Fill: slice=%s in=%s out=%s fee=%s\n", out.SliceId, out.AmountIn, out.AmountOut, out.Fee)
-				}
-			case "OrderStatus":
-				var out struct {
-					FilledAmountIn, ReceivedAmountOut, Fee *big.Int
-					Status                                 uint8
-				}
-				if err := cABI.UnpackIntoInterface(&out, "OrderStatus", lg.Data); err == nil {
-					fmt.Printf("[Event] OrderStatus: filled=%s received=%s fee=%s status=%d\n", out.FilledAmountIn, out.ReceivedAmountOut, out.Fee, out.Status)
-					if out.Status == 2 && !terminalLogged { // Filled
-						s, _ := readStrategy(ctx, addr, cABI, client)
-						fmt.Printf("TWAP Summary: filled=%s/%s, received=%s, fee=%s, status=%d\n", out.FilledAmountIn, s.TotalAmountIn, out.ReceivedAmountOut, out.Fee, out.Status)
-						fmt.Println("Continuing to watch events...")
-						terminalLogged = true
-					}
-				}
-			}
+		case sig := <-sigs:
+			log.Printf("%s: shutting down (send it again to exit now)", sig)
+			cancel()
+		case <-ctx.Done():
 		}
-	}
+	}()
+	return ctx, cancel
 }
 
-func handleBlock(ctx context.Context, addr common.Address, cABI abi.ABI, bound *bind.BoundContract, client *ethclient.Client, privHex string, chainID uint64, number *big.Int) {
-	hdr, err := client.HeaderByNumber(ctx, number)
-	if err == nil {
-		fmt.Printf("New block %d time=%d\n", hdr.Number.Uint64(), hdr.Time)
-	}
-	// Skip execution attempts if order is filled or canceled
-	if st, err := readStatus(ctx, addr, cABI, client); err == nil {
-		if st == 2 || st == 3 { // Filled or Canceleled
-			return
-		}
-	}
-	// Attempt execute if eligible
-	s, err := readStrategy(ctx, addr, cABI, client)
-	if err != nil {
-		return
-	}
-	N, err := readTotalSlices(ctx, addr, cABI, client)
-	if err != nil {
-		return
-	}
-	now := new(big.Int).SetUint64(hdr.Time)
-	// Determine the first (unrelaized) slice regardless of schedule
-	var firstUndone int64 = -1
-	for i := int64(0); i < N.Int64(); i++ {
-		done, _ := readSliceDone(ctx, addr, cABI, client, big.NewInt(i))
-		if !done {
-			firstUndone = i
-			break
-		}
-	}
-	if firstUndone >= 0 {
-		// Compute schedule info
-		interval := new(big.Int).Div(new(big.Int).Sub(s.EndTime, s.StartTime), N)
-		scheduled := new(big.Int).Add(s.StartTime, new(big.Int).Mul(interval, big.NewInt(firstUndone)))
-		execNow := now.Cmp(scheduled) >= 0
-		if execNow {
-			fmt.Printf("Eligible slice %d at block %d\n", firstUndone, hdr.Number.Uint64())
-			execute(ctx, bound, client, privHex, chainID, firstUndone)
-		} else {
-			// Log when it will be executable
-			diff := new(big.Int).Sub(scheduled, now)
-			fmt.Printf("Next slice %d scheduled at %d (in ~%ds)\n", firstUndone, scheduled.Uint64(), diff.Uint64())
+// handleOperatorSignals passes bot mode's operator signals to agent: SIGHUP
+// resets a tripped circuit breaker, SIGUSR1 pauses submissions and SIGUSR2
+// resumes them. The returned func stops listening.
+func handleOperatorSignals(agent *twapagent.Agent) func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				switch sig {
+				case syscall.SIGHUP:
+					agent.ResetBreaker()
+				case syscall.SIGUSR1:
+					agent.Pause(sig.String())
+				case syscall.SIGUSR2:
+					agent.Resume(sig.String())
+				}
+			case <-done:
+				return
+			}
 		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
 	}
 }
-
-// printLog handling moved inline in bot() to allow summary trigger only via Filled event
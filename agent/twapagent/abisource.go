@@ -0,0 +1,251 @@
+package twapagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ABI sources accepted by --abi-source.
+const (
+	abiSourceEtherscan = "etherscan"
+	abiSourceSourcify  = "sourcify"
+)
+
+// errNotVerified means the explorer has no verified source for the address.
+var errNotVerified = errors.New("contract source is not verified")
+
+// errRateLimited marks a response worth retrying after a pause.
+var errRateLimited = errors.New("rate limited")
+
+// errNotFound is a 404 from the explorer.
+var errNotFound = errors.New("not found")
+
+// abiFetcher downloads verified ABIs from Etherscan (v2 multichain API) or
+// Sourcify.
+type abiFetcher struct {
+	http         *http.Client
+	etherscanURL string
+	sourcifyURL  string
+	apiKey       string
+	// First pause after a rate-limited response; doubles per retry.
+	backoff  time.Duration
+	attempts int
+}
+
+func newABIFetcher(apiKey string) *abiFetcher {
+	return &abiFetcher{
+		http:         &http.Client{Timeout: 30 * time.Second},
+		etherscanURL: "https://api.etherscan.io/v2/api",
+		sourcifyURL:  "https://sourcify.dev/server",
+		apiKey:       apiKey,
+		backoff:      time.Second,
+		attempts:     5,
+	}
+}
+
+// fetchABIToCache writes the verified ABI of addr to cacheDir and returns the
+// file's path. An existing cache file is reused unless refresh is set.
+func fetchABIToCache(ctx context.Context, f *abiFetcher, source string, chainID uint64, addr common.Address, cacheDir string, refresh bool) (string, error) {
+	path := filepath.Join(cacheDir, fmt.Sprintf("%s-%d-%s.json", source, chainID, strings.ToLower(addr.Hex())))
+	if !refresh {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	var abiJSON []byte
+	var err error
+	switch source {
+	case abiSourceEtherscan:
+		abiJSON, err = f.etherscan(ctx, chainID, addr)
+	case abiSourceSourcify:
+		abiJSON, err = f.sourcify(ctx, chainID, addr)
+	default:
+		return "", fmt.Errorf("unknown --abi-source %q (want %s or %s)", source, abiSourceEtherscan, abiSourceSourcify)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return "", fmt.Errorf("abi cache: %w", err)
+	}
+	if err := os.WriteFile(path, abiJSON, 0o644); err != nil {
+		return "", fmt.Errorf("abi cache: %w", err)
+	}
+	return path, nil
+}
+
+// defaultABICacheDir is where fetched ABIs are kept when --abi-cache-dir is unset.
+func defaultABICacheDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "twap-agent", "abi")
+	}
+	return ".twap-abi-cache"
+}
+
+type etherscanSource struct {
+	ABI            string
+	ContractName   string
+	Proxy          string
+	Implementation string
+}
+
+// etherscan returns the verified ABI of addr, following a proxy to its
+// implementation when Etherscan has detected one.
+func (f *abiFetcher) etherscan(ctx context.Context, chainID uint64, addr common.Address) ([]byte, error) {
+	if f.apiKey == "" {
+		return nil, errors.New("--abi-source etherscan needs --etherscan-api-key (or ETHERSCAN_API_KEY)")
+	}
+	src, err := f.etherscanSource(ctx, chainID, addr)
+	if err != nil {
+		return nil, err
+	}
+	if src.Proxy == "1" && common.IsHexAddress(src.Implementation) {
+		impl := common.HexToAddress(src.Implementation)
+		fmt.Fprintf(outputFrom(ctx), "%s is a proxy, using the ABI of implementation %s\n", addr.Hex(), impl.Hex())
+		if src, err = f.etherscanSource(ctx, chainID, impl); err != nil {
+			return nil, fmt.Errorf("implementation %s: %w", impl.Hex(), err)
+		}
+	}
+	return []byte(src.ABI), nil
+}
+
+func (f *abiFetcher) etherscanSource(ctx context.Context, chainID uint64, addr common.Address) (etherscanSource, error) {
+	q := url.Values{
+		"chainid": {fmt.Sprint(chainID)},
+		"module":  {"contract"},
+		"action":  {"getsourcecode"},
+		"address": {addr.Hex()},
+		"apikey":  {f.apiKey},
+	}
+	var out etherscanSource
+	err := f.retry(ctx, func() error {
+		body, err := f.get(ctx, f.etherscanURL+"?"+q.Encode())
+		if err != nil {
+			return err
+		}
+		var resp struct {
+			Status  string
+			Message string
+			Result  json.RawMessage
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return fmt.Errorf("etherscan: %w", err)
+		}
+		if resp.Status != "1" {
+			// Errors come back as a string result.
+			var msg string
+			_ = json.Unmarshal(resp.Result, &msg)
+			if strings.Contains(strings.ToLower(msg), "rate limit") {
+				return fmt.Errorf("etherscan: %w: %s", errRateLimited, msg)
+			}
+			return fmt.Errorf("etherscan: %s: %s", resp.Message, msg)
+		}
+		var results []etherscanSource
+		if err := json.Unmarshal(resp.Result, &results); err != nil || len(results) == 0 {
+			return fmt.Errorf("etherscan: unexpected getsourcecode result")
+		}
+		out = results[0]
+		return nil
+	})
+	if err != nil {
+		return etherscanSource{}, err
+	}
+	if !strings.HasPrefix(strings.TrimSpace(out.ABI), "[") {
+		return etherscanSource{}, fmt.Errorf("%s on chain %d: %w (etherscan: %s)", addr.Hex(), chainID, errNotVerified, out.ABI)
+	}
+	return out, nil
+}
+
+// sourcify returns the verified ABI of addr, following the first proxy
+// implementation Sourcify resolved.
+func (f *abiFetcher) sourcify(ctx context.Context, chainID uint64, addr common.Address) ([]byte, error) {
+	var out struct {
+		ABI             json.RawMessage `json:"abi"`
+		ProxyResolution *struct {
+			IsProxy         bool `json:"isProxy"`
+			Implementations []struct {
+				Address common.Address `json:"address"`
+			} `json:"implementations"`
+		} `json:"proxyResolution"`
+	}
+	u := fmt.Sprintf("%s/v2/contract/%d/%s?fields=abi,proxyResolution", f.sourcifyURL, chainID, addr.Hex())
+	err := f.retry(ctx, func() error {
+		body, err := f.get(ctx, u)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(body, &out)
+	})
+	if errors.Is(err, errNotFound) {
+		return nil, fmt.Errorf("%s on chain %d: %w on sourcify", addr.Hex(), chainID, errNotVerified)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sourcify: %w", err)
+	}
+	if pr := out.ProxyResolution; pr != nil && pr.IsProxy && len(pr.Implementations) > 0 {
+		impl := pr.Implementations[0].Address
+		fmt.Fprintf(outputFrom(ctx), "%s is a proxy, using the ABI of implementation %s\n", addr.Hex(), impl.Hex())
+		abiJSON, err := f.sourcify(ctx, chainID, impl)
+		if err != nil {
+			return nil, fmt.Errorf("implementation %s: %w", impl.Hex(), err)
+		}
+		return abiJSON, nil
+	}
+	if len(out.ABI) == 0 || string(out.ABI) == "null" {
+		return nil, fmt.Errorf("%s on chain %d: sourcify returned no abi", addr.Hex(), chainID)
+	}
+	return out.ABI, nil
+}
+
+func (f *abiFetcher) get(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil, fmt.Errorf("%w: %s", errRateLimited, resp.Status)
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errNotFound
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// retry runs fn until it succeeds or fails with something other than a rate limit.
+func (f *abiFetcher) retry(ctx context.Context, fn func() error) error {
+	wait := f.backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if !errors.Is(err, errRateLimited) || attempt >= f.attempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
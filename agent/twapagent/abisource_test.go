@@ -0,0 +1,96 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	abiTestProxy = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	abiTestImpl  = common.HexToAddress("0x2222222222222222222222222222222222222222")
+	abiTestRaw   = common.HexToAddress("0x3333333333333333333333333333333333333333")
+)
+
+func testFetcher(url string) *abiFetcher {
+	f := newABIFetcher("key")
+	f.etherscanURL, f.sourcifyURL = url, url
+	f.backoff = time.Millisecond
+	return f
+}
+
+func TestEtherscanFollowsProxyAndRetries(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			fmt.Fprint(w, `{"status":"0","message":"NOTOK","result":"Max rate limit reached"}`)
+			return
+		}
+		switch common.HexToAddress(r.URL.Query().Get("address")) {
+		case abiTestProxy:
+			fmt.Fprintf(w, `{"status":"1","message":"OK","result":[{"ABI":"[]","Proxy":"1","Implementation":"%s"}]}`, abiTestImpl.Hex())
+		case abiTestImpl:
+			fmt.Fprint(w, `{"status":"1","message":"OK","result":[{"ABI":"[{\"type\":\"fallback\"}]","Proxy":"0"}]}`)
+		default:
+			fmt.Fprint(w, `{"status":"1","message":"OK","result":[{"ABI":"Contract source code not verified","Proxy":"0"}]}`)
+		}
+	}))
+	defer srv.Close()
+	f := testFetcher(srv.URL)
+	dir := t.TempDir()
+
+	path, err := fetchABIToCache(context.Background(), f, abiSourceEtherscan, 1, abiTestProxy, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `[{"type":"fallback"}]` {
+		t.Fatalf("cached abi = %s, want the implementation's", data)
+	}
+
+	// A second run uses the cache without calling the API.
+	before := calls.Load()
+	if _, err := fetchABIToCache(context.Background(), f, abiSourceEtherscan, 1, abiTestProxy, dir, false); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != before {
+		t.Fatal("cached abi was fetched again")
+	}
+
+	_, err = fetchABIToCache(context.Background(), f, abiSourceEtherscan, 1, abiTestRaw, dir, false)
+	if !errors.Is(err, errNotVerified) {
+		t.Fatalf("err = %v, want errNotVerified", err)
+	}
+}
+
+func TestSourcifyNotVerified(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, abiTestImpl.Hex()) {
+			fmt.Fprint(w, `{"abi":[{"type":"fallback"}],"proxyResolution":{"isProxy":false}}`)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	f := testFetcher(srv.URL)
+
+	if _, err := fetchABIToCache(context.Background(), f, abiSourceSourcify, 1, abiTestImpl, t.TempDir(), false); err != nil {
+		t.Fatal(err)
+	}
+	_, err := fetchABIToCache(context.Background(), f, abiSourceSourcify, 1, abiTestRaw, t.TempDir(), false)
+	if !errors.Is(err, errNotVerified) {
+		t.Fatalf("err = %v, want errNotVerified", err)
+	}
+}
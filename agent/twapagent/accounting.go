@@ -0,0 +1,226 @@
+package twapagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// receiptRecord is the gas cost of one mined executeSlice transaction.
+type receiptRecord struct {
+	Contract          string `json:"contract"`
+	From              string `json:"from,omitempty"`
+	TxHash            string `json:"txHash"`
+	Slice             int64  `json:"slice"`
+	Block             uint64 `json:"block"`
+	Success           bool   `json:"success"`
+	GasUsed           uint64 `json:"gasUsed"`
+	EffectiveGasPrice string `json:"effectiveGasPrice"`
+	FeeWei            string `json:"feeWei"`
+}
+
+// gasLedger collects executeSlice receipts and persists them to a JSON file
+// keyed by tx hash, so a restarted bot (or the report mode) still sees them.
+type gasLedger struct {
+	mu      sync.Mutex
+	path    string
+	records map[string]receiptRecord
+}
+
+// loadGasLedger opens the ledger at path; a missing file starts empty. An
+// empty path keeps the ledger in memory only.
+func loadGasLedger(path string) (*gasLedger, error) {
+	l := &gasLedger{path: path, records: make(map[string]receiptRecord)}
+	if path == "" {
+		return l, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read receipts file: %w", err)
+	}
+	if err := json.Unmarshal(data, &l.records); err != nil {
+		return nil, fmt.Errorf("parse receipts file %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// Record stores a mined receipt sent by from. fallbackPrice is used when the
+// node doesn't report effectiveGasPrice.
+func (l *gasLedger) Record(contract, from common.Address, slice int64, r *types.Receipt, fallbackPrice *big.Int) error {
+	price := r.EffectiveGasPrice
+	if price == nil {
+		price = fallbackPrice
+	}
+	if price == nil {
+		price = new(big.Int)
+	}
+	fee := new(big.Int).Mul(price, new(big.Int).SetUint64(r.GasUsed))
+	rec := receiptRecord{
+		Contract:          contract.Hex(),
+		From:              from.Hex(),
+		TxHash:            r.TxHash.Hex(),
+		Slice:             slice,
+		Block:             r.BlockNumber.Uint64(),
+		Success:           r.Status == types.ReceiptStatusSuccessful,
+		GasUsed:           r.GasUsed,
+		EffectiveGasPrice: price.String(),
+		FeeWei:            fee.String(),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records[rec.TxHash] = rec
+	return l.saveLocked()
+}
+
+func (l *gasLedger) saveLocked() error {
+	if l.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(l.records, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write-then-rename so a crash never leaves a truncated file behind.
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write receipts file: %w", err)
+	}
+	return os.Rename(tmp, l.path)
+}
+
+// Records returns the receipts for contract ordered by block.
+func (l *gasLedger) Records(contract common.Address) []receiptRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []receiptRecord
+	for _, r := range l.records {
+		if common.HexToAddress(r.Contract) == contract {
+			out = append(out, r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Block < out[j].Block })
+	return out
+}
+
+// gasSummary aggregates the ledger for one contract.
+type gasSummary struct {
+	Txs      int
+	Failed   int
+	Slices   int
+	TotalGas uint64
+	TotalFee *big.Int
+}
+
+func (l *gasLedger) Summary(contract common.Address) gasSummary {
+	return summarize(l.Records(contract))
+}
+
+// AgentSummaries splits the contract's totals by sending account. Records
+// from before the sender was tracked are grouped under "unknown".
+func (l *gasLedger) AgentSummaries(contract common.Address) map[string]gasSummary {
+	byAgent := make(map[string][]receiptRecord)
+	for _, r := range l.Records(contract) {
+		from := r.From
+		if from == "" {
+			from = "unknown"
+		}
+		byAgent[from] = append(byAgent[from], r)
+	}
+	out := make(map[string]gasSummary, len(byAgent))
+	for from, recs := range byAgent {
+		out[from] = summarize(recs)
+	}
+	return out
+}
+
+func summarize(records []receiptRecord) gasSummary {
+	sum := gasSummary{TotalFee: new(big.Int)}
+	for _, r := range records {
+		sum.Txs++
+		if r.Success {
+			sum.Slices++
+		} else {
+			sum.Failed++
+		}
+		sum.TotalGas += r.GasUsed
+		if fee, ok := new(big.Int).SetString(r.FeeWei, 10); ok {
+			sum.TotalFee.Add(sum.TotalFee, fee)
+		}
+	}
+	return sum
+}
+
+// printGasSummary prints execution cost totals. contractFee is the
+// contract-reported cumulative fee (OrderStatus.fee); nil skips the ratio.
+func printGasSummary(w io.Writer, sum gasSummary, contractFee *big.Int) {
+	fmt.Fprintf(w, "Gas Summary: txs=%d (failed=%d), totalGas=%d, totalSpent=%s wei (%s ETH)\n",
+		sum.Txs, sum.Failed, sum.TotalGas, sum.TotalFee, weiToEth(sum.TotalFee))
+	if sum.Slices > 0 {
+		avg := new(big.Int).Div(sum.TotalFee, big.NewInt(int64(sum.Slices)))
+		fmt.Fprintf(w, "- averageFeePerSlice: %s wei (%s ETH)\n", avg, weiToEth(avg))
+	}
+	if contractFee != nil && contractFee.Sign() > 0 {
+		ratio := new(big.Rat).SetFrac(sum.TotalFee, contractFee)
+		fmt.Fprintf(w, "- gasCost/contractFee: %s\n", ratio.FloatString(6))
+	}
+}
+
+func weiToEth(wei *big.Int) string {
+	return new(big.Rat).SetFrac(wei, big.NewInt(params.Ether)).FloatString(6)
+}
+
+// report prints the persisted gas ledger for addr, so numbers from a
+// restarted or finished run are still available.
+func report(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, receiptsPath string) error {
+	if receiptsPath == "" {
+		return fmt.Errorf("receipts-file is required for report mode")
+	}
+	ledger, err := loadGasLedger(receiptsPath)
+	if err != nil {
+		return err
+	}
+	records := ledger.Records(addr)
+	fmt.Fprintf(outputFrom(ctx), "Report (%s):\n", receiptsPath)
+	for _, r := range records {
+		status := "ok"
+		if !r.Success {
+			status = "failed"
+		}
+		fmt.Fprintf(outputFrom(ctx), "- slice %d block %d tx %s %s gasUsed=%d price=%s fee=%s wei\n", r.Slice, r.Block, r.TxHash, status, r.GasUsed, r.EffectiveGasPrice, r.FeeWei)
+	}
+	var contractFee *big.Int
+	if outs, err := callView(ctx, addr, cABI, client, "accruedFee"); err == nil {
+		contractFee = outs[0].(*big.Int)
+	} else {
+		logf(ctx, "read accruedFee: %v", err)
+	}
+	printGasSummary(outputFrom(ctx), ledger.Summary(addr), contractFee)
+	if byAgent := ledger.AgentSummaries(addr); len(byAgent) > 1 {
+		agents := make([]string, 0, len(byAgent))
+		for a := range byAgent {
+			agents = append(agents, a)
+		}
+		sort.Strings(agents)
+		for _, a := range agents {
+			sum := byAgent[a]
+			fmt.Fprintf(outputFrom(ctx), "- agent %s: txs=%d (failed=%d), totalGas=%d, totalSpent=%s wei (%s ETH)\n", a, sum.Txs, sum.Failed, sum.TotalGas, sum.TotalFee, weiToEth(sum.TotalFee))
+		}
+	}
+	return nil
+}
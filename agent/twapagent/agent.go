@@ -0,0 +1,894 @@
+// Package twapagent executes the slices of Twap vaults. The twap-agent
+// command is a thin CLI over it: a Config holds its flags, New sets up what
+// the mode needs, and RunMode runs it. Services embedding the agent use Run,
+// ExecuteSlice and Preflight on an Agent built for bot mode.
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"twap-agent/twapbind"
+)
+
+// Strategy is the vault's strategy() tuple.
+type Strategy = twapbind.Strategy
+
+// Config is everything New needs. Its fields are the CLI's flags, grouped
+// the same way; DefaultConfig has their defaults.
+type Config struct {
+	// Mode is the CLI mode RunMode runs, and decides what New sets up. Empty
+	// is bot mode, which is what Run, ExecuteSlice and Preflight need.
+	Mode string
+
+	// RPC is read from; TxRPC, if set, takes gas queries, nonces and
+	// submissions. RPCAuth adds headers to both.
+	RPC     string
+	TxRPC   string
+	RPCAuth RPCAuthConfig
+	// Contracts are the vaults, as addresses or ENS names. Only bot mode
+	// runs more than one; the other modes and ExecuteSlice use the first.
+	Contracts []string
+	// ChainID is checked against the node's; 0 takes the node's.
+	ChainID uint64
+	// Bot mode's vaults found through a factory, besides Contracts.
+	Factory FactoryConfig
+
+	Signer SignerConfig
+	// A Defender relayer sends executeSlice instead of a Signer key.
+	DefenderAPIKey    string
+	DefenderAPISecret string
+	// Signed txs go to PrivateRPC instead of the public mempool, and to the
+	// latter too after PrivateFallbackBlocks (0 = never).
+	PrivateRPC            string
+	PrivateFallbackBlocks uint64
+
+	// The Twap ABI is embedded unless ABIPath names a file, or ABISource
+	// (etherscan|sourcify) fetches it into ABICacheDir.
+	ABIPath         string
+	ABISource       string
+	EtherscanAPIKey string
+	ABICacheDir     string
+	ABIRefresh      bool
+
+	Tx      TxConfig
+	Retry   RetryConfig
+	Balance BalanceConfig
+	Feed    FeedConfig
+	Driver  DriverConfig
+	End     EndConfig
+	Safe    SafeConfig
+	Deploy  DeployConfig
+	Events  EventsConfig
+	Devnet  DevnetConfig
+	// Bot mode's status API and notifications.
+	API    APIConfig
+	Notify NotifyConfig
+	// The Agent's logger, which Logger returns. Records carry the chain
+	// id, and the vault's address once there is one.
+	Log LogConfig
+	// Where reports and summaries go, and the log; nil is os.Stdout and
+	// os.Stderr. With EventsOut "-" the records take Stdout and the reports
+	// go to Stderr.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Parsed by New into Tx.Oracle, Tx.Quoter and Tx.USD. TokenUSDFeeds
+	// are token=feed pairs.
+	OracleABI     string
+	OracleAddress string
+	QuoteAddress  string
+	UniswapV3Fee  uint
+	EthUSDFeed    string
+	TokenUSDFeeds []string
+	FeedRegistry  string
+	USDMaxAge     time.Duration
+
+	// Reads are capped at RPCRPS a second (0 = unlimited) and each is given
+	// up and retried after CallTimeout (0 = no limit).
+	RPCRPS      float64
+	CallTimeout time.Duration
+	// Multicall3 for bot mode's per-block reads: auto|on|off.
+	Multicall string
+	// Re-read the cached strategy this often (0 = after reconfiguration only).
+	RefreshStrategy time.Duration
+	// A dev node (anvil, hardhat) whose clock bot mode moves to each slice
+	// instead of waiting for it. Only dev chain ids, unless IKnowWhatImDoing.
+	AnvilControl     string
+	IKnowWhatImDoing bool
+	// Mined executeSlice receipts are recorded here ("" keeps them in memory).
+	ReceiptsFile string
+	// Bot mode's last handled block, to backfill from after a restart.
+	StateFile string
+	// Append a JSON record per decision, tx and event ("-" = stdout).
+	EventsOut string
+
+	// The slice execute and once modes run (-1 = the next one), withdraw
+	// mode's recipient, the output format and file of the reporting modes,
+	// and how many slices schedule mode lists.
+	Slice          int64
+	WithdrawTo     string
+	Format         string
+	Out            string
+	ScheduleSlices int64
+	// Print token amounts as raw integers rather than in whole tokens.
+	RawAmounts bool
+}
+
+// DefaultConfig is the CLI's defaults, with Mode left as bot mode.
+func DefaultConfig() Config {
+	cfg := Config{
+		ABICacheDir: defaultABICacheDir(),
+		Tx: TxConfig{
+			TxType:              txTypeAuto,
+			CeilingGrace:        900,
+			BumpAfter:           60,
+			BumpPercent:         12.5,
+			MaxBumps:            3,
+			GasBufferPercent:    20,
+			WaitTimeout:         5 * time.Minute,
+			ReceiptPollInterval: time.Second,
+			ResubmitAfter:       10 * time.Minute,
+			MaxScanSlices:       1000,
+			ProgressInterval:    5 * time.Minute,
+			ShutdownGrace:       30 * time.Second,
+		},
+		Retry: RetryConfig{
+			MaxFailuresPerSlice: 5,
+			BaseBackoff:         30 * time.Second,
+			MaxBackoff:          30 * time.Minute,
+			BreakerThreshold:    10,
+		},
+		Balance: BalanceConfig{EveryBlocks: 20},
+		Feed:    FeedConfig{PollInterval: 4 * time.Second, MaxReconnectWait: time.Minute},
+		Driver:  DriverConfig{Driver: driverTimer},
+		End:     EndConfig{ExpiryGrace: 15 * time.Minute},
+		Safe:    SafeConfig{Call: "executeSlice"},
+		Deploy: DeployConfig{
+			Artifact:        "out/Twap.sol/Twap.json",
+			MaxSlippageBps:  100,
+			MaxDeviationBps: 250,
+		},
+		Events:          EventsConfig{FromBlock: -1, ToBlock: -1, ChunkBlocks: 2000},
+		Devnet:          DevnetConfig{BlockPeriod: 250 * time.Millisecond},
+		Notify:          NotifyConfig{Webhooks: WebhookConfig{MaxAttempts: 5}, PageAfter: 10 * time.Minute},
+		Log:             LogConfig{Level: "info", Format: logFormatText},
+		OracleABI:       oracleKindIOracle,
+		UniswapV3Fee:    3000,
+		USDMaxAge:       24 * time.Hour,
+		CallTimeout:     10 * time.Second,
+		Multicall:       multicallAuto,
+		RefreshStrategy: 10 * time.Minute,
+		ReceiptsFile:    "twap-receipts.json",
+		Slice:           -1,
+		Format:          formatText,
+		Out:             "-",
+		ScheduleSlices:  20,
+	}
+	cfg.Signer.Keys.HDPath = defaultHDPath
+	return cfg
+}
+
+// Agent is an agent set up by New: dialed, its vaults resolved and checked,
+// its signer built. Close releases it.
+type Agent struct {
+	cfg     Config
+	limiter *rpcLimiter
+	logger  *slog.Logger
+	stdout  io.Writer // the reports' writer, after EventsOut took its share
+	events  *eventLog // nil without EventsOut
+	closers []func()
+
+	client    *ethclient.Client
+	rawClient *rpc.Client
+	txClient  *ethclient.Client
+	sender    *txBroadcaster
+	chainID   uint64
+	// How token amounts are printed; nil until the RPC is dialled.
+	amounts *amountFormatter
+	// Values them in dollars; nil without a USD feed.
+	usd *usdPricer
+
+	// What signs: signers as configured, vaultSigners the one picked for
+	// each of addrs, signer the first vault's, relay for Defender.
+	signers      []Signer
+	vaultSigners []Signer
+	signer       Signer
+	relay        *defenderRelay
+
+	// None before deploy mode, or when validate mode checks flags alone.
+	addrs     []common.Address
+	cABI      abi.ABI
+	twap      *twapbind.Twap
+	multicall bool
+	warp      *timeWarp // nil without AnvilControl
+	// Finds bot mode's vaults; nil without Factory.Address.
+	factory *vaultFactory
+
+	// Operator requests for Run's loop, from ResetBreaker, Pause and Resume.
+	resetC chan struct{}
+	pauseC chan pauseRequest
+}
+
+// pauseRequest is a Pause or Resume on its way to Run's loop.
+type pauseRequest struct {
+	paused bool
+	by     string
+}
+
+// New checks cfg and sets up what its mode needs, so a bad key, endpoint or
+// contract fails here rather than once running.
+func New(cfg Config) (*Agent, error) {
+	if cfg.Mode == "" {
+		cfg.Mode = "bot"
+	}
+	if err := cfg.check(); err != nil {
+		return nil, err
+	}
+	if cfg.Stdout == nil {
+		cfg.Stdout = os.Stdout
+	}
+	if cfg.Stderr == nil {
+		cfg.Stderr = os.Stderr
+	}
+	a := &Agent{cfg: cfg, limiter: newRPCLimiter(cfg.RPCRPS, cfg.CallTimeout), logger: newLogger(cfg.Log, cfg.Stderr), stdout: cfg.Stdout,
+		resetC: make(chan struct{}, 1), pauseC: make(chan pauseRequest, 1)}
+	if err := a.setup(); err != nil {
+		a.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// execMode is whether mode executes slices one at a time.
+func execMode(mode string) bool { return mode == "once" || mode == "execute" }
+
+// ownerMode is whether mode sends the owner's own transactions, with one key.
+func ownerMode(mode string) bool {
+	return mode == "cancel" || mode == "deposit" || mode == "withdraw" || mode == "deploy"
+}
+
+// check validates cfg's combinations and parses the settings that go into
+// Tx.
+func (cfg *Config) check() error {
+	mode, txCfg := cfg.Mode, &cfg.Tx
+	if mode == "devnet" {
+		return errors.New("devnet mode brings up its own chain; run it with RunDevnet")
+	}
+	if len(cfg.Contracts) > 1 && mode != "bot" {
+		return fmt.Errorf("--contract is given %d times; only bot mode runs several vaults", len(cfg.Contracts))
+	}
+	if len(cfg.Contracts) > 1 && cfg.Slice >= 0 {
+		return errors.New("--slice needs a single --contract")
+	}
+	if err := cfg.Factory.validate(cfg); err != nil {
+		return err
+	}
+	if cfg.RPC == "" || (len(cfg.Contracts) == 0 && cfg.Factory.Address == "" && mode != "deploy" && mode != "validate") {
+		return errors.New("rpc and contract are required")
+	}
+	if !validTxType(txCfg.TxType) {
+		return fmt.Errorf("invalid tx-type: %s", txCfg.TxType)
+	}
+	if cfg.Signer.From != "" && !common.IsHexAddress(cfg.Signer.From) {
+		return fmt.Errorf("invalid --from address: %s", cfg.Signer.From)
+	}
+	if txCfg.BumpPercent < minReplacementPercent {
+		// Nodes reject replacements that raise fees by less than 10%.
+		return fmt.Errorf("bump-percent must be at least %d, got %v", minReplacementPercent, txCfg.BumpPercent)
+	}
+	if err := cfg.Driver.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Log.validate(); err != nil {
+		return err
+	}
+	if cfg.AnvilControl != "" && mode != "bot" {
+		return fmt.Errorf("--anvil-control is not supported in %s mode", mode)
+	}
+	if cfg.API.Addr != "" && mode != "bot" {
+		return fmt.Errorf("--api-addr is not supported in %s mode", mode)
+	}
+	if cfg.API.Token != "" && cfg.API.Addr == "" {
+		return errors.New("--api-token requires --api-addr")
+	}
+	if err := cfg.Notify.validate(); err != nil {
+		return err
+	}
+	if cfg.Feed.AllEvents && mode != "bot" {
+		return fmt.Errorf("--all-events is not supported in %s mode", mode)
+	}
+	if cfg.StateFile != "" && mode != "bot" {
+		return fmt.Errorf("--state-file is not supported in %s mode", mode)
+	}
+	if f := cfg.Notify.flag(); f != "" && mode != "bot" {
+		return fmt.Errorf("%s is not supported in %s mode", f, mode)
+	}
+	if txCfg.DryRun && mode != "bot" && !execMode(mode) {
+		return fmt.Errorf("--dry-run is not supported in %s mode", mode)
+	}
+	if txCfg.DryRun && txCfg.UnsignedOut != "" {
+		return errors.New("--dry-run and --unsigned-out are mutually exclusive")
+	}
+	if execMode(mode) && txCfg.UnsignedOut != "" {
+		return fmt.Errorf("--unsigned-out is not supported in %s mode; use preflight mode", mode)
+	}
+	switch {
+	case mode == "execute" && cfg.Slice < 0:
+		return errors.New("execute mode requires --slice")
+	case cfg.Slice >= 0 && !execMode(mode) && mode != "bot":
+		return fmt.Errorf("--slice is not supported in %s mode", mode)
+	}
+	if !validFormat(cfg.Format) || (mode == "preflight" && cfg.Format == formatCSV) {
+		return fmt.Errorf("invalid --output for %s mode: %s", mode, cfg.Format)
+	}
+	oracle, err := loadOracleShape(cfg.OracleABI)
+	if err != nil {
+		return err
+	}
+	txCfg.Oracle = oracle
+	if txCfg.MaxOracleAge > 0 && oracle.Kind != oracleKindChainlink {
+		return errors.New("--max-oracle-age needs --oracle-abi chainlink: IOracle's getPrice has no updatedAt")
+	}
+	if cfg.OracleAddress != "" {
+		if !common.IsHexAddress(cfg.OracleAddress) {
+			return fmt.Errorf("invalid --oracle-address: %s", cfg.OracleAddress)
+		}
+		txCfg.Oracle.Address = common.HexToAddress(cfg.OracleAddress)
+	}
+	if cfg.QuoteAddress != "" {
+		if !common.IsHexAddress(cfg.QuoteAddress) {
+			return fmt.Errorf("invalid --quote-address: %s", cfg.QuoteAddress)
+		}
+		txCfg.Quoter.Address = common.HexToAddress(cfg.QuoteAddress)
+	}
+	if cfg.EthUSDFeed != "" {
+		if !common.IsHexAddress(cfg.EthUSDFeed) {
+			return fmt.Errorf("invalid --eth-usd-feed: %s", cfg.EthUSDFeed)
+		}
+		txCfg.USD.EthFeed = common.HexToAddress(cfg.EthUSDFeed)
+	}
+	for _, kv := range cfg.TokenUSDFeeds {
+		token, feed, ok := strings.Cut(kv, "=")
+		if !ok || !common.IsHexAddress(token) || !common.IsHexAddress(feed) {
+			return fmt.Errorf("invalid --token-usd-feed %q, want TOKEN=FEED addresses", kv)
+		}
+		if txCfg.USD.TokenFeeds == nil {
+			txCfg.USD.TokenFeeds = map[common.Address]common.Address{}
+		}
+		txCfg.USD.TokenFeeds[common.HexToAddress(token)] = common.HexToAddress(feed)
+	}
+	if cfg.FeedRegistry != "" {
+		if !common.IsHexAddress(cfg.FeedRegistry) {
+			return fmt.Errorf("invalid --feed-registry: %s", cfg.FeedRegistry)
+		}
+		txCfg.USD.Registry = common.HexToAddress(cfg.FeedRegistry)
+	}
+	txCfg.USD.MaxAge = cfg.USDMaxAge
+	if cfg.UniswapV3Fee >= 1<<24 {
+		return fmt.Errorf("uniswap-v3-fee must fit in a uint24, got %d", cfg.UniswapV3Fee)
+	}
+	txCfg.Quoter.Fee = uint32(cfg.UniswapV3Fee)
+	if cfg.ScheduleSlices < 0 {
+		return fmt.Errorf("schedule-slices must not be negative, got %d", cfg.ScheduleSlices)
+	}
+	if txCfg.CatchupParallel && !txCfg.Catchup {
+		return errors.New("--catchup-parallel requires --catchup")
+	}
+	if isHTTPURL(cfg.RPC) {
+		cfg.Feed.Poll = true
+	}
+	if cfg.RPCRPS < 0 {
+		return fmt.Errorf("rpc-rps must not be negative, got %v", cfg.RPCRPS)
+	}
+	if cfg.EventsOut != "" && mode != "bot" && !execMode(mode) {
+		return fmt.Errorf("--events-out is not supported in %s mode", mode)
+	}
+	if cfg.EventsOut == "-" && txCfg.UnsignedOut == "-" {
+		return errors.New("--events-out and --unsigned-out cannot both write to stdout")
+	}
+	if cfg.ABISource != "" && cfg.ABIPath != "" {
+		return errors.New("--abi and --abi-source are mutually exclusive")
+	}
+	return nil
+}
+
+// setup opens the event log, builds the signers, dials the endpoints and,
+// unless deploy mode has no vault yet, resolves and checks the vaults.
+func (a *Agent) setup() error {
+	cfg, mode := &a.cfg, a.cfg.Mode
+	if cfg.EventsOut != "" {
+		if cfg.EventsOut == "-" {
+			// Keep stdout for the records; the human-readable lines go with the log.
+			a.stdout = cfg.Stderr
+		}
+		l, err := openEventLog(cfg.EventsOut, cfg.Stdout)
+		if err != nil {
+			return err
+		}
+		a.events = l
+		a.closers = append(a.closers, func() { l.Close() })
+	}
+	ctx := a.scope(context.Background())
+	if cfg.Tx.Quoter.Kind != "" && !knownAdapterKind(cfg.Tx.Quoter.Kind) {
+		warnf(ctx, "unknown --adapter-kind %q, quotes will be unavailable", cfg.Tx.Quoter.Kind)
+	}
+
+	// The signers go first so a bad key, password or KMS setup fails early
+	if (mode == "bot" || execMode(mode)) && (cfg.DefenderAPIKey != "" || cfg.DefenderAPISecret != "") {
+		if cfg.Signer.Keys.local() || cfg.Signer.KMSKeyID != "" || cfg.Signer.RemoteURL != "" {
+			return errors.New("--defender-api-key cannot be combined with a local key, --kms-key-id or --remote-signer-url")
+		}
+		r, err := newDefenderRelay(ctx, cfg.DefenderAPIKey, cfg.DefenderAPISecret)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(outputFrom(ctx), "Relayer address: %s\n", r.Address().Hex())
+		a.relay = r
+	} else if mode == "bot" || execMode(mode) || ownerMode(mode) || mode == "propose" {
+		ss, err := buildSigners(ctx, cfg.Signer)
+		if err != nil {
+			return err
+		}
+		for _, s := range ss {
+			fmt.Fprintf(outputFrom(ctx), "Agent address: %s\n", s.Address().Hex())
+		}
+		a.signers = ss
+	}
+
+	rpcHeaders, err := cfg.RPCAuth.headers()
+	if err != nil {
+		return err
+	}
+	if len(rpcHeaders) > 0 {
+		logf(ctx, "sending headers to the RPC: %s", headerNames(rpcHeaders))
+	}
+	if a.client, a.rawClient, err = dialRPC(ctx, cfg.RPC, rpcHeaders); err != nil {
+		return fmt.Errorf("dial rpc: %w", err)
+	}
+	a.closers = append(a.closers, a.client.Close)
+	a.amounts = &amountFormatter{tokens: newTokenCache(a.client), raw: cfg.RawAmounts}
+	if cfg.Tx.USD.enabled() {
+		a.usd = newUSDPricer(a.client, cfg.Tx.USD, a.amounts.tokens)
+	}
+
+	// Optional separate endpoint for everything transaction-related
+	a.txClient = a.client
+	if cfg.TxRPC != "" && cfg.TxRPC != cfg.RPC {
+		if a.txClient, _, err = dialRPC(ctx, cfg.TxRPC, rpcHeaders); err != nil {
+			return fmt.Errorf("dial tx rpc: %w", err)
+		}
+		a.closers = append(a.closers, a.txClient.Close)
+		if err := checkSameChain(ctx, a.client, a.txClient); err != nil {
+			return err
+		}
+	}
+
+	a.sender = &txBroadcaster{public: a.txClient, fallbackBlocks: cfg.PrivateFallbackBlocks, relay: a.relay}
+	if cfg.PrivateRPC != "" {
+		priv, err := ethclient.DialContext(ctx, cfg.PrivateRPC)
+		if err != nil {
+			return fmt.Errorf("dial private rpc: %w", err)
+		}
+		a.closers = append(a.closers, priv.Close)
+		a.sender.private = priv
+	}
+
+	if mode == "deploy" {
+		if len(a.signers) != 1 {
+			return errors.New("deploy mode signs as the new vault's owner; pass one key")
+		}
+		if a.chainID, err = resolveChainID(ctx, a.client, cfg.ChainID); err != nil {
+			return err
+		}
+		a.setLogger()
+		return nil
+	}
+	if mode == "validate" && len(cfg.Contracts) == 0 {
+		return nil // before deployment: RunMode checks deploy mode's flags instead
+	}
+
+	// Bot mode's other vaults; the first one stands for all in the setup below.
+	for _, c := range cfg.Contracts {
+		addr, err := resolveAddress(ctx, a.client, "--contract", c)
+		if err != nil {
+			return err
+		}
+		if !common.IsHexAddress(c) {
+			fmt.Fprintf(outputFrom(ctx), "Resolved %s to %s\n", c, addr.Hex())
+		}
+		for _, seen := range a.addrs {
+			if addr == seen {
+				return fmt.Errorf("--contract %s is given twice", addr.Hex())
+			}
+		}
+		a.addrs = append(a.addrs, addr)
+	}
+
+	// Resolve the chain ID once: detect it, or check --chain-id against the node
+	if a.chainID, err = resolveChainID(ctx, a.client, cfg.ChainID); err != nil {
+		return err
+	}
+	a.setLogger()
+	ctx = a.scope(context.Background())
+
+	// ABI: embedded, --abi, or fetched from an explorer into a local cache
+	abiPath := cfg.ABIPath
+	if cfg.ABISource != "" {
+		if len(a.addrs) == 0 {
+			return errors.New("--abi-source fetches the ABI of a --contract; with --factory alone pass --abi")
+		}
+		addr := a.addrs[0]
+		path, err := fetchABIToCache(ctx, newABIFetcher(cfg.EtherscanAPIKey), cfg.ABISource, a.chainID, addr, cfg.ABICacheDir, cfg.ABIRefresh)
+		if err != nil {
+			return fmt.Errorf("fetch abi: %w", err)
+		}
+		fmt.Fprintf(outputFrom(ctx), "ABI for %s from %s cached at %s\n", addr.Hex(), cfg.ABISource, path)
+		abiPath = path
+	}
+	cABI, abiSource, err := loadTwapABI(abiPath)
+	if err != nil {
+		return err
+	}
+	logf(ctx, "using %s", abiSource)
+	a.cABI = cABI
+
+	if len(a.addrs) > 0 {
+		a.twap = twapbind.NewTwap(a.addrs[0], cABI, a.client, a.txClient, a.client)
+	}
+	switch mode {
+	case "preflight", "bot", "watch", "events", "replay", "schedule", "simulate", "validate", "once", "execute", "cancel", "deposit", "withdraw":
+		for _, addr := range a.addrs {
+			if err := checkContract(ctx, addr, cABI, a.client, a.chainID); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch {
+	case mode == "propose" && len(a.signers) > 1:
+		return errors.New("propose mode signs as a single Safe owner; pass one key")
+	case ownerMode(mode) && len(a.signers) > 1:
+		return fmt.Errorf("%s mode signs as the vault owner; pass one key", mode)
+	case len(a.signers) > 0:
+		// Each vault signs with its own agent's key; with several vaults
+		// even a single key must be theirs.
+		pick := agentSigner
+		if len(a.addrs) > 1 || cfg.Factory.Address != "" {
+			pick = matchAgentSigner
+		}
+		for _, addr := range a.addrs {
+			s, err := pick(ctx, addr, cABI, a.client, a.signers)
+			if err != nil {
+				return err
+			}
+			if len(a.signers) > 1 {
+				fmt.Fprintf(outputFrom(ctx), "Using %s for %s\n", s.Address().Hex(), addr.Hex())
+			}
+			a.vaultSigners = append(a.vaultSigners, s)
+		}
+	case cfg.Tx.DryRun && a.relay == nil:
+		for _, addr := range a.addrs {
+			from, err := dryRunFrom(ctx, addr, cABI, a.client, cfg.Signer.From)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(outputFrom(ctx), "Dry run: simulating %s as %s\n", addr.Hex(), from.Hex())
+			a.vaultSigners = append(a.vaultSigners, dryRunSigner{from})
+		}
+	}
+
+	// The factory's vaults join those of --contract, with their signers
+	if cfg.Factory.Address != "" {
+		if a.factory, err = newVaultFactory(ctx, a.client, cfg.Factory); err != nil {
+			return err
+		}
+		head, err := blockNumber(ctx, a.client)
+		if err != nil {
+			return err
+		}
+		found, err := a.factory.discover(ctx, a.client, head)
+		if err != nil {
+			return err
+		}
+		for _, addr := range found {
+			if containsAddress(a.addrs, addr) {
+				continue
+			}
+			s, ok := a.admitVault(ctx, addr)
+			if !ok {
+				continue
+			}
+			if s != nil {
+				a.vaultSigners = append(a.vaultSigners, s)
+			}
+			a.addrs = append(a.addrs, addr)
+		}
+		logf(ctx, "factory %s: running %d vaults", a.factory.addr.Hex(), len(a.addrs))
+	}
+	if len(a.vaultSigners) > 0 {
+		a.signer = a.vaultSigners[0]
+	}
+
+	// Multicall3 for the bot's per-block reads, where deployed
+	if mode == "bot" {
+		if a.multicall, err = detectMulticall(ctx, a.client, cfg.Multicall); err != nil {
+			return err
+		}
+		if a.multicall {
+			logf(ctx, "reading vault state through Multicall3 at %s", multicall3Address.Hex())
+		}
+		if cfg.AnvilControl != "" {
+			if a.warp, err = dialTimeWarp(ctx, cfg.AnvilControl, rpcHeaders, a.chainID, cfg.IKnowWhatImDoing); err != nil {
+				return err
+			}
+			a.closers = append(a.closers, a.warp.Close)
+			logf(ctx, "warping chain %d's clock to each slice through %s", a.chainID, cfg.AnvilControl)
+		}
+	}
+	return nil
+}
+
+// Close closes the endpoints and the event log.
+func (a *Agent) Close() {
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		a.closers[i]()
+	}
+	a.closers = nil
+}
+
+// scope gives ctx the agent's rpc limiter, logger, output, event log, and
+// amount formatting and pricing.
+func (a *Agent) scope(ctx context.Context) context.Context {
+	ctx = withRPCLimiter(ctx, a.limiter)
+	ctx = withLogger(ctx, a.logger)
+	ctx = withOutput(ctx, a.stdout)
+	if a.events != nil {
+		ctx = withEventLog(ctx, a.events)
+	}
+	if a.amounts != nil {
+		ctx = withAmountFormatter(ctx, a.amounts)
+	}
+	if a.usd != nil {
+		ctx = withUSDPricer(ctx, a.usd)
+	}
+	return ctx
+}
+
+// setLogger adds the chain id to the logger's records, and the vault's
+// address for a single one; several vaults, or a factory's, label their
+// own records.
+func (a *Agent) setLogger() {
+	a.logger = newLogger(a.cfg.Log, a.cfg.Stderr).With("chainId", a.chainID)
+	if len(a.addrs) == 1 && a.cfg.Factory.Address == "" {
+		a.logger = a.logger.With("contract", a.addrs[0].Hex())
+	}
+}
+
+// Logger is the logger the agent writes its records with, for an embedding
+// program's own; the CLI makes it slog's default.
+func (a *Agent) Logger() *slog.Logger { return a.logger }
+
+// from is the configured --from address, zero if unset.
+func (a *Agent) from() common.Address {
+	if a.cfg.Signer.From == "" {
+		return common.Address{}
+	}
+	return common.HexToAddress(a.cfg.Signer.From)
+}
+
+// executorFor is the executor the modes outside bot mode use for addr,
+// signing with the first vault's signer. Its state has only the tx endpoint
+// and sender; once mode adds what executing a slice needs.
+func (a *Agent) executorFor(addr common.Address) *executor {
+	return &executor{addr: addr, cABI: a.cABI, twap: twapbind.NewTwap(addr, a.cABI, a.client, a.txClient, a.client), client: a.client,
+		signer: a.signer, chainID: a.chainID, txCfg: a.cfg.Tx, st: &botState{txClient: a.txClient, sender: a.sender}}
+}
+
+// vault is the first vault, or an error if there is none yet.
+func (a *Agent) vault() (common.Address, error) {
+	if len(a.addrs) == 0 {
+		return common.Address{}, fmt.Errorf("no vault in %s mode", a.cfg.Mode)
+	}
+	return a.addrs[0], nil
+}
+
+// Run is bot mode: it evaluates the vaults as blocks arrive and submits their
+// slices as they come due. It returns once ctx is canceled, after waiting out
+// Tx.ShutdownGrace for submitted txs, or with End.ExitOnComplete once every
+// order has ended; ExitCode maps what it returns. ResetBreaker, Pause and
+// Resume act on it while it runs. With Factory it also takes on the vaults
+// the factory creates, and may start with none.
+func (a *Agent) Run(ctx context.Context) error {
+	if _, err := a.vault(); err != nil && a.factory == nil {
+		return err
+	}
+	return a.bot(a.scope(ctx))
+}
+
+// ResetBreaker closes a tripped circuit breaker on every vault Run is
+// executing. A request made while Run isn't running waits for it.
+func (a *Agent) ResetBreaker() {
+	select {
+	case a.resetC <- struct{}{}:
+	default: // one is already waiting
+	}
+}
+
+// Pause stops Run from submitting to any vault until Resume; heads and logs
+// are still followed. by names who asked, for the log.
+func (a *Agent) Pause(by string) { a.requestPause(true, by) }
+
+// Resume lets Run submit again after Pause or Tx.StartPaused.
+func (a *Agent) Resume(by string) { a.requestPause(false, by) }
+
+func (a *Agent) requestPause(paused bool, by string) {
+	for {
+		select {
+		case a.pauseC <- pauseRequest{paused: paused, by: by}:
+			return
+		default:
+		}
+		// Only the latest request counts: drop one Run hasn't taken yet.
+		select {
+		case <-a.pauseC:
+		default:
+		}
+	}
+}
+
+// ExecuteSlice submits slice id of the first vault as execute mode does and
+// waits for it to mine, returning the tx's hash. Unless Tx.Force is set the
+// slice must be due.
+func (a *Agent) ExecuteSlice(ctx context.Context, id int64) (common.Hash, error) {
+	addr, err := a.vault()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if id < 0 {
+		return common.Hash{}, fmt.Errorf("invalid slice %d", id)
+	}
+	return a.once(a.scope(ctx), addr, id)
+}
+
+// Preflight reads what preflight mode prints about the first vault.
+func (a *Agent) Preflight(ctx context.Context) (PreflightReport, error) {
+	addr, err := a.vault()
+	if err != nil {
+		return PreflightReport{}, err
+	}
+	r, err := a.buildPreflight(a.scope(ctx), addr)
+	if err != nil {
+		return PreflightReport{}, err
+	}
+	return *r, nil
+}
+
+// RunMode runs the Config's Mode as the CLI does.
+func (a *Agent) RunMode(ctx context.Context) error {
+	cfg, mode := &a.cfg, a.cfg.Mode
+	ctx = a.scope(ctx)
+	switch mode {
+	case "deploy":
+		_, err := a.deployVault(ctx)
+		return err
+	case "validate":
+		if len(a.addrs) == 0 {
+			s, err := cfg.Deploy.parse()
+			if err != nil {
+				return err
+			}
+			return validateStrategy(ctx, a.client, s, false, cfg.Deploy.agent())
+		}
+	case "bot":
+		if a.factory != nil {
+			return a.Run(ctx) // with no vault yet, until the factory creates one
+		}
+	}
+	addr, err := a.vault()
+	if err != nil {
+		return err
+	}
+	switch mode {
+	case "preflight":
+		if cfg.Tx.UnsignedOut != "-" {
+			if err := a.preflight(ctx, addr); err != nil {
+				return err
+			}
+		}
+		if cfg.Tx.UnsignedOut != "" {
+			return emitNextUnsigned(ctx, addr, a.cABI, a.client, a.chainID, cfg.Tx, a.from())
+		}
+		return nil
+	case "bot":
+		if cfg.Slice >= 0 {
+			// The operator's pick goes first; the loop takes over either way.
+			if _, err := a.ExecuteSlice(ctx, cfg.Slice); err != nil {
+				logf(ctx, "--slice %d: %v", cfg.Slice, err)
+			}
+		}
+		return a.Run(ctx)
+	case "once", "execute":
+		_, err := a.once(ctx, addr, cfg.Slice)
+		return err
+	case "watch":
+		return watch(ctx, addr, a.cABI, a.client, a.rawClient, cfg.Feed)
+	case "cancel":
+		return a.executorFor(addr).cancelOrder(ctx)
+	case "deposit":
+		return a.executorFor(addr).deposit(ctx)
+	case "withdraw":
+		var to common.Address
+		if cfg.WithdrawTo != "" {
+			if to, err = resolveAddress(ctx, a.client, "--to", cfg.WithdrawTo); err != nil {
+				return err
+			}
+		}
+		return a.executorFor(addr).withdraw(ctx, to)
+	case "events":
+		return events(ctx, addr, a.cABI, a.client, cfg.Feed, cfg.Events)
+	case "simulate":
+		from, err := dryRunFrom(ctx, addr, a.cABI, a.client, cfg.Signer.From)
+		if err != nil {
+			return err
+		}
+		return simulateAll(ctx, addr, a.cABI, a.client, a.rawClient, from, cfg.Tx.Oracle)
+	case "replay":
+		return replay(ctx, addr, a.cABI, a.client, cfg.Events.ChunkBlocks, cfg.Format, cfg.Out)
+	case "schedule":
+		return schedule(ctx, addr, a.cABI, a.client, a.rawClient, cfg.ScheduleSlices, cfg.Format, cfg.Out)
+	case "validate":
+		return validateDeployed(ctx, addr, a.cABI, a.client)
+	case "report":
+		if cfg.Format == formatText {
+			return report(ctx, addr, a.cABI, a.client, cfg.ReceiptsFile)
+		}
+		return reportFills(ctx, addr, a.cABI, a.client, cfg.Tx.Oracle, cfg.Events.ChunkBlocks, cfg.Format, cfg.Out)
+	case "propose":
+		return propose(ctx, addr, a.cABI, a.client, a.signer, a.chainID, cfg.Safe, cfg.Tx)
+	}
+	return fmt.Errorf("unknown mode: %s", mode)
+}
+
+// ExitCode is the CLI's exit status for an error from RunMode or Run, ctx
+// being the one they ran with. It logs what the operator should see on the
+// way out; anything unexpected is logged and 1.
+func ExitCode(ctx context.Context, err error) int {
+	if err == nil {
+		return 0
+	}
+	var end *orderEnd
+	if errors.As(err, &end) {
+		return end.Code
+	}
+	var pending *pendingTxError
+	switch {
+	case errors.As(err, &pending):
+		logf(ctx, "%v", err)
+		return ExitPendingTx
+	case errors.Is(err, errInterrupted) || (ctx.Err() != nil && errors.Is(err, context.Canceled)):
+		logf(ctx, "shut down cleanly")
+		return ExitInterrupted
+	case errors.Is(err, errNothingDue):
+		return ExitNotDue
+	case errors.Is(err, errAlreadyFunded):
+		fmt.Fprintln(outputFrom(ctx), err)
+		return 0
+	case errors.Is(err, errSliceNotDue):
+		logf(ctx, "%v", err)
+		return ExitNotDue
+	}
+	logf(ctx, "%v", err)
+	return 1
+}
@@ -0,0 +1,254 @@
+package twapagent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// devnetConfig is a config for startDevnet that mines a block every period
+// (only with transactions if 0) and deploys without an artifact.
+func devnetConfig(t *testing.T, period time.Duration) Config {
+	cfg := DefaultConfig()
+	cfg.Deploy.Artifact = filepath.Join(t.TempDir(), "missing.json")
+	cfg.Devnet.BlockPeriod = period
+	cfg.Tx.ReceiptPollInterval = 10 * time.Millisecond
+	return cfg
+}
+
+func startTestDevnet(t *testing.T, cfg Config) *devnet {
+	t.Helper()
+	d, err := startDevnet(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.close)
+	return d
+}
+
+func TestRunDrivesStrategyToCompletion(t *testing.T) {
+	cfg := devnetConfig(t, 5*time.Millisecond)
+	d := startTestDevnet(t, cfg)
+	a, err := New(d.botConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err = a.Run(ctx)
+	var end *orderEnd
+	if !errors.As(err, &end) || end.Code != ExitFilled {
+		t.Fatalf("Run = %v, want the order filled", err)
+	}
+	if ExitCode(ctx, err) != 0 {
+		t.Errorf("exit code %d, want 0", ExitCode(ctx, err))
+	}
+	v := d.chain.vault(d.vault)
+	d.chain.mu.Lock()
+	defer d.chain.mu.Unlock()
+	for id := int64(0); id < devSlices; id++ {
+		if !v.done[id] {
+			t.Errorf("slice %d not executed", id)
+		}
+	}
+	if v.status != StatusFilled || v.filled.Cmp(v.s.TotalAmountIn) != 0 {
+		t.Errorf("vault %s with %s of %s filled, want Filled", v.status, v.filled, v.s.TotalAmountIn)
+	}
+}
+
+// Resume reaches Run's loop even when asked for before Run starts.
+func TestResumeStartsAPausedRun(t *testing.T) {
+	cfg := devnetConfig(t, 5*time.Millisecond)
+	d := startTestDevnet(t, cfg)
+	bcfg := d.botConfig(cfg)
+	bcfg.Tx.StartPaused = true
+	a, err := New(bcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	a.Pause("test")
+	a.Resume("test")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var end *orderEnd
+	if err := a.Run(ctx); !errors.As(err, &end) || end.Code != ExitFilled {
+		t.Fatalf("Run = %v, want the order filled once resumed", err)
+	}
+}
+
+// Two vaults with different agents: each is executed with its own key, and
+// without that key bot mode refuses to start.
+func TestRunVaultsWithTheirOwnAgents(t *testing.T) {
+	cfg := devnetConfig(t, 5*time.Millisecond)
+	// Deploy both vaults before blocks start ticking, so the second order's
+	// start isn't already past by the time configureStrategy mines.
+	d := startTestDevnet(t, devnetConfig(t, 0))
+	agent2, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault2, err := d.deploy(context.Background(), cfg, crypto.PubkeyToAddress(agent2.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.mineEvery(context.Background(), cfg.Devnet.BlockPeriod)
+	bcfg := d.botConfig(cfg)
+	bcfg.Contracts = append(bcfg.Contracts, vault2.Hex())
+	if _, err := New(bcfg); err == nil || !strings.Contains(err.Error(), vault2.Hex()) {
+		t.Fatalf("New with only the first vault's key: %v, want %s's agent missing", err, vault2.Hex())
+	}
+
+	bcfg.Signer.Keys.Hex = append(bcfg.Signer.Keys.Hex, hexutil.Encode(crypto.FromECDSA(agent2)))
+	a, err := New(bcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var end *orderEnd
+	if err := a.Run(ctx); !errors.As(err, &end) || end.Code != ExitFilled {
+		t.Fatalf("Run = %v, want both orders filled", err)
+	}
+	for _, addr := range []common.Address{d.vault, vault2} {
+		v := d.chain.vault(addr)
+		d.chain.mu.Lock()
+		status := v.status
+		d.chain.mu.Unlock()
+		if status != StatusFilled {
+			t.Errorf("vault %s is %s, want Filled", addr.Hex(), status)
+		}
+	}
+}
+
+func TestExecuteSliceReturnsMinedTx(t *testing.T) {
+	cfg := devnetConfig(t, 0)
+	d := startTestDevnet(t, cfg)
+	a, err := New(d.botConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	ctx := context.Background()
+
+	if _, err := a.ExecuteSlice(ctx, 0); !errors.Is(err, errSliceNotDue) {
+		t.Fatalf("executing slice 0 before the start: %v, want %v", err, errSliceNotDue)
+	}
+	d.chain.warp(d.chain.vault(d.vault).s.StartTime.Uint64())
+	hash, err := a.ExecuteSlice(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := d.chain.GetTransactionReceipt(hash); r == nil || r.Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("ExecuteSlice returned %s, not a successful tx", hash.Hex())
+	}
+	if _, err := a.ExecuteSlice(ctx, 0); !errors.Is(err, errSliceDone) {
+		t.Errorf("executing slice 0 again: %v, want %v", err, errSliceDone)
+	}
+	report, err := a.Preflight(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.TotalSlices != devSlices || report.NextOpenSlice == nil || *report.NextOpenSlice != 1 {
+		t.Errorf("preflight: %d slices, next open %v; want %d and slice 1", report.TotalSlices, report.NextOpenSlice, devSlices)
+	}
+	if report.FilledAmountIn != devSliceAmount.String() {
+		t.Errorf("preflight: filledAmountIn %s, want %s", report.FilledAmountIn, devSliceAmount)
+	}
+}
+
+// The double keeps the contract's guards: only the agent executes, and the
+// price may not leave the deviation band.
+func TestDevVaultGuards(t *testing.T) {
+	cfg := devnetConfig(t, 0)
+	d := startTestDevnet(t, cfg)
+	v := d.chain.vault(d.vault)
+	d.chain.warp(v.s.StartTime.Uint64())
+	data, err := d.chain.cABI.Pack("executeSlice", big.NewInt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.chain.Call(devCallArgs{From: &d.vault, To: &d.vault, Data: data}, "latest"); err == nil || err.Error() != "execution reverted: AGENT" {
+		t.Errorf("executeSlice from the vault itself: %v, want AGENT", err)
+	}
+
+	a, err := New(d.botConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	d.chain.setPrice(big.NewInt(3e18)) // 50% over the reference price
+	if _, err := a.ExecuteSlice(context.Background(), 0); err == nil {
+		t.Error("executing at a deviated price succeeded")
+	}
+	if v.sliceDone(big.NewInt(0)) {
+		t.Error("slice 0 done despite PRICE_DEVIATION")
+	}
+}
+
+// An embedded agent writes to the Config's writers and leaves the process's
+// stdout and default logger as they were.
+func TestAgentKeepsToItsWriters(t *testing.T) {
+	cfg := devnetConfig(t, 0)
+	d := startTestDevnet(t, cfg)
+	defaultLogger, stdout := slog.Default(), os.Stdout
+	var out, errOut bytes.Buffer
+	pcfg := d.config(cfg, d.agent)
+	pcfg.Mode, pcfg.Stdout, pcfg.Stderr = "preflight", &out, &errOut
+	a, err := New(pcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if err := a.RunMode(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "Preflight:") {
+		t.Errorf("Stdout got %q, want the preflight report", out.String())
+	}
+	if slog.Default() != defaultLogger || os.Stdout != stdout {
+		t.Error("New replaced the process's default logger or stdout")
+	}
+}
+
+func TestRunDevnetFillsTheOrder(t *testing.T) {
+	cfg := devnetConfig(t, 5*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := RunDevnet(ctx, cfg)
+	if code := ExitCode(ctx, err); code != 0 {
+		t.Errorf("RunDevnet = %v (exit code %d), want the order filled", err, code)
+	}
+}
+
+func TestNewRejectsBadConfig(t *testing.T) {
+	for name, edit := range map[string]func(*Config){
+		"no rpc":           func(c *Config) { c.RPC = "" },
+		"several vaults":   func(c *Config) { c.Mode, c.Contracts = "once", []string{"0x01", "0x02"} },
+		"execute no slice": func(c *Config) { c.Mode = "execute" },
+		"low bump":         func(c *Config) { c.Tx.BumpPercent = 5 },
+		"devnet":           func(c *Config) { c.Mode = "devnet" },
+	} {
+		cfg := DefaultConfig()
+		cfg.RPC, cfg.Contracts = "http://127.0.0.1:0", []string{"0x01"}
+		edit(&cfg)
+		if _, err := New(cfg); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
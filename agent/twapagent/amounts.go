@@ -0,0 +1,69 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// amountFormatterKey carries how a mode prints amounts of the order's
+// tokens: in whole tokens with their symbol, each token's metadata read
+// once, unless --raw-amounts.
+type amountFormatterKey struct{}
+
+type amountFormatter struct {
+	tokens *tokenCache
+	raw    bool
+}
+
+func withAmountFormatter(ctx context.Context, f *amountFormatter) context.Context {
+	return context.WithValue(ctx, amountFormatterKey{}, f)
+}
+
+// orderAmounts prints amounts of an order's tokenIn and tokenOut. The zero
+// value prints them raw.
+type orderAmounts struct{ in, out tokenInfo }
+
+// orderAmountsFor is how ctx prints s's amounts: raw with --raw-amounts,
+// or without a formatter at all, as for the library's callers.
+func orderAmountsFor(ctx context.Context, s Strategy) orderAmounts {
+	f, _ := ctx.Value(amountFormatterKey{}).(*amountFormatter)
+	if f == nil || f.raw || f.tokens == nil {
+		return orderAmounts{}
+	}
+	var a orderAmounts
+	if s.TokenIn != (common.Address{}) {
+		a.in = f.tokens.get(ctx, s.TokenIn)
+	}
+	if s.TokenOut != (common.Address{}) {
+		a.out = f.tokens.get(ctx, s.TokenOut)
+	}
+	return a
+}
+
+// In renders an amount of tokenIn, e.g. "250 USDC".
+func (a orderAmounts) In(v *big.Int) string { return a.in.human(v) }
+
+// Out renders an amount of tokenOut.
+func (a orderAmounts) Out(v *big.Int) string { return a.out.human(v) }
+
+// human is format for a token with decimals, and v as %s prints it
+// otherwise, so output that never knew the token stays as it was.
+func (t tokenInfo) human(v *big.Int) string {
+	if !t.Known || v == nil {
+		return fmt.Sprint(v)
+	}
+	return t.format(v)
+}
+
+// parseDecimal reads the decimal strings reports carry their amounts in;
+// nil if s isn't one.
+func parseDecimal(s string) *big.Int {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil
+	}
+	return v
+}
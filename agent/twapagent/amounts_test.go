@@ -0,0 +1,86 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+var (
+	metaUSDC  = common.Address{0x01} // string symbol, 6 decimals
+	metaMKR   = common.Address{0x02} // bytes32 symbol
+	metaNoSym = common.Address{0x03} // decimals() only
+	metaBare  = common.Address{0x04} // neither
+)
+
+// tokenMetaEth answers symbol() and decimals() the ways tokens do, counting
+// the calls.
+type tokenMetaEth struct{ calls int }
+
+func (f *tokenMetaEth) Call(args fakeCallArgs, _ string) (hexutil.Bytes, error) {
+	f.calls++
+	m, err := erc20ABI.MethodById(args.Data)
+	if err != nil {
+		return nil, err
+	}
+	switch to := *args.To; {
+	case m.Name == "decimals" && to == metaUSDC:
+		return m.Outputs.Pack(uint8(6))
+	case m.Name == "decimals" && (to == metaMKR || to == metaNoSym):
+		return m.Outputs.Pack(uint8(18))
+	case m.Name == "symbol" && to == metaUSDC:
+		return m.Outputs.Pack("USDC")
+	case m.Name == "symbol" && to == metaMKR:
+		var b [32]byte
+		copy(b[:], "MKR")
+		return b[:], nil
+	}
+	return nil, errors.New("execution reverted")
+}
+
+func TestReadTokenInfo(t *testing.T) {
+	client := dialFakeEth(t, &tokenMetaEth{})
+	for token, want := range map[common.Address]tokenInfo{
+		metaUSDC:  {Symbol: "USDC", Decimals: 6, Known: true},
+		metaMKR:   {Symbol: "MKR", Decimals: 18, Known: true},
+		metaNoSym: {Symbol: metaNoSym.Hex(), Decimals: 18, Known: true},
+		metaBare:  {Symbol: metaBare.Hex()},
+	} {
+		if got := readTokenInfo(context.Background(), client, token); got != want {
+			t.Errorf("readTokenInfo(%s) = %+v, want %+v", token.Hex(), got, want)
+		}
+	}
+}
+
+func TestOrderAmountsFor(t *testing.T) {
+	eth := &tokenMetaEth{}
+	tokens := newTokenCache(dialFakeEth(t, eth))
+	s := Strategy{TokenIn: metaUSDC, TokenOut: metaBare}
+	v := big.NewInt(250_000_000)
+
+	ctx := withAmountFormatter(context.Background(), &amountFormatter{tokens: tokens})
+	a := orderAmountsFor(ctx, s)
+	if got := a.In(v); got != "250 USDC" {
+		t.Errorf("In = %q, want 250 USDC", got)
+	}
+	// A token without decimals() stays raw.
+	if got := a.Out(v); got != "250000000" {
+		t.Errorf("Out = %q, want the raw amount", got)
+	}
+	calls := eth.calls
+	orderAmountsFor(ctx, s)
+	if eth.calls != calls {
+		t.Errorf("the token metadata was read again: %d calls, then %d", calls, eth.calls)
+	}
+
+	raw := withAmountFormatter(context.Background(), &amountFormatter{tokens: tokens, raw: true})
+	for name, ctx := range map[string]context.Context{"--raw-amounts": raw, "no formatter": context.Background()} {
+		if got := orderAmountsFor(ctx, s).In(v); got != "250000000" {
+			t.Errorf("%s: In = %q, want the raw amount", name, got)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package twapagent
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// APIConfig is bot mode's status API.
+type APIConfig struct {
+	// Where to serve it, e.g. 127.0.0.1:8080 ("" = off).
+	Addr string
+	// Enables POST /pause and /resume for requests bearing it.
+	Token string
+}
+
+// apiServer serves the state of bot mode's vaults as JSON. It only reads:
+// the bot loop owns the state, and pause and resume requests go to it
+// through control.
+type apiServer struct {
+	cfg     APIConfig
+	chainID uint64
+	// The vaults, which change as a factory creates and ends them.
+	mu      sync.RWMutex
+	vaults  []*vaultBot
+	byAddr  map[common.Address]*vaultBot
+	catchup bool
+	control chan apiControl
+	srv     *http.Server
+}
+
+// apiControl is a POST /pause or /resume for the bot loop to apply to
+// vaults, nil for every one; changed is sent back whether any of them
+// changed.
+type apiControl struct {
+	vaults  []*vaultBot
+	paused  bool
+	changed chan bool
+}
+
+// vaultView is what the API shows of a vault beyond what botState already
+// holds: the last block's reads, the last submission, and this run's fills.
+type vaultView struct {
+	mu       sync.Mutex
+	head     uint64
+	headTime uint64
+	status   *Status
+	filled   *big.Int
+	lastTx   *apiSubmission
+	fills    []apiFill
+}
+
+type apiSubmission struct {
+	Slice int64  `json:"slice"`
+	Tx    string `json:"tx"`
+	// submitted, mined or failed.
+	Result string `json:"result"`
+	// Why it failed: reverted, timeout, canceled, shutdown, or the error.
+	Reason  string    `json:"reason,omitempty"`
+	Block   uint64    `json:"block,omitempty"`
+	GasUsed uint64    `json:"gasUsed,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+type apiFill struct {
+	Slice     int64  `json:"slice"`
+	AmountIn  string `json:"amountIn"`
+	AmountOut string `json:"amountOut"`
+	Fee       string `json:"fee"`
+	Tx        string `json:"tx"`
+	Block     uint64 `json:"block"`
+}
+
+// apiStatus is GET /status.
+type apiStatus struct {
+	Contract checksumAddress `json:"contract"`
+	ChainID  uint64          `json:"chainId"`
+	// The latest block handled, and the reads made at it; status and
+	// filledAmountIn are omitted until read.
+	Block     uint64 `json:"block"`
+	BlockTime uint64 `json:"blockTime"`
+	Status    string `json:"status,omitempty"`
+	// How the status got there, oldest first.
+	StatusHistory  []statusTransition `json:"statusHistory,omitempty"`
+	Strategy       *preflightStrategy `json:"strategy"`
+	FilledAmountIn string             `json:"filledAmountIn,omitempty"`
+	Progress       *orderProgress     `json:"progress,omitempty"`
+	// The first slice not done nor given up on, and when it is due;
+	// omitted when there is none or the slice state isn't loaded yet.
+	NextSlice      *int64         `json:"nextSlice,omitempty"`
+	NextSliceAt    uint64         `json:"nextSliceAt,omitempty"`
+	LastSubmission *apiSubmission `json:"lastSubmission,omitempty"`
+	// The balance in wei of the key executing the vault, as last read.
+	AgentBalance string `json:"agentBalance,omitempty"`
+	Paused       bool   `json:"paused"`
+	Halted       bool   `json:"halted"`
+	HaltReason   string `json:"haltReason,omitempty"`
+}
+
+// newAPIServer builds the server. Its tap goes on the bot's ctx before the
+// vaults are set up, and serve then gives it the vaults.
+func newAPIServer(cfg APIConfig, chainID uint64, catchup bool) *apiServer {
+	return &apiServer{cfg: cfg, chainID: chainID, byAddr: map[common.Address]*vaultBot{}, catchup: catchup, control: make(chan apiControl)}
+}
+
+// serve listens on cfg.Addr and serves vaults, which must have a view,
+// until Close.
+func (a *apiServer) serve(ctx context.Context, vaults []*vaultBot) error {
+	a.track(vaults)
+	ln, err := net.Listen("tcp", a.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("api: %w", err)
+	}
+	a.srv = &http.Server{Handler: a, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := a.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errorf(ctx, "api: %v", err)
+		}
+	}()
+	pause := "read-only"
+	if a.cfg.Token != "" {
+		pause = "POST /pause and /resume with --api-token"
+	}
+	logf(ctx, "serving the status API on http://%s (%s)", ln.Addr(), pause)
+	return nil
+}
+
+// track makes vaults the ones served, each of which must have a view.
+func (a *apiServer) track(vaults []*vaultBot) {
+	byAddr := make(map[common.Address]*vaultBot, len(vaults))
+	for _, v := range vaults {
+		byAddr[v.addr] = v
+	}
+	a.mu.Lock()
+	a.vaults, a.byAddr = vaults, byAddr
+	a.mu.Unlock()
+}
+
+// snapshot is the vaults served now.
+func (a *apiServer) snapshot() ([]*vaultBot, map[common.Address]*vaultBot) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.vaults, a.byAddr
+}
+
+func (a *apiServer) Close() {
+	if a.srv != nil {
+		a.srv.Close()
+	}
+}
+
+// tap records the submissions and fills of the vault rec is about.
+func (a *apiServer) tap(rec eventRecord) {
+	vaults, byAddr := a.snapshot()
+	if len(vaults) == 0 {
+		return // still setting up
+	}
+	v := vaults[0]
+	if rec.Contract != "" {
+		if v = byAddr[common.HexToAddress(rec.Contract)]; v == nil {
+			return
+		}
+	}
+	view := v.st.view
+	slice, _ := rec.Data["slice"].(int64)
+	tx, _ := rec.Data["tx"].(string)
+	view.mu.Lock()
+	defer view.mu.Unlock()
+	switch rec.Type {
+	case evTxSubmitted:
+		view.lastTx = &apiSubmission{Slice: slice, Tx: tx, Result: "submitted", Time: rec.Time}
+	case evTxMined:
+		gasUsed, _ := rec.Data["gasUsed"].(uint64)
+		view.lastTx = &apiSubmission{Slice: slice, Tx: tx, Result: "mined", Block: rec.Block, GasUsed: gasUsed, Time: rec.Time}
+	case evTxFailed:
+		reason, _ := rec.Data["reason"].(string)
+		view.lastTx = &apiSubmission{Slice: slice, Tx: tx, Result: "failed", Reason: reason, Time: rec.Time}
+	case evFill:
+		if removed, _ := rec.Data["removed"].(bool); removed {
+			for i, f := range view.fills {
+				if f.Slice == slice && f.Tx == tx {
+					view.fills = append(view.fills[:i], view.fills[i+1:]...)
+					// Until the next block's read.
+					if in, ok := new(big.Int).SetString(f.AmountIn, 10); ok && view.filled != nil && view.filled.Cmp(in) >= 0 {
+						view.filled = new(big.Int).Sub(view.filled, in)
+					}
+					break
+				}
+			}
+			return
+		}
+		f := apiFill{Slice: slice, Tx: tx, Block: rec.Block}
+		f.AmountIn, _ = rec.Data["amountIn"].(string)
+		f.AmountOut, _ = rec.Data["amountOut"].(string)
+		f.Fee, _ = rec.Data["fee"].(string)
+		view.fills = append(view.fills, f)
+	}
+}
+
+// observeBlock records what handleBlock read at the head.
+func (view *vaultView) observeBlock(number, time uint64, reads blockReads) {
+	view.mu.Lock()
+	defer view.mu.Unlock()
+	view.head, view.headTime = number, time
+	if reads.StatusErr == nil {
+		status := reads.Status
+		view.status = &status
+	}
+	if reads.Filled != nil {
+		view.filled = reads.Filled
+	}
+}
+
+// ServeHTTP routes /status, /slices and /fills, each also under
+// /<address>/ and only there with several vaults, and POST /pause and
+// /resume, which act on every vault unless under an address.
+func (a *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vaults, byAddr := a.snapshot()
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 1 && parts[0] == "" {
+		if r.Method != http.MethodGet {
+			apiError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		addrs := make([]checksumAddress, len(vaults))
+		for i, v := range vaults {
+			addrs[i] = checksumAddress(v.addr)
+		}
+		apiJSON(w, map[string]interface{}{"chainId": a.chainID, "contracts": addrs})
+		return
+	}
+	targets, keyed := vaults, false
+	if len(parts) == 2 {
+		if !common.IsHexAddress(parts[0]) || byAddr[common.HexToAddress(parts[0])] == nil {
+			apiError(w, http.StatusNotFound, "no vault "+parts[0])
+			return
+		}
+		targets, keyed = []*vaultBot{byAddr[common.HexToAddress(parts[0])]}, true
+		parts = parts[1:]
+	}
+	if len(parts) != 1 {
+		apiError(w, http.StatusNotFound, "not found")
+		return
+	}
+	switch route := parts[0]; route {
+	case "pause", "resume":
+		if !keyed {
+			targets = nil // every vault the bot loop has then
+		}
+		a.serveControl(w, r, targets, route == "pause")
+	case "status", "slices", "fills":
+		if r.Method != http.MethodGet {
+			apiError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		switch {
+		case len(targets) == 0:
+			apiError(w, http.StatusServiceUnavailable, "no vault yet")
+			return
+		case len(targets) > 1:
+			apiError(w, http.StatusNotFound, fmt.Sprintf("%d vaults: use /<address>/%s", len(targets), route))
+			return
+		}
+		v := targets[0]
+		switch route {
+		case "status":
+			apiJSON(w, a.status(v))
+		case "slices":
+			a.serveSlices(w, v)
+		case "fills":
+			v.st.view.mu.Lock()
+			fills := append([]apiFill{}, v.st.view.fills...)
+			v.st.view.mu.Unlock()
+			apiJSON(w, fills)
+		}
+	default:
+		apiError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (a *apiServer) status(v *vaultBot) apiStatus {
+	st, view := v.st, v.st.view
+	out := apiStatus{Contract: checksumAddress(v.addr), ChainID: a.chainID, Paused: st.paused.Load()}
+	if reason := st.halt.Reason(); reason != "" {
+		out.Halted, out.HaltReason = true, reason
+	}
+	view.mu.Lock()
+	out.Block, out.BlockTime, out.LastSubmission = view.head, view.headTime, view.lastTx
+	filled := view.filled
+	if view.status != nil {
+		out.Status = view.status.String()
+	}
+	view.mu.Unlock()
+	out.StatusHistory = st.status.Transitions()
+
+	s, N := st.strategy.Cached()
+	out.Strategy = newPreflightStrategy(s)
+	n, err := sliceCount(N)
+	if filled != nil {
+		out.FilledAmountIn = filled.String()
+		if err == nil && out.BlockTime > 0 {
+			bt, _ := st.clock.blockTime()
+			p := newOrderProgress(s, n, filled, out.BlockTime, bt, a.catchup)
+			out.Progress = &p
+		}
+	}
+	if err == nil && n > 0 && st.done.Loaded(n) {
+		if next := st.done.FirstUndone(st.failures.GaveUp); next >= 0 {
+			if at, err := sliceScheduledAt(s, n, next); err == nil && at.IsUint64() {
+				out.NextSlice, out.NextSliceAt = &next, at.Uint64()
+			}
+		}
+	}
+	if st.balance != nil {
+		if bal := st.balance.Last(); bal != nil {
+			out.AgentBalance = bal.String()
+		}
+	}
+	return out
+}
+
+// serveSlices is schedule mode's table of every slice, as of the last block.
+func (a *apiServer) serveSlices(w http.ResponseWriter, v *vaultBot) {
+	st := v.st
+	s, N := st.strategy.Cached()
+	n, err := sliceCount(N)
+	if err != nil || n == 0 || !st.done.Loaded(n) {
+		apiError(w, http.StatusServiceUnavailable, "the slice state is not loaded yet")
+		return
+	}
+	st.view.mu.Lock()
+	now := st.view.headTime
+	st.view.mu.Unlock()
+	t, err := buildSchedule(s, n, 0, 0, now, st.done.Done)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	apiJSON(w, t)
+}
+
+// serveControl hands a pause or resume to the bot loop, for a request
+// bearing --api-token.
+func (a *apiServer) serveControl(w http.ResponseWriter, r *http.Request, vaults []*vaultBot, paused bool) {
+	if a.cfg.Token == "" {
+		apiError(w, http.StatusNotFound, "pause and resume need --api-token")
+		return
+	}
+	if r.Method != http.MethodPost {
+		apiError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.cfg.Token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		apiError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+		return
+	}
+	c := apiControl{vaults: vaults, paused: paused, changed: make(chan bool, 1)}
+	select {
+	case a.control <- c:
+	case <-r.Context().Done():
+		return
+	}
+	select {
+	case changed := <-c.changed:
+		apiJSON(w, map[string]bool{"paused": paused, "changed": changed})
+	case <-r.Context().Done():
+	}
+}
+
+func apiJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func apiError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
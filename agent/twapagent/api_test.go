@@ -0,0 +1,236 @@
+package twapagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// freeAddr is a loopback address nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func apiRequest(t *testing.T, method, url, token string, out interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// The bot starts paused, shows its state, and fills the order once resumed
+// through the API.
+func TestAPIServesAndResumesTheBot(t *testing.T) {
+	cfg := devnetConfig(t, 5*time.Millisecond)
+	d := startTestDevnet(t, cfg)
+	bcfg := d.botConfig(cfg)
+	bcfg.Tx.StartPaused = true
+	bcfg.API = APIConfig{Addr: freeAddr(t), Token: "s3cret"}
+	a, err := New(bcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx) }()
+
+	base := "http://" + bcfg.API.Addr
+	// apiStatus as a client sees it.
+	var st struct {
+		Contract  common.Address
+		Block     uint64
+		Status    string
+		Strategy  map[string]interface{}
+		NextSlice *int64
+		Paused    bool
+	}
+	for st.NextSlice == nil {
+		select {
+		case err := <-done:
+			t.Fatalf("Run = %v before the API showed the next slice", err)
+		case <-time.After(20 * time.Millisecond):
+		}
+		st.NextSlice = nil
+		apiRequest(t, http.MethodGet, base+"/status", "", &st)
+	}
+	if !st.Paused || st.Status != "Open" || st.Strategy == nil || st.Block == 0 || st.Contract != d.vault {
+		t.Errorf("status = %+v", st)
+	}
+	var slices scheduleTable
+	if code := apiRequest(t, http.MethodGet, base+"/"+d.vault.Hex()+"/slices", "", &slices); code != http.StatusOK || len(slices.Slices) != 5 {
+		t.Errorf("GET /<vault>/slices = %d with %d slices", code, len(slices.Slices))
+	}
+
+	if code := apiRequest(t, http.MethodPost, base+"/resume", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("POST /resume without a token = %d, want 401", code)
+	}
+	if code := apiRequest(t, http.MethodPost, base+"/resume", "wrong", nil); code != http.StatusUnauthorized {
+		t.Errorf("POST /resume with a wrong token = %d, want 401", code)
+	}
+	if code := apiRequest(t, http.MethodGet, base+"/resume", "s3cret", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /resume = %d, want 405", code)
+	}
+	var res map[string]bool
+	if code := apiRequest(t, http.MethodPost, base+"/resume", "s3cret", &res); code != http.StatusOK || !res["changed"] {
+		t.Fatalf("POST /resume = %d %v", code, res)
+	}
+
+	var end *orderEnd
+	if err := <-done; !errors.As(err, &end) || end.Code != ExitFilled {
+		t.Fatalf("Run = %v, want the order filled", err)
+	}
+	if code := apiRequest(t, http.MethodGet, base+"/status", "", nil); code != 0 {
+		t.Errorf("GET /status after Run = %d, want the server closed", code)
+	}
+}
+
+// testAPI serves vaults that have a view and nothing else, which is all
+// the fills and routing need.
+func testAPI(token string, addrs ...common.Address) *apiServer {
+	a := newAPIServer(APIConfig{Token: token}, 31337, false)
+	for _, addr := range addrs {
+		v := &vaultBot{executor: executor{addr: addr, st: &botState{view: &vaultView{}}}}
+		a.vaults = append(a.vaults, v)
+		a.byAddr[addr] = v
+	}
+	return a
+}
+
+func serveTest(a *apiServer, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestAPIFills(t *testing.T) {
+	v1, v2 := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+	a := testAPI("", v1, v2)
+	fill := func(contract common.Address, slice int64, tx string, removed bool) {
+		data := map[string]interface{}{"slice": slice, "tx": tx, "amountIn": "10", "amountOut": "20", "fee": "0"}
+		if removed {
+			data["removed"] = true
+		}
+		a.tap(eventRecord{Type: evFill, Block: 9, Contract: contract.Hex(), Data: data})
+	}
+	fill(v1, 0, "0xa", false)
+	fill(v2, 0, "0xb", false)
+	fill(v1, 1, "0xc", false)
+	fill(v1, 0, "0xa", true)
+	a.tap(eventRecord{Type: evTxMined, Block: 9, Contract: v2.Hex(), Data: map[string]interface{}{"slice": int64(1), "tx": "0xd", "gasUsed": uint64(21000)}})
+
+	w := serveTest(a, http.MethodGet, "/"+v1.Hex()+"/fills")
+	var fills []apiFill
+	if err := json.NewDecoder(w.Body).Decode(&fills); err != nil {
+		t.Fatal(err)
+	}
+	if len(fills) != 1 || fills[0].Slice != 1 || fills[0].Tx != "0xc" || fills[0].AmountOut != "20" {
+		t.Errorf("v1 fills = %+v", fills)
+	}
+	if tx := a.byAddr[v2].st.view.lastTx; tx == nil || tx.Result != "mined" || tx.GasUsed != 21000 {
+		t.Errorf("v2 last submission = %+v", tx)
+	}
+}
+
+func TestAPIRouting(t *testing.T) {
+	v1, v2 := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+	for _, c := range []struct {
+		vaults []common.Address
+		method string
+		path   string
+		want   int
+	}{
+		{[]common.Address{v1}, http.MethodGet, "/fills", http.StatusOK},
+		{[]common.Address{v1}, http.MethodGet, "/" + v1.Hex() + "/fills", http.StatusOK},
+		{[]common.Address{v1}, http.MethodGet, "/" + strings.ToLower(v1.Hex()) + "/fills", http.StatusOK},
+		{[]common.Address{v1}, http.MethodGet, "/" + v2.Hex() + "/fills", http.StatusNotFound},
+		{[]common.Address{v1}, http.MethodGet, "/nope", http.StatusNotFound},
+		{[]common.Address{v1}, http.MethodPost, "/fills", http.StatusMethodNotAllowed},
+		{[]common.Address{v1, v2}, http.MethodGet, "/fills", http.StatusNotFound},
+		{[]common.Address{v1, v2}, http.MethodGet, "/" + v2.Hex() + "/fills", http.StatusOK},
+		// Without --api-token there is nothing to pause with.
+		{[]common.Address{v1}, http.MethodPost, "/pause", http.StatusNotFound},
+	} {
+		a := testAPI("", c.vaults...)
+		if w := serveTest(a, c.method, c.path); w.Code != c.want {
+			t.Errorf("%d vaults, %s %s = %d, want %d", len(c.vaults), c.method, c.path, w.Code, c.want)
+		}
+	}
+
+	a := testAPI("", v1, v2)
+	var index struct {
+		ChainID   uint64   `json:"chainId"`
+		Contracts []string `json:"contracts"`
+	}
+	if err := json.NewDecoder(serveTest(a, http.MethodGet, "/").Body).Decode(&index); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(index.ChainID, index.Contracts); got != fmt.Sprint(31337, []string{v1.Hex(), v2.Hex()}) {
+		t.Errorf("GET / = %s", got)
+	}
+}
+
+// A keyed pause goes to the bot loop for that vault alone.
+func TestAPIPauseTargetsTheKeyedVault(t *testing.T) {
+	v1, v2 := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+	a := testAPI("tok", v1, v2)
+	go func() {
+		c := <-a.control
+		c.changed <- len(c.vaults) == 1 && c.vaults[0].addr == v2 && c.paused
+	}()
+	req := httptest.NewRequest(http.MethodPost, "/"+v2.Hex()+"/pause", nil)
+	req.Header.Set("Authorization", "Bearer tok")
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, req)
+	var res map[string]bool
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || !res["changed"] || !res["paused"] {
+		t.Errorf("POST /<v2>/pause = %d %v", w.Code, res)
+	}
+}
+
+func TestNewRejectsAPIOutsideBot(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Mode, cfg.RPC, cfg.Contracts = "once", "http://127.0.0.1:0", []string{"0x01"}
+	cfg.API.Addr = "127.0.0.1:0"
+	if _, err := New(cfg); err == nil {
+		t.Error("--api-addr in once mode: want an error")
+	}
+	cfg.Mode, cfg.API = "bot", APIConfig{Token: "tok"}
+	if _, err := New(cfg); err == nil {
+		t.Error("--api-token without --api-addr: want an error")
+	}
+}
@@ -0,0 +1,136 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// BalanceConfig controls the agent ETH balance checks in bot mode.
+type BalanceConfig struct {
+	// Warn when the balance drops below this (nil disables the warning).
+	MinWei *big.Int
+	// Re-read the balance every this many blocks (0 = only at startup and before sends).
+	EveryBlocks uint64
+}
+
+// errInsufficientBalance means the account can't pay for the next tx.
+var errInsufficientBalance = errors.New("insufficient agent balance")
+
+// balanceWatcher tracks the submitting account's ETH balance. Low reports
+// whether it is below --min-balance-wei, for anything that wants to alert on it.
+type balanceWatcher struct {
+	cfg     BalanceConfig
+	account common.Address
+
+	mu        sync.Mutex
+	last      *big.Int
+	lastBlock uint64
+	low       bool
+}
+
+func newBalanceWatcher(cfg BalanceConfig, account common.Address) *balanceWatcher {
+	return &balanceWatcher{cfg: cfg, account: account}
+}
+
+// Low reports whether the last seen balance was below the threshold.
+func (w *balanceWatcher) Low() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.low
+}
+
+// Last is the balance as last read, or nil before the first read.
+func (w *balanceWatcher) Last() *big.Int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.last == nil {
+		return nil
+	}
+	return new(big.Int).Set(w.last)
+}
+
+// Check reads the balance at startup and then every cfg.EveryBlocks blocks.
+func (w *balanceWatcher) Check(ctx context.Context, client *ethclient.Client, block uint64) {
+	w.mu.Lock()
+	due := w.last == nil || (w.cfg.EveryBlocks > 0 && block >= w.lastBlock+w.cfg.EveryBlocks)
+	w.mu.Unlock()
+	if !due {
+		return
+	}
+	var bal *big.Int
+	err := rpcRead(ctx, "eth_getBalance", func(ctx context.Context) (err error) {
+		bal, err = client.BalanceAt(ctx, w.account, nil)
+		return err
+	})
+	if err != nil {
+		warnf(ctx, "balance of %s: %v", w.account.Hex(), err)
+		return
+	}
+	w.mu.Lock()
+	w.lastBlock = block
+	w.mu.Unlock()
+	w.observe(ctx, bal)
+}
+
+// observe records bal and logs when it crosses the threshold either way.
+func (w *balanceWatcher) observe(ctx context.Context, bal *big.Int) (crossedLow, recovered bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	first := w.last == nil
+	w.last = new(big.Int).Set(bal)
+	if first {
+		logf(ctx, "agent %s balance: %s wei (%s ETH)", w.account.Hex(), bal, weiToEth(bal))
+	}
+	if w.cfg.MinWei == nil {
+		return false, false
+	}
+	low := bal.Cmp(w.cfg.MinWei) < 0
+	crossedLow, recovered = low && !w.low, !low && w.low
+	w.low = low
+	if crossedLow {
+		warnf(ctx, "agent %s balance %s wei (%s ETH) is below --min-balance-wei %s", w.account.Hex(), bal, weiToEth(bal), w.cfg.MinWei)
+	} else if recovered {
+		logf(ctx, "agent %s balance back above --min-balance-wei: %s wei", w.account.Hex(), bal)
+	}
+	return crossedLow, recovered
+}
+
+// Afford reads the pending balance and fails with errInsufficientBalance if it
+// doesn't cover gasLimit at maxPrice. A nil maxPrice (pricing unknown) passes.
+func (w *balanceWatcher) Afford(ctx context.Context, client *ethclient.Client, gasLimit uint64, maxPrice *big.Int) error {
+	if maxPrice == nil {
+		return nil
+	}
+	var bal *big.Int
+	err := rpcRead(ctx, "eth_getBalance", func(ctx context.Context) (err error) {
+		bal, err = client.PendingBalanceAt(ctx, w.account)
+		return err
+	})
+	if err != nil {
+		// Not knowing is no reason to stall; the node rejects an unfunded tx anyway.
+		warnf(ctx, "balance of %s: %v", w.account.Hex(), err)
+		return nil
+	}
+	w.observe(ctx, bal)
+	cost := new(big.Int).Mul(new(big.Int).SetUint64(gasLimit), maxPrice)
+	if bal.Cmp(cost) < 0 {
+		return fmt.Errorf("%w: %s wei < %s wei (gasLimit %d x %s wei)", errInsufficientBalance, bal, cost, gasLimit, maxPrice)
+	}
+	return nil
+}
+
+// slicesCovered is how many executeSlice txs bal pays for at gasPerSlice and
+// price, or nil if either is unknown.
+func slicesCovered(bal *big.Int, gasPerSlice uint64, price *big.Int) *big.Int {
+	if gasPerSlice == 0 || price == nil || price.Sign() == 0 {
+		return nil
+	}
+	perSlice := new(big.Int).Mul(new(big.Int).SetUint64(gasPerSlice), price)
+	return new(big.Int).Div(bal, perSlice)
+}
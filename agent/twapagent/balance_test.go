@@ -0,0 +1,50 @@
+package twapagent
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestBalanceWatcherThreshold(t *testing.T) {
+	w := newBalanceWatcher(BalanceConfig{MinWei: big.NewInt(1000)}, common.Address{})
+	steps := []struct {
+		bal                int64
+		crossed, recovered bool
+		low                bool
+	}{
+		{5000, false, false, false},
+		{999, true, false, true},
+		{10, false, false, true}, // still low: warn once
+		{1000, false, true, false},
+	}
+	for i, s := range steps {
+		crossed, recovered := w.observe(context.Background(), big.NewInt(s.bal))
+		if crossed != s.crossed || recovered != s.recovered || w.Low() != s.low {
+			t.Errorf("step %d (%d wei): crossed=%v recovered=%v low=%v, want %v %v %v", i, s.bal, crossed, recovered, w.Low(), s.crossed, s.recovered, s.low)
+		}
+	}
+}
+
+func TestBalanceWatcherNoThreshold(t *testing.T) {
+	w := newBalanceWatcher(BalanceConfig{}, common.Address{})
+	if crossed, _ := w.observe(context.Background(), big.NewInt(0)); crossed || w.Low() {
+		t.Fatal("zero balance flagged low without --min-balance-wei")
+	}
+}
+
+func TestSlicesCovered(t *testing.T) {
+	gwei := big.NewInt(1_000_000_000)
+	bal := new(big.Int).Mul(big.NewInt(1_000_000), gwei) // 0.001 ETH
+	if n := slicesCovered(bal, 100_000, gwei); n == nil || n.Int64() != 10 {
+		t.Fatalf("covered = %v, want 10", n)
+	}
+	if n := slicesCovered(bal, 0, gwei); n != nil {
+		t.Fatalf("covered with unknown gas = %v, want nil", n)
+	}
+	if n := slicesCovered(bal, 100_000, nil); n != nil {
+		t.Fatalf("covered with unknown price = %v, want nil", n)
+	}
+}
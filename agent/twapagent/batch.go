@@ -0,0 +1,145 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// maxBatchCalls caps one JSON-RPC batch; providers commonly reject batches
+// of more than 100 requests.
+const maxBatchCalls = 100
+
+// blockReads is everything handleBlock reads once per head, fetched in one
+// JSON-RPC batch. The strategy isn't among it: bot mode caches that, see
+// strategyCache.
+type blockReads struct {
+	Status    Status
+	StatusErr error    // handleBlock carries on without a status
+	Filled    *big.Int // nil if the read failed
+}
+
+// viewCall is one eth_call against "latest" inside a batch.
+type viewCall struct {
+	method string
+	data   []byte
+	out    hexutil.Bytes
+	err    error
+}
+
+func newViewCall(cABI abi.ABI, method string, args ...interface{}) (*viewCall, error) {
+	data, err := cABI.Pack(method, args...)
+	if err != nil {
+		return nil, fmt.Errorf("pack %s: %w", method, err)
+	}
+	return &viewCall{method: method, data: data}, nil
+}
+
+func (c *viewCall) elem(addr common.Address) rpc.BatchElem {
+	return rpc.BatchElem{
+		Method: "eth_call",
+		Args:   []interface{}{map[string]interface{}{"to": addr, "data": hexutil.Bytes(c.data)}, "latest"},
+		Result: &c.out,
+	}
+}
+
+// unpack decodes the call's single return value, or the node's error.
+func (c *viewCall) unpack(cABI abi.ABI) (interface{}, error) {
+	if c.err != nil {
+		return nil, wrapCallError(cABI, c.method, c.err)
+	}
+	outs, err := cABI.Unpack(c.method, c.out)
+	if err != nil {
+		return nil, fmt.Errorf("unpack %s: %w", c.method, err)
+	}
+	return outs[0], nil
+}
+
+// sendBatch issues elems as one JSON-RPC batch through rpcRead. A request
+// the provider rate limited fails the whole batch so it is retried.
+func sendBatch(ctx context.Context, rc *rpc.Client, elems []rpc.BatchElem) error {
+	return rpcRead(ctx, fmt.Sprintf("batch of %d", len(elems)), func(ctx context.Context) error {
+		for i := range elems {
+			elems[i].Error = nil
+		}
+		if err := rc.BatchCallContext(ctx, elems); err != nil {
+			return err
+		}
+		for _, e := range elems {
+			if isRateLimited(e.Error) {
+				return e.Error
+			}
+		}
+		return nil
+	})
+}
+
+// readBlock fetches status() and filledAmountIn() in a single round trip.
+func readBlock(ctx context.Context, addr common.Address, cABI abi.ABI, rc *rpc.Client) (blockReads, error) {
+	var calls []*viewCall
+	var elems []rpc.BatchElem
+	for _, m := range []string{"status", "filledAmountIn"} {
+		c, err := newViewCall(cABI, m)
+		if err != nil {
+			return blockReads{}, err
+		}
+		calls, elems = append(calls, c), append(elems, c.elem(addr))
+	}
+	if err := sendBatch(ctx, rc, elems); err != nil {
+		return blockReads{}, err
+	}
+	for i, c := range calls {
+		c.err = elems[i].Error
+	}
+	status, filled := calls[0], calls[1]
+
+	var r blockReads
+	if v, err := status.unpack(cABI); err != nil {
+		r.StatusErr = err
+	} else {
+		r.Status = Status(*abi.ConvertType(v, new(uint8)).(*uint8))
+	}
+	if v, err := filled.unpack(cABI); err == nil {
+		r.Filled = *abi.ConvertType(v, new(*big.Int)).(**big.Int)
+	}
+	return r, nil
+}
+
+// sliceDoneRange reads sliceDone() for slices from..to-1 in batches of
+// maxBatchCalls.
+func sliceDoneRange(ctx context.Context, addr common.Address, cABI abi.ABI, rc *rpc.Client, from, to int64) ([]bool, error) {
+	done := make([]bool, 0, to-from)
+	for start := from; start < to; start += maxBatchCalls {
+		end := start + maxBatchCalls
+		if end > to {
+			end = to
+		}
+		calls := make([]*viewCall, end-start)
+		elems := make([]rpc.BatchElem, end-start)
+		for i := range calls {
+			c, err := newViewCall(cABI, "sliceDone", big.NewInt(start+int64(i)))
+			if err != nil {
+				return nil, err
+			}
+			calls[i], elems[i] = c, c.elem(addr)
+		}
+		if err := sendBatch(ctx, rc, elems); err != nil {
+			return nil, err
+		}
+		for i, c := range calls {
+			c.err = elems[i].Error
+			v, err := c.unpack(cABI)
+			if err != nil {
+				return nil, err
+			}
+			d, _ := v.(bool)
+			done = append(done, d)
+		}
+	}
+	return done, nil
+}
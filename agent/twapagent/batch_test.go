@@ -0,0 +1,258 @@
+package twapagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"twap-agent/twapbind"
+)
+
+// vaultOwner is owner() of the vaultRPC vault.
+var vaultOwner = common.HexToAddress("0x00000000000000000000000000000000000000e0")
+
+// vaultRPC is a counting mock transport: an HTTP JSON-RPC endpoint serving a
+// vault with totalSlices slices of which the first done are executed, or
+// those in doneSet when it is set.
+type vaultRPC struct {
+	cABI        abi.ABI
+	totalSlices int64
+	done        int64
+	doneSet     map[int64]bool
+	roundTrips  atomic.Int64
+	down        atomic.Bool // answer every request with a 503
+}
+
+type jsonrpcReq struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+func (v *vaultRPC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.roundTrips.Add(1)
+	if v.down.Load() {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+		var reqs []jsonrpcReq
+		json.Unmarshal(raw, &reqs)
+		resps := make([]map[string]interface{}, len(reqs))
+		for i, req := range reqs {
+			resps[i] = v.answer(req)
+		}
+		json.NewEncoder(w).Encode(resps)
+		return
+	}
+	var req jsonrpcReq
+	json.Unmarshal(raw, &req)
+	json.NewEncoder(w).Encode(v.answer(req))
+}
+
+func (v *vaultRPC) answer(req jsonrpcReq) map[string]interface{} {
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+	switch req.Method {
+	case "eth_getBlockByNumber":
+		resp["result"] = &types.Header{Number: big.NewInt(100), Difficulty: new(big.Int), Time: 2_000}
+	case "eth_call":
+		var call struct {
+			Data hexutil.Bytes `json:"data"`
+		}
+		json.Unmarshal(req.Params[0], &call)
+		out, err := v.call(call.Data)
+		if err != nil {
+			resp["error"] = map[string]interface{}{"code": -32000, "message": err.Error()}
+		} else {
+			resp["result"] = hexutil.Bytes(out)
+		}
+	default:
+		resp["error"] = map[string]interface{}{"code": -32601, "message": "method not found"}
+	}
+	return resp
+}
+
+func (v *vaultRPC) call(data []byte) ([]byte, error) {
+	m, err := v.cABI.MethodById(data)
+	if err != nil {
+		return nil, err
+	}
+	switch m.Name {
+	case "status":
+		return m.Outputs.Pack(uint8(1))
+	case "strategy":
+		return m.Outputs.Pack(common.Address{1}, common.Address{2}, common.Address{3}, common.Address{4},
+			big.NewInt(1000), big.NewInt(10), big.NewInt(1_000), big.NewInt(3_000), uint16(50), uint16(100))
+	case "owner":
+		return m.Outputs.Pack(vaultOwner)
+	case "totalSlices":
+		return m.Outputs.Pack(big.NewInt(v.totalSlices))
+	case "filledAmountIn":
+		return m.Outputs.Pack(big.NewInt(10 * v.done))
+	case "receivedAmountOut":
+		return m.Outputs.Pack(big.NewInt(20 * v.done))
+	case "accruedFee":
+		return m.Outputs.Pack(big.NewInt(v.done))
+	case "sliceDone":
+		args, err := m.Inputs.Unpack(data[4:])
+		if err != nil {
+			return nil, err
+		}
+		i := args[0].(*big.Int).Int64()
+		if v.doneSet != nil {
+			return m.Outputs.Pack(v.doneSet[i])
+		}
+		return m.Outputs.Pack(i < v.done)
+	}
+	return nil, nil
+}
+
+func newVaultRPC(t testing.TB, total, done int64) (*vaultRPC, *twapbind.Twap, abi.ABI, *ethclient.Client, *rpc.Client) {
+	cABI, err := twapbind.ParseABI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &vaultRPC{cABI: cABI, totalSlices: total, done: done}
+	srv := httptest.NewServer(v)
+	t.Cleanup(srv.Close)
+	rc, err := rpc.DialHTTP(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(rc.Close)
+	client := ethclient.NewClient(rc)
+	addr := common.Address{0xaa}
+	return v, twapbind.NewTwap(addr, cABI, client, client, client), cABI, client, rc
+}
+
+// sequentialBlockReads is what handleBlock did before batching.
+func sequentialBlockReads(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client) (int64, error) {
+	if _, err := headerByNumber(ctx, client, big.NewInt(100)); err != nil {
+		return -1, err
+	}
+	if _, err := readStatus(ctx, addr, cABI, client); err != nil {
+		return -1, err
+	}
+	if _, err := readStrategy(ctx, addr, cABI, client); err != nil {
+		return -1, err
+	}
+	n, err := readTotalSlices(ctx, addr, cABI, client)
+	if err != nil {
+		return -1, err
+	}
+	for i := int64(0); i < n.Int64(); i++ {
+		done, err := readSliceDone(ctx, addr, cABI, client, big.NewInt(i))
+		if err != nil {
+			return -1, err
+		}
+		if !done {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// batchedBlockReads is handleBlock's per-block reads with the strategy
+// cached and the sliceDone bitmap loaded.
+func batchedBlockReads(ctx context.Context, addr common.Address, cABI abi.ABI, rc *rpc.Client, done *sliceBitmap) (int64, error) {
+	if _, err := readBlock(ctx, addr, cABI, rc); err != nil {
+		return -1, err
+	}
+	return done.FirstUndone(func(int64) bool { return false }), nil
+}
+
+func TestBatchedBlockReadsRoundTrips(t *testing.T) {
+	ctx := context.Background()
+	v, twap, cABI, client, rc := newVaultRPC(t, 24, 20)
+
+	want, err := sequentialBlockReads(ctx, twap.Address(), cABI, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sequential := v.roundTrips.Swap(0)
+
+	var done sliceBitmap
+	if err := loadSliceBitmap(ctx, &done, twap.Address(), cABI, client, rc, false, v.totalSlices); err != nil {
+		t.Fatal(err)
+	}
+	if n := v.roundTrips.Swap(0); n != 1 {
+		t.Fatalf("loading 24 slices took %d round trips, want 1", n)
+	}
+	reads, err := readBlock(ctx, twap.Address(), cABI, rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := done.FirstUndone(func(int64) bool { return false })
+	batched := v.roundTrips.Load()
+
+	if got != want || got != 20 {
+		t.Fatalf("first undone slice: batched %d, sequential %d, want 20", got, want)
+	}
+	if reads.Status != 1 || reads.StatusErr != nil || reads.Filled.Int64() != 200 {
+		t.Fatalf("reads = %+v", reads)
+	}
+	if sequential != 4+21 || batched != 1 {
+		t.Fatalf("round trips: sequential %d (want 25), batched %d (want 1)", sequential, batched)
+	}
+	t.Logf("round trips per block: sequential %d, batched %d", sequential, batched)
+}
+
+func TestSliceDoneRangePages(t *testing.T) {
+	ctx := context.Background()
+	v, twap, cABI, _, rc := newVaultRPC(t, 250, 150)
+	done, err := sliceDoneRange(ctx, twap.Address(), cABI, rc, 0, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 250 || !done[149] || done[150] {
+		t.Fatalf("sliceDoneRange: %d results, [149]=%v [150]=%v", len(done), done[149], done[150])
+	}
+	if n := v.roundTrips.Load(); n != 3 {
+		t.Fatalf("%d round trips for 250 slices, want 3 batches of <= %d", n, maxBatchCalls)
+	}
+}
+
+func BenchmarkBlockReads(b *testing.B) {
+	ctx := context.Background()
+	v, twap, cABI, client, rc := newVaultRPC(b, 48, 40)
+	var done sliceBitmap
+	if err := loadSliceBitmap(ctx, &done, twap.Address(), cABI, client, rc, false, v.totalSlices); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("sequential", func(b *testing.B) {
+		v.roundTrips.Store(0)
+		for i := 0; i < b.N; i++ {
+			if _, err := sequentialBlockReads(ctx, twap.Address(), cABI, client); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(v.roundTrips.Load())/float64(b.N), "roundtrips/op")
+	})
+	b.Run("batched", func(b *testing.B) {
+		v.roundTrips.Store(0)
+		for i := 0; i < b.N; i++ {
+			if _, err := batchedBlockReads(ctx, twap.Address(), cABI, rc, &done); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(v.roundTrips.Load())/float64(b.N), "roundtrips/op")
+	})
+}
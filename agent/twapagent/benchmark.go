@@ -0,0 +1,125 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// The benchmark an order's fills are measured against is the oracle's TWAP:
+// the arithmetic mean of its price at each fill's block. Prices here are
+// raw tokenOut per raw tokenIn, so 1 WETH for 2000 USDC is 2000e6/1e18;
+// wholePrice scales them for output.
+
+// sampleOracle reads the oracle's price at each fill's block, in IOracle's
+// unit. A block the node has no state for any more, as past a full node's
+// recent window, leaves its entry nil.
+func sampleOracle(ctx context.Context, client *ethclient.Client, shape OracleShape, s Strategy, fills []fillRecord) []*big.Int {
+	oracle := s.PriceOracle
+	if shape.Address != (common.Address{}) {
+		oracle = shape.Address
+	}
+	prices := make([]*big.Int, len(fills))
+	var missing int
+	var firstErr error
+	for i, f := range fills {
+		p, _, err := readOraclePriceAt(ctx, client, shape, oracle, s, new(big.Int).SetUint64(f.Block))
+		if err != nil {
+			if missing++; firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		prices[i] = p
+	}
+	if missing > 0 {
+		warnf(ctx, "oracle price unavailable at %d of %d fill blocks, left out of the benchmark (an archive node has them all): %v", missing, len(fills), firstErr)
+	}
+	return prices
+}
+
+// oracleRat is an oracle price, scaled by 1e18, as a raw price.
+func oracleRat(p *big.Int) *big.Rat {
+	return new(big.Rat).SetFrac(p, big.NewInt(1e18))
+}
+
+// realizedPrice is out over in; nil when nothing went in.
+func realizedPrice(in, out *big.Int) *big.Rat {
+	if in == nil || out == nil || in.Sign() == 0 {
+		return nil
+	}
+	return new(big.Rat).SetFrac(out, in)
+}
+
+// benchmarkPrice is the mean of the prices sampled; nil when there are none.
+func benchmarkPrice(prices []*big.Int) *big.Rat {
+	sum, n := new(big.Int), int64(0)
+	for _, p := range prices {
+		if p != nil {
+			sum.Add(sum, p)
+			n++
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	return new(big.Rat).SetFrac(sum, new(big.Int).Mul(big.NewInt(n), big.NewInt(1e18)))
+}
+
+// shortfallBps is the implementation shortfall of realized against
+// benchmark in bps, to two decimals: how much less tokenOut the order got
+// per tokenIn than the benchmark price. Negative means it did better.
+func shortfallBps(realized, benchmark *big.Rat) string {
+	if realized == nil || benchmark == nil || benchmark.Sign() == 0 {
+		return ""
+	}
+	d := new(big.Rat).Sub(benchmark, realized)
+	d.Mul(d, big.NewRat(10_000, 1))
+	return d.Quo(d, benchmark).FloatString(2)
+}
+
+// printBenchmark adds the order's VWAP against the oracle TWAP over its
+// fills to the terminal summary. The fills are read back from startTime,
+// so a restarted bot still has them all.
+func printBenchmark(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, shape OracleShape, s Strategy) {
+	if s.StartTime == nil || s.StartTime.Sign() == 0 {
+		return
+	}
+	fills, _, _, err := readFills(ctx, addr, cABI, client, s, backfillChunkBlocks)
+	if err != nil {
+		warnf(ctx, "benchmark: %v", err)
+		return
+	}
+	if len(fills) == 0 {
+		return
+	}
+	sumIn, sumOut := new(big.Int), new(big.Int)
+	for _, f := range fills {
+		sumIn.Add(sumIn, f.AmountIn)
+		sumOut.Add(sumOut, f.AmountOut)
+	}
+	prices := sampleOracle(ctx, client, shape, s, fills)
+	bench := benchmarkPrice(prices)
+	if bench == nil {
+		return
+	}
+	in, out := readTokenInfo(ctx, client, s.TokenIn), readTokenInfo(ctx, client, s.TokenOut)
+	realized := realizedPrice(sumIn, sumOut)
+	unit := out.Symbol + "/" + in.Symbol
+	fmt.Fprintf(outputFrom(ctx), "- benchmark: VWAP %s %s, oracle TWAP %s %s over %d slices, shortfall %s bps\n",
+		wholePrice(realized, in.Decimals, out.Decimals), unit, wholePrice(bench, in.Decimals, out.Decimals), unit, countSampled(prices), shortfallBps(realized, bench))
+}
+
+func countSampled(prices []*big.Int) int {
+	n := 0
+	for _, p := range prices {
+		if p != nil {
+			n++
+		}
+	}
+	return n
+}
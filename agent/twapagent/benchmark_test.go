@@ -0,0 +1,35 @@
+package twapagent
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestBenchmarkPrice(t *testing.T) {
+	// WETH (18) -> USDC (6) at 2000 and 2100: IOracle prices of 2e9 and 2.1e9.
+	prices := []*big.Int{big.NewInt(2_000_000_000), nil, big.NewInt(2_100_000_000)}
+	bench := benchmarkPrice(prices)
+	if got := wholePrice(bench, 18, 6); got != "2050" {
+		t.Errorf("benchmark = %s, want 2050, the unsampled fill left out", got)
+	}
+	if benchmarkPrice([]*big.Int{nil}) != nil {
+		t.Error("a benchmark without samples")
+	}
+
+	eth, _ := new(big.Int).SetString("1000000000000000000", 10)
+	for _, tc := range []struct {
+		out  int64
+		want string
+	}{
+		{2050e6, "0.00"},
+		{2029_500000, "100.00"}, // 1% short of the benchmark
+		{2060_250000, "-50.00"}, // and 0.5% better
+	} {
+		if got := shortfallBps(realizedPrice(eth, big.NewInt(tc.out)), bench); got != tc.want {
+			t.Errorf("1 WETH for %d USDC units: shortfall %s bps, want %s", tc.out, got, tc.want)
+		}
+	}
+	if got := shortfallBps(realizedPrice(big.NewInt(0), big.NewInt(1)), bench); got != "" {
+		t.Errorf("shortfall without a fill = %q", got)
+	}
+}
@@ -0,0 +1,75 @@
+package twapagent
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// blockClock estimates the chain's block time from the heads the bot sees,
+// so it can tell whether the next block will reach a slice's schedule.
+type blockClock struct {
+	mu      sync.Mutex
+	last    *types.Header
+	avg     float64 // seconds, exponential moving average
+	samples int
+}
+
+// blockClockWeight is the weight of the newest interval in the average.
+const blockClockWeight = 0.2
+
+// observe feeds a new head. Gaps of several blocks (a missed head, a
+// reconnect) count as their per-block average; reorged or repeated heights
+// are ignored.
+func (c *blockClock) observe(h *types.Header) {
+	if h == nil || h.Number == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last != nil && h.Number.Cmp(c.last.Number) > 0 && h.Time >= c.last.Time {
+		blocks := new(big.Int).Sub(h.Number, c.last.Number).Uint64()
+		dt := float64(h.Time-c.last.Time) / float64(blocks)
+		if c.samples == 0 {
+			c.avg = dt
+		} else {
+			c.avg += blockClockWeight * (dt - c.avg)
+		}
+		c.samples++
+	}
+	if c.last == nil || h.Number.Cmp(c.last.Number) > 0 {
+		c.last = h
+	}
+}
+
+// blockTime returns the average block time in whole seconds, rounded up;
+// ok is false until two heads were seen.
+func (c *blockClock) blockTime() (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.samples == 0 {
+		return 0, false
+	}
+	secs := uint64(c.avg)
+	if float64(secs) < c.avg {
+		secs++
+	}
+	return secs, true
+}
+
+// submitAhead reports whether a slice scheduled at scheduled should be sent
+// while the head at headTime is still too early for it: the next block is
+// expected to reach the schedule, and it is at most lead seconds away
+// (0 disables early submission).
+func submitAhead(c *blockClock, headTime uint64, scheduled *big.Int, lead uint64) bool {
+	if lead == 0 || !scheduled.IsUint64() {
+		return false
+	}
+	at := scheduled.Uint64()
+	if at <= headTime || at-headTime > lead {
+		return false
+	}
+	bt, ok := c.blockTime()
+	return ok && headTime+bt >= at
+}
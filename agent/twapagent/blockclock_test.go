@@ -0,0 +1,65 @@
+package twapagent
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func head(n int64, t uint64) *types.Header {
+	return &types.Header{Number: big.NewInt(n), Time: t}
+}
+
+func TestBlockClockAverage(t *testing.T) {
+	var c blockClock
+	if _, ok := c.blockTime(); ok {
+		t.Fatal("block time known before any head")
+	}
+	c.observe(head(10, 1000))
+	if _, ok := c.blockTime(); ok {
+		t.Fatal("block time known after one head")
+	}
+	c.observe(head(11, 1012))
+	if bt, _ := c.blockTime(); bt != 12 {
+		t.Fatalf("block time = %d, want 12", bt)
+	}
+	// A missed head counts per block; stale and repeated heights are ignored.
+	c.observe(head(13, 1036))
+	c.observe(head(12, 2000))
+	c.observe(head(13, 2000))
+	if bt, _ := c.blockTime(); bt != 12 {
+		t.Fatalf("block time = %d after gap and stale heads, want 12", bt)
+	}
+	c.observe(head(14, 1039))
+	if bt, _ := c.blockTime(); bt != 11 {
+		t.Fatalf("block time = %d, want 10.2 rounded up to 11", bt)
+	}
+}
+
+func TestSubmitAhead(t *testing.T) {
+	var c blockClock
+	c.observe(head(1, 100))
+	c.observe(head(2, 112))
+	at := big.NewInt(120)
+	for _, tc := range []struct {
+		name     string
+		headTime uint64
+		lead     uint64
+		want     bool
+	}{
+		{"disabled", 112, 0, false},
+		{"next block reaches it", 112, 30, true},
+		{"outside lead window", 112, 5, false},
+		{"next block still early", 100, 30, false},
+		{"already due", 120, 30, false},
+	} {
+		if got := submitAhead(&c, tc.headTime, at, tc.lead); got != tc.want {
+			t.Errorf("%s: submitAhead = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	var cold blockClock
+	if submitAhead(&cold, 112, at, 30) {
+		t.Error("submitted ahead without a block time estimate")
+	}
+}
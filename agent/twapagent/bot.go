@@ -0,0 +1,1327 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"twap-agent/twapbind"
+)
+
+// resolveChainID returns the node's chain id, or fails if a non-zero
+// configured id disagrees with it.
+func resolveChainID(ctx context.Context, client *ethclient.Client, configured uint64) (uint64, error) {
+	id, err := client.ChainID(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("chain id: %w", err)
+	}
+	if configured != 0 && id.Uint64() != configured {
+		return 0, fmt.Errorf("--chain-id %d does not match the RPC's chain id %s", configured, id)
+	}
+	return id.Uint64(), nil
+}
+
+// checkSameChain fails if the read and tx endpoints are on different chains.
+func checkSameChain(ctx context.Context, read, tx *ethclient.Client) error {
+	readID, err := read.ChainID(ctx)
+	if err != nil {
+		return fmt.Errorf("rpc chain id: %w", err)
+	}
+	txID, err := tx.ChainID(ctx)
+	if err != nil {
+		return fmt.Errorf("tx-rpc chain id: %w", err)
+	}
+	if readID.Cmp(txID) != 0 {
+		return fmt.Errorf("rpc is on chain %s but tx-rpc is on chain %s", readID, txID)
+	}
+	return nil
+}
+
+// callView packs, executes a static call and unpacks outputs.
+func callView(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, method string, args ...interface{}) ([]interface{}, error) {
+	return callViewAt(ctx, addr, cABI, client, false, nil, method, args...)
+}
+
+// callViewPending is callView against the "pending" block tag.
+func callViewPending(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, method string, args ...interface{}) ([]interface{}, error) {
+	return callViewAt(ctx, addr, cABI, client, true, nil, method, args...)
+}
+
+// callViewBlock is callView against block, nil for the latest. Past the
+// node's recent state that needs an archive node.
+func callViewBlock(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, block *big.Int, method string, args ...interface{}) ([]interface{}, error) {
+	return callViewAt(ctx, addr, cABI, client, false, block, method, args...)
+}
+
+func callViewAt(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, pending bool, block *big.Int, method string, args ...interface{}) ([]interface{}, error) {
+	data, err := cABI.Pack(method, args...)
+	if err != nil {
+		return nil, fmt.Errorf("pack %s: %w", method, err)
+	}
+	msg := ethereum.CallMsg{To: &addr, Data: data}
+	var res []byte
+	err = rpcRead(ctx, "eth_call "+method, func(ctx context.Context) (err error) {
+		if pending {
+			res, err = client.PendingCallContract(ctx, msg)
+		} else {
+			res, err = client.CallContract(ctx, msg, block)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, wrapCallError(cABI, method, err)
+	}
+	outs, err := cABI.Unpack(method, res)
+	if err != nil {
+		return nil, fmt.Errorf("unpack %s: %w", method, err)
+	}
+	return outs, nil
+}
+
+// twapAt binds the vault for reads through client.
+func twapAt(addr common.Address, cABI abi.ABI, client *ethclient.Client) *twapbind.Twap {
+	return twapbind.NewTwap(addr, cABI, client, client, client)
+}
+
+func readStrategy(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client) (Strategy, error) {
+	var s Strategy
+	err := rpcRead(ctx, "eth_call strategy", func(ctx context.Context) (err error) {
+		s, err = twapAt(addr, cABI, client).Strategy(&bind.CallOpts{Context: ctx})
+		return err
+	})
+	if err != nil {
+		return Strategy{}, wrapCallError(cABI, "strategy", err)
+	}
+	return s, nil
+}
+
+func readFilled(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client) (*big.Int, error) {
+	var v *big.Int
+	err := rpcRead(ctx, "eth_call filledAmountIn", func(ctx context.Context) (err error) {
+		v, err = twapAt(addr, cABI, client).FilledAmountIn(&bind.CallOpts{Context: ctx})
+		return err
+	})
+	if err != nil {
+		return nil, wrapCallError(cABI, "filledAmountIn", err)
+	}
+	return v, nil
+}
+
+func readTotalSlices(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client) (*big.Int, error) {
+	var v *big.Int
+	err := rpcRead(ctx, "eth_call totalSlices", func(ctx context.Context) (err error) {
+		v, err = twapAt(addr, cABI, client).TotalSlices(&bind.CallOpts{Context: ctx})
+		return err
+	})
+	if err != nil {
+		return nil, wrapCallError(cABI, "totalSlices", err)
+	}
+	return v, nil
+}
+
+func readSliceDone(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, i *big.Int) (bool, error) {
+	var done bool
+	err := rpcRead(ctx, "eth_call sliceDone", func(ctx context.Context) (err error) {
+		done, err = twapAt(addr, cABI, client).SliceDone(&bind.CallOpts{Context: ctx}, i)
+		return err
+	})
+	if err != nil {
+		return false, wrapCallError(cABI, "sliceDone", err)
+	}
+	return done, nil
+}
+
+// readSliceDonePending reads sliceDone(i) including txs still in the mempool.
+func readSliceDonePending(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, i *big.Int) (bool, error) {
+	var done bool
+	err := rpcRead(ctx, "eth_call sliceDone", func(ctx context.Context) (err error) {
+		done, err = twapAt(addr, cABI, client).SliceDone(&bind.CallOpts{Context: ctx, Pending: true}, i)
+		return err
+	})
+	if err != nil {
+		return false, wrapCallError(cABI, "sliceDone", err)
+	}
+	return done, nil
+}
+
+// gasPerSlice picks the gas figure preflight uses to cost a slice: --gas-limit,
+// else the average of recorded receipts, else an estimate for the due slice.
+func gasPerSlice(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, txCfg TxConfig, receiptsPath string, agent common.Address, next int64) (uint64, string) {
+	if txCfg.GasLimit > 0 {
+		return txCfg.GasLimit, "--gas-limit"
+	}
+	if ledger, err := loadGasLedger(receiptsPath); err == nil {
+		if sum := ledger.Summary(addr); sum.Txs > 0 {
+			return sum.TotalGas / uint64(sum.Txs), fmt.Sprintf("average of %d recorded txs", sum.Txs)
+		}
+	}
+	if next < 0 {
+		return 0, ""
+	}
+	data, err := cABI.Pack("executeSlice", big.NewInt(next))
+	if err != nil {
+		return 0, ""
+	}
+	est, err := estimateGas(ctx, client, ethereum.CallMsg{From: agent, To: &addr, Data: data})
+	if err != nil {
+		return 0, ""
+	}
+	return est, fmt.Sprintf("estimate for slice %d", next)
+}
+
+func (e *executor) execute(ctx context.Context, sliceId int64, overdue int64) {
+	if e.txCfg.DryRun {
+		from := e.signer
+		if from == nil {
+			from = dryRunSigner{e.st.sender.relay.Address()}
+		}
+		dryRunSlice(ctx, e.addr, e.cABI, e.twap, e.client, e.txCfg, e.st, from.Address(), sliceId, overdue)
+		return
+	}
+	if e.st.sender.relay != nil {
+		executeViaRelay(ctx, e.addr, e.cABI, e.client, e.txCfg, e.st, sliceId, overdue)
+		return
+	}
+
+	// Prepare transactor; everything transaction-related goes through the tx endpoint.
+	// chainID was resolved against the node at startup.
+	txClient := e.st.txClient
+	auth, err := e.signer.TransactOpts(ctx, e.chainID)
+	if err != nil {
+		errorf(ctx, "transactor: %v", err)
+		return
+	}
+
+	// Dry-run the call first so predictable reverts don't cost gas
+	if !e.txCfg.SkipSimulation {
+		if err := simulateSlice(ctx, e.addr, e.cABI, e.client, auth.From, sliceId, overdue < 0); err != nil {
+			logSkippedSlice(ctx, sliceId, overdue, err)
+			return
+		}
+	}
+	if !priceGate(ctx, e.addr, e.cABI, e.client, e.txCfg, e.st, sliceId) {
+		return
+	}
+
+	// Gas pricing: legacy gasPrice or EIP-1559 fee caps depending on --tx-type
+	quote, err := quoteGas(ctx, txClient, e.txCfg)
+	switch {
+	case errors.Is(err, errFeeCapTooLow):
+		logf(ctx, "deferring slice %d: %v", sliceId, err)
+		return
+	case err != nil && e.txCfg.hasCeiling() && overdue <= int64(e.txCfg.CeilingGrace):
+		// Unpriced, the tx would go out with whatever fees bind picks.
+		warnf(ctx, "deferring slice %d, gas ceiling can't be checked: %v", sliceId, err)
+		return
+	case err != nil:
+		warnf(ctx, "gas pricing error (will let sender handle): %v", err)
+	}
+	if price, ceiling, over := e.txCfg.checkCeiling(quote); over {
+		if overdue <= int64(e.txCfg.CeilingGrace) {
+			logf(ctx, "deferring slice %d, gas too high: %s wei > ceiling %s wei", sliceId, price, ceiling)
+			return
+		}
+		logf(ctx, "slice %d overdue by %ds, ignoring gas ceiling %s wei (current %s wei)", sliceId, overdue, ceiling, price)
+	}
+	quote.apply(auth)
+	gasSource, err := planGasLimit(e.twap, auth, e.txCfg, sliceId)
+	if err != nil {
+		errorf(ctx, "executeSlice(%d) error: %v", sliceId, err)
+		return
+	}
+	fees := quote.fees()
+	if e.st.balance != nil {
+		if err := e.st.balance.Afford(ctx, txClient, auth.GasLimit, quote.maxPrice()); err != nil {
+			warnf(ctx, "not submitting slice %d: %v", sliceId, err)
+			emitEvent(ctx, evDecision, 0, map[string]interface{}{"slice": sliceId, "action": "unfunded", "reason": err.Error()})
+			return
+		}
+	}
+
+	// Last-moment check: another keeper may have executed the slice since the scan
+	if done, err := readSliceDonePending(ctx, e.addr, e.cABI, e.client, big.NewInt(sliceId)); err != nil {
+		warnf(ctx, "pending sliceDone(%d) check failed, submitting anyway: %v", sliceId, err)
+	} else if done {
+		n := e.st.avoided.Add(1)
+		logf(ctx, "slice %d already executed by someone else, not submitting (%d submissions avoided)", sliceId, n)
+		return
+	}
+
+	// A retry follows a tx that timed out, was dropped or expired after
+	// --resubmit-after; the nonce handed out after it may never be reached.
+	if e.st.submitted.Sent(sliceId) {
+		e.st.nonces.Invalidate()
+	}
+
+	// Submit with a nonce from the bot's nonce manager, printing the plan first
+	tx, err := e.st.nonces.Send(ctx, func(nonce uint64) (*types.Transaction, error) {
+		auth.Nonce = new(big.Int).SetUint64(nonce)
+		args := []interface{}{"slice", sliceId, "nonce", nonce, "gasLimit", auth.GasLimit, "gasSource", gasSource}
+		if fees != "" {
+			args = append(args, "fees", fees)
+		}
+		logAt(ctx, slog.LevelInfo, "Planning tx", args...)
+		return signAndSend(ctx, e.st.sender, e.twap, auth, sliceId)
+	})
+	if errors.Is(err, errSignerRejected) {
+		errorf(ctx, "executeSlice(%d) not sent, signer refused: %v", sliceId, err)
+		return
+	} else if err != nil {
+		errorf(ctx, "executeSlice(%d) error: %v", sliceId, err)
+		emitEvent(ctx, evError, 0, map[string]interface{}{"slice": sliceId, "error": err.Error()})
+		return
+	}
+	logAt(ctx, slog.LevelInfo, "Submitted tx", "slice", sliceId, "tx", tx.Hash().Hex(), "nonce", tx.Nonce(), "gasLimit", tx.Gas())
+	emitEvent(ctx, evTxSubmitted, 0, map[string]interface{}{"slice": sliceId, "tx": tx.Hash().Hex(), "nonce": tx.Nonce()})
+	e.st.submitted.MarkTx(sliceId, tx, time.Now())
+
+	// Wait for mining, bumping fees if the tx gets stuck
+	receipt, err := waitWithBumps(ctx, txClient, e.st.sender, e.twap, auth, e.txCfg, tx, sliceId)
+	switch {
+	case errors.Is(err, errWaitTimeout):
+		warnf(ctx, "tx %s for slice %d not mined after %s, moving on", tx.Hash().Hex(), sliceId, e.txCfg.WaitTimeout)
+		e.st.nonces.Invalidate()
+		emitTxFailed(ctx, sliceId, tx.Hash(), "timeout")
+		return
+	case errors.Is(err, errTxCanceled):
+		warnf(ctx, "slice %d tx %s canceled after deadline, will re-evaluate on the next block", sliceId, tx.Hash().Hex())
+		e.st.submitted.Clear(sliceId)
+		emitTxFailed(ctx, sliceId, tx.Hash(), "canceled")
+		return
+	case errors.Is(err, errShutdown):
+		// Still marked submitted: shutdown waits out --shutdown-grace for it.
+		logf(ctx, "stopped waiting for tx %s (slice %d): %v", tx.Hash().Hex(), sliceId, err)
+		return
+	case err != nil:
+		errorf(ctx, "wait mined error: %v", err)
+		e.st.nonces.Invalidate()
+		emitTxFailed(ctx, sliceId, tx.Hash(), err.Error())
+		return
+	}
+	e.st.submitted.Clear(sliceId)
+	finishSlice(ctx, e.addr, auth.From, e.st, sliceId, receipt, tx.GasPrice())
+}
+
+// finishSlice books a mined executeSlice receipt and feeds the outcome to the
+// retry tracker.
+func finishSlice(ctx context.Context, addr, from common.Address, st *botState, sliceId int64, receipt *types.Receipt, fallbackPrice *big.Int) {
+	if err := st.ledger.Record(addr, from, sliceId, receipt, fallbackPrice); err != nil {
+		errorf(ctx, "record receipt: %v", err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		logAt(ctx, slog.LevelError, "Tx reverted", "slice", sliceId, "tx", receipt.TxHash.Hex(), "block", receipt.BlockNumber.Uint64(), "gasUsed", receipt.GasUsed)
+		emitTxFailed(ctx, sliceId, receipt.TxHash, "reverted")
+		recordSliceFailure(ctx, st, sliceId)
+		return
+	}
+	st.failures.RecordSuccess(sliceId)
+	kv := []interface{}{"slice", sliceId, "tx", receipt.TxHash.Hex(), "block", receipt.BlockNumber.Uint64(), "gasUsed", receipt.GasUsed}
+	if p := usdPricerFrom(ctx); p != nil {
+		if v := p.receiptGasUSD(ctx, receipt, fallbackPrice); v != nil {
+			kv = append(kv, "gasUsd", v.String())
+		}
+	}
+	logAt(ctx, slog.LevelInfo, "Mined tx", kv...)
+	emitEvent(ctx, evTxMined, receipt.BlockNumber.Uint64(), map[string]interface{}{"slice": sliceId, "tx": receipt.TxHash.Hex(), "gasUsed": receipt.GasUsed})
+}
+
+func recordSliceFailure(ctx context.Context, st *botState, sliceId int64) {
+	gaveUp, tripped := st.failures.RecordFailure(sliceId, time.Now())
+	if gaveUp {
+		errorf(ctx, "giving up on slice %d after repeated failures; it will not be attempted again", sliceId)
+		emitEvent(ctx, evGaveUp, 0, map[string]interface{}{"slice": sliceId, "failures": st.failures.cfg.MaxFailuresPerSlice})
+	}
+	if tripped {
+		errorf(ctx, "circuit breaker tripped, pausing all submissions (send SIGHUP to resume)")
+		emitEvent(ctx, evBreakerTripped, 0, map[string]interface{}{"slice": sliceId, "consecutiveFailures": st.failures.cfg.BreakerThreshold})
+	}
+}
+
+// simulateSlice eth_calls executeSlice(sliceId) from the agent address and
+// returns the decoded revert reason if it would fail. A slice sent ahead of
+// its schedule is simulated against the pending block, whose timestamp is
+// the one it would be mined with.
+func simulateSlice(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, from common.Address, sliceId int64, pending bool) error {
+	err := callExecuteSlice(ctx, addr, cABI, client, from, sliceId, pending)
+	if err == nil {
+		return nil
+	}
+	if reason, ok := describeRevert(cABI, err); ok {
+		return fmt.Errorf("simulation reverted: %s", reason)
+	}
+	return fmt.Errorf("simulation failed: %w", err)
+}
+
+// callExecuteSlice eth_calls executeSlice(sliceId) from from and returns the
+// node's error as is, revert data included.
+func callExecuteSlice(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, from common.Address, sliceId int64, pending bool) error {
+	data, err := cABI.Pack("executeSlice", big.NewInt(sliceId))
+	if err != nil {
+		return fmt.Errorf("pack executeSlice: %w", err)
+	}
+	return rpcRead(ctx, "eth_call executeSlice", func(ctx context.Context) error {
+		msg := ethereum.CallMsg{From: from, To: &addr, Data: data}
+		if pending {
+			_, err := client.PendingCallContract(ctx, msg)
+			return err
+		}
+		_, err := client.CallContract(ctx, msg, nil)
+		return err
+	})
+}
+
+func readStatus(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client) (Status, error) {
+	var st uint8
+	err := rpcRead(ctx, "eth_call status", func(ctx context.Context) (err error) {
+		st, err = twapAt(addr, cABI, client).Status(&bind.CallOpts{Context: ctx})
+		return err
+	})
+	if err != nil {
+		return 0, wrapCallError(cABI, "status", err)
+	}
+	return Status(st), nil
+}
+
+// botState is the mutable state owned by the bot loop and shared with the
+// block handler and execute().
+type botState struct {
+	rawClient *rpc.Client // behind the read client, for batched reads
+	multicall bool        // read per-block state through Multicall3
+	strategy  *strategyCache
+	done      sliceBitmap
+	clock     blockClock
+	lastHead  uint64 // highest block handled, once headSeen
+	headSeen  bool
+	// The not-initialized message was logged; reset once there are slices.
+	waitingLogged bool
+	// What the status API serves; nil without --api-addr.
+	view        *vaultView
+	expiryGrace time.Duration
+	// A terminal order seen by handleBlock, for the bot loop to report.
+	ended     *orderEnd
+	txClient  *ethclient.Client
+	nonces    *nonceManager
+	ledger    *gasLedger
+	failures  *failureTracker
+	sender    *txBroadcaster
+	inFlight  inFlightGuard
+	submitted submittedSlices
+	balance   *balanceWatcher // nil when nothing is signed (--unsigned-out)
+	// Submissions skipped because the pending-state recheck found the slice done.
+	avoided atomic.Int64
+	// When handleBlock last logged the order's progress.
+	progressAt time.Time
+	// Quotes of submitted slices, for the realized slippage on their Fill.
+	quotes sliceQuotes
+	// Set while the oracle is stale or too far off (--max-oracle-age,
+	// --halt-deviation-bps).
+	halt haltSwitch
+	// Set by SIGUSR1 (or --start-paused), cleared by SIGUSR2.
+	paused atomic.Bool
+	// The goroutines submitting slices, for shutdown to wait on.
+	workers sync.WaitGroup
+	// When the next slice is due, if handleBlock found it not yet due and
+	// submissions active; for --anvil-control to warp to.
+	nextDue uint64
+	// 1 + the slice last reported not due; later heads report it at debug.
+	notDueLogged int64
+	// The order's status changes, from OrderStatus events and block reads.
+	status statusHistory
+}
+
+// executor is what submitting a vault's slices takes: the vault, the
+// endpoint it is read through, the key and tx settings, and its state. Bot
+// mode has one per vault; once and execute modes build one for theirs.
+type executor struct {
+	addr    common.Address
+	cABI    abi.ABI
+	twap    *twapbind.Twap
+	client  *ethclient.Client
+	signer  Signer // nil with Defender or --unsigned-out
+	chainID uint64
+	txCfg   TxConfig
+	st      *botState
+}
+
+// vaultBot is one of the contracts bot mode runs, with its own state. The
+// gas ledger in st is shared by all, and the nonce manager and balance
+// watcher by the vaults signer executes.
+type vaultBot struct {
+	executor
+	// Labelled with addr when there are several vaults.
+	ctx context.Context
+	// The outcome last reported, and the end --exit-on-complete waits on.
+	reported string
+	ended    *orderEnd
+}
+
+func (a *Agent) bot(ctx context.Context) error {
+	cfg := &a.cfg
+	// Its topics are filled in below.
+	feedCfg := cfg.Feed
+	if len(a.vaultSigners) == 0 && len(a.signers) == 0 && a.sender.relay == nil && cfg.Tx.UnsignedOut == "" {
+		return fmt.Errorf("a signer (or --defender-api-key, or --unsigned-out) is required for bot mode (--private-key, AGENT_PK, --private-key-file, --keystore, --mnemonic-file, --kms-key-id or --remote-signer-url)")
+	}
+	ledger, err := loadGasLedger(cfg.ReceiptsFile)
+	if err != nil {
+		return err
+	}
+	cp, err := loadCheckpoint(cfg.StateFile, a.chainID)
+	if err != nil {
+		return err
+	}
+	// One nonce sequence and one balance per key, for every vault it
+	// executes.
+	nonces := map[common.Address]*nonceManager{}
+	balances := map[common.Address]*balanceWatcher{}
+	var keys []common.Address // in the order the vaults first use them
+	account := func(s Signer) (common.Address, bool) {
+		switch {
+		case s != nil:
+			return s.Address(), true
+		case a.sender.relay != nil:
+			return a.sender.relay.Address(), false
+		}
+		return common.Address{}, false
+	}
+	useKey := func(s Signer) common.Address {
+		key, signs := account(s)
+		if key == (common.Address{}) || balances[key] != nil {
+			return key
+		}
+		keys = append(keys, key)
+		balances[key] = newBalanceWatcher(cfg.Balance, key)
+		if signs {
+			nonces[key] = newNonceManager(a.txClient, key)
+		}
+		return key
+	}
+	// The status API's and notifiers' taps go on ctx before the vaults
+	// derive theirs.
+	var api *apiServer
+	if cfg.API.Addr != "" {
+		api = newAPIServer(cfg.API, a.chainID, cfg.Tx.Catchup)
+		ctx = withEventTap(ctx, api.tap)
+	}
+	var notify *notifyHub
+	if notifiers := cfg.Notify.notifiers(a.chainID, a.client); len(notifiers) > 0 {
+		notify = newNotifyHub(a.chainID, cfg.Notify.PageAfter, notifiers)
+		ctx = withEventTap(ctx, notify.tap)
+	}
+
+	// newVault sets up the vault at addr, executed by signer, with the state
+	// the last run saved for it.
+	newVault := func(addr common.Address, signer Signer) (*vaultBot, error) {
+		key := useKey(signer)
+		v := &vaultBot{ctx: ctx, executor: executor{
+			addr:    addr,
+			cABI:    a.cABI,
+			twap:    twapbind.NewTwap(addr, a.cABI, a.client, a.txClient, a.client),
+			client:  a.client,
+			signer:  signer,
+			chainID: a.chainID,
+			txCfg:   cfg.Tx,
+		}}
+		if len(a.addrs) > 1 || a.factory != nil {
+			v.ctx = withContractLabel(ctx, addr)
+		}
+		v.st = &botState{
+			failures:  newFailureTracker(cfg.Retry),
+			ledger:    ledger,
+			rawClient: a.rawClient,
+			multicall: a.multicall,
+			strategy:  newStrategyCache(addr, a.cABI, a.client, cfg.RefreshStrategy),
+			txClient:  a.txClient,
+			sender:    a.sender,
+			nonces:    nonces[key],
+			balance:   balances[key],
+
+			expiryGrace: cfg.End.ExpiryGrace,
+		}
+		v.st.paused.Store(cfg.Tx.StartPaused)
+		v.st.status.restore(cp.transitions(addr))
+		if api != nil {
+			v.st.view = &vaultView{}
+		}
+		if err := v.st.strategy.Load(v.ctx); err != nil {
+			return nil, fmt.Errorf("%s: %w", addr.Hex(), err)
+		}
+		if s, N := v.st.strategy.Get(v.ctx, time.Now()); N != nil && N.Sign() > 0 {
+			// Read the tokens' symbols and decimals now, not at the first fill.
+			orderAmountsFor(v.ctx, s)
+			warnUnderfunded(v.ctx, addr, a.cABI, a.client, s)
+		}
+		return v, nil
+	}
+	vaults := make([]*vaultBot, len(a.addrs))
+	byAddr := make(map[common.Address]*vaultBot, len(a.addrs))
+	for i, addr := range a.addrs {
+		var signer Signer
+		if i < len(a.vaultSigners) {
+			signer = a.vaultSigners[i]
+		}
+		v, err := newVault(addr, signer)
+		if err != nil {
+			return err
+		}
+		vaults[i], byAddr[addr] = v, v
+	}
+	if len(vaults) > 1 {
+		logf(ctx, "running %d vaults", len(vaults))
+	}
+	if cfg.Tx.StartPaused {
+		if cfg.API.Token != "" {
+			logf(ctx, "starting paused: send SIGUSR2 or POST /resume to start submitting")
+		} else {
+			logf(ctx, "starting paused: send SIGUSR2 to start submitting")
+		}
+	}
+	if a.sender.isPrivate() {
+		logf(ctx, "submitting transactions through private RPC")
+	}
+	if a.sender.relay != nil {
+		logf(ctx, "submitting executeSlice through Defender relayer %s", a.sender.relay.Address().Hex())
+	}
+	for _, key := range keys {
+		if n := nonces[key]; n != nil {
+			if err := n.Sync(ctx); err != nil {
+				// Not fatal: the manager retries the sync before the first submission.
+				logf(ctx, "initial %v", err)
+			}
+		}
+	}
+	if len(keys) > 0 {
+		head, err := blockNumber(ctx, a.txClient)
+		if err != nil {
+			warnf(ctx, "block number: %v", err)
+		}
+		for _, key := range keys {
+			balances[key].Check(ctx, a.txClient, head)
+		}
+	}
+	for _, v := range vaults {
+		key, _ := account(v.signer)
+		resumeVault(v.ctx, v, key, cp)
+	}
+
+	// Heads and logs of every vault: websocket subscriptions, or polling over
+	// HTTP, starting with those since the last run's last head
+	if from := cp.resumeFrom(); from > 0 {
+		logf(ctx, "resuming from block %d, backfilling the logs since", from)
+	}
+	if !feedCfg.AllEvents {
+		feedCfg.topics = eventTopics(a.cABI, feedEvents)
+	}
+	feedAddrs, from := a.addrs, cp.resumeFrom()
+	if a.factory != nil {
+		// Vaults come and go: every contract's logs with the vault events'
+		// topics, and the factory's creation events, since discovery if not
+		// since the last run.
+		feedAddrs = nil
+		feedCfg.topics[0] = append(feedCfg.topics[0], a.factory.event.ID)
+		if from == 0 {
+			from = a.factory.scanned + 1
+		}
+	}
+	feed, err := openFeed(ctx, a.client, feedAddrs, feedCfg, from)
+	if err != nil {
+		return err
+	}
+	defer feed.Close()
+
+	// The timer driver evaluates when a slice is due; heads only keep its
+	// clock. Its channel stays nil with --driver blocks. It follows one
+	// schedule, so several vaults are evaluated on every head instead.
+	var slots *slotTimer
+	var slotC <-chan time.Time
+	switch {
+	case cfg.Driver.Driver == driverTimer && a.factory != nil:
+		logf(ctx, "timer driver: evaluating every head, as the factory's vaults each have their own schedule")
+	case cfg.Driver.Driver == driverTimer && len(vaults) > 1:
+		logf(ctx, "timer driver: evaluating every head, as %d vaults each have their own schedule", len(vaults))
+	case cfg.Driver.Driver == driverTimer:
+		slots = newSlotTimer(cfg.Driver.LeadTime)
+		defer slots.stop()
+		slotC = slots.C()
+		logf(ctx, "timer driver: evaluating %s before each slice is due", cfg.Driver.LeadTime)
+	}
+	// wake re-arms the timer after something changed which slice is next.
+	wake := func(now bool) {
+		switch {
+		case slots == nil:
+		case now:
+			slots.fireNow()
+		default:
+			slots.reschedule(vaults[0].st, time.Now())
+		}
+	}
+
+	// With a factory, drop stops running v once its order has ended, and
+	// take starts running the vault at addr, created in block, if it passes
+	// the checks. The status API and notifiers follow.
+	track := func() {
+		if api != nil {
+			api.track(vaults)
+		}
+		if notify != nil {
+			notify.track(vaults)
+		}
+	}
+	drop := func(v *vaultBot) {
+		kept := make([]*vaultBot, 0, len(vaults))
+		for _, o := range vaults {
+			if o != v {
+				kept = append(kept, o)
+			}
+		}
+		vaults = kept
+		delete(byAddr, v.addr)
+		cp.forget(v.addr)
+		track()
+		logf(v.ctx, "factory: no longer running %s, its order is %s", v.addr.Hex(), v.reported)
+	}
+	// New vaults follow the last pause or resume of every vault.
+	pausedAll := cfg.Tx.StartPaused
+	take := func(addr common.Address, block uint64) {
+		if byAddr[addr] != nil || !a.factory.isNew(addr) {
+			return
+		}
+		signer, ok := a.admitVault(ctx, addr)
+		if !ok {
+			return
+		}
+		v, err := newVault(addr, signer)
+		if err != nil {
+			warnf(ctx, "factory: %v", err)
+			return
+		}
+		v.st.paused.Store(pausedAll)
+		key, _ := account(signer)
+		resumeVault(v.ctx, v, key, cp)
+		vaults, byAddr[addr] = append(vaults, v), v
+		track()
+		logf(v.ctx, "factory: running %s, created in block %d", addr.Hex(), block)
+	}
+
+	// finish reports v's end once per outcome; an expired order can still
+	// fill late. With --exit-on-complete bot mode ends once every vault has,
+	// with the code of the worst outcome.
+	finish := func(v *vaultBot, end *orderEnd, totals *orderTotals) error {
+		if end == nil || end.Outcome == v.reported {
+			return nil
+		}
+		v.reported = end.Outcome
+		if end.Code != ExitFilled {
+			end.SlicesLeft = v.st.done.Undone()
+		}
+		if totals == nil {
+			t, err := readOrderTotals(v.ctx, v.addr, a.cABI, a.client)
+			if err != nil {
+				warnf(v.ctx, "read order totals: %v", err)
+			}
+			totals = &t
+		}
+		s, _ := v.st.strategy.Cached()
+		ended := map[string]interface{}{"outcome": end.Outcome, "summary": terminalSummaryLine(end, s, *totals, orderAmounts{})}
+		if end.SlicesLeft > 0 {
+			ended["slicesRemaining"] = end.SlicesLeft
+		}
+		for k, x := range map[string]*big.Int{"filledAmountIn": totals.Filled, "receivedAmountOut": totals.Received, "fee": totals.Fee} {
+			if x != nil {
+				ended[k] = x.String()
+			}
+		}
+		emitEvent(v.ctx, evOrderEnded, 0, ended)
+		if len(vaults) > 1 {
+			logf(v.ctx, "Vault %s:", v.addr.Hex())
+		}
+		gas := v.st.ledger.Summary(v.addr)
+		printTerminalSummary(outputFrom(v.ctx), end, s, *totals, gas, orderAmountsFor(v.ctx, s))
+		if p := usdPricerFrom(v.ctx); p != nil {
+			p.printUSDSummary(v.ctx, s, *totals, gas)
+		}
+		printBenchmark(v.ctx, v.addr, a.cABI, a.client, cfg.Tx.Oracle, s)
+		if a.factory != nil && (end.Code == ExitFilled || end.Code == ExitCancelled) {
+			drop(v)
+			return nil
+		}
+		if !cfg.End.ExitOnComplete {
+			logf(v.ctx, "Continuing to watch events...")
+			return nil
+		}
+		v.ended = end
+		worst := end
+		for _, o := range vaults {
+			if o.ended == nil {
+				logf(v.ctx, "Waiting for the other vaults to end...")
+				return nil
+			}
+			if o.ended.Code > worst.Code {
+				worst = o.ended
+			}
+		}
+		return worst
+	}
+	// evaluate runs handleBlock for each vault and reports an end one ran into.
+	// With --anvil-control it then moves the chain's clock to the earliest
+	// slice none of them could submit yet.
+	evaluate := func(h *types.Header) error {
+		var due uint64
+		var dueVault *vaultBot
+		for _, v := range vaults {
+			v.st.nextDue = 0
+			v.handleBlock(v.ctx, h)
+			end := v.st.ended
+			v.st.ended = nil
+			if err := finish(v, end, nil); err != nil {
+				return err
+			}
+			if t := v.st.nextDue; t > 0 && (dueVault == nil || t < due) {
+				due, dueVault = t, v
+			}
+		}
+		if a.warp != nil && dueVault != nil {
+			warpTo(dueVault.ctx, a.warp, due)
+		}
+		return nil
+	}
+
+	// applyLog hands a log, once it has its confirmations, to its vault and
+	// records it in the checkpoint.
+	held := &confirmBuffer{depth: cfg.Tx.Confirmations}
+	applyLog := func(lg types.Log) error {
+		var err error
+		if v := byAddr[lg.Address]; v != nil { // else dropped while held
+			err = v.handleLog(lg, wake, finish)
+		}
+		cp.logHandled(lg)
+		return err
+	}
+
+	// A dev node mines only with transactions, so with --anvil-control the
+	// block driver would wait forever for the head that makes the first warp.
+	if a.warp != nil && slots == nil {
+		hdr, err := headerByNumber(ctx, a.client, nil)
+		if err != nil {
+			return fmt.Errorf("latest header: %w", err)
+		}
+		if err := evaluate(hdr); err != nil {
+			return err
+		}
+	}
+
+	// setPaused pauses or resumes submissions to targets, nil for every
+	// vault, for by, the operator or the API, and reports whether that
+	// changed any of them.
+	setPaused := func(targets []*vaultBot, paused bool, by string) bool {
+		changed := false
+		if targets == nil {
+			targets = vaults
+			changed = a.factory != nil && pausedAll != paused
+			pausedAll = paused
+		}
+		for _, v := range targets {
+			changed = v.st.paused.Swap(paused) != paused || changed
+		}
+		pctx := ctx
+		if len(targets) == 1 {
+			pctx = targets[0].ctx
+		}
+		switch {
+		case !changed:
+			state := "paused"
+			if len(targets) > 0 {
+				state = targets[0].st.runState()
+			} else if !paused {
+				state = "active"
+			}
+			logf(pctx, "%s: already %s", by, state)
+			return false
+		case paused:
+			logf(pctx, "%s: submissions paused by operator", by)
+			emitEvent(pctx, evPaused, 0, nil)
+		default:
+			logf(pctx, "%s: submissions resumed by operator", by)
+			emitEvent(pctx, evUnpaused, 0, nil)
+		}
+		wake(true)
+		return true
+	}
+	var apiControl <-chan apiControl
+	if api != nil {
+		if err := api.serve(ctx, vaults); err != nil {
+			return err
+		}
+		defer api.Close()
+		apiControl = api.control
+	}
+	if notify != nil {
+		notify.start(ctx, vaults)
+		defer notify.close()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			var left []pendingTx
+			for _, v := range vaults {
+				var pending *pendingTxError
+				if err := drainSubmitted(v.ctx, v.addr, cfg.Tx, v.st); errors.As(err, &pending) {
+					left = append(left, pending.Txs...)
+				}
+				saveVault(v, cp)
+			}
+			if err := cp.flush(); err != nil {
+				warnf(ctx, "%v", err)
+			}
+			if len(left) > 0 {
+				return &pendingTxError{Txs: left}
+			}
+			return errInterrupted
+		case <-a.resetC:
+			for _, v := range vaults {
+				v.st.failures.ResetBreaker()
+			}
+			logf(ctx, "circuit breaker reset by operator")
+			wake(true)
+		case p := <-a.pauseC:
+			setPaused(nil, p.paused, p.by)
+		case c := <-apiControl:
+			c.changed <- setPaused(c.vaults, c.paused, "api")
+		case h := <-feed.Heads():
+			if h != nil && h.Number != nil {
+				logs, err := held.release(ctx, a.client, h.Number.Uint64())
+				if err != nil {
+					warnf(ctx, "%v", err)
+				}
+				for _, lg := range logs {
+					if err := applyLog(lg); err != nil {
+						return err
+					}
+				}
+				if n := held.final(h.Number.Uint64()); n > 0 {
+					for _, v := range vaults {
+						saveVault(v, cp)
+					}
+					if err := cp.headHandled(n); err != nil {
+						warnf(ctx, "%v", err)
+					}
+				}
+			}
+			for _, v := range vaults {
+				v.st.clock.observe(h)
+			}
+			if slots != nil {
+				slots.noteHead(h, time.Now())
+				if a.warp != nil {
+					// A dev node's heads are warps and the bot's own txs;
+					// after a warp the slice is due without the wall clock
+					// having moved.
+					wake(true)
+				}
+				continue
+			}
+			if err := evaluate(h); err != nil {
+				return err
+			}
+		case <-slotC:
+			// One block-time read: handleBlock checks eligibility against it
+			hdr, err := headerByNumber(ctx, a.client, nil)
+			if err != nil {
+				warnf(ctx, "timer: latest header: %v (retrying in %s)", err, timerRecheck)
+				slots.timer.Reset(timerRecheck)
+				continue
+			}
+			slots.noteHead(hdr, time.Now())
+			if err := evaluate(hdr); err != nil {
+				return err
+			}
+			slots.evaluated(time.Now())
+			slots.reschedule(vaults[0].st, time.Now())
+		case lg := <-feed.Logs():
+			if a.factory != nil {
+				if addr, ok := a.factory.vaultOf(lg); ok {
+					if !lg.Removed {
+						take(addr, lg.BlockNumber)
+					}
+					continue
+				}
+			}
+			if byAddr[lg.Address] == nil || len(lg.Topics) == 0 {
+				continue
+			}
+			// A backfill can overlap what the last run, or the live
+			// stream, delivered.
+			if !lg.Removed && cp.handled(lg) {
+				continue
+			}
+			for _, lg := range held.add(lg) {
+				if err := applyLog(lg); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// handleLog logs one of v's logs, decoded with whatever event of cABI it
+// is, and applies it to v's state: Fill marks the slice done (or open again
+// when reorged out), Unpaused and a reset OrderStatus reload the strategy,
+// and a terminal OrderStatus is reported through finish.
+func (v *vaultBot) handleLog(lg types.Log, wake func(bool), finish func(*vaultBot, *orderEnd, *orderTotals) error) error {
+	ctx, st := v.ctx, v.st
+	ev, values, err := decodeEvent(v.cABI, lg)
+	if ev == nil {
+		// Not in the ABI: the contract and the ABI the agent runs with differ.
+		warnf(ctx, "[Event] unknown topic0 %s in block %d (tx %s), data %s", lg.Topics[0].Hex(), lg.BlockNumber, lg.TxHash.Hex(), hexutil.Encode(lg.Data))
+		return nil
+	}
+	line := ev.Name + " (" + fmt.Sprint(err) + ")"
+	if err == nil {
+		line = formatEvent(ev, values, st.amounts(ctx))
+	}
+	if lg.Removed {
+		line += " [removed by reorg]"
+	}
+	logAt(ctx, slog.LevelInfo, "[Event] "+line, "block", lg.BlockNumber, "tx", lg.TxHash.Hex())
+	if err != nil {
+		return nil
+	}
+	switch ev.Name {
+	case "Fill":
+		var out FillEvent
+		if err := unpackLog(v.cABI, &out, "Fill", lg); err != nil {
+			return nil
+		}
+		if lg.Removed {
+			// Reorged out: the slice is open again, to execute unless the
+			// tx makes it into the new chain.
+			warnf(ctx, "reorg removed fill for slice %s (tx %s, block %d); the slice is open again", out.SliceId, lg.TxHash.Hex(), lg.BlockNumber)
+			emitEvent(ctx, evFill, lg.BlockNumber, map[string]interface{}{
+				"slice": out.SliceId.Int64(), "amountIn": out.AmountIn.String(), "amountOut": out.AmountOut.String(),
+				"fee": out.Fee.String(), "tx": lg.TxHash.Hex(), "removed": true,
+			})
+			st.done.Set(out.SliceId.Int64(), false)
+			wake(false)
+			return nil
+		}
+		emitEvent(ctx, evFill, lg.BlockNumber, map[string]interface{}{
+			"slice": out.SliceId.Int64(), "amountIn": out.AmountIn.String(), "amountOut": out.AmountOut.String(),
+			"fee": out.Fee.String(), "tx": lg.TxHash.Hex(),
+		})
+		if p := usdPricerFrom(ctx); p != nil && st.strategy != nil {
+			s, _ := st.strategy.Cached()
+			if line := p.fillUSD(ctx, s, out.AmountIn, out.AmountOut); line != "" {
+				logf(ctx, "Slice %s: %s", out.SliceId, line)
+			}
+		}
+		if q, ok := st.quotes.Take(out.SliceId.Int64()); ok {
+			if bps, ok := realizedSlippageBps(q.AmountIn, q.AmountOut, out.AmountIn, out.AmountOut); ok {
+				a := st.amounts(ctx)
+				logf(ctx, "Slice %s: received %s, quoted %s for %s in: realized slippage %s bps", out.SliceId, a.Out(out.AmountOut), a.Out(q.AmountOut), a.In(q.AmountIn), bps)
+			}
+		}
+		st.done.Set(out.SliceId.Int64(), true)
+		st.inFlight.Release(out.SliceId.Int64())
+		st.submitted.Clear(out.SliceId.Int64())
+		// Possibly executed by someone else: the next slice may be due now
+		wake(false)
+	case "Unpaused":
+		// The strategy can only be reconfigured while paused.
+		st.strategy.Invalidate()
+		wake(true)
+	case "OrderStatus":
+		var out OrderStatusEvent
+		if err := unpackLog(v.cABI, &out, "OrderStatus", lg); err != nil {
+			return nil
+		}
+		status := Status(out.Status)
+		emitEvent(ctx, evOrderStatus, lg.BlockNumber, map[string]interface{}{
+			"filledAmountIn": out.FilledAmountIn.String(), "receivedAmountOut": out.ReceivedAmountOut.String(),
+			"fee": out.Fee.String(), "status": status.String(), "removed": lg.Removed, "tx": lg.TxHash.Hex(),
+		})
+		if status == StatusOpen { // configureStrategy reset the order
+			st.strategy.Invalidate()
+			st.done.Invalidate()
+			v.reported, v.ended = "", nil
+			wake(true)
+		}
+		if lg.Removed {
+			return nil
+		}
+		st.noteStatus(ctx, status, lg.BlockNumber, time.Now(), "event")
+		if status != StatusOpen {
+			s, N := st.strategy.Get(ctx, time.Now())
+			if n, err := sliceCount(N); err == nil && n > 0 {
+				bt, _ := st.clock.blockTime()
+				logf(ctx, "%s", newOrderProgress(s, n, out.FilledAmountIn, uint64(time.Now().Unix()), bt, v.txCfg.Catchup))
+			}
+		}
+		totals := &orderTotals{Filled: out.FilledAmountIn, Received: out.ReceivedAmountOut, Fee: out.Fee}
+		return finish(v, terminalEnd(status), totals)
+	}
+	return nil
+}
+
+// amounts prints the cached strategy's token amounts the way ctx asks.
+func (st *botState) amounts(ctx context.Context) orderAmounts {
+	if st.strategy == nil {
+		return orderAmounts{}
+	}
+	s, _ := st.strategy.Cached()
+	return orderAmountsFor(ctx, s)
+}
+
+// noteStatus records the order's status as seen at block, and logs and
+// emits the transition when it changed.
+func (st *botState) noteStatus(ctx context.Context, status Status, block uint64, at time.Time, source string) {
+	t, ok := st.status.observe(status, block, at, source)
+	if !ok {
+		return
+	}
+	data := map[string]interface{}{"to": t.To, "source": source}
+	if t.From == "" {
+		logf(ctx, "order status %s at block %d (%s)", t.To, block, source)
+	} else {
+		logf(ctx, "order status %s -> %s at block %d (%s)", t.From, t.To, block, source)
+		data["from"] = t.From
+	}
+	emitEvent(ctx, evStatusChanged, block, data)
+}
+
+func (e *executor) handleBlock(ctx context.Context, hdr *types.Header) {
+	if hdr == nil || hdr.Number == nil {
+		debugf(ctx, "ignoring incomplete header from the feed: %+v", hdr)
+		return
+	}
+	number := hdr.Number
+	// After a reconnect the feed can replay heads the bot already handled
+	if e.st.headSeen && number.Uint64() < e.st.lastHead {
+		debugf(ctx, "ignoring block %d, older than the last handled block %d", number.Uint64(), e.st.lastHead)
+		return
+	}
+	e.st.lastHead, e.st.headSeen = number.Uint64(), true
+	logAt(ctx, slog.LevelDebug, "New block", "block", number.Uint64(), "time", hdr.Time)
+	head := map[string]interface{}{"time": hdr.Time, "state": e.st.runState()}
+	if reason := e.st.halt.Reason(); reason != "" {
+		head["halted"] = reason
+	}
+	emitEvent(ctx, evHead, number.Uint64(), head)
+	if l := rpcLimiterFrom(ctx); l != nil {
+		l.logStats(ctx)
+	}
+	if e.st.balance != nil {
+		e.st.balance.Check(ctx, e.st.txClient, number.Uint64())
+	}
+
+	// The strategy is cached; status and filledAmountIn come back in one
+	// batch or one Multicall3 call
+	s, N := e.st.strategy.Get(ctx, time.Now())
+	n, err := sliceCount(N)
+	if err != nil {
+		warnf(ctx, "block %d: %v", number.Uint64(), err)
+		emitEvent(ctx, evError, number.Uint64(), map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if n == 0 {
+		// OrderStatus from configureStrategy invalidates the cached strategy
+		if !e.st.waitingLogged {
+			logf(ctx, "%v", errNotInitialized)
+			e.st.waitingLogged = true
+		}
+		return
+	}
+	e.st.waitingLogged = false
+	var reads blockReads
+	if e.st.multicall {
+		reads, err = readBlockMulticall(ctx, e.addr, e.cABI, e.client, number)
+	} else {
+		reads, err = readBlock(ctx, e.addr, e.cABI, e.st.rawClient)
+	}
+	if e.st.view != nil {
+		e.st.view.observeBlock(number.Uint64(), hdr.Time, reads)
+	}
+	// Skip execution attempts if order is filled or canceled
+	if reads.StatusErr == nil {
+		e.st.noteStatus(ctx, reads.Status, number.Uint64(), time.Unix(int64(hdr.Time), 0).UTC(), "read")
+		if end := terminalEnd(reads.Status); end != nil {
+			e.st.ended = end
+			return
+		}
+	}
+	// Attempt execute if eligible
+	if err != nil {
+		warnf(ctx, "block %d: %v", hdr.Number.Uint64(), err)
+		emitEvent(ctx, evError, number.Uint64(), map[string]interface{}{"error": err.Error()})
+		return
+	}
+	now := new(big.Int).SetUint64(hdr.Time)
+	if e.txCfg.ProgressInterval > 0 && reads.Filled != nil && time.Since(e.st.progressAt) >= e.txCfg.ProgressInterval {
+		bt, _ := e.st.clock.blockTime()
+		p := newOrderProgress(s, n, reads.Filled, hdr.Time, bt, e.txCfg.Catchup)
+		switch reason := e.st.halt.Reason(); {
+		case e.st.paused.Load():
+			logf(ctx, "%s; paused", p)
+		case reason != "":
+			logf(ctx, "%s; halted: %s", p, reason)
+		default:
+			logf(ctx, "%s", p)
+		}
+		e.st.progressAt = time.Now()
+	}
+	// Determine the first (unrelaized) slice regardless of schedule, from the
+	// bitmap kept current by Fill events
+	if !e.st.done.Loaded(n) {
+		if err := loadSliceBitmap(ctx, &e.st.done, e.addr, e.cABI, e.client, e.st.rawClient, e.st.multicall, n); err != nil {
+			warnf(ctx, "block %d: load sliceDone: %v", hdr.Number.Uint64(), err)
+			emitEvent(ctx, evError, number.Uint64(), map[string]interface{}{"error": "load sliceDone: " + err.Error()})
+			return
+		}
+	}
+	if reads.StatusErr == nil && orderExpired(s, hdr.Time, e.st.expiryGrace) && e.st.done.FirstUndone(func(int64) bool { return false }) >= 0 {
+		// The vault has no per-slice deadline and still executes late
+		// slices, so carry on unless told to exit.
+		e.st.ended = &orderEnd{Outcome: "expired", Code: ExitExpired}
+	}
+	firstUndone := e.st.done.FirstUndone(e.st.failures.GaveUp)
+	if firstUndone >= 0 {
+		// Compute schedule info
+		scheduled, err := sliceScheduledAt(s, n, firstUndone)
+		if err != nil {
+			warnf(ctx, "block %d: %v", number.Uint64(), err)
+			emitEvent(ctx, evError, number.Uint64(), map[string]interface{}{"error": err.Error()})
+			return
+		}
+		decide := func(action string, data map[string]interface{}) {
+			if data == nil {
+				data = map[string]interface{}{}
+			}
+			data["slice"], data["action"], data["state"] = firstUndone, action, e.st.runState()
+			emitEvent(ctx, evDecision, number.Uint64(), data)
+		}
+		execNow := now.Cmp(scheduled) >= 0
+		early := !execNow && submitAhead(&e.st.clock, hdr.Time, scheduled, e.txCfg.LeadTimeSeconds)
+		if execNow || early {
+			if ok, reason := e.st.failures.Allow(firstUndone, time.Now()); !ok {
+				logf(ctx, "Not submitting slice %d: %s", firstUndone, reason)
+				decide("blocked", map[string]interface{}{"reason": reason})
+				return
+			}
+			if e.st.paused.Load() {
+				logf(ctx, "paused, would have executed slice %d", firstUndone)
+				decide("paused", nil)
+				return
+			}
+			if e.txCfg.UnsignedOut != "" {
+				if early {
+					return // the call is written once the slice is due
+				}
+				if !confirmUndone(ctx, e.addr, e.cABI, e.client, e.st, firstUndone) {
+					return
+				}
+				e.emitUnsignedSlice(ctx, firstUndone, scheduled.Uint64())
+				return
+			}
+			if sub, ok := e.st.submitted.Pending(firstUndone, e.txCfg.ResubmitAfter, time.Now()); ok {
+				logf(ctx, "Slice %d already submitted in %s, waiting for it to mine", firstUndone, sub.Hash.Hex())
+				decide("waiting", map[string]interface{}{"tx": sub.Hash.Hex()})
+				return
+			}
+			if inFlight, ok := e.st.inFlight.Current(); ok {
+				logf(ctx, "Slice %d in flight, not submitting slice %d", inFlight, firstUndone)
+				decide("in_flight", map[string]interface{}{"inFlight": inFlight})
+				return
+			}
+			if !confirmUndone(ctx, e.addr, e.cABI, e.client, e.st, firstUndone) {
+				return
+			}
+			if e.txCfg.Catchup && execNow {
+				if batch := overdueSlices(e.st, s, n, now, firstUndone, e.txCfg.ResubmitAfter); len(batch) > 1 {
+					if !e.st.inFlight.TryAcquireAll(batch) {
+						return
+					}
+					decide("catchup", map[string]interface{}{"slices": batch})
+					e.st.workers.Add(1)
+					go func() {
+						defer e.st.workers.Done()
+						e.catchUp(ctx, s, n, batch, now)
+					}()
+					return
+				}
+			}
+			if !e.st.inFlight.TryAcquire(firstUndone) {
+				return
+			}
+			overdue := new(big.Int).Sub(now, scheduled).Int64()
+			if early {
+				logAt(ctx, slog.LevelInfo, "Submitting slice ahead of its schedule", "slice", firstUndone, "block", hdr.Number.Uint64(), "dueIn", -overdue)
+				decide("submit_early", map[string]interface{}{"dueIn": -overdue})
+			} else {
+				logAt(ctx, slog.LevelInfo, "Eligible slice", "slice", firstUndone, "block", hdr.Number.Uint64(), "overdue", overdue)
+				decide("submit", map[string]interface{}{"overdue": overdue})
+			}
+			// Run off the event loop so heads and logs keep draining while the tx is pending.
+			e.st.workers.Add(1)
+			go func(sliceId int64) {
+				defer e.st.workers.Done()
+				defer e.st.inFlight.Release(sliceId)
+				e.execute(ctx, sliceId, overdue)
+			}(firstUndone)
+		} else {
+			// Log when it will be executable
+			diff := new(big.Int).Sub(scheduled, now)
+			level := slog.LevelInfo
+			if e.st.notDueLogged == firstUndone+1 {
+				level = slog.LevelDebug
+			}
+			e.st.notDueLogged = firstUndone + 1
+			logAt(ctx, level, "Next slice not due", "slice", firstUndone, "scheduledAt", scheduled.Uint64(), "in", diff.Uint64(), "state", e.st.runState())
+			decide("not_due", map[string]interface{}{"scheduledAt": scheduled.Uint64()})
+			if e.st.runState() == "active" {
+				e.st.nextDue = scheduled.Uint64()
+			}
+		}
+	}
+}
+
+// logSkippedSlice reports a failed simulation; for a slice sent ahead of its
+// schedule a revert usually just means the pending block is still too early.
+func logSkippedSlice(ctx context.Context, sliceId, overdue int64, err error) {
+	emitEvent(ctx, evDecision, 0, map[string]interface{}{"slice": sliceId, "action": "skipped", "reason": err.Error()})
+	if overdue < 0 {
+		logf(ctx, "slice %d not submitted ahead of schedule, retrying on the next head: %v", sliceId, err)
+		return
+	}
+	warnf(ctx, "skipping slice %d: %v", sliceId, err)
+}
+
+// confirmUndone re-reads sliceDone for the slice the bitmap picked, right
+// before it is submitted; a Fill the bot missed marks it done instead.
+func confirmUndone(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, st *botState, sliceId int64) bool {
+	done, err := readSliceDone(ctx, addr, cABI, client, big.NewInt(sliceId))
+	if err != nil {
+		// execute() checks the pending state again before sending.
+		warnf(ctx, "sliceDone(%d) re-check failed: %v", sliceId, err)
+		return true
+	}
+	if done {
+		logf(ctx, "slice %d is already done on chain, updating the local bitmap", sliceId)
+		st.done.Set(sliceId, true)
+		return false
+	}
+	return true
+}
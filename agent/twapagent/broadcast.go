@@ -0,0 +1,57 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	"twap-agent/twapbind"
+)
+
+// txBroadcaster sends signed transactions either through the read RPC or,
+// when --private-rpc is set, through a private relay (Flashbots Protect,
+// MEV Blocker, ...) so executeSlice never sits in the public mempool.
+type txBroadcaster struct {
+	public  *ethclient.Client
+	private *ethclient.Client
+	// Re-broadcast publicly if a private tx isn't included within this many blocks (0 = never).
+	fallbackBlocks uint64
+	// When set, executeSlice goes through the Defender Relayer instead of being signed locally.
+	relay *defenderRelay
+}
+
+func (b *txBroadcaster) isPrivate() bool { return b.private != nil }
+
+// Send broadcasts tx via eth_sendRawTransaction on the private relay if one is configured.
+func (b *txBroadcaster) Send(ctx context.Context, tx *types.Transaction) error {
+	if b.private != nil {
+		if err := b.private.SendTransaction(ctx, tx); err != nil {
+			return fmt.Errorf("private send: %w", err)
+		}
+		return nil
+	}
+	return b.public.SendTransaction(ctx, tx)
+}
+
+// SendPublic broadcasts tx through the read RPC regardless of configuration.
+func (b *txBroadcaster) SendPublic(ctx context.Context, tx *types.Transaction) error {
+	return b.public.SendTransaction(ctx, tx)
+}
+
+// signAndSend signs the executeSlice call with opts and hands it to the broadcaster.
+func signAndSend(ctx context.Context, b *txBroadcaster, twap *twapbind.Twap, opts *bind.TransactOpts, sliceId int64) (*types.Transaction, error) {
+	signOpts := *opts
+	signOpts.NoSend = true
+	tx, err := twap.ExecuteSlice(&signOpts, big.NewInt(sliceId))
+	if err != nil {
+		return nil, err
+	}
+	if err := b.Send(ctx, tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
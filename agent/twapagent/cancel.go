@@ -0,0 +1,78 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// orderStatusFromLogs decodes the vault's last OrderStatus event in logs.
+func orderStatusFromLogs(cABI abi.ABI, addr common.Address, logs []*types.Log) (orderTotals, Status, bool) {
+	ev, ok := cABI.Events["OrderStatus"]
+	if !ok {
+		return orderTotals{}, 0, false
+	}
+	var t orderTotals
+	var status Status
+	found := false
+	for _, lg := range logs {
+		if lg.Address != addr || len(lg.Topics) == 0 || lg.Topics[0] != ev.ID {
+			continue
+		}
+		var out OrderStatusEvent
+		if err := unpackLog(cABI, &out, "OrderStatus", *lg); err != nil {
+			continue
+		}
+		t = orderTotals{Filled: out.FilledAmountIn, Received: out.ReceivedAmountOut, Fee: out.Fee}
+		status, found = Status(out.Status), true
+	}
+	return t, status, found
+}
+
+// cancelOrder is cancel mode: as the owner, cancel the order and pause the
+// vault. The contract refunds nothing by itself; what is left in the vault is
+// printed so the owner can sweep it.
+func (e *executor) cancelOrder(ctx context.Context) error {
+	if err := e.checkOwner(ctx); err != nil {
+		return err
+	}
+	status, err := readStatus(ctx, e.addr, e.cABI, e.client)
+	if err != nil {
+		return err
+	}
+	if status.Terminal() {
+		return fmt.Errorf("order is already %s, nothing to cancel", status)
+	}
+	data, err := e.cABI.Pack("cancel")
+	if err != nil {
+		return fmt.Errorf("pack cancel: %w", err)
+	}
+	receipt, err := e.sendOwnerTx(ctx, e.addr, e.cABI, "cancel", data, e.twap.Cancel)
+	if err != nil {
+		return err
+	}
+	if t, st, ok := orderStatusFromLogs(e.cABI, e.addr, receipt.Logs); ok {
+		fmt.Fprintf(outputFrom(ctx), "[Event] OrderStatus: filled=%s received=%s fee=%s status=%s\n", t.Filled, t.Received, t.Fee, st.describe())
+	}
+
+	s, err := readStrategy(ctx, e.addr, e.cABI, e.client)
+	if err != nil {
+		return fmt.Errorf("read strategy: %w", err)
+	}
+	for _, tok := range []struct {
+		name string
+		addr common.Address
+	}{{"tokenIn", s.TokenIn}, {"tokenOut", s.TokenOut}} {
+		bal, err := readTokenBalance(ctx, e.client, tok.addr, e.addr)
+		if err != nil {
+			logf(ctx, "read %s balance: %v", tok.name, err)
+			continue
+		}
+		fmt.Fprintf(outputFrom(ctx), "- vault %s balance: %s (%s)\n", tok.name, bal, tok.addr.Hex())
+	}
+	fmt.Fprintln(outputFrom(ctx), "The vault is cancelled and paused; its balances stay there until the owner sweeps them.")
+	return nil
+}
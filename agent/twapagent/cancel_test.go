@@ -0,0 +1,53 @@
+package twapagent
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"twap-agent/twapbind"
+)
+
+func TestCheckOwner(t *testing.T) {
+	_, twap, cABI, client, _ := newVaultRPC(t, 24, 5)
+	ctx := context.Background()
+	signedBy := func(signer Signer) *executor {
+		return &executor{addr: twap.Address(), cABI: cABI, client: client, signer: signer}
+	}
+	if err := signedBy(dryRunSigner{vaultOwner}).checkOwner(ctx); err != nil {
+		t.Fatalf("owner key rejected: %v", err)
+	}
+	if err := signedBy(newFakeSigner(t)).checkOwner(ctx); err == nil {
+		t.Fatal("a key that is not the owner was accepted")
+	}
+	if err := signedBy(nil).checkOwner(ctx); err == nil {
+		t.Fatal("no key was accepted")
+	}
+}
+
+func TestOrderStatusFromLogs(t *testing.T) {
+	cABI, err := twapbind.ParseABI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault := common.Address{0xaa}
+	ev := cABI.Events["OrderStatus"]
+	data, err := ev.Inputs.Pack(big.NewInt(300), big.NewInt(600), big.NewInt(3), uint8(StatusCancelled))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := []*types.Log{
+		{Address: common.Address{0xbb}, Topics: []common.Hash{ev.ID}, Data: data}, // another contract
+		{Address: vault, Topics: []common.Hash{ev.ID}, Data: data},
+	}
+	totals, status, ok := orderStatusFromLogs(cABI, vault, logs)
+	if !ok || status != StatusCancelled || totals.Filled.Int64() != 300 || totals.Received.Int64() != 600 {
+		t.Fatalf("decoded %+v %s %v", totals, status, ok)
+	}
+	if _, _, ok := orderStatusFromLogs(cABI, vault, logs[:1]); ok {
+		t.Fatal("decoded another contract's OrderStatus")
+	}
+}
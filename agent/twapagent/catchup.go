@@ -0,0 +1,94 @@
+package twapagent
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// maxCatchupBatch caps one --catchup pass. It matches geth's default of 16
+// pending txs per account, which matters with --catchup-parallel; whatever is
+// left over is picked up on the next block.
+const maxCatchupBatch = 16
+
+// overdueSlices lists first and the open slices after it whose schedule has
+// passed at now, in order. Slices the retry tracker gave up on, or with a tx
+// still pending, are left out.
+func overdueSlices(st *botState, s Strategy, n int64, now *big.Int, first int64, resubmitAfter time.Duration) []int64 {
+	ids := []int64{first}
+	for i := first + 1; i < n && len(ids) < maxCatchupBatch; i++ {
+		scheduled, err := sliceScheduledAt(s, n, i)
+		if err != nil || now.Cmp(scheduled) < 0 {
+			break // later slices are due later still
+		}
+		if st.done.Done(i) || st.failures.GaveUp(i) {
+			continue
+		}
+		if _, pending := st.submitted.Pending(i, resubmitAfter, time.Now()); pending {
+			continue
+		}
+		ids = append(ids, i)
+	}
+	return ids
+}
+
+// catchUp executes a batch of overdue slices, all already marked in flight.
+// By default they go one after another, each waiting for its receipt; with
+// txCfg.CatchupParallel they are all submitted at once with consecutive
+// nonces. A failed slice doesn't stop the rest, but a tripped circuit
+// breaker does, and every slice is re-checked against the retry tracker and
+// the Fill events seen meanwhile before it is attempted.
+func (e *executor) catchUp(ctx context.Context, s Strategy, n int64, batch []int64, now *big.Int) {
+	logf(ctx, "Catching up on %d overdue slices: %v", len(batch), batch)
+	run := func(id int64) {
+		defer e.st.inFlight.Release(id)
+		scheduled, err := sliceScheduledAt(s, n, id)
+		if err != nil {
+			warnf(ctx, "slice %d: %v", id, err)
+			return
+		}
+		e.execute(ctx, id, new(big.Int).Sub(now, scheduled).Int64())
+	}
+	// ready re-checks id just before it is attempted, releasing it if not.
+	ready := func(id int64) bool {
+		if e.st.done.Done(id) {
+			e.st.inFlight.Release(id)
+			return false
+		}
+		if ok, reason := e.st.failures.Allow(id, time.Now()); !ok {
+			logf(ctx, "Not submitting slice %d: %s", id, reason)
+			e.st.inFlight.Release(id)
+			return false
+		}
+		return true
+	}
+
+	if e.txCfg.CatchupParallel {
+		var wg sync.WaitGroup
+		for _, id := range batch {
+			if !ready(id) {
+				continue
+			}
+			wg.Add(1)
+			go func(id int64) {
+				defer wg.Done()
+				run(id)
+			}(id)
+		}
+		wg.Wait()
+		return
+	}
+	for i, id := range batch {
+		if ctx.Err() != nil || e.st.failures.Tripped() {
+			for _, rest := range batch[i:] {
+				e.st.inFlight.Release(rest)
+			}
+			logf(ctx, "catch-up stopped with %d slices left", len(batch)-i)
+			return
+		}
+		if ready(id) {
+			run(id)
+		}
+	}
+}
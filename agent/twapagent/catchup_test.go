@@ -0,0 +1,121 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// revertingEth makes the executeSlice simulation of one slice revert.
+type revertingEth struct {
+	*fakeEth
+	execSel []byte
+	revert  *big.Int
+}
+
+func (f *revertingEth) Call(args fakeCallArgs, block string) (hexutil.Bytes, error) {
+	if data := args.Data; len(data) == 36 && string(data[:4]) == string(f.execSel) && new(big.Int).SetBytes(data[4:]).Cmp(f.revert) == 0 {
+		return nil, errors.New("execution reverted")
+	}
+	return f.fakeEth.Call(args, block), nil
+}
+
+func catchupStrategy() Strategy {
+	return Strategy{StartTime: big.NewInt(0), EndTime: big.NewInt(400)}
+}
+
+func slicesSent(t *testing.T, h *executeHarness, txs []*types.Transaction) map[int64]bool {
+	t.Helper()
+	got := make(map[int64]bool)
+	for _, tx := range txs {
+		args, err := h.cABI.Methods["executeSlice"].Inputs.Unpack(tx.Data()[4:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[args[0].(*big.Int).Int64()] = true
+	}
+	return got
+}
+
+func TestOverdueSlices(t *testing.T) {
+	st := &botState{failures: newFailureTracker(RetryConfig{MaxFailuresPerSlice: 1})}
+	st.done.Load([]bool{true, false, true, false, false, false})
+	st.failures.RecordFailure(4, time.Now())
+	// interval 100: slices 0-3 are due at 350, 4 gave up, 5 isn't due.
+	s := Strategy{StartTime: big.NewInt(0), EndTime: big.NewInt(600)}
+	got := overdueSlices(st, s, 6, big.NewInt(350), 1, time.Minute)
+	if len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Fatalf("overdue = %v, want [1 3]", got)
+	}
+}
+
+func TestCatchUpSequentialContinuesPastFailure(t *testing.T) {
+	h := newExecuteHarness(t)
+	eth := &revertingEth{fakeEth: h.eth, execSel: h.cABI.Methods["executeSlice"].ID, revert: big.NewInt(1)}
+	h.client = dialFakeEth(t, eth)
+	signer := newFakeSigner(t)
+	st := h.state(t, signer)
+	st.txClient, st.sender, st.nonces = h.client, &txBroadcaster{public: h.client}, newNonceManager(h.client, signer.Address())
+
+	batch := []int64{0, 1, 2, 3}
+	if !st.inFlight.TryAcquireAll(batch) {
+		t.Fatal("acquire")
+	}
+	h.executor(signer, h.cfg, st).catchUp(context.Background(), catchupStrategy(), 4, batch, big.NewInt(1_000))
+
+	got := slicesSent(t, h, h.eth.sentTxs())
+	if len(got) != 3 || got[1] {
+		t.Fatalf("sent slices %v, want 0, 2 and 3", got)
+	}
+	if _, ok := st.inFlight.Current(); ok {
+		t.Fatal("guard still held after catch-up")
+	}
+}
+
+func TestCatchUpParallel(t *testing.T) {
+	h := newExecuteHarness(t)
+	signer := newFakeSigner(t)
+	st := h.state(t, signer)
+	cfg := h.cfg
+	cfg.CatchupParallel = true
+
+	batch := []int64{0, 1, 2}
+	st.inFlight.TryAcquireAll(batch)
+	h.executor(signer, cfg, st).catchUp(context.Background(), catchupStrategy(), 4, batch, big.NewInt(1_000))
+
+	sent := h.eth.sentTxs()
+	if got := slicesSent(t, h, sent); len(got) != 3 {
+		t.Fatalf("sent slices %v, want 0-2", got)
+	}
+	nonces := make(map[uint64]bool)
+	for _, tx := range sent {
+		nonces[tx.Nonce()] = true
+	}
+	if len(nonces) != 3 {
+		t.Fatalf("nonces %v, want 3 distinct", nonces)
+	}
+}
+
+func TestCatchUpStopsOnBreaker(t *testing.T) {
+	h := newExecuteHarness(t)
+	signer := newFakeSigner(t)
+	st := h.state(t, signer)
+	st.failures = newFailureTracker(RetryConfig{BreakerThreshold: 1})
+	st.failures.RecordFailure(99, time.Now())
+
+	batch := []int64{0, 1}
+	st.inFlight.TryAcquireAll(batch)
+	h.executor(signer, h.cfg, st).catchUp(context.Background(), catchupStrategy(), 4, batch, big.NewInt(1_000))
+
+	if n := len(h.eth.sentTxs()); n != 0 {
+		t.Fatalf("sent %d txs with the breaker open, want 0", n)
+	}
+	if _, ok := st.inFlight.Current(); ok {
+		t.Fatal("guard still held after a stopped catch-up")
+	}
+}
@@ -0,0 +1,263 @@
+package twapagent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// logKey names a log on one chain: a reorg gives the log a new block hash,
+// so the replacement counts as new.
+type logKey struct {
+	BlockHash common.Hash `json:"blockHash"`
+	Index     uint        `json:"logIndex"`
+}
+
+// checkpointRecord is the --state-file's contents.
+type checkpointRecord struct {
+	ChainID uint64 `json:"chainId"`
+	// The last head bot mode handled.
+	Block uint64 `json:"block"`
+	// The logs it handled from Block on, which a restart's backfill fetches
+	// again.
+	Logs []logKey `json:"logs,omitempty"`
+	// Each vault's order status transitions.
+	Statuses map[common.Address][]statusTransition `json:"statuses,omitempty"`
+	// Each vault's executeSlice txs not yet seen mined.
+	Submitted map[common.Address][]submissionRecord `json:"submitted,omitempty"`
+	// Each vault's failure counters and circuit breaker.
+	Retries map[common.Address]*retryRecord `json:"retries,omitempty"`
+}
+
+// submissionRecord is a broadcast executeSlice tx as the state file keeps
+// it. Nonce is unknown for a relayer's tx.
+type submissionRecord struct {
+	Slice int64       `json:"slice"`
+	Hash  common.Hash `json:"hash"`
+	Nonce *uint64     `json:"nonce,omitempty"`
+	At    time.Time   `json:"at"`
+}
+
+// retryRecord is a failureTracker's state.
+type retryRecord struct {
+	Slices      []sliceFailureRecord `json:"slices,omitempty"`
+	Consecutive int                  `json:"consecutive,omitempty"`
+	TrippedAt   *time.Time           `json:"trippedAt,omitempty"`
+}
+
+type sliceFailureRecord struct {
+	Slice       int64     `json:"slice"`
+	Count       int       `json:"count"`
+	NextAttempt time.Time `json:"nextAttempt"`
+}
+
+// checkpoint is where bot mode got to: the last head it handled and the logs
+// it handled since, so a log the feed delivers twice (a backfill overlapping
+// the live stream) is only handled once. With a path it is kept in a JSON
+// file, so a restarted bot backfills the logs it missed while down, and
+// neither resends a tx still pending nor forgets the failures it counted.
+type checkpoint struct {
+	path    string
+	chainID uint64
+	block   uint64
+	// The block number of each log handled at or after block.
+	seen      map[logKey]uint64
+	statuses  map[common.Address][]statusTransition
+	submitted map[common.Address][]submissionRecord
+	retries   map[common.Address]*retryRecord
+	dirty     bool
+}
+
+// loadCheckpoint opens the checkpoint at path; a missing file starts empty.
+// An empty path keeps it in memory only.
+func loadCheckpoint(path string, chainID uint64) (*checkpoint, error) {
+	c := &checkpoint{
+		path:      path,
+		chainID:   chainID,
+		seen:      map[logKey]uint64{},
+		statuses:  map[common.Address][]statusTransition{},
+		submitted: map[common.Address][]submissionRecord{},
+		retries:   map[common.Address]*retryRecord{},
+	}
+	if path == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read state file: %w", err)
+	}
+	var rec checkpointRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("parse state file %s: %w", path, err)
+	}
+	if rec.ChainID != chainID {
+		return nil, fmt.Errorf("state file %s is for chain %d, not %d", path, rec.ChainID, chainID)
+	}
+	c.block = rec.Block
+	for _, k := range rec.Logs {
+		c.seen[k] = rec.Block
+	}
+	for addr, ts := range rec.Statuses {
+		c.statuses[addr] = ts
+	}
+	for addr, subs := range rec.Submitted {
+		c.submitted[addr] = subs
+	}
+	for addr, r := range rec.Retries {
+		c.retries[addr] = r
+	}
+	return c, nil
+}
+
+// resumeFrom is the block to backfill logs from, or 0 without a saved head.
+func (c *checkpoint) resumeFrom() uint64 {
+	return c.block
+}
+
+// handled reports whether lg was handled already.
+func (c *checkpoint) handled(lg types.Log) bool {
+	_, ok := c.seen[logKey{lg.BlockHash, lg.Index}]
+	return ok
+}
+
+// logHandled records lg, or forgets it when a reorg removed it.
+func (c *checkpoint) logHandled(lg types.Log) {
+	k := logKey{lg.BlockHash, lg.Index}
+	if lg.Removed {
+		delete(c.seen, k)
+	} else {
+		c.seen[k] = lg.BlockNumber
+	}
+	c.dirty = true
+}
+
+// transitions is addr's status history as last saved.
+func (c *checkpoint) transitions(addr common.Address) []statusTransition {
+	return c.statuses[addr]
+}
+
+// setTransitions records addr's status history, for the next save. The
+// history only grows, so a longer one is a change.
+func (c *checkpoint) setTransitions(addr common.Address, ts []statusTransition) {
+	if len(ts) != len(c.statuses[addr]) {
+		c.statuses[addr] = ts
+		c.dirty = true
+	}
+}
+
+// submissions is addr's pending txs as last saved.
+func (c *checkpoint) submissions(addr common.Address) []submissionRecord {
+	return c.submitted[addr]
+}
+
+// setSubmissions records addr's pending txs, for the next save.
+func (c *checkpoint) setSubmissions(addr common.Address, subs []submissionRecord) {
+	if !reflect.DeepEqual(subs, c.submitted[addr]) {
+		if len(subs) == 0 {
+			delete(c.submitted, addr)
+		} else {
+			c.submitted[addr] = subs
+		}
+		c.dirty = true
+	}
+}
+
+// retryState is addr's failure counters as last saved, nil if none.
+func (c *checkpoint) retryState(addr common.Address) *retryRecord {
+	return c.retries[addr]
+}
+
+// setRetryState records addr's failure counters, for the next save.
+func (c *checkpoint) setRetryState(addr common.Address, r *retryRecord) {
+	if !reflect.DeepEqual(r, c.retries[addr]) {
+		if r == nil {
+			delete(c.retries, addr)
+		} else {
+			c.retries[addr] = r
+		}
+		c.dirty = true
+	}
+}
+
+// forget drops what is recorded of addr, a vault bot mode no longer runs.
+func (c *checkpoint) forget(addr common.Address) {
+	_, st := c.statuses[addr]
+	_, sub := c.submitted[addr]
+	_, ret := c.retries[addr]
+	if st || sub || ret {
+		delete(c.statuses, addr)
+		delete(c.submitted, addr)
+		delete(c.retries, addr)
+		c.dirty = true
+	}
+}
+
+// flush saves what changed since the last head, for a shutdown.
+func (c *checkpoint) flush() error {
+	if !c.dirty {
+		return nil
+	}
+	c.dirty = false
+	return c.save()
+}
+
+// headHandled moves the checkpoint to head n and saves it. The logs before
+// n are forgotten, as a restart doesn't fetch them again.
+func (c *checkpoint) headHandled(n uint64) error {
+	if n < c.block || (n == c.block && !c.dirty) {
+		return nil
+	}
+	c.block, c.dirty = n, false
+	for k, b := range c.seen {
+		if b < n {
+			delete(c.seen, k)
+		}
+	}
+	return c.save()
+}
+
+func (c *checkpoint) save() error {
+	if c.path == "" {
+		return nil
+	}
+	rec := checkpointRecord{ChainID: c.chainID, Block: c.block}
+	if len(c.statuses) > 0 {
+		rec.Statuses = c.statuses
+	}
+	if len(c.submitted) > 0 {
+		rec.Submitted = c.submitted
+	}
+	if len(c.retries) > 0 {
+		rec.Retries = c.retries
+	}
+	for k := range c.seen {
+		rec.Logs = append(rec.Logs, k)
+	}
+	sort.Slice(rec.Logs, func(i, j int) bool {
+		a, b := rec.Logs[i], rec.Logs[j]
+		if a.BlockHash != b.BlockHash {
+			return a.BlockHash.Hex() < b.BlockHash.Hex()
+		}
+		return a.Index < b.Index
+	})
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write-then-rename, as for the receipts file.
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write state file: %w", err)
+	}
+	return os.Rename(tmp, c.path)
+}
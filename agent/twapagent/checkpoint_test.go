@@ -0,0 +1,149 @@
+package twapagent
+
+import (
+	"context"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	c, err := loadCheckpoint(path, 1)
+	if err != nil || c.resumeFrom() != 0 {
+		t.Fatalf("new checkpoint: from %d, err %v", c.resumeFrom(), err)
+	}
+	old := types.Log{BlockNumber: 99, BlockHash: common.HexToHash("0x99"), Index: 0}
+	lg := types.Log{BlockNumber: 100, BlockHash: common.HexToHash("0x100"), Index: 2}
+	c.logHandled(old)
+	c.logHandled(lg)
+	vault := common.HexToAddress("0xabc")
+	c.setTransitions(vault, []statusTransition{{To: "Open", Block: 90, Source: "read"}, {From: "Open", To: "PartialFilled", Block: 100, Source: "event"}})
+	if err := c.headHandled(100); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = loadCheckpoint(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.resumeFrom() != 100 {
+		t.Errorf("resumeFrom() = %d, want 100", c.resumeFrom())
+	}
+	if !c.handled(lg) {
+		t.Error("the log in the saved head wasn't kept")
+	}
+	if ts := c.transitions(vault); len(ts) != 2 || ts[1].To != "PartialFilled" || ts[1].Block != 100 {
+		t.Errorf("saved status transitions %+v", ts)
+	}
+	// Before the head, so the backfill doesn't fetch it again.
+	if c.handled(old) {
+		t.Error("the log before the saved head was kept")
+	}
+	// Same position in a block a reorg replaced.
+	if c.handled(types.Log{BlockNumber: 100, BlockHash: common.HexToHash("0xbeef"), Index: 2}) {
+		t.Error("a log in a different block counted as handled")
+	}
+
+	if _, err := loadCheckpoint(path, 5); err == nil || !strings.Contains(err.Error(), "chain 1") {
+		t.Errorf("loading another chain's state: %v", err)
+	}
+}
+
+// A removed log is forgotten, so its replacement is handled.
+func TestCheckpointRemovedLog(t *testing.T) {
+	c, _ := loadCheckpoint("", 1)
+	lg := types.Log{BlockNumber: 7, BlockHash: common.HexToHash("0x07"), Index: 1}
+	c.logHandled(lg)
+	lg.Removed = true
+	c.logHandled(lg)
+	lg.Removed = false
+	if c.handled(lg) {
+		t.Error("removed log still counted as handled")
+	}
+}
+
+// A restart reconciles the txs the last run left pending against the chain
+// and carries on with its failure counters.
+func TestResumeVault(t *testing.T) {
+	h := newExecuteHarness(t)
+	signer := newFakeSigner(t)
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	// Slice 1's tx mined while the agent was down.
+	auth, err := signer.TransactOpts(context.Background(), fakeChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mined, err := auth.Signer(auth.From, types.NewTx(&types.LegacyTx{Nonce: 0, To: &h.addr, Gas: 100_000, GasPrice: big.NewInt(1e9)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.eth.sent = append(h.eth.sent, mined)
+
+	st := h.state(t, signer)
+	v := &vaultBot{ctx: context.Background(), executor: *h.executor(signer, h.cfg, st)}
+	at := time.Now().Add(-time.Minute)
+	st.submitted.MarkTx(1, mined, at)
+	// Slice 2's tx is still pending: its nonce hasn't been reached.
+	pending := types.NewTx(&types.LegacyTx{Nonce: 5, To: &h.addr, Gas: 100_000, GasPrice: big.NewInt(1e9)})
+	st.submitted.MarkTx(2, pending, at)
+	// Slice 3's nonce was used by a replacement.
+	replaced := types.NewTx(&types.LegacyTx{Nonce: 0, To: &h.addr, Gas: 100_000, GasPrice: big.NewInt(2e9)})
+	st.submitted.MarkTx(3, replaced, at)
+	for i := 0; i < 2; i++ {
+		st.failures.RecordFailure(4, at)
+	}
+	st.failures.cfg.BreakerThreshold = 1
+	st.failures.RecordFailure(4, at)
+
+	cp, err := loadCheckpoint(path, fakeChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveVault(v, cp)
+	if err := cp.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next run.
+	cp, err = loadCheckpoint(path, fakeChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st = h.state(t, signer)
+	v = &vaultBot{ctx: context.Background(), executor: *h.executor(signer, h.cfg, st)}
+	resumeVault(v.ctx, v, signer.Address(), cp)
+
+	if sum := st.ledger.Summary(h.addr); sum.Slices != 1 {
+		t.Errorf("ledger slices = %d, want the tx mined while down", sum.Slices)
+	}
+	if sub, ok := st.submitted.Pending(2, 0, time.Now()); !ok || sub.Hash != pending.Hash() || !sub.At.Equal(at) {
+		t.Errorf("slice 2 pending = %+v, %v; want its tx from the last run", sub, ok)
+	}
+	if !st.submitted.Sent(2) {
+		t.Error("slice 2 must count as sent, so a retry resyncs the nonce")
+	}
+	for _, id := range []int64{1, 3} {
+		if _, ok := st.submitted.Pending(id, 0, time.Now()); ok {
+			t.Errorf("slice %d still pending", id)
+		}
+	}
+	if ok, reason := st.failures.Allow(4, time.Now()); ok || !strings.Contains(reason, "circuit breaker") {
+		t.Errorf("Allow(4) = %v %q, want the breaker still open", ok, reason)
+	}
+	st.failures.ResetBreaker()
+	if _, ok := st.failures.slices[4]; !ok || st.failures.slices[4].count != 3 {
+		t.Errorf("slice 4 failures = %+v, want 3", st.failures.slices[4])
+	}
+
+	saveVault(v, cp)
+	if subs := cp.submissions(h.addr); len(subs) != 1 || subs[0].Slice != 2 || subs[0].Nonce == nil || *subs[0].Nonce != 5 {
+		t.Errorf("saved submissions %+v, want slice 2's", subs)
+	}
+}
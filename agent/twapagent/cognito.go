@@ -0,0 +1,187 @@
+package twapagent
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Cognito's SRP group: the RFC 3526 3072-bit MODP prime with generator 2.
+const srpNHex = "" +
+	"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74" +
+	"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437" +
+	"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED" +
+	"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF05" +
+	"98DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB" +
+	"9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B" +
+	"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718" +
+	"3995497CEA956AE515D2261898FA051015728E5A8AAAC42DAD33170D04507A33" +
+	"A85521ABDF1CBA64ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7" +
+	"ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6BF12FFA06D98A0864" +
+	"D87602733EC86A64521F2B18177B200CBBE117577A615D6C770988C0BAD946E2" +
+	"08E24FA074E5AB3143DB5BFCE0FD108E4B82D120A93AD2CAFFFFFFFFFFFFFFFF"
+
+var (
+	srpN, _ = new(big.Int).SetString(srpNHex, 16)
+	srpG    = big.NewInt(2)
+	srpK    = new(big.Int).SetBytes(sha256Hex(srpPadHex(srpN) + srpPadHex(srpG)))
+)
+
+// cognitoLogin authenticates username/password against a Cognito user pool
+// with USER_SRP_AUTH (the flow the Defender API clients use) and returns the
+// access token.
+func cognitoLogin(ctx context.Context, hc *http.Client, poolID, clientID, username, password string) (string, error) {
+	region, poolName, ok := strings.Cut(poolID, "_")
+	if !ok {
+		return "", fmt.Errorf("invalid cognito pool id %q", poolID)
+	}
+	endpoint := "https://cognito-idp." + region + ".amazonaws.com/"
+
+	a, err := rand.Int(rand.Reader, srpN)
+	if err != nil {
+		return "", err
+	}
+	A := new(big.Int).Exp(srpG, a, srpN)
+
+	var challenge struct {
+		ChallengeName       string
+		ChallengeParameters map[string]string
+	}
+	err = cognitoCall(ctx, hc, endpoint, "InitiateAuth", map[string]interface{}{
+		"AuthFlow":       "USER_SRP_AUTH",
+		"ClientId":       clientID,
+		"AuthParameters": map[string]string{"USERNAME": username, "SRP_A": fmt.Sprintf("%x", A)},
+	}, &challenge)
+	if err != nil {
+		return "", err
+	}
+	if challenge.ChallengeName != "PASSWORD_VERIFIER" {
+		return "", fmt.Errorf("cognito: unexpected challenge %q", challenge.ChallengeName)
+	}
+	p := challenge.ChallengeParameters
+	B, ok1 := new(big.Int).SetString(p["SRP_B"], 16)
+	salt, ok2 := new(big.Int).SetString(p["SALT"], 16)
+	if !ok1 || !ok2 || new(big.Int).Mod(B, srpN).Sign() == 0 {
+		return "", errors.New("cognito: invalid SRP challenge")
+	}
+	userID := p["USER_ID_FOR_SRP"]
+	secretBlock, err := base64.StdEncoding.DecodeString(p["SECRET_BLOCK"])
+	if err != nil {
+		return "", fmt.Errorf("cognito: secret block: %w", err)
+	}
+
+	key := srpSessionKey(a, A, B, salt, poolName, userID, password)
+	timestamp := time.Now().UTC().Format("Mon Jan 2 15:04:05 UTC 2006")
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(poolName + userID))
+	mac.Write(secretBlock)
+	mac.Write([]byte(timestamp))
+
+	var result struct {
+		AuthenticationResult struct {
+			AccessToken string
+		}
+	}
+	err = cognitoCall(ctx, hc, endpoint, "RespondToAuthChallenge", map[string]interface{}{
+		"ChallengeName": "PASSWORD_VERIFIER",
+		"ClientId":      clientID,
+		"ChallengeResponses": map[string]string{
+			"USERNAME":                    userID,
+			"TIMESTAMP":                   timestamp,
+			"PASSWORD_CLAIM_SECRET_BLOCK": p["SECRET_BLOCK"],
+			"PASSWORD_CLAIM_SIGNATURE":    base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		},
+	}, &result)
+	if err != nil {
+		return "", err
+	}
+	if result.AuthenticationResult.AccessToken == "" {
+		return "", errors.New("cognito: no access token in response")
+	}
+	return result.AuthenticationResult.AccessToken, nil
+}
+
+// srpSessionKey derives the 16-byte password-claim key:
+// S = (B - k*g^x)^(a + u*x) mod N, then HKDF-SHA256 over S salted with u.
+func srpSessionKey(a, A, B, salt *big.Int, poolName, userID, password string) []byte {
+	u := new(big.Int).SetBytes(sha256Hex(srpPadHex(A) + srpPadHex(B)))
+	inner := sha256.Sum256([]byte(poolName + userID + ":" + password))
+	x := new(big.Int).SetBytes(sha256Hex(srpPadHex(salt) + hex.EncodeToString(inner[:])))
+
+	gx := new(big.Int).Exp(srpG, x, srpN)
+	base := new(big.Int).Sub(B, new(big.Int).Mul(srpK, gx))
+	base.Mod(base, srpN)
+	exp := new(big.Int).Add(a, new(big.Int).Mul(u, x))
+	S := new(big.Int).Exp(base, exp, srpN)
+
+	ikm, _ := hex.DecodeString(srpPadHex(S))
+	hsalt, _ := hex.DecodeString(srpPadHex(u))
+	prk := hmac.New(sha256.New, hsalt)
+	prk.Write(ikm)
+	info := hmac.New(sha256.New, prk.Sum(nil))
+	info.Write([]byte("Caldera Derived Key\x01"))
+	return info.Sum(nil)[:16]
+}
+
+// srpPadHex hex-encodes n the way the Cognito SDKs do: even length, with a
+// leading 00 when the top bit is set so the value reads as positive.
+func srpPadHex(n *big.Int) string {
+	h := fmt.Sprintf("%x", n)
+	if len(h)%2 == 1 {
+		return "0" + h
+	}
+	if strings.ContainsRune("89abcdef", rune(h[0])) {
+		return "00" + h
+	}
+	return h
+}
+
+func sha256Hex(h string) []byte {
+	b, _ := hex.DecodeString(h)
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+// cognitoCall performs an unauthenticated Cognito Identity Provider API call.
+func cognitoCall(ctx context.Context, hc *http.Client, endpoint, action string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AWSCognitoIdentityProviderService."+action)
+	resp, err := hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("cognito %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("cognito %s: %w", action, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(data, &e)
+		return fmt.Errorf("cognito %s: %s: %s %s", action, resp.Status, e.Type, e.Message)
+	}
+	return json.Unmarshal(data, out)
+}
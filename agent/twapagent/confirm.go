@@ -0,0 +1,81 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// confirmBuffer holds bot mode's contract logs until --confirmations heads
+// have built on their block, so a fill that a shallow reorg takes back is
+// never acted on. With a depth of 0 logs pass straight through.
+type confirmBuffer struct {
+	depth uint64
+	// In delivery order, so block order.
+	held []types.Log
+}
+
+// add takes a log from the feed and returns the logs to act on now. A log
+// removed by a reorg while held is dropped together with its original;
+// one already released is passed on for handleLog to undo.
+func (b *confirmBuffer) add(lg types.Log) []types.Log {
+	if b.depth == 0 {
+		return []types.Log{lg}
+	}
+	for i, h := range b.held {
+		if h.BlockHash == lg.BlockHash && h.Index == lg.Index {
+			if lg.Removed {
+				b.held = append(b.held[:i], b.held[i+1:]...)
+			}
+			return nil
+		}
+	}
+	if lg.Removed {
+		return []types.Log{lg}
+	}
+	b.held = append(b.held, lg)
+	return nil
+}
+
+// release returns the held logs with depth heads on top of them at head,
+// dropping those whose block is no longer canonical: a polled feed doesn't
+// report removed logs. When a block's hash can't be read, it and the logs
+// after it stay held for the next head.
+func (b *confirmBuffer) release(ctx context.Context, client *ethclient.Client, head uint64) ([]types.Log, error) {
+	var out []types.Log
+	canonical := map[uint64]bool{}
+	for len(b.held) > 0 {
+		lg := b.held[0]
+		if lg.BlockNumber+b.depth > head {
+			break
+		}
+		ok, seen := canonical[lg.BlockNumber]
+		if !seen {
+			hdr, err := headerByNumber(ctx, client, new(big.Int).SetUint64(lg.BlockNumber))
+			if err != nil {
+				return out, fmt.Errorf("confirm logs of block %d: %w", lg.BlockNumber, err)
+			}
+			ok = hdr.Hash() == lg.BlockHash
+			canonical[lg.BlockNumber] = ok
+		}
+		b.held = b.held[1:]
+		if !ok {
+			warnf(ctx, "dropping log %d in block %d (tx %s): the block was reorged out before %d confirmations", lg.Index, lg.BlockNumber, lg.TxHash.Hex(), b.depth)
+			continue
+		}
+		out = append(out, lg)
+	}
+	return out, nil
+}
+
+// final is the highest block whose logs have all been released at head, for
+// the checkpoint: a restart must fetch the held ones again.
+func (b *confirmBuffer) final(head uint64) uint64 {
+	if head < b.depth {
+		return 0
+	}
+	return head - b.depth
+}
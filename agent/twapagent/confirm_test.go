@@ -0,0 +1,80 @@
+package twapagent
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// pollHash is the hash of block n on a pollEth chain.
+func pollHash(n uint64) common.Hash {
+	return (&types.Header{Number: new(big.Int).SetUint64(n), Difficulty: new(big.Int), Time: 1000 + n}).Hash()
+}
+
+func TestConfirmBufferPassesThroughAtDepthZero(t *testing.T) {
+	b := &confirmBuffer{}
+	lg := types.Log{BlockNumber: 5}
+	if got := b.add(lg); len(got) != 1 || len(b.held) != 0 {
+		t.Errorf("add returned %d logs, held %d", len(got), len(b.held))
+	}
+}
+
+// Logs are held for depth heads, and dropped when reorged out meanwhile,
+// whether the feed says so or their block's hash changed.
+func TestConfirmBufferHoldsLogs(t *testing.T) {
+	eth := &pollEth{head: 13}
+	client := dialFakeEth(t, eth)
+	b := &confirmBuffer{depth: 2}
+	kept := types.Log{BlockNumber: 10, BlockHash: pollHash(10), Index: 0}
+	orphaned := types.Log{BlockNumber: 11, BlockHash: common.HexToHash("0xdead"), Index: 0}
+	removed := types.Log{BlockNumber: 12, BlockHash: pollHash(12), Index: 3}
+	for _, lg := range []types.Log{kept, kept, orphaned, removed} {
+		if got := b.add(lg); len(got) != 0 {
+			t.Fatalf("add(block %d) released %d logs", lg.BlockNumber, len(got))
+		}
+	}
+	removed.Removed = true
+	if got := b.add(removed); len(got) != 0 || len(b.held) != 2 {
+		t.Fatalf("removing a held log: released %d, held %d", len(got), len(b.held))
+	}
+
+	ctx := context.Background()
+	if got, err := b.release(ctx, client, 11); err != nil || len(got) != 0 {
+		t.Fatalf("release(11) = %d logs, %v; block 10 has 1 confirmation", len(got), err)
+	}
+	got, err := b.release(ctx, client, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].BlockNumber != 10 || len(b.held) != 0 {
+		t.Fatalf("release(13) = %+v, held %d; want block 10's log only", got, len(b.held))
+	}
+	if b.final(13) != 11 || b.final(1) != 0 {
+		t.Errorf("final(13) = %d, final(1) = %d", b.final(13), b.final(1))
+	}
+
+	// Released already: the removal goes on to be undone.
+	kept.Removed = true
+	if got := b.add(kept); len(got) != 1 {
+		t.Errorf("removal of a released log: %d logs", len(got))
+	}
+}
+
+func TestConfirmedReceipt(t *testing.T) {
+	eth := &pollEth{head: 103}
+	client := dialFakeEth(t, eth)
+	r := &types.Receipt{BlockNumber: big.NewInt(101)}
+	if !confirmed(context.Background(), client, r, 0) {
+		t.Error("depth 0 should be confirmed at once")
+	}
+	if confirmed(context.Background(), client, r, 3) {
+		t.Error("confirmed with 2 blocks on top, want 3")
+	}
+	eth.setHead(104)
+	if !confirmed(context.Background(), client, r, 3) {
+		t.Error("not confirmed with 3 blocks on top")
+	}
+}
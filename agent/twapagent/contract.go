@@ -0,0 +1,43 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// checkContract fails early when addr has no code, or has code that doesn't
+// answer status() the way a Twap vault does, instead of letting a wrong
+// --contract surface later as a string of unpack errors.
+func checkContract(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, chainID uint64) error {
+	code, err := client.CodeAt(ctx, addr, nil)
+	if err != nil {
+		return fmt.Errorf("code at %s: %w", addr.Hex(), err)
+	}
+	if len(code) == 0 {
+		return fmt.Errorf("no contract code at %s on chain %d", addr.Hex(), chainID)
+	}
+	data, err := cABI.Pack("status")
+	if err != nil {
+		return fmt.Errorf("pack status: %w", err)
+	}
+	res, err := client.CallContract(ctx, ethereum.CallMsg{To: &addr, Data: data}, nil)
+	if err != nil {
+		// Only a node-side failure says something about the contract.
+		var rpcErr rpc.Error
+		if !errors.As(err, &rpcErr) {
+			return fmt.Errorf("probe status(): %w", err)
+		}
+		return fmt.Errorf("%s has code but does not look like a Twap contract: status() failed: %v", addr.Hex(), err)
+	}
+	if _, err := cABI.Unpack("status", res); err != nil {
+		return fmt.Errorf("%s has code but does not look like a Twap contract: status() returned %d bytes", addr.Hex(), len(res))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const statusTestABI = `[{"type":"function","name":"status","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint8"}]}]`
+
+// probeEth answers the calls checkContract makes.
+type probeEth struct {
+	code   hexutil.Bytes
+	status hexutil.Bytes
+	revert bool
+}
+
+func (f *probeEth) ChainId() *hexutil.Big { return (*hexutil.Big)(common.Big1) }
+
+func (f *probeEth) GetCode(common.Address, string) hexutil.Bytes { return f.code }
+
+func (f *probeEth) Call(fakeCallArgs, string) (hexutil.Bytes, error) {
+	if f.revert {
+		return nil, errors.New("execution reverted")
+	}
+	return f.status, nil
+}
+
+func TestCheckContract(t *testing.T) {
+	cABI := mustABI(t, statusTestABI)
+	addr := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	for _, tc := range []struct {
+		name string
+		eth  *probeEth
+		want string
+	}{
+		{"twap", &probeEth{code: hexutil.Bytes{0x60}, status: make(hexutil.Bytes, 32)}, ""},
+		{"no code", &probeEth{}, "no contract code at " + addr.Hex() + " on chain 1"},
+		{"reverts", &probeEth{code: hexutil.Bytes{0x60}, revert: true}, "does not look like a Twap contract"},
+		{"no return data", &probeEth{code: hexutil.Bytes{0x60}}, "does not look like a Twap contract"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkContract(context.Background(), addr, cABI, dialFakeEth(t, tc.eth), 1)
+			switch {
+			case tc.want == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+				t.Fatalf("err = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
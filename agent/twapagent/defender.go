@@ -0,0 +1,294 @@
+package twapagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// Defender Relay API endpoint and the Cognito pool its API keys live in.
+const (
+	defenderRelayURL      = "https://api.defender.openzeppelin.com"
+	defenderRelayPoolID   = "us-west-2_iLmIggsiy"
+	defenderRelayClientID = "1bpd19lcr33qvg5cr3oi79rdap"
+	// Access tokens last an hour; log in again a little before that.
+	defenderTokenTTL = 50 * time.Minute
+)
+
+// errRelayRejected marks a request the relayer refused (insufficient funds,
+// policy, bad request), as opposed to a network failure.
+var errRelayRejected = errors.New("relayer rejected request")
+
+// defenderRelay submits transactions through an OpenZeppelin Defender
+// Relayer, which holds the key and signs and prices the tx itself.
+type defenderRelay struct {
+	apiKey, apiSecret string
+	http              *http.Client
+	address           common.Address
+
+	mu      sync.Mutex
+	token   string
+	tokenAt time.Time
+}
+
+// relayTx is the subset of the Relay API's transaction object we use.
+type relayTx struct {
+	TransactionID string      `json:"transactionId"`
+	Hash          common.Hash `json:"hash"`
+	Status        string      `json:"status"`
+	Nonce         uint64      `json:"nonce"`
+}
+
+// newDefenderRelay logs in and looks up the relayer's address.
+func newDefenderRelay(ctx context.Context, apiKey, apiSecret string) (*defenderRelay, error) {
+	if apiKey == "" || apiSecret == "" {
+		return nil, errors.New("--defender-api-key and --defender-api-secret must be set together")
+	}
+	r := &defenderRelay{apiKey: apiKey, apiSecret: apiSecret, http: &http.Client{Timeout: 30 * time.Second}}
+	var info struct {
+		Address common.Address `json:"address"`
+	}
+	if err := r.do(ctx, http.MethodGet, "/relayer", nil, &info); err != nil {
+		return nil, err
+	}
+	r.address = info.Address
+	return r, nil
+}
+
+func (r *defenderRelay) Address() common.Address { return r.address }
+
+func (r *defenderRelay) accessToken(ctx context.Context, refresh bool) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !refresh && r.token != "" && time.Since(r.tokenAt) < defenderTokenTTL {
+		return r.token, nil
+	}
+	tok, err := cognitoLogin(ctx, r.http, defenderRelayPoolID, defenderRelayClientID, r.apiKey, r.apiSecret)
+	if err != nil {
+		return "", fmt.Errorf("defender login: %w", err)
+	}
+	r.token, r.tokenAt = tok, time.Now()
+	return tok, nil
+}
+
+// do calls the Relay API, logging in again once if the token was rejected.
+func (r *defenderRelay) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		tok, err := r.accessToken(ctx, attempt > 0)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, method, defenderRelayURL+path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Api-Key", r.apiKey)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := r.http.Do(req)
+		if err != nil {
+			return fmt.Errorf("defender %s %s: %w", method, path, err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("defender %s %s: %w", method, path, err)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			continue
+		}
+		switch {
+		case resp.StatusCode >= 400 && resp.StatusCode < 500:
+			return fmt.Errorf("%w: %s: %s", errRelayRejected, resp.Status, relayErrorText(data))
+		case resp.StatusCode/100 != 2:
+			return fmt.Errorf("defender %s %s: %s: %s", method, path, resp.Status, relayErrorText(data))
+		}
+		if out == nil {
+			return nil
+		}
+		return json.Unmarshal(data, out)
+	}
+}
+
+// relayErrorText pulls the message out of a Relay API error body.
+func relayErrorText(data []byte) string {
+	var e struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal(data, &e) == nil {
+		if e.Message != "" {
+			return e.Message
+		}
+		if e.Error != "" {
+			return e.Error
+		}
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Send asks the relayer to sign and submit a call to `to`.
+func (r *defenderRelay) Send(ctx context.Context, to common.Address, data []byte, gasLimit uint64, validFor time.Duration) (relayTx, error) {
+	req := map[string]interface{}{
+		"to":       to.Hex(),
+		"data":     fmt.Sprintf("0x%x", data),
+		"value":    "0",
+		"gasLimit": gasLimit,
+		"speed":    "fast",
+	}
+	if validFor > 0 {
+		req["validUntil"] = time.Now().Add(validFor).UTC().Format(time.RFC3339)
+	}
+	var out relayTx
+	err := r.do(ctx, http.MethodPost, "/txs", req, &out)
+	return out, err
+}
+
+// Tx fetches the current state of a relayed transaction. The hash changes
+// when the relayer resubmits with a higher fee.
+func (r *defenderRelay) Tx(ctx context.Context, id string) (relayTx, error) {
+	var out relayTx
+	err := r.do(ctx, http.MethodGet, "/txs/"+id, nil, &out)
+	return out, err
+}
+
+// executeViaRelay is execute() for the Defender backend: the relayer signs,
+// prices and resubmits, the bot only decides when and with what gas limit.
+func executeViaRelay(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, txCfg TxConfig, st *botState, sliceId int64, overdue int64) {
+	relay := st.sender.relay
+	from := relay.Address()
+	if !txCfg.SkipSimulation {
+		if err := simulateSlice(ctx, addr, cABI, client, from, sliceId, overdue < 0); err != nil {
+			logSkippedSlice(ctx, sliceId, overdue, err)
+			return
+		}
+	}
+	if !priceGate(ctx, addr, cABI, client, txCfg, st, sliceId) {
+		return
+	}
+	// The relayer picks the fees, but the operator's ceiling still applies
+	quote, err := quoteGas(ctx, st.txClient, txCfg)
+	if err != nil && txCfg.hasCeiling() && overdue <= int64(txCfg.CeilingGrace) {
+		logf(ctx, "deferring slice %d, gas ceiling can't be checked: %v", sliceId, err)
+		return
+	} else if err != nil {
+		logf(ctx, "gas pricing error (ceiling not checked): %v", err)
+	} else if price, ceiling, over := txCfg.checkCeiling(quote); over {
+		if overdue <= int64(txCfg.CeilingGrace) {
+			logf(ctx, "deferring slice %d, gas too high: %s wei > ceiling %s wei", sliceId, price, ceiling)
+			return
+		}
+		logf(ctx, "slice %d overdue by %ds, ignoring gas ceiling %s wei (current %s wei)", sliceId, overdue, ceiling, price)
+	}
+	data, err := cABI.Pack("executeSlice", big.NewInt(sliceId))
+	if err != nil {
+		logf(ctx, "pack executeSlice: %v", err)
+		return
+	}
+	gasLimit := txCfg.GasLimit
+	if gasLimit == 0 {
+		est, err := estimateGas(ctx, st.txClient, ethereum.CallMsg{From: from, To: &addr, Data: data})
+		if err != nil {
+			logf(ctx, "executeSlice(%d) error: estimate gas: %v", sliceId, err)
+			return
+		}
+		gasLimit = est * (100 + txCfg.GasBufferPercent) / 100
+	}
+	// The relayer charges its own account; don't queue what it can't pay for
+	if st.balance != nil {
+		if err := st.balance.Afford(ctx, st.txClient, gasLimit, quote.maxPrice()); err != nil {
+			logf(ctx, "not submitting slice %d: %v", sliceId, err)
+			emitEvent(ctx, evDecision, 0, map[string]interface{}{"slice": sliceId, "action": "unfunded", "reason": err.Error()})
+			return
+		}
+	}
+	if done, err := readSliceDonePending(ctx, addr, cABI, client, big.NewInt(sliceId)); err != nil {
+		logf(ctx, "pending sliceDone(%d) check failed, submitting anyway: %v", sliceId, err)
+	} else if done {
+		n := st.avoided.Add(1)
+		logf(ctx, "slice %d already executed by someone else, not submitting (%d submissions avoided)", sliceId, n)
+		return
+	}
+
+	rtx, err := relay.Send(ctx, addr, data, gasLimit, txCfg.TxDeadline)
+	if err != nil {
+		logf(ctx, "relay executeSlice(%d): %v", sliceId, err)
+		recordSliceFailure(ctx, st, sliceId)
+		return
+	}
+	logf(ctx, "Submitted relay tx %s (%s) for slice %d, gasLimit=%d", rtx.TransactionID, rtx.Hash.Hex(), sliceId, gasLimit)
+	emitEvent(ctx, evTxSubmitted, 0, map[string]interface{}{"slice": sliceId, "tx": rtx.Hash.Hex(), "relayId": rtx.TransactionID})
+	st.submitted.Mark(sliceId, rtx.Hash, time.Now())
+
+	waitCtx := ctx
+	if txCfg.WaitTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, txCfg.WaitTimeout)
+		defer cancel()
+	}
+	poll := txCfg.ReceiptPollInterval
+	if poll < time.Second {
+		poll = time.Second // the Relay API is rate limited
+	}
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				logf(ctx, "stopped waiting for relay tx %s (slice %d): %v", rtx.TransactionID, sliceId, errShutdown)
+			} else {
+				logf(ctx, "relay tx %s for slice %d not mined after %s, moving on", rtx.TransactionID, sliceId, txCfg.WaitTimeout)
+			}
+			return
+		case <-ticker.C:
+		}
+		cur, err := relay.Tx(waitCtx, rtx.TransactionID)
+		if err != nil {
+			logf(ctx, "relay tx %s status: %v", rtx.TransactionID, err)
+			continue
+		}
+		switch cur.Status {
+		case "mined", "confirmed":
+			var receipt *types.Receipt
+			err := rpcRead(waitCtx, "eth_getTransactionReceipt", func(ctx context.Context) (err error) {
+				receipt, err = client.TransactionReceipt(ctx, cur.Hash)
+				return err
+			})
+			if err != nil {
+				logf(ctx, "receipt for relay tx %s (%s): %v", rtx.TransactionID, cur.Hash.Hex(), err)
+				continue
+			}
+			st.submitted.Clear(sliceId)
+			finishSlice(ctx, addr, from, st, sliceId, receipt, nil)
+			return
+		case "failed":
+			logf(ctx, "relay tx %s for slice %d failed (last hash %s)", rtx.TransactionID, sliceId, cur.Hash.Hex())
+			emitTxFailed(ctx, sliceId, cur.Hash, "relay failed")
+			st.submitted.Clear(sliceId)
+			recordSliceFailure(ctx, st, sliceId)
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package twapagent
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+// The client's session key must match what a server holding only the
+// password verifier derives: S = (A * v^u)^b mod N.
+func TestSRPSessionKeyMatchesServer(t *testing.T) {
+	const pool, user, password = "iLmIggsiy", "user-id", "hunter2"
+	salt := big.NewInt(0x5eed)
+	inner := sha256.Sum256([]byte(pool + user + ":" + password))
+	x := new(big.Int).SetBytes(sha256Hex(srpPadHex(salt) + hex.EncodeToString(inner[:])))
+	v := new(big.Int).Exp(srpG, x, srpN)
+
+	a, _ := rand.Int(rand.Reader, srpN)
+	b, _ := rand.Int(rand.Reader, srpN)
+	A := new(big.Int).Exp(srpG, a, srpN)
+	B := new(big.Int).Add(new(big.Int).Mul(srpK, v), new(big.Int).Exp(srpG, b, srpN))
+	B.Mod(B, srpN)
+
+	u := new(big.Int).SetBytes(sha256Hex(srpPadHex(A) + srpPadHex(B)))
+	S := new(big.Int).Mul(A, new(big.Int).Exp(v, u, srpN))
+	S.Exp(S.Mod(S, srpN), b, srpN)
+	ikm, _ := hex.DecodeString(srpPadHex(S))
+	hsalt, _ := hex.DecodeString(srpPadHex(u))
+	prk := hmac.New(sha256.New, hsalt)
+	prk.Write(ikm)
+	okm := hmac.New(sha256.New, prk.Sum(nil))
+	okm.Write([]byte("Caldera Derived Key\x01"))
+	want := okm.Sum(nil)[:16]
+
+	if got := srpSessionKey(a, A, B, salt, pool, user, password); !bytes.Equal(got, want) {
+		t.Fatalf("session key %x, server derived %x", got, want)
+	}
+	if got := srpSessionKey(a, A, B, salt, pool, user, "wrong"); bytes.Equal(got, want) {
+		t.Fatal("wrong password produced the server's key")
+	}
+}
+
+func TestSRPPadHex(t *testing.T) {
+	for _, tc := range []struct {
+		n    int64
+		want string
+	}{
+		{0x1, "01"},
+		{0x7f, "7f"},
+		{0x80, "0080"},
+		{0xabc, "0abc"},
+	} {
+		if got := srpPadHex(big.NewInt(tc.n)); got != tc.want {
+			t.Errorf("srpPadHex(%#x) = %q, want %q", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestRelayErrorText(t *testing.T) {
+	for body, want := range map[string]string{
+		`{"message":"Insufficient funds for gasLimit * price"}`: "Insufficient funds for gasLimit * price",
+		`{"error":"Relayer policy: address not whitelisted"}`:   "Relayer policy: address not whitelisted",
+		"Bad Gateway\n": "Bad Gateway",
+	} {
+		if got := relayErrorText([]byte(body)); got != want {
+			t.Errorf("relayErrorText(%s) = %q, want %q", body, got, want)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package twapagent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"twap-agent/twapbind"
+)
+
+// DeployConfig collects deploy mode's flags.
+type DeployConfig struct {
+	// Foundry artifact holding the Twap ABI and creation bytecode.
+	Artifact                                  string
+	TokenIn, TokenOut, Adapter, Oracle, Agent string
+	TotalAmount, SliceAmount                  *big.Int
+	// RFC3339 or unix seconds.
+	Start, End                      string
+	MaxSlippageBps, MaxDeviationBps uint
+	// Fund the new vault with deposit mode once it is configured.
+	AndDeposit bool
+	// Don't ask for confirmation before sending.
+	Yes bool
+}
+
+// parseTime reads unix seconds or an RFC3339 time.
+func parseTime(v string) (*big.Int, error) {
+	if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+		return new(big.Int).SetUint64(n), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither unix seconds nor RFC3339", v)
+	}
+	if t.Unix() < 0 {
+		return nil, fmt.Errorf("%q is before 1970", v)
+	}
+	return big.NewInt(t.Unix()), nil
+}
+
+// parse builds the strategy from the flags, checking only that each one is
+// well-formed; strategyRules judges the values.
+func (c DeployConfig) parse() (Strategy, error) {
+	var s Strategy
+	for _, a := range []struct {
+		flag, v string
+		dst     *common.Address
+	}{{"--token-in", c.TokenIn, &s.TokenIn}, {"--token-out", c.TokenOut, &s.TokenOut}, {"--adapter", c.Adapter, &s.Adapter}, {"--oracle", c.Oracle, &s.PriceOracle}} {
+		if !common.IsHexAddress(a.v) {
+			return s, fmt.Errorf("%s must be an address, got %q", a.flag, a.v)
+		}
+		*a.dst = common.HexToAddress(a.v)
+	}
+	if c.TotalAmount == nil || c.SliceAmount == nil {
+		return s, errors.New("--total-amount and --slice-amount are required")
+	}
+	s.TotalAmountIn, s.SliceAmountIn = c.TotalAmount, c.SliceAmount
+	var err error
+	if s.StartTime, err = parseTime(c.Start); err != nil {
+		return s, fmt.Errorf("--start: %w", err)
+	}
+	if s.EndTime, err = parseTime(c.End); err != nil {
+		return s, fmt.Errorf("--end: %w", err)
+	}
+	if c.MaxSlippageBps > math.MaxUint16 || c.MaxDeviationBps > math.MaxUint16 {
+		return s, errors.New("--max-slippage-bps and --max-deviation-bps must fit in 16 bits")
+	}
+	s.MaxSlippageBps, s.MaxPriceDeviationBps = uint16(c.MaxSlippageBps), uint16(c.MaxDeviationBps)
+	return s, nil
+}
+
+// agent is --agent, or the zero address when unset.
+func (c DeployConfig) agent() common.Address {
+	if c.Agent == "" {
+		return common.Address{}
+	}
+	return common.HexToAddress(c.Agent)
+}
+
+// strategy builds the strategy from the flags and checks it locally against
+// configureStrategy's requirements, with now as the latest block time.
+func (c DeployConfig) strategy(now uint64) (Strategy, error) {
+	s, err := c.parse()
+	if err != nil {
+		return s, err
+	}
+	if f := strategyRules(s, now, false, c.agent()).firstError(); f != nil {
+		return s, fmt.Errorf("%s: %s", f.Check, f.Detail)
+	}
+	return s, nil
+}
+
+// describeStrategy prints what the vault will derive from s, for the
+// operator to check before anything is sent.
+func describeStrategy(w io.Writer, s Strategy) {
+	N := sliceTotal(s)
+	interval := new(big.Int).Sub(s.EndTime, s.StartTime)
+	interval.Div(interval, N)
+	fmt.Fprintf(w, "Strategy:\n")
+	fmt.Fprintf(w, "- tokenIn -> tokenOut: %s -> %s\n", s.TokenIn.Hex(), s.TokenOut.Hex())
+	fmt.Fprintf(w, "- adapter / oracle: %s / %s\n", s.Adapter.Hex(), s.PriceOracle.Hex())
+	fmt.Fprintf(w, "- totalAmountIn: %s in slices of %s\n", s.TotalAmountIn, s.SliceAmountIn)
+	fmt.Fprintf(w, "- window: %s -> %s\n", time.Unix(s.StartTime.Int64(), 0).UTC().Format(time.RFC3339), time.Unix(s.EndTime.Int64(), 0).UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "- totalSlices: %s, one every %ss\n", N, interval)
+	if rem := new(big.Int).Mod(s.TotalAmountIn, s.SliceAmountIn); rem.Sign() > 0 {
+		fmt.Fprintf(w, "- note: the slice amount doesn't divide the total; the last slice swaps %s\n", rem)
+	}
+	if interval.Sign() == 0 {
+		fmt.Fprintf(w, "- note: the window is shorter than the slice count; all slices are due at the start\n")
+	}
+	fmt.Fprintf(w, "- maxSlippageBps: %d, maxPriceDeviationBps: %d\n", s.MaxSlippageBps, s.MaxPriceDeviationBps)
+}
+
+// readArtifact loads the ABI and creation bytecode from a Foundry artifact.
+func readArtifact(path string) (abi.ABI, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return abi.ABI{}, nil, fmt.Errorf("read artifact: %w (build it with `forge build`)", err)
+	}
+	var artifact struct {
+		ABI      json.RawMessage `json:"abi"`
+		Bytecode struct {
+			Object string `json:"object"`
+		} `json:"bytecode"`
+	}
+	if err := json.Unmarshal(data, &artifact); err != nil {
+		return abi.ABI{}, nil, fmt.Errorf("unmarshal artifact %s: %w", path, err)
+	}
+	parsed, err := abi.JSON(strings.NewReader(string(artifact.ABI)))
+	if err != nil {
+		return abi.ABI{}, nil, fmt.Errorf("parse abi from %s: %w", path, err)
+	}
+	if err := checkTwapABI(parsed); err != nil {
+		return abi.ABI{}, nil, fmt.Errorf("abi from %s: %w", path, err)
+	}
+	code, err := hexutil.Decode(artifact.Bytecode.Object)
+	if err != nil || len(code) == 0 {
+		return abi.ABI{}, nil, fmt.Errorf("artifact %s has no creation bytecode", path)
+	}
+	return parsed, code, nil
+}
+
+// confirm asks a yes/no question on in.
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	line, _ := bufio.NewReader(in).ReadString('\n')
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
+
+// deployVault is deploy mode. It deploys a Twap owned by the signer, sets its
+// agent, and configures the strategy the way script/Deploy.s.sol does (pause,
+// configureStrategy, unpause), waiting for each receipt. With AndDeposit it
+// then funds the vault like deposit mode.
+func (a *Agent) deployVault(ctx context.Context) (common.Address, error) {
+	parsed, code, err := readArtifact(a.cfg.Deploy.Artifact)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return a.deployTwap(ctx, parsed, code)
+}
+
+// deployTwap is deployVault with the ABI and creation bytecode already read.
+func (a *Agent) deployTwap(ctx context.Context, parsed abi.ABI, code []byte) (common.Address, error) {
+	cfg, signer := a.cfg.Deploy, a.signers[0]
+	if signer == nil {
+		return common.Address{}, errors.New("deploy mode needs the key that will own the vault")
+	}
+	if cfg.Agent != "" && !common.IsHexAddress(cfg.Agent) {
+		return common.Address{}, fmt.Errorf("invalid --agent address %q", cfg.Agent)
+	}
+	head, err := headerByNumber(ctx, a.client, nil)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("header: %w", err)
+	}
+	s, err := cfg.strategy(head.Time)
+	if err != nil {
+		return common.Address{}, err
+	}
+	owner := signer.Address()
+	describeStrategy(outputFrom(ctx), s)
+	fmt.Fprintf(outputFrom(ctx), "- owner: %s\n", owner.Hex())
+	if cfg.Agent != "" {
+		fmt.Fprintf(outputFrom(ctx), "- agent: %s\n", common.HexToAddress(cfg.Agent).Hex())
+	} else {
+		fmt.Fprintf(outputFrom(ctx), "- agent: not set (pass --agent, or call setAgent later)\n")
+	}
+	if !cfg.Yes && !confirm(os.Stdin, outputFrom(ctx), fmt.Sprintf("Deploy and configure on chain %d?", a.chainID)) {
+		return common.Address{}, errors.New("deploy not confirmed (pass --yes to skip the prompt)")
+	}
+
+	auth, err := signer.TransactOpts(ctx, a.chainID)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("transactor: %w", err)
+	}
+	quote, err := quoteGas(ctx, a.txClient, a.cfg.Tx)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("pricing: %w", err)
+	}
+	quote.apply(auth)
+	addr, tx, _, err := bind.DeployContract(auth, parsed, code, a.txClient, owner)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("deploy: %w", err)
+	}
+	fmt.Fprintf(outputFrom(ctx), "Submitted deployment tx %s\n", tx.Hash().Hex())
+	waitCtx := ctx
+	if a.cfg.Tx.WaitTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, a.cfg.Tx.WaitTimeout)
+		defer cancel()
+	}
+	if _, err := bind.WaitDeployed(waitCtx, a.txClient, tx); err != nil {
+		return common.Address{}, fmt.Errorf("wait for deployment %s: %w", tx.Hash().Hex(), err)
+	}
+	fmt.Fprintf(outputFrom(ctx), "Deployed Twap at %s\n", addr.Hex())
+
+	// The new vault's executor, for the owner's calls to it.
+	e := &executor{addr: addr, cABI: parsed, twap: twapbind.NewTwap(addr, parsed, a.client, a.txClient, a.client), client: a.client,
+		signer: signer, chainID: a.chainID, txCfg: a.cfg.Tx, st: &botState{txClient: a.txClient}}
+	steps := []struct {
+		method string
+		args   []interface{}
+		send   func(*bind.TransactOpts) (*types.Transaction, error)
+	}{
+		{"pause", nil, e.twap.Pause},
+		{"configureStrategy", []interface{}{s}, func(o *bind.TransactOpts) (*types.Transaction, error) { return e.twap.ConfigureStrategy(o, s) }},
+		{"unpause", nil, e.twap.Unpause},
+	}
+	if cfg.Agent != "" {
+		agent := common.HexToAddress(cfg.Agent)
+		steps = append([]struct {
+			method string
+			args   []interface{}
+			send   func(*bind.TransactOpts) (*types.Transaction, error)
+		}{{"setAgent", []interface{}{agent}, func(o *bind.TransactOpts) (*types.Transaction, error) { return e.twap.SetAgent(o, agent) }}}, steps...)
+	}
+	for _, step := range steps {
+		data, err := parsed.Pack(step.method, step.args...)
+		if err != nil {
+			return addr, fmt.Errorf("pack %s: %w", step.method, err)
+		}
+		if _, err := e.sendOwnerTx(ctx, addr, parsed, step.method, data, step.send); err != nil {
+			return addr, fmt.Errorf("vault deployed at %s but not configured: %w", addr.Hex(), err)
+		}
+	}
+	fmt.Fprintf(outputFrom(ctx), "Vault %s is configured\n", addr.Hex())
+	if cfg.AndDeposit {
+		if err := e.deposit(ctx); err != nil && !errors.Is(err, errAlreadyFunded) {
+			return addr, fmt.Errorf("deposit: %w", err)
+		}
+	}
+	return addr, nil
+}
@@ -0,0 +1,93 @@
+package twapagent
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestParseTime(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"1700000000", 1700000000, true},
+		{"2023-11-14T22:13:20Z", 1700000000, true},
+		{"2023-11-15T00:13:20+02:00", 1700000000, true},
+		{"1969-12-31T23:59:59Z", 0, false},
+		{"tomorrow", 0, false},
+		{"", 0, false},
+	} {
+		got, err := parseTime(tc.in)
+		if (err == nil) != tc.ok {
+			t.Errorf("%q: err=%v, want ok=%v", tc.in, err, tc.ok)
+			continue
+		}
+		if tc.ok && got.Int64() != tc.want {
+			t.Errorf("%q: got %s, want %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+func validDeployConfig() DeployConfig {
+	return DeployConfig{
+		TokenIn:         "0x00000000000000000000000000000000000000a1",
+		TokenOut:        "0x00000000000000000000000000000000000000a2",
+		Adapter:         "0x00000000000000000000000000000000000000a3",
+		Oracle:          "0x00000000000000000000000000000000000000a4",
+		TotalAmount:     big.NewInt(1000),
+		SliceAmount:     big.NewInt(100),
+		Start:           "2000",
+		End:             "3000",
+		MaxSlippageBps:  100,
+		MaxDeviationBps: 250,
+	}
+}
+
+func TestDeployConfigStrategy(t *testing.T) {
+	s, err := validDeployConfig().strategy(1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.StartTime.Int64() != 2000 || s.EndTime.Int64() != 3000 || s.MaxSlippageBps != 100 || s.MaxPriceDeviationBps != 250 {
+		t.Errorf("unexpected strategy %+v", s)
+	}
+
+	for name, mutate := range map[string]func(*DeployConfig){
+		"zero token":       func(c *DeployConfig) { c.TokenIn = "0x0000000000000000000000000000000000000000" },
+		"bad address":      func(c *DeployConfig) { c.Oracle = "oracle" },
+		"same tokens":      func(c *DeployConfig) { c.TokenOut = c.TokenIn },
+		"agent is adapter": func(c *DeployConfig) { c.Agent = c.Adapter },
+		"no total":         func(c *DeployConfig) { c.TotalAmount = nil },
+		"zero slice":       func(c *DeployConfig) { c.SliceAmount = big.NewInt(0) },
+		"slice over total": func(c *DeployConfig) { c.SliceAmount = big.NewInt(1001) },
+		"end before start": func(c *DeployConfig) { c.End = "2000" },
+		"start passed":     func(c *DeployConfig) { c.Start = "1500" },
+		"slippage bound":   func(c *DeployConfig) { c.MaxSlippageBps = SlippageBpsLimit + 1 },
+		"deviation bound":  func(c *DeployConfig) { c.MaxDeviationBps = DeviationBpsLimit + 1 },
+	} {
+		c := validDeployConfig()
+		mutate(&c)
+		if _, err := c.strategy(1500); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestDescribeStrategy(t *testing.T) {
+	c := validDeployConfig()
+	c.SliceAmount = big.NewInt(300)
+	s, err := c.strategy(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	describeStrategy(&out, s)
+	for _, want := range []string{"totalSlices: 4, one every 250s", "the last slice swaps 100"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+}
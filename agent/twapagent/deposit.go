@@ -0,0 +1,131 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// errAlreadyFunded is returned by deposit mode when the vault holds enough
+// tokenIn for the rest of the order.
+var errAlreadyFunded = errors.New("the vault already holds enough tokenIn for the order")
+
+// depositShortfall is how much tokenIn the vault still needs: what the order
+// has left to fill, less what it already holds. Zero or less means funded.
+func depositShortfall(s Strategy, filled, vaultBalance *big.Int) *big.Int {
+	need := new(big.Int).Sub(s.TotalAmountIn, filled)
+	return need.Sub(need, vaultBalance)
+}
+
+// vaultFunding compares the vault's tokenIn balance with what the rest of
+// the order will swap. The vault approves the adapter for each slice as it
+// executes it, so the balance is all a slice needs.
+type vaultFunding struct {
+	Balance   *big.Int
+	Remaining *big.Int // totalAmountIn - filledAmountIn
+	Shortfall *big.Int // zero when funded
+}
+
+// readVaultFunding reads the vault's tokenIn balance and checks it against
+// the rest of the order.
+func readVaultFunding(ctx context.Context, addr common.Address, client *ethclient.Client, s Strategy, filled *big.Int) (vaultFunding, error) {
+	held, err := readTokenBalance(ctx, client, s.TokenIn, addr)
+	if err != nil {
+		return vaultFunding{}, err
+	}
+	f := vaultFunding{Balance: held, Remaining: new(big.Int).Sub(s.TotalAmountIn, filled), Shortfall: depositShortfall(s, filled, held)}
+	if f.Shortfall.Sign() < 0 {
+		f.Shortfall.SetInt64(0)
+	}
+	return f, nil
+}
+
+// warnUnderfunded logs a warning at bot startup when the vault holds less
+// tokenIn than the rest of the order swaps: the last slices would revert.
+func warnUnderfunded(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, s Strategy) {
+	filled, err := readFilled(ctx, addr, cABI, client)
+	if err != nil {
+		logf(ctx, "read filled: %v", err)
+		return
+	}
+	f, err := readVaultFunding(ctx, addr, client, s, filled)
+	if err != nil {
+		logf(ctx, "vault tokenIn balance: %v", err)
+		return
+	}
+	if f.Shortfall.Sign() > 0 {
+		a := orderAmountsFor(ctx, s)
+		warnf(ctx, "the vault holds %s tokenIn but the order has %s left to swap, short by %s; later slices will revert until it is topped up (deposit mode)", a.In(f.Balance), a.In(f.Remaining), a.In(f.Shortfall))
+	}
+}
+
+// deposit is deposit mode: it funds the configured order by transferring the
+// missing tokenIn from the key's account to the vault. The vault has no
+// deposit function and never pulls tokens from the owner (slices swap what it
+// holds), so a plain transfer is all it takes and no approval is needed.
+// Running it again once the vault is funded does nothing.
+func (e *executor) deposit(ctx context.Context) error {
+	if e.signer == nil {
+		return errors.New("deposit mode needs the key holding tokenIn")
+	}
+	s, err := readStrategy(ctx, e.addr, e.cABI, e.client)
+	if err != nil {
+		return fmt.Errorf("read strategy: %w", err)
+	}
+	if s.TotalAmountIn == nil || s.TotalAmountIn.Sign() == 0 {
+		return errNotInitialized
+	}
+	status, err := readStatus(ctx, e.addr, e.cABI, e.client)
+	if err != nil {
+		return err
+	}
+	if status.Terminal() {
+		return fmt.Errorf("order is %s, not funding it", status)
+	}
+	filled, err := readFilled(ctx, e.addr, e.cABI, e.client)
+	if err != nil {
+		return fmt.Errorf("read filled: %w", err)
+	}
+	held, err := readTokenBalance(ctx, e.client, s.TokenIn, e.addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(outputFrom(ctx), "Order: totalAmountIn=%s, filled=%s, vault holds %s of tokenIn %s\n", s.TotalAmountIn, filled, held, s.TokenIn.Hex())
+	shortfall := depositShortfall(s, filled, held)
+	if shortfall.Sign() <= 0 {
+		return errAlreadyFunded
+	}
+
+	from := e.signer.Address()
+	balance, err := readTokenBalance(ctx, e.client, s.TokenIn, from)
+	if err != nil {
+		return err
+	}
+	if balance.Cmp(shortfall) < 0 {
+		return fmt.Errorf("%s holds %s tokenIn, the vault needs %s", from.Hex(), balance, shortfall)
+	}
+
+	fmt.Fprintf(outputFrom(ctx), "Transferring %s tokenIn from %s to the vault\n", shortfall, from.Hex())
+	data, err := erc20ABI.Pack("transfer", e.addr, shortfall)
+	if err != nil {
+		return fmt.Errorf("pack transfer: %w", err)
+	}
+	token := bind.NewBoundContract(s.TokenIn, erc20ABI, e.st.txClient, e.st.txClient, e.st.txClient)
+	receipt, err := e.sendOwnerTx(ctx, s.TokenIn, erc20ABI, "transfer", data, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return token.Transact(auth, "transfer", e.addr, shortfall)
+	})
+	if err != nil {
+		return err
+	}
+	for _, tr := range transfersFromLogs(receipt.Logs) {
+		fmt.Fprintf(outputFrom(ctx), "[Event] Transfer: token=%s from=%s to=%s value=%s\n", tr.Token.Hex(), tr.From.Hex(), tr.To.Hex(), tr.Value)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package twapagent
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestDepositShortfall(t *testing.T) {
+	s := Strategy{TotalAmountIn: big.NewInt(1000)}
+	for _, tc := range []struct {
+		filled, held, want int64
+	}{
+		{0, 0, 1000},
+		{0, 400, 600},
+		{300, 700, 0},    // funded for what is left
+		{300, 900, -200}, // more than enough
+	} {
+		if got := depositShortfall(s, big.NewInt(tc.filled), big.NewInt(tc.held)); got.Int64() != tc.want {
+			t.Errorf("filled=%d held=%d: shortfall %s, want %d", tc.filled, tc.held, got, tc.want)
+		}
+	}
+}
+
+func TestTransfersFromLogs(t *testing.T) {
+	ev := erc20ABI.Events["Transfer"]
+	token, from, to := common.Address{1}, common.Address{2}, common.Address{3}
+	data, err := ev.Inputs.NonIndexed().Pack(big.NewInt(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	topics := []common.Hash{ev.ID, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())}
+	logs := []*types.Log{
+		{Address: token, Topics: topics, Data: data},
+		{Address: token, Topics: append(topics, common.Hash{}), Data: nil}, // ERC-721
+	}
+	got := transfersFromLogs(logs)
+	if len(got) != 1 || got[0].Token != token || got[0].From != from || got[0].To != to || got[0].Value.Int64() != 42 {
+		t.Fatalf("transfers = %+v", got)
+	}
+}
@@ -0,0 +1,914 @@
+package twapagent
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// devChain is an in-process chain for devnet mode and the package tests,
+// served over JSON-RPC so the agent dials it like any node. It has no EVM:
+// a deployment installs devVault, a Go double of src/Twap.sol that keeps
+// its state, guards and events, next to two tokens, an oracle and an
+// adapter that answer what the agent reads. Every transaction is mined at
+// once into a block of its own, devBlockTime seconds after the head, and
+// mine adds empty ones. eth_call always sees the latest state. Its evm
+// namespace has anvil's evm_setNextBlockTimestamp and evm_mine.
+type devChain struct {
+	mu       sync.Mutex
+	cABI     abi.ABI
+	signer   types.Signer
+	headers  []*types.Header
+	nonces   map[common.Address]uint64
+	spent    map[common.Address]*big.Int
+	vaults   map[common.Address]*devVault
+	logs     []types.Log
+	receipts map[common.Hash]*types.Receipt
+	// tokenOut per tokenIn, 1e18-scaled as IOracle returns it.
+	price *big.Int
+	// The next block's time, set by evm_setNextBlockTimestamp (0 = none).
+	nextTime uint64
+}
+
+// Dev chain parameters: anvil's chain id, mainnet's block time, and the
+// ether every account starts with.
+const (
+	devChainID   = 31337
+	devBlockTime = 12
+)
+
+var devFunding = new(big.Int).Mul(big.NewInt(100), big.NewInt(1e18))
+
+// The contracts the dev chain has from genesis.
+var (
+	devTokenIn  = common.HexToAddress("0xa000000000000000000000000000000000000001")
+	devTokenOut = common.HexToAddress("0xa000000000000000000000000000000000000002")
+	devAdapter  = common.HexToAddress("0xa000000000000000000000000000000000000003")
+	devOracle   = common.HexToAddress("0xa000000000000000000000000000000000000004")
+)
+
+// devAdapterFeeBps is the fee the adapter reports on each swap; it fills
+// at the oracle price otherwise.
+const devAdapterFeeBps = 30
+
+// devGasPrice is what eth_gasPrice answers; the chain has no base fee.
+var devGasPrice = big.NewInt(1e9)
+
+func newDevChain(cABI abi.ABI, genesis uint64) *devChain {
+	c := &devChain{
+		cABI:     cABI,
+		signer:   types.LatestSignerForChainID(big.NewInt(devChainID)),
+		nonces:   map[common.Address]uint64{},
+		spent:    map[common.Address]*big.Int{},
+		vaults:   map[common.Address]*devVault{},
+		receipts: map[common.Hash]*types.Receipt{},
+		price:    big.NewInt(2e18),
+	}
+	c.headers = []*types.Header{{Number: new(big.Int), Difficulty: new(big.Int), GasLimit: 30_000_000, Time: genesis}}
+	return c
+}
+
+// serveDevChain serves c's eth and evm namespaces on a loopback port. It returns the
+// URL and a func that stops serving.
+func serveDevChain(c *devChain) (string, func(), error) {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("eth", c); err != nil {
+		return "", nil, err
+	}
+	if err := srv.RegisterName("evm", devEVM{c}); err != nil {
+		return "", nil, err
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	hs := &http.Server{Handler: srv}
+	go hs.Serve(ln)
+	return "http://" + ln.Addr().String(), func() {
+		hs.Close()
+		srv.Stop()
+	}, nil
+}
+
+func (c *devChain) head() *types.Header { return c.headers[len(c.headers)-1] }
+
+func (c *devChain) headTime() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.head().Time
+}
+
+// mineLocked appends a block at ts, or one second after the head if that
+// is not later. A time set by evm_setNextBlockTimestamp overrides ts. The
+// caller holds c.mu.
+func (c *devChain) mineLocked(ts uint64) *types.Header {
+	parent := c.head()
+	if c.nextTime > 0 {
+		ts, c.nextTime = c.nextTime, 0
+	}
+	if ts <= parent.Time {
+		ts = parent.Time + 1
+	}
+	h := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number, common.Big1),
+		Difficulty: new(big.Int),
+		GasLimit:   parent.GasLimit,
+		Time:       ts,
+	}
+	c.headers = append(c.headers, h)
+	return h
+}
+
+// mine adds an empty block devBlockTime after the head.
+func (c *devChain) mine() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mineLocked(c.head().Time + devBlockTime)
+}
+
+// warp mines an empty block at ts, moving the chain's clock forward.
+func (c *devChain) warp(ts uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mineLocked(ts)
+}
+
+// devEVM is the dev chain's evm namespace, the part of anvil's that time
+// warps use.
+type devEVM struct{ c *devChain }
+
+// SetNextBlockTimestamp is evm_setNextBlockTimestamp. Like anvil, it
+// refuses a time that is not after the head's.
+func (e devEVM) SetNextBlockTimestamp(ts uint64) error {
+	e.c.mu.Lock()
+	defer e.c.mu.Unlock()
+	if head := e.c.head().Time; ts <= head {
+		return fmt.Errorf("timestamp %d is not after the latest block's (%d)", ts, head)
+	}
+	e.c.nextTime = ts
+	return nil
+}
+
+// Mine is evm_mine: an empty block, devBlockTime after the head unless
+// SetNextBlockTimestamp said otherwise.
+func (e devEVM) Mine() {
+	e.c.mine()
+}
+
+func (c *devChain) setPrice(p *big.Int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.price = new(big.Int).Set(p)
+}
+
+func (c *devChain) vault(addr common.Address) *devVault {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.vaults[addr]
+}
+
+// blockAt reads a block tag: a hex number or latest, pending, safe,
+// finalized or earliest. Pending is the latest block; nothing is pooled.
+func (c *devChain) blockAt(tag string) (uint64, error) {
+	switch tag {
+	case "", "latest", "pending", "safe", "finalized":
+		return c.head().Number.Uint64(), nil
+	case "earliest":
+		return 0, nil
+	}
+	return hexutil.DecodeUint64(tag)
+}
+
+// devRevert is a reverted call as a node reports it: code 3, with the
+// revert payload as data.
+type devRevert struct {
+	reason string
+	data   []byte
+}
+
+func (r devRevert) Error() string {
+	if r.reason == "" {
+		return "execution reverted"
+	}
+	return "execution reverted: " + r.reason
+}
+
+func (r devRevert) ErrorCode() int { return 3 }
+
+func (r devRevert) ErrorData() interface{} { return hexutil.Encode(r.data) }
+
+var stringArgs = func() abi.Arguments {
+	t, err := abi.NewType("string", "", nil)
+	if err != nil {
+		panic(err)
+	}
+	return abi.Arguments{{Type: t}}
+}()
+
+// revertWith is require(false, reason).
+func revertWith(reason string) devRevert {
+	packed, _ := stringArgs.Pack(reason)
+	return devRevert{reason: reason, data: append(append([]byte{}, errorStringSelector...), packed...)}
+}
+
+// revertError reverts with the ABI's custom error name.
+func (c *devChain) revertError(name string, args ...interface{}) devRevert {
+	e := c.cABI.Errors[name]
+	packed, _ := e.Inputs.Pack(args...)
+	return devRevert{reason: name, data: append(append([]byte{}, e.ID[:4]...), packed...)}
+}
+
+// devLog is an event for the chain to encode once its call commits.
+type devLog struct {
+	event string
+	args  []interface{}
+}
+
+// callLocked runs data against to at time now. A state-changing call
+// returns a commit func that applies it and returns its events; eth_call
+// and eth_estimateGas drop it. The caller holds c.mu.
+func (c *devChain) callLocked(from, to common.Address, data []byte, now uint64) ([]byte, func() []devLog, error) {
+	if v, ok := c.vaults[to]; ok {
+		return v.call(from, data, now)
+	}
+	switch to {
+	case devTokenIn, devTokenOut:
+		return c.tokenCall(to, data)
+	case devOracle:
+		m, args, err := methodCall(oracleABI, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if args[0].(common.Address) != devTokenIn || args[1].(common.Address) != devTokenOut {
+			return nil, nil, revertWith("NO_PRICE")
+		}
+		out, err := m.Outputs.Pack(c.price)
+		return out, nil, err
+	}
+	// An account without code: the call succeeds and returns nothing.
+	return nil, nil, nil
+}
+
+// tokenCall answers the ERC-20 views. Vaults hold the tokenIn their order
+// has left to swap and the tokenOut it has received, as if funded and paid
+// by the adapter; nobody else holds any.
+func (c *devChain) tokenCall(token common.Address, data []byte) ([]byte, func() []devLog, error) {
+	m, args, err := methodCall(erc20ABI, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var out []interface{}
+	switch m.Name {
+	case "symbol":
+		out = []interface{}{map[common.Address]string{devTokenIn: "TIN", devTokenOut: "TOUT"}[token]}
+	case "decimals":
+		out = []interface{}{uint8(18)}
+	case "balanceOf":
+		bal := new(big.Int)
+		if v, ok := c.vaults[args[0].(common.Address)]; ok && token == devTokenIn && v.s.TokenIn == token {
+			bal.Sub(v.s.TotalAmountIn, v.filled)
+		} else if ok && token == devTokenOut && v.s.TokenOut == token {
+			bal.Set(v.received)
+		}
+		out = []interface{}{bal}
+	case "allowance":
+		out = []interface{}{new(big.Int)}
+	default:
+		return nil, nil, revertWith("devnet tokens can't be transferred")
+	}
+	packed, err := m.Outputs.Pack(out...)
+	return packed, nil, err
+}
+
+// methodCall finds data's method in parsed and unpacks its arguments; a
+// selector the contract lacks reverts without data, as a call to a contract
+// with no fallback does.
+func methodCall(parsed abi.ABI, data []byte) (*abi.Method, []interface{}, error) {
+	if len(data) < 4 {
+		return nil, nil, devRevert{}
+	}
+	m, err := parsed.MethodById(data[:4])
+	if err != nil {
+		return nil, nil, devRevert{}
+	}
+	args, err := m.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, nil, devRevert{}
+	}
+	return m, args, nil
+}
+
+// logLocked encodes l as emitted by addr. The caller holds c.mu.
+func (c *devChain) logLocked(addr common.Address, l devLog) (types.Log, error) {
+	ev := c.cABI.Events[l.event]
+	out := types.Log{Address: addr, Topics: []common.Hash{ev.ID}}
+	var data []interface{}
+	for i, in := range ev.Inputs {
+		if in.Indexed {
+			out.Topics = append(out.Topics, common.BytesToHash(l.args[i].(common.Address).Bytes()))
+		} else {
+			data = append(data, l.args[i])
+		}
+	}
+	var err error
+	out.Data, err = ev.Inputs.NonIndexed().Pack(data...)
+	return out, err
+}
+
+// devCallArgs is the eth_call and eth_estimateGas argument; clients send
+// the calldata as data or input.
+type devCallArgs struct {
+	From  *common.Address `json:"from"`
+	To    *common.Address `json:"to"`
+	Data  hexutil.Bytes   `json:"data"`
+	Input hexutil.Bytes   `json:"input"`
+}
+
+func (a devCallArgs) calldata() []byte {
+	if len(a.Input) > 0 {
+		return a.Input
+	}
+	return a.Data
+}
+
+func (a devCallArgs) from() common.Address {
+	if a.From == nil {
+		return common.Address{}
+	}
+	return *a.From
+}
+
+type devFilterArgs struct {
+	FromBlock string           `json:"fromBlock"`
+	ToBlock   string           `json:"toBlock"`
+	BlockHash *common.Hash     `json:"blockHash"`
+	Addresses []common.Address `json:"address"`
+	Topics    [][]common.Hash  `json:"topics"`
+}
+
+func (c *devChain) ChainId() *hexutil.Big { return (*hexutil.Big)(big.NewInt(devChainID)) }
+
+func (c *devChain) BlockNumber() hexutil.Uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return hexutil.Uint64(c.head().Number.Uint64())
+}
+
+func (c *devChain) GetBlockByNumber(tag string, _ bool) (*types.Header, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, err := c.blockAt(tag)
+	if err != nil || n >= uint64(len(c.headers)) {
+		return nil, err
+	}
+	return c.headers[n], nil
+}
+
+func (c *devChain) GetBlockByHash(hash common.Hash, _ bool) *types.Header {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, h := range c.headers {
+		if h.Hash() == hash {
+			return h
+		}
+	}
+	return nil
+}
+
+func (c *devChain) GetCode(addr common.Address, _ string) hexutil.Bytes {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.vaults[addr]; ok {
+		return hexutil.Bytes{0x60, 0x80}
+	}
+	switch addr {
+	case devTokenIn, devTokenOut, devAdapter, devOracle:
+		return hexutil.Bytes{0x60, 0x80}
+	}
+	return hexutil.Bytes{}
+}
+
+func (c *devChain) GetBalance(addr common.Address, _ string) *hexutil.Big {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bal := new(big.Int).Set(devFunding)
+	if spent, ok := c.spent[addr]; ok {
+		bal.Sub(bal, spent)
+	}
+	return (*hexutil.Big)(bal)
+}
+
+func (c *devChain) GasPrice() *hexutil.Big { return (*hexutil.Big)(devGasPrice) }
+
+func (c *devChain) GetTransactionCount(addr common.Address, _ string) hexutil.Uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return hexutil.Uint64(c.nonces[addr])
+}
+
+func (c *devChain) Call(args devCallArgs, _ string) (hexutil.Bytes, error) {
+	if args.To == nil {
+		return nil, errors.New("eth_call needs a to address")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out, _, err := c.callLocked(args.from(), *args.To, args.calldata(), c.head().Time)
+	return out, err
+}
+
+// EstimateGas answers a flat figure for any call that doesn't revert.
+func (c *devChain) EstimateGas(args devCallArgs) (hexutil.Uint64, error) {
+	if args.To == nil {
+		return 1_200_000, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, _, err := c.callLocked(args.from(), *args.To, args.calldata(), c.head().Time); err != nil {
+		return 0, err
+	}
+	return 100_000, nil
+}
+
+// SendRawTransaction mines tx into a new block. A contract creation
+// deploys a devVault owned by the constructor's address argument.
+func (c *devChain) SendRawTransaction(raw hexutil.Bytes) (common.Hash, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		return common.Hash{}, err
+	}
+	from, err := types.Sender(c.signer, tx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch nonce := c.nonces[from]; {
+	case tx.Nonce() < nonce:
+		return common.Hash{}, fmt.Errorf("nonce too low: next nonce %d, tx nonce %d", nonce, tx.Nonce())
+	case tx.Nonce() > nonce:
+		return common.Hash{}, fmt.Errorf("nonce too high: next nonce %d, tx nonce %d", nonce, tx.Nonce())
+	}
+	spent := c.spent[from]
+	if spent == nil {
+		spent = new(big.Int)
+		c.spent[from] = spent
+	}
+	cost := new(big.Int).Mul(new(big.Int).SetUint64(tx.Gas()), tx.GasPrice())
+	if new(big.Int).Add(spent, cost).Cmp(devFunding) > 0 {
+		return common.Hash{}, errors.New("insufficient funds for gas * price + value")
+	}
+	c.nonces[from]++
+	h := c.mineLocked(c.head().Time + devBlockTime)
+	r := &types.Receipt{
+		Type:              tx.Type(),
+		Status:            types.ReceiptStatusSuccessful,
+		TxHash:            tx.Hash(),
+		GasUsed:           80_000,
+		EffectiveGasPrice: tx.GasPrice(),
+		BlockHash:         h.Hash(),
+		BlockNumber:       h.Number,
+		Logs:              []*types.Log{},
+	}
+	var events []devLog
+	if tx.To() == nil {
+		r.GasUsed = 1_000_000
+		r.ContractAddress = crypto.CreateAddress(from, tx.Nonce())
+		owner := from
+		if data := tx.Data(); len(data) >= 32 {
+			owner = common.BytesToAddress(data[len(data)-32:])
+		}
+		c.vaults[r.ContractAddress] = newDevVault(c, owner)
+		events = []devLog{{"OwnershipTransferred", []interface{}{common.Address{}, owner}}}
+	} else if _, commit, err := c.callLocked(from, *tx.To(), tx.Data(), h.Time); err != nil {
+		r.Status = types.ReceiptStatusFailed
+	} else if commit != nil {
+		events = commit()
+	}
+	if r.GasUsed > tx.Gas() {
+		r.GasUsed, r.Status, events = tx.Gas(), types.ReceiptStatusFailed, nil
+	}
+	r.CumulativeGasUsed = r.GasUsed
+	spent.Add(spent, new(big.Int).Mul(new(big.Int).SetUint64(r.GasUsed), tx.GasPrice()))
+	emitter := r.ContractAddress
+	if tx.To() != nil {
+		emitter = *tx.To()
+	}
+	for i, ev := range events {
+		l, err := c.logLocked(emitter, ev)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		l.BlockNumber, l.BlockHash, l.TxHash, l.Index = h.Number.Uint64(), r.BlockHash, r.TxHash, uint(i)
+		c.logs = append(c.logs, l)
+		r.Logs = append(r.Logs, &c.logs[len(c.logs)-1])
+	}
+	r.Bloom = types.CreateBloom(types.Receipts{r})
+	c.receipts[tx.Hash()] = r
+	return tx.Hash(), nil
+}
+
+func (c *devChain) GetTransactionReceipt(hash common.Hash) *types.Receipt {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.receipts[hash]
+}
+
+func (c *devChain) GetLogs(args devFilterArgs) ([]types.Log, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	from, err := c.blockAt(args.FromBlock)
+	if err != nil {
+		return nil, fmt.Errorf("fromBlock: %w", err)
+	}
+	to, err := c.blockAt(args.ToBlock)
+	if err != nil {
+		return nil, fmt.Errorf("toBlock: %w", err)
+	}
+	logs := []types.Log{}
+	for _, l := range c.logs {
+		if args.BlockHash != nil {
+			if l.BlockHash != *args.BlockHash {
+				continue
+			}
+		} else if l.BlockNumber < from || l.BlockNumber > to {
+			continue
+		}
+		if len(args.Addresses) > 0 && !containsAddress(args.Addresses, l.Address) {
+			continue
+		}
+		if !topicsMatch(args.Topics, l.Topics) {
+			continue
+		}
+		logs = append(logs, l)
+	}
+	return logs, nil
+}
+
+func containsAddress(addrs []common.Address, a common.Address) bool {
+	for _, b := range addrs {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
+
+// topicsMatch applies an eth_getLogs topic filter: each position is a set
+// of alternatives, and an empty one matches anything.
+func topicsMatch(filter [][]common.Hash, topics []common.Hash) bool {
+	if len(filter) > len(topics) {
+		return false
+	}
+	for i, alts := range filter {
+		if len(alts) == 0 {
+			continue
+		}
+		match := false
+		for _, t := range alts {
+			match = match || t == topics[i]
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+// devVault is src/Twap.sol in Go: the same guards, in the same order, with
+// the same revert reasons and events. Tokens don't move; the dev chain's
+// balanceOf derives balances from the accounting instead. devchain_test.go
+// holds it to the contract's source, so a change to either fails the tests
+// until the other follows.
+type devVault struct {
+	chain    *devChain
+	owner    common.Address
+	agent    common.Address
+	paused   bool
+	s        Strategy
+	status   Status
+	filled   *big.Int
+	received *big.Int
+	fee      *big.Int
+	refPrice *big.Int
+	done     map[int64]bool
+}
+
+func newDevVault(c *devChain, owner common.Address) *devVault {
+	return &devVault{
+		chain: c,
+		owner: owner,
+		s: Strategy{
+			TotalAmountIn: new(big.Int), SliceAmountIn: new(big.Int),
+			StartTime: new(big.Int), EndTime: new(big.Int),
+		},
+		filled:   new(big.Int),
+		received: new(big.Int),
+		fee:      new(big.Int),
+		refPrice: new(big.Int),
+		done:     map[int64]bool{},
+	}
+}
+
+func (v *devVault) slices() *big.Int {
+	if v.s.SliceAmountIn.Sign() == 0 {
+		return new(big.Int)
+	}
+	return sliceTotal(v.s)
+}
+
+func (v *devVault) sliceDone(id *big.Int) bool {
+	return id.IsInt64() && v.done[id.Int64()]
+}
+
+func (v *devVault) call(from common.Address, data []byte, now uint64) ([]byte, func() []devLog, error) {
+	m, args, err := methodCall(v.chain.cABI, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.IsConstant() {
+		out, err := v.view(m.Name, args)
+		if err != nil {
+			return nil, nil, err
+		}
+		packed, err := m.Outputs.Pack(out...)
+		return packed, nil, err
+	}
+	commit, err := v.exec(from, m.Name, args, now)
+	return nil, commit, err
+}
+
+func (v *devVault) view(name string, args []interface{}) ([]interface{}, error) {
+	s := v.s
+	switch name {
+	case "agent":
+		return []interface{}{v.agent}, nil
+	case "owner":
+		return []interface{}{v.owner}, nil
+	case "paused":
+		return []interface{}{v.paused}, nil
+	case "status":
+		return []interface{}{uint8(v.status)}, nil
+	case "strategy":
+		return []interface{}{s.TokenIn, s.TokenOut, s.Adapter, s.PriceOracle, s.TotalAmountIn, s.SliceAmountIn, s.StartTime, s.EndTime, s.MaxSlippageBps, s.MaxPriceDeviationBps}, nil
+	case "getStrategyParams":
+		return []interface{}{s.TokenIn, s.TokenOut, s.Adapter, s.PriceOracle, s.TotalAmountIn, s.MaxSlippageBps, s.MaxPriceDeviationBps}, nil
+	case "filledAmountIn":
+		return []interface{}{v.filled}, nil
+	case "receivedAmountOut":
+		return []interface{}{v.received}, nil
+	case "accruedFee":
+		return []interface{}{v.fee}, nil
+	case "referencePrice":
+		return []interface{}{v.refPrice}, nil
+	case "totalSlices":
+		return []interface{}{v.slices()}, nil
+	case "sliceDone":
+		return []interface{}{v.sliceDone(args[0].(*big.Int))}, nil
+	case "nextIntervalTimestamp":
+		id, n := args[0].(*big.Int), v.slices()
+		if id.Cmp(n) >= 0 {
+			return []interface{}{abi.MaxUint256}, nil
+		}
+		at, err := sliceScheduledAt(s, n.Int64(), id.Int64())
+		return []interface{}{at}, err
+	}
+	return nil, devRevert{}
+}
+
+// exec checks a state-changing call and returns the func that applies it.
+func (v *devVault) exec(from common.Address, name string, args []interface{}, now uint64) (func() []devLog, error) {
+	c := v.chain
+	onlyOwner := func() error {
+		if from != v.owner {
+			return c.revertError("OwnableUnauthorizedAccount", from)
+		}
+		return nil
+	}
+	whenNotPaused := func() error {
+		if v.paused {
+			return c.revertError("EnforcedPause")
+		}
+		return nil
+	}
+	switch name {
+	case "transferOwnership", "renounceOwnership":
+		if err := onlyOwner(); err != nil {
+			return nil, err
+		}
+		next := common.Address{}
+		if name == "transferOwnership" {
+			if next = args[0].(common.Address); next == (common.Address{}) {
+				return nil, c.revertError("OwnableInvalidOwner", next)
+			}
+		}
+		return func() []devLog {
+			prev := v.owner
+			v.owner = next
+			return []devLog{{"OwnershipTransferred", []interface{}{prev, next}}}
+		}, nil
+	case "setAgent":
+		if err := onlyOwner(); err != nil {
+			return nil, err
+		}
+		agent := args[0].(common.Address)
+		switch {
+		case agent == common.Address{}:
+			return nil, revertWith("AGENT_ZERO")
+		case agent == v.s.Adapter:
+			return nil, revertWith("AGENT_EQ_ADAPTER")
+		}
+		return func() []devLog { v.agent = agent; return nil }, nil
+	case "pause":
+		if err := onlyOwner(); err != nil {
+			return nil, err
+		}
+		if err := whenNotPaused(); err != nil {
+			return nil, err
+		}
+		return func() []devLog {
+			v.paused = true
+			return []devLog{{"Paused", []interface{}{from}}}
+		}, nil
+	case "unpause":
+		if err := onlyOwner(); err != nil {
+			return nil, err
+		}
+		if !v.paused {
+			return nil, c.revertError("ExpectedPause")
+		}
+		return func() []devLog {
+			v.paused = false
+			return []devLog{{"Unpaused", []interface{}{from}}}
+		}, nil
+	case "configureStrategy":
+		if err := onlyOwner(); err != nil {
+			return nil, err
+		}
+		if !v.paused {
+			return nil, c.revertError("ExpectedPause")
+		}
+		s := *abi.ConvertType(args[0], new(Strategy)).(*Strategy)
+		if err := configureRules(s, now, v.agent); err != nil {
+			return nil, err
+		}
+		price, err := c.priceFrom(s)
+		if err != nil {
+			return nil, err
+		}
+		if price.Sign() == 0 {
+			return nil, revertWith("NO_REFERENCE_PRICE")
+		}
+		return func() []devLog {
+			v.s, v.status = s, StatusOpen
+			v.filled, v.received, v.fee = new(big.Int), new(big.Int), new(big.Int)
+			v.done = map[int64]bool{}
+			v.refPrice = price
+			return []devLog{v.orderStatus()}
+		}, nil
+	case "cancel":
+		if err := onlyOwner(); err != nil {
+			return nil, err
+		}
+		if v.status == StatusCancelled || v.status == StatusFilled {
+			return nil, revertWith("ORDER_TERMINATED")
+		}
+		if err := whenNotPaused(); err != nil {
+			return nil, err
+		}
+		return func() []devLog {
+			v.status, v.paused = StatusCancelled, true
+			return []devLog{{"Paused", []interface{}{from}}, v.orderStatus()}
+		}, nil
+	case "sweep":
+		if err := onlyOwner(); err != nil {
+			return nil, err
+		}
+		if args[1].(common.Address) == (common.Address{}) {
+			return nil, revertWith("INVALID_TO")
+		}
+		return func() []devLog { return nil }, nil
+	case "executeSlice":
+		if from != v.agent {
+			return nil, revertWith("AGENT")
+		}
+		if err := whenNotPaused(); err != nil {
+			return nil, err
+		}
+		return v.executeSlice(args[0].(*big.Int), now)
+	}
+	return nil, devRevert{}
+}
+
+// configureRules is configureStrategy's requires on s.
+func configureRules(s Strategy, now uint64, agent common.Address) error {
+	zero := common.Address{}
+	switch {
+	case s.TokenIn == zero || s.TokenOut == zero:
+		return revertWith("INVALID_TOKENS")
+	case s.TokenIn == s.TokenOut:
+		return revertWith("SAME_TOKEN")
+	case s.Adapter == zero || s.PriceOracle == zero:
+		return revertWith("INVALID_ADDRESSES")
+	case s.TotalAmountIn.Sign() == 0 || s.SliceAmountIn.Sign() == 0:
+		return revertWith("INVALID_AMOUNTS")
+	case s.EndTime.Cmp(s.StartTime) <= 0 || s.StartTime.Cmp(new(big.Int).SetUint64(now)) <= 0:
+		return revertWith("INVALID_TIME_WINDOW")
+	case s.MaxSlippageBps > SlippageBpsLimit || s.MaxPriceDeviationBps > DeviationBpsLimit:
+		return revertWith("INVALID_BPS")
+	case s.Adapter == agent:
+		return revertWith("ADAPTER_EQ_AGENT")
+	}
+	return nil
+}
+
+// priceFrom is IOracle(s.priceOracle).getPrice(s.tokenIn, s.tokenOut),
+// which only the dev oracle answers. The caller holds c.mu.
+func (c *devChain) priceFrom(s Strategy) (*big.Int, error) {
+	if s.PriceOracle != devOracle || s.TokenIn != devTokenIn || s.TokenOut != devTokenOut {
+		return nil, devRevert{}
+	}
+	return new(big.Int).Set(c.price), nil
+}
+
+func (v *devVault) orderStatus() devLog {
+	return devLog{"OrderStatus", []interface{}{new(big.Int).Set(v.filled), new(big.Int).Set(v.received), new(big.Int).Set(v.fee), uint8(v.status)}}
+}
+
+func (v *devVault) executeSlice(id *big.Int, now uint64) (func() []devLog, error) {
+	s := v.s
+	if v.status == StatusCancelled || v.status == StatusFilled {
+		return nil, revertWith("ORDER_TERMINATED")
+	}
+	n := v.slices()
+	if n.Sign() == 0 {
+		// Math.ceilDiv by a zero sliceAmountIn.
+		return nil, devRevert{reason: "Panic(0x12)", data: append(append([]byte{}, panicSelector...), common.LeftPadBytes([]byte{0x12}, 32)...)}
+	}
+	if id.Cmp(n) >= 0 {
+		return nil, revertWith("INVALID_SLICE_ID")
+	}
+	if v.sliceDone(id) {
+		return nil, revertWith("SLICE_DONE")
+	}
+	at, err := sliceScheduledAt(s, n.Int64(), id.Int64())
+	if err != nil {
+		return nil, err
+	}
+	if new(big.Int).SetUint64(now).Cmp(at) < 0 {
+		return nil, revertWith("TOO_EARLY")
+	}
+	amountIn := new(big.Int).Sub(s.TotalAmountIn, v.filled)
+	if amountIn.Cmp(s.SliceAmountIn) > 0 {
+		amountIn.Set(s.SliceAmountIn)
+	}
+	if amountIn.Sign() == 0 {
+		return nil, revertWith("NOTHING_REMAINING")
+	}
+	p, err := v.chain.priceFrom(s)
+	if err != nil {
+		return nil, err
+	}
+	if p.Sign() == 0 {
+		return nil, revertWith("INVALID_PRICE")
+	}
+	dev := new(big.Int).Sub(p, v.refPrice)
+	dev.Abs(dev).Mul(dev, big.NewInt(10_000)).Div(dev, v.refPrice)
+	if dev.Cmp(big.NewInt(int64(s.MaxPriceDeviationBps))) > 0 {
+		return nil, revertWith("PRICE_DEVIATION")
+	}
+	minOut := new(big.Int).Mul(p, big.NewInt(10_000-int64(s.MaxSlippageBps)))
+	minOut.Mul(minOut, amountIn).Div(minOut, big.NewInt(1e18)).Div(minOut, big.NewInt(10_000))
+	if minOut.Sign() == 0 {
+		return nil, revertWith("MIN_OUT_ZERO")
+	}
+	// The adapter fills all of it at the oracle price and reports a fee.
+	out := new(big.Int).Mul(amountIn, p)
+	out.Div(out, big.NewInt(1e18))
+	fee := new(big.Int).Mul(amountIn, big.NewInt(devAdapterFeeBps))
+	fee.Div(fee, big.NewInt(10_000))
+	if out.Cmp(minOut) < 0 {
+		return nil, revertWith("SLIPPAGE")
+	}
+	return func() []devLog {
+		v.filled = new(big.Int).Add(v.filled, amountIn)
+		v.received = new(big.Int).Add(v.received, out)
+		v.fee = new(big.Int).Add(v.fee, fee)
+		v.done[id.Int64()] = true
+		v.status = StatusPartialFilled
+		if v.filled.Cmp(s.TotalAmountIn) >= 0 {
+			v.status = StatusFilled
+		}
+		return []devLog{{"Fill", []interface{}{new(big.Int).Set(id), amountIn, out, fee}}, v.orderStatus()}
+	}, nil
+}
@@ -0,0 +1,524 @@
+package twapagent
+
+import (
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+
+	"twap-agent/twapbind"
+)
+
+// The dev chain has no EVM, so these tests hold devVault to src/Twap.sol
+// instead: its guards against the contract's requires, its slice math and
+// its status changes. Editing either side fails them until the other is
+// brought in line.
+
+// twapSource is src/Twap.sol with its // comments removed.
+func twapSource(t *testing.T) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("..", "..", "src", "Twap.sol"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return regexp.MustCompile(`//[^\n]*`).ReplaceAllString(string(b), "")
+}
+
+// solidityBlock is the body of the block opening at the first { from start.
+func solidityBlock(src string, start int) string {
+	open := strings.IndexByte(src[start:], '{') + start
+	depth := 0
+	for i := open; i < len(src); i++ {
+		switch src[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return src[open+1 : i]
+			}
+		}
+	}
+	return src[open+1:]
+}
+
+// The revert each modifier and internal call of OpenZeppelin's that
+// Twap.sol uses raises.
+var ozReverts = map[string]string{
+	"onlyOwner":     "OwnableUnauthorizedAccount",
+	"whenPaused":    "ExpectedPause",
+	"whenNotPaused": "EnforcedPause",
+	"_pause":        "EnforcedPause",
+	"_unpause":      "ExpectedPause",
+}
+
+var (
+	solFunction = regexp.MustCompile(`(?s)\b(function|modifier)\s+(\w+)\s*\(([^)]*)\)([^{;]*)`)
+	solReason   = regexp.MustCompile(`"([A-Z_]+)"\s*\)\s*;|\b(_pause|_unpause)\(\)`)
+	solWord     = regexp.MustCompile(`\w+`)
+)
+
+// twapGuards is, for each state-changing function of Twap.sol, its
+// reverts in the order they are checked: its modifiers', then the requires
+// of its body.
+func twapGuards(src string) map[string][]string {
+	modifiers := map[string][]string{}
+	guards := map[string][]string{}
+	for _, m := range solFunction.FindAllStringSubmatchIndex(src, -1) {
+		kind, name, header := src[m[2]:m[3]], src[m[4]:m[5]], src[m[8]:m[9]]
+		if strings.Contains(header, " view") {
+			continue
+		}
+		var reasons []string
+		for _, word := range solWord.FindAllString(header, -1) {
+			if r, ok := modifiers[word]; ok {
+				reasons = append(reasons, r...)
+			} else if r, ok := ozReverts[word]; ok {
+				reasons = append(reasons, r)
+			}
+		}
+		for _, r := range solReason.FindAllStringSubmatch(solidityBlock(src, m[1]), -1) {
+			if r[1] != "" {
+				reasons = append(reasons, r[1])
+			} else {
+				reasons = append(reasons, ozReverts[r[2]])
+			}
+		}
+		if kind == "modifier" {
+			modifiers[name] = reasons
+		} else {
+			guards[name] = reasons
+		}
+	}
+	return guards
+}
+
+// devVaultGuards is, for each function devVault.exec handles, the reverts
+// its case raises in source order, following the calls into devchain.go's
+// own functions and exec's guard closures.
+func devVaultGuards(t *testing.T) map[string][]string {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), "devchain.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies := map[string]*ast.BlockStmt{}
+	var exec *ast.FuncDecl
+	for _, d := range f.Decls {
+		if fd, ok := d.(*ast.FuncDecl); ok && fd.Body != nil {
+			bodies[fd.Name.Name] = fd.Body
+			if fd.Name.Name == "exec" {
+				exec = fd
+			}
+		}
+	}
+	if exec == nil {
+		t.Fatal("devchain.go has no exec")
+	}
+	ast.Inspect(exec.Body, func(n ast.Node) bool {
+		if as, ok := n.(*ast.AssignStmt); ok && len(as.Lhs) == 1 && len(as.Rhs) == 1 {
+			if id, ok := as.Lhs[0].(*ast.Ident); ok {
+				if fl, ok := as.Rhs[0].(*ast.FuncLit); ok {
+					bodies[id.Name] = fl.Body
+				}
+			}
+		}
+		return true
+	})
+	callee := func(c *ast.CallExpr) string {
+		switch fn := c.Fun.(type) {
+		case *ast.Ident:
+			return fn.Name
+		case *ast.SelectorExpr:
+			return fn.Sel.Name
+		}
+		return ""
+	}
+	var walk func(n ast.Node, depth int, out *[]string)
+	walk = func(n ast.Node, depth int, out *[]string) {
+		ast.Inspect(n, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			switch name := callee(call); {
+			case name == "revertWith" || name == "revertError":
+				if lit, ok := call.Args[0].(*ast.BasicLit); ok {
+					reason, _ := strconv.Unquote(lit.Value)
+					*out = append(*out, reason)
+				}
+				return false
+			case bodies[name] != nil && name != "exec" && depth < 4:
+				walk(bodies[name], depth+1, out)
+			}
+			return true
+		})
+	}
+	guards := map[string][]string{}
+	ast.Inspect(exec.Body, func(n ast.Node) bool {
+		cc, ok := n.(*ast.CaseClause)
+		if !ok {
+			return true
+		}
+		var reasons []string
+		for _, stmt := range cc.Body {
+			walk(stmt, 0, &reasons)
+		}
+		for _, e := range cc.List {
+			if lit, ok := e.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				name, _ := strconv.Unquote(lit.Value)
+				guards[name] = reasons
+			}
+		}
+		return false
+	})
+	return guards
+}
+
+// doubleOmits are the contract's reverts devVault can't run into, as its
+// adapter fills every slice in full and it holds no ether or tokens.
+var doubleOmits = map[string]bool{"INVALID_FILL": true, "ETH": true}
+
+func TestDevVaultGuardsFollowTwap(t *testing.T) {
+	want := twapGuards(twapSource(t))
+	got := devVaultGuards(t)
+	if len(want) == 0 {
+		t.Fatal("found no functions in Twap.sol")
+	}
+	for fn, reasons := range want {
+		var kept []string
+		for _, r := range reasons {
+			if !doubleOmits[r] {
+				kept = append(kept, r)
+			}
+		}
+		if !reflect.DeepEqual(got[fn], kept) {
+			t.Errorf("%s reverts:\n Twap.sol  %v\n devVault  %v", fn, kept, got[fn])
+		}
+	}
+}
+
+// parityVault is a devVault called directly, at a time of the test's
+// choosing.
+type parityVault struct {
+	t            *testing.T
+	chain        *devChain
+	v            *devVault
+	owner, agent common.Address
+	now          uint64
+}
+
+// parityStrategy is a valid three-slice order starting 100s after genesis.
+func parityStrategy() Strategy {
+	return Strategy{
+		TokenIn: devTokenIn, TokenOut: devTokenOut, Adapter: devAdapter, PriceOracle: devOracle,
+		TotalAmountIn: big.NewInt(3e18), SliceAmountIn: big.NewInt(1e18),
+		StartTime: big.NewInt(1100), EndTime: big.NewInt(1400),
+		MaxSlippageBps: 100, MaxPriceDeviationBps: 250,
+	}
+}
+
+// newParityVault is a vault with an agent and, unless s is nil, s
+// configured and unpaused.
+func newParityVault(t *testing.T, s *Strategy) *parityVault {
+	t.Helper()
+	cABI, err := twapbind.ParseABI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &parityVault{t: t, chain: newDevChain(cABI, 1000), now: 1000,
+		owner: common.HexToAddress("0x0a"), agent: common.HexToAddress("0x0b")}
+	p.v = newDevVault(p.chain, p.owner)
+	p.mustSend(p.owner, "setAgent", p.agent)
+	if s != nil {
+		p.mustSend(p.owner, "pause")
+		p.mustSend(p.owner, "configureStrategy", *s)
+		p.mustSend(p.owner, "unpause")
+	}
+	return p
+}
+
+// send calls method from from, committing it unless it reverts, and
+// returns the revert reason, "" if none.
+func (p *parityVault) send(from common.Address, method string, args ...interface{}) string {
+	p.t.Helper()
+	data, err := p.chain.cABI.Pack(method, args...)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	_, commit, err := p.v.call(from, data, p.now)
+	var rev devRevert
+	switch {
+	case errors.As(err, &rev):
+		return rev.reason
+	case err != nil:
+		p.t.Fatal(err)
+	case commit != nil:
+		commit()
+	}
+	return ""
+}
+
+func (p *parityVault) mustSend(from common.Address, method string, args ...interface{}) {
+	p.t.Helper()
+	if r := p.send(from, method, args...); r != "" {
+		p.t.Fatalf("%s reverted with %s", method, r)
+	}
+}
+
+// Every revert of Twap.sol's that the double can run into has a case here
+// raising it, so a new require fails this test until devVault has it too.
+func TestDevVaultRevertsLikeTwap(t *testing.T) {
+	// configure calls configureStrategy on a paused vault with s edited.
+	configure := func(edit func(*Strategy, *parityVault)) func(*parityVault) string {
+		return func(p *parityVault) string {
+			s := parityStrategy()
+			edit(&s, p)
+			p.mustSend(p.owner, "pause")
+			return p.send(p.owner, "configureStrategy", s)
+		}
+	}
+	execute := func(id int64) func(*parityVault) string {
+		return func(p *parityVault) string { return p.send(p.agent, "executeSlice", big.NewInt(id)) }
+	}
+	at := func(now uint64, then func(*parityVault) string) func(*parityVault) string {
+		return func(p *parityVault) string { p.now = now; return then(p) }
+	}
+	cases := map[string]func(*parityVault) string{
+		"setAgent/OwnableUnauthorizedAccount": func(p *parityVault) string { return p.send(p.agent, "setAgent", p.agent) },
+		"setAgent/AGENT_ZERO":                 func(p *parityVault) string { return p.send(p.owner, "setAgent", common.Address{}) },
+		"setAgent/AGENT_EQ_ADAPTER":           func(p *parityVault) string { return p.send(p.owner, "setAgent", devAdapter) },
+
+		"pause/OwnableUnauthorizedAccount": func(p *parityVault) string { return p.send(p.agent, "pause") },
+		"pause/EnforcedPause": func(p *parityVault) string {
+			p.mustSend(p.owner, "pause")
+			return p.send(p.owner, "pause")
+		},
+		"unpause/OwnableUnauthorizedAccount": func(p *parityVault) string { return p.send(p.agent, "unpause") },
+		"unpause/ExpectedPause":              func(p *parityVault) string { return p.send(p.owner, "unpause") },
+
+		"configureStrategy/OwnableUnauthorizedAccount": func(p *parityVault) string {
+			return p.send(p.agent, "configureStrategy", parityStrategy())
+		},
+		"configureStrategy/ExpectedPause": func(p *parityVault) string {
+			return p.send(p.owner, "configureStrategy", parityStrategy())
+		},
+		"configureStrategy/INVALID_TOKENS":      configure(func(s *Strategy, _ *parityVault) { s.TokenOut = common.Address{} }),
+		"configureStrategy/SAME_TOKEN":          configure(func(s *Strategy, _ *parityVault) { s.TokenOut = s.TokenIn }),
+		"configureStrategy/INVALID_ADDRESSES":   configure(func(s *Strategy, _ *parityVault) { s.PriceOracle = common.Address{} }),
+		"configureStrategy/INVALID_AMOUNTS":     configure(func(s *Strategy, _ *parityVault) { s.SliceAmountIn = new(big.Int) }),
+		"configureStrategy/INVALID_TIME_WINDOW": configure(func(s *Strategy, p *parityVault) { s.StartTime = new(big.Int).SetUint64(p.now) }),
+		"configureStrategy/INVALID_BPS":         configure(func(s *Strategy, _ *parityVault) { s.MaxPriceDeviationBps = DeviationBpsLimit + 1 }),
+		"configureStrategy/ADAPTER_EQ_AGENT":    configure(func(s *Strategy, p *parityVault) { s.Adapter = p.agent }),
+		"configureStrategy/NO_REFERENCE_PRICE": configure(func(_ *Strategy, p *parityVault) {
+			p.chain.setPrice(new(big.Int))
+		}),
+
+		"cancel/OwnableUnauthorizedAccount": func(p *parityVault) string { return p.send(p.agent, "cancel") },
+		"cancel/ORDER_TERMINATED": func(p *parityVault) string {
+			p.mustSend(p.owner, "cancel")
+			return p.send(p.owner, "cancel") // paused too: the status goes first
+		},
+		"cancel/EnforcedPause": func(p *parityVault) string {
+			p.mustSend(p.owner, "pause")
+			return p.send(p.owner, "cancel")
+		},
+
+		"sweep/OwnableUnauthorizedAccount": func(p *parityVault) string { return p.send(p.agent, "sweep", devTokenIn, p.agent) },
+		"sweep/INVALID_TO":                 func(p *parityVault) string { return p.send(p.owner, "sweep", devTokenIn, common.Address{}) },
+
+		"executeSlice/AGENT": at(1100, func(p *parityVault) string { return p.send(p.owner, "executeSlice", big.NewInt(0)) }),
+		"executeSlice/EnforcedPause": at(1100, func(p *parityVault) string {
+			p.mustSend(p.owner, "pause")
+			return execute(0)(p)
+		}),
+		"executeSlice/ORDER_TERMINATED": at(1100, func(p *parityVault) string {
+			p.mustSend(p.owner, "cancel")
+			p.mustSend(p.owner, "unpause")
+			return execute(0)(p)
+		}),
+		"executeSlice/INVALID_SLICE_ID": at(1400, execute(3)),
+		"executeSlice/SLICE_DONE": at(1100, func(p *parityVault) string {
+			p.mustSend(p.agent, "executeSlice", big.NewInt(0))
+			return execute(0)(p)
+		}),
+		"executeSlice/TOO_EARLY": at(1199, execute(1)),
+		"executeSlice/INVALID_PRICE": at(1100, func(p *parityVault) string {
+			p.chain.setPrice(new(big.Int))
+			return execute(0)(p)
+		}),
+		"executeSlice/PRICE_DEVIATION": at(1100, func(p *parityVault) string {
+			p.chain.setPrice(big.NewInt(21e17)) // 5% off the reference, past 2.5%
+			return execute(0)(p)
+		}),
+		"executeSlice/MIN_OUT_ZERO": func(p *parityVault) string {
+			// One wei at a price of 1 less 15% rounds down to nothing.
+			p.chain.setPrice(big.NewInt(1e18))
+			s := parityStrategy()
+			s.TotalAmountIn, s.SliceAmountIn, s.MaxSlippageBps = big.NewInt(3), big.NewInt(1), SlippageBpsLimit
+			p.mustSend(p.owner, "pause")
+			p.mustSend(p.owner, "configureStrategy", s)
+			p.mustSend(p.owner, "unpause")
+			p.now = 1100
+			return execute(0)(p)
+		},
+	}
+	// A vault with nothing remaining is Filled, and the dev adapter pays the
+	// oracle price: the double checks these, but they can't be reached.
+	unreachable := map[string]bool{"executeSlice/NOTHING_REMAINING": true, "executeSlice/SLIPPAGE": true}
+
+	for fn, reasons := range twapGuards(twapSource(t)) {
+		for _, r := range reasons {
+			name := fn + "/" + r
+			if doubleOmits[r] || unreachable[name] {
+				continue
+			}
+			run, ok := cases[name]
+			if !ok {
+				t.Errorf("%s: Twap.sol reverts with it, but no case here raises it", name)
+				continue
+			}
+			s := parityStrategy()
+			if got := run(newParityVault(t, &s)); got != r {
+				t.Errorf("%s: devVault reverted with %q", name, got)
+			}
+		}
+	}
+}
+
+// solidityLine is the first statement of src matching pattern, spaces
+// collapsed.
+func solidityLine(t *testing.T, src, pattern string) string {
+	t.Helper()
+	m := regexp.MustCompile(pattern).FindString(src)
+	if m == "" {
+		t.Fatalf("Twap.sol has no statement matching %s", pattern)
+	}
+	return strings.Join(strings.Fields(m), " ")
+}
+
+// The slice math the double and the agent share is Twap.sol's: the
+// formulas are pinned to the contract's source and then evaluated here on
+// their own against devVault's views.
+func TestDevVaultSliceMathFollowsTwap(t *testing.T) {
+	src := twapSource(t)
+	for pattern, want := range map[string]string{
+		`uint256 N = [^;]*;`:         "uint256 N = Math.ceilDiv(strategy.totalAmountIn, strategy.sliceAmountIn);",
+		`uint256 interval = [^;]*;`:  "uint256 interval = (strategy.endTime - strategy.startTime) / N;",
+		`uint256 scheduled = [^;]*;`: "uint256 scheduled = strategy.startTime + (interval * sliceId);",
+		`s\.maxSlippageBps <= [^"]*`: "s.maxSlippageBps <= 1500 && s.maxPriceDeviationBps <= 2500,",
+	} {
+		if got := solidityLine(t, src, pattern); got != want {
+			t.Errorf("Twap.sol now has %q, not %q: bring devchain.go and the agent's slice math in line", got, want)
+		}
+	}
+	if SlippageBpsLimit != 1500 || DeviationBpsLimit != 2500 {
+		t.Errorf("bps limits %d and %d, want Twap.sol's 1500 and 2500", SlippageBpsLimit, DeviationBpsLimit)
+	}
+
+	for _, c := range []struct {
+		total, slice, start, end int64
+	}{
+		{3e18, 1e18, 1100, 1400},
+		{10, 3, 1100, 1200}, // a short last slice
+		{5, 5, 1100, 1101},  // one slice
+		{7, 1, 1100, 1103},  // a window shorter than the slice count
+	} {
+		s := parityStrategy()
+		s.TotalAmountIn, s.SliceAmountIn = big.NewInt(c.total), big.NewInt(c.slice)
+		s.StartTime, s.EndTime = big.NewInt(c.start), big.NewInt(c.end)
+		p := newParityVault(t, &s)
+		n := (c.total + c.slice - 1) / c.slice
+		interval := (c.end - c.start) / n
+		if got := p.view("totalSlices"); got.(*big.Int).Int64() != n {
+			t.Errorf("%+v: totalSlices %v, want %d", c, got, n)
+		}
+		for id := int64(0); id <= n; id++ {
+			want := big.NewInt(c.start + interval*id)
+			if id == n {
+				want = abi.MaxUint256
+			}
+			if got := p.view("nextIntervalTimestamp", big.NewInt(id)); got.(*big.Int).Cmp(want) != 0 {
+				t.Errorf("%+v: nextIntervalTimestamp(%d) %v, want %v", c, id, got, want)
+			}
+		}
+	}
+}
+
+func (p *parityVault) view(name string, args ...interface{}) interface{} {
+	p.t.Helper()
+	out, err := p.v.view(name, args)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return out[0]
+}
+
+// devVault's statuses change where Twap.sol's do: Open on configuration,
+// PartialFilled and Filled with the fills, Cancelled (and paused) on
+// cancel, and Open again with the slices cleared on reconfiguration.
+func TestDevVaultStatusFollowsTwap(t *testing.T) {
+	src := twapSource(t)
+	assigns := regexp.MustCompile(`status = Status\.(\w+);`)
+	for fn, want := range map[string][]string{
+		"configureStrategy": {"Open"},
+		"cancel":            {"Cancelled"},
+		"executeSlice":      {"Filled", "PartialFilled", "Open"},
+	} {
+		i := regexp.MustCompile(`function ` + fn + `\b`).FindStringIndex(src)
+		if i == nil {
+			t.Fatalf("Twap.sol has no %s", fn)
+		}
+		var got []string
+		for _, m := range assigns.FindAllStringSubmatch(solidityBlock(src, i[1]), -1) {
+			got = append(got, m[1])
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Twap.sol's %s sets status %v, devVault follows %v", fn, got, want)
+		}
+	}
+
+	s := parityStrategy()
+	p := newParityVault(t, &s)
+	step := func(what string, want Status) {
+		t.Helper()
+		if p.v.status != want {
+			t.Errorf("after %s: %s, want %s", what, p.v.status, want)
+		}
+	}
+	step("configureStrategy", StatusOpen)
+	p.now = 1400
+	p.mustSend(p.agent, "executeSlice", big.NewInt(1))
+	step("a first fill", StatusPartialFilled)
+	p.mustSend(p.agent, "executeSlice", big.NewInt(0))
+	step("a second fill", StatusPartialFilled)
+	p.mustSend(p.agent, "executeSlice", big.NewInt(2))
+	step("the last fill", StatusFilled)
+	if r := p.send(p.owner, "cancel"); r != "ORDER_TERMINATED" {
+		t.Errorf("cancel of a Filled order: %q, want ORDER_TERMINATED", r)
+	}
+
+	p.mustSend(p.owner, "pause")
+	s.StartTime, s.EndTime = big.NewInt(1500), big.NewInt(1800)
+	p.mustSend(p.owner, "configureStrategy", s)
+	step("reconfiguration", StatusOpen)
+	if p.v.filled.Sign() != 0 || p.view("sliceDone", big.NewInt(0)).(bool) {
+		t.Errorf("reconfiguration kept filled %s or slice 0 done", p.v.filled)
+	}
+	p.mustSend(p.owner, "unpause")
+	p.mustSend(p.owner, "cancel")
+	step("cancel", StatusCancelled)
+	if !p.v.paused {
+		t.Error("cancel left the vault unpaused")
+	}
+}
@@ -0,0 +1,202 @@
+package twapagent
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"twap-agent/twapbind"
+)
+
+// DevnetConfig controls devnet mode.
+type DevnetConfig struct {
+	// Wall-clock time between the dev chain's blocks. Each block moves the
+	// chain's clock devBlockTime seconds, so the order runs that much faster.
+	BlockPeriod time.Duration
+}
+
+// The order devnet mode deploys: devSlices slices of 1 TIN, the first
+// devStartDelay seconds of chain time after deployment, one every
+// devInterval seconds.
+const (
+	devSlices     = 5
+	devStartDelay = 120
+	devInterval   = 60
+)
+
+var devSliceAmount = big.NewInt(1e18)
+
+// devnet is a dev chain with a configured vault on it, the harness behind
+// devnet mode and the package tests.
+type devnet struct {
+	chain *devChain
+	url   string
+	vault common.Address
+	owner *ecdsa.PrivateKey
+	agent *ecdsa.PrivateKey
+	stop  func()
+	// The artifact deploy deploys.
+	parsed abi.ABI
+	code   []byte
+}
+
+// startDevnet starts a dev chain and deploys a vault on it through deploy
+// mode, owned by a fresh key and with another as its agent. The ABI and
+// bytecode come from cfg.Deploy.Artifact when it can be read; the chain
+// runs its Twap double either way. Once the vault is configured, a block is
+// mined every cfg.Devnet.BlockPeriod, or only with transactions if that
+// is 0.
+func startDevnet(ctx context.Context, cfg Config) (*devnet, error) {
+	cABI, err := twapbind.ParseABI()
+	if err != nil {
+		return nil, fmt.Errorf("parse embedded abi: %w", err)
+	}
+	parsed, code, err := readArtifact(cfg.Deploy.Artifact)
+	if err != nil {
+		logf(ctx, "devnet: %v; deploying the embedded ABI instead", err)
+		parsed, code = cABI, []byte{0x60, 0x80}
+	}
+	d := &devnet{chain: newDevChain(cABI, uint64(time.Now().Unix())), parsed: parsed, code: code}
+	if d.owner, err = crypto.GenerateKey(); err != nil {
+		return nil, err
+	}
+	if d.agent, err = crypto.GenerateKey(); err != nil {
+		return nil, err
+	}
+	url, stopServing, err := serveDevChain(d.chain)
+	if err != nil {
+		return nil, fmt.Errorf("serve devnet: %w", err)
+	}
+	d.url, d.stop = url, stopServing
+	if d.vault, err = d.deploy(ctx, cfg, crypto.PubkeyToAddress(d.agent.PublicKey)); err != nil {
+		d.close()
+		return nil, err
+	}
+
+	d.mineEvery(ctx, cfg.Devnet.BlockPeriod)
+	return d, nil
+}
+
+// mineEvery mines a block every period until d is closed or ctx ends. With
+// period 0 blocks are only mined with transactions.
+func (d *devnet) mineEvery(ctx context.Context, period time.Duration) {
+	if period <= 0 {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(period)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				d.chain.mine()
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	stop := d.stop
+	d.stop = func() {
+		close(done)
+		stop()
+	}
+}
+
+func (d *devnet) close() { d.stop() }
+
+// deploy deploys and configures a vault through deploy mode, owned by
+// d.owner and executed by agent, with devnet mode's order starting
+// devStartDelay seconds from the chain's clock.
+func (d *devnet) deploy(ctx context.Context, cfg Config, agent common.Address) (common.Address, error) {
+	dcfg := d.config(cfg, d.owner)
+	dcfg.Mode, dcfg.Contracts = "deploy", nil
+	startTime := d.chain.headTime() + devStartDelay
+	dcfg.Deploy = DeployConfig{
+		TokenIn:         devTokenIn.Hex(),
+		TokenOut:        devTokenOut.Hex(),
+		Adapter:         devAdapter.Hex(),
+		Oracle:          devOracle.Hex(),
+		Agent:           agent.Hex(),
+		TotalAmount:     new(big.Int).Mul(devSliceAmount, big.NewInt(devSlices)),
+		SliceAmount:     devSliceAmount,
+		Start:           strconv.FormatUint(startTime, 10),
+		End:             strconv.FormatUint(startTime+devSlices*devInterval, 10),
+		MaxSlippageBps:  cfg.Deploy.MaxSlippageBps,
+		MaxDeviationBps: cfg.Deploy.MaxDeviationBps,
+		Yes:             true,
+	}
+	a, err := New(dcfg)
+	if err != nil {
+		return common.Address{}, err
+	}
+	defer a.Close()
+	return a.deployTwap(a.scope(ctx), d.parsed, d.code)
+}
+
+// config is cfg pointed at the dev chain and signing with key. It polls
+// for heads about twice a block and drives the bot by block, since the
+// chain's clock runs ahead of the wall clock.
+func (d *devnet) config(cfg Config, key *ecdsa.PrivateKey) Config {
+	cfg.RPC, cfg.TxRPC, cfg.RPCAuth = d.url, "", RPCAuthConfig{}
+	cfg.ChainID = devChainID
+	cfg.Signer = SignerConfig{Keys: KeySource{Hex: []string{hexutil.Encode(crypto.FromECDSA(key))}, HDPath: defaultHDPath}}
+	cfg.DefenderAPIKey, cfg.DefenderAPISecret, cfg.PrivateRPC = "", "", ""
+	cfg.ABIPath, cfg.ABISource = "", ""
+	cfg.Multicall = multicallOff
+	cfg.Driver.Driver = driverBlocks
+	cfg.Feed.Poll = true
+	if p := cfg.Devnet.BlockPeriod / 2; p > 0 {
+		cfg.Feed.PollInterval = p
+		cfg.Tx.ReceiptPollInterval = p
+	}
+	cfg.ReceiptsFile = ""
+	if d.vault != (common.Address{}) {
+		cfg.Contracts = []string{d.vault.Hex()}
+	}
+	return cfg
+}
+
+// botConfig is bot mode as the vault's agent, exiting when the order ends.
+func (d *devnet) botConfig(cfg Config) Config {
+	cfg = d.config(cfg, d.agent)
+	cfg.Mode = "bot"
+	cfg.End.ExitOnComplete = true
+	return cfg
+}
+
+// RunDevnet is devnet mode: it starts a dev chain in process, deploys and
+// configures a vault with a five-slice order on it, and runs bot mode as
+// its agent until the order is filled. The chain's RPC stays up meanwhile,
+// so other modes can be pointed at it.
+func RunDevnet(ctx context.Context, cfg Config) error {
+	d, err := startDevnet(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("devnet: %w", err)
+	}
+	defer d.close()
+	fmt.Fprintf(outputFrom(ctx), "Devnet: chain %d at %s, one block every %s (%ds of chain time)\n", devChainID, d.url, cfg.Devnet.BlockPeriod, devBlockTime)
+	fmt.Fprintf(outputFrom(ctx), "Devnet: vault %s, agent %s\n", d.vault.Hex(), crypto.PubkeyToAddress(d.agent.PublicKey).Hex())
+	a, err := New(d.botConfig(cfg))
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	err = a.Run(ctx)
+	var end *orderEnd
+	if errors.As(err, &end) && end.Code == ExitFilled {
+		fmt.Fprintln(outputFrom(ctx), "Devnet: order filled")
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	"twap-agent/twapbind"
+)
+
+// errDryRun is what a dryRunSigner returns instead of transact opts.
+var errDryRun = errors.New("--dry-run never signs")
+
+// dryRunSigner stands in for the agent key with --dry-run and no key: it has
+// an address to simulate and estimate from, and refuses to sign.
+type dryRunSigner struct {
+	addr common.Address
+}
+
+func (s dryRunSigner) Address() common.Address { return s.addr }
+
+func (s dryRunSigner) TransactOpts(context.Context, uint64) (*bind.TransactOpts, error) {
+	return nil, errDryRun
+}
+
+// dryRunSlice is execute() with --dry-run. It simulates executeSlice(sliceId),
+// prices and estimates it the way execute() does and prints the tx it would
+// submit, without signing or sending anything. The slice is marked submitted
+// so it is printed again only after --resubmit-after.
+func dryRunSlice(ctx context.Context, addr common.Address, cABI abi.ABI, twap *twapbind.Twap, client *ethclient.Client, txCfg TxConfig, st *botState, from common.Address, sliceId int64, overdue int64) {
+	defer st.submitted.Mark(sliceId, common.Hash{}, time.Now())
+	data, err := cABI.Pack("executeSlice", big.NewInt(sliceId))
+	if err != nil {
+		logf(ctx, "[dry-run] slice %d: pack executeSlice: %v", sliceId, err)
+		return
+	}
+	logf(ctx, "[dry-run] slice %d: from=%s to=%s data=%s", sliceId, from.Hex(), addr.Hex(), hexutil.Encode(data))
+
+	// Simulated even with --skip-simulation: checking slippage settings is
+	// much of the point of a dry run.
+	if err := simulateSlice(ctx, addr, cABI, client, from, sliceId, overdue < 0); err != nil {
+		logf(ctx, "[dry-run] slice %d: simulation failed, would not submit: %v", sliceId, err)
+		return
+	}
+	logf(ctx, "[dry-run] slice %d: simulation succeeded", sliceId)
+
+	quote, err := quoteGas(ctx, st.txClient, txCfg)
+	if errors.Is(err, errFeeCapTooLow) {
+		logf(ctx, "[dry-run] slice %d: would defer: %v", sliceId, err)
+		return
+	} else if err != nil && txCfg.hasCeiling() && overdue <= int64(txCfg.CeilingGrace) {
+		logf(ctx, "[dry-run] slice %d: would defer, gas ceiling can't be checked: %v", sliceId, err)
+		return
+	} else if err != nil {
+		logf(ctx, "[dry-run] slice %d: gas pricing error: %v", sliceId, err)
+	}
+	if price, ceiling, over := txCfg.checkCeiling(quote); over && overdue <= int64(txCfg.CeilingGrace) {
+		logf(ctx, "[dry-run] slice %d: would defer, gas too high: %s wei > ceiling %s wei", sliceId, price, ceiling)
+		return
+	}
+	auth := &bind.TransactOpts{From: from, Context: ctx}
+	quote.apply(auth)
+	gasSource, err := planGasLimit(twap, auth, txCfg, sliceId)
+	if err != nil {
+		logf(ctx, "[dry-run] slice %d: %v", sliceId, err)
+		return
+	}
+	if fees := quote.fees(); fees != "" {
+		logf(ctx, "[dry-run] slice %d: would submit with %s, gasLimit=%d (%s)", sliceId, fees, auth.GasLimit, gasSource)
+	} else {
+		logf(ctx, "[dry-run] slice %d: would submit with gasLimit=%d (%s)", sliceId, auth.GasLimit, gasSource)
+	}
+}
+
+// dryRunFrom is the address --dry-run simulates from without a key: --from,
+// or else the contract's agent, since executeSlice is onlyAgent.
+func dryRunFrom(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, from string) (common.Address, error) {
+	if from != "" {
+		if !common.IsHexAddress(from) {
+			return common.Address{}, fmt.Errorf("invalid --from address %q", from)
+		}
+		return common.HexToAddress(from), nil
+	}
+	outs, err := callView(ctx, addr, cABI, client, "agent")
+	if err != nil {
+		return common.Address{}, fmt.Errorf("read agent: %w", err)
+	}
+	return outs[0].(common.Address), nil
+}
@@ -0,0 +1,36 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestExecuteDryRunNeverSends(t *testing.T) {
+	h := newExecuteHarness(t)
+	h.cfg.DryRun = true
+	h.cfg.ResubmitAfter = time.Minute
+	signer := dryRunSigner{common.HexToAddress("0x00000000000000000000000000000000000000bb")}
+	st := h.state(t, signer)
+
+	h.executor(signer, h.cfg, st).execute(context.Background(), 3, 0)
+
+	if n := len(h.eth.sentTxs()); n != 0 {
+		t.Fatalf("dry run sent %d txs", n)
+	}
+	if _, ok := st.submitted.Pending(3, h.cfg.ResubmitAfter, time.Now()); !ok {
+		t.Fatal("dry-run slice not marked, it would be printed on every evaluation")
+	}
+	if sum := st.ledger.Summary(h.addr); sum.Txs != 0 {
+		t.Fatalf("ledger txs = %d after a dry run, want 0", sum.Txs)
+	}
+}
+
+func TestDryRunSignerRefusesToSign(t *testing.T) {
+	if _, err := (dryRunSigner{}).TransactOpts(context.Background(), fakeChainID); !errors.Is(err, errDryRun) {
+		t.Fatalf("TransactOpts err = %v, want errDryRun", err)
+	}
+}
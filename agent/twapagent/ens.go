@@ -0,0 +1,90 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// ensRegistry is the ENS registry, deployed at the same address on mainnet
+// and the public testnets.
+var ensRegistry = common.HexToAddress("0x00000000000C2E074eC69A0dFb2997BA6C7d2e1e")
+
+var (
+	ensResolverSelector = crypto.Keccak256([]byte("resolver(bytes32)"))[:4]
+	ensAddrSelector     = crypto.Keccak256([]byte("addr(bytes32)"))[:4]
+)
+
+// resolveAddress accepts a hex address or an ENS name and returns the
+// address. flagName is only used in error messages.
+func resolveAddress(ctx context.Context, client *ethclient.Client, flagName, s string) (common.Address, error) {
+	if common.IsHexAddress(s) {
+		return common.HexToAddress(s), nil
+	}
+	if !strings.Contains(s, ".") {
+		return common.Address{}, fmt.Errorf("%s %q is neither a hex address nor an ENS name", flagName, s)
+	}
+	addr, err := resolveENS(ctx, client, s)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("%s: %w", flagName, err)
+	}
+	return addr, nil
+}
+
+// resolveENS looks up name's resolver in the registry and asks it for the
+// name's address.
+func resolveENS(ctx context.Context, client *ethclient.Client, name string) (common.Address, error) {
+	node := ensNamehash(name)
+	resolver, err := ensCallAddress(ctx, client, ensRegistry, ensResolverSelector, node)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("ens resolver(%s): %w", name, err)
+	}
+	if resolver == (common.Address{}) {
+		return common.Address{}, fmt.Errorf("ens name %s has no resolver on this chain", name)
+	}
+	addr, err := ensCallAddress(ctx, client, resolver, ensAddrSelector, node)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("ens addr(%s) on resolver %s: %w", name, resolver.Hex(), err)
+	}
+	if addr == (common.Address{}) {
+		return common.Address{}, fmt.Errorf("ens name %s resolves to the zero address", name)
+	}
+	return addr, nil
+}
+
+// ensCallAddress calls a `f(bytes32) returns (address)` function.
+func ensCallAddress(ctx context.Context, client *ethclient.Client, to common.Address, selector []byte, node common.Hash) (common.Address, error) {
+	data := append(append([]byte{}, selector...), node.Bytes()...)
+	res, err := client.CallContract(ctx, ethereum.CallMsg{To: &to, Data: data}, nil)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if len(res) == 0 {
+		// No code at `to`: the registry isn't deployed on this chain.
+		return common.Address{}, nil
+	}
+	if len(res) != 32 {
+		return common.Address{}, fmt.Errorf("unexpected %d-byte result", len(res))
+	}
+	return common.BytesToAddress(res), nil
+}
+
+// ensNamehash implements EIP-137 namehash. Names are only lowercased, not
+// fully UTS-46 normalized, which covers the ASCII names we use.
+func ensNamehash(name string) common.Hash {
+	var node common.Hash
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return node
+	}
+	labels := strings.Split(name, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		node = crypto.Keccak256Hash(node.Bytes(), crypto.Keccak256([]byte(labels[i])))
+	}
+	return node
+}
@@ -0,0 +1,84 @@
+package twapagent
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestENSNamehash(t *testing.T) {
+	// Vectors from EIP-137.
+	for name, want := range map[string]string{
+		"":        "0x0000000000000000000000000000000000000000000000000000000000000000",
+		"eth":     "0x93cdeb708b7545dc668eb9280176169d1c33cfd8ed6f04690a0bcc88a93fc4ae",
+		"foo.eth": "0xde9b09fd7c5f901e23a3f19fecc54828e9c848539801e86591bd9801b019f84f",
+		"FOO.eth": "0xde9b09fd7c5f901e23a3f19fecc54828e9c848539801e86591bd9801b019f84f",
+	} {
+		if got := ensNamehash(name).Hex(); got != want {
+			t.Errorf("namehash(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+// fakeENS serves the registry's resolver() and one resolver's addr().
+type fakeENS struct {
+	resolvers map[common.Hash]common.Address
+	addrs     map[common.Hash]common.Address
+}
+
+func (f *fakeENS) Call(args fakeCallArgs, _ string) hexutil.Bytes {
+	if args.To == nil || len(args.Data) != 36 {
+		return nil
+	}
+	node := common.BytesToHash(args.Data[4:])
+	switch {
+	case *args.To == ensRegistry && bytes.Equal(args.Data[:4], ensResolverSelector):
+		return common.LeftPadBytes(f.resolvers[node].Bytes(), 32)
+	case bytes.Equal(args.Data[:4], ensAddrSelector):
+		if f.resolvers[node] != *args.To {
+			return nil
+		}
+		return common.LeftPadBytes(f.addrs[node].Bytes(), 32)
+	}
+	return nil
+}
+
+func TestResolveAddress(t *testing.T) {
+	resolver := common.HexToAddress("0x4976fb03C32e5B8cfe2b6cCB31c09Ba78EBaBa41")
+	vault := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	named := ensNamehash("eth-usdc-twap.ourdao.eth")
+	unset := ensNamehash("unset.ourdao.eth")
+	ens := &fakeENS{
+		resolvers: map[common.Hash]common.Address{named: resolver, unset: resolver},
+		addrs:     map[common.Hash]common.Address{named: vault},
+	}
+	client := dialFakeEth(t, ens)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		in      string
+		want    common.Address
+		wantErr string
+	}{
+		{vault.Hex(), vault, ""},
+		{"eth-usdc-twap.ourdao.eth", vault, ""},
+		{"unset.ourdao.eth", common.Address{}, "resolves to the zero address"},
+		{"missing.ourdao.eth", common.Address{}, "has no resolver"},
+		{"0x1234", common.Address{}, "neither a hex address nor an ENS name"},
+	} {
+		got, err := resolveAddress(ctx, client, "--contract", tc.in)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("resolveAddress(%q) err = %v, want %q", tc.in, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("resolveAddress(%q) = %s, %v; want %s", tc.in, got.Hex(), err, tc.want.Hex())
+		}
+	}
+}
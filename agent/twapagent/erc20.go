@@ -0,0 +1,173 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// erc20ABIJSON is the part of ERC-20 the agent reads and sends.
+const erc20ABIJSON = `[
+	{"type":"function","name":"balanceOf","stateMutability":"view","inputs":[{"name":"owner","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"allowance","stateMutability":"view","inputs":[{"name":"owner","type":"address"},{"name":"spender","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"approve","stateMutability":"nonpayable","inputs":[{"name":"spender","type":"address"},{"name":"amount","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]},
+	{"type":"function","name":"transfer","stateMutability":"nonpayable","inputs":[{"name":"to","type":"address"},{"name":"amount","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]},
+	{"type":"function","name":"symbol","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"string"}]},
+	{"type":"function","name":"decimals","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint8"}]},
+	{"type":"event","name":"Transfer","anonymous":false,"inputs":[{"name":"from","type":"address","indexed":true},{"name":"to","type":"address","indexed":true},{"name":"value","type":"uint256","indexed":false}]}
+]`
+
+var erc20ABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(erc20ABIJSON))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// erc20Bytes32SymbolABI is symbol() as early tokens such as MKR declare it.
+var erc20Bytes32SymbolABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(`[{"type":"function","name":"symbol","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"bytes32"}]}]`))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// readTokenBalance reads token.balanceOf(holder).
+func readTokenBalance(ctx context.Context, client *ethclient.Client, token, holder common.Address) (*big.Int, error) {
+	outs, err := callView(ctx, token, erc20ABI, client, "balanceOf", holder)
+	if err != nil {
+		return nil, fmt.Errorf("balanceOf %s: %w", token.Hex(), err)
+	}
+	return outs[0].(*big.Int), nil
+}
+
+// tokenInfo is what amounts of a token are printed with.
+type tokenInfo struct {
+	Symbol   string
+	Decimals uint8
+	Known    bool // decimals() answered
+}
+
+// readTokenInfo reads symbol() and decimals(). Both are optional in ERC-20:
+// a token without decimals() is shown with raw amounts, and one without a
+// symbol by its address.
+func readTokenInfo(ctx context.Context, client *ethclient.Client, token common.Address) tokenInfo {
+	info := tokenInfo{Symbol: token.Hex()}
+	dec, err := callView(ctx, token, erc20ABI, client, "decimals")
+	if err != nil {
+		return info
+	}
+	info.Decimals, info.Known = dec[0].(uint8), true
+	if sym := readTokenSymbol(ctx, client, token); sym != "" {
+		info.Symbol = sym
+	}
+	return info
+}
+
+// readTokenSymbol reads symbol() as a string, or as the bytes32 some older
+// tokens return; "" if the token has neither.
+func readTokenSymbol(ctx context.Context, client *ethclient.Client, token common.Address) string {
+	if out, err := callView(ctx, token, erc20ABI, client, "symbol"); err == nil {
+		return strings.TrimSpace(out[0].(string))
+	}
+	out, err := callView(ctx, token, erc20Bytes32SymbolABI, client, "symbol")
+	if err != nil {
+		return ""
+	}
+	b := out[0].([32]byte)
+	return strings.TrimSpace(strings.TrimRight(string(b[:]), "\x00"))
+}
+
+// tokenCache reads each token's symbol and decimals once, for the
+// notifiers and the amounts the modes print.
+type tokenCache struct {
+	client *ethclient.Client
+	mu     sync.Mutex
+	m      map[common.Address]tokenInfo
+}
+
+func newTokenCache(client *ethclient.Client) *tokenCache {
+	return &tokenCache{client: client, m: map[common.Address]tokenInfo{}}
+}
+
+func (c *tokenCache) get(ctx context.Context, token common.Address) tokenInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info, ok := c.m[token]
+	if !ok {
+		if c.client == nil {
+			return tokenInfo{Symbol: token.Hex()}
+		}
+		info = readTokenInfo(ctx, c.client, token)
+		c.m[token] = info
+	}
+	return info
+}
+
+// format renders v in whole token units, e.g. "1.5 WETH".
+func (t tokenInfo) format(v *big.Int) string {
+	if !t.Known {
+		return fmt.Sprintf("%s (raw) %s", v, t.Symbol)
+	}
+	return fmt.Sprintf("%s %s", formatUnits(v, t.Decimals), t.Symbol)
+}
+
+// formatUnits renders v / 10^decimals without rounding or trailing zeros.
+func formatUnits(v *big.Int, decimals uint8) string {
+	if v == nil {
+		return "0"
+	}
+	neg := v.Sign() < 0
+	digits := new(big.Int).Abs(v).String()
+	d := int(decimals)
+	if len(digits) <= d {
+		digits = strings.Repeat("0", d-len(digits)+1) + digits
+	}
+	whole, frac := digits[:len(digits)-d], strings.TrimRight(digits[len(digits)-d:], "0")
+	out := whole
+	if frac != "" {
+		out += "." + frac
+	}
+	if neg {
+		out = "-" + out
+	}
+	return out
+}
+
+// tokenTransfer is a decoded ERC-20 Transfer event.
+type tokenTransfer struct {
+	Token, From, To common.Address
+	Value           *big.Int
+}
+
+// transfersFromLogs decodes the ERC-20 Transfer events in logs.
+func transfersFromLogs(logs []*types.Log) []tokenTransfer {
+	ev := erc20ABI.Events["Transfer"]
+	var out []tokenTransfer
+	for _, lg := range logs {
+		// ERC-721 Transfer has the same signature with the id indexed.
+		if len(lg.Topics) != 3 || lg.Topics[0] != ev.ID {
+			continue
+		}
+		vals, err := ev.Inputs.NonIndexed().Unpack(lg.Data)
+		if err != nil || len(vals) != 1 {
+			continue
+		}
+		out = append(out, tokenTransfer{
+			Token: lg.Address,
+			From:  common.BytesToAddress(lg.Topics[1].Bytes()),
+			To:    common.BytesToAddress(lg.Topics[2].Bytes()),
+			Value: vals[0].(*big.Int),
+		})
+	}
+	return out
+}
@@ -0,0 +1,123 @@
+package twapagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Types of the records written to --events-out.
+const (
+	evHead        = "head"
+	evDecision    = "decision"
+	evTxSubmitted = "tx_submitted"
+	evTxMined     = "tx_mined"
+	evTxFailed    = "tx_failed"
+	evFill        = "fill"
+	evOrderStatus = "order_status"
+	// A change of the order's status, whether from an event or a read.
+	evStatusChanged = "status_changed"
+	// The bot's report of the order's end: filled, cancelled or expired.
+	evOrderEnded = "order_ended"
+	evReconnect  = "reconnect"
+	evError      = "error"
+	// The oracle kill switch stopping and resuming submissions.
+	evHalted  = "halted"
+	evResumed = "resumed"
+	// The operator pausing and resuming submissions.
+	evPaused   = "paused"
+	evUnpaused = "unpaused"
+	// The retry tracker giving up on a slice, and its circuit breaker
+	// stopping all submissions.
+	evGaveUp         = "gave_up"
+	evBreakerTripped = "breaker_tripped"
+)
+
+// eventRecord is one NDJSON line. Amounts in Data are decimal strings.
+type eventRecord struct {
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Block uint64    `json:"block,omitempty"`
+	// The vault, when bot mode runs several.
+	Contract string                 `json:"contract,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+// eventLog writes eventRecords to --events-out, one JSON object per line.
+// The bot's goroutines share it.
+type eventLog struct {
+	mu  sync.Mutex
+	w   io.WriteCloser
+	enc *json.Encoder
+}
+
+// openEventLog appends to path, or writes to stdout for "-".
+func openEventLog(path string, stdout io.Writer) (*eventLog, error) {
+	var w io.WriteCloser = nopCloser{stdout}
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open events-out: %w", err)
+		}
+		w = f
+	}
+	return &eventLog{w: w, enc: json.NewEncoder(w)}, nil
+}
+
+func (l *eventLog) Close() error { return l.w.Close() }
+
+func (l *eventLog) emit(ctx context.Context, typ string, block uint64, contract string, data map[string]interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(eventRecord{Type: typ, Time: time.Now().UTC(), Block: block, Contract: contract, Data: data}); err != nil {
+		warnf(ctx, "events-out: %v", err)
+	}
+}
+
+type eventLogKey struct{}
+
+func withEventLog(ctx context.Context, l *eventLog) context.Context {
+	return context.WithValue(ctx, eventLogKey{}, l)
+}
+
+// eventTapsKey carries funcs that see every record, whether or not there is
+// an event log: the status API's.
+type eventTapsKey struct{}
+
+// withEventTap adds tap to ctx's taps. It is called from the goroutine that
+// emits the record, so it must not block.
+func withEventTap(ctx context.Context, tap func(eventRecord)) context.Context {
+	taps, _ := ctx.Value(eventTapsKey{}).([]func(eventRecord))
+	return context.WithValue(ctx, eventTapsKey{}, append(taps[:len(taps):len(taps)], tap))
+}
+
+// emitEvent writes a record to ctx's event log, if there is one, and hands
+// it to ctx's taps. block is 0 for records not tied to a block. A labelled
+// ctx names the contract.
+func emitEvent(ctx context.Context, typ string, block uint64, data map[string]interface{}) {
+	l, _ := ctx.Value(eventLogKey{}).(*eventLog)
+	taps, _ := ctx.Value(eventTapsKey{}).([]func(eventRecord))
+	if l == nil && len(taps) == 0 {
+		return
+	}
+	var contract string
+	if addr, ok := contractLabel(ctx); ok {
+		contract = addr.Hex()
+	}
+	if l != nil {
+		l.emit(ctx, typ, block, contract, data)
+	}
+	for _, tap := range taps {
+		tap(eventRecord{Type: typ, Time: time.Now().UTC(), Block: block, Contract: contract, Data: data})
+	}
+}
+
+func emitTxFailed(ctx context.Context, sliceId int64, tx common.Hash, reason string) {
+	emitEvent(ctx, evTxFailed, 0, map[string]interface{}{"slice": sliceId, "tx": tx.Hex(), "reason": reason})
+}
@@ -0,0 +1,67 @@
+package twapagent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmitEventWritesNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := openEventLog("-", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := withEventLog(context.Background(), l)
+	emitEvent(ctx, evHead, 12, map[string]interface{}{"time": 1000})
+	emitEvent(ctx, evDecision, 12, map[string]interface{}{"slice": 3, "action": "submit"})
+
+	sc := bufio.NewScanner(&buf)
+	var recs []eventRecord
+	for sc.Scan() {
+		var r eventRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		recs = append(recs, r)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("got %d records, want 2", len(recs))
+	}
+	if recs[0].Type != evHead || recs[0].Block != 12 || recs[0].Time.IsZero() {
+		t.Errorf("head record = %+v", recs[0])
+	}
+	if recs[1].Type != evDecision || recs[1].Data["action"] != "submit" {
+		t.Errorf("decision record = %+v", recs[1])
+	}
+}
+
+func TestEmitEventWithoutLog(t *testing.T) {
+	// Must not panic when --events-out is unset.
+	emitEvent(context.Background(), evError, 0, map[string]interface{}{"error": "x"})
+}
+
+func TestOpenEventLogAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	for i := 0; i < 2; i++ {
+		l, err := openEventLog(path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.emit(context.Background(), evReconnect, 0, "", map[string]interface{}{"reconnects": i})
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(data, []byte("\n")); n != 2 {
+		t.Errorf("got %d lines, want 2:\n%s", n, data)
+	}
+}
@@ -0,0 +1,315 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// EventsConfig controls events mode's backfill.
+type EventsConfig struct {
+	// First and last block to read; -1 means near startTime and latest.
+	FromBlock, ToBlock int64
+	// Blocks per eth_getLogs request. Halved when the provider refuses a range.
+	ChunkBlocks uint64
+	// Keep printing new events once the backfill is done.
+	Follow bool
+}
+
+// decodeEvent unpacks lg into its ABI event and the values of all its
+// arguments, indexed ones from the topics and the rest from the data.
+func decodeEvent(cABI abi.ABI, lg types.Log) (*abi.Event, map[string]interface{}, error) {
+	if len(lg.Topics) == 0 {
+		return nil, nil, fmt.Errorf("anonymous log")
+	}
+	ev, err := cABI.EventByID(lg.Topics[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("unknown event topic %s", lg.Topics[0].Hex())
+	}
+	values := map[string]interface{}{}
+	if len(lg.Data) > 0 {
+		if err := ev.Inputs.NonIndexed().UnpackIntoMap(values, lg.Data); err != nil {
+			return ev, nil, fmt.Errorf("unpack %s data: %w", ev.Name, err)
+		}
+	}
+	indexed, err := indexedArgs(ev, lg)
+	if err == nil {
+		err = abi.ParseTopicsIntoMap(values, indexed, lg.Topics[1:])
+	}
+	if err != nil {
+		return ev, nil, fmt.Errorf("unpack %s topics: %w", ev.Name, err)
+	}
+	return ev, values, nil
+}
+
+// unpackLog fills out, a struct such as FillEvent, from lg, an event name
+// of cABI: the non-indexed arguments from its data and the indexed ones
+// from its topics, so it decodes however the contract indexes the event.
+func unpackLog(cABI abi.ABI, out interface{}, name string, lg types.Log) error {
+	ev, ok := cABI.Events[name]
+	if !ok {
+		return fmt.Errorf("no %s event in the ABI", name)
+	}
+	if len(ev.Inputs.NonIndexed()) > 0 {
+		if err := cABI.UnpackIntoInterface(out, name, lg.Data); err != nil {
+			return fmt.Errorf("unpack %s data: %w", name, err)
+		}
+	}
+	indexed, err := indexedArgs(&ev, lg)
+	if err == nil {
+		err = abi.ParseTopics(out, indexed, lg.Topics[1:])
+	}
+	if err != nil {
+		return fmt.Errorf("unpack %s topics: %w", name, err)
+	}
+	return nil
+}
+
+// indexedArgs is ev's indexed arguments, which lg must have a topic for
+// each of after the event's ID.
+func indexedArgs(ev *abi.Event, lg types.Log) (abi.Arguments, error) {
+	var indexed abi.Arguments
+	for _, in := range ev.Inputs {
+		if in.Indexed {
+			indexed = append(indexed, in)
+		}
+	}
+	if len(lg.Topics) != len(indexed)+1 {
+		return nil, fmt.Errorf("%d topics for %d indexed arguments", len(lg.Topics)-1, len(indexed))
+	}
+	return indexed, nil
+}
+
+// formatEvent renders a decoded event as "Name: k=v ...", in ABI order,
+// with the vault's tokenIn and tokenOut amounts as a prints them. The fee is
+// taken from the input side, so it prints in tokenIn.
+func formatEvent(ev *abi.Event, values map[string]interface{}, a orderAmounts) string {
+	parts := make([]string, 0, len(ev.Inputs))
+	for _, in := range ev.Inputs {
+		v := values[in.Name]
+		switch x := v.(type) {
+		case common.Address:
+			v = x.Hex()
+		case [32]byte:
+			v = common.Hash(x).Hex()
+		case []byte:
+			v = common.Bytes2Hex(x)
+		case uint8:
+			if ev.Name == "OrderStatus" && in.Name == "status" {
+				v = Status(x)
+			}
+		case *big.Int:
+			switch in.Name {
+			case "amountIn", "filledAmountIn", "fee":
+				v = a.In(x)
+			case "amountOut", "receivedAmountOut":
+				v = a.Out(x)
+			}
+		}
+		parts = append(parts, fmt.Sprintf("%s=%v", in.Name, v))
+	}
+	return ev.Name + ": " + strings.Join(parts, " ")
+}
+
+// blockTimes caches block timestamps, so a block with several events costs
+// one header read.
+type blockTimes struct {
+	client *ethclient.Client
+	times  map[uint64]uint64
+}
+
+func newBlockTimes(client *ethclient.Client) *blockTimes {
+	return &blockTimes{client: client, times: map[uint64]uint64{}}
+}
+
+func (b *blockTimes) get(ctx context.Context, n uint64) (uint64, error) {
+	if t, ok := b.times[n]; ok {
+		return t, nil
+	}
+	h, err := headerByNumber(ctx, b.client, new(big.Int).SetUint64(n))
+	if err != nil {
+		return 0, fmt.Errorf("header %d: %w", n, err)
+	}
+	b.times[n] = h.Time
+	return h.Time, nil
+}
+
+// blockAtTime returns the first block at or before latest whose timestamp is
+// at least t, by binary search over header timestamps; latest if none is.
+func blockAtTime(ctx context.Context, times *blockTimes, t uint64, latest uint64) (uint64, error) {
+	lo, hi := uint64(0), latest
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		mt, err := times.get(ctx, mid)
+		if err != nil {
+			return 0, err
+		}
+		if mt >= t {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo, nil
+}
+
+// fetchLogs reads addrs' logs in [from, to] in ranges of at most *chunk
+// blocks. A refused range is retried at half the size, and the smaller size
+// is kept for the rest, since providers cap eth_getLogs ranges or result
+// sizes.
+func fetchLogs(ctx context.Context, client *ethclient.Client, addrs []common.Address, topics [][]common.Hash, from, to uint64, chunk *uint64, onChunk func([]types.Log) error) error {
+	if *chunk == 0 {
+		*chunk = 1
+	}
+	for start := from; start <= to; {
+		end := to
+		if to-start >= *chunk {
+			end = start + *chunk - 1
+		}
+		var logs []types.Log
+		err := rpcRead(ctx, "eth_getLogs", func(ctx context.Context) (err error) {
+			logs, err = client.FilterLogs(ctx, ethereum.FilterQuery{
+				FromBlock: new(big.Int).SetUint64(start),
+				ToBlock:   new(big.Int).SetUint64(end),
+				Addresses: addrs,
+				Topics:    topics,
+			})
+			return err
+		})
+		if err != nil {
+			if *chunk == 1 || ctx.Err() != nil {
+				return fmt.Errorf("logs %d-%d: %w", start, end, err)
+			}
+			*chunk /= 2
+			logf(ctx, "logs %d-%d: %v; retrying %d blocks at a time", start, end, err, *chunk)
+			continue
+		}
+		if err := onChunk(logs); err != nil {
+			return err
+		}
+		start = end + 1
+	}
+	return nil
+}
+
+// printEventLog prints one contract log with its block, time and tx.
+func printEventLog(ctx context.Context, cABI abi.ABI, times *blockTimes, a orderAmounts, lg types.Log) error {
+	t, err := times.get(ctx, lg.BlockNumber)
+	if err != nil {
+		return err
+	}
+	var line string
+	ev, values, err := decodeEvent(cABI, lg)
+	switch {
+	case ev == nil:
+		line = err.Error()
+	case err != nil:
+		line = fmt.Sprintf("%s (%v)", ev.Name, err)
+	default:
+		line = formatEvent(ev, values, a)
+	}
+	if lg.Removed {
+		line += " [removed by reorg]"
+	}
+	fmt.Fprintf(outputFrom(ctx), "#%d %s %s [Event] %s\n", lg.BlockNumber, time.Unix(int64(t), 0).UTC().Format(time.RFC3339), lg.TxHash.Hex(), line)
+	return nil
+}
+
+// events is events mode: it prints every event the vault emitted between
+// cfg.FromBlock and cfg.ToBlock in chain order. The default start is the
+// first block at the strategy's startTime. With cfg.Follow it then prints new
+// events as they arrive.
+func events(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, feedCfg FeedConfig, cfg EventsConfig) error {
+	if cfg.Follow && cfg.ToBlock >= 0 {
+		return fmt.Errorf("--follow and --to-block are mutually exclusive")
+	}
+	var feed *chainFeed
+	if cfg.Follow {
+		// Subscribe before the backfill reads the head, so no block falls
+		// between the two.
+		f, err := openFeed(ctx, client, []common.Address{addr}, feedCfg, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		feed = f
+	}
+	head, err := headerByNumber(ctx, client, nil)
+	if err != nil {
+		return fmt.Errorf("latest header: %w", err)
+	}
+	latest := head.Number.Uint64()
+	times := newBlockTimes(client)
+	times.times[latest] = head.Time
+
+	to := latest
+	if cfg.ToBlock >= 0 {
+		if uint64(cfg.ToBlock) > latest {
+			return fmt.Errorf("--to-block %d is past the latest block %d", cfg.ToBlock, latest)
+		}
+		to = uint64(cfg.ToBlock)
+	}
+	// The strategy gives the default start and the tokens amounts are in.
+	s, err := readStrategy(ctx, addr, cABI, client)
+	if err != nil && cfg.FromBlock < 0 {
+		return fmt.Errorf("read strategy: %w", err)
+	}
+	amounts := orderAmountsFor(ctx, s)
+	var from uint64
+	if cfg.FromBlock >= 0 {
+		from = uint64(cfg.FromBlock)
+	} else {
+		if s.StartTime != nil && s.StartTime.IsUint64() && s.StartTime.Sign() > 0 {
+			if from, err = blockAtTime(ctx, times, s.StartTime.Uint64(), latest); err != nil {
+				return fmt.Errorf("find the block at startTime: %w", err)
+			}
+		}
+	}
+	if from > to {
+		return fmt.Errorf("--from-block %d is after the last block %d", from, to)
+	}
+
+	fmt.Fprintf(outputFrom(ctx), "Events of %s in blocks %d-%d\n", addr.Hex(), from, to)
+	count := 0
+	chunk := cfg.ChunkBlocks
+	err = fetchLogs(ctx, client, []common.Address{addr}, nil, from, to, &chunk, func(logs []types.Log) error {
+		for _, lg := range logs {
+			if err := printEventLog(ctx, cABI, times, amounts, lg); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(outputFrom(ctx), "%d events\n", count)
+	if feed == nil {
+		return nil
+	}
+
+	fmt.Fprintf(outputFrom(ctx), "Following %s\n", addr.Hex())
+	for {
+		select {
+		case <-ctx.Done():
+			return errInterrupted
+		case <-feed.Heads():
+		case lg := <-feed.Logs():
+			if !lg.Removed && lg.BlockNumber <= to {
+				continue // already printed by the backfill
+			}
+			if err := printEventLog(ctx, cABI, times, amounts, lg); err != nil {
+				logf(ctx, "event at block %d: %v", lg.BlockNumber, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestDecodeEvent(t *testing.T) {
+	cABI, _, err := loadTwapABI("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := cABI.Events["OrderStatus"]
+	data, err := ev.Inputs.Pack(big.NewInt(300), big.NewInt(600), big.NewInt(3), uint8(StatusPartialFilled))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, values, err := decodeEvent(cABI, types.Log{Topics: []common.Hash{ev.ID}, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line := formatEvent(got, values, orderAmounts{}); line != "OrderStatus: filledAmountIn=300 receivedAmountOut=600 fee=3 status=PartialFilled" {
+		t.Fatalf("formatted %q", line)
+	}
+	a := orderAmounts{in: tokenInfo{Symbol: "TIN", Decimals: 2, Known: true}, out: tokenInfo{Symbol: "TOUT", Decimals: 3, Known: true}}
+	if line := formatEvent(got, values, a); line != "OrderStatus: filledAmountIn=3 TIN receivedAmountOut=0.6 TOUT fee=0.03 TIN status=PartialFilled" {
+		t.Fatalf("formatted with token amounts %q", line)
+	}
+
+	if _, _, err := decodeEvent(cABI, types.Log{Topics: []common.Hash{{0xab}}}); err == nil || !strings.Contains(err.Error(), "unknown event topic") {
+		t.Fatalf("unknown topic: %v", err)
+	}
+}
+
+func TestDecodeEventIndexed(t *testing.T) {
+	// A Fill with sliceId moved into the topics.
+	indexedABI, err := abi.JSON(strings.NewReader(`[{"type":"event","name":"Fill","inputs":[
+		{"name":"sliceId","type":"uint256","indexed":true},
+		{"name":"amountIn","type":"uint256"},{"name":"amountOut","type":"uint256"},{"name":"fee","type":"uint256"}]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := indexedABI.Events["Fill"]
+	data, err := ev.Inputs.NonIndexed().Pack(big.NewInt(100), big.NewInt(200), big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lg := types.Log{Topics: []common.Hash{ev.ID, common.BigToHash(big.NewInt(7))}, Data: data}
+	got, values, err := decodeEvent(indexedABI, lg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line := formatEvent(got, values, orderAmounts{}); line != "Fill: sliceId=7 amountIn=100 amountOut=200 fee=1" {
+		t.Fatalf("formatted %q", line)
+	}
+	a := orderAmounts{in: tokenInfo{Symbol: "TIN", Decimals: 2, Known: true}, out: tokenInfo{Symbol: "TOUT", Decimals: 3, Known: true}}
+	if line := formatEvent(got, values, a); line != "Fill: sliceId=7 amountIn=1 TIN amountOut=0.2 TOUT fee=0.01 TIN" {
+		t.Fatalf("formatted with token amounts %q", line)
+	}
+}
+
+// unpackLog gives the same FillEvent however the ABI indexes Fill.
+func TestUnpackLogFill(t *testing.T) {
+	want := FillEvent{SliceId: big.NewInt(7), AmountIn: big.NewInt(100), AmountOut: big.NewInt(200), Fee: big.NewInt(1)}
+	args := map[string]*big.Int{"sliceId": want.SliceId, "amountIn": want.AmountIn, "amountOut": want.AmountOut, "fee": want.Fee}
+	for _, c := range []struct {
+		name    string
+		indexed []string
+	}{
+		{"none indexed", nil},
+		{"sliceId indexed", []string{"sliceId"}},
+		{"sliceId and fee indexed", []string{"sliceId", "fee"}},
+		{"three indexed", []string{"sliceId", "amountIn", "amountOut"}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var inputs []string
+			for _, name := range []string{"sliceId", "amountIn", "amountOut", "fee"} {
+				indexed := false
+				for _, i := range c.indexed {
+					indexed = indexed || i == name
+				}
+				inputs = append(inputs, fmt.Sprintf(`{"name":%q,"type":"uint256","indexed":%v}`, name, indexed))
+			}
+			cABI, err := abi.JSON(strings.NewReader(`[{"type":"event","name":"Fill","inputs":[` + strings.Join(inputs, ",") + `]}]`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ev := cABI.Events["Fill"]
+			lg := types.Log{Topics: []common.Hash{ev.ID}}
+			var data []interface{}
+			for _, in := range ev.Inputs {
+				if in.Indexed {
+					lg.Topics = append(lg.Topics, common.BigToHash(args[in.Name]))
+				} else {
+					data = append(data, args[in.Name])
+				}
+			}
+			if lg.Data, err = ev.Inputs.NonIndexed().Pack(data...); err != nil {
+				t.Fatal(err)
+			}
+			var got FillEvent
+			if err := unpackLog(cABI, &got, "Fill", lg); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("unpacked %v, want %v", got, want)
+			}
+			lg.Topics = lg.Topics[:1]
+			if len(c.indexed) > 0 && unpackLog(cABI, &got, "Fill", lg) == nil {
+				t.Error("unpacked a log missing its indexed topics")
+			}
+		})
+	}
+}
+
+func TestBlockAtTime(t *testing.T) {
+	// Block n has timestamp 1000+n.
+	times := newBlockTimes(dialFakeEth(t, &pollEth{head: 100}))
+	for _, tc := range []struct{ at, want uint64 }{{0, 0}, {1000, 0}, {1050, 50}, {1100, 100}, {5000, 100}} {
+		got, err := blockAtTime(context.Background(), times, tc.at, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("blockAtTime(%d) = %d, want %d", tc.at, got, tc.want)
+		}
+	}
+}
+
+// rangeLimitEth refuses eth_getLogs over more than max blocks.
+type rangeLimitEth struct {
+	*pollEth
+	max uint64
+}
+
+func (f *rangeLimitEth) GetLogs(args fakeFilterArgs) ([]types.Log, error) {
+	if args.ToBlock.ToInt().Uint64()-args.FromBlock.ToInt().Uint64()+1 > f.max {
+		return nil, errors.New("block range is too wide")
+	}
+	return f.pollEth.GetLogs(args)
+}
+
+func TestFetchLogsShrinksChunks(t *testing.T) {
+	eth := &rangeLimitEth{pollEth: &pollEth{head: 100}, max: 10}
+	client := dialFakeEth(t, eth)
+	chunk := uint64(64)
+	var blocks []uint64
+	err := fetchLogs(context.Background(), client, nil, nil, 1, 40, &chunk, func(logs []types.Log) error {
+		for _, lg := range logs {
+			blocks = append(blocks, lg.BlockNumber)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunk != 8 {
+		t.Errorf("chunk = %d, want 8", chunk)
+	}
+	if len(blocks) != 40 || blocks[0] != 1 || blocks[39] != 40 {
+		t.Fatalf("got logs for %v, want blocks 1-40 in order", blocks)
+	}
+	for i := 1; i < len(blocks); i++ {
+		if blocks[i] != blocks[i-1]+1 {
+			t.Fatalf("blocks out of order or duplicated: %v", blocks)
+		}
+	}
+}
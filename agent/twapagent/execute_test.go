@@ -0,0 +1,317 @@
+package twapagent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"twap-agent/twapbind"
+)
+
+const executeTestABI = `[
+	{"type":"function","name":"executeSlice","stateMutability":"nonpayable","inputs":[{"name":"sliceId","type":"uint256"}],"outputs":[]},
+	{"type":"function","name":"sliceDone","stateMutability":"view","inputs":[{"name":"","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]}
+]`
+
+const fakeChainID = 31337
+
+// fakeEth is a minimal "eth" namespace: enough for execute() to simulate,
+// price, estimate, send and wait for one executeSlice transaction.
+type fakeEth struct {
+	mu       sync.Mutex
+	sliceSel []byte
+	sent     []*types.Transaction
+}
+
+type fakeCallArgs struct {
+	From *common.Address `json:"from"`
+	To   *common.Address `json:"to"`
+	Data hexutil.Bytes   `json:"data"`
+}
+
+func (f *fakeEth) ChainId() *hexutil.Big { return (*hexutil.Big)(big.NewInt(fakeChainID)) }
+
+func (f *fakeEth) BlockNumber() hexutil.Uint64 { return 100 }
+
+func (f *fakeEth) GasPrice() *hexutil.Big { return (*hexutil.Big)(big.NewInt(2e9)) }
+
+func (f *fakeEth) GetCode(common.Address, string) hexutil.Bytes { return hexutil.Bytes{0x60, 0x80} }
+
+func (f *fakeEth) EstimateGas(fakeCallArgs) hexutil.Uint64 { return 100_000 }
+
+func (f *fakeEth) GetTransactionCount(common.Address, string) hexutil.Uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return hexutil.Uint64(len(f.sent))
+}
+
+func (f *fakeEth) Call(args fakeCallArgs, block string) hexutil.Bytes {
+	if data := args.Data; len(data) >= 4 && bytes.Equal(data[:4], f.sliceSel) {
+		return make(hexutil.Bytes, 32) // sliceDone == false
+	}
+	return hexutil.Bytes{}
+}
+
+func (f *fakeEth) SendRawTransaction(raw hexutil.Bytes) (common.Hash, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		return common.Hash{}, err
+	}
+	f.mu.Lock()
+	f.sent = append(f.sent, tx)
+	f.mu.Unlock()
+	return tx.Hash(), nil
+}
+
+func (f *fakeEth) GetTransactionReceipt(hash common.Hash) *types.Receipt {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, tx := range f.sent {
+		if tx.Hash() == hash {
+			return &types.Receipt{
+				Status:            types.ReceiptStatusSuccessful,
+				TxHash:            hash,
+				GasUsed:           80_000,
+				CumulativeGasUsed: 80_000,
+				EffectiveGasPrice: tx.GasPrice(),
+				BlockNumber:       big.NewInt(101),
+				Logs:              []*types.Log{},
+			}
+		}
+	}
+	return nil
+}
+
+func (f *fakeEth) sentTxs() []*types.Transaction {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*types.Transaction(nil), f.sent...)
+}
+
+// fakeSigner wraps a throwaway key and counts TransactOpts calls; err makes
+// it fail instead.
+type fakeSigner struct {
+	key   *keySigner
+	mu    sync.Mutex
+	calls int
+	err   error
+}
+
+func newFakeSigner(t *testing.T) *fakeSigner {
+	k, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeSigner{key: newKeySigner(k)}
+}
+
+func (s *fakeSigner) Address() common.Address { return s.key.Address() }
+
+func (s *fakeSigner) TransactOpts(ctx context.Context, chainID uint64) (*bind.TransactOpts, error) {
+	s.mu.Lock()
+	s.calls++
+	s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.key.TransactOpts(ctx, chainID)
+}
+
+// dialFakeEth serves svc as the "eth" namespace over an in-process RPC client.
+func dialFakeEth(t *testing.T, svc interface{}) *ethclient.Client {
+	t.Helper()
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("eth", svc); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Stop)
+	client := ethclient.NewClient(rpc.DialInProc(srv))
+	t.Cleanup(client.Close)
+	return client
+}
+
+type executeHarness struct {
+	eth    *fakeEth
+	addr   common.Address
+	cABI   abi.ABI
+	client *ethclient.Client
+	twap   *twapbind.Twap
+	cfg    TxConfig
+}
+
+// executor runs the harness's vault with signer, cfg and st.
+func (h *executeHarness) executor(signer Signer, cfg TxConfig, st *botState) *executor {
+	return &executor{addr: h.addr, cABI: h.cABI, twap: h.twap, client: h.client, signer: signer, chainID: fakeChainID, txCfg: cfg, st: st}
+}
+
+func newExecuteHarness(t *testing.T) *executeHarness {
+	t.Helper()
+	cABI := mustABI(t, executeTestABI)
+	eth := &fakeEth{sliceSel: cABI.Methods["sliceDone"].ID}
+	client := dialFakeEth(t, eth)
+
+	addr := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	return &executeHarness{
+		eth:    eth,
+		addr:   addr,
+		cABI:   cABI,
+		client: client,
+		twap:   twapbind.NewTwap(addr, cABI, client, client, client),
+		cfg: TxConfig{
+			TxType:              txTypeLegacy,
+			GasBufferPercent:    20,
+			WaitTimeout:         5 * time.Second,
+			ReceiptPollInterval: 10 * time.Millisecond,
+		},
+	}
+}
+
+func (h *executeHarness) state(t *testing.T, signer Signer) *botState {
+	ledger, err := loadGasLedger("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &botState{
+		txClient: h.client,
+		nonces:   newNonceManager(h.client, signer.Address()),
+		ledger:   ledger,
+		failures: newFailureTracker(RetryConfig{MaxFailuresPerSlice: 5}),
+		sender:   &txBroadcaster{public: h.client},
+	}
+}
+
+func TestExecuteSignsWithSigner(t *testing.T) {
+	h := newExecuteHarness(t)
+	signer := newFakeSigner(t)
+	st := h.state(t, signer)
+
+	h.executor(signer, h.cfg, st).execute(context.Background(), 3, 0)
+
+	if signer.calls != 1 {
+		t.Fatalf("TransactOpts calls = %d, want 1", signer.calls)
+	}
+	sent := h.eth.sentTxs()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d txs, want 1", len(sent))
+	}
+	tx := sent[0]
+	from, err := types.Sender(types.LatestSignerForChainID(big.NewInt(fakeChainID)), tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != signer.Address() {
+		t.Fatalf("tx from %s, want signer %s", from.Hex(), signer.Address().Hex())
+	}
+	if tx.To() == nil || *tx.To() != h.addr {
+		t.Fatalf("tx to %v, want %s", tx.To(), h.addr.Hex())
+	}
+	if tx.Gas() != 120_000 {
+		t.Fatalf("gas limit = %d, want estimate plus 20%%", tx.Gas())
+	}
+	want, _ := h.cABI.Pack("executeSlice", big.NewInt(3))
+	if !bytes.Equal(tx.Data(), want) {
+		t.Fatalf("calldata = %x, want %x", tx.Data(), want)
+	}
+	if sum := st.ledger.Summary(h.addr); sum.Slices != 1 {
+		t.Fatalf("ledger slices = %d, want 1", sum.Slices)
+	}
+}
+
+func TestExecuteSignerErrorDoesNotSend(t *testing.T) {
+	h := newExecuteHarness(t)
+	signer := newFakeSigner(t)
+	signer.err = errors.New("hsm unavailable")
+	st := h.state(t, signer)
+
+	// Must log and return rather than exiting the process.
+	h.executor(signer, h.cfg, st).execute(context.Background(), 0, 0)
+
+	if n := len(h.eth.sentTxs()); n != 0 {
+		t.Fatalf("sent %d txs, want 0", n)
+	}
+}
+
+// gasPriceDownEth fails the agent's eth_gasPrice; the one bind makes when
+// sending an unpriced tx succeeds.
+type gasPriceDownEth struct {
+	*fakeEth
+	calls int
+}
+
+func (f *gasPriceDownEth) GasPrice() (*hexutil.Big, error) {
+	if f.calls++; f.calls == 1 {
+		return nil, errors.New("upstream unavailable")
+	}
+	return f.fakeEth.GasPrice(), nil
+}
+
+// GetBlockByNumber is a pre-London head, so bind prices a legacy tx.
+func (f *gasPriceDownEth) GetBlockByNumber(string, bool) *types.Header {
+	return &types.Header{Number: big.NewInt(100), Difficulty: new(big.Int)}
+}
+
+// With a gas ceiling set, a slice that can't be priced is deferred rather
+// than sent at whatever fees bind picks; without one it still goes out.
+func TestExecuteDefersUnpricedSliceUnderCeiling(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		ceiling *big.Int
+		overdue int64
+		want    int
+	}{
+		{"ceiling", big.NewInt(1e9), 0, 0},
+		{"no ceiling", nil, 0, 1},
+		{"overdue past grace", big.NewInt(1e9), 10, 1},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			h := newExecuteHarness(t)
+			h.client = dialFakeEth(t, &gasPriceDownEth{fakeEth: h.eth})
+			h.twap = twapbind.NewTwap(h.addr, h.cABI, h.client, h.client, h.client)
+			signer := newFakeSigner(t)
+			st := h.state(t, signer)
+			cfg := h.cfg
+			cfg.MaxGasPrice = c.ceiling
+
+			h.executor(signer, cfg, st).execute(context.Background(), 0, c.overdue)
+
+			if n := len(h.eth.sentTxs()); n != c.want {
+				t.Fatalf("sent %d txs, want %d", n, c.want)
+			}
+		})
+	}
+}
+
+func TestHandleBlockIgnoresStaleAndIncompleteHeads(t *testing.T) {
+	// Neither head gets as far as reading the vault, so the state needs no clients.
+	st := &botState{lastHead: 10, headSeen: true}
+	(&executor{st: st}).handleBlock(context.Background(), &types.Header{Number: big.NewInt(9)})
+	(&executor{st: st}).handleBlock(context.Background(), &types.Header{})
+	(&executor{st: st}).handleBlock(context.Background(), nil)
+	if st.lastHead != 10 {
+		t.Fatalf("last head = %d after stale and incomplete heads, want 10", st.lastHead)
+	}
+}
+
+func TestHandleBlockWaitsForUninitializedOrder(t *testing.T) {
+	// totalSlices is 0: handleBlock must return before any read or division.
+	st := &botState{strategy: &strategyCache{total: new(big.Int)}}
+	for n := int64(1); n <= 2; n++ {
+		(&executor{st: st}).handleBlock(context.Background(), &types.Header{Number: big.NewInt(n)})
+	}
+	if !st.waitingLogged {
+		t.Fatal("uninitialized order not reported")
+	}
+}
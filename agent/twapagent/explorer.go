@@ -0,0 +1,42 @@
+package twapagent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// explorerTxURLs are the tx page templates of each chain's main explorer;
+// {tx} stands for the hash. --explorer-tx-url covers the others.
+var explorerTxURLs = map[uint64]string{
+	1:        "https://etherscan.io/tx/{tx}",
+	10:       "https://optimistic.etherscan.io/tx/{tx}",
+	56:       "https://bscscan.com/tx/{tx}",
+	100:      "https://gnosisscan.io/tx/{tx}",
+	137:      "https://polygonscan.com/tx/{tx}",
+	8453:     "https://basescan.org/tx/{tx}",
+	17000:    "https://holesky.etherscan.io/tx/{tx}",
+	42161:    "https://arbiscan.io/tx/{tx}",
+	43114:    "https://snowtrace.io/tx/{tx}",
+	59144:    "https://lineascan.build/tx/{tx}",
+	84532:    "https://sepolia.basescan.org/tx/{tx}",
+	11155111: "https://sepolia.etherscan.io/tx/{tx}",
+}
+
+func validateExplorerTxURL(template string) error {
+	if template != "" && !strings.Contains(template, "{tx}") {
+		return fmt.Errorf("--explorer-tx-url %q has no {tx} for the hash", template)
+	}
+	return nil
+}
+
+// txURL links tx on template, or else on chainID's explorer. It is "" for a
+// chain without one, such as a dev chain.
+func txURL(template string, chainID uint64, tx string) string {
+	if template == "" {
+		template = explorerTxURLs[chainID]
+	}
+	if template == "" {
+		return ""
+	}
+	return strings.ReplaceAll(template, "{tx}", tx)
+}
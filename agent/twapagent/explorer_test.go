@@ -0,0 +1,23 @@
+package twapagent
+
+import "testing"
+
+func TestTxURL(t *testing.T) {
+	for _, c := range []struct {
+		template string
+		chainID  uint64
+		want     string
+	}{
+		{"", 1, "https://etherscan.io/tx/0xab"},
+		{"", 8453, "https://basescan.org/tx/0xab"},
+		{"", 31337, ""},
+		{"http://localhost:5100/tx/{tx}", 31337, "http://localhost:5100/tx/0xab"},
+	} {
+		if got := txURL(c.template, c.chainID, "0xab"); got != c.want {
+			t.Errorf("txURL(%q, %d) = %q, want %q", c.template, c.chainID, got, c.want)
+		}
+	}
+	if err := validateExplorerTxURL("https://etherscan.io/tx/"); err == nil {
+		t.Error("a template without {tx}: want an error")
+	}
+}
@@ -0,0 +1,277 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// FactoryConfig has bot mode find its vaults in a factory's creation
+// events, on top of any Contracts: those emitted since FromBlock at
+// startup, and the new ones as they come. Vaults that end are dropped.
+type FactoryConfig struct {
+	// The factory, as an address or ENS name; empty runs Contracts alone.
+	Address string
+	// The creation event's signature, e.g.
+	// "VaultCreated(address indexed vault, address owner)".
+	Event string
+	// The event argument holding the vault; empty takes its first address.
+	VaultArg  string
+	FromBlock uint64
+	// Only vaults selling OnlyTokenIn (an address or symbol), and in one of
+	// OnlyStatus's statuses. A vault whose order has ended is never run.
+	OnlyTokenIn string
+	OnlyStatus  []string
+}
+
+func (c FactoryConfig) validate(cfg *Config) error {
+	if c.Address == "" {
+		switch {
+		case c.Event != "", c.VaultArg != "", c.FromBlock > 0:
+			return errors.New("--factory-event, --factory-vault-arg and --factory-from-block need --factory")
+		case c.OnlyTokenIn != "", len(c.OnlyStatus) > 0:
+			return errors.New("--only-token-in and --only-status need --factory")
+		}
+		return nil
+	}
+	switch {
+	case cfg.Mode != "bot":
+		return fmt.Errorf("--factory is not supported in %s mode", cfg.Mode)
+	case c.Event == "":
+		return errors.New("--factory needs --factory-event, the signature of its creation event")
+	case cfg.End.ExitOnComplete:
+		return errors.New("--exit-on-complete cannot be combined with --factory, which keeps watching for new vaults")
+	case cfg.Slice >= 0:
+		return errors.New("--slice needs a single --contract, not --factory")
+	case cfg.Feed.AllEvents:
+		return errors.New("--all-events cannot be combined with --factory")
+	}
+	if _, err := parseEventSignature(c.Event); err != nil {
+		return err
+	}
+	for _, s := range c.OnlyStatus {
+		if _, err := parseStatus(s); err != nil {
+			return fmt.Errorf("--only-status: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseEventSignature parses a human-readable event signature such as
+// "VaultCreated(address indexed vault, address owner)". Arguments may go
+// unnamed; they are then called arg0, arg1, and so on.
+func parseEventSignature(sig string) (abi.Event, error) {
+	sig = strings.TrimSpace(sig)
+	open := strings.IndexByte(sig, '(')
+	if open <= 0 || !strings.HasSuffix(sig, ")") {
+		return abi.Event{}, fmt.Errorf("event signature %q: want Name(type [indexed] [name], ...)", sig)
+	}
+	name := strings.TrimSpace(strings.TrimPrefix(sig[:open], "event "))
+	var args abi.Arguments
+	if body := strings.TrimSpace(sig[open+1 : len(sig)-1]); body != "" {
+		for i, part := range strings.Split(body, ",") {
+			fields := strings.Fields(part)
+			if len(fields) == 0 {
+				return abi.Event{}, fmt.Errorf("event signature %q: empty argument %d", sig, i)
+			}
+			typ, err := abi.NewType(fields[0], "", nil)
+			if err != nil {
+				return abi.Event{}, fmt.Errorf("event signature %q: argument %d: %w", sig, i, err)
+			}
+			arg := abi.Argument{Name: fmt.Sprintf("arg%d", i), Type: typ}
+			rest := fields[1:]
+			if len(rest) > 0 && rest[0] == "indexed" {
+				arg.Indexed, rest = true, rest[1:]
+			}
+			switch len(rest) {
+			case 0:
+			case 1:
+				arg.Name = rest[0]
+			default:
+				return abi.Event{}, fmt.Errorf("event signature %q: argument %d: unexpected %q", sig, i, strings.Join(rest[1:], " "))
+			}
+			args = append(args, arg)
+		}
+	}
+	return abi.NewEvent(name, name, false, args), nil
+}
+
+// vaultFactory finds the vaults a factory creates, from its creation event.
+type vaultFactory struct {
+	addr     common.Address
+	event    abi.Event
+	eABI     abi.ABI // the event alone, for decodeEvent
+	vaultArg string
+	from     uint64
+	tokenIn  string
+	statuses map[Status]bool
+	// Every vault an event has named, run or skipped, so that a backfill
+	// overlapping the live stream considers each once.
+	seen map[common.Address]bool
+	// The last block discover read; the live stream takes over after it.
+	scanned uint64
+}
+
+func newVaultFactory(ctx context.Context, client *ethclient.Client, cfg FactoryConfig) (*vaultFactory, error) {
+	addr, err := resolveAddress(ctx, client, "--factory", cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	ev, err := parseEventSignature(cfg.Event)
+	if err != nil {
+		return nil, err
+	}
+	f := &vaultFactory{
+		addr: addr, event: ev, eABI: abi.ABI{Events: map[string]abi.Event{ev.Name: ev}},
+		vaultArg: cfg.VaultArg, from: cfg.FromBlock, tokenIn: cfg.OnlyTokenIn, seen: map[common.Address]bool{},
+	}
+	if f.vaultArg == "" {
+		for _, in := range ev.Inputs {
+			if in.Type.T == abi.AddressTy {
+				f.vaultArg = in.Name
+				break
+			}
+		}
+		if f.vaultArg == "" {
+			return nil, fmt.Errorf("--factory-event %s has no address argument for the vault", ev.Sig)
+		}
+	} else {
+		found := false
+		for _, in := range ev.Inputs {
+			if in.Name == f.vaultArg {
+				if in.Type.T != abi.AddressTy {
+					return nil, fmt.Errorf("--factory-vault-arg %s is a %s, not an address", in.Name, in.Type)
+				}
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("--factory-event %s has no argument %s", cfg.Event, f.vaultArg)
+		}
+	}
+	for _, s := range cfg.OnlyStatus {
+		status, err := parseStatus(s)
+		if err != nil {
+			return nil, fmt.Errorf("--only-status: %w", err)
+		}
+		if f.statuses == nil {
+			f.statuses = map[Status]bool{}
+		}
+		f.statuses[status] = true
+	}
+	return f, nil
+}
+
+// vaultOf is the vault lg announces, if it is the factory's creation event.
+func (f *vaultFactory) vaultOf(lg types.Log) (common.Address, bool) {
+	if lg.Address != f.addr || len(lg.Topics) == 0 || lg.Topics[0] != f.event.ID {
+		return common.Address{}, false
+	}
+	_, values, err := decodeEvent(f.eABI, lg)
+	if err != nil {
+		return common.Address{}, false
+	}
+	vault, ok := values[f.vaultArg].(common.Address)
+	return vault, ok
+}
+
+// isNew reports whether addr is a vault no earlier event named.
+func (f *vaultFactory) isNew(addr common.Address) bool {
+	if f.seen[addr] {
+		return false
+	}
+	f.seen[addr] = true
+	return true
+}
+
+// discover reads the factory's creation events from FromBlock to block to,
+// returning the vaults they name in order.
+func (f *vaultFactory) discover(ctx context.Context, client *ethclient.Client, to uint64) ([]common.Address, error) {
+	var found []common.Address
+	chunk := uint64(backfillChunkBlocks)
+	err := fetchLogs(ctx, client, []common.Address{f.addr}, [][]common.Hash{{f.event.ID}}, f.from, to, &chunk, func(logs []types.Log) error {
+		for _, lg := range logs {
+			if vault, ok := f.vaultOf(lg); ok && f.isNew(vault) {
+				found = append(found, vault)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("factory %s: %w", f.addr.Hex(), err)
+	}
+	f.scanned = to
+	logf(ctx, "factory %s: %d vaults created in blocks %d to %d", f.addr.Hex(), len(found), f.from, to)
+	return found, nil
+}
+
+// skip is why the vault at addr doesn't pass the filters, "" if it does.
+// An order that has ended never does.
+func (f *vaultFactory) skip(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client) (string, error) {
+	status, err := readStatus(ctx, addr, cABI, client)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case status.Terminal():
+		return "its order is " + status.String(), nil
+	case f.statuses != nil && !f.statuses[status]:
+		return "its order is " + status.String() + ", not in --only-status", nil
+	case f.tokenIn == "":
+		return "", nil
+	}
+	s, err := readStrategy(ctx, addr, cABI, client)
+	if err != nil {
+		return "", err
+	}
+	if common.IsHexAddress(f.tokenIn) {
+		if s.TokenIn != common.HexToAddress(f.tokenIn) {
+			return "it sells " + s.TokenIn.Hex() + ", not --only-token-in", nil
+		}
+		return "", nil
+	}
+	if sym := readTokenSymbol(ctx, client, s.TokenIn); !strings.EqualFold(sym, f.tokenIn) {
+		return fmt.Sprintf("it sells %s (%s), not --only-token-in", s.TokenIn.Hex(), sym), nil
+	}
+	return "", nil
+}
+
+// admitVault checks a vault the factory created before bot mode runs it:
+// that it is a Twap vault, passes the filters and, with keys, that one of
+// them is its agent. It returns the vault's signer, nil when nothing signs
+// (Defender, --unsigned-out), and false, having logged why, to skip it.
+func (a *Agent) admitVault(ctx context.Context, addr common.Address) (Signer, bool) {
+	skip := func(reason interface{}) (Signer, bool) {
+		logf(ctx, "factory: skipping %s: %v", addr.Hex(), reason)
+		return nil, false
+	}
+	if err := checkContract(ctx, addr, a.cABI, a.client, a.chainID); err != nil {
+		return skip(err)
+	}
+	if reason, err := a.factory.skip(ctx, addr, a.cABI, a.client); err != nil {
+		return skip(err)
+	} else if reason != "" {
+		return skip(reason)
+	}
+	switch {
+	case len(a.signers) > 0:
+		s, err := matchAgentSigner(ctx, addr, a.cABI, a.client, a.signers)
+		if err != nil {
+			return skip(err)
+		}
+		return s, true
+	case a.cfg.Tx.DryRun && a.relay == nil:
+		from, err := dryRunFrom(ctx, addr, a.cABI, a.client, a.cfg.Signer.From)
+		if err != nil {
+			return skip(err)
+		}
+		return dryRunSigner{from}, true
+	}
+	return nil, true
+}
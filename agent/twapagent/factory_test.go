@@ -0,0 +1,161 @@
+package twapagent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestParseEventSignature(t *testing.T) {
+	ev, err := parseEventSignature("VaultCreated(address indexed vault, address owner, uint256)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := crypto.Keccak256Hash([]byte("VaultCreated(address,address,uint256)")); ev.ID != want {
+		t.Errorf("ID = %s, want %s", ev.ID.Hex(), want.Hex())
+	}
+	var got []string
+	for _, in := range ev.Inputs {
+		got = append(got, in.Name+":"+in.Type.String()+":"+map[bool]string{true: "indexed", false: "data"}[in.Indexed])
+	}
+	if want := "vault:address:indexed owner:address:data arg2:uint256:data"; strings.Join(got, " ") != want {
+		t.Errorf("inputs = %s, want %s", strings.Join(got, " "), want)
+	}
+	for _, bad := range []string{"VaultCreated", "(address vault)", "VaultCreated(addr vault)", "VaultCreated(address indexed vault owner)", "VaultCreated(address,)"} {
+		if _, err := parseEventSignature(bad); err == nil {
+			t.Errorf("%q: want an error", bad)
+		}
+	}
+}
+
+func TestNewRejectsBadFactoryConfig(t *testing.T) {
+	for name, edit := range map[string]func(*Config){
+		"not bot":          func(c *Config) { c.Mode = "once" },
+		"no event":         func(c *Config) { c.Factory.Event = "" },
+		"bad event":        func(c *Config) { c.Factory.Event = "VaultCreated(vault)" },
+		"exit on complete": func(c *Config) { c.End.ExitOnComplete = true },
+		"slice":            func(c *Config) { c.Slice = 0 },
+		"all events":       func(c *Config) { c.Feed.AllEvents = true },
+		"bad status":       func(c *Config) { c.Factory.OnlyStatus = []string{"Done"} },
+		"filter alone":     func(c *Config) { c.Factory.Address, c.Factory.Event, c.Factory.OnlyTokenIn = "", "", "WETH" },
+	} {
+		cfg := DefaultConfig()
+		cfg.Mode, cfg.RPC = "bot", "http://127.0.0.1:0"
+		cfg.Factory = FactoryConfig{Address: "0x01", Event: "VaultCreated(address indexed vault)"}
+		edit(&cfg)
+		if _, err := New(cfg); err == nil || strings.Contains(err.Error(), "dial") {
+			t.Errorf("%s: %v, want a config error", name, err)
+		}
+	}
+}
+
+// devFactory is a factory address on the dev chain, with no code: the test
+// emits its creation events itself.
+var devFactory = common.HexToAddress("0xfac0000000000000000000000000000000000001")
+
+const devFactoryEvent = "VaultCreated(address indexed vault, address owner)"
+
+// announce mines a block with devFactory's creation event for vault.
+func announce(t *testing.T, d *devnet, vault common.Address) {
+	t.Helper()
+	ev, err := parseEventSignature(devFactoryEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ev.Inputs.NonIndexed().Pack(crypto.PubkeyToAddress(d.owner.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := d.chain
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := c.mineLocked(c.head().Time + devBlockTime)
+	c.logs = append(c.logs, types.Log{
+		Address: devFactory, Topics: []common.Hash{ev.ID, common.BytesToHash(vault.Bytes())}, Data: data,
+		BlockNumber: h.Number.Uint64(), BlockHash: h.Hash(),
+	})
+}
+
+// lockedBuffer is a bytes.Buffer the bot's log and a test can share.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// A vault announced before startup is found by the backfill and one
+// announced while the bot runs is picked up live; both are filled and then
+// dropped. An address without code is skipped.
+func TestRunFactoryVaults(t *testing.T) {
+	cfg := devnetConfig(t, 5*time.Millisecond)
+	d := startTestDevnet(t, devnetConfig(t, 0))
+	vault2, err := d.deploy(context.Background(), cfg, crypto.PubkeyToAddress(d.agent.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	announce(t, d, common.HexToAddress("0xdead"))
+	announce(t, d, d.vault)
+
+	bcfg := d.botConfig(cfg)
+	bcfg.Contracts, bcfg.End.ExitOnComplete = nil, false
+	bcfg.Factory = FactoryConfig{Address: devFactory.Hex(), Event: devFactoryEvent, OnlyStatus: []string{"Open"}}
+	logs := &lockedBuffer{}
+	bcfg.Stdout, bcfg.Stderr = logs, logs
+	a, err := New(bcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if len(a.addrs) != 1 || a.addrs[0] != d.vault {
+		t.Fatalf("discovered %v, want only %s", a.addrs, d.vault.Hex())
+	}
+	if !strings.Contains(logs.String(), "skipping "+common.HexToAddress("0xdead").Hex()) {
+		t.Errorf("no skip logged for the address without code:\n%s", logs)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx) }()
+	d.mineEvery(ctx, cfg.Devnet.BlockPeriod)
+	announce(t, d, vault2)
+
+	for _, addr := range []common.Address{d.vault, vault2} {
+		for !strings.Contains(logs.String(), "no longer running "+addr.Hex()) {
+			select {
+			case err := <-done:
+				t.Fatalf("Run = %v before %s was dropped:\n%s", err, addr.Hex(), logs)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		v := d.chain.vault(addr)
+		d.chain.mu.Lock()
+		if v.status != StatusFilled {
+			t.Errorf("vault %s is %s, want Filled", addr.Hex(), v.status)
+		}
+		d.chain.mu.Unlock()
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, errInterrupted) {
+		t.Errorf("Run = %v, want interrupted", err)
+	}
+}
@@ -0,0 +1,573 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// FeedConfig selects how bot mode learns about new blocks and contract logs.
+type FeedConfig struct {
+	// Poll over plain requests instead of subscribing (implied by an http(s) --rpc).
+	Poll bool
+	// How often to ask for the latest block when polling.
+	PollInterval time.Duration
+	// Upper bound on the pause between websocket reconnect attempts.
+	MaxReconnectWait time.Duration
+	// Bot mode: subscribe to every log of the contracts rather than those
+	// of feedEvents.
+	AllEvents bool
+
+	// The topic filter of the feed's logs, which the mode sets; nil takes
+	// them all.
+	topics [][]common.Hash
+}
+
+// feedEvents are the contract events bot and watch mode act on.
+var feedEvents = []string{"Fill", "OrderStatus", "Unpaused"}
+
+// eventTopics is the topic filter matching the named events of cABI, those
+// it has.
+func eventTopics(cABI abi.ABI, names []string) [][]common.Hash {
+	var ids []common.Hash
+	for _, name := range names {
+		if ev, ok := cABI.Events[name]; ok {
+			ids = append(ids, ev.ID)
+		}
+	}
+	return [][]common.Hash{ids}
+}
+
+// backfillChunkBlocks is the eth_getLogs range a backfill starts with;
+// fetchLogs halves it when the provider refuses.
+const backfillChunkBlocks = 2000
+
+// maxPollHeads caps how many skipped heights one poll replays through
+// handleBlock; beyond that only the latest head is delivered. Logs are
+// always fetched for the whole range.
+const maxPollHeads = 32
+
+// pollReorgDepth is how many recent heights a polled feed keeps the hashes
+// and logs of, to find the fork point of a reorg and take back the logs it
+// delivered past it.
+const pollReorgDepth = 64
+
+// chainFeed delivers new heads and the contract's logs to the bot loop, over
+// either websocket subscriptions or HTTP polling. Both ride out connection
+// errors on their own, so the feed only ends with Close.
+type chainFeed struct {
+	heads chan *types.Header
+	logs  chan types.Log
+	stop  func()
+}
+
+func (f *chainFeed) Heads() <-chan *types.Header { return f.heads }
+func (f *chainFeed) Logs() <-chan types.Log      { return f.logs }
+func (f *chainFeed) Close()                      { f.stop() }
+
+// isHTTPURL reports whether rpcURL is an http(s) endpoint, which can't carry
+// subscriptions.
+func isHTTPURL(rpcURL string) bool {
+	u := strings.ToLower(rpcURL)
+	return strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://")
+}
+
+// openFeed subscribes to the logs of addrs and to new heads, or starts
+// polling. A from block other than 0 first delivers the logs since it, from
+// before the bot started.
+func openFeed(ctx context.Context, client *ethclient.Client, addrs []common.Address, cfg FeedConfig, from uint64) (*chainFeed, error) {
+	if cfg.Poll {
+		return pollFeed(ctx, client, addrs, cfg.topics, cfg.PollInterval, from)
+	}
+	return subscribeFeed(ctx, client, addrs, cfg, from)
+}
+
+// wsSubs is one set of live subscriptions.
+type wsSubs struct {
+	logs    chan types.Log
+	heads   chan *types.Header
+	logSub  ethereum.Subscription
+	headSub ethereum.Subscription
+}
+
+func (s *wsSubs) unsubscribe() {
+	s.logSub.Unsubscribe()
+	s.headSub.Unsubscribe()
+}
+
+// logCursor is the position of the last log handed to the bot, so logs seen
+// again after a reconnect (backfill overlapping the new subscription) are
+// dropped.
+type logCursor struct {
+	ok    bool
+	block uint64
+	index uint
+}
+
+// handled reports whether lg is at or before the cursor.
+func (c *logCursor) handled(lg types.Log) bool {
+	return c.ok && (lg.BlockNumber < c.block || lg.BlockNumber == c.block && lg.Index <= c.index)
+}
+
+func (c *logCursor) advance(lg types.Log) {
+	*c = logCursor{ok: true, block: lg.BlockNumber, index: lg.Index}
+}
+
+// retreat moves the cursor back to just before lg, which a reorg removed.
+func (c *logCursor) retreat(lg types.Log) {
+	if !c.handled(lg) {
+		return
+	}
+	switch {
+	case lg.Index > 0:
+		*c = logCursor{ok: true, block: lg.BlockNumber, index: lg.Index - 1}
+	case lg.BlockNumber > 0:
+		*c = logCursor{ok: true, block: lg.BlockNumber - 1, index: ^uint(0)}
+	default:
+		*c = logCursor{}
+	}
+}
+
+// reconnectWait is the pause before reconnect attempt n (1-based): 1s,
+// doubling, capped at max.
+func reconnectWait(n int, max time.Duration) time.Duration {
+	wait := time.Second
+	for i := 1; i < n && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	return wait
+}
+
+// wsFeed keeps the log and head subscriptions alive across connection
+// drops. client is a websocket ethclient; its rpc client redials on the next
+// request after the connection is lost, so resubscribing is the reconnect.
+type wsFeed struct {
+	client     *ethclient.Client
+	addrs      []common.Address
+	topics     [][]common.Hash
+	maxWait    time.Duration
+	lastHead   uint64
+	cursor     logCursor
+	reconnects int
+}
+
+func subscribeFeed(ctx context.Context, client *ethclient.Client, addrs []common.Address, cfg FeedConfig, from uint64) (*chainFeed, error) {
+	if cfg.MaxReconnectWait <= 0 {
+		return nil, fmt.Errorf("--max-reconnect-wait must be positive, got %s", cfg.MaxReconnectWait)
+	}
+	head, err := headerByNumber(ctx, client, nil)
+	if err != nil {
+		return nil, fmt.Errorf("latest header: %w", err)
+	}
+	w := &wsFeed{client: client, addrs: addrs, topics: cfg.topics, maxWait: cfg.MaxReconnectWait, lastHead: head.Number.Uint64()}
+	resume := from > 0 && from <= w.lastHead
+	if resume {
+		w.lastHead = from
+	}
+	subs, err := w.subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	logf(ctx, "subscribed to contract logs and new heads")
+
+	f := &chainFeed{
+		heads: make(chan *types.Header, 32),
+		logs:  make(chan types.Log, 128),
+	}
+	sctx, cancel := context.WithCancel(ctx)
+	f.stop = cancel
+	go func() {
+		// Subscribed first, as on a reconnect.
+		if resume {
+			if err := w.backfill(sctx, f); err != nil {
+				warnf(ctx, "backfill since block %d: %v", from, err)
+			}
+		}
+		w.run(sctx, f, subs)
+	}()
+	return f, nil
+}
+
+func (w *wsFeed) subscribe(ctx context.Context) (*wsSubs, error) {
+	s := &wsSubs{logs: make(chan types.Log, 128), heads: make(chan *types.Header, 32)}
+	var err error
+	s.logSub, err = w.client.SubscribeFilterLogs(ctx, ethereum.FilterQuery{Addresses: w.addrs, Topics: w.topics}, s.logs)
+	if err != nil {
+		return nil, fmt.Errorf("log subscribe failed: %w", err)
+	}
+	s.headSub, err = w.client.SubscribeNewHead(ctx, s.heads)
+	if err != nil {
+		s.logSub.Unsubscribe()
+		return nil, fmt.Errorf("header subscribe failed: %w", err)
+	}
+	return s, nil
+}
+
+// run forwards from subs until ctx ends, reconnecting whenever a
+// subscription fails.
+func (w *wsFeed) run(ctx context.Context, f *chainFeed, subs *wsSubs) {
+	for {
+		err := w.forward(ctx, f, subs)
+		subs.unsubscribe()
+		if ctx.Err() != nil {
+			return
+		}
+		warnf(ctx, "websocket subscription lost: %v", err)
+		if subs = w.reconnect(ctx, f); subs == nil {
+			return
+		}
+	}
+}
+
+// forward passes logs and heads on to the bot until a subscription fails.
+func (w *wsFeed) forward(ctx context.Context, f *chainFeed, subs *wsSubs) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-subs.headSub.Err():
+			return fmt.Errorf("header sub error: %w", err)
+		case err := <-subs.logSub.Err():
+			return fmt.Errorf("log sub error: %w", err)
+		case lg := <-subs.logs:
+			if !w.sendLog(ctx, f, lg) {
+				return ctx.Err()
+			}
+		case h := <-subs.heads:
+			if !w.sendHead(ctx, f, h) {
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func (w *wsFeed) sendLog(ctx context.Context, f *chainFeed, lg types.Log) bool {
+	if !lg.Removed && w.cursor.handled(lg) {
+		return true
+	}
+	select {
+	case f.logs <- lg:
+		if lg.Removed {
+			// Let the log's replacement on the new chain through.
+			w.cursor.retreat(lg)
+		} else {
+			w.cursor.advance(lg)
+		}
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (w *wsFeed) sendHead(ctx context.Context, f *chainFeed, h *types.Header) bool {
+	select {
+	case f.heads <- h:
+		if n := h.Number.Uint64(); n > w.lastHead {
+			w.lastHead = n
+		}
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// reconnect resubscribes with backoff and backfills the logs emitted while
+// disconnected. It returns nil only when ctx ends.
+func (w *wsFeed) reconnect(ctx context.Context, f *chainFeed) *wsSubs {
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(reconnectWait(attempt, w.maxWait)):
+		}
+		subs, err := w.subscribe(ctx)
+		if err != nil {
+			warnf(ctx, "reconnect attempt %d: %v", attempt, err)
+			emitEvent(ctx, evError, 0, map[string]interface{}{"error": err.Error(), "reconnectAttempt": attempt})
+			continue
+		}
+		// Subscribed first, so nothing falls between the backfill and the new stream.
+		if err := w.backfill(ctx, f); err != nil {
+			subs.unsubscribe()
+			warnf(ctx, "reconnect attempt %d: %v", attempt, err)
+			emitEvent(ctx, evError, 0, map[string]interface{}{"error": err.Error(), "reconnectAttempt": attempt})
+			continue
+		}
+		w.reconnects++
+		logf(ctx, "websocket resubscribed (reconnects: %d)", w.reconnects)
+		emitEvent(ctx, evReconnect, 0, map[string]interface{}{"reconnects": w.reconnects})
+		return subs
+	}
+}
+
+// backfill delivers the contracts' logs from the last handled head to the current
+// one, then that head, so the bot re-evaluates the schedule straight away.
+func (w *wsFeed) backfill(ctx context.Context, f *chainFeed) error {
+	head, err := headerByNumber(ctx, w.client, nil)
+	if err != nil {
+		return fmt.Errorf("latest header: %w", err)
+	}
+	n := head.Number.Uint64()
+	if n < w.lastHead {
+		return nil
+	}
+	count, chunk := 0, uint64(backfillChunkBlocks)
+	err = fetchLogs(ctx, w.client, w.addrs, w.topics, w.lastHead, n, &chunk, func(logs []types.Log) error {
+		for _, lg := range logs {
+			if !w.sendLog(ctx, f, lg) {
+				return ctx.Err()
+			}
+		}
+		count += len(logs)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("backfill: %w", err)
+	}
+	if count > 0 {
+		logf(ctx, "backfilled %d contract logs from blocks %d-%d", count, w.lastHead, n)
+	}
+	if n > w.lastHead {
+		w.sendHead(ctx, f, head)
+	}
+	return nil
+}
+
+// headPoller turns periodic eth_getBlockByNumber("latest") answers into the
+// stream of logs and heads a subscription would have produced. eth_getLogs
+// never reports removed logs, so it remembers the recent blocks' hashes and
+// the logs it delivered from them, and on a reorg sends those past the fork
+// point again with Removed set.
+type headPoller struct {
+	client *ethclient.Client
+	addrs  []common.Address
+	topics [][]common.Hash
+	last   uint64 // highest height delivered
+	hashes map[uint64]common.Hash
+	sent   []types.Log // in delivery order
+}
+
+func pollFeed(ctx context.Context, client *ethclient.Client, addrs []common.Address, topics [][]common.Hash, interval time.Duration, from uint64) (*chainFeed, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("--poll-interval must be positive, got %s", interval)
+	}
+	head, err := headerByNumber(ctx, client, nil)
+	if err != nil {
+		return nil, fmt.Errorf("latest header: %w", err)
+	}
+	p := &headPoller{client: client, addrs: addrs, topics: topics, last: head.Number.Uint64()}
+	p.remember([]*types.Header{head}, nil)
+	logf(ctx, "polling for new blocks and contract logs every %s from block %d", interval, p.last)
+
+	f := &chainFeed{
+		heads: make(chan *types.Header, 32),
+		logs:  make(chan types.Log, 128),
+	}
+	pctx, cancel := context.WithCancel(ctx)
+	f.stop = cancel
+	go func() {
+		if from > 0 && from <= p.last {
+			if err := p.backfill(pctx, f, from); err != nil {
+				warnf(ctx, "backfill since block %d: %v", from, err)
+			}
+		}
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-pctx.Done():
+				return
+			case <-t.C:
+			}
+			logs, heads, err := p.poll(pctx)
+			if err != nil {
+				// Transient on HTTP; the next tick retries from the same height.
+				warnf(ctx, "poll: %v", err)
+				continue
+			}
+			// Logs before heads, so a Fill is seen before the block that follows it.
+			for _, lg := range logs {
+				select {
+				case f.logs <- lg:
+				case <-pctx.Done():
+					return
+				}
+			}
+			for _, h := range heads {
+				select {
+				case f.heads <- h:
+				case <-pctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return f, nil
+}
+
+// backfill delivers the contracts' logs from block from up to the height
+// polling starts at.
+func (p *headPoller) backfill(ctx context.Context, f *chainFeed, from uint64) error {
+	count, chunk := 0, uint64(backfillChunkBlocks)
+	err := fetchLogs(ctx, p.client, p.addrs, p.topics, from, p.last, &chunk, func(logs []types.Log) error {
+		for _, lg := range logs {
+			select {
+			case f.logs <- lg:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		p.remember(nil, logs)
+		count += len(logs)
+		return nil
+	})
+	if count > 0 {
+		logf(ctx, "backfilled %d contract logs from blocks %d-%d", count, from, p.last)
+	}
+	return err
+}
+
+// poll returns the contract's logs and the headers for every height since
+// the last call, and advances past them only if all reads succeeded. After a
+// reorg the logs start with those delivered past the fork point, newest
+// first and marked Removed, and the rest are fetched again from there.
+func (p *headPoller) poll(ctx context.Context) ([]types.Log, []*types.Header, error) {
+	latest, err := headerByNumber(ctx, p.client, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("latest header: %w", err)
+	}
+	n := latest.Number.Uint64()
+	fork, err := p.forkPoint(ctx, latest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fork == p.last && n <= p.last {
+		return nil, nil, nil
+	}
+	var logs []types.Log
+	if n > fork {
+		err = rpcRead(ctx, "eth_getLogs", func(ctx context.Context) (err error) {
+			logs, err = p.client.FilterLogs(ctx, ethereum.FilterQuery{
+				FromBlock: new(big.Int).SetUint64(fork + 1),
+				ToBlock:   latest.Number,
+				Addresses: p.addrs,
+				Topics:    p.topics,
+			})
+			return err
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("logs %d-%d: %w", fork+1, n, err)
+		}
+	}
+	var heads []*types.Header
+	if n > fork {
+		from := fork + 1
+		if n-fork > maxPollHeads {
+			debugf(ctx, "poll: %d blocks since %d, only handling the latest", n-fork, fork)
+			from = n
+		}
+		heads = make([]*types.Header, 0, n-from+1)
+		for h := from; h < n; h++ {
+			hdr, err := headerByNumber(ctx, p.client, new(big.Int).SetUint64(h))
+			if err != nil {
+				return nil, nil, fmt.Errorf("header %d: %w", h, err)
+			}
+			heads = append(heads, hdr)
+		}
+		heads = append(heads, latest)
+	}
+	if fork < p.last {
+		removed := p.rewind(fork)
+		warnf(ctx, "poll: reorg from block %d, fork point %d: %d contract logs removed", fork+1, fork, len(removed))
+		logs = append(removed, logs...)
+	}
+	p.last = n
+	p.remember(heads, logs)
+	return logs, heads, nil
+}
+
+// forkPoint is the highest delivered height still on latest's chain: p.last
+// when there was no reorg. Heights older than the hashes kept count as
+// canonical.
+func (p *headPoller) forkPoint(ctx context.Context, latest *types.Header) (uint64, error) {
+	h := p.last
+	if n := latest.Number.Uint64(); n == h+1 {
+		if want, ok := p.hashes[h]; !ok || latest.ParentHash == want {
+			return h, nil
+		}
+	} else if n < h {
+		h = n
+	}
+	for ; h > 0; h-- {
+		want, ok := p.hashes[h]
+		if !ok {
+			return h, nil
+		}
+		hdr := latest
+		if h != latest.Number.Uint64() {
+			var err error
+			if hdr, err = headerByNumber(ctx, p.client, new(big.Int).SetUint64(h)); err != nil {
+				return 0, fmt.Errorf("header %d: %w", h, err)
+			}
+		}
+		if hdr.Hash() == want {
+			return h, nil
+		}
+	}
+	return 0, nil
+}
+
+// rewind forgets everything past fork and returns the logs delivered from
+// there, newest first, marked Removed.
+func (p *headPoller) rewind(fork uint64) []types.Log {
+	var removed []types.Log
+	for len(p.sent) > 0 && p.sent[len(p.sent)-1].BlockNumber > fork {
+		lg := p.sent[len(p.sent)-1]
+		lg.Removed = true
+		removed = append(removed, lg)
+		p.sent = p.sent[:len(p.sent)-1]
+	}
+	for h := range p.hashes {
+		if h > fork {
+			delete(p.hashes, h)
+		}
+	}
+	return removed
+}
+
+// remember records the hashes of heads and the logs delivered, dropping
+// those more than pollReorgDepth below p.last.
+func (p *headPoller) remember(heads []*types.Header, logs []types.Log) {
+	if p.hashes == nil {
+		p.hashes = map[uint64]common.Hash{}
+	}
+	for _, h := range heads {
+		p.hashes[h.Number.Uint64()] = h.Hash()
+	}
+	p.sent = append(p.sent, logs...)
+	if p.last < pollReorgDepth {
+		return
+	}
+	oldest := p.last - pollReorgDepth
+	for h := range p.hashes {
+		if h < oldest {
+			delete(p.hashes, h)
+		}
+	}
+	i := 0
+	for i < len(p.sent) && p.sent[i].BlockNumber < oldest {
+		i++
+	}
+	p.sent = p.sent[i:]
+}
@@ -0,0 +1,326 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// pollEth is a chain whose head the test moves by hand. Every block has one
+// contract log. A block's hash changes with each reorg that replaces it.
+type pollEth struct {
+	mu       sync.Mutex
+	head     uint64
+	branch   map[uint64]byte
+	logsFail bool
+	ranges   [][2]uint64
+	// The topic filter of the last eth_getLogs.
+	topics [][]common.Hash
+}
+
+type fakeFilterArgs struct {
+	FromBlock *hexutil.Big    `json:"fromBlock"`
+	ToBlock   *hexutil.Big    `json:"toBlock"`
+	Topics    [][]common.Hash `json:"topics"`
+}
+
+func (f *pollEth) setHead(n uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.head = n
+}
+
+func (f *pollEth) GetBlockByNumber(number string, _ bool) (*types.Header, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := f.head
+	if number != "latest" {
+		v, err := hexutil.DecodeUint64(number)
+		if err != nil {
+			return nil, err
+		}
+		n = v
+	}
+	return f.header(n), nil
+}
+
+func (f *pollEth) header(n uint64) *types.Header {
+	h := &types.Header{Number: new(big.Int).SetUint64(n), Difficulty: new(big.Int), Time: 1000 + n}
+	if b := f.branch[n]; b != 0 {
+		h.Extra = []byte{b}
+	}
+	return h
+}
+
+// reorg replaces the blocks from n on, and moves the head to head.
+func (f *pollEth) reorg(n, head uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.branch == nil {
+		f.branch = map[uint64]byte{}
+	}
+	for ; n <= head; n++ {
+		f.branch[n]++
+	}
+	f.head = head
+}
+
+func (f *pollEth) BlockNumber() hexutil.Uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return hexutil.Uint64(f.head)
+}
+
+func (f *pollEth) GetLogs(args fakeFilterArgs) ([]types.Log, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.logsFail {
+		return nil, errors.New("query returned more than 10000 results")
+	}
+	from, to := args.FromBlock.ToInt().Uint64(), args.ToBlock.ToInt().Uint64()
+	f.ranges = append(f.ranges, [2]uint64{from, to})
+	f.topics = args.Topics
+	var logs []types.Log
+	for n := from; n <= to; n++ {
+		logs = append(logs, types.Log{BlockNumber: n, BlockHash: f.header(n).Hash(), Topics: []common.Hash{}, Data: []byte{}})
+	}
+	return logs, nil
+}
+
+func heightsOf(heads []*types.Header) []uint64 {
+	var out []uint64
+	for _, h := range heads {
+		out = append(out, h.Number.Uint64())
+	}
+	return out
+}
+
+func TestHeadPollerDeliversNewHeights(t *testing.T) {
+	eth := &pollEth{head: 100}
+	p := &headPoller{client: dialFakeEth(t, eth), last: 100}
+
+	logs, heads, err := p.poll(context.Background())
+	if err != nil || len(logs) != 0 || len(heads) != 0 {
+		t.Fatalf("no new block: logs=%d heads=%v err=%v", len(logs), heightsOf(heads), err)
+	}
+
+	eth.setHead(103)
+	logs, heads, err = p.poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := heightsOf(heads); len(got) != 3 || got[0] != 101 || got[2] != 103 {
+		t.Fatalf("heads = %v, want 101..103", got)
+	}
+	if len(logs) != 3 || eth.ranges[0] != [2]uint64{101, 103} {
+		t.Fatalf("logs = %d over %v, want 3 over 101-103", len(logs), eth.ranges)
+	}
+
+	// A large gap replays only the latest head but still fetches every log.
+	eth.setHead(103 + maxPollHeads + 10)
+	logs, heads, err = p.poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := heightsOf(heads); len(got) != 1 || got[0] != 103+maxPollHeads+10 {
+		t.Fatalf("heads after gap = %v, want only the latest", got)
+	}
+	if len(logs) != maxPollHeads+10 {
+		t.Fatalf("logs after gap = %d, want %d", len(logs), maxPollHeads+10)
+	}
+}
+
+func TestHeadPollerRetriesFailedRange(t *testing.T) {
+	eth := &pollEth{head: 105, logsFail: true}
+	p := &headPoller{client: dialFakeEth(t, eth), last: 100}
+	if _, _, err := p.poll(context.Background()); err == nil {
+		t.Fatal("poll succeeded with failing eth_getLogs")
+	}
+	eth.mu.Lock()
+	eth.logsFail = false
+	eth.mu.Unlock()
+	logs, heads, err := p.poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 5 || len(heads) != 5 || eth.ranges[0] != [2]uint64{101, 105} {
+		t.Fatalf("retry got %d logs, heads %v over %v; want blocks 101-105", len(logs), heightsOf(heads), eth.ranges)
+	}
+}
+
+// A reorg takes back the logs delivered past the fork point, newest first,
+// and fetches the new chain's from there.
+func TestHeadPollerReorg(t *testing.T) {
+	eth := &pollEth{head: 100}
+	p := &headPoller{client: dialFakeEth(t, eth), last: 100}
+	eth.setHead(103)
+	if _, _, err := p.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	old := eth.header(103).Hash()
+
+	eth.reorg(102, 104)
+	logs, heads, err := p.poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, lg := range logs {
+		got = append(got, fmt.Sprintf("%d/%v", lg.BlockNumber, lg.Removed))
+	}
+	if want := "[103/true 102/true 102/false 103/false 104/false]"; fmt.Sprint(got) != want {
+		t.Fatalf("logs %v, want %s", got, want)
+	}
+	if logs[0].BlockHash != old || logs[3].BlockHash == old {
+		t.Fatal("removed log must keep the old block hash, its replacement the new one")
+	}
+	if h := heightsOf(heads); fmt.Sprint(h) != "[102 103 104]" {
+		t.Fatalf("heads %v, want 102..104", h)
+	}
+	if r := eth.ranges[len(eth.ranges)-1]; r != [2]uint64{102, 104} {
+		t.Fatalf("refetched %v, want 102-104", r)
+	}
+
+	// A reorg of the head alone, at the same height.
+	eth.reorg(104, 104)
+	logs, heads, err = p.poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 || !logs[0].Removed || logs[1].Removed || logs[1].BlockNumber != 104 || len(heads) != 1 {
+		t.Fatalf("same-height reorg: logs %+v heads %v", logs, heightsOf(heads))
+	}
+
+	// Without a reorg nothing comes back.
+	if logs, heads, err := p.poll(context.Background()); err != nil || len(logs) != 0 || len(heads) != 0 {
+		t.Fatalf("steady head: logs=%d heads=%v err=%v", len(logs), heightsOf(heads), err)
+	}
+}
+
+// Polling from a saved head first delivers the logs since it.
+func TestHeadPollerBackfill(t *testing.T) {
+	eth := &pollEth{head: 103}
+	p := &headPoller{client: dialFakeEth(t, eth), last: 103}
+	f := &chainFeed{heads: make(chan *types.Header, 8), logs: make(chan types.Log, 8)}
+	if err := p.backfill(context.Background(), f, 100); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.logs) != 4 || eth.ranges[0] != [2]uint64{100, 103} {
+		t.Fatalf("backfilled %d logs over %v, want 4 over 100-103", len(f.logs), eth.ranges)
+	}
+}
+
+// The feed asks only for the events the bot handles.
+func TestFeedEventTopics(t *testing.T) {
+	cABI, _, err := loadTwapABI("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	topics := eventTopics(cABI, feedEvents)
+	if len(topics) != 1 || len(topics[0]) != len(feedEvents) || topics[0][0] != cABI.Events["Fill"].ID {
+		t.Fatalf("topics = %v", topics)
+	}
+	eth := &pollEth{head: 101}
+	p := &headPoller{client: dialFakeEth(t, eth), topics: topics, last: 100}
+	if _, _, err := p.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(eth.topics) != 1 || len(eth.topics[0]) != len(feedEvents) {
+		t.Errorf("eth_getLogs topics = %v", eth.topics)
+	}
+}
+
+func TestIsHTTPURL(t *testing.T) {
+	for url, want := range map[string]bool{
+		"https://eth.llamarpc.com": true,
+		"HTTP://127.0.0.1:8545":    true,
+		"ws://127.0.0.1:8545":      false,
+		"wss://mainnet.example":    false,
+		"/tmp/geth.ipc":            false,
+	} {
+		if got := isHTTPURL(url); got != want {
+			t.Errorf("isHTTPURL(%q) = %v, want %v", url, got, want)
+		}
+	}
+}
+
+func TestWSFeedBackfillSkipsHandledLogs(t *testing.T) {
+	eth := &pollEth{head: 103}
+	w := &wsFeed{client: dialFakeEth(t, eth), lastHead: 100}
+	w.cursor.advance(types.Log{BlockNumber: 100})
+	f := &chainFeed{heads: make(chan *types.Header, 8), logs: make(chan types.Log, 8)}
+	if err := w.backfill(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	if eth.ranges[0] != [2]uint64{100, 103} {
+		t.Fatalf("backfilled %v, want 100-103", eth.ranges)
+	}
+	if len(f.logs) != 3 {
+		t.Fatalf("delivered %d logs, want 3 (block 100 was already handled)", len(f.logs))
+	}
+	if first := <-f.logs; first.BlockNumber != 101 {
+		t.Fatalf("first backfilled log in block %d, want 101", first.BlockNumber)
+	}
+	if len(f.heads) != 1 || w.lastHead != 103 {
+		t.Fatalf("heads=%d lastHead=%d, want the new head 103 delivered", len(f.heads), w.lastHead)
+	}
+}
+
+func TestLogCursor(t *testing.T) {
+	var c logCursor
+	at := func(block uint64, index uint) types.Log { return types.Log{BlockNumber: block, Index: index} }
+	if c.handled(at(0, 0)) {
+		t.Fatal("empty cursor reports a log handled")
+	}
+	c.advance(at(10, 3))
+	for _, tc := range []struct {
+		lg   types.Log
+		want bool
+	}{
+		{at(9, 7), true},
+		{at(10, 2), true},
+		{at(10, 3), true},
+		{at(10, 4), false},
+		{at(11, 0), false},
+	} {
+		if got := c.handled(tc.lg); got != tc.want {
+			t.Errorf("handled(%d/%d) = %v, want %v", tc.lg.BlockNumber, tc.lg.Index, got, tc.want)
+		}
+	}
+}
+
+func TestLogCursorRetreat(t *testing.T) {
+	var c logCursor
+	at := func(block uint64, index uint) types.Log { return types.Log{BlockNumber: block, Index: index} }
+	c.advance(at(10, 3))
+	c.retreat(at(10, 2))
+	if c.handled(at(10, 2)) || !c.handled(at(10, 1)) {
+		t.Fatalf("after retreating past 10/2: cursor %+v", c)
+	}
+	c.retreat(at(10, 0))
+	if c.handled(at(10, 0)) || !c.handled(at(9, 1<<20)) {
+		t.Fatalf("after retreating past 10/0: cursor %+v", c)
+	}
+	// A removed log the cursor never reached leaves it alone.
+	c.retreat(at(12, 0))
+	if !c.handled(at(9, 5)) || c.handled(at(10, 0)) {
+		t.Fatalf("retreat past an unseen log moved the cursor: %+v", c)
+	}
+}
+
+func TestReconnectWait(t *testing.T) {
+	max := 10 * time.Second
+	for n, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 5: max, 50: max} {
+		if got := reconnectWait(n, max); got != want {
+			t.Errorf("reconnectWait(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
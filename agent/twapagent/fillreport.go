@@ -0,0 +1,191 @@
+package twapagent
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// priceDecimals is how many decimals execution prices are rounded to.
+const priceDecimals = 12
+
+// impliedPrice is out/in in whole tokens of tokenOut per tokenIn, or "" when
+// in is zero.
+func impliedPrice(in, out *big.Int, decIn, decOut uint8) string {
+	if in == nil || out == nil || in.Sign() == 0 {
+		return ""
+	}
+	return wholePrice(new(big.Rat).SetFrac(out, in), decIn, decOut)
+}
+
+// wholePrice turns a price in raw tokenOut per raw tokenIn into whole
+// tokens, or "" for nil.
+func wholePrice(raw *big.Rat, decIn, decOut uint8) string {
+	if raw == nil {
+		return ""
+	}
+	scale := new(big.Rat).SetFrac(
+		new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decIn)), nil),
+		new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decOut)), nil),
+	)
+	p := new(big.Rat).Mul(raw, scale).FloatString(priceDecimals)
+	return strings.TrimSuffix(strings.TrimRight(p, "0"), ".")
+}
+
+// fillRow is one line of the fill report. Amounts are in whole tokens; the
+// fee is raw, as its unit is up to the adapter. OraclePrice is the oracle's
+// price at the fill's block, empty when it couldn't be read there, and
+// ShortfallBps how far Price fell short of it.
+type fillRow struct {
+	Slice        int64  `json:"slice"`
+	Block        uint64 `json:"block"`
+	Timestamp    string `json:"timestamp"`
+	AmountIn     string `json:"amountIn"`
+	AmountOut    string `json:"amountOut"`
+	Fee          string `json:"fee"`
+	Price        string `json:"price"`
+	OraclePrice  string `json:"oraclePrice,omitempty"`
+	ShortfallBps string `json:"shortfallBps,omitempty"`
+	TxHash       string `json:"txHash"`
+}
+
+// fillTotals is the report's footer: summed amounts, the volume-weighted
+// average price (total out over total in), and that price's shortfall
+// against the benchmark, the oracle's TWAP over the fills.
+type fillTotals struct {
+	AmountIn     string `json:"amountIn"`
+	AmountOut    string `json:"amountOut"`
+	Fee          string `json:"fee"`
+	VWAP         string `json:"vwap"`
+	Benchmark    string `json:"benchmark,omitempty"`
+	ShortfallBps string `json:"shortfallBps,omitempty"`
+}
+
+type reportToken struct {
+	Address  common.Address `json:"address"`
+	Symbol   string         `json:"symbol"`
+	Decimals uint8          `json:"decimals"`
+}
+
+// fillReport is report mode's CSV and JSON output.
+type fillReport struct {
+	TokenIn  reportToken `json:"tokenIn"`
+	TokenOut reportToken `json:"tokenOut"`
+	Fills    []fillRow   `json:"fills"`
+	Totals   fillTotals  `json:"totals"`
+}
+
+// buildFillReport turns fills into rows scaled by the tokens' decimals.
+// oracle holds the oracle's price at each fill's block (see sampleOracle),
+// or is nil to leave the benchmark out.
+func buildFillReport(in, out reportToken, fills []fillRecord, oracle []*big.Int, blockTime func(uint64) (uint64, error)) (fillReport, error) {
+	r := fillReport{TokenIn: in, TokenOut: out, Fills: []fillRow{}}
+	sumIn, sumOut, sumFee := new(big.Int), new(big.Int), new(big.Int)
+	for i, f := range fills {
+		t, err := blockTime(f.Block)
+		if err != nil {
+			return r, err
+		}
+		r.Fills = append(r.Fills, fillRow{
+			Slice:     f.Slice,
+			Block:     f.Block,
+			Timestamp: unixUTC(t),
+			AmountIn:  formatUnits(f.AmountIn, in.Decimals),
+			AmountOut: formatUnits(f.AmountOut, out.Decimals),
+			Fee:       f.Fee.String(),
+			Price:     impliedPrice(f.AmountIn, f.AmountOut, in.Decimals, out.Decimals),
+			TxHash:    f.Tx.Hex(),
+		})
+		if i < len(oracle) && oracle[i] != nil {
+			row := &r.Fills[len(r.Fills)-1]
+			p := oracleRat(oracle[i])
+			row.OraclePrice = wholePrice(p, in.Decimals, out.Decimals)
+			row.ShortfallBps = shortfallBps(realizedPrice(f.AmountIn, f.AmountOut), p)
+		}
+		sumIn.Add(sumIn, f.AmountIn)
+		sumOut.Add(sumOut, f.AmountOut)
+		sumFee.Add(sumFee, f.Fee)
+	}
+	r.Totals = fillTotals{
+		AmountIn:  formatUnits(sumIn, in.Decimals),
+		AmountOut: formatUnits(sumOut, out.Decimals),
+		Fee:       sumFee.String(),
+		VWAP:      impliedPrice(sumIn, sumOut, in.Decimals, out.Decimals),
+	}
+	if bench := benchmarkPrice(oracle); bench != nil {
+		r.Totals.Benchmark = wholePrice(bench, in.Decimals, out.Decimals)
+		r.Totals.ShortfallBps = shortfallBps(realizedPrice(sumIn, sumOut), bench)
+	}
+	return r, nil
+}
+
+func (r fillReport) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"slice", "block", "timestamp", "amount_in", "amount_out", "fee", "price", "oracle_price", "shortfall_bps", "tx_hash"})
+	for _, f := range r.Fills {
+		cw.Write([]string{strconv.FormatInt(f.Slice, 10), strconv.FormatUint(f.Block, 10), f.Timestamp, f.AmountIn, f.AmountOut, f.Fee, f.Price, f.OraclePrice, f.ShortfallBps, f.TxHash})
+	}
+	cw.Write([]string{"total", "", "", r.Totals.AmountIn, r.Totals.AmountOut, r.Totals.Fee, r.Totals.VWAP, r.Totals.Benchmark, r.Totals.ShortfallBps, ""})
+	cw.Flush()
+	return cw.Error()
+}
+
+func (r fillReport) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// reportTokenOf reads symbol and decimals; a token without decimals is reported
+// in raw units.
+func reportTokenOf(ctx context.Context, client *ethclient.Client, token common.Address) reportToken {
+	info := readTokenInfo(ctx, client, token)
+	if !info.Known {
+		logf(ctx, "%s has no decimals(); reporting its amounts raw", token.Hex())
+	}
+	return reportToken{Address: token, Symbol: info.Symbol, Decimals: info.Decimals}
+}
+
+// reportFills is report mode with --output csv or json: every Fill since
+// startTime with its execution price and the oracle's at its block, and a
+// totals row with the VWAP against the oracle TWAP.
+func reportFills(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, shape OracleShape, chunkBlocks uint64, format, outPath string) error {
+	s, err := readStrategy(ctx, addr, cABI, client)
+	if err != nil {
+		return fmt.Errorf("read strategy: %w", err)
+	}
+	if s.StartTime == nil || s.StartTime.Sign() == 0 {
+		return errNotInitialized
+	}
+	fills, times, _, err := readFills(ctx, addr, cABI, client, s, chunkBlocks)
+	if err != nil {
+		return err
+	}
+	in, out := reportTokenOf(ctx, client, s.TokenIn), reportTokenOf(ctx, client, s.TokenOut)
+	r, err := buildFillReport(in, out, fills, sampleOracle(ctx, client, shape, s, fills), func(b uint64) (uint64, error) { return times.get(ctx, b) })
+	if err != nil {
+		return err
+	}
+	w, err := createOutput(ctx, outPath)
+	if err != nil {
+		return err
+	}
+	if format == formatJSON {
+		err = r.writeJSON(w)
+	} else {
+		err = r.writeCSV(w)
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package twapagent
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestImpliedPrice(t *testing.T) {
+	e := func(n int64, dec int64) *big.Int {
+		return new(big.Int).Mul(big.NewInt(n), new(big.Int).Exp(big.NewInt(10), big.NewInt(dec), nil))
+	}
+	for _, tc := range []struct {
+		in, out       *big.Int
+		decIn, decOut uint8
+		want          string
+	}{
+		{e(1, 18), e(2000, 6), 18, 6, "2000"},   // 1 WETH -> 2000 USDC
+		{e(2000, 6), e(1, 18), 6, 18, "0.0005"}, // and back
+		{big.NewInt(3), big.NewInt(1), 0, 0, "0.333333333333"},
+		{big.NewInt(0), big.NewInt(1), 0, 0, ""},
+	} {
+		if got := impliedPrice(tc.in, tc.out, tc.decIn, tc.decOut); got != tc.want {
+			t.Errorf("price(%s -> %s) = %q, want %q", tc.in, tc.out, got, tc.want)
+		}
+	}
+}
+
+func TestBuildFillReport(t *testing.T) {
+	in := reportToken{Symbol: "WETH", Decimals: 18}
+	out := reportToken{Symbol: "USDC", Decimals: 6}
+	eth, _ := new(big.Int).SetString("1000000000000000000", 10)
+	fills := []fillRecord{
+		{Slice: 0, AmountIn: eth, AmountOut: big.NewInt(2000e6), Fee: big.NewInt(5), Block: 10},
+		{Slice: 1, AmountIn: eth, AmountOut: big.NewInt(2100e6), Fee: big.NewInt(7), Block: 20},
+	}
+	r, err := buildFillReport(in, out, fills, nil, func(b uint64) (uint64, error) { return 1000 + b, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Fills[1].Price != "2100" || r.Fills[0].AmountIn != "1" || r.Fills[1].Timestamp != "1970-01-01T00:17:00Z" {
+		t.Errorf("rows %+v", r.Fills)
+	}
+	if r.Totals.AmountIn != "2" || r.Totals.AmountOut != "4100" || r.Totals.Fee != "12" || r.Totals.VWAP != "2050" {
+		t.Errorf("totals %+v", r.Totals)
+	}
+	var buf bytes.Buffer
+	if err := r.writeCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || lines[3] != "total,,,2,4100,12,2050,,," {
+		t.Errorf("csv:\n%s", buf.String())
+	}
+}
+
+// With the oracle sampled at the first fill's block only, the second row has
+// no benchmark and the TWAP is that one sample.
+func TestBuildFillReportBenchmark(t *testing.T) {
+	in := reportToken{Symbol: "WETH", Decimals: 18}
+	out := reportToken{Symbol: "USDC", Decimals: 6}
+	eth, _ := new(big.Int).SetString("1000000000000000000", 10)
+	fills := []fillRecord{
+		{Slice: 0, AmountIn: eth, AmountOut: big.NewInt(1980e6), Fee: big.NewInt(0), Block: 10},
+		{Slice: 1, AmountIn: eth, AmountOut: big.NewInt(2020e6), Fee: big.NewInt(0), Block: 20},
+	}
+	oracle := []*big.Int{big.NewInt(2_000_000_000), nil}
+	r, err := buildFillReport(in, out, fills, oracle, func(b uint64) (uint64, error) { return b, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Fills[0].OraclePrice != "2000" || r.Fills[0].ShortfallBps != "100.00" || r.Fills[1].OraclePrice != "" || r.Fills[1].ShortfallBps != "" {
+		t.Errorf("rows %+v", r.Fills)
+	}
+	if r.Totals.VWAP != "2000" || r.Totals.Benchmark != "2000" || r.Totals.ShortfallBps != "0.00" {
+		t.Errorf("totals %+v", r.Totals)
+	}
+	var buf bytes.Buffer
+	if err := r.writeCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || lines[1] != "0,10,1970-01-01T00:00:10Z,1,1980,0,1980,2000,100.00,"+fills[0].Tx.Hex() {
+		t.Errorf("csv:\n%s", buf.String())
+	}
+}
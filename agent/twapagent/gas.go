@@ -0,0 +1,303 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	"twap-agent/twapbind"
+)
+
+// Transaction pricing modes accepted by --tx-type.
+const (
+	txTypeLegacy  = "legacy"
+	txTypeDynamic = "dynamic"
+	txTypeAuto    = "auto"
+)
+
+// TxConfig groups the settings that shape how execute() builds transactions.
+type TxConfig struct {
+	TxType string
+	// Fee overrides from --priority-fee-gwei/--fee-cap-gwei, in wei; nil uses the node's suggestion.
+	PriorityFee *big.Int
+	FeeCap      *big.Int
+	// Gas ceilings; nil means no ceiling.
+	MaxGasPrice  *big.Int
+	MaxFeePerGas *big.Int
+	// Once a slice is overdue by more than this many seconds the ceiling is ignored.
+	CeilingGrace uint64
+	// Replace-by-fee: resubmit a pending tx every BumpAfter seconds with fees raised by BumpPercent.
+	BumpAfter   uint64
+	BumpPercent float64
+	MaxBumps    int
+	// Fixed gas limit (0 = estimate) and the safety margin added on top of estimates.
+	GasLimit         uint64
+	GasBufferPercent uint64
+	// Submit without simulating executeSlice via eth_call first.
+	SkipSimulation bool
+	// How long to wait for a receipt (0 = forever) and how often to poll for it.
+	WaitTimeout         time.Duration
+	ReceiptPollInterval time.Duration
+	// Heads that must build on a receipt's block, or on a Fill's in bot
+	// mode, before it is acted on (0 = at once).
+	Confirmations uint64
+	// A submitted slice is not resubmitted until its receipt or Fill is seen, or this much time passes.
+	ResubmitAfter time.Duration
+	// Cancel a tx still pending after this long with a self-transfer at its nonce (0 disables).
+	TxDeadline time.Duration
+	// Write executeSlice calls as unsigned JSON here ("-" = stdout) instead of
+	// sending them; Force allows slices that aren't due yet.
+	UnsignedOut string
+	Force       bool
+	// Limit on sliceDone reads when looking for the next slice outside bot
+	// mode (0 = none).
+	MaxScanSlices int64
+	// Bot mode: submit all overdue slices in one pass (--catchup), in
+	// parallel rather than receipt by receipt (--catchup-parallel).
+	Catchup         bool
+	CatchupParallel bool
+	// Submit a slice this many seconds ahead of its schedule at most, when
+	// the next block is expected to reach it (0 = never early).
+	LeadTimeSeconds uint64
+	// Bot mode: log the order's progress this often, besides after each
+	// fill (0 = only after fills).
+	ProgressInterval time.Duration
+	// How to read the oracle for the deviation check, and whether to hold
+	// a slice back while the check would fail.
+	Oracle          OracleShape
+	SkipOnDeviation bool
+	// Kill switch: hold every slice back while the feed is older than
+	// MaxOracleAge or deviates by more than HaltDeviationBps (0 = off).
+	MaxOracleAge     time.Duration
+	HaltDeviationBps uint
+	// Bot mode: watch without submitting until Agent.Resume (SIGUSR2 in the CLI).
+	StartPaused bool
+	// On SIGINT/SIGTERM, wait this long for submitted txs to mine.
+	ShutdownGrace time.Duration
+	// Venue quote logged before each slice (--adapter-kind).
+	Quoter AdapterQuoter
+	// Chainlink feeds to show slices, fills and gas in dollars.
+	USD USDConfig
+	// Print the executeSlice txs that would be sent instead of signing them.
+	DryRun bool
+}
+
+func validTxType(t string) bool {
+	return t == txTypeLegacy || t == txTypeDynamic || t == txTypeAuto
+}
+
+// gasQuote is the pricing execute() would apply to a transaction right now.
+type gasQuote struct {
+	Mode     string
+	GasPrice *big.Int // legacy only
+	BaseFee  *big.Int // dynamic only
+	TipCap   *big.Int // dynamic only
+	FeeCap   *big.Int // dynamic only
+}
+
+// resolveTxType turns auto into legacy or dynamic depending on whether the
+// chain reports a base fee in its latest header.
+func resolveTxType(ctx context.Context, client *ethclient.Client, txType string) (string, *big.Int, error) {
+	if txType == txTypeLegacy {
+		return txTypeLegacy, nil, nil
+	}
+	head, err := headerByNumber(ctx, client, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("header: %w", err)
+	}
+	if head.BaseFee == nil {
+		if txType == txTypeDynamic {
+			return "", nil, fmt.Errorf("dynamic pricing requested but chain reports no base fee")
+		}
+		return txTypeLegacy, nil, nil
+	}
+	return txTypeDynamic, head.BaseFee, nil
+}
+
+// errFeeCapTooLow means the configured fee cap can't cover base fee + tip.
+var errFeeCapTooLow = errors.New("fee cap below base fee + priority fee")
+
+// quoteGas fetches current suggested pricing for the resolved tx type and
+// applies the --priority-fee-gwei/--fee-cap-gwei overrides. With both set the
+// suggestion is ignored entirely; a priority fee alone is added on top of the
+// current base fee.
+func quoteGas(ctx context.Context, client *ethclient.Client, txCfg TxConfig) (gasQuote, error) {
+	mode, baseFee, err := resolveTxType(ctx, client, txCfg.TxType)
+	if err != nil {
+		return gasQuote{}, err
+	}
+	if mode == txTypeLegacy {
+		return quoteLegacy(ctx, client, txCfg)
+	}
+	tip := txCfg.PriorityFee
+	if tip == nil {
+		err = rpcRead(ctx, "eth_maxPriorityFeePerGas", func(ctx context.Context) (err error) {
+			tip, err = client.SuggestGasTipCap(ctx)
+			return err
+		})
+		if err != nil {
+			return gasQuote{Mode: mode, BaseFee: baseFee}, fmt.Errorf("suggest gas tip cap: %w", err)
+		}
+	}
+	feeCap := txCfg.FeeCap
+	if feeCap == nil {
+		// Same headroom go-ethereum uses by default: survive a few full blocks of base fee growth.
+		feeCap = new(big.Int).Add(tip, new(big.Int).Mul(baseFee, big.NewInt(2)))
+	}
+	q := gasQuote{Mode: mode, BaseFee: baseFee, TipCap: tip, FeeCap: feeCap}
+	return q, checkFeeCap(feeCap, baseFee, tip)
+}
+
+// quoteLegacy prices a type-0 transaction. A configured priority fee is added
+// to the chain's base fee when it reports one, and to the node's suggested
+// gas price otherwise; the fee cap bounds the result.
+func quoteLegacy(ctx context.Context, client *ethclient.Client, txCfg TxConfig) (gasQuote, error) {
+	q := gasQuote{Mode: txTypeLegacy}
+	var baseFee *big.Int
+	if txCfg.PriorityFee != nil {
+		head, err := headerByNumber(ctx, client, nil)
+		if err != nil {
+			return q, fmt.Errorf("header: %w", err)
+		}
+		baseFee = head.BaseFee
+	}
+	switch {
+	case txCfg.PriorityFee != nil && baseFee != nil:
+		q.GasPrice = new(big.Int).Add(baseFee, txCfg.PriorityFee)
+	case txCfg.PriorityFee != nil && txCfg.FeeCap != nil:
+		// No base fee to build on: the cap is the price.
+		q.GasPrice = new(big.Int).Set(txCfg.FeeCap)
+	default:
+		var gp *big.Int
+		err := rpcRead(ctx, "eth_gasPrice", func(ctx context.Context) (err error) {
+			gp, err = client.SuggestGasPrice(ctx)
+			return err
+		})
+		if err != nil {
+			return q, fmt.Errorf("suggest gas price: %w", err)
+		}
+		q.GasPrice = gp
+		if txCfg.PriorityFee != nil {
+			q.GasPrice = new(big.Int).Add(gp, txCfg.PriorityFee)
+		}
+	}
+	if txCfg.FeeCap != nil && q.GasPrice.Cmp(txCfg.FeeCap) > 0 {
+		if baseFee != nil {
+			return q, checkFeeCap(txCfg.FeeCap, baseFee, txCfg.PriorityFee)
+		}
+		q.GasPrice = new(big.Int).Set(txCfg.FeeCap)
+	}
+	return q, nil
+}
+
+func checkFeeCap(feeCap, baseFee, tip *big.Int) error {
+	if baseFee == nil || tip == nil {
+		return nil
+	}
+	min := new(big.Int).Add(baseFee, tip)
+	if feeCap.Cmp(min) < 0 {
+		return fmt.Errorf("%w: cap %s wei < %s wei (baseFee %s + tip %s)", errFeeCapTooLow, feeCap, min, baseFee, tip)
+	}
+	return nil
+}
+
+// apply sets either GasPrice (legacy) or GasFeeCap/GasTipCap (EIP-1559) on auth.
+func (q gasQuote) apply(auth *bind.TransactOpts) {
+	if q.Mode == txTypeDynamic {
+		if q.FeeCap != nil {
+			auth.GasFeeCap = new(big.Int).Set(q.FeeCap)
+			auth.GasTipCap = new(big.Int).Set(q.TipCap)
+		}
+		return
+	}
+	if q.GasPrice != nil {
+		auth.GasPrice = new(big.Int).Set(q.GasPrice)
+	}
+}
+
+// effectivePrice is the per-gas price a transaction is expected to pay.
+func (q gasQuote) effectivePrice() *big.Int {
+	if q.Mode == txTypeDynamic {
+		if q.BaseFee == nil || q.TipCap == nil {
+			return nil
+		}
+		return new(big.Int).Add(q.BaseFee, q.TipCap)
+	}
+	return q.GasPrice
+}
+
+// maxPrice is the most a transaction priced by q can pay per gas.
+func (q gasQuote) maxPrice() *big.Int {
+	if q.Mode == txTypeDynamic {
+		return q.FeeCap
+	}
+	return q.GasPrice
+}
+
+// fees renders the quote for the planning log line.
+func (q gasQuote) fees() string {
+	if q.Mode == txTypeDynamic && q.FeeCap != nil {
+		return fmt.Sprintf("maxFeePerGas=%s wei, maxPriorityFeePerGas=%s wei, baseFee=%s wei", q.FeeCap, q.TipCap, q.BaseFee)
+	}
+	if q.GasPrice != nil {
+		return fmt.Sprintf("gasPrice=%s wei", q.GasPrice)
+	}
+	return ""
+}
+
+// hasCeiling reports whether --max-gas-price or --max-fee-per-gas is set.
+func (c TxConfig) hasCeiling() bool {
+	return c.MaxGasPrice != nil || c.MaxFeePerGas != nil
+}
+
+// checkCeiling compares the quote against the configured ceiling. For 1559
+// pricing --max-fee-per-gas caps maxFeePerGas; without it --max-gas-price
+// caps the expected effective price (baseFee + tip).
+func (c TxConfig) checkCeiling(q gasQuote) (price, ceiling *big.Int, over bool) {
+	if q.Mode == txTypeDynamic && c.MaxFeePerGas != nil {
+		price, ceiling = q.FeeCap, c.MaxFeePerGas
+	} else if c.MaxGasPrice != nil {
+		price, ceiling = q.effectivePrice(), c.MaxGasPrice
+	}
+	if price == nil || ceiling == nil {
+		return price, ceiling, false
+	}
+	return price, ceiling, price.Cmp(ceiling) > 0
+}
+
+// planGasLimit sets auth.GasLimit either from --gas-limit or from the node's
+// estimate scaled up by --gas-buffer-percent, and reports which one was used.
+func planGasLimit(twap *twapbind.Twap, auth *bind.TransactOpts, txCfg TxConfig, sliceId int64) (string, error) {
+	if txCfg.GasLimit > 0 {
+		auth.GasLimit = txCfg.GasLimit
+		return "override", nil
+	}
+	// Build (but don't send) the tx to let bind run its usual estimation.
+	opts := *auth
+	opts.NoSend = true
+	opts.GasLimit = 0
+	// Skip signing: with KMS or a remote signer that is a round trip per estimate.
+	opts.Signer = func(_ common.Address, tx *types.Transaction) (*types.Transaction, error) { return tx, nil }
+	parent := opts.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	var tx *types.Transaction
+	err := rpcRead(parent, "eth_estimateGas", func(ctx context.Context) (err error) {
+		opts.Context = ctx
+		tx, err = twap.ExecuteSlice(&opts, big.NewInt(sliceId))
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("estimate gas: %w", err)
+	}
+	auth.GasLimit = tx.Gas() * (100 + txCfg.GasBufferPercent) / 100
+	return fmt.Sprintf("estimate %d +%d%%", tx.Gas(), txCfg.GasBufferPercent), nil
+}
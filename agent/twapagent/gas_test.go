@@ -0,0 +1,37 @@
+package twapagent
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// On a chain without a base fee, a priority fee alone goes on top of the
+// node's gas price, and the fee cap still bounds it.
+func TestQuoteLegacyAddsPriorityFeeWithoutBaseFee(t *testing.T) {
+	d := startTestDevnet(t, devnetConfig(t, 0))
+	client, err := ethclient.Dial(d.url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	tip := big.NewInt(2e9)
+	for _, tc := range []struct {
+		name   string
+		feeCap *big.Int
+		want   *big.Int
+	}{
+		{"tip only", nil, new(big.Int).Add(devGasPrice, tip)},
+		{"capped", big.NewInt(25e8), big.NewInt(25e8)},
+	} {
+		q, err := quoteGas(context.Background(), client, TxConfig{TxType: txTypeAuto, PriorityFee: tip, FeeCap: tc.feeCap})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if q.Mode != txTypeLegacy || q.GasPrice.Cmp(tc.want) != 0 {
+			t.Errorf("%s: %s gasPrice %s, want legacy %s", tc.name, q.Mode, q.GasPrice, tc.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// haltReason is why c should stop the bot submitting, or "" when it shouldn't:
+// a Chainlink round older than maxAge, or a deviation over haltBps. Zero
+// limits are off; an IOracle read has no updatedAt to age.
+func haltReason(c priceCheck, now time.Time, maxAge time.Duration, haltBps uint) string {
+	if maxAge > 0 && c.UpdatedAt > 0 {
+		updated := time.Unix(int64(c.UpdatedAt), 0)
+		if age := now.Sub(updated); age > maxAge {
+			return fmt.Sprintf("oracle stale, updated %s ago (max %s)", age.Truncate(time.Second), maxAge)
+		}
+	}
+	if haltBps > 0 && c.DeviationBps != nil && c.DeviationBps.Cmp(new(big.Int).SetUint64(uint64(haltBps))) > 0 {
+		return fmt.Sprintf("oracle deviation %s bps over %d", c.DeviationBps, haltBps)
+	}
+	return ""
+}
+
+// haltSwitch is the kill switch: while halted the bot keeps watching heads
+// but submits nothing. Each price check sets or clears it, so it resumes on
+// its own once the oracle is back in line.
+type haltSwitch struct {
+	mu     sync.Mutex
+	reason string
+	since  time.Time
+}
+
+// update halts with reason, or resumes when reason is "", and logs and emits
+// the transition when there is one.
+func (h *haltSwitch) update(ctx context.Context, reason string) {
+	h.mu.Lock()
+	prev, since := h.reason, h.since
+	h.reason = reason
+	if reason != "" && prev == "" {
+		h.since = time.Now()
+	}
+	h.mu.Unlock()
+	switch {
+	case reason != "" && prev == "":
+		warnf(ctx, "halting: %s", reason)
+		emitEvent(ctx, evHalted, 0, map[string]interface{}{"reason": reason})
+	case reason == "" && prev != "":
+		halted := time.Since(since).Truncate(time.Second)
+		logf(ctx, "resuming after %s halted (%s)", halted, prev)
+		emitEvent(ctx, evResumed, 0, map[string]interface{}{"reason": prev, "haltedSeconds": int64(halted / time.Second)})
+	}
+}
+
+// Reason is why the bot is halted, or "".
+func (h *haltSwitch) Reason() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.reason
+}
+
+// holding logs that sliceId isn't submitted and returns true while halted.
+func (h *haltSwitch) holding(ctx context.Context, sliceId int64) bool {
+	reason := h.Reason()
+	if reason == "" {
+		return false
+	}
+	logf(ctx, "halted: %s; not submitting slice %d", reason, sliceId)
+	return true
+}
+
+// runState is "paused" while the operator has paused submissions, "halted"
+// while the kill switch holds them, else "active".
+func (st *botState) runState() string {
+	switch {
+	case st.paused.Load():
+		return "paused"
+	case st.halt.Reason() != "":
+		return "halted"
+	}
+	return "active"
+}
@@ -0,0 +1,52 @@
+package twapagent
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHaltReason(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	fresh := uint64(now.Add(-30 * time.Second).Unix())
+	stale := uint64(now.Add(-2 * time.Hour).Unix())
+	for _, tc := range []struct {
+		name      string
+		updatedAt uint64
+		bps       int64
+		maxAge    time.Duration
+		haltBps   uint
+		want      string
+	}{
+		{"all off", stale, 900, 0, 0, ""},
+		{"fresh", fresh, 10, time.Hour, 100, ""},
+		{"stale", stale, 10, time.Hour, 100, "oracle stale"},
+		{"ioracle has no age", 0, 10, time.Hour, 0, ""},
+		{"deviation at the limit", fresh, 100, time.Hour, 100, ""},
+		{"deviation over", fresh, 101, time.Hour, 100, "deviation 101 bps over 100"},
+	} {
+		c := priceCheck{UpdatedAt: tc.updatedAt, DeviationBps: big.NewInt(tc.bps)}
+		got := haltReason(c, now, tc.maxAge, tc.haltBps)
+		if (tc.want == "") != (got == "") || !strings.Contains(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestHaltSwitch(t *testing.T) {
+	var h haltSwitch
+	ctx := context.Background()
+	if h.holding(ctx, 0) {
+		t.Fatal("a new switch should not hold")
+	}
+	h.update(ctx, "oracle stale")
+	if !h.holding(ctx, 0) || h.Reason() != "oracle stale" {
+		t.Fatalf("halted: holding %v, reason %q", h.holding(ctx, 0), h.Reason())
+	}
+	h.update(ctx, "")
+	if h.holding(ctx, 0) {
+		t.Error("the switch should resume once the reason clears")
+	}
+}
@@ -0,0 +1,161 @@
+package twapagent
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// inFlightGuard allows at most one executeSlice submission, or one --catchup
+// batch, to be outstanding while the bot loop keeps processing heads and logs.
+type inFlightGuard struct {
+	mu     sync.Mutex
+	slices map[int64]bool
+}
+
+// TryAcquire marks slice as in flight. It fails if any slice is already in flight.
+func (g *inFlightGuard) TryAcquire(slice int64) bool {
+	return g.TryAcquireAll([]int64{slice})
+}
+
+// TryAcquireAll marks every slice of a catch-up batch as in flight at once.
+// It fails if any slice is already in flight.
+func (g *inFlightGuard) TryAcquireAll(slices []int64) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.slices) > 0 || len(slices) == 0 {
+		return false
+	}
+	g.slices = make(map[int64]bool, len(slices))
+	for _, s := range slices {
+		g.slices[s] = true
+	}
+	return true
+}
+
+// Release clears slice if it is in flight; the guard stays active while
+// other slices of its batch are. Releasing a slice that is not in flight
+// (e.g. a late Fill after a timeout) is a no-op.
+func (g *inFlightGuard) Release(slice int64) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.slices[slice] {
+		return false
+	}
+	delete(g.slices, slice)
+	return true
+}
+
+// Current returns the lowest slice in flight, if any.
+func (g *inFlightGuard) Current() (int64, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	lowest, ok := int64(0), false
+	for s := range g.slices {
+		if !ok || s < lowest {
+			lowest, ok = s, true
+		}
+	}
+	return lowest, ok
+}
+
+// submission records a broadcast executeSlice tx.
+type submission struct {
+	Hash  common.Hash
+	Nonce *uint64 // nil when the relayer picked it
+	At    time.Time
+}
+
+// submittedSlices remembers slices whose tx was broadcast but not yet seen
+// mined, so later heads don't resubmit them while the first tx is pending
+// (including after the receipt wait gave up).
+type submittedSlices struct {
+	mu sync.Mutex
+	m  map[int64]submission
+	// Slices a tx was ever broadcast for, kept after Clear.
+	sent map[int64]bool
+}
+
+func (s *submittedSlices) Mark(slice int64, hash common.Hash, at time.Time) {
+	s.mark(slice, submission{Hash: hash, At: at})
+}
+
+// MarkTx is Mark for a tx the agent signed, keeping its nonce so a restart
+// can tell whether a replacement used it.
+func (s *submittedSlices) MarkTx(slice int64, tx *types.Transaction, at time.Time) {
+	nonce := tx.Nonce()
+	s.mark(slice, submission{Hash: tx.Hash(), Nonce: &nonce, At: at})
+}
+
+func (s *submittedSlices) mark(slice int64, sub submission) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[int64]submission)
+	}
+	s.m[slice] = sub
+	if sub.Hash != (common.Hash{}) {
+		if s.sent == nil {
+			s.sent = make(map[int64]bool)
+		}
+		s.sent[slice] = true
+	}
+}
+
+// Sent reports whether a tx was broadcast for slice before, so submitting
+// it now is a retry.
+func (s *submittedSlices) Sent(slice int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sent[slice]
+}
+
+// Clear forgets slice once its receipt or Fill event has been seen.
+func (s *submittedSlices) Clear(slice int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, slice)
+}
+
+// All returns the outstanding submissions that were broadcast, by slice.
+func (s *submittedSlices) All() map[int64]submission {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := make(map[int64]submission, len(s.m))
+	for id, sub := range s.m {
+		if sub.Hash != (common.Hash{}) { // dry runs and unsigned calls mark no tx
+			all[id] = sub
+		}
+	}
+	return all
+}
+
+// records returns the outstanding broadcast submissions for the state file,
+// by slice.
+func (s *submittedSlices) records() []submissionRecord {
+	var recs []submissionRecord
+	for id, sub := range s.All() {
+		recs = append(recs, submissionRecord{Slice: id, Hash: sub.Hash, Nonce: sub.Nonce, At: sub.At})
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Slice < recs[j].Slice })
+	return recs
+}
+
+// Pending reports the outstanding submission for slice. Entries older than
+// expiry are dropped so a tx that was silently discarded gets retried.
+func (s *submittedSlices) Pending(slice int64, expiry time.Duration, now time.Time) (submission, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.m[slice]
+	if !ok {
+		return submission{}, false
+	}
+	if expiry > 0 && now.Sub(sub.At) >= expiry {
+		delete(s.m, slice)
+		return submission{}, false
+	}
+	return sub, true
+}
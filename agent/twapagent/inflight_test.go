@@ -0,0 +1,121 @@
+package twapagent
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestInFlightGuardSingleSubmission(t *testing.T) {
+	var g inFlightGuard
+	var wins int32
+	var wg sync.WaitGroup
+	// Simulate many heads racing to submit the same slice.
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if g.TryAcquire(3) {
+				atomic.AddInt32(&wins, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if wins != 1 {
+		t.Fatalf("acquired %d times, want 1", wins)
+	}
+	if g.TryAcquire(4) {
+		t.Fatal("second slice acquired while another is in flight")
+	}
+}
+
+func TestInFlightGuardRelease(t *testing.T) {
+	var g inFlightGuard
+	if !g.TryAcquire(1) {
+		t.Fatal("acquire on idle guard failed")
+	}
+	if g.Release(2) {
+		t.Fatal("released a slice that is not in flight")
+	}
+	// A Fill event and the receipt path may both release; only one succeeds.
+	if !g.Release(1) || g.Release(1) {
+		t.Fatal("expected exactly one successful release")
+	}
+	if _, ok := g.Current(); ok {
+		t.Fatal("guard still active after release")
+	}
+	if !g.TryAcquire(2) {
+		t.Fatal("acquire after release failed")
+	}
+	// The stale goroutine for slice 1 finishing must not clear slice 2.
+	g.Release(1)
+	if s, ok := g.Current(); !ok || s != 2 {
+		t.Fatalf("current = %d,%v; want 2,true", s, ok)
+	}
+}
+
+func TestInFlightGuardBatch(t *testing.T) {
+	var g inFlightGuard
+	if !g.TryAcquireAll([]int64{4, 5, 6}) {
+		t.Fatal("acquiring a batch on an idle guard failed")
+	}
+	if g.TryAcquire(7) {
+		t.Fatal("acquired a slice while a batch is in flight")
+	}
+	// A Fill for the first slice must leave the rest of the batch guarded.
+	g.Release(4)
+	if s, ok := g.Current(); !ok || s != 5 {
+		t.Fatalf("current = %d,%v; want 5,true", s, ok)
+	}
+	g.Release(6)
+	g.Release(5)
+	if _, ok := g.Current(); ok {
+		t.Fatal("guard still active after the whole batch was released")
+	}
+}
+
+func TestSubmittedSlicesExpiry(t *testing.T) {
+	var s submittedSlices
+	start := time.Unix(1_700_000_000, 0)
+	hash := common.HexToHash("0x01")
+	s.Mark(5, hash, start)
+
+	if sub, ok := s.Pending(5, time.Minute, start.Add(30*time.Second)); !ok || sub.Hash != hash {
+		t.Fatalf("pending = %v,%v; want recorded submission", sub, ok)
+	}
+	if _, ok := s.Pending(6, time.Minute, start); ok {
+		t.Fatal("unsubmitted slice reported pending")
+	}
+	if _, ok := s.Pending(5, time.Minute, start.Add(time.Minute)); ok {
+		t.Fatal("expired submission still pending")
+	}
+	// Expired entries are dropped, not resurrected.
+	if _, ok := s.Pending(5, 0, start); ok {
+		t.Fatal("expired submission came back")
+	}
+
+	s.Mark(7, hash, start)
+	s.Clear(7)
+	if _, ok := s.Pending(7, 0, start.Add(time.Hour)); ok {
+		t.Fatal("cleared submission still pending")
+	}
+}
+
+func TestSubmittedSlicesAll(t *testing.T) {
+	var s submittedSlices
+	now := time.Unix(1_700_000_000, 0)
+	s.Mark(1, common.HexToHash("0x01"), now)
+	s.Mark(2, common.Hash{}, now) // a dry run sends nothing
+	all := s.All()
+	if len(all) != 1 || all[1].Hash != common.HexToHash("0x01") {
+		t.Fatalf("All() = %v, want only slice 1", all)
+	}
+	// Slice 1 stays sent once cleared, so resubmitting it is a retry.
+	s.Clear(1)
+	if !s.Sent(1) || s.Sent(2) || s.Sent(3) {
+		t.Fatalf("Sent = %v %v %v, want only slice 1", s.Sent(1), s.Sent(2), s.Sent(3))
+	}
+}
@@ -0,0 +1,149 @@
+package twapagent
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// KeySource lists the ways agent keys can be supplied. Raw keys may be
+// repeated and mixed; otherwise at most one source may be set.
+type KeySource struct {
+	Hex          []string // --private-key (repeatable) / AGENT_PK
+	File         []string // --private-key-file (repeatable)
+	Keystore     string   // --keystore
+	PasswordFile string   // --keystore-password-file
+
+	Mnemonic           string // --mnemonic-file
+	MnemonicPassphrase string // --mnemonic-passphrase-file
+	HDPath             string // --hd-path
+}
+
+// local reports whether any local key source is set.
+func (s KeySource) local() bool {
+	return len(s.Hex) > 0 || len(s.File) > 0 || s.Keystore != "" || s.Mnemonic != ""
+}
+
+// loadAgentKeys returns the agent signing keys, or none if no source is set.
+func loadAgentKeys(src KeySource) ([]*ecdsa.PrivateKey, error) {
+	var set []string
+	if len(src.Hex) > 0 || len(src.File) > 0 {
+		set = append(set, "--private-key (or AGENT_PK) / --private-key-file")
+	}
+	if src.Keystore != "" {
+		set = append(set, "--keystore")
+	}
+	if src.Mnemonic != "" {
+		set = append(set, "--mnemonic-file")
+	}
+	if len(set) > 1 {
+		return nil, fmt.Errorf("%s are mutually exclusive; use one", strings.Join(set, " and "))
+	}
+	switch {
+	case src.Mnemonic != "":
+		key, err := readMnemonicKey(src.Mnemonic, src.MnemonicPassphrase, src.HDPath)
+		if err != nil {
+			return nil, err
+		}
+		return []*ecdsa.PrivateKey{key}, nil
+	case src.Keystore != "":
+		key, err := readKeystore(src.Keystore, src.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		return []*ecdsa.PrivateKey{key}, nil
+	}
+	var keys []*ecdsa.PrivateKey
+	for _, hex := range src.Hex {
+		key, err := parseHexKey(hex)
+		if err != nil {
+			return nil, fmt.Errorf("--private-key #%d: %w", len(keys)+1, err)
+		}
+		keys = append(keys, key)
+	}
+	for _, path := range src.File {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read private key file: %w", err)
+		}
+		key, err := parseHexKey(string(raw))
+		if err != nil {
+			return nil, fmt.Errorf("private key file %s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	seen := make(map[common.Address]bool, len(keys))
+	for _, k := range keys {
+		a := crypto.PubkeyToAddress(k.PublicKey)
+		if seen[a] {
+			return nil, fmt.Errorf("agent key for %s is given more than once", a.Hex())
+		}
+		seen[a] = true
+	}
+	return keys, nil
+}
+
+// parseHexKey parses a hex private key, tolerating a 0x prefix and surrounding whitespace.
+func parseHexKey(s string) (*ecdsa.PrivateKey, error) {
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(strings.TrimSpace(s), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("parse key: %w", err)
+	}
+	return key, nil
+}
+
+// readKeystore decrypts a go-ethereum UTC JSON keystore file. The password
+// comes from passwordFile, or an interactive prompt when none is given.
+func readKeystore(path, passwordFile string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read keystore: %w", err)
+	}
+	var password string
+	if passwordFile != "" {
+		raw, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return nil, fmt.Errorf("read keystore password file: %w", err)
+		}
+		password = strings.TrimRight(string(raw), "\r\n")
+	} else {
+		password, err = promptPassword(fmt.Sprintf("Password for %s: ", path))
+		if err != nil {
+			return nil, err
+		}
+	}
+	k, err := keystore.DecryptKey(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt keystore %s: %w", path, err)
+	}
+	return k.PrivateKey, nil
+}
+
+// promptPassword reads a line from stdin with terminal echo turned off where possible.
+func promptPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if err := stty("-echo"); err == nil {
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
@@ -0,0 +1,50 @@
+package twapagent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Hardhat's first two dev accounts.
+const (
+	testKey0 = "ac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80"
+	testKey1 = "0x59c6995e998f97a5a0044966f0945389dc9e86dae88c7a8412f4603b6b78690d"
+)
+
+func TestLoadAgentKeysRepeated(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(file, []byte(testKey1+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := loadAgentKeys(KeySource{Hex: []string{testKey0}, File: []string{file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266", "0x70997970C51812dc3A010C7d01b50e0d17dc79C8"}
+	if len(keys) != len(want) {
+		t.Fatalf("got %d keys, want %d", len(keys), len(want))
+	}
+	for i, k := range keys {
+		if got := crypto.PubkeyToAddress(k.PublicKey).Hex(); got != want[i] {
+			t.Errorf("key %d is %s, want %s", i, got, want[i])
+		}
+	}
+}
+
+func TestLoadAgentKeysRejectsDuplicates(t *testing.T) {
+	_, err := loadAgentKeys(KeySource{Hex: []string{testKey0, "0x" + testKey0}})
+	if err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Fatalf("err = %v, want duplicate key error", err)
+	}
+}
+
+func TestLoadAgentKeysExclusive(t *testing.T) {
+	_, err := loadAgentKeys(KeySource{Hex: []string{testKey0}, Keystore: "ks.json"})
+	if err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Fatalf("err = %v, want mutually exclusive error", err)
+	}
+}
@@ -0,0 +1,363 @@
+package twapagent
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// kmsSigner signs with an AWS KMS ECC_SECG_P256K1 key, so the key material
+// never leaves KMS. Requests are signed with SigV4 using credentials from the
+// environment or, failing that, the EC2 instance metadata service.
+type kmsSigner struct {
+	keyID    string
+	region   string
+	endpoint string
+	http     *http.Client
+	pub      *ecdsa.PublicKey
+	addr     common.Address
+
+	credMu sync.Mutex
+	creds  awsCredentials
+}
+
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time // zero for static credentials
+}
+
+// newKMSSigner fetches the key's public key and derives the agent address.
+// region may be empty when keyID is an ARN or AWS_REGION is set.
+func newKMSSigner(ctx context.Context, keyID, region string) (*kmsSigner, error) {
+	if region == "" {
+		region = kmsRegion(keyID)
+	}
+	if region == "" {
+		return nil, errors.New("kms: region unknown; set --kms-region or AWS_REGION")
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_KMS")
+	if endpoint == "" {
+		endpoint = "https://kms." + region + ".amazonaws.com"
+	}
+	s := &kmsSigner{
+		keyID:    keyID,
+		region:   region,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		http:     &http.Client{Timeout: 15 * time.Second},
+	}
+
+	var out struct {
+		PublicKey string
+		KeySpec   string
+	}
+	if err := s.call(ctx, "GetPublicKey", map[string]string{"KeyId": keyID}, &out); err != nil {
+		return nil, err
+	}
+	if out.KeySpec != "" && out.KeySpec != "ECC_SECG_P256K1" {
+		return nil, fmt.Errorf("kms: key %s has spec %s, want ECC_SECG_P256K1", keyID, out.KeySpec)
+	}
+	der, err := base64.StdEncoding.DecodeString(out.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("kms: decode public key: %w", err)
+	}
+	pub, err := parseKMSPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	s.pub = pub
+	s.addr = crypto.PubkeyToAddress(*pub)
+	return s, nil
+}
+
+// kmsRegion extracts the region from a key ARN, falling back to the environment.
+func kmsRegion(keyID string) string {
+	if parts := strings.Split(keyID, ":"); len(parts) > 3 && parts[0] == "arn" {
+		return parts[3]
+	}
+	if r := os.Getenv("AWS_REGION"); r != "" {
+		return r
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+func (s *kmsSigner) Address() common.Address { return s.addr }
+
+func (s *kmsSigner) TransactOpts(ctx context.Context, chainID uint64) (*bind.TransactOpts, error) {
+	signer := types.LatestSignerForChainID(new(big.Int).SetUint64(chainID))
+	return &bind.TransactOpts{
+		From:    s.addr,
+		Context: ctx,
+		Signer: func(from common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			if from != s.addr {
+				return nil, bind.ErrNotAuthorized
+			}
+			sig, err := s.SignHash(ctx, signer.Hash(tx).Bytes())
+			if err != nil {
+				return nil, err
+			}
+			return tx.WithSignature(signer, sig)
+		},
+	}, nil
+}
+
+// SignHash asks KMS to sign a 32-byte digest and returns it as r||s||v.
+func (s *kmsSigner) SignHash(ctx context.Context, hash []byte) ([]byte, error) {
+	req := map[string]string{
+		"KeyId":            s.keyID,
+		"Message":          base64.StdEncoding.EncodeToString(hash),
+		"MessageType":      "DIGEST",
+		"SigningAlgorithm": "ECDSA_SHA_256",
+	}
+	var out struct{ Signature string }
+	if err := s.call(ctx, "Sign", req, &out); err != nil {
+		return nil, err
+	}
+	der, err := base64.StdEncoding.DecodeString(out.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("kms: decode signature: %w", err)
+	}
+	return derToEthSignature(der, hash, s.pub)
+}
+
+// parseKMSPublicKey decodes the DER SubjectPublicKeyInfo KMS returns.
+func parseKMSPublicKey(der []byte) (*ecdsa.PublicKey, error) {
+	var spki struct {
+		Algorithm asn1.RawValue
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, fmt.Errorf("kms: parse public key: %w", err)
+	}
+	pub, err := crypto.UnmarshalPubkey(spki.PublicKey.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("kms: public key is not secp256k1: %w", err)
+	}
+	return pub, nil
+}
+
+var (
+	secp256k1N     = crypto.S256().Params().N
+	secp256k1HalfN = new(big.Int).Rsh(secp256k1N, 1)
+)
+
+// derToEthSignature converts an ASN.1 ECDSA signature into the 65-byte
+// r||s||v form. s is normalized to the lower half of the curve order, as
+// Ethereum rejects high-s signatures, and v is found by recovering the public
+// key with each candidate recovery id.
+func derToEthSignature(der, hash []byte, pub *ecdsa.PublicKey) ([]byte, error) {
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("kms: parse signature: %w", err)
+	}
+	if sig.S.Cmp(secp256k1HalfN) > 0 {
+		sig.S = new(big.Int).Sub(secp256k1N, sig.S)
+	}
+	out := make([]byte, 65)
+	sig.R.FillBytes(out[:32])
+	sig.S.FillBytes(out[32:64])
+	want := crypto.FromECDSAPub(pub)
+	for v := byte(0); v < 2; v++ {
+		out[64] = v
+		got, err := crypto.Ecrecover(hash, out)
+		if err == nil && bytes.Equal(got, want) {
+			return out, nil
+		}
+	}
+	return nil, errors.New("kms: signature does not recover to the key's public key")
+}
+
+// call performs one KMS JSON API request.
+func (s *kmsSigner) call(ctx context.Context, action string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	creds, err := s.credentials(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	signV4(req, body, creds, s.region, "kms", time.Now().UTC())
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("kms %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("kms %s: %w", action, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(data, &e)
+		return fmt.Errorf("kms %s: %s: %s %s", action, resp.Status, e.Type, e.Message)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// signV4 adds AWS Signature Version 4 headers to req.
+func signV4(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(req.Header.Get(k))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonical := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonHeaders.String(), signed, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	canonHash := sha256.Sum256([]byte(canonical))
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// credentials returns AWS credentials from the environment or, when unset,
+// the instance role via IMDSv2. Role credentials are refreshed before expiry.
+func (s *kmsSigner) credentials(ctx context.Context) (awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	s.credMu.Lock()
+	defer s.credMu.Unlock()
+	if s.creds.AccessKeyID != "" && time.Until(s.creds.Expiration) > 5*time.Minute {
+		return s.creds, nil
+	}
+	creds, err := imdsCredentials(ctx, s.http)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("kms: no AWS_ACCESS_KEY_ID and instance credentials unavailable: %w", err)
+	}
+	s.creds = creds
+	return creds, nil
+}
+
+const imdsBase = "http://169.254.169.254/latest"
+
+func imdsCredentials(ctx context.Context, hc *http.Client) (awsCredentials, error) {
+	tokReq, err := http.NewRequestWithContext(ctx, http.MethodPut, imdsBase+"/api/token", nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	tokReq.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := imdsGet(hc, tokReq)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	get := func(path string) (string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, imdsBase+path, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+		return imdsGet(hc, req)
+	}
+	roles, err := get("/meta-data/iam/security-credentials/")
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	role := strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+	if role == "" {
+		return awsCredentials{}, errors.New("no instance role attached")
+	}
+	raw, err := get("/meta-data/iam/security-credentials/" + role)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	var c struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal([]byte(raw), &c); err != nil {
+		return awsCredentials{}, fmt.Errorf("parse instance credentials: %w", err)
+	}
+	return awsCredentials{AccessKeyID: c.AccessKeyId, SecretAccessKey: c.SecretAccessKey, SessionToken: c.Token, Expiration: c.Expiration}, nil
+}
+
+func imdsGet(hc *http.Client, req *http.Request) (string, error) {
+	resp, err := hc.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,166 @@
+package twapagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestDERToEthSignature(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := crypto.Keccak256([]byte("executeSlice"))
+	want, err := crypto.Sign(hash, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := new(big.Int).SetBytes(want[:32])
+	s := new(big.Int).SetBytes(want[32:64])
+
+	// KMS may return either s or n-s; both must normalize to the same low-s form.
+	for name, sv := range map[string]*big.Int{"low-s": s, "high-s": new(big.Int).Sub(secp256k1N, s)} {
+		der, err := asn1.Marshal(struct{ R, S *big.Int }{r, sv})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := derToEthSignature(der, hash, &key.PublicKey)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s: sig = %x, want %x", name, got, want)
+		}
+	}
+}
+
+func TestDERToEthSignatureWrongKey(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	hash := crypto.Keccak256([]byte("x"))
+	sig, _ := crypto.Sign(hash, key)
+	der, _ := asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:64])})
+	if _, err := derToEthSignature(der, hash, &other.PublicKey); err == nil {
+		t.Fatal("expected recovery mismatch error")
+	}
+}
+
+func TestKMSRegion(t *testing.T) {
+	t.Setenv("AWS_REGION", "eu-west-1")
+	if got := kmsRegion("arn:aws:kms:us-east-2:111122223333:key/abcd"); got != "us-east-2" {
+		t.Fatalf("arn region = %q", got)
+	}
+	if got := kmsRegion("alias/twap-agent"); got != "eu-west-1" {
+		t.Fatalf("env region = %q", got)
+	}
+}
+
+// Vectors from the AWS Signature Version 4 test suite, which signs for
+// service "service" in us-east-1 at 20150830T123600Z.
+func TestSignV4TestSuite(t *testing.T) {
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name, method, body, contentType string
+		wantSigned, wantSignature       string
+	}{
+		{"get-vanilla", http.MethodGet, "", "", "host;x-amz-date", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"post-vanilla", http.MethodPost, "", "", "host;x-amz-date", "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+		{"post-x-www-form-urlencoded", http.MethodPost, "Param1=value1", "application/x-www-form-urlencoded", "content-type;host;x-amz-date", "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"},
+	} {
+		req, err := http.NewRequest(tc.method, "https://example.amazonaws.com/", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		signV4(req, []byte(tc.body), creds, "us-east-1", "service", now)
+		want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=" + tc.wantSigned + ", Signature=" + tc.wantSignature
+		if got := req.Header.Get("Authorization"); got != want {
+			t.Errorf("%s:\n got %s\nwant %s", tc.name, got, want)
+		}
+		if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+			t.Errorf("%s: X-Amz-Date %q", tc.name, got)
+		}
+	}
+}
+
+// SignHash sends KMS a signed Sign request for the digest and turns the DER
+// signature it returns into one that recovers to the key.
+func TestKMSSignRequest(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := crypto.Keccak256([]byte("executeSlice"))
+	const keyID = "arn:aws:kms:eu-west-1:111122223333:key/1234abcd"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Amz-Target"); got != "TrentService.Sign" {
+			t.Errorf("X-Amz-Target = %q", got)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/x-amz-json-1.1" {
+			t.Errorf("Content-Type = %q", got)
+		}
+		if got := r.Header.Get("X-Amz-Security-Token"); got != "session" {
+			t.Errorf("X-Amz-Security-Token = %q", got)
+		}
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") ||
+			!strings.Contains(auth, "/eu-west-1/kms/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-amz-target, Signature=") {
+			t.Errorf("Authorization = %q", auth)
+		}
+		var in map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			t.Error(err)
+			return
+		}
+		want := map[string]string{
+			"KeyId":            keyID,
+			"Message":          base64.StdEncoding.EncodeToString(hash),
+			"MessageType":      "DIGEST",
+			"SigningAlgorithm": "ECDSA_SHA_256",
+		}
+		if !reflect.DeepEqual(in, want) {
+			t.Errorf("body = %v, want %v", in, want)
+		}
+		sig, err := crypto.Sign(hash, key)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		der, err := asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:64])})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"KeyId": keyID, "Signature": base64.StdEncoding.EncodeToString(der)})
+	}))
+	defer srv.Close()
+
+	s := &kmsSigner{keyID: keyID, region: kmsRegion(keyID), endpoint: srv.URL, http: srv.Client(), pub: &key.PublicKey, addr: crypto.PubkeyToAddress(key.PublicKey)}
+	sig, err := s.SignHash(context.Background(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := crypto.SigToPub(hash, sig)
+	if err != nil || crypto.PubkeyToAddress(*pub) != s.addr {
+		t.Fatalf("signature recovers to %v (%v), want %s", pub, err, s.addr.Hex())
+	}
+}
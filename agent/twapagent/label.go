@@ -0,0 +1,21 @@
+package twapagent
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// contractLabelKey carries the vault a bot mode goroutine works for when it
+// runs several, so its log records and events can be told apart.
+type contractLabelKey struct{}
+
+func withContractLabel(ctx context.Context, addr common.Address) context.Context {
+	return context.WithValue(ctx, contractLabelKey{}, addr)
+}
+
+// contractLabel is the labelled vault, if any.
+func contractLabel(ctx context.Context) (common.Address, bool) {
+	addr, ok := ctx.Value(contractLabelKey{}).(common.Address)
+	return addr, ok
+}
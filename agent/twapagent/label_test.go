@@ -0,0 +1,128 @@
+package twapagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestEmitEventNamesContract(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := openEventLog("-", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := common.HexToAddress("0xabc")
+	emitEvent(withContractLabel(withEventLog(context.Background(), l), addr), evHead, 1, nil)
+	var r eventRecord
+	if err := json.Unmarshal(buf.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Contract != addr.Hex() {
+		t.Errorf("contract = %q, want %s", r.Contract, addr.Hex())
+	}
+}
+
+func TestVaultHandleLogFill(t *testing.T) {
+	cABI, _, err := loadTwapABI("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fill := cABI.Events["Fill"]
+	data, err := fill.Inputs.Pack(big.NewInt(1), big.NewInt(10), big.NewInt(20), big.NewInt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := common.HexToAddress("0xabc")
+	v := &vaultBot{executor: executor{addr: addr, cABI: cABI, st: &botState{}}, ctx: withContractLabel(context.Background(), addr)}
+	v.st.done.Load([]bool{true, false, false})
+	v.st.inFlight.TryAcquire(1)
+	woken := false
+	noFinish := func(*vaultBot, *orderEnd, *orderTotals) error { return nil }
+	lg := types.Log{Address: addr, Topics: []common.Hash{fill.ID}, Data: data}
+	if err := v.handleLog(lg, func(bool) { woken = true }, noFinish); err != nil {
+		t.Fatal(err)
+	}
+	if !v.st.done.Done(1) || !woken {
+		t.Errorf("fill: done %v, woken %v", v.st.done.Done(1), woken)
+	}
+	if _, ok := v.st.inFlight.Current(); ok {
+		t.Error("the filled slice should no longer be in flight")
+	}
+}
+
+// A Fill removed by a reorg opens its slice again and takes the fill back
+// from the status API.
+func TestVaultHandleLogRemovedFill(t *testing.T) {
+	cABI, _, err := loadTwapABI("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fill := cABI.Events["Fill"]
+	data, err := fill.Inputs.Pack(big.NewInt(1), big.NewInt(10), big.NewInt(20), big.NewInt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := common.HexToAddress("0xabc")
+	v := &vaultBot{executor: executor{addr: addr, cABI: cABI, st: &botState{view: &vaultView{}}}}
+	a := &apiServer{vaults: []*vaultBot{v}, byAddr: map[common.Address]*vaultBot{addr: v}}
+	v.ctx = withEventTap(withContractLabel(context.Background(), addr), a.tap)
+	v.st.done.Load([]bool{true, false, false})
+	noFinish := func(*vaultBot, *orderEnd, *orderTotals) error { return nil }
+	lg := types.Log{Address: addr, Topics: []common.Hash{fill.ID}, Data: data, TxHash: common.HexToHash("0x01")}
+	if err := v.handleLog(lg, func(bool) {}, noFinish); err != nil {
+		t.Fatal(err)
+	}
+	v.st.view.filled = big.NewInt(25)
+
+	lg.Removed = true
+	woken := false
+	if err := v.handleLog(lg, func(bool) { woken = true }, noFinish); err != nil {
+		t.Fatal(err)
+	}
+	if v.st.done.Done(1) || !woken {
+		t.Errorf("removed fill: done %v, woken %v", v.st.done.Done(1), woken)
+	}
+	if len(v.st.view.fills) != 0 || v.st.view.filled.Int64() != 15 {
+		t.Errorf("API still has fills %+v, filled %s", v.st.view.fills, v.st.view.filled)
+	}
+}
+
+// Every event of the ABI is logged decoded, indexed arguments included,
+// and a topic0 it lacks is logged raw.
+func TestVaultHandleLogLogsEvents(t *testing.T) {
+	cABI, _, err := loadTwapABI("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	addr := common.HexToAddress("0xabc")
+	ctx := withLogger(context.Background(), newLogger(LogConfig{Level: "info", Format: logFormatText}, &buf))
+	v := &vaultBot{executor: executor{addr: addr, cABI: cABI, st: &botState{}}, ctx: ctx}
+	noFinish := func(*vaultBot, *orderEnd, *orderTotals) error { return nil }
+	owner := cABI.Events["OwnershipTransferred"]
+	for _, lg := range []types.Log{
+		{Topics: []common.Hash{owner.ID, common.HexToHash("0x01"), common.HexToHash("0x02")}},
+		{Topics: []common.Hash{common.HexToHash("0xfeed")}, Data: []byte{0xab, 0xcd}},
+	} {
+		if err := v.handleLog(lg, func(bool) {}, noFinish); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"[Event] OwnershipTransferred: previousOwner=0x0000000000000000000000000000000000000001 newOwner=0x0000000000000000000000000000000000000002",
+		"[Event] unknown topic0 " + common.HexToHash("0xfeed").Hex(),
+		"data 0xabcd",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log lacks %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// --log-format values.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// LogConfig sets what the agent logs and how.
+type LogConfig struct {
+	// debug|info|warn|error. Per-block chatter is debug, decisions,
+	// submissions and receipts info, failures warn or error.
+	Level string
+	// logFormatText or logFormatJSON.
+	Format string
+}
+
+func (c LogConfig) validate() error {
+	if _, err := parseLogLevel(c.Level); err != nil {
+		return err
+	}
+	switch c.Format {
+	case logFormatText, logFormatJSON:
+		return nil
+	}
+	return fmt.Errorf("invalid --log-format %q (want %s or %s)", c.Format, logFormatText, logFormatJSON)
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil || strings.ContainsAny(s, "+-") {
+		return 0, fmt.Errorf("invalid --log-level %q (want debug, info, warn or error)", s)
+	}
+	return l, nil
+}
+
+// newLogger writes cfg's records to w. It expects a validated cfg.
+func newLogger(cfg LogConfig, w io.Writer) *slog.Logger {
+	level, _ := parseLogLevel(cfg.Level)
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.Format == logFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// loggerKey carries the agent's logger, with the chain id and, for a single
+// vault, its address.
+type loggerKey struct{}
+
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// logAt logs msg with args as attributes, and ctx's contract label.
+func logAt(ctx context.Context, level slog.Level, msg string, args ...interface{}) {
+	l := loggerFrom(ctx)
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	if addr, ok := contractLabel(ctx); ok {
+		args = append(args, "contract", addr.Hex())
+	}
+	l.Log(ctx, level, msg, args...)
+}
+
+func debugf(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelDebug, fmt.Sprintf(format, args...))
+}
+
+// logf logs at info level.
+func logf(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func warnf(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelWarn, fmt.Sprintf(format, args...))
+}
+
+func errorf(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelError, fmt.Sprintf(format, args...))
+}
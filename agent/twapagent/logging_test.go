@@ -0,0 +1,64 @@
+package twapagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestLogConfigValidate(t *testing.T) {
+	for _, level := range []string{"debug", "info", "warn", "error", "WARN"} {
+		if err := (LogConfig{Level: level, Format: logFormatText}).validate(); err != nil {
+			t.Errorf("level %q: %v", level, err)
+		}
+	}
+	for _, c := range []LogConfig{
+		{Level: "verbose", Format: logFormatText},
+		{Level: "info+2", Format: logFormatText},
+		{Level: "info", Format: "logfmt"},
+	} {
+		if err := c.validate(); err == nil {
+			t.Errorf("%+v: want an error", c)
+		}
+	}
+}
+
+func TestJSONRecordsCarryChainAndContract(t *testing.T) {
+	var buf bytes.Buffer
+	addr := common.HexToAddress("0xabc")
+	l := newLogger(LogConfig{Level: "info", Format: logFormatJSON}, &buf).With("chainId", uint64(1))
+	ctx := withContractLabel(withLogger(context.Background(), l), addr)
+
+	debugf(ctx, "New block %d", 7)
+	logAt(ctx, slog.LevelInfo, "Submitted tx", "slice", int64(3), "tx", "0x01")
+	warnf(ctx, "poll: %v", "timeout")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d records at info level, want 2:\n%s", len(lines), buf.String())
+	}
+	var rec struct {
+		Level    string
+		Msg      string
+		ChainID  uint64 `json:"chainId"`
+		Contract string
+		Slice    int64
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Level != "INFO" || rec.Msg != "Submitted tx" || rec.ChainID != 1 || rec.Contract != addr.Hex() || rec.Slice != 3 {
+		t.Errorf("record = %+v", rec)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Level != "WARN" || rec.Msg != "poll: timeout" {
+		t.Errorf("record = %+v", rec)
+	}
+}
@@ -0,0 +1,133 @@
+package twapagent
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// defaultHDPath is the first account of the standard Ethereum BIP-44 path.
+const defaultHDPath = "m/44'/60'/0'/0/0"
+
+// readMnemonicKey derives the agent key from a BIP-39 mnemonic file at the
+// given BIP-32 path. passphraseFile is optional.
+func readMnemonicKey(mnemonicFile, passphraseFile, hdPath string) (*ecdsa.PrivateKey, error) {
+	raw, err := os.ReadFile(mnemonicFile)
+	if err != nil {
+		return nil, fmt.Errorf("read mnemonic file: %w", err)
+	}
+	mnemonic := strings.Join(strings.Fields(strings.ToLower(string(raw))), " ")
+	if err := checkMnemonic(mnemonic); err != nil {
+		return nil, fmt.Errorf("mnemonic file %s: %w", mnemonicFile, err)
+	}
+	var passphrase string
+	if passphraseFile != "" {
+		p, err := os.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("read mnemonic passphrase file: %w", err)
+		}
+		passphrase = strings.TrimRight(string(p), "\r\n")
+	}
+	if hdPath == "" {
+		hdPath = defaultHDPath
+	}
+	path, err := accounts.ParseDerivationPath(hdPath)
+	if err != nil {
+		return nil, fmt.Errorf("hd-path: %w", err)
+	}
+	seed, err := mnemonicSeed(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return deriveKey(seed, path)
+}
+
+// checkMnemonic verifies the word count, that every word is in the English
+// list, and the checksum bits.
+func checkMnemonic(mnemonic string) error {
+	words := strings.Fields(mnemonic)
+	if n := len(words); n < 12 || n > 24 || n%3 != 0 {
+		return fmt.Errorf("mnemonic has %d words, want 12, 15, 18, 21 or 24", n)
+	}
+	index := make(map[string]int, len(bip39English))
+	for i, w := range bip39English {
+		index[w] = i
+	}
+	bits := new(big.Int)
+	for _, w := range words {
+		i, ok := index[w]
+		if !ok {
+			return fmt.Errorf("unknown mnemonic word %q", w)
+		}
+		bits.Lsh(bits, 11).Or(bits, big.NewInt(int64(i)))
+	}
+	// Each word is 11 bits; one checksum bit per 32 bits of entropy.
+	csBits := uint(len(words) * 11 / 33)
+	entBytes := len(words) * 11 * 32 / 33 / 8
+	checksum := new(big.Int).And(bits, big.NewInt(1<<csBits-1)).Uint64()
+	entropy := new(big.Int).Rsh(bits, csBits).FillBytes(make([]byte, entBytes))
+	sum := sha256.Sum256(entropy)
+	if uint64(sum[0]>>(8-csBits)) != checksum {
+		return errors.New("mnemonic checksum mismatch (typo or wrong word order?)")
+	}
+	return nil
+}
+
+// mnemonicSeed is the BIP-39 seed. Passphrases must be ASCII: BIP-39 wants
+// NFKD-normalized input and ASCII is the only case that is already normalized.
+func mnemonicSeed(mnemonic, passphrase string) ([]byte, error) {
+	for _, r := range passphrase {
+		if r > 0x7f {
+			return nil, errors.New("mnemonic passphrase must be ASCII")
+		}
+	}
+	return pbkdf2.Key([]byte(mnemonic), []byte("mnemonic"+passphrase), 2048, 64, sha512.New), nil
+}
+
+// deriveKey walks a BIP-32 private derivation from the master seed.
+func deriveKey(seed []byte, path accounts.DerivationPath) (*ecdsa.PrivateKey, error) {
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	I := mac.Sum(nil)
+	k, chain := new(big.Int).SetBytes(I[:32]), I[32:]
+	if k.Sign() == 0 || k.Cmp(secp256k1N) >= 0 {
+		return nil, errors.New("invalid master key")
+	}
+	for _, idx := range path {
+		data := make([]byte, 0, 37)
+		if idx >= 0x80000000 {
+			data = append(append(data, 0), k.FillBytes(make([]byte, 32))...)
+		} else {
+			priv, err := crypto.ToECDSA(k.FillBytes(make([]byte, 32)))
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, crypto.CompressPubkey(&priv.PublicKey)...)
+		}
+		data = binary.BigEndian.AppendUint32(data, idx)
+		mac := hmac.New(sha512.New, chain)
+		mac.Write(data)
+		I := mac.Sum(nil)
+		il := new(big.Int).SetBytes(I[:32])
+		if il.Cmp(secp256k1N) >= 0 {
+			return nil, fmt.Errorf("invalid child key at index %d", idx)
+		}
+		k = il.Add(il, k).Mod(il, secp256k1N)
+		if k.Sign() == 0 {
+			return nil, fmt.Errorf("invalid child key at index %d", idx)
+		}
+		chain = I[32:]
+	}
+	return crypto.ToECDSA(k.FillBytes(make([]byte, 32)))
+}
@@ -0,0 +1,74 @@
+package twapagent
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestBIP39Wordlist(t *testing.T) {
+	if len(bip39English) != 2048 || !sort.StringsAreSorted(bip39English) {
+		t.Fatalf("wordlist has %d words (sorted=%v), want 2048 sorted", len(bip39English), sort.StringsAreSorted(bip39English))
+	}
+}
+
+func TestCheckMnemonic(t *testing.T) {
+	valid := []string{
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"gravity machine north sort system female filter attitude volume fold club stay feature office ecology stable narrow fog",
+		"void come effort suffer camp survey warrior heavy shoot primary clutch crush open amazing screen patrol group space point ten exist slush involve unfold",
+	}
+	for _, m := range valid {
+		if err := checkMnemonic(m); err != nil {
+			t.Errorf("%q: %v", m, err)
+		}
+	}
+	invalid := []string{
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon", // checksum
+		"legal winner thank year wave sausage worth useful legal winner thank",                            // 11 words
+		"legal winner thank year wave sausage worth useful legal winner thank yelow",                      // unknown word
+	}
+	for _, m := range invalid {
+		if err := checkMnemonic(m); err == nil {
+			t.Errorf("%q: expected error", m)
+		}
+	}
+}
+
+func TestMnemonicSeed(t *testing.T) {
+	// BIP-39 reference vector with passphrase "TREZOR".
+	seed, err := mnemonicSeed("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about", "TREZOR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"
+	if got := hex.EncodeToString(seed); got != want {
+		t.Fatalf("seed = %s, want %s", got, want)
+	}
+}
+
+func TestReadMnemonicKey(t *testing.T) {
+	// The well-known development mnemonic used by Hardhat and Anvil.
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mnemonic")
+	if err := os.WriteFile(path, []byte("test test test test test test test test test test test junk\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for hdPath, want := range map[string]string{
+		defaultHDPath:      "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266",
+		"m/44'/60'/0'/0/1": "0x70997970C51812dc3A010C7d01b50e0d17dc79C8",
+	} {
+		key, err := readMnemonicKey(path, "", hdPath)
+		if err != nil {
+			t.Fatalf("%s: %v", hdPath, err)
+		}
+		if got := crypto.PubkeyToAddress(key.PublicKey).Hex(); got != want {
+			t.Errorf("%s: address = %s, want %s", hdPath, got, want)
+		}
+	}
+}
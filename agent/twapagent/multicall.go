@@ -0,0 +1,180 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// Multicall3 is deployed at the same address on most chains; see
+// https://github.com/mds1/multicall.
+var multicall3Address = common.HexToAddress("0xcA11bde05977b3631167028862bE2a173976CA11")
+
+// --multicall modes.
+const (
+	multicallAuto = "auto"
+	multicallOn   = "on"
+	multicallOff  = "off"
+)
+
+const multicall3ABIJSON = `[
+	{"type":"function","name":"aggregate3","stateMutability":"payable",
+	 "inputs":[{"name":"calls","type":"tuple[]","components":[
+		{"name":"target","type":"address"},{"name":"allowFailure","type":"bool"},{"name":"callData","type":"bytes"}]}],
+	 "outputs":[{"name":"returnData","type":"tuple[]","components":[
+		{"name":"success","type":"bool"},{"name":"returnData","type":"bytes"}]}]}
+]`
+
+var multicall3ABI = mustParseABI(multicall3ABIJSON)
+
+func mustParseABI(s string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(s))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// multicallCall is Multicall3.Call3.
+type multicallCall struct {
+	Target       common.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+// multicallResult is Multicall3.Result.
+type multicallResult struct {
+	Success    bool
+	ReturnData []byte
+}
+
+// detectMulticall decides whether per-block reads go through Multicall3: in
+// auto mode when it has code, always with on (failing if it is missing).
+func detectMulticall(ctx context.Context, client *ethclient.Client, mode string) (bool, error) {
+	switch mode {
+	case multicallOff:
+		return false, nil
+	case multicallAuto, multicallOn:
+	default:
+		return false, fmt.Errorf("invalid --multicall %q (want %s, %s or %s)", mode, multicallAuto, multicallOn, multicallOff)
+	}
+	var code []byte
+	err := rpcRead(ctx, "eth_getCode", func(ctx context.Context) (err error) {
+		code, err = client.CodeAt(ctx, multicall3Address, nil)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("multicall3 code: %w", err)
+	}
+	if len(code) == 0 {
+		if mode == multicallOn {
+			return false, fmt.Errorf("--multicall on, but there is no Multicall3 at %s", multicall3Address.Hex())
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// aggregate3 runs calls in one eth_call at block (nil = latest).
+func aggregate3(ctx context.Context, client *ethclient.Client, calls []multicallCall, block *big.Int) ([]multicallResult, error) {
+	data, err := multicall3ABI.Pack("aggregate3", calls)
+	if err != nil {
+		return nil, fmt.Errorf("pack aggregate3: %w", err)
+	}
+	var res []byte
+	err = rpcRead(ctx, "eth_call aggregate3", func(ctx context.Context) (err error) {
+		res, err = client.CallContract(ctx, ethereum.CallMsg{To: &multicall3Address, Data: data}, block)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("call aggregate3: %w", err)
+	}
+	outs, err := multicall3ABI.Unpack("aggregate3", res)
+	if err != nil {
+		return nil, fmt.Errorf("unpack aggregate3: %w", err)
+	}
+	results := *abi.ConvertType(outs[0], new([]multicallResult)).(*[]multicallResult)
+	if len(results) != len(calls) {
+		return nil, fmt.Errorf("aggregate3 returned %d results for %d calls", len(results), len(calls))
+	}
+	return results, nil
+}
+
+// unpackResult decodes one vault call's single return value out of an
+// aggregate3 result.
+func unpackResult(cABI abi.ABI, method string, r multicallResult) (interface{}, error) {
+	if !r.Success {
+		return nil, fmt.Errorf("call %s: reverted: %s", method, decodeRevert(cABI, r.ReturnData))
+	}
+	outs, err := cABI.Unpack(method, r.ReturnData)
+	if err != nil {
+		return nil, fmt.Errorf("unpack %s: %w", method, err)
+	}
+	return outs[0], nil
+}
+
+// readBlockMulticall is readBlock as one Multicall3 call at block number.
+func readBlockMulticall(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, number *big.Int) (blockReads, error) {
+	var calls []multicallCall
+	for _, m := range []string{"status", "filledAmountIn"} {
+		data, err := cABI.Pack(m)
+		if err != nil {
+			return blockReads{}, fmt.Errorf("pack %s: %w", m, err)
+		}
+		calls = append(calls, multicallCall{Target: addr, AllowFailure: true, CallData: data})
+	}
+	results, err := aggregate3(ctx, client, calls, number)
+	if err != nil {
+		return blockReads{}, err
+	}
+
+	var r blockReads
+	if v, err := unpackResult(cABI, "status", results[0]); err != nil {
+		r.StatusErr = err
+	} else {
+		r.Status = Status(*abi.ConvertType(v, new(uint8)).(*uint8))
+	}
+	if v, err := unpackResult(cABI, "filledAmountIn", results[1]); err == nil {
+		r.Filled = *abi.ConvertType(v, new(*big.Int)).(**big.Int)
+	}
+	return r, nil
+}
+
+// sliceDoneRangeMulticall is sliceDoneRange through aggregate3, maxBatchCalls
+// slices per call.
+func sliceDoneRangeMulticall(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, from, to int64) ([]bool, error) {
+	done := make([]bool, 0, to-from)
+	for start := from; start < to; start += maxBatchCalls {
+		end := start + maxBatchCalls
+		if end > to {
+			end = to
+		}
+		calls := make([]multicallCall, end-start)
+		for i := range calls {
+			data, err := cABI.Pack("sliceDone", big.NewInt(start+int64(i)))
+			if err != nil {
+				return nil, fmt.Errorf("pack sliceDone: %w", err)
+			}
+			calls[i] = multicallCall{Target: addr, AllowFailure: true, CallData: data}
+		}
+		results, err := aggregate3(ctx, client, calls, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range results {
+			v, err := unpackResult(cABI, "sliceDone", res)
+			if err != nil {
+				return nil, err
+			}
+			d, _ := v.(bool)
+			done = append(done, d)
+		}
+	}
+	return done, nil
+}
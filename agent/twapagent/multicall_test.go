@@ -0,0 +1,101 @@
+package twapagent
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	"twap-agent/twapbind"
+)
+
+// multicallEth serves Multicall3.aggregate3 over a vaultRPC's state.
+type multicallEth struct {
+	vault    *vaultRPC
+	deployed bool
+	calls    atomic.Int64
+}
+
+func (f *multicallEth) GetCode(addr common.Address, _ string) hexutil.Bytes {
+	if f.deployed && addr == multicall3Address {
+		return hexutil.Bytes{0x60, 0x80}
+	}
+	return nil
+}
+
+func (f *multicallEth) Call(args fakeCallArgs, _ string) (hexutil.Bytes, error) {
+	f.calls.Add(1)
+	m := multicall3ABI.Methods["aggregate3"]
+	in, err := m.Inputs.Unpack(args.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+	var results []multicallResult
+	for _, c := range *abi.ConvertType(in[0], new([]multicallCall)).(*[]multicallCall) {
+		out, err := f.vault.call(c.CallData)
+		results = append(results, multicallResult{Success: err == nil, ReturnData: out})
+	}
+	return m.Outputs.Pack(results)
+}
+
+func newMulticallEth(t *testing.T, total, done int64) (*multicallEth, *twapbind.Twap, *ethclient.Client) {
+	cABI, err := twapbind.ParseABI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &multicallEth{vault: &vaultRPC{cABI: cABI, totalSlices: total, done: done}, deployed: true}
+	client := dialFakeEth(t, f)
+	return f, twapbind.NewTwap(common.Address{0xaa}, cABI, client, client, client), client
+}
+
+func TestReadBlockMulticallOneCall(t *testing.T) {
+	f, twap, client := newMulticallEth(t, 24, 20)
+	reads, err := readBlockMulticall(context.Background(), twap.Address(), f.vault.cABI, client, big.NewInt(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reads.Status != 1 || reads.Filled.Int64() != 200 {
+		t.Fatalf("reads = %+v", reads)
+	}
+	if n := f.calls.Load(); n != 1 {
+		t.Fatalf("%d eth_calls, want 1", n)
+	}
+}
+
+func TestSliceDoneRangeMulticallPages(t *testing.T) {
+	f, twap, client := newMulticallEth(t, 250, 150)
+	done, err := sliceDoneRangeMulticall(context.Background(), twap.Address(), f.vault.cABI, client, 0, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 250 || !done[149] || done[150] {
+		t.Fatalf("sliceDoneRangeMulticall: %d results, [149]=%v [150]=%v", len(done), done[149], done[150])
+	}
+	if n := f.calls.Load(); n != 3 {
+		t.Fatalf("%d eth_calls, want 3", n)
+	}
+}
+
+func TestDetectMulticall(t *testing.T) {
+	ctx := context.Background()
+	missing := dialFakeEth(t, &multicallEth{})
+	if ok, err := detectMulticall(ctx, missing, multicallAuto); ok || err != nil {
+		t.Fatalf("auto without Multicall3: %v, %v", ok, err)
+	}
+	if _, err := detectMulticall(ctx, missing, multicallOn); err == nil || !strings.Contains(err.Error(), "no Multicall3") {
+		t.Fatalf("on without Multicall3: err = %v", err)
+	}
+	deployed := dialFakeEth(t, &multicallEth{deployed: true})
+	if ok, err := detectMulticall(ctx, deployed, multicallAuto); !ok || err != nil {
+		t.Fatalf("auto with Multicall3: %v, %v", ok, err)
+	}
+	if ok, _ := detectMulticall(ctx, deployed, multicallOff); ok {
+		t.Fatal("off still uses Multicall3")
+	}
+}
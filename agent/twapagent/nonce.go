@@ -0,0 +1,93 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// nonceSource is the subset of ethclient.Client the nonce manager needs.
+type nonceSource interface {
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+}
+
+// nonceManager hands out monotonically increasing nonces for one account so
+// back-to-back submissions don't both read the same PendingNonceAt value.
+// It falls back to the node's view whenever a send fails, and after
+// Invalidate.
+type nonceManager struct {
+	mu      sync.Mutex
+	src     nonceSource
+	account common.Address
+	next    uint64
+	synced  bool
+}
+
+func newNonceManager(src nonceSource, account common.Address) *nonceManager {
+	return &nonceManager{src: src, account: account}
+}
+
+// Sync reloads the next nonce from the node's pending state.
+func (m *nonceManager) Sync(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.syncLocked(ctx)
+}
+
+func (m *nonceManager) syncLocked(ctx context.Context) error {
+	var n uint64
+	err := rpcRead(ctx, "eth_getTransactionCount", func(ctx context.Context) (err error) {
+		n, err = m.src.PendingNonceAt(ctx, m.account)
+		return err
+	})
+	if err != nil {
+		m.synced = false
+		return fmt.Errorf("pending nonce: %w", err)
+	}
+	m.next = n
+	m.synced = true
+	return nil
+}
+
+// Invalidate makes the next Send re-read the nonce from the node. It is
+// called when a sent tx may never mine (a receipt wait that timed out, a
+// slice being retried), since the nonce handed out after it would then
+// wait behind a gap that never fills.
+func (m *nonceManager) Invalidate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.synced = false
+}
+
+// Send assigns the next nonce and calls submit with it. Submissions are
+// serialized so nonces are used in order. After a failed submit the manager
+// resyncs with the node; a "nonce too low" failure (another process used the
+// nonce) is retried once with the fresh value.
+func (m *nonceManager) Send(ctx context.Context, submit func(nonce uint64) (*types.Transaction, error)) (*types.Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		if !m.synced {
+			if err := m.syncLocked(ctx); err != nil {
+				return nil, err
+			}
+		}
+		nonce := m.next
+		tx, err := submit(nonce)
+		if err == nil {
+			m.next = nonce + 1
+			return tx, nil
+		}
+		if syncErr := m.syncLocked(ctx); syncErr != nil {
+			warnf(ctx, "nonce resync after failed send: %v", syncErr)
+		}
+		if !isNonceTooLow(err) || attempt > 0 {
+			return nil, err
+		}
+		warnf(ctx, "nonce %d too low for %s, resynced to %d and retrying", nonce, m.account.Hex(), m.next)
+	}
+}
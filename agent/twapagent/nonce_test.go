@@ -0,0 +1,128 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type fakeNonceSource struct {
+	pending uint64
+	calls   int
+}
+
+func (f *fakeNonceSource) PendingNonceAt(context.Context, common.Address) (uint64, error) {
+	f.calls++
+	return f.pending, nil
+}
+
+func dummyTx(nonce uint64) *types.Transaction {
+	return types.NewTx(&types.LegacyTx{Nonce: nonce})
+}
+
+func TestNonceManagerRapidSubmissions(t *testing.T) {
+	ctx := context.Background()
+	src := &fakeNonceSource{pending: 7}
+	m := newNonceManager(src, common.Address{})
+
+	var used []uint64
+	submit := func(n uint64) (*types.Transaction, error) {
+		used = append(used, n)
+		return dummyTx(n), nil
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := m.Send(ctx, submit); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	// The node hasn't seen the first tx yet, so a naive PendingNonceAt per
+	// send would hand out 7 twice.
+	if len(used) != 2 || used[0] != 7 || used[1] != 8 {
+		t.Fatalf("nonces = %v, want [7 8]", used)
+	}
+	if src.calls != 1 {
+		t.Fatalf("PendingNonceAt calls = %d, want 1", src.calls)
+	}
+}
+
+func TestNonceManagerResyncsOnNonceTooLow(t *testing.T) {
+	ctx := context.Background()
+	src := &fakeNonceSource{pending: 3}
+	m := newNonceManager(src, common.Address{})
+	if err := m.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process sends two txs with the same key behind our back.
+	src.pending = 5
+	var used []uint64
+	tx, err := m.Send(ctx, func(n uint64) (*types.Transaction, error) {
+		used = append(used, n)
+		if n < src.pending {
+			return nil, errors.New("nonce too low: next nonce 5, tx nonce 3")
+		}
+		return dummyTx(n), nil
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if tx.Nonce() != 5 || len(used) != 2 || used[0] != 3 {
+		t.Fatalf("used nonces %v, final %d; want [3 5]", used, tx.Nonce())
+	}
+	if m.next != 6 {
+		t.Fatalf("next = %d, want 6", m.next)
+	}
+}
+
+func TestNonceManagerRetriesOnlyOnce(t *testing.T) {
+	ctx := context.Background()
+	m := newNonceManager(&fakeNonceSource{pending: 1}, common.Address{})
+	attempts := 0
+	_, err := m.Send(ctx, func(uint64) (*types.Transaction, error) {
+		attempts++
+		return nil, errors.New("nonce too low")
+	})
+	if err == nil || attempts != 2 {
+		t.Fatalf("attempts = %d, err = %v; want 2 attempts and an error", attempts, err)
+	}
+}
+
+func TestNonceManagerResyncsAfterOtherFailures(t *testing.T) {
+	ctx := context.Background()
+	src := &fakeNonceSource{pending: 10}
+	m := newNonceManager(src, common.Address{})
+	_, err := m.Send(ctx, func(uint64) (*types.Transaction, error) {
+		return nil, errors.New("insufficient funds")
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	// The failed nonce was never used, so the next send must reuse it.
+	tx, err := m.Send(ctx, func(n uint64) (*types.Transaction, error) { return dummyTx(n), nil })
+	if err != nil || tx.Nonce() != 10 {
+		t.Fatalf("nonce = %v, err = %v; want 10", tx, err)
+	}
+}
+
+func TestNonceManagerResyncsAfterInvalidate(t *testing.T) {
+	ctx := context.Background()
+	src := &fakeNonceSource{pending: 4}
+	m := newNonceManager(src, common.Address{})
+	submit := func(n uint64) (*types.Transaction, error) { return dummyTx(n), nil }
+	if _, err := m.Send(ctx, submit); err != nil {
+		t.Fatal(err)
+	}
+	// Nonce 4 timed out and was dropped: the node still expects 4, and
+	// nonce 5 would wait behind it forever.
+	m.Invalidate()
+	tx, err := m.Send(ctx, submit)
+	if err != nil || tx.Nonce() != 4 {
+		t.Fatalf("nonce = %v, err = %v; want 4", tx, err)
+	}
+	if src.calls != 2 {
+		t.Fatalf("PendingNonceAt calls = %d, want 2", src.calls)
+	}
+}
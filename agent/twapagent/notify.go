@@ -0,0 +1,661 @@
+package twapagent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// NotifyConfig is where bot mode sends notifications.
+type NotifyConfig struct {
+	Webhooks  WebhookConfig
+	Telegram  TelegramConfig
+	Slack     SlackConfig
+	PagerDuty PagerDutyConfig
+	// A due slice still unexecuted this long makes a behind_schedule alert,
+	// which pages (0 = never).
+	PageAfter time.Duration
+	// Tx links, {tx} standing for the hash ("" = the chain's explorer, if
+	// known).
+	ExplorerTxURL string
+}
+
+func (c NotifyConfig) validate() error {
+	if err := c.Webhooks.validate(); err != nil {
+		return err
+	}
+	if err := c.Telegram.validate(); err != nil {
+		return err
+	}
+	if err := c.Slack.validate(); err != nil {
+		return err
+	}
+	if c.PageAfter < 0 {
+		return fmt.Errorf("--page-after must not be negative, got %s", c.PageAfter)
+	}
+	if c.PagerDuty.RoutingKey != "" && c.PageAfter == 0 {
+		return errors.New("--pagerduty-routing-key needs a --page-after above 0")
+	}
+	return validateExplorerTxURL(c.ExplorerTxURL)
+}
+
+// flag names a backend c sets, or is "" when there is none.
+func (c NotifyConfig) flag() string {
+	switch {
+	case len(c.Webhooks.URLs) > 0:
+		return "--webhook-url"
+	case c.Telegram.BotToken != "":
+		return "--telegram-bot-token"
+	case c.Slack.WebhookURL != "":
+		return "--slack-webhook-url"
+	case c.PagerDuty.RoutingKey != "":
+		return "--pagerduty-routing-key"
+	}
+	return ""
+}
+
+// notifiers builds the backends c sets.
+func (c NotifyConfig) notifiers(chainID uint64, client *ethclient.Client) []notifier {
+	f := &notifyFormatter{chainID: chainID, explorer: c.ExplorerTxURL, tokens: newTokenCache(client)}
+	var out []notifier
+	if len(c.Webhooks.URLs) > 0 {
+		out = append(out, newWebhookNotifier(c.Webhooks, client, f))
+	}
+	if c.Telegram.BotToken != "" {
+		out = append(out, newTelegramNotifier(c.Telegram, f))
+	}
+	if c.Slack.WebhookURL != "" {
+		out = append(out, newSlackNotifier(c.Slack, f))
+	}
+	if c.PagerDuty.RoutingKey != "" {
+		out = append(out, newPagerDutyNotifier(c.PagerDuty, f))
+	}
+	return out
+}
+
+// notifier is a notification backend: webhooks, Telegram, Slack, PagerDuty. It
+// delivers from its own goroutine, usually through a notifyQueue.
+type notifier interface {
+	// start begins delivering, logging to ctx.
+	start(ctx context.Context)
+	// notify takes n if the backend sends it. It must not block.
+	notify(n notification)
+	// close delivers what is queued, for up to notifyDrain.
+	close()
+}
+
+// Notification kinds besides order_ended, halted, resumed, paused,
+// unpaused, gave_up and breaker_tripped, which keep their event names.
+const (
+	notifySliceExecuted  = "slice_executed"
+	notifyTxFailed       = "tx_failed"
+	notifyRPCFailures    = "rpc_failures"
+	notifyBehindSchedule = "behind_schedule"
+	// The agent can't pay for the due slice's gas.
+	notifyBalanceEmpty = "balance_empty"
+	// A reorg took out a fill already notified as slice_executed.
+	notifyFillRemoved = "fill_removed"
+)
+
+const (
+	// Notifications waiting on a backend beyond this many are dropped.
+	notifyQueueSize = 256
+	// Consecutive heads with failed reads, or failed reconnects, that make
+	// an rpc_failures notification.
+	notifyRPCStreak = 3
+	// close waits this long for the queue to drain, so that the order's
+	// end still goes out.
+	notifyDrain = 10 * time.Second
+)
+
+// notifySeverity routes a notification: alerts are for someone to act on.
+type notifySeverity int
+
+const (
+	severityInfo notifySeverity = iota
+	severityAlert
+)
+
+func (s notifySeverity) String() string {
+	if s == severityAlert {
+		return "alert"
+	}
+	return "info"
+}
+
+// routeBySeverity is where a backend with a separate alerting channel
+// sends n: alerts there when it is set, and everything else to routine.
+func routeBySeverity(n notification, routine, alerts string) string {
+	if n.severity == severityAlert && alerts != "" {
+		return alerts
+	}
+	return routine
+}
+
+// notification is an event record worth telling someone about, with the
+// vault's strategy as it was then.
+type notification struct {
+	kind     string
+	severity notifySeverity
+	time     time.Time
+	chainID  uint64
+	block    uint64
+	// Zero for chain-wide ones with several vaults.
+	contract common.Address
+	// How many vaults the bot runs.
+	vaults int
+	data   map[string]interface{}
+	// The vault's slice count and tokens, once its strategy is cached.
+	slices            int64
+	tokenIn, tokenOut common.Address
+}
+
+// dueSlice is a vault's next slice while it is due and unexecuted, from
+// the decisions about it.
+type dueSlice struct {
+	slice int64
+	since time.Time
+	// The last decision's, if it gave one.
+	reason            string
+	unfunded, alerted bool
+}
+
+// notifiedFill is a fill sent as slice_executed, which a reorg can take back.
+type notifiedFill struct {
+	contract common.Address
+	slice    int64
+	tx       string
+}
+
+// rpcStreak counts a vault's consecutive RPC failures from its head and
+// error records.
+type rpcStreak struct {
+	head, errHead uint64
+	count         int
+}
+
+// notifyHub is the event tap behind the notifiers. It picks the records
+// worth a notification, tells which vault each is about, and hands them to
+// every backend. Like the status API's, its tap goes on the bot's ctx
+// before the vaults are set up, and start then gives it the vaults.
+type notifyHub struct {
+	chainID   uint64
+	pageAfter time.Duration
+	notifiers []notifier
+
+	mu     sync.Mutex
+	vaults []*vaultBot
+	byAddr map[common.Address]*vaultBot
+	closed bool
+	rpc    map[common.Address]*rpcStreak
+	due    map[common.Address]*dueSlice
+	fills  map[notifiedFill]bool
+}
+
+func newNotifyHub(chainID uint64, pageAfter time.Duration, notifiers []notifier) *notifyHub {
+	return &notifyHub{
+		chainID: chainID, pageAfter: pageAfter, notifiers: notifiers,
+		byAddr: map[common.Address]*vaultBot{},
+		rpc:    map[common.Address]*rpcStreak{},
+		due:    map[common.Address]*dueSlice{},
+		fills:  map[notifiedFill]bool{},
+	}
+}
+
+func (h *notifyHub) start(ctx context.Context, vaults []*vaultBot) {
+	for _, n := range h.notifiers {
+		n.start(ctx)
+	}
+	h.track(vaults)
+}
+
+// track makes vaults the ones the records are about.
+func (h *notifyHub) track(vaults []*vaultBot) {
+	byAddr := make(map[common.Address]*vaultBot, len(vaults))
+	for _, v := range vaults {
+		byAddr[v.addr] = v
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.vaults, h.byAddr = vaults, byAddr
+}
+
+func (h *notifyHub) close() {
+	h.mu.Lock()
+	h.closed = true
+	h.mu.Unlock()
+	for _, n := range h.notifiers {
+		n.close()
+	}
+}
+
+// tap turns rec into a notification for every backend, if it is one.
+func (h *notifyHub) tap(rec eventRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed || h.vaults == nil {
+		return
+	}
+	// A record without a contract is the only vault's, or chain-wide.
+	var v *vaultBot
+	if rec.Contract != "" {
+		v = h.byAddr[common.HexToAddress(rec.Contract)]
+	} else if len(h.vaults) == 1 {
+		v = h.vaults[0]
+	}
+	n := notification{kind: rec.Type, severity: severityInfo, time: rec.Time, chainID: h.chainID, block: rec.Block, vaults: len(h.vaults), data: rec.Data}
+	if v != nil {
+		n.contract = v.addr
+		s, N := v.st.strategy.Cached()
+		n.slices, _ = sliceCount(N)
+		n.tokenIn, n.tokenOut = s.TokenIn, s.TokenOut
+	}
+	switch rec.Type {
+	case evFill:
+		key := notifiedFill{contract: n.contract}
+		key.slice, _ = rec.Data["slice"].(int64)
+		key.tx, _ = rec.Data["tx"].(string)
+		if removed, _ := rec.Data["removed"].(bool); removed {
+			if !h.fills[key] {
+				return
+			}
+			// Correct the slice_executed sent for it.
+			delete(h.fills, key)
+			n.kind, n.severity = notifyFillRemoved, severityAlert
+			break
+		}
+		h.fills[key] = true
+		if d := h.due[n.contract]; d != nil && rec.Data["slice"] == d.slice {
+			delete(h.due, n.contract)
+		}
+		n.kind = notifySliceExecuted
+	case evDecision:
+		h.decided(n)
+		return
+	case evError:
+		if slice, ok := rec.Data["slice"]; ok {
+			// Sending failed.
+			n.kind, n.severity = notifyTxFailed, severityAlert
+			n.data = map[string]interface{}{"slice": slice, "reason": rec.Data["error"]}
+			break
+		}
+		count := h.rpcFailed(n.contract, rec.Block)
+		if count != notifyRPCStreak {
+			return
+		}
+		n.kind, n.severity = notifyRPCFailures, severityAlert
+		n.data = map[string]interface{}{"consecutiveFailures": count, "error": rec.Data["error"]}
+	case evHead:
+		h.rpcHead(n.contract, rec.Block)
+		return
+	case evReconnect:
+		delete(h.rpc, n.contract)
+		return
+	case evOrderEnded:
+		if outcome, _ := rec.Data["outcome"].(string); outcome != "filled" {
+			n.severity = severityAlert
+		}
+	case evTxFailed, evHalted, evGaveUp, evBreakerTripped:
+		n.severity = severityAlert
+	case evResumed, evPaused, evUnpaused:
+	default:
+		return
+	}
+	h.send(n)
+}
+
+func (h *notifyHub) send(n notification) {
+	for _, b := range h.notifiers {
+		b.notify(n)
+	}
+}
+
+// decided follows the vault's due slice from a decision about it, n. It
+// makes a balance_empty alert the first time the slice can't be paid for,
+// and a behind_schedule one once it has been due for pageAfter. A slice
+// not due yet, or held by the operator's pause, isn't behind.
+func (h *notifyHub) decided(n notification) {
+	action, _ := n.data["action"].(string)
+	slice, _ := n.data["slice"].(int64)
+	if action == "not_due" || action == "paused" {
+		delete(h.due, n.contract)
+		return
+	}
+	d := h.due[n.contract]
+	if d == nil || d.slice != slice {
+		d = &dueSlice{slice: slice, since: n.time}
+		h.due[n.contract] = d
+	}
+	if reason, _ := n.data["reason"].(string); reason != "" {
+		d.reason = reason
+	}
+	n.severity = severityAlert
+	if action == "unfunded" && !d.unfunded {
+		d.unfunded = true
+		n.kind = notifyBalanceEmpty
+		n.data = map[string]interface{}{"slice": slice, "reason": d.reason}
+		h.send(n)
+	}
+	if h.pageAfter > 0 && !d.alerted && n.time.Sub(d.since) >= h.pageAfter {
+		d.alerted = true
+		n.kind = notifyBehindSchedule
+		n.data = map[string]interface{}{"slice": slice, "dueSeconds": int64(n.time.Sub(d.since) / time.Second), "reason": d.reason}
+		h.send(n)
+	}
+}
+
+// rpcHead starts a new streak unless the previous head failed too.
+func (h *notifyHub) rpcHead(key common.Address, block uint64) {
+	s := h.rpc[key]
+	if s == nil {
+		s = &rpcStreak{}
+		h.rpc[key] = s
+	}
+	if s.errHead != s.head {
+		s.count = 0
+	}
+	s.head = block
+}
+
+// rpcFailed counts a failure at block, once per block; block 0 is a failed
+// reconnect. It returns the streak.
+func (h *notifyHub) rpcFailed(key common.Address, block uint64) int {
+	s := h.rpc[key]
+	if s == nil {
+		s = &rpcStreak{}
+		h.rpc[key] = s
+	}
+	if block == 0 || block != s.errHead {
+		s.count++
+		if block != 0 {
+			s.errHead = block
+		}
+	}
+	return s.count
+}
+
+// notifyQueue runs a backend's deliveries on a goroutine, dropping with a
+// warning what comes in while notifyQueueSize wait.
+type notifyQueue struct {
+	name string
+	ch   chan notification
+
+	mu     sync.Mutex
+	ctx    context.Context
+	closed bool
+	done   chan struct{}
+	cancel context.CancelFunc
+}
+
+func newNotifyQueue(name string) *notifyQueue {
+	return &notifyQueue{name: name, ch: make(chan notification, notifyQueueSize), done: make(chan struct{})}
+}
+
+// start calls deliver for each notification in turn, logging to ctx.
+func (q *notifyQueue) start(ctx context.Context, deliver func(context.Context, notification)) {
+	wctx, cancel := context.WithCancel(context.Background())
+	q.mu.Lock()
+	q.ctx, q.cancel = ctx, cancel
+	q.mu.Unlock()
+	go func() {
+		defer close(q.done)
+		for n := range q.ch {
+			deliver(wctx, n)
+		}
+	}()
+}
+
+func (q *notifyQueue) push(n notification) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || q.cancel == nil {
+		return
+	}
+	select {
+	case q.ch <- n:
+	default:
+		warnf(q.ctx, "%s: %d notifications waiting, dropping a %s", q.name, notifyQueueSize, n.kind)
+	}
+}
+
+// more takes what else is queued, without waiting.
+func (q *notifyQueue) more() []notification {
+	var out []notification
+	for {
+		select {
+		case n, ok := <-q.ch:
+			if !ok {
+				return out
+			}
+			out = append(out, n)
+		default:
+			return out
+		}
+	}
+}
+
+// close stops taking notifications and gives the queued ones notifyDrain
+// to go out.
+func (q *notifyQueue) close() {
+	q.mu.Lock()
+	if q.closed || q.cancel == nil {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.ch)
+	q.mu.Unlock()
+	select {
+	case <-q.done:
+	case <-time.After(notifyDrain):
+		warnf(q.ctx, "%s: %d notifications not delivered by shutdown", q.name, len(q.ch))
+	}
+	q.cancel()
+}
+
+// notifyMessage is a notification worded for people, for each chat
+// backend to mark up its own way.
+type notifyMessage struct {
+	Title string
+	// Plain text after the title, and a line to show preformatted.
+	Text, Code string
+	Tx, TxURL  string
+	// Zero for chain-wide notifications.
+	Contract common.Address
+}
+
+// notifyFormatter words notifications. Slices are numbered from 1 in
+// them, as "n/N".
+type notifyFormatter struct {
+	chainID  uint64
+	explorer string
+	tokens   *tokenCache
+}
+
+func (f *notifyFormatter) message(ctx context.Context, n notification) notifyMessage {
+	m := notifyMessage{Contract: n.contract}
+	if tx, _ := n.data["tx"].(string); tx != "" {
+		m.Tx, m.TxURL = tx, txURL(f.explorer, f.chainID, tx)
+	}
+	slice, _ := n.data["slice"].(int64)
+	reason, _ := n.data["reason"].(string)
+	switch n.kind {
+	case notifySliceExecuted:
+		m.Title = "Slice " + f.sliceOf(n, slice) + " filled"
+		m.Text = f.fillText(ctx, n)
+	case notifyTxFailed:
+		m.Title = "Slice " + f.sliceOf(n, slice) + " tx failed"
+		m.Text = reason
+	case evOrderEnded:
+		outcome, _ := n.data["outcome"].(string)
+		m.Title = "Order " + outcome
+		m.Code, _ = n.data["summary"].(string)
+	case evHalted:
+		m.Title, m.Text = "Halted", reason
+	case evResumed:
+		m.Title = "Resumed"
+		m.Text = fmt.Sprintf("halted %vs (%s)", n.data["haltedSeconds"], reason)
+	case evPaused:
+		m.Title = "Paused by the operator"
+	case evUnpaused:
+		m.Title = "Resumed by the operator"
+	case evGaveUp:
+		m.Title = "Gave up on slice " + f.sliceOf(n, slice)
+		m.Text = fmt.Sprintf("%v failures; it will not be attempted again", n.data["failures"])
+	case evBreakerTripped:
+		m.Title = "Circuit breaker tripped"
+		m.Text = fmt.Sprintf("%v consecutive failures; no submissions until SIGHUP or the cooldown", n.data["consecutiveFailures"])
+	case notifyBehindSchedule:
+		m.Title = "Slice " + f.sliceOf(n, slice) + " behind schedule"
+		due, _ := n.data["dueSeconds"].(int64)
+		m.Text = fmt.Sprintf("due for %s and not executed", time.Duration(due)*time.Second)
+		if reason != "" {
+			m.Text += ": " + reason
+		}
+	case notifyBalanceEmpty:
+		m.Title = "Agent balance too low for slice " + f.sliceOf(n, slice)
+		m.Text = reason
+	case notifyFillRemoved:
+		m.Title = "Slice " + f.sliceOf(n, slice) + " fill reorged out"
+		m.Text = "the slice is open again: ignore its earlier fill notification"
+	case notifyRPCFailures:
+		m.Title = "RPC failing"
+		m.Text = fmt.Sprintf("%v failures running: %v", n.data["consecutiveFailures"], n.data["error"])
+	default:
+		m.Title = n.kind
+	}
+	return m
+}
+
+func (f *notifyFormatter) sliceOf(n notification, slice int64) string {
+	if n.slices > 0 {
+		return fmt.Sprintf("%d/%d", slice+1, n.slices)
+	}
+	return strconv.FormatInt(slice+1, 10)
+}
+
+// fillText is a fill's amounts and price, e.g.
+// "1.5 WETH → 3000 USDC at 2000 USDC/WETH".
+func (f *notifyFormatter) fillText(ctx context.Context, n notification) string {
+	in, out := fillAmounts(n)
+	if in == nil || out == nil {
+		return ""
+	}
+	if n.tokenIn == (common.Address{}) {
+		return fmt.Sprintf("%s in → %s out (raw)", in, out)
+	}
+	tin, tout := f.tokens.get(ctx, n.tokenIn), f.tokens.get(ctx, n.tokenOut)
+	text := tin.format(in) + " → " + tout.format(out)
+	if p, ok := f.price(ctx, n); ok {
+		text += fmt.Sprintf(" at %s %s/%s", p, tout.Symbol, tin.Symbol)
+	}
+	return text
+}
+
+// price is a fill's price in whole tokenOut per tokenIn, when both tokens'
+// decimals are known.
+func (f *notifyFormatter) price(ctx context.Context, n notification) (string, bool) {
+	in, out := fillAmounts(n)
+	if in == nil || out == nil || n.tokenIn == (common.Address{}) {
+		return "", false
+	}
+	tin, tout := f.tokens.get(ctx, n.tokenIn), f.tokens.get(ctx, n.tokenOut)
+	if !tin.Known || !tout.Known {
+		return "", false
+	}
+	p := impliedPrice(in, out, tin.Decimals, tout.Decimals)
+	return p, p != ""
+}
+
+func fillAmounts(n notification) (in, out *big.Int) {
+	in, _ = new(big.Int).SetString(fmt.Sprint(n.data["amountIn"]), 10)
+	out, _ = new(big.Int).SetString(fmt.Sprint(n.data["amountOut"]), 10)
+	return in, out
+}
+
+// errPermanent marks a delivery that retrying won't fix: a 4xx other than
+// 408 and 429.
+var errPermanent = errors.New("not retried")
+
+// jsonPoster POSTs JSON bodies to webhook-style endpoints, retrying
+// network errors, 5xx, 408 and 429 with the wait doubling from backoff.
+type jsonPoster struct {
+	// Names the backend in logs.
+	name     string
+	http     *http.Client
+	attempts int
+	backoff  time.Duration
+}
+
+func newJSONPoster(name string, attempts int) jsonPoster {
+	return jsonPoster{name: name, http: &http.Client{Timeout: 10 * time.Second}, attempts: attempts, backoff: time.Second}
+}
+
+// deliver POSTs body to u with header, logging to logCtx when it gives up
+// on what.
+func (p jsonPoster) deliver(ctx, logCtx context.Context, u, what string, body []byte, header http.Header) {
+	wait := p.backoff
+	for attempt := 1; ; attempt++ {
+		err := p.post(ctx, u, body, header)
+		if err == nil {
+			return
+		}
+		if attempt >= p.attempts || errors.Is(err, errPermanent) {
+			warnf(logCtx, "%s %s: dropping %s after %d attempt(s): %v", p.name, webhookHost(u), what, attempt, err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			warnf(logCtx, "%s %s: dropping %s at shutdown: %v", p.name, webhookHost(u), what, err)
+			return
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func (p jsonPoster) post(ctx context.Context, u string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "twap-agent")
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	resp.Body.Close()
+	switch code := resp.StatusCode; {
+	case code >= 200 && code < 300:
+		return nil
+	case code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s", errPermanent, resp.Status)
+	default:
+		return errors.New(resp.Status)
+	}
+}
+
+// webhookHost names a webhook in logs without the path, which often holds
+// the receiver's own secret.
+func webhookHost(u string) string {
+	if p, err := url.Parse(u); err == nil {
+		return p.Host
+	}
+	return "?"
+}
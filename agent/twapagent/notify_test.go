@@ -0,0 +1,214 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// notifyRecorder is a backend that keeps what it is given.
+type notifyRecorder struct {
+	mu  sync.Mutex
+	got []notification
+}
+
+func (r *notifyRecorder) start(context.Context) {}
+func (r *notifyRecorder) close()                {}
+
+func (r *notifyRecorder) notify(n notification) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.got = append(r.got, n)
+}
+
+// testVault is a vault with only a strategy cache, of total slices when
+// total is not nil.
+func testVault(addr common.Address, s Strategy, total *big.Int) *vaultBot {
+	return &vaultBot{executor: executor{addr: addr, st: &botState{strategy: &strategyCache{s: s, total: total}}}}
+}
+
+// testHub hands vaults' records to b.
+func testHub(chainID uint64, b notifier, vaults ...*vaultBot) *notifyHub {
+	h := newNotifyHub(chainID, 0, []notifier{b})
+	h.start(context.Background(), vaults)
+	return h
+}
+
+// Only the records worth a notification get to the backends, with their
+// severity.
+func TestNotifyHubSelectsEvents(t *testing.T) {
+	v1, v2 := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+	rec := &notifyRecorder{}
+	h := testHub(1, rec, testVault(v1, Strategy{}, nil), testVault(v2, Strategy{}, nil))
+	head := func(c common.Address, b uint64) eventRecord {
+		return eventRecord{Type: evHead, Block: b, Contract: c.Hex()}
+	}
+	readErr := func(c common.Address, b uint64) eventRecord {
+		return eventRecord{Type: evError, Block: b, Contract: c.Hex(), Data: map[string]interface{}{"error": "timeout"}}
+	}
+	for _, r := range []eventRecord{
+		{Type: evOrderStatus, Contract: v1.Hex(), Data: map[string]interface{}{"status": "Filled"}},
+		{Type: evOrderEnded, Contract: v1.Hex(), Data: map[string]interface{}{"outcome": "filled"}},
+		{Type: evFill, Contract: v1.Hex(), Data: map[string]interface{}{"slice": int64(1), "removed": true}},
+		{Type: evDecision, Contract: v1.Hex()},
+		{Type: evError, Contract: v2.Hex(), Data: map[string]interface{}{"slice": int64(3), "error": "nonce too low"}}, // tx_failed
+		// v1 fails three heads running; v2 recovers in between.
+		head(v1, 10), readErr(v1, 10), head(v2, 10), readErr(v2, 10),
+		head(v1, 11), readErr(v1, 11), head(v2, 11),
+		head(v1, 12), readErr(v1, 12), head(v2, 12), readErr(v2, 12), // rpc_failures for v1
+		head(v1, 13), readErr(v1, 13),
+		{Type: evOrderEnded, Contract: v2.Hex(), Data: map[string]interface{}{"outcome": "expired"}},
+	} {
+		h.tap(r)
+	}
+	var got []string
+	for _, n := range rec.got {
+		got = append(got, n.kind+" "+n.severity.String()+" "+n.contract.Hex())
+	}
+	want := []string{
+		evOrderEnded + " info " + v1.Hex(),
+		notifyTxFailed + " alert " + v2.Hex(),
+		notifyRPCFailures + " alert " + v1.Hex(),
+		evOrderEnded + " alert " + v2.Hex(),
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("notified %q, want %q", got, want)
+	}
+}
+
+func TestRouteBySeverity(t *testing.T) {
+	info, alert := notification{severity: severityInfo}, notification{severity: severityAlert}
+	if got := routeBySeverity(alert, "routine", "alerts"); got != "alerts" {
+		t.Errorf("alert went to %q", got)
+	}
+	if got := routeBySeverity(info, "routine", "alerts"); got != "routine" {
+		t.Errorf("info went to %q", got)
+	}
+	if got := routeBySeverity(alert, "routine", ""); got != "routine" {
+		t.Errorf("alert without an alerts channel went to %q", got)
+	}
+}
+
+func TestNotifyQueueDropsWhenFull(t *testing.T) {
+	q := newNotifyQueue("test")
+	// Started without its worker, so nothing drains the queue.
+	q.ctx, q.cancel = context.Background(), func() {}
+	for i := 0; i < notifyQueueSize+5; i++ {
+		q.push(notification{kind: evPaused})
+	}
+	if len(q.ch) != notifyQueueSize {
+		t.Errorf("queued %d, want %d", len(q.ch), notifyQueueSize)
+	}
+}
+
+func TestNotifyFormatter(t *testing.T) {
+	f := &notifyFormatter{chainID: 1, tokens: newTokenCache(nil)}
+	f.tokens.m[tgIn] = tokenInfo{Symbol: "WETH", Decimals: 18, Known: true}
+	f.tokens.m[tgOut] = tokenInfo{Symbol: "USDC", Decimals: 6, Known: true}
+	ctx := context.Background()
+	for _, c := range []struct {
+		n    notification
+		want notifyMessage
+	}{
+		{
+			notification{kind: notifySliceExecuted, slices: 5, tokenIn: tgIn, tokenOut: tgOut, contract: tgVault, data: tgFill(2, "0xab").Data},
+			notifyMessage{Title: "Slice 3/5 filled", Text: "1.5 WETH → 3000 USDC at 2000 USDC/WETH", Tx: "0xab", TxURL: "https://etherscan.io/tx/0xab", Contract: tgVault},
+		},
+		{
+			notification{kind: notifyTxFailed, data: map[string]interface{}{"slice": int64(0), "reason": "nonce too low"}},
+			notifyMessage{Title: "Slice 1 tx failed", Text: "nonce too low"},
+		},
+		{
+			notification{kind: evOrderEnded, data: map[string]interface{}{"outcome": "expired", "summary": "TWAP Summary: order expired"}},
+			notifyMessage{Title: "Order expired", Code: "TWAP Summary: order expired"},
+		},
+	} {
+		if got := f.message(ctx, c.n); got != c.want {
+			t.Errorf("message(%s) = %+v, want %+v", c.n.kind, got, c.want)
+		}
+	}
+}
+
+func TestNotifyConfigModes(t *testing.T) {
+	cfg := NotifyConfig{Slack: SlackConfig{WebhookURL: "https://hooks.slack.com/services/x"}}
+	if f := cfg.flag(); f != "--slack-webhook-url" {
+		t.Errorf("flag() = %q", f)
+	}
+	if n := cfg.notifiers(1, nil); len(n) != 1 {
+		t.Errorf("%d notifiers, want Slack's", len(n))
+	}
+	if f := (NotifyConfig{}).flag(); f != "" {
+		t.Errorf("flag() without a backend = %q", f)
+	}
+}
+
+// A slice still due after pageAfter is alerted on once, and a slice the
+// agent can't pay for as soon as it is seen.
+func TestNotifyHubBehindSchedule(t *testing.T) {
+	rec := &notifyRecorder{}
+	h := testHub(1, rec, testVault(tgVault, Strategy{}, nil))
+	h.pageAfter = 10 * time.Minute
+	t0 := time.Unix(1700000000, 0)
+	decision := func(after time.Duration, slice int64, action string) eventRecord {
+		return eventRecord{Type: evDecision, Time: t0.Add(after), Data: map[string]interface{}{"slice": slice, "action": action}}
+	}
+	for _, r := range []eventRecord{
+		decision(0, 0, "submit"),
+		decision(5*time.Minute, 0, "waiting"),
+		{Type: evDecision, Time: t0.Add(6 * time.Minute), Data: map[string]interface{}{"slice": int64(0), "action": "unfunded", "reason": "insufficient agent balance"}},
+		decision(7*time.Minute, 0, "unfunded"),
+		decision(11*time.Minute, 0, "blocked"),
+		decision(12*time.Minute, 0, "blocked"),
+		// Filled: slice 1 starts its own wait, and pausing clears it.
+		{Type: evFill, Data: map[string]interface{}{"slice": int64(0), "amountIn": "1", "amountOut": "1"}},
+		decision(13*time.Minute, 1, "submit"),
+		decision(14*time.Minute, 1, "paused"),
+		decision(30*time.Minute, 1, "submit"),
+	} {
+		h.tap(r)
+	}
+	var got []string
+	for _, n := range rec.got {
+		got = append(got, fmt.Sprintf("%s %s %v", n.kind, n.severity, n.data["slice"]))
+	}
+	want := []string{notifyBalanceEmpty + " alert 0", notifyBehindSchedule + " alert 0", notifySliceExecuted + " info 0"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("notified %q, want %q", got, want)
+	}
+	if len(rec.got) > 1 {
+		if n := rec.got[1]; n.data["dueSeconds"] != int64(660) || n.data["reason"] != "insufficient agent balance" {
+			t.Errorf("behind_schedule = %+v", n.data)
+		}
+	}
+}
+
+// A fill reorged out after its slice_executed is corrected; one never
+// notified isn't.
+func TestNotifyHubFillRemoved(t *testing.T) {
+	rec := &notifyRecorder{}
+	h := testHub(1, rec, testVault(tgVault, Strategy{}, nil))
+	fill := func(slice int64, tx string, removed bool) eventRecord {
+		return eventRecord{Type: evFill, Data: map[string]interface{}{"slice": slice, "tx": tx, "removed": removed}}
+	}
+	for _, r := range []eventRecord{fill(0, "0xaa", false), fill(0, "0xaa", true), fill(1, "0xbb", true), fill(0, "0xaa", true)} {
+		h.tap(r)
+	}
+	var got []string
+	for _, n := range rec.got {
+		got = append(got, fmt.Sprintf("%s %s %v", n.kind, n.severity, n.data["slice"]))
+	}
+	want := []string{notifySliceExecuted + " info 0", notifyFillRemoved + " alert 0"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("notified %q, want %q", got, want)
+	}
+	f := &notifyFormatter{chainID: 1, tokens: newTokenCache(nil)}
+	if m := f.message(context.Background(), rec.got[len(rec.got)-1]); m.Title != "Slice 1 fill reorged out" || m.Tx != "0xaa" {
+		t.Errorf("correction = %+v", m)
+	}
+}
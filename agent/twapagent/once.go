@@ -0,0 +1,157 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// ExitNotDue is once mode's exit code when no slice is due yet.
+const ExitNotDue = 5
+
+var (
+	// errNothingDue is returned by once when the next slice isn't due yet.
+	errNothingDue = errors.New("no slice is due")
+	// Why a slice picked with --slice can't be executed.
+	errSliceDone   = errors.New("slice already done")
+	errSliceNotDue = errors.New("slice not yet due")
+)
+
+// once is a single bot evaluation for cron and keeper frameworks. It finds the
+// next slice the way preflight does and, if it is due, submits it through
+// execute() and waits for the receipt, so simulation, the gas ceiling and the
+// pending sliceDone check apply as in bot mode. It needs no subscriptions.
+// A target of 0 or more executes that slice instead of the next one. It
+// returns the hash of the tx that executed it, zero for a dry run.
+func (a *Agent) once(ctx context.Context, addr common.Address, target int64) (common.Hash, error) {
+	e := a.executorFor(addr)
+	if e.signer == nil && e.st.sender.relay == nil {
+		return common.Hash{}, fmt.Errorf("a signer (or --defender-api-key) is required for once mode")
+	}
+	status, err := readStatus(ctx, e.addr, e.cABI, e.client)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if end := terminalEnd(status); end != nil {
+		fmt.Fprintf(outputFrom(ctx), "Order is %s, nothing to execute\n", end.Outcome)
+		return common.Hash{}, end
+	}
+	var next nextSlice
+	if target >= 0 {
+		if next, err = targetSlice(ctx, e.addr, e.cABI, e.client, target, e.txCfg.Force); err != nil {
+			return common.Hash{}, err
+		}
+	} else {
+		if next, err = findNextSlice(ctx, e.addr, e.cABI, e.client, e.txCfg.MaxScanSlices); err != nil {
+			return common.Hash{}, err
+		}
+		if !next.Eligible() {
+			fmt.Fprintf(outputFrom(ctx), "Next slice %d scheduled at %s (in ~%ss)\n", next.ID, next.Scheduled, new(big.Int).Sub(next.Scheduled, next.Now))
+			return common.Hash{}, errNothingDue
+		}
+	}
+
+	ledger, err := loadGasLedger(a.cfg.ReceiptsFile)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	e.st.ledger, e.st.failures = ledger, newFailureTracker(a.cfg.Retry)
+	var from common.Address
+	if e.signer != nil {
+		from = e.signer.Address()
+		e.st.nonces = newNonceManager(e.st.txClient, from)
+	} else {
+		from = e.st.sender.relay.Address()
+	}
+	e.st.balance = newBalanceWatcher(a.cfg.Balance, from)
+	head, err := blockNumber(ctx, e.st.txClient)
+	if err != nil {
+		logf(ctx, "block number: %v", err)
+	}
+	e.st.balance.Check(ctx, e.st.txClient, head)
+
+	if !e.txCfg.SkipSimulation && !e.txCfg.DryRun {
+		// Simulated here too so a revert comes back as the error.
+		if err := simulateSlice(ctx, e.addr, e.cABI, e.client, from, next.ID, !next.Eligible()); err != nil {
+			return common.Hash{}, fmt.Errorf("slice %d reverts: %w", next.ID, err)
+		}
+	}
+	if next.Eligible() {
+		fmt.Fprintf(outputFrom(ctx), "Eligible slice %d\n", next.ID)
+	} else {
+		fmt.Fprintf(outputFrom(ctx), "Submitting slice %d before it is due (--force)\n", next.ID)
+	}
+	e.execute(ctx, next.ID, new(big.Int).Sub(next.Now, next.Scheduled).Int64())
+	if ctx.Err() != nil {
+		return common.Hash{}, drainSubmitted(ctx, e.addr, e.txCfg, e.st)
+	}
+	if e.txCfg.DryRun {
+		return common.Hash{}, nil
+	}
+
+	// execute() logs why it didn't go through; the chain has the final say.
+	done, err := readSliceDone(ctx, e.addr, e.cABI, e.client, big.NewInt(next.ID))
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("read sliceDone(%d): %w", next.ID, err)
+	}
+	if !done {
+		return common.Hash{}, fmt.Errorf("slice %d was not executed", next.ID)
+	}
+	fmt.Fprintf(outputFrom(ctx), "Slice %d executed\n", next.ID)
+	// The ledger has the receipt unless someone else's tx filled it.
+	var hash common.Hash
+	for _, r := range e.st.ledger.Records(e.addr) {
+		if r.Slice == next.ID && r.Success {
+			hash = common.HexToHash(r.TxHash)
+		}
+	}
+	return hash, nil
+}
+
+// targetSlice checks slice id for --slice: it must exist and be open, and
+// unless force is set it must be due at the latest block.
+func targetSlice(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, id int64, force bool) (nextSlice, error) {
+	s, err := readStrategy(ctx, addr, cABI, client)
+	if err != nil {
+		return nextSlice{}, fmt.Errorf("read strategy: %w", err)
+	}
+	N, err := readTotalSlices(ctx, addr, cABI, client)
+	if err != nil {
+		return nextSlice{}, fmt.Errorf("read totalSlices: %w", err)
+	}
+	n, err := sliceCount(N)
+	if err != nil {
+		return nextSlice{}, err
+	}
+	if n == 0 {
+		return nextSlice{}, errNotInitialized
+	}
+	if id >= n {
+		return nextSlice{}, fmt.Errorf("slice %d does not exist, the order has %d slices", id, n)
+	}
+	done, err := readSliceDone(ctx, addr, cABI, client, big.NewInt(id))
+	if err != nil {
+		return nextSlice{}, fmt.Errorf("read sliceDone(%d): %w", id, err)
+	}
+	if done {
+		return nextSlice{}, fmt.Errorf("%w: slice %d", errSliceDone, id)
+	}
+	scheduled, err := sliceScheduledAt(s, n, id)
+	if err != nil {
+		return nextSlice{}, err
+	}
+	header, err := headerByNumber(ctx, client, nil)
+	if err != nil {
+		return nextSlice{}, fmt.Errorf("header: %w", err)
+	}
+	next := nextSlice{ID: id, Scheduled: scheduled, Now: new(big.Int).SetUint64(header.Time)}
+	if !next.Eligible() && !force {
+		return nextSlice{}, fmt.Errorf("%w: slice %d is scheduled at %s (in ~%ss); pass --force to submit it anyway", errSliceNotDue, id, scheduled, new(big.Int).Sub(scheduled, next.Now))
+	}
+	return next, nil
+}
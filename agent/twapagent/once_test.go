@@ -0,0 +1,61 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// onceAgent is an Agent for once mode against the mock vault.
+func onceAgent(cABI abi.ABI, client *ethclient.Client, signer Signer) *Agent {
+	return &Agent{cABI: cABI, client: client, txClient: client, signer: signer, chainID: fakeChainID, sender: &txBroadcaster{public: client}}
+}
+
+func TestOnceNothingDue(t *testing.T) {
+	// 24 slices from t=1000 to 3000 with 20 done: slice 20 is due at 2660,
+	// after the mock's block time of 2000.
+	v, twap, cABI, client, _ := newVaultRPC(t, 24, 20)
+	signer := newFakeSigner(t)
+	_, err := onceAgent(cABI, client, signer).once(context.Background(), twap.Address(), -1)
+	if !errors.Is(err, errNothingDue) {
+		t.Fatalf("once = %v, want errNothingDue", err)
+	}
+	if signer.calls != 0 {
+		t.Fatalf("TransactOpts calls = %d, want 0 when nothing is due", signer.calls)
+	}
+	if v.roundTrips.Load() == 0 {
+		t.Fatal("once made no RPC calls")
+	}
+}
+
+func TestOnceRequiresSigner(t *testing.T) {
+	_, twap, cABI, client, _ := newVaultRPC(t, 24, 0)
+	if _, err := onceAgent(cABI, client, nil).once(context.Background(), twap.Address(), -1); err == nil {
+		t.Fatal("once ran without a signer")
+	}
+}
+
+func TestTargetSlice(t *testing.T) {
+	// 24 slices from t=1000 to 3000, interval 83, block time 2000: slices
+	// 0-4 are done and slice 12 (due at 1996) is the last one that is due.
+	_, twap, cABI, client, _ := newVaultRPC(t, 24, 5)
+	ctx := context.Background()
+	if _, err := targetSlice(ctx, twap.Address(), cABI, client, 3, false); !errors.Is(err, errSliceDone) {
+		t.Errorf("done slice: err = %v, want errSliceDone", err)
+	}
+	if _, err := targetSlice(ctx, twap.Address(), cABI, client, 13, false); !errors.Is(err, errSliceNotDue) {
+		t.Errorf("future slice: err = %v, want errSliceNotDue", err)
+	}
+	if next, err := targetSlice(ctx, twap.Address(), cABI, client, 13, true); err != nil || next.ID != 13 || next.Eligible() {
+		t.Errorf("forced future slice = %+v, %v; want slice 13, not yet eligible", next, err)
+	}
+	if next, err := targetSlice(ctx, twap.Address(), cABI, client, 12, false); err != nil || !next.Eligible() {
+		t.Errorf("due slice = %+v, %v; want it eligible", next, err)
+	}
+	if _, err := targetSlice(ctx, twap.Address(), cABI, client, 24, true); err == nil {
+		t.Error("slice past the last one accepted")
+	}
+}
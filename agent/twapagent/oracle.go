@@ -0,0 +1,221 @@
+package twapagent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// oracleABIJSON is IOracle. getPrice isn't declared view, but the mocks and
+// feeds behind it only read, so it is eth_called like one.
+const oracleABIJSON = `[{"type":"function","name":"getPrice","stateMutability":"nonpayable","inputs":[{"name":"tokenIn","type":"address"},{"name":"tokenOut","type":"address"}],"outputs":[{"name":"price","type":"uint256"}]}]`
+
+var oracleABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(oracleABIJSON))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// priceCheck is executeSlice's oracle arithmetic for one slice: the price
+// and its deviation from the reference, and the minimum output it demands.
+type priceCheck struct {
+	Price, Reference *big.Int
+	// |price - reference| * 10000 / reference, rounded down like the vault.
+	DeviationBps *big.Int
+	MaxDeviation uint16
+	AmountIn     *big.Int
+	// amountIn at the oracle price, and that less maxSlippageBps.
+	OracleOut, MinOut *big.Int
+	// The Chainlink round's updatedAt, unix seconds; 0 for IOracle, which
+	// doesn't say.
+	UpdatedAt uint64
+}
+
+// DeviationOK reports whether the PRICE_DEVIATION check passes.
+func (c priceCheck) DeviationOK() bool {
+	return c.DeviationBps.Cmp(big.NewInt(int64(c.MaxDeviation))) <= 0
+}
+
+// newPriceCheck computes executeSlice's checks for amountIn at price p.
+func newPriceCheck(s Strategy, p, reference, amountIn *big.Int) priceCheck {
+	c := priceCheck{Price: p, Reference: reference, MaxDeviation: s.MaxPriceDeviationBps, AmountIn: amountIn, DeviationBps: new(big.Int)}
+	if reference.Sign() > 0 {
+		diff := new(big.Int).Sub(p, reference)
+		c.DeviationBps.Abs(diff).Mul(c.DeviationBps, big.NewInt(10_000)).Div(c.DeviationBps, reference)
+	}
+	c.OracleOut = new(big.Int).Mul(amountIn, p)
+	c.OracleOut.Div(c.OracleOut, big.NewInt(1e18))
+	c.MinOut = new(big.Int).Mul(amountIn, p)
+	c.MinOut.Mul(c.MinOut, big.NewInt(int64(10_000-int(s.MaxSlippageBps))))
+	c.MinOut.Div(c.MinOut, new(big.Int).Exp(big.NewInt(10), big.NewInt(22), nil))
+	return c
+}
+
+// Oracle shapes --oracle-abi selects.
+const (
+	oracleKindIOracle   = "ioracle"
+	oracleKindChainlink = "chainlink"
+)
+
+// chainlinkABIJSON is the part of AggregatorV3Interface the agent reads.
+const chainlinkABIJSON = `[
+{"type":"function","name":"decimals","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint8"}]},
+{"type":"function","name":"latestRoundData","stateMutability":"view","inputs":[],"outputs":[{"name":"roundId","type":"uint80"},{"name":"answer","type":"int256"},{"name":"startedAt","type":"uint256"},{"name":"updatedAt","type":"uint256"},{"name":"answeredInRound","type":"uint80"}]}
+]`
+
+var chainlinkABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(chainlinkABIJSON))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// OracleShape is how the agent reads a price: IOracle's getPrice, or a
+// Chainlink AggregatorV3 feed, with the ABI to pack the calls with.
+type OracleShape struct {
+	Kind string
+	ABI  abi.ABI
+	// Read this contract instead of the strategy's priceOracle when set.
+	Address common.Address
+}
+
+// loadOracleShape resolves --oracle-abi: "ioracle" (or empty), "chainlink",
+// or the path of a JSON ABI that has getPrice(address,address) or
+// latestRoundData().
+func loadOracleShape(spec string) (OracleShape, error) {
+	switch spec {
+	case "", oracleKindIOracle:
+		return OracleShape{Kind: oracleKindIOracle, ABI: oracleABI}, nil
+	case oracleKindChainlink:
+		return OracleShape{Kind: oracleKindChainlink, ABI: chainlinkABI}, nil
+	}
+	data, err := os.ReadFile(spec)
+	if err != nil {
+		return OracleShape{}, fmt.Errorf("read --oracle-abi: %w", err)
+	}
+	parsed, err := abi.JSON(bytes.NewReader(data))
+	if err != nil {
+		return OracleShape{}, fmt.Errorf("parse --oracle-abi %s: %w", spec, err)
+	}
+	if m, ok := parsed.Methods["getPrice"]; ok && len(m.Inputs) == 2 && len(m.Outputs) == 1 {
+		return OracleShape{Kind: oracleKindIOracle, ABI: parsed}, nil
+	}
+	if m, ok := parsed.Methods["latestRoundData"]; ok && len(m.Outputs) == 5 {
+		if _, ok := parsed.Methods["decimals"]; !ok {
+			parsed.Methods["decimals"] = chainlinkABI.Methods["decimals"]
+		}
+		return OracleShape{Kind: oracleKindChainlink, ABI: parsed}, nil
+	}
+	return OracleShape{}, fmt.Errorf("--oracle-abi %s has neither getPrice(address,address) nor latestRoundData()", spec)
+}
+
+// readOraclePrice reads oracle's price for s's pair in IOracle's unit: raw
+// tokenOut per raw tokenIn, times 1e18. A Chainlink answer is a whole-token
+// price with the feed's decimals, so it is rescaled by the tokens' decimals.
+// The round's updatedAt comes with it, 0 for IOracle.
+func readOraclePrice(ctx context.Context, client *ethclient.Client, shape OracleShape, oracle common.Address, s Strategy) (*big.Int, uint64, error) {
+	return readOraclePriceAt(ctx, client, shape, oracle, s, nil)
+}
+
+// readOraclePriceAt is readOraclePrice as of block.
+func readOraclePriceAt(ctx context.Context, client *ethclient.Client, shape OracleShape, oracle common.Address, s Strategy, block *big.Int) (*big.Int, uint64, error) {
+	if shape.Kind != oracleKindChainlink {
+		outs, err := callViewBlock(ctx, oracle, shape.ABI, client, block, "getPrice", s.TokenIn, s.TokenOut)
+		if err != nil {
+			return nil, 0, fmt.Errorf("oracle getPrice: %w", err)
+		}
+		return outs[0].(*big.Int), 0, nil
+	}
+	outs, err := callViewBlock(ctx, oracle, shape.ABI, client, block, "latestRoundData")
+	if err != nil {
+		return nil, 0, fmt.Errorf("oracle latestRoundData: %w", err)
+	}
+	answer, updatedAt := outs[1].(*big.Int), outs[3].(*big.Int)
+	if answer.Sign() <= 0 {
+		return nil, 0, fmt.Errorf("oracle answered %s", answer)
+	}
+	outs, err = callViewBlock(ctx, oracle, shape.ABI, client, block, "decimals")
+	if err != nil {
+		return nil, 0, fmt.Errorf("oracle decimals: %w", err)
+	}
+	in, out := readTokenInfo(ctx, client, s.TokenIn), readTokenInfo(ctx, client, s.TokenOut)
+	if !in.Known || !out.Known {
+		return nil, 0, fmt.Errorf("token decimals unknown, cannot scale the feed's answer")
+	}
+	return chainlinkToPrice(answer, outs[0].(uint8), in.Decimals, out.Decimals), updatedAt.Uint64(), nil
+}
+
+// chainlinkToPrice turns a feed answer with feedDec decimals into IOracle's
+// unit: answer * 1e18 * 10^decOut / (10^feedDec * 10^decIn).
+func chainlinkToPrice(answer *big.Int, feedDec, decIn, decOut uint8) *big.Int {
+	num := new(big.Int).Mul(answer, new(big.Int).Exp(big.NewInt(10), big.NewInt(18+int64(decOut)), nil))
+	den := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(feedDec)+int64(decIn)), nil)
+	return num.Div(num, den)
+}
+
+// readPriceCheck reads the oracle price and the vault's reference price and
+// computes the checks for amountIn. shape says how to read the strategy's
+// priceOracle.
+func readPriceCheck(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, shape OracleShape, s Strategy, amountIn *big.Int) (priceCheck, error) {
+	oracle := s.PriceOracle
+	if shape.Address != (common.Address{}) {
+		oracle = shape.Address
+	}
+	p, updatedAt, err := readOraclePrice(ctx, client, shape, oracle, s)
+	if err != nil {
+		return priceCheck{}, err
+	}
+	outs, err := callView(ctx, addr, cABI, client, "referencePrice")
+	if err != nil {
+		return priceCheck{}, fmt.Errorf("read referencePrice: %w", err)
+	}
+	c := newPriceCheck(s, p, outs[0].(*big.Int), amountIn)
+	c.UpdatedAt = updatedAt
+	return c, nil
+}
+
+// priceGate logs the oracle's deviation from the reference price, and the
+// venue's quote with --adapter-kind, before sliceId is submitted. It returns
+// false, so the slice is retried on a later block, when the deviation is over
+// the maximum and txCfg.SkipOnDeviation is set: executeSlice would revert
+// with PRICE_DEVIATION. It also returns false while the kill switch holds the
+// bot halted (see haltSwitch). A failed read lets the slice through, unless
+// the bot is already halted.
+func priceGate(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, txCfg TxConfig, st *botState, sliceId int64) bool {
+	s, err := sliceStrategy(ctx, addr, cABI, client, st)
+	if err != nil {
+		logf(ctx, "slice %d: price check unavailable: %v", sliceId, err)
+		return !st.halt.holding(ctx, sliceId)
+	}
+	c, err := readPriceCheck(ctx, addr, cABI, client, txCfg.Oracle, s, s.SliceAmountIn)
+	if err != nil {
+		logf(ctx, "slice %d: price check unavailable: %v", sliceId, err)
+		if st.halt.holding(ctx, sliceId) {
+			return false
+		}
+	} else {
+		logf(ctx, "Slice %d: oracle price %s, reference %s, deviation %s bps (max %d)", sliceId, c.Price, c.Reference, c.DeviationBps, c.MaxDeviation)
+		st.halt.update(ctx, haltReason(c, time.Now(), txCfg.MaxOracleAge, txCfg.HaltDeviationBps))
+		if st.halt.holding(ctx, sliceId) {
+			return false
+		}
+		if !c.DeviationOK() && txCfg.SkipOnDeviation {
+			logf(ctx, "not submitting slice %d: deviation %s bps exceeds maxPriceDeviationBps %d, retrying on the next block", sliceId, c.DeviationBps, c.MaxDeviation)
+			emitEvent(ctx, evDecision, 0, map[string]interface{}{"slice": sliceId, "action": "skipped", "reason": "PRICE_DEVIATION", "deviationBps": c.DeviationBps.String()})
+			return false
+		}
+	}
+	logSliceQuote(ctx, client, txCfg, st, s, sliceId)
+	return true
+}
@@ -0,0 +1,55 @@
+package twapagent
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChainlinkToPrice(t *testing.T) {
+	// ETH/USD at 2000 with 8 decimals; WETH (18) in, USDC (6) out: one raw
+	// wei buys 2000e6/1e18 raw USDC, times 1e18.
+	got := chainlinkToPrice(big.NewInt(2000e8), 8, 18, 6)
+	if want := big.NewInt(2000e6); got.Cmp(want) != 0 {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	// Same decimals on both tokens: the answer rescaled to 18 decimals.
+	got = chainlinkToPrice(big.NewInt(15e7), 8, 18, 18)
+	if want := new(big.Int).Mul(big.NewInt(15), big.NewInt(1e17)); got.Cmp(want) != 0 {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestLoadOracleShape(t *testing.T) {
+	for spec, want := range map[string]string{"": oracleKindIOracle, "ioracle": oracleKindIOracle, "chainlink": oracleKindChainlink} {
+		shape, err := loadOracleShape(spec)
+		if err != nil || shape.Kind != want {
+			t.Errorf("%q: got %q, %v; want %q", spec, shape.Kind, err, want)
+		}
+	}
+
+	dir := t.TempDir()
+	write := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	shape, err := loadOracleShape(write("ioracle.json", oracleABIJSON))
+	if err != nil || shape.Kind != oracleKindIOracle {
+		t.Errorf("getPrice ABI: %q, %v", shape.Kind, err)
+	}
+	feed := write("feed.json", `[{"type":"function","name":"latestRoundData","stateMutability":"view","inputs":[],"outputs":[{"name":"roundId","type":"uint80"},{"name":"answer","type":"int256"},{"name":"startedAt","type":"uint256"},{"name":"updatedAt","type":"uint256"},{"name":"answeredInRound","type":"uint80"}]}]`)
+	shape, err = loadOracleShape(feed)
+	if err != nil || shape.Kind != oracleKindChainlink {
+		t.Fatalf("latestRoundData ABI: %q, %v", shape.Kind, err)
+	}
+	if _, ok := shape.ABI.Methods["decimals"]; !ok {
+		t.Error("decimals() should be added when the ABI lacks it")
+	}
+	if _, err := loadOracleShape(write("other.json", `[{"type":"function","name":"foo","inputs":[],"outputs":[]}]`)); err == nil {
+		t.Error("an ABI with neither shape should be rejected")
+	}
+}
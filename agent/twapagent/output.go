@@ -0,0 +1,52 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Values of --output.
+const (
+	formatText = "text"
+	formatCSV  = "csv"
+	formatJSON = "json"
+)
+
+func validFormat(f string) bool {
+	return f == formatText || f == formatCSV || f == formatJSON
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// createOutput opens --out for writing, truncating it; "" and "-" are
+// ctx's output.
+func createOutput(ctx context.Context, path string) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return nopCloser{outputFrom(ctx)}, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// outputKey carries where a mode prints its reports and summaries: the
+// Agent's stdout, or its stderr while --events-out has stdout.
+type outputKey struct{}
+
+func withOutput(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, outputKey{}, w)
+}
+
+// outputFrom is ctx's output, os.Stdout without one.
+func outputFrom(ctx context.Context) io.Writer {
+	if w, ok := ctx.Value(outputKey{}).(io.Writer); ok {
+		return w
+	}
+	return os.Stdout
+}
@@ -0,0 +1,83 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// checkOwner fails unless e signs as the vault's owner().
+func (e *executor) checkOwner(ctx context.Context) error {
+	if e.signer == nil {
+		return errors.New("this mode needs the vault owner's key")
+	}
+	outs, err := callView(ctx, e.addr, e.cABI, e.client, "owner")
+	if err != nil {
+		return fmt.Errorf("read owner: %w", err)
+	}
+	if owner := outs[0].(common.Address); owner != e.signer.Address() {
+		return fmt.Errorf("%s is not the owner of %s (owner is %s)", e.signer.Address().Hex(), e.addr.Hex(), owner.Hex())
+	}
+	return nil
+}
+
+// sendOwnerTx sends one transaction from the owner key outside bot mode, for
+// the owner's own calls to the vault or a token. The call (data to `to`,
+// decoded against cABI) is simulated first so a rejection comes back as the
+// decoded revert, then priced per --tx-type, sent by send with a buffered
+// gas estimate, and waited on for up to --wait-timeout. A mined revert is an
+// error too, returned alongside its receipt.
+func (e *executor) sendOwnerTx(ctx context.Context, to common.Address, cABI abi.ABI, method string, data []byte, send func(*bind.TransactOpts) (*types.Transaction, error)) (*types.Receipt, error) {
+	from := e.signer.Address()
+	msg := ethereum.CallMsg{From: from, To: &to, Data: data}
+	err := rpcRead(ctx, "eth_call "+method, func(ctx context.Context) error {
+		_, err := e.client.CallContract(ctx, msg, nil)
+		return err
+	})
+	if err != nil {
+		return nil, wrapCallError(cABI, method, err)
+	}
+
+	auth, err := e.signer.TransactOpts(ctx, e.chainID)
+	if err != nil {
+		return nil, fmt.Errorf("transactor: %w", err)
+	}
+	quote, err := quoteGas(ctx, e.st.txClient, e.txCfg)
+	if err != nil {
+		return nil, fmt.Errorf("pricing: %w", err)
+	}
+	quote.apply(auth)
+	gas, err := estimateGas(ctx, e.st.txClient, msg)
+	if err != nil {
+		return nil, fmt.Errorf("estimate gas for %s: %w", method, err)
+	}
+	auth.GasLimit = gas * (100 + e.txCfg.GasBufferPercent) / 100
+
+	tx, err := send(auth)
+	if err != nil {
+		return nil, fmt.Errorf("send %s: %w", method, err)
+	}
+	fmt.Fprintf(outputFrom(ctx), "Submitted %s tx %s\n", method, tx.Hash().Hex())
+
+	waitCtx := ctx
+	if e.txCfg.WaitTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, e.txCfg.WaitTimeout)
+		defer cancel()
+	}
+	receipt, err := bind.WaitMined(waitCtx, e.st.txClient, tx)
+	if err != nil {
+		return nil, fmt.Errorf("wait for %s tx %s: %w", method, tx.Hash().Hex(), err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return receipt, fmt.Errorf("%s tx %s reverted in block %d", method, tx.Hash().Hex(), receipt.BlockNumber.Uint64())
+	}
+	fmt.Fprintf(outputFrom(ctx), "Mined %s in block %d (gasUsed=%d)\n", method, receipt.BlockNumber.Uint64(), receipt.GasUsed)
+	return receipt, nil
+}
@@ -0,0 +1,177 @@
+package twapagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// PagerDutyConfig pages through the Events API v2 when a slice stays
+// unexecuted past --page-after.
+type PagerDutyConfig struct {
+	// An Events API v2 integration's routing key.
+	RoutingKey string
+}
+
+const (
+	pagerDutyAPI = "https://events.pagerduty.com/v2/enqueue"
+	// Tries per event, with the wait doubling from 1s between them.
+	pagerDutyAttempts = 5
+	// The Events API's limit on a summary.
+	pagerDutyMaxSummary = 1024
+)
+
+// PagerDuty severities: a slice behind schedule warns, and one behind a
+// tripped breaker or an empty balance is critical.
+const (
+	pdWarning  = "warning"
+	pdCritical = "critical"
+)
+
+// pagerDutyEvent is an Events API v2 body.
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	Timestamp     time.Time              `json:"timestamp"`
+	Component     string                 `json:"component,omitempty"`
+	Group         string                 `json:"group"`
+	Class         string                 `json:"class"`
+	CustomDetails map[string]interface{} `json:"custom_details,omitempty"`
+}
+
+// pagerDutyNotifier opens an incident per contract and slice on
+// behind_schedule, escalates it on a tripped breaker or an empty balance,
+// and resolves it when the slice fills or the order ends.
+type pagerDutyNotifier struct {
+	cfg    PagerDutyConfig
+	format *notifyFormatter
+	poster jsonPoster
+	apiURL string
+	q      *notifyQueue
+	ctx    context.Context
+
+	// The worker's: the open incidents, and the contracts whose next one is
+	// critical.
+	open     map[string]notification
+	critical map[common.Address]bool
+}
+
+func newPagerDutyNotifier(cfg PagerDutyConfig, f *notifyFormatter) *pagerDutyNotifier {
+	return &pagerDutyNotifier{
+		cfg: cfg, format: f,
+		poster:   newJSONPoster("pagerduty", pagerDutyAttempts),
+		apiURL:   pagerDutyAPI,
+		q:        newNotifyQueue("pagerduty"),
+		open:     map[string]notification{},
+		critical: map[common.Address]bool{},
+	}
+}
+
+func (p *pagerDutyNotifier) start(ctx context.Context) {
+	p.ctx = ctx
+	p.q.start(ctx, p.handle)
+	logf(ctx, "paging PagerDuty when a slice stays unexecuted")
+}
+
+// notify takes what opens, escalates or resolves an incident.
+func (p *pagerDutyNotifier) notify(n notification) {
+	switch n.kind {
+	case notifyBehindSchedule, evBreakerTripped, notifyBalanceEmpty, notifySliceExecuted, evOrderEnded:
+		p.q.push(n)
+	}
+}
+
+func (p *pagerDutyNotifier) close() { p.q.close() }
+
+func (p *pagerDutyNotifier) handle(ctx context.Context, n notification) {
+	switch n.kind {
+	case notifyBehindSchedule:
+		sev := pdWarning
+		if p.critical[n.contract] {
+			sev = pdCritical
+		}
+		key := p.dedupKey(n)
+		p.open[key] = n
+		p.trigger(ctx, key, n, sev)
+	case evBreakerTripped, notifyBalanceEmpty:
+		if p.critical[n.contract] {
+			return
+		}
+		p.critical[n.contract] = true
+		// Re-triggering an open incident raises its severity.
+		for key, opened := range p.open {
+			if opened.contract == n.contract {
+				p.trigger(ctx, key, opened, pdCritical)
+			}
+		}
+	case notifySliceExecuted:
+		delete(p.critical, n.contract)
+		if key := p.dedupKey(n); p.open[key].kind != "" {
+			p.resolve(ctx, key)
+		}
+	case evOrderEnded:
+		delete(p.critical, n.contract)
+		for key, opened := range p.open {
+			if opened.contract == n.contract {
+				p.resolve(ctx, key)
+			}
+		}
+	}
+}
+
+// dedupKey names the incident for n's contract and slice.
+func (p *pagerDutyNotifier) dedupKey(n notification) string {
+	slice, _ := n.data["slice"].(int64)
+	return fmt.Sprintf("twap-agent/%d/%s/%d", n.chainID, n.contract.Hex(), slice)
+}
+
+func (p *pagerDutyNotifier) trigger(ctx context.Context, key string, n notification, sev string) {
+	m := p.format.message(ctx, n)
+	summary := m.Title
+	if m.Text != "" {
+		summary += ": " + m.Text
+	}
+	if len(summary) > pagerDutyMaxSummary {
+		summary = summary[:pagerDutyMaxSummary]
+	}
+	source, component := "twap-agent", ""
+	if n.contract != (common.Address{}) {
+		source, component = n.contract.Hex(), n.contract.Hex()
+	}
+	details := map[string]interface{}{"chainId": n.chainID, "block": n.block}
+	for k, v := range n.data {
+		details[k] = v
+	}
+	p.send(ctx, key, pagerDutyEvent{
+		RoutingKey: p.cfg.RoutingKey, EventAction: "trigger", DedupKey: key,
+		Payload: &pagerDutyPayload{
+			Summary: summary, Source: source, Severity: sev, Timestamp: n.time,
+			Component: component, Group: "twap-agent", Class: n.kind, CustomDetails: details,
+		},
+	})
+}
+
+func (p *pagerDutyNotifier) resolve(ctx context.Context, key string) {
+	delete(p.open, key)
+	p.send(ctx, key, pagerDutyEvent{RoutingKey: p.cfg.RoutingKey, EventAction: "resolve", DedupKey: key})
+}
+
+func (p *pagerDutyNotifier) send(ctx context.Context, key string, ev pagerDutyEvent) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		warnf(p.ctx, "pagerduty %s: %v", key, err)
+		return
+	}
+	p.poster.deliver(ctx, p.ctx, p.apiURL, ev.EventAction+" "+key, body, nil)
+}
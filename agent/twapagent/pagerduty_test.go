@@ -0,0 +1,79 @@
+package twapagent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pagerDutySink is the Events API, keeping each event as
+// "action dedup_key severity".
+type pagerDutySink struct {
+	mu  sync.Mutex
+	got []string
+}
+
+func (s *pagerDutySink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var ev pagerDutyEvent
+	if err := json.NewDecoder(r.Body).Decode(&ev); err != nil || ev.RoutingKey != "KEY" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	line := ev.EventAction + " " + ev.DedupKey
+	if ev.Payload != nil {
+		line += " " + ev.Payload.Severity
+	}
+	s.mu.Lock()
+	s.got = append(s.got, line)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// An incident opens warning, escalates to critical with the breaker and
+// resolves with its slice's fill, while the next one resolves with the
+// order's end.
+func TestPagerDutyIncidents(t *testing.T) {
+	sink := &pagerDutySink{}
+	srv := httptest.NewServer(sink)
+	defer srv.Close()
+	p := newPagerDutyNotifier(PagerDutyConfig{RoutingKey: "KEY"}, &notifyFormatter{chainID: 1, tokens: newTokenCache(nil)})
+	p.apiURL = srv.URL
+	h := testHub(1, p, testVault(tgVault, Strategy{}, nil))
+	h.pageAfter = time.Minute
+	t0 := time.Unix(1700000000, 0)
+	decision := func(after time.Duration, slice int64) eventRecord {
+		return eventRecord{Type: evDecision, Time: t0.Add(after), Data: map[string]interface{}{"slice": slice, "action": "blocked", "reason": "reverted"}}
+	}
+	for _, r := range []eventRecord{
+		decision(0, 2), decision(2*time.Minute, 2),
+		{Type: evBreakerTripped, Data: map[string]interface{}{"slice": int64(2), "consecutiveFailures": 5}},
+		{Type: evFill, Data: map[string]interface{}{"slice": int64(2), "amountIn": "1", "amountOut": "1"}},
+		decision(3*time.Minute, 3), decision(5*time.Minute, 3),
+		{Type: evOrderEnded, Data: map[string]interface{}{"outcome": "expired"}},
+	} {
+		h.tap(r)
+	}
+	h.close()
+
+	key := "twap-agent/1/" + tgVault.Hex() + "/"
+	want := []string{
+		"trigger " + key + "2 warning",
+		"trigger " + key + "2 critical",
+		"resolve " + key + "2",
+		"trigger " + key + "3 warning",
+		"resolve " + key + "3",
+	}
+	if strings.Join(sink.got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("sent\n%q\nwant\n%q", sink.got, want)
+	}
+}
+
+func TestPagerDutyNeedsPageAfter(t *testing.T) {
+	if err := (NotifyConfig{PagerDuty: PagerDutyConfig{RoutingKey: "KEY"}}).validate(); err == nil {
+		t.Error("a routing key with --page-after 0: want an error")
+	}
+}
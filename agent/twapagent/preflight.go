@@ -0,0 +1,450 @@
+package twapagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// PreflightReport is what preflight mode found, as printed with
+// --output json. Field names are stable; uint256 values are decimal strings
+// and times unix seconds.
+type PreflightReport struct {
+	ChainID     uint64 `json:"chainId"`
+	BlockNumber uint64 `json:"blockNumber"`
+	BlockTime   uint64 `json:"blockTime"`
+	// False until the owner calls configureStrategy; the order fields are
+	// then left out.
+	Initialized bool `json:"initialized"`
+
+	Strategy       *preflightStrategy `json:"strategy,omitempty"`
+	FilledAmountIn string             `json:"filledAmountIn,omitempty"`
+	// Name and number of status(); omitted if it couldn't be read.
+	Status     string `json:"status,omitempty"`
+	StatusCode *uint8 `json:"statusCode,omitempty"`
+	// filledAmountIn / totalAmountIn, in percent.
+	ProgressPercent float64 `json:"progressPercent"`
+	TotalSlices     int64   `json:"totalSlices"`
+	IntervalSeconds string  `json:"intervalSeconds,omitempty"`
+	// First slice not yet done, when the scan found one, and when it is due.
+	NextOpenSlice       *int64 `json:"nextOpenSlice"`
+	NextOpenScheduledAt string `json:"nextOpenScheduledAt,omitempty"`
+	// NextOpenSlice if it is due now.
+	NextEligibleSlice *int64 `json:"nextEligibleSlice"`
+	SlicesChecked     int64  `json:"slicesChecked"`
+	// The scan stopped at --max-scan-slices before finding an open slice.
+	ScanLimited bool `json:"scanLimited"`
+	// When the last slice comes due: the earliest the order can complete.
+	EstimatedCompletionTime string `json:"estimatedCompletionTime,omitempty"`
+	// Slices, time elapsed and the ETA, as the bot logs them.
+	Progress *orderProgress `json:"progress,omitempty"`
+
+	Funding *preflightFunding `json:"funding,omitempty"`
+	Oracle  *preflightOracle  `json:"oracle,omitempty"`
+	// sliceAmountIn in dollars, with a USD feed for tokenIn.
+	SliceAmountInUSD *usdAmount `json:"sliceAmountInUsd,omitempty"`
+	// The --adapter-kind venue's amountOut for the next slice's amountIn;
+	// omitted without a quote.
+	QuotedAmountOut string `json:"quotedAmountOut,omitempty"`
+	QuoteError      string `json:"quoteError,omitempty"`
+
+	Pricing *preflightPricing `json:"pricing,omitempty"`
+	// What executing NextEligibleSlice costs now; omitted when none is due.
+	Estimate *preflightEstimate `json:"estimate,omitempty"`
+	Agent    *preflightAgent    `json:"agent,omitempty"`
+
+	quote   gasQuote
+	status  Status
+	amounts orderAmounts
+}
+
+type preflightStrategy struct {
+	TokenIn              checksumAddress `json:"tokenIn"`
+	TokenOut             checksumAddress `json:"tokenOut"`
+	Adapter              checksumAddress `json:"adapter"`
+	PriceOracle          checksumAddress `json:"priceOracle"`
+	TotalAmountIn        string          `json:"totalAmountIn"`
+	SliceAmountIn        string          `json:"sliceAmountIn"`
+	StartTime            string          `json:"startTime"`
+	EndTime              string          `json:"endTime"`
+	MaxSlippageBps       uint16          `json:"maxSlippageBps"`
+	MaxPriceDeviationBps uint16          `json:"maxPriceDeviationBps"`
+}
+
+func newPreflightStrategy(s Strategy) *preflightStrategy {
+	return &preflightStrategy{
+		TokenIn: checksumAddress(s.TokenIn), TokenOut: checksumAddress(s.TokenOut), Adapter: checksumAddress(s.Adapter), PriceOracle: checksumAddress(s.PriceOracle),
+		TotalAmountIn: bigString(s.TotalAmountIn), SliceAmountIn: bigString(s.SliceAmountIn),
+		StartTime: bigString(s.StartTime), EndTime: bigString(s.EndTime),
+		MaxSlippageBps: s.MaxSlippageBps, MaxPriceDeviationBps: s.MaxPriceDeviationBps,
+	}
+}
+
+// preflightFunding is the vault's tokenIn against what the order has left to
+// swap; omitted if balanceOf couldn't be read.
+type preflightFunding struct {
+	VaultBalance string `json:"vaultBalance"`
+	Remaining    string `json:"remaining"`
+	Shortfall    string `json:"shortfall"`
+	Funded       bool   `json:"funded"`
+}
+
+// preflightOracle is executeSlice's price deviation check as of now;
+// omitted if the oracle couldn't be read.
+type preflightOracle struct {
+	Price           string `json:"price"`
+	ReferencePrice  string `json:"referencePrice"`
+	DeviationBps    string `json:"deviationBps"`
+	MaxDeviationBps uint16 `json:"maxPriceDeviationBps"`
+	WithinMax       bool   `json:"withinMax"`
+}
+
+// preflightPricing holds wei per gas; the fields that don't apply to Mode
+// are omitted.
+type preflightPricing struct {
+	Mode     string `json:"mode"`
+	TxType   string `json:"txType"`
+	GasPrice string `json:"gasPrice,omitempty"`
+	BaseFee  string `json:"baseFee,omitempty"`
+	TipCap   string `json:"maxPriorityFeePerGas,omitempty"`
+	FeeCap   string `json:"maxFeePerGas,omitempty"`
+	// Set with --max-gas-price or --max-fee-per-gas.
+	Ceiling      string `json:"ceiling,omitempty"`
+	CeilingPrice string `json:"ceilingComparedPrice,omitempty"`
+	AboveCeiling bool   `json:"aboveCeiling"`
+}
+
+// preflightEstimate is eth_estimateGas for the due slice, priced. Revert is
+// set instead of the gas figures when the estimate fails.
+type preflightEstimate struct {
+	Slice    int64           `json:"slice"`
+	From     checksumAddress `json:"from"`
+	GasUnits uint64          `json:"gasUnits,omitempty"`
+	// At the effective price (baseFee + tip under EIP-1559), and at
+	// maxFeePerGas, the most the tx can pay.
+	CostWei    string `json:"costWei,omitempty"`
+	MaxCostWei string `json:"maxCostWei,omitempty"`
+	// CostWei in dollars, with --eth-usd-feed or --feed-registry.
+	CostUSD      string `json:"costUsd,omitempty"`
+	CostUSDStale bool   `json:"costUsdStale,omitempty"`
+	Revert       string `json:"revert,omitempty"`
+}
+
+type preflightAgent struct {
+	Address         checksumAddress `json:"address"`
+	BalanceWei      string          `json:"balanceWei"`
+	BelowMinBalance bool            `json:"belowMinBalance"`
+	GasPerSlice     uint64          `json:"gasPerSlice,omitempty"`
+	GasSource       string          `json:"gasSource,omitempty"`
+	// How many slices the balance pays for at current prices; omitted
+	// without a gas figure.
+	SlicesCovered string `json:"slicesCovered,omitempty"`
+}
+
+// checksumAddress marshals in its EIP-55 form; common.Address marshals to
+// lowercase hex.
+type checksumAddress common.Address
+
+func (a checksumAddress) Hex() string { return common.Address(a).Hex() }
+
+func (a checksumAddress) MarshalText() ([]byte, error) { return []byte(a.Hex()), nil }
+
+func bigString(v *big.Int) string {
+	if v == nil {
+		return ""
+	}
+	return v.String()
+}
+
+// buildPreflight reads everything preflight mode reports about addr. The gas
+// estimate is made for --from, or the contract's agent without it.
+func (a *Agent) buildPreflight(ctx context.Context, addr common.Address) (*PreflightReport, error) {
+	s, err := readStrategy(ctx, addr, a.cABI, a.client)
+	if err != nil {
+		return nil, fmt.Errorf("read strategy: %w", err)
+	}
+	filled, err := readFilled(ctx, addr, a.cABI, a.client)
+	if err != nil {
+		return nil, fmt.Errorf("read filled: %w", err)
+	}
+	totalSlices, err := readTotalSlices(ctx, addr, a.cABI, a.client)
+	if err != nil {
+		return nil, fmt.Errorf("read totalSlices: %w", err)
+	}
+	header, err := headerByNumber(ctx, a.client, nil)
+	if err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	now := new(big.Int).SetUint64(header.Time)
+	r := &PreflightReport{ChainID: a.chainID, BlockNumber: header.Number.Uint64(), BlockTime: header.Time}
+
+	n, err := sliceCount(totalSlices)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return r, nil
+	}
+	r.Initialized = true
+	r.TotalSlices = n
+	r.Strategy = newPreflightStrategy(s)
+	r.amounts = orderAmountsFor(ctx, s)
+	if p := usdPricerFrom(ctx); p != nil {
+		r.SliceAmountInUSD = p.tokenUSD(ctx, s.TokenIn, s.SliceAmountIn)
+	}
+	r.FilledAmountIn = filled.String()
+	r.ProgressPercent = percentOf(filled, s.TotalAmountIn)
+	blockTime, err := estimateBlockTime(ctx, a.client, header, blockTimeSpan)
+	if err != nil {
+		logf(ctx, "block time: %v", err)
+	}
+	progress := newOrderProgress(s, n, filled, header.Time, blockTime, a.cfg.Tx.Catchup)
+	r.Progress = &progress
+	if status, err := readStatus(ctx, addr, a.cABI, a.client); err == nil {
+		code := uint8(status)
+		r.status, r.Status, r.StatusCode = status, status.String(), &code
+	} else {
+		logf(ctx, "read status: %v", err)
+	}
+	if first, err := sliceScheduledAt(s, n, 1); err == nil {
+		r.IntervalSeconds = new(big.Int).Sub(first, s.StartTime).String()
+	}
+	if last, err := sliceScheduledAt(s, n, n-1); err == nil {
+		r.EstimatedCompletionTime = last.String()
+	}
+
+	if f, err := readVaultFunding(ctx, addr, a.client, s, filled); err == nil {
+		r.Funding = &preflightFunding{VaultBalance: f.Balance.String(), Remaining: f.Remaining.String(), Shortfall: f.Shortfall.String(), Funded: f.Shortfall.Sign() == 0}
+	} else {
+		logf(ctx, "vault tokenIn balance: %v", err)
+	}
+
+	amountIn := new(big.Int).Sub(s.TotalAmountIn, filled)
+	if amountIn.Cmp(s.SliceAmountIn) > 0 {
+		amountIn.Set(s.SliceAmountIn)
+	}
+	if c, err := readPriceCheck(ctx, addr, a.cABI, a.client, a.cfg.Tx.Oracle, s, amountIn); err == nil {
+		r.Oracle = &preflightOracle{Price: c.Price.String(), ReferencePrice: c.Reference.String(), DeviationBps: c.DeviationBps.String(), MaxDeviationBps: c.MaxDeviation, WithinMax: c.DeviationOK()}
+	} else {
+		logf(ctx, "price check: %v", err)
+	}
+	if out, err := a.cfg.Tx.Quoter.quote(ctx, a.client, s, amountIn); err == nil {
+		r.QuotedAmountOut = out.String()
+	} else {
+		r.QuoteError = err.Error()
+	}
+
+	// Later slices are scheduled later, so only the first open one can be due
+	scan, err := scanFirstUndone(ctx, addr, a.cABI, a.client, n, a.cfg.Tx.MaxScanSlices)
+	if err != nil {
+		return nil, err
+	}
+	r.SlicesChecked, r.ScanLimited = scan.Checked, scan.Limited
+	next := int64(-1)
+	if scan.First >= 0 {
+		scheduled, err := sliceScheduledAt(s, n, scan.First)
+		if err != nil {
+			return nil, err
+		}
+		first := scan.First
+		r.NextOpenSlice, r.NextOpenScheduledAt = &first, scheduled.String()
+		if now.Cmp(scheduled) >= 0 {
+			next = first
+			r.NextEligibleSlice = &first
+		}
+	}
+
+	quote, err := quoteGas(ctx, a.client, a.cfg.Tx)
+	if err != nil {
+		return nil, fmt.Errorf("pricing: %w", err)
+	}
+	r.quote = quote
+	r.Pricing = &preflightPricing{Mode: quote.Mode, TxType: a.cfg.Tx.TxType, GasPrice: bigString(quote.GasPrice)}
+	if quote.Mode == txTypeDynamic {
+		r.Pricing.BaseFee, r.Pricing.TipCap, r.Pricing.FeeCap = bigString(quote.BaseFee), bigString(quote.TipCap), bigString(quote.FeeCap)
+	}
+	if price, ceiling, over := a.cfg.Tx.checkCeiling(quote); ceiling != nil {
+		r.Pricing.Ceiling, r.Pricing.CeilingPrice, r.Pricing.AboveCeiling = ceiling.String(), bigString(price), over
+	}
+
+	// Agent funding: balance and how many slices it pays for at current prices
+	outs, err := callView(ctx, addr, a.cABI, a.client, "agent")
+	if err != nil {
+		return nil, fmt.Errorf("read agent: %w", err)
+	}
+	agent := outs[0].(common.Address)
+	var bal *big.Int
+	err = rpcRead(ctx, "eth_getBalance", func(ctx context.Context) (err error) {
+		bal, err = a.client.BalanceAt(ctx, agent, nil)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("agent balance: %w", err)
+	}
+	if next >= 0 {
+		from := a.from()
+		if from == (common.Address{}) {
+			from = agent
+		}
+		r.Estimate = estimateSlice(ctx, addr, a.cABI, a.client, a.cfg.Tx, quote, from, next)
+	}
+	r.Agent = &preflightAgent{Address: checksumAddress(agent), BalanceWei: bal.String(), BelowMinBalance: a.cfg.Balance.MinWei != nil && bal.Cmp(a.cfg.Balance.MinWei) < 0}
+	gas, source := gasPerSlice(ctx, addr, a.cABI, a.client, a.cfg.Tx, a.cfg.ReceiptsFile, agent, next)
+	r.Agent.GasPerSlice, r.Agent.GasSource = gas, source
+	if covered := slicesCovered(bal, gas, quote.effectivePrice()); covered != nil {
+		r.Agent.SlicesCovered = covered.String()
+	}
+	return r, nil
+}
+
+// estimateSlice runs eth_estimateGas for executeSlice(sliceId) from from and
+// prices the result with quote.
+func estimateSlice(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, txCfg TxConfig, quote gasQuote, from common.Address, sliceId int64) *preflightEstimate {
+	e := &preflightEstimate{Slice: sliceId, From: checksumAddress(from)}
+	data, err := cABI.Pack("executeSlice", big.NewInt(sliceId))
+	if err != nil {
+		e.Revert = err.Error()
+		return e
+	}
+	gas, err := estimateGas(ctx, client, ethereum.CallMsg{From: from, To: &addr, Data: data})
+	if err != nil {
+		if reason, ok := describeRevert(cABI, err); ok {
+			e.Revert = reason
+		} else {
+			e.Revert = err.Error()
+		}
+		return e
+	}
+	e.GasUnits = gas
+	units := new(big.Int).SetUint64(gas)
+	if p := quote.effectivePrice(); p != nil {
+		cost := new(big.Int).Mul(units, p)
+		e.CostWei = cost.String()
+		if p := usdPricerFrom(ctx); p != nil {
+			if v := p.weiUSD(ctx, cost); v != nil {
+				e.CostUSD, e.CostUSDStale = v.USD, v.Stale
+			}
+		}
+	}
+	if quote.Mode == txTypeDynamic && quote.FeeCap != nil {
+		e.MaxCostWei = new(big.Int).Mul(units, quote.FeeCap).String()
+	}
+	return e
+}
+
+// writeText prints the report the way preflight always has.
+func (r *PreflightReport) writeText(w io.Writer, balCfg BalanceConfig) {
+	fmt.Fprintf(w, "Preflight:\n")
+	fmt.Fprintf(w, "- chainId: %d\n", r.ChainID)
+	fmt.Fprintf(w, "- blockTime: %d (%s)\n", r.BlockTime, time.Unix(int64(r.BlockTime), 0).UTC().Format(time.RFC3339))
+	if !r.Initialized {
+		fmt.Fprintf(w, "- order: not initialized (totalSlices is 0; the owner has not called configureStrategy)\n")
+		return
+	}
+	in := func(v string) string { return r.amounts.In(parseDecimal(v)) }
+	fmt.Fprintf(w, "- totalAmountIn: %s\n", in(r.Strategy.TotalAmountIn))
+	if u := r.SliceAmountInUSD; u != nil {
+		fmt.Fprintf(w, "- sliceAmountIn: %s (%s)\n", in(r.Strategy.SliceAmountIn), u)
+	} else {
+		fmt.Fprintf(w, "- sliceAmountIn: %s\n", in(r.Strategy.SliceAmountIn))
+	}
+	fmt.Fprintf(w, "- window: %s -> %s\n", r.Strategy.StartTime, r.Strategy.EndTime)
+	fmt.Fprintf(w, "- filledAmountIn: %s\n", in(r.FilledAmountIn))
+	if r.StatusCode != nil {
+		fmt.Fprintf(w, "- status: %s\n", r.status.describe())
+	}
+	fmt.Fprintf(w, "- totalSlices: %d\n", r.TotalSlices)
+	if p := r.Progress; p != nil {
+		fmt.Fprintf(w, "- progress: %.2f%% filled, %d/%d slices, %ds of %ds elapsed (%.2f%%)\n", p.FilledPercent, p.SlicesDone, p.TotalSlices, p.ElapsedSeconds, p.WindowSeconds, p.ElapsedPercent)
+		if p.ETA > 0 {
+			fmt.Fprintf(w, "- eta: %d (%s)\n", p.ETA, time.Unix(int64(p.ETA), 0).UTC().Format(time.RFC3339))
+		} else {
+			fmt.Fprintf(w, "- eta: none, every slice is done\n")
+		}
+	}
+	switch {
+	case r.NextEligibleSlice != nil:
+		fmt.Fprintf(w, "- nextEligibleSlice: %d\n", *r.NextEligibleSlice)
+	case r.ScanLimited:
+		fmt.Fprintf(w, "- nextEligibleSlice: unknown (scan stopped at --max-scan-slices)\n")
+	default:
+		fmt.Fprintf(w, "- nextEligibleSlice: none (by schedule or all done)\n")
+	}
+	fmt.Fprintf(w, "- slicesChecked: %d of %d\n", r.SlicesChecked, r.TotalSlices)
+	if f := r.Funding; f != nil {
+		verdict := "OK"
+		if !f.Funded {
+			verdict = "SHORTFALL " + in(f.Shortfall)
+		}
+		fmt.Fprintf(w, "- vaultTokenIn: holds %s, order needs %s: %s\n", in(f.VaultBalance), in(f.Remaining), verdict)
+	}
+	if o := r.Oracle; o != nil {
+		verdict := "ok"
+		if !o.WithinMax {
+			verdict = "EXCEEDED, executeSlice reverts with PRICE_DEVIATION"
+		}
+		fmt.Fprintf(w, "- oracle: price %s, reference %s, deviation %s bps, maxPriceDeviationBps %d (%s)\n", o.Price, o.ReferencePrice, o.DeviationBps, o.MaxDeviationBps, verdict)
+	}
+	if r.QuotedAmountOut != "" {
+		fmt.Fprintf(w, "- quote: %s out for the next slice\n", r.amounts.Out(parseDecimal(r.QuotedAmountOut)))
+	} else if r.QuoteError != "" {
+		fmt.Fprintf(w, "- quote: %s\n", r.QuoteError)
+	}
+	fmt.Fprintf(w, "- pricing: %s (tx-type=%s, %s)\n", r.quote.Mode, r.Pricing.TxType, r.quote.fees())
+	if r.Pricing.Ceiling != "" {
+		verdict := "below"
+		if r.Pricing.AboveCeiling {
+			verdict = "ABOVE"
+		}
+		fmt.Fprintf(w, "- gasCeiling: %s wei, current %s wei is %s the ceiling\n", r.Pricing.Ceiling, r.Pricing.CeilingPrice, verdict)
+	}
+	if e := r.Estimate; e != nil {
+		if e.Revert != "" {
+			fmt.Fprintf(w, "- estimate: slice %d from %s would revert: %s\n", e.Slice, e.From.Hex(), e.Revert)
+		} else {
+			line := fmt.Sprintf("- estimate: slice %d from %s: %d gas", e.Slice, e.From.Hex(), e.GasUnits)
+			if cost, ok := new(big.Int).SetString(e.CostWei, 10); ok {
+				line += fmt.Sprintf(", %s wei (%s ETH)", cost, weiToEth(cost))
+			}
+			if e.CostUSD != "" {
+				line += ", " + usdAmount{USD: e.CostUSD, Stale: e.CostUSDStale}.String()
+			}
+			if max, ok := new(big.Int).SetString(e.MaxCostWei, 10); ok {
+				line += fmt.Sprintf(", at most %s ETH at maxFeePerGas", weiToEth(max))
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+	bal, _ := new(big.Int).SetString(r.Agent.BalanceWei, 10)
+	fmt.Fprintf(w, "- agentBalance: %s wei (%s ETH) for %s\n", bal, weiToEth(bal), r.Agent.Address.Hex())
+	if r.Agent.BelowMinBalance {
+		fmt.Fprintf(w, "- WARNING: agent balance is below --min-balance-wei %s\n", balCfg.MinWei)
+	}
+	if r.Agent.SlicesCovered != "" {
+		fmt.Fprintf(w, "- balanceCovers: ~%s slices at %d gas each (%s)\n", r.Agent.SlicesCovered, r.Agent.GasPerSlice, r.Agent.GasSource)
+	} else {
+		fmt.Fprintf(w, "- balanceCovers: unknown (no gas figure yet; set --gas-limit or wait for a due slice)\n")
+	}
+}
+
+func (a *Agent) preflight(ctx context.Context, addr common.Address) error {
+	r, err := a.buildPreflight(ctx, addr)
+	if err != nil {
+		return err
+	}
+	if a.cfg.Format == formatJSON {
+		enc := json.NewEncoder(outputFrom(ctx))
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	r.writeText(outputFrom(ctx), a.cfg.Balance)
+	return nil
+}
@@ -0,0 +1,86 @@
+package twapagent
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func testPreflightReport() *PreflightReport {
+	next, code := int64(3), uint8(StatusPartialFilled)
+	return &PreflightReport{
+		ChainID: 31337, BlockNumber: 100, BlockTime: 2000, Initialized: true,
+		Strategy: &preflightStrategy{
+			TokenIn: checksumAddress(common.HexToAddress("0x00000000000000000000000000000000000000a1")), TotalAmountIn: "1000", SliceAmountIn: "10",
+			StartTime: "1000", EndTime: "3000", MaxSlippageBps: 50, MaxPriceDeviationBps: 100,
+		},
+		FilledAmountIn: "30", Status: "PartialFilled", StatusCode: &code, ProgressPercent: 3,
+		TotalSlices: 100, IntervalSeconds: "20", NextOpenSlice: &next, NextOpenScheduledAt: "1060", NextEligibleSlice: &next,
+		SlicesChecked: 1, EstimatedCompletionTime: "2980",
+		Progress: &orderProgress{FilledPercent: 3, SlicesDone: 3, TotalSlices: 100, ElapsedSeconds: 1000, WindowSeconds: 2000, ElapsedPercent: 50, ETA: 2980},
+		Funding:  &preflightFunding{VaultBalance: "900", Remaining: "970", Shortfall: "70"},
+		Oracle:   &preflightOracle{Price: "1030", ReferencePrice: "1000", DeviationBps: "300", MaxDeviationBps: 100},
+		Pricing:  &preflightPricing{Mode: txTypeLegacy, TxType: "auto", GasPrice: "7"},
+		Estimate: &preflightEstimate{Slice: 3, From: checksumAddress{0xa9}, GasUnits: 1000, CostWei: "7000", CostUSD: "0.01"},
+		Agent:    &preflightAgent{Address: checksumAddress{0xa9}, BalanceWei: "1000000", GasPerSlice: 1000, GasSource: "--gas-limit", SlicesCovered: "142"},
+		quote:    gasQuote{Mode: txTypeLegacy, GasPrice: big.NewInt(7)},
+		status:   StatusPartialFilled,
+	}
+}
+
+func TestPreflightJSONFields(t *testing.T) {
+	data, err := json.Marshal(testPreflightReport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"chainId", "blockTime", "strategy", "filledAmountIn", "status", "statusCode", "progressPercent",
+		"totalSlices", "nextOpenSlice", "nextOpenScheduledAt", "nextEligibleSlice", "estimatedCompletionTime", "progress", "funding", "oracle", "pricing", "estimate", "agent"} {
+		if _, ok := got[key]; !ok {
+			t.Errorf("missing %q in %s", key, data)
+		}
+	}
+	strategy := got["strategy"].(map[string]interface{})
+	if strategy["tokenIn"] != common.HexToAddress("0xa1").Hex() || strategy["totalAmountIn"] != "1000" {
+		t.Errorf("strategy %v: want a checksummed address and decimal string amounts", strategy)
+	}
+
+	// Before configureStrategy only the chain fields are there.
+	data, err = json.Marshal(&PreflightReport{ChainID: 1, BlockTime: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(data); !strings.Contains(s, `"initialized":false`) || strings.Contains(s, "strategy") || !strings.Contains(s, `"nextEligibleSlice":null`) {
+		t.Errorf("uninitialized report: %s", s)
+	}
+}
+
+func TestPreflightText(t *testing.T) {
+	var buf bytes.Buffer
+	testPreflightReport().writeText(&buf, BalanceConfig{})
+	for _, want := range []string{
+		"- blockTime: 2000 (1970-01-01T00:33:20Z)\n",
+		"- window: 1000 -> 3000\n",
+		"- status: PartialFilled (1)\n",
+		"- nextEligibleSlice: 3\n",
+		"- slicesChecked: 1 of 100\n",
+		"- progress: 3.00% filled, 3/100 slices, 1000s of 2000s elapsed (50.00%)\n",
+		"- eta: 2980 (1970-01-01T00:49:40Z)\n",
+		"- vaultTokenIn: holds 900, order needs 970: SHORTFALL 70\n",
+		"- oracle: price 1030, reference 1000, deviation 300 bps, maxPriceDeviationBps 100 (EXCEEDED, executeSlice reverts with PRICE_DEVIATION)\n",
+		"- pricing: legacy (tx-type=auto, gasPrice=7 wei)\n",
+		"- estimate: slice 3 from " + common.Address{0xa9}.Hex() + ": 1000 gas, 7000 wei (0.000000 ETH), $0.01\n",
+		"- balanceCovers: ~142 slices at 1000 gas each (--gas-limit)\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("text lacks %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package twapagent
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// orderProgress is how far along the order is, by amount, slice and time.
+type orderProgress struct {
+	// filledAmountIn / totalAmountIn, in percent; 0 for a zero total.
+	FilledPercent float64 `json:"filledPercent"`
+	SlicesDone    int64   `json:"slicesDone"`
+	TotalSlices   int64   `json:"totalSlices"`
+	// Time since startTime, capped at the window once it has passed.
+	ElapsedSeconds uint64  `json:"elapsedSeconds"`
+	WindowSeconds  uint64  `json:"windowSeconds"`
+	ElapsedPercent float64 `json:"elapsedPercent"`
+	// When the last remaining slice is expected to execute, unix seconds;
+	// omitted once every slice is done.
+	ETA uint64 `json:"eta,omitempty"`
+}
+
+// percentOf is part/whole in percent, truncated to two decimals; 0 when
+// whole isn't positive.
+func percentOf(part, whole *big.Int) float64 {
+	if part == nil || whole == nil || whole.Sign() <= 0 {
+		return 0
+	}
+	pct, _ := new(big.Rat).SetFrac(new(big.Int).Mul(part, big.NewInt(10_000)), whole).Float64()
+	return float64(int64(pct)) / 100
+}
+
+// newOrderProgress works out the order's progress at block time now. Slices
+// done are counted from filled, as each slice fills sliceAmountIn. The ETA
+// is when the last slice comes due, or later when the remaining slices can't
+// all go by then: one per block of blockTime seconds, or all in the next
+// block with catchup. blockTime 0 means unknown.
+func newOrderProgress(s Strategy, n int64, filled *big.Int, now, blockTime uint64, catchup bool) orderProgress {
+	p := orderProgress{
+		FilledPercent: percentOf(filled, s.TotalAmountIn),
+		SlicesDone:    expectedFirstUndone(s, filled, n),
+		TotalSlices:   n,
+	}
+	if s.StartTime == nil || s.EndTime == nil || !s.StartTime.IsUint64() || !s.EndTime.IsUint64() {
+		return p
+	}
+	start, end := s.StartTime.Uint64(), s.EndTime.Uint64()
+	if end > start {
+		p.WindowSeconds = end - start
+	}
+	if now > start {
+		p.ElapsedSeconds = now - start
+		if p.ElapsedSeconds > p.WindowSeconds {
+			p.ElapsedSeconds = p.WindowSeconds
+		}
+	}
+	switch {
+	case p.WindowSeconds > 0:
+		p.ElapsedPercent = float64(p.ElapsedSeconds*10_000/p.WindowSeconds) / 100
+	case now >= start:
+		p.ElapsedPercent = 100 // an empty window is over as soon as it starts
+	}
+
+	remaining := n - p.SlicesDone
+	if remaining <= 0 {
+		return p
+	}
+	eta := end
+	if last, err := sliceScheduledAt(s, n, n-1); err == nil && last.IsUint64() {
+		eta = last.Uint64()
+	}
+	blocks := uint64(remaining)
+	if catchup {
+		blocks = 1
+	}
+	if earliest := now + blocks*blockTime; earliest > eta {
+		eta = earliest
+	}
+	p.ETA = eta
+	return p
+}
+
+// String is the progress line the bot logs.
+func (p orderProgress) String() string {
+	eta := "complete"
+	if p.ETA > 0 {
+		eta = "ETA " + time.Unix(int64(p.ETA), 0).UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprintf("Progress: %.2f%% filled, slices %d/%d, elapsed %ds/%ds (%.2f%%), %s",
+		p.FilledPercent, p.SlicesDone, p.TotalSlices, p.ElapsedSeconds, p.WindowSeconds, p.ElapsedPercent, eta)
+}
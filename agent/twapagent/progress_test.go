@@ -0,0 +1,66 @@
+package twapagent
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func progressStrategy(total, slice, start, end int64) Strategy {
+	return Strategy{
+		TotalAmountIn: big.NewInt(total), SliceAmountIn: big.NewInt(slice),
+		StartTime: big.NewInt(start), EndTime: big.NewInt(end),
+	}
+}
+
+func TestOrderProgressMidWindow(t *testing.T) {
+	// 10 slices over 1000s: slice 9 is due at 1900.
+	s := progressStrategy(1000, 100, 1000, 2000)
+	p := newOrderProgress(s, 10, big.NewInt(300), 1500, 12, false)
+	if p.FilledPercent != 30 || p.SlicesDone != 3 || p.TotalSlices != 10 {
+		t.Errorf("amounts: %+v", p)
+	}
+	if p.ElapsedSeconds != 500 || p.WindowSeconds != 1000 || p.ElapsedPercent != 50 {
+		t.Errorf("time: %+v", p)
+	}
+	if p.ETA != 1900 {
+		t.Errorf("ETA = %d, want 1900 (the last slice's schedule)", p.ETA)
+	}
+}
+
+func TestOrderProgressOverdue(t *testing.T) {
+	// Past the window with 7 slices left: one per 12s block, or all at once
+	// with catch-up.
+	s := progressStrategy(1000, 100, 1000, 2000)
+	p := newOrderProgress(s, 10, big.NewInt(300), 2500, 12, false)
+	if p.ElapsedSeconds != 1000 || p.ElapsedPercent != 100 {
+		t.Errorf("elapsed should stop at the window: %+v", p)
+	}
+	if p.ETA != 2500+7*12 {
+		t.Errorf("ETA = %d, want %d", p.ETA, 2500+7*12)
+	}
+	if p := newOrderProgress(s, 10, big.NewInt(300), 2500, 12, true); p.ETA != 2512 {
+		t.Errorf("catch-up ETA = %d, want 2512", p.ETA)
+	}
+}
+
+func TestOrderProgressDegenerate(t *testing.T) {
+	// Zero total and an empty window must not divide by zero.
+	p := newOrderProgress(progressStrategy(0, 0, 1000, 1000), 1, big.NewInt(0), 1000, 0, false)
+	if p.FilledPercent != 0 || p.WindowSeconds != 0 || p.ElapsedPercent != 100 {
+		t.Errorf("%+v", p)
+	}
+	p = newOrderProgress(progressStrategy(0, 0, 1000, 1000), 1, big.NewInt(0), 999, 0, false)
+	if p.ElapsedPercent != 0 {
+		t.Errorf("before start: %+v", p)
+	}
+
+	// Every slice done: no ETA.
+	p = newOrderProgress(progressStrategy(1000, 100, 1000, 2000), 10, big.NewInt(1000), 2100, 12, false)
+	if p.ETA != 0 || p.SlicesDone != 10 || p.FilledPercent != 100 {
+		t.Errorf("done: %+v", p)
+	}
+	if !strings.HasSuffix(p.String(), "complete") {
+		t.Errorf("String() = %q", p.String())
+	}
+}
@@ -0,0 +1,169 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// Venues --adapter-kind knows how to quote. IDexAdapter itself has no quote
+// function, so the agent asks the venue behind it.
+const (
+	adapterKindUniswapV2 = "uniswap-v2"        // router getAmountsOut
+	adapterKindV3Quoter  = "uniswap-v3-quoter" // QuoterV2 quoteExactInputSingle
+	adapterKindGeneric   = "generic"           // getAmountOut(address,address,uint256)
+)
+
+// errQuoteUnavailable means no --adapter-kind the agent can use was given.
+var errQuoteUnavailable = errors.New("quote unavailable")
+
+const quoteABIJSON = `[
+{"type":"function","name":"getAmountsOut","stateMutability":"view","inputs":[{"name":"amountIn","type":"uint256"},{"name":"path","type":"address[]"}],"outputs":[{"name":"amounts","type":"uint256[]"}]},
+{"type":"function","name":"quoteExactInputSingle","stateMutability":"nonpayable","inputs":[{"name":"params","type":"tuple","components":[{"name":"tokenIn","type":"address"},{"name":"tokenOut","type":"address"},{"name":"amountIn","type":"uint256"},{"name":"fee","type":"uint24"},{"name":"sqrtPriceLimitX96","type":"uint160"}]}],"outputs":[{"name":"amountOut","type":"uint256"},{"name":"sqrtPriceX96After","type":"uint160"},{"name":"initializedTicksCrossed","type":"uint32"},{"name":"gasEstimate","type":"uint256"}]},
+{"type":"function","name":"getAmountOut","stateMutability":"view","inputs":[{"name":"tokenIn","type":"address"},{"name":"tokenOut","type":"address"},{"name":"amountIn","type":"uint256"}],"outputs":[{"name":"amountOut","type":"uint256"}]}
+]`
+
+var quoteABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(quoteABIJSON))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// AdapterQuoter asks a venue what a slice would receive.
+type AdapterQuoter struct {
+	// One of the adapterKind constants; anything else quotes nothing.
+	Kind string
+	// Contract to quote; the strategy's adapter when unset.
+	Address common.Address
+	// Pool fee tier for uniswap-v3-quoter, in hundredths of a bip.
+	Fee uint32
+}
+
+// knownAdapterKind reports whether kind is one the agent can quote.
+func knownAdapterKind(kind string) bool {
+	return kind == adapterKindUniswapV2 || kind == adapterKindV3Quoter || kind == adapterKindGeneric
+}
+
+// quote returns the expected amountOut for amountIn of s's pair.
+func (q AdapterQuoter) quote(ctx context.Context, client *ethclient.Client, s Strategy, amountIn *big.Int) (*big.Int, error) {
+	to := s.Adapter
+	if q.Address != (common.Address{}) {
+		to = q.Address
+	}
+	switch q.Kind {
+	case adapterKindUniswapV2:
+		outs, err := callView(ctx, to, quoteABI, client, "getAmountsOut", amountIn, []common.Address{s.TokenIn, s.TokenOut})
+		if err != nil {
+			return nil, fmt.Errorf("getAmountsOut: %w", err)
+		}
+		amounts := outs[0].([]*big.Int)
+		if len(amounts) != 2 {
+			return nil, fmt.Errorf("getAmountsOut returned %d amounts", len(amounts))
+		}
+		return amounts[1], nil
+	case adapterKindV3Quoter:
+		params := struct {
+			TokenIn           common.Address
+			TokenOut          common.Address
+			AmountIn          *big.Int
+			Fee               *big.Int
+			SqrtPriceLimitX96 *big.Int
+		}{s.TokenIn, s.TokenOut, amountIn, new(big.Int).SetUint64(uint64(q.Fee)), new(big.Int)}
+		outs, err := callView(ctx, to, quoteABI, client, "quoteExactInputSingle", params)
+		if err != nil {
+			return nil, fmt.Errorf("quoteExactInputSingle: %w", err)
+		}
+		return outs[0].(*big.Int), nil
+	case adapterKindGeneric:
+		outs, err := callView(ctx, to, quoteABI, client, "getAmountOut", s.TokenIn, s.TokenOut, amountIn)
+		if err != nil {
+			return nil, fmt.Errorf("getAmountOut: %w", err)
+		}
+		return outs[0].(*big.Int), nil
+	}
+	return nil, errQuoteUnavailable
+}
+
+// realizedSlippageBps is how much less than quoted a fill received, in bps
+// of the quote scaled to the filled input; negative when it got more.
+func realizedSlippageBps(quotedIn, quotedOut, filledIn, received *big.Int) (*big.Int, bool) {
+	if quotedIn.Sign() == 0 || quotedOut.Sign() == 0 || filledIn.Sign() == 0 {
+		return nil, false
+	}
+	expected := new(big.Int).Mul(quotedOut, filledIn)
+	expected.Quo(expected, quotedIn)
+	if expected.Sign() == 0 {
+		return nil, false
+	}
+	bps := new(big.Int).Sub(expected, received)
+	bps.Mul(bps, big.NewInt(10_000)).Quo(bps, expected)
+	return bps, true
+}
+
+// sliceQuote is the quote logged before a slice was submitted.
+type sliceQuote struct {
+	AmountIn, AmountOut *big.Int
+}
+
+// sliceQuotes keeps the last quote per submitted slice until its Fill.
+type sliceQuotes struct {
+	mu sync.Mutex
+	m  map[int64]sliceQuote
+}
+
+func (q *sliceQuotes) Put(id int64, sq sliceQuote) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.m == nil {
+		q.m = map[int64]sliceQuote{}
+	}
+	q.m[id] = sq
+}
+
+// Take returns and forgets the quote for id.
+func (q *sliceQuotes) Take(id int64) (sliceQuote, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	sq, ok := q.m[id]
+	delete(q.m, id)
+	return sq, ok
+}
+
+// sliceStrategy is the strategy as the bot has it cached, or read afresh in
+// once and execute modes, which don't cache it.
+func sliceStrategy(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, st *botState) (Strategy, error) {
+	if st.strategy != nil {
+		s, _ := st.strategy.Get(ctx, time.Now())
+		return s, nil
+	}
+	s, err := readStrategy(ctx, addr, cABI, client)
+	if err != nil {
+		return Strategy{}, fmt.Errorf("read strategy: %w", err)
+	}
+	return s, nil
+}
+
+// logSliceQuote prints the venue's quote for sliceAmountIn before sliceId is
+// submitted and keeps it to compare with the Fill.
+func logSliceQuote(ctx context.Context, client *ethclient.Client, txCfg TxConfig, st *botState, s Strategy, sliceId int64) {
+	if txCfg.Quoter.Kind == "" {
+		return
+	}
+	out, err := txCfg.Quoter.quote(ctx, client, s, s.SliceAmountIn)
+	if err != nil {
+		logf(ctx, "slice %d: %v", sliceId, err)
+		return
+	}
+	logf(ctx, "Slice %d: %s quotes %s out for %s in", sliceId, txCfg.Quoter.Kind, out, s.SliceAmountIn)
+	st.quotes.Put(sliceId, sliceQuote{AmountIn: s.SliceAmountIn, AmountOut: out})
+}
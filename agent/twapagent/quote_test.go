@@ -0,0 +1,90 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// quoteEth answers each quote call with the amountOut it was built with, and
+// records which function was asked.
+type quoteEth struct {
+	out    *big.Int
+	method string
+}
+
+func (f *quoteEth) Call(args fakeCallArgs, _ string) (hexutil.Bytes, error) {
+	m, err := quoteABI.MethodById(args.Data)
+	if err != nil {
+		return nil, err
+	}
+	f.method = m.Name
+	switch m.Name {
+	case "getAmountsOut":
+		return m.Outputs.Pack([]*big.Int{big.NewInt(1), f.out})
+	case "quoteExactInputSingle":
+		return m.Outputs.Pack(f.out, big.NewInt(0), uint32(0), big.NewInt(0))
+	}
+	return m.Outputs.Pack(f.out)
+}
+
+func TestAdapterQuoterKinds(t *testing.T) {
+	s := Strategy{TokenIn: common.Address{1}, TokenOut: common.Address{2}, Adapter: common.Address{3}}
+	for kind, method := range map[string]string{
+		adapterKindUniswapV2: "getAmountsOut",
+		adapterKindV3Quoter:  "quoteExactInputSingle",
+		adapterKindGeneric:   "getAmountOut",
+	} {
+		eth := &quoteEth{out: big.NewInt(990)}
+		q := AdapterQuoter{Kind: kind, Fee: 3000}
+		got, err := q.quote(context.Background(), dialFakeEth(t, eth), s, big.NewInt(1000))
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if got.Int64() != 990 || eth.method != method {
+			t.Errorf("%s: got %s via %s, want 990 via %s", kind, got, eth.method, method)
+		}
+	}
+}
+
+func TestAdapterQuoterUnknownKind(t *testing.T) {
+	for _, kind := range []string{"", "curve"} {
+		_, err := AdapterQuoter{Kind: kind}.quote(context.Background(), nil, Strategy{}, big.NewInt(1))
+		if !errors.Is(err, errQuoteUnavailable) {
+			t.Errorf("%q: got %v, want errQuoteUnavailable", kind, err)
+		}
+	}
+}
+
+func TestRealizedSlippageBps(t *testing.T) {
+	// Quoted 2000 out for 1000 in; a 500 fill should get 1000.
+	bps, ok := realizedSlippageBps(big.NewInt(1000), big.NewInt(2000), big.NewInt(500), big.NewInt(990))
+	if !ok || bps.Int64() != 100 {
+		t.Errorf("got %v, %v; want 100 bps", bps, ok)
+	}
+	bps, _ = realizedSlippageBps(big.NewInt(1000), big.NewInt(2000), big.NewInt(1000), big.NewInt(2010))
+	if bps.Int64() != -50 {
+		t.Errorf("better than quoted: got %s, want -50", bps)
+	}
+	if _, ok := realizedSlippageBps(big.NewInt(1000), big.NewInt(0), big.NewInt(1000), big.NewInt(1)); ok {
+		t.Error("a zero quote has no slippage")
+	}
+}
+
+func TestSliceQuotesTake(t *testing.T) {
+	var q sliceQuotes
+	if _, ok := q.Take(1); ok {
+		t.Fatal("empty store returned a quote")
+	}
+	q.Put(1, sliceQuote{AmountIn: big.NewInt(1), AmountOut: big.NewInt(2)})
+	if sq, ok := q.Take(1); !ok || sq.AmountOut.Int64() != 2 {
+		t.Fatalf("got %+v, %v", sq, ok)
+	}
+	if _, ok := q.Take(1); ok {
+		t.Error("a quote should be taken once")
+	}
+}
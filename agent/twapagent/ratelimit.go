@@ -0,0 +1,240 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// rpcLimiter paces RPC reads with a token bucket (--rpc-rps), bounds each
+// one by --call-timeout, and retries reads the provider rejected as rate
+// limited, with jittered exponential backoff, or that timed out. It travels in
+// the context so every read helper can use it without another parameter; see
+// withRPCLimiter.
+type rpcLimiter struct {
+	rps        float64 // 0 = unlimited
+	burst      float64
+	timeout    time.Duration // per attempt; 0 = none
+	backoff    time.Duration
+	maxBackoff time.Duration
+	attempts   int
+	// Attempts for a read that keeps timing out; a hung node rarely recovers
+	// within one call, and the next block retries anyway.
+	timeoutAttempts int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+
+	// Counters for tuning --rpc-rps.
+	delayed   atomic.Int64 // reads held back by the bucket
+	throttled atomic.Int64 // rate-limit errors from the provider
+	retried   atomic.Int64 // reads sent again after one
+	timedOut  atomic.Int64 // attempts that hit --call-timeout
+
+	logMu      sync.Mutex
+	lastLogged [4]int64
+}
+
+func newRPCLimiter(rps float64, timeout time.Duration) *rpcLimiter {
+	burst := 1.0
+	if rps > 1 {
+		burst = rps
+	}
+	return &rpcLimiter{
+		rps:             rps,
+		burst:           burst,
+		tokens:          burst,
+		timeout:         timeout,
+		backoff:         500 * time.Millisecond,
+		maxBackoff:      15 * time.Second,
+		attempts:        6,
+		timeoutAttempts: 2,
+	}
+}
+
+type rpcLimiterKey struct{}
+
+func withRPCLimiter(ctx context.Context, l *rpcLimiter) context.Context {
+	return context.WithValue(ctx, rpcLimiterKey{}, l)
+}
+
+func rpcLimiterFrom(ctx context.Context) *rpcLimiter {
+	l, _ := ctx.Value(rpcLimiterKey{}).(*rpcLimiter)
+	return l
+}
+
+// rpcRead runs the read fn, named by its RPC method for logs, under ctx's
+// limiter, or directly if there is none. fn must use the context it is given.
+func rpcRead(ctx context.Context, method string, fn func(context.Context) error) error {
+	if l := rpcLimiterFrom(ctx); l != nil {
+		return l.do(ctx, method, fn)
+	}
+	return fn(ctx)
+}
+
+func (l *rpcLimiter) do(ctx context.Context, method string, fn func(context.Context) error) error {
+	wait := l.backoff
+	timeouts := 0
+	for attempt := 1; ; attempt++ {
+		if err := l.take(ctx); err != nil {
+			return err
+		}
+		err := l.attempt(ctx, fn)
+		switch {
+		case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+			l.timedOut.Add(1)
+			timeouts++
+			warnf(ctx, "rpc: %s timed out after %s", method, l.timeout)
+			if timeouts >= l.timeoutAttempts {
+				return fmt.Errorf("%s: %w", method, err)
+			}
+			l.retried.Add(1)
+			continue
+		case !isRateLimited(err):
+			return err
+		}
+		l.throttled.Add(1)
+		if attempt >= l.attempts {
+			return err
+		}
+		l.retried.Add(1)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(jitter(wait)):
+		}
+		if wait *= 2; wait > l.maxBackoff {
+			wait = l.maxBackoff
+		}
+	}
+}
+
+func (l *rpcLimiter) attempt(ctx context.Context, fn func(context.Context) error) error {
+	if l.timeout <= 0 {
+		return fn(ctx)
+	}
+	cctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+	return fn(cctx)
+}
+
+// headerByNumber is eth_getBlockByNumber through rpcRead; nil is the latest block.
+func headerByNumber(ctx context.Context, client *ethclient.Client, number *big.Int) (*types.Header, error) {
+	var h *types.Header
+	err := rpcRead(ctx, "eth_getBlockByNumber", func(ctx context.Context) (err error) {
+		h, err = client.HeaderByNumber(ctx, number)
+		return err
+	})
+	return h, err
+}
+
+// blockNumber is eth_blockNumber through rpcRead.
+func blockNumber(ctx context.Context, client *ethclient.Client) (uint64, error) {
+	var n uint64
+	err := rpcRead(ctx, "eth_blockNumber", func(ctx context.Context) (err error) {
+		n, err = client.BlockNumber(ctx)
+		return err
+	})
+	return n, err
+}
+
+// estimateGas is eth_estimateGas through rpcRead.
+func estimateGas(ctx context.Context, client *ethclient.Client, msg ethereum.CallMsg) (uint64, error) {
+	var gas uint64
+	err := rpcRead(ctx, "eth_estimateGas", func(ctx context.Context) (err error) {
+		gas, err = client.EstimateGas(ctx, msg)
+		return err
+	})
+	return gas, err
+}
+
+// take blocks until the bucket has a token.
+func (l *rpcLimiter) take(ctx context.Context) error {
+	if l.rps <= 0 {
+		return nil
+	}
+	counted := false
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		if !l.last.IsZero() {
+			l.tokens += now.Sub(l.last).Seconds() * l.rps
+			if l.tokens > l.burst {
+				l.tokens = l.burst
+			}
+		}
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - l.tokens) / l.rps * float64(time.Second))
+		l.mu.Unlock()
+		if !counted {
+			l.delayed.Add(1)
+			counted = true
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// logStats prints the counters when they changed since the last call.
+func (l *rpcLimiter) logStats(ctx context.Context) {
+	cur := [4]int64{l.delayed.Load(), l.throttled.Load(), l.retried.Load(), l.timedOut.Load()}
+	l.logMu.Lock()
+	changed := cur != l.lastLogged
+	l.lastLogged = cur
+	l.logMu.Unlock()
+	if changed {
+		logf(ctx, "rpc: %d reads delayed by --rpc-rps, %d rate limited by the provider, %d timed out, %d retried", cur[0], cur[1], cur[3], cur[2])
+	}
+}
+
+// jitter spreads d over [d/2, d) so clients throttled together don't retry together.
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// isRateLimited recognises an HTTP 429 and the JSON-RPC errors providers
+// return over websockets when a plan's request limit is hit.
+func isRateLimited(err error) bool {
+	if err == nil {
+		return false
+	}
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) && rpcErr.ErrorCode() == -32005 {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"too many requests", "rate limit", "exceeded its compute units", "request limit"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+type codeErr struct {
+	code int
+	msg  string
+}
+
+func (e codeErr) Error() string  { return e.msg }
+func (e codeErr) ErrorCode() int { return e.code }
+
+func TestIsRateLimited(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{rpc.HTTPError{StatusCode: 429, Status: "429 Too Many Requests"}, true},
+		{fmt.Errorf("call status: %w", rpc.HTTPError{StatusCode: 503, Status: "503 Service Unavailable"}), false},
+		{codeErr{-32005, "limit exceeded"}, true},
+		{codeErr{-32000, "execution reverted"}, false},
+		{errors.New("Your app has exceeded its compute units per second capacity"), true},
+		{errors.New("project ID request rate exceeded: Too Many Requests"), true},
+	} {
+		if got := isRateLimited(tc.err); got != tc.want {
+			t.Errorf("isRateLimited(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestRPCLimiterRetriesRateLimited(t *testing.T) {
+	l := newRPCLimiter(0, 0)
+	l.backoff = time.Millisecond
+	calls := 0
+	err := rpcRead(withRPCLimiter(context.Background(), l), "eth_call", func(context.Context) error {
+		if calls++; calls < 3 {
+			return rpc.HTTPError{StatusCode: 429}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("err=%v after %d calls, want success on the 3rd", err, calls)
+	}
+	if l.throttled.Load() != 2 || l.retried.Load() != 2 {
+		t.Fatalf("throttled=%d retried=%d, want 2 and 2", l.throttled.Load(), l.retried.Load())
+	}
+}
+
+func TestRPCLimiterGivesUp(t *testing.T) {
+	l := newRPCLimiter(0, 0)
+	l.backoff, l.attempts = time.Millisecond, 3
+	calls := 0
+	err := l.do(context.Background(), "eth_call", func(context.Context) error {
+		calls++
+		return rpc.HTTPError{StatusCode: 429}
+	})
+	if !isRateLimited(err) || calls != 3 {
+		t.Fatalf("err=%v after %d calls, want the 429 after 3", err, calls)
+	}
+}
+
+func TestRPCLimiterPaces(t *testing.T) {
+	l := newRPCLimiter(50, 0) // burst 50, then one per 20ms
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 55; i++ {
+		if err := l.take(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Fatalf("55 reads at 50 rps took %s, want >= ~100ms past the burst", elapsed)
+	}
+	if l.delayed.Load() == 0 {
+		t.Fatal("no reads counted as delayed")
+	}
+}
+
+// slowEth is pollEth whose eth_getBlockByNumber hangs while hang > 0,
+// decrementing it on every call.
+type slowEth struct {
+	pollEth
+	hang    int
+	release chan struct{}
+}
+
+func (f *slowEth) GetBlockByNumber(number string, full bool) (*types.Header, error) {
+	f.mu.Lock()
+	hang := f.hang > 0
+	if hang {
+		f.hang--
+	}
+	f.mu.Unlock()
+	if hang {
+		<-f.release
+	}
+	return f.pollEth.GetBlockByNumber(number, full)
+}
+
+func TestCallTimeoutKeepsPollerMoving(t *testing.T) {
+	eth := &slowEth{pollEth: pollEth{head: 102}, hang: 1, release: make(chan struct{})}
+	defer close(eth.release)
+	l := newRPCLimiter(0, 50*time.Millisecond)
+	ctx := withRPCLimiter(context.Background(), l)
+	p := &headPoller{client: dialFakeEth(t, eth), last: 100}
+
+	// The first header read hangs; it is abandoned and retried.
+	_, heads, err := p.poll(ctx)
+	if err != nil || len(heads) != 2 {
+		t.Fatalf("poll with one hung read: heads=%d err=%v, want 2 heads", len(heads), err)
+	}
+	if l.timedOut.Load() != 1 {
+		t.Fatalf("timedOut = %d, want 1", l.timedOut.Load())
+	}
+
+	// A node that hangs on every try fails the poll instead of blocking it...
+	eth.mu.Lock()
+	eth.head, eth.hang = 104, 10
+	eth.mu.Unlock()
+	start := time.Now()
+	if _, _, err := p.poll(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("poll against a hung node: err=%v, want a deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("poll against a hung node took %s", elapsed)
+	}
+
+	// ...and the next poll picks up where it left off once the node answers.
+	eth.mu.Lock()
+	eth.hang = 0
+	eth.mu.Unlock()
+	_, heads, err = p.poll(ctx)
+	if err != nil || len(heads) != 2 || heads[1].Number.Uint64() != 104 {
+		t.Fatalf("poll after recovery: heads=%v err=%v, want 103 and 104", heightsOf(heads), err)
+	}
+}
@@ -0,0 +1,147 @@
+package twapagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// errSignerRejected marks a transaction the remote signer refused to sign
+// (policy, unknown account, ...), as opposed to the signer being unreachable.
+var errSignerRejected = errors.New("remote signer rejected transaction")
+
+// remoteSigner signs through a JSON-RPC eth_signTransaction endpoint such as
+// web3signer or clef, so no key material lives in the agent process.
+type remoteSigner struct {
+	rpc  *rpc.Client
+	from common.Address
+}
+
+// newRemoteSigner connects to url and resolves the agent address from from,
+// or from eth_accounts when from is empty.
+func newRemoteSigner(ctx context.Context, url, from string) (*remoteSigner, error) {
+	c, err := rpc.DialContext(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("dial remote signer: %w", err)
+	}
+	s := &remoteSigner{rpc: c}
+	if from != "" {
+		if !common.IsHexAddress(from) {
+			return nil, fmt.Errorf("invalid --from address %q", from)
+		}
+		s.from = common.HexToAddress(from)
+		return s, nil
+	}
+	var accounts []common.Address
+	if err := c.CallContext(ctx, &accounts, "eth_accounts"); err != nil {
+		return nil, fmt.Errorf("remote signer eth_accounts: %w", err)
+	}
+	switch len(accounts) {
+	case 0:
+		return nil, errors.New("remote signer has no accounts")
+	case 1:
+		s.from = accounts[0]
+		return s, nil
+	}
+	return nil, fmt.Errorf("remote signer has %d accounts; pick one with --from", len(accounts))
+}
+
+func (s *remoteSigner) Address() common.Address { return s.from }
+
+func (s *remoteSigner) TransactOpts(ctx context.Context, chainID uint64) (*bind.TransactOpts, error) {
+	id := new(big.Int).SetUint64(chainID)
+	return &bind.TransactOpts{
+		From:    s.from,
+		Context: ctx,
+		Signer: func(from common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			if from != s.from {
+				return nil, bind.ErrNotAuthorized
+			}
+			return s.signTx(ctx, tx, id)
+		},
+	}, nil
+}
+
+type signTxArgs struct {
+	From                 common.Address  `json:"from"`
+	To                   *common.Address `json:"to,omitempty"`
+	Gas                  hexutil.Uint64  `json:"gas"`
+	GasPrice             *hexutil.Big    `json:"gasPrice,omitempty"`
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas,omitempty"`
+	Value                *hexutil.Big    `json:"value"`
+	Data                 hexutil.Bytes   `json:"data"`
+	Nonce                hexutil.Uint64  `json:"nonce"`
+	ChainID              *hexutil.Big    `json:"chainId"`
+}
+
+// signTx sends the unsigned tx to eth_signTransaction and checks that what
+// comes back is the same transaction, signed by the agent address.
+func (s *remoteSigner) signTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	args := signTxArgs{
+		From:    s.from,
+		To:      tx.To(),
+		Gas:     hexutil.Uint64(tx.Gas()),
+		Value:   (*hexutil.Big)(tx.Value()),
+		Data:    tx.Data(),
+		Nonce:   hexutil.Uint64(tx.Nonce()),
+		ChainID: (*hexutil.Big)(chainID),
+	}
+	if tx.Type() == types.DynamicFeeTxType {
+		args.MaxFeePerGas = (*hexutil.Big)(tx.GasFeeCap())
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(tx.GasTipCap())
+	} else {
+		args.GasPrice = (*hexutil.Big)(tx.GasPrice())
+	}
+
+	var res json.RawMessage
+	if err := s.rpc.CallContext(ctx, &res, "eth_signTransaction", args); err != nil {
+		var rpcErr rpc.Error
+		if errors.As(err, &rpcErr) {
+			return nil, fmt.Errorf("%w: %v", errSignerRejected, err)
+		}
+		return nil, fmt.Errorf("remote signer unavailable: %w", err)
+	}
+	raw, err := decodeSignResult(res)
+	if err != nil {
+		return nil, err
+	}
+	signed := new(types.Transaction)
+	if err := signed.UnmarshalBinary(raw); err != nil {
+		return nil, fmt.Errorf("decode signed tx: %w", err)
+	}
+	sender, err := types.Sender(types.LatestSignerForChainID(chainID), signed)
+	if err != nil {
+		return nil, fmt.Errorf("recover signed tx sender: %w", err)
+	}
+	if sender != s.from || signed.Nonce() != tx.Nonce() || signed.Gas() != tx.Gas() ||
+		signed.To() == nil || tx.To() == nil || *signed.To() != *tx.To() || !bytes.Equal(signed.Data(), tx.Data()) {
+		return nil, fmt.Errorf("%w: signed tx does not match the request", errSignerRejected)
+	}
+	return signed, nil
+}
+
+// decodeSignResult accepts both result shapes in use: a bare raw tx hex string
+// (web3signer) and geth/clef's {"raw": ..., "tx": ...} object.
+func decodeSignResult(res json.RawMessage) ([]byte, error) {
+	var raw hexutil.Bytes
+	if err := json.Unmarshal(res, &raw); err == nil {
+		return raw, nil
+	}
+	var obj struct {
+		Raw hexutil.Bytes `json:"raw"`
+	}
+	if err := json.Unmarshal(res, &obj); err != nil || len(obj.Raw) == 0 {
+		return nil, fmt.Errorf("unexpected eth_signTransaction result %s", res)
+	}
+	return obj.Raw, nil
+}
@@ -0,0 +1,100 @@
+package twapagent
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// fakeRemoteSigner serves eth_signTransaction the way clef does.
+type fakeRemoteSigner struct {
+	key    *ecdsa.PrivateKey
+	reject bool
+}
+
+type signTxResult struct {
+	Raw hexutil.Bytes `json:"raw"`
+}
+
+func (f *fakeRemoteSigner) SignTransaction(args signTxArgs) (*signTxResult, error) {
+	if f.reject {
+		return nil, errors.New("request denied by policy")
+	}
+	tx := types.NewTx(&types.LegacyTx{
+		Nonce:    uint64(args.Nonce),
+		To:       args.To,
+		Gas:      uint64(args.Gas),
+		GasPrice: args.GasPrice.ToInt(),
+		Value:    args.Value.ToInt(),
+		Data:     args.Data,
+	})
+	signed, err := types.SignTx(tx, types.LatestSignerForChainID(args.ChainID.ToInt()), f.key)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := signed.MarshalBinary()
+	return &signTxResult{Raw: raw}, err
+}
+
+func newTestRemoteSigner(t *testing.T, reject bool) *remoteSigner {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("eth", &fakeRemoteSigner{key: key, reject: reject}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Stop)
+	return &remoteSigner{rpc: rpc.DialInProc(srv), from: crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+func legacyTestTx() *types.Transaction {
+	to := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	return types.NewTx(&types.LegacyTx{Nonce: 4, To: &to, Gas: 90_000, GasPrice: big.NewInt(1e9), Data: []byte{0xde, 0xad}})
+}
+
+func TestRemoteSignerSigns(t *testing.T) {
+	s := newTestRemoteSigner(t, false)
+	opts, err := s.TransactOpts(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := opts.Signer(s.Address(), legacyTestTx())
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(big.NewInt(1)), signed)
+	if err != nil || from != s.Address() || signed.Nonce() != 4 {
+		t.Fatalf("signed by %s nonce %d (err %v), want %s nonce 4", from.Hex(), signed.Nonce(), err, s.Address().Hex())
+	}
+}
+
+func TestRemoteSignerRejection(t *testing.T) {
+	s := newTestRemoteSigner(t, true)
+	opts, _ := s.TransactOpts(context.Background(), 1)
+	if _, err := opts.Signer(s.Address(), legacyTestTx()); !errors.Is(err, errSignerRejected) {
+		t.Fatalf("err = %v, want errSignerRejected", err)
+	}
+}
+
+func TestDecodeSignResult(t *testing.T) {
+	for _, in := range []string{`"0x01ff"`, `{"raw":"0x01ff","tx":{}}`} {
+		raw, err := decodeSignResult([]byte(in))
+		if err != nil || hexutil.Encode(raw) != "0x01ff" {
+			t.Fatalf("%s: raw = %x, err = %v", in, raw, err)
+		}
+	}
+	if _, err := decodeSignResult([]byte(`{"foo":1}`)); err == nil {
+		t.Fatal("expected error for unknown result shape")
+	}
+}
@@ -0,0 +1,254 @@
+package twapagent
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// sliceExecution is when one slice was scheduled and when its Fill landed.
+type sliceExecution struct {
+	Slice     int64       `json:"slice"`
+	Scheduled uint64      `json:"scheduledAt"`
+	Block     uint64      `json:"block"`
+	Executed  uint64      `json:"executedAt"`
+	Delay     uint64      `json:"delaySeconds"`
+	TxHash    common.Hash `json:"txHash"`
+	// Executed after a slice with a higher id.
+	OutOfOrder bool `json:"outOfOrder"`
+}
+
+// missedSlice is a slice without a Fill.
+type missedSlice struct {
+	Slice     int64  `json:"slice"`
+	Scheduled uint64 `json:"scheduledAt"`
+	Due       bool   `json:"due"`
+}
+
+// replayReport is how closely an order's fills followed its schedule.
+type replayReport struct {
+	TotalSlices  int64            `json:"totalSlices"`
+	Executed     []sliceExecution `json:"executed"`
+	Missed       []missedSlice    `json:"missed"`
+	WorstDelay   uint64           `json:"worstDelaySeconds"`
+	AverageDelay float64          `json:"averageDelaySeconds"`
+	OutOfOrder   int              `json:"outOfOrder"`
+}
+
+// fillRecord is a decoded Fill log.
+type fillRecord struct {
+	Slice                    int64
+	AmountIn, AmountOut, Fee *big.Int
+	Block                    uint64
+	Index                    uint
+	Tx                       common.Hash
+}
+
+// fillsFromLogs picks the Fill events out of logs, in chain order.
+func fillsFromLogs(cABI abi.ABI, logs []types.Log) []fillRecord {
+	var fills []fillRecord
+	for _, lg := range logs {
+		ev, values, err := decodeEvent(cABI, lg)
+		if err != nil || ev.Name != "Fill" || lg.Removed {
+			continue
+		}
+		id, _ := values["sliceId"].(*big.Int)
+		amountIn, _ := values["amountIn"].(*big.Int)
+		amountOut, _ := values["amountOut"].(*big.Int)
+		fee, _ := values["fee"].(*big.Int)
+		if id == nil || !id.IsInt64() || amountIn == nil || amountOut == nil || fee == nil {
+			continue
+		}
+		fills = append(fills, fillRecord{Slice: id.Int64(), AmountIn: amountIn, AmountOut: amountOut, Fee: fee, Block: lg.BlockNumber, Index: lg.Index, Tx: lg.TxHash})
+	}
+	return fills
+}
+
+// buildReplay matches fills, in chain order, against the schedule of s with
+// n slices, as of block time now. blockTime returns a block's timestamp.
+func buildReplay(s Strategy, n int64, fills []fillRecord, now uint64, blockTime func(uint64) (uint64, error)) (replayReport, error) {
+	r := replayReport{TotalSlices: n}
+	seen := map[int64]bool{}
+	highest := int64(-1)
+	var total uint64
+	for _, f := range fills {
+		if f.Slice >= n || seen[f.Slice] {
+			continue
+		}
+		seen[f.Slice] = true
+		scheduled, err := sliceScheduledAt(s, n, f.Slice)
+		if err != nil {
+			return r, err
+		}
+		executed, err := blockTime(f.Block)
+		if err != nil {
+			return r, err
+		}
+		e := sliceExecution{Slice: f.Slice, Scheduled: scheduled.Uint64(), Block: f.Block, Executed: executed, TxHash: f.Tx}
+		if executed > e.Scheduled {
+			e.Delay = executed - e.Scheduled
+		}
+		if f.Slice < highest {
+			e.OutOfOrder = true
+			r.OutOfOrder++
+		}
+		if f.Slice > highest {
+			highest = f.Slice
+		}
+		if e.Delay > r.WorstDelay {
+			r.WorstDelay = e.Delay
+		}
+		total += e.Delay
+		r.Executed = append(r.Executed, e)
+	}
+	if len(r.Executed) > 0 {
+		r.AverageDelay = float64(total) / float64(len(r.Executed))
+	}
+	for id := int64(0); id < n; id++ {
+		if seen[id] {
+			continue
+		}
+		scheduled, err := sliceScheduledAt(s, n, id)
+		if err != nil {
+			return r, err
+		}
+		r.Missed = append(r.Missed, missedSlice{Slice: id, Scheduled: scheduled.Uint64(), Due: scheduled.Uint64() <= now})
+	}
+	return r, nil
+}
+
+// readFills reads the vault's Fill events from the block at s.StartTime to
+// the latest block, which it returns along with the block times it looked up.
+func readFills(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, s Strategy, chunkBlocks uint64) ([]fillRecord, *blockTimes, *types.Header, error) {
+	head, err := headerByNumber(ctx, client, nil)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("latest header: %w", err)
+	}
+	times := newBlockTimes(client)
+	times.times[head.Number.Uint64()] = head.Time
+	// A reconfiguration needs a startTime in the future, so fills of an
+	// earlier strategy all come before this one's start.
+	from, err := blockAtTime(ctx, times, s.StartTime.Uint64(), head.Number.Uint64())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("find the block at startTime: %w", err)
+	}
+	var fills []fillRecord
+	chunk := chunkBlocks
+	err = fetchLogs(ctx, client, []common.Address{addr}, nil, from, head.Number.Uint64(), &chunk, func(logs []types.Log) error {
+		fills = append(fills, fillsFromLogs(cABI, logs)...)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return fills, times, head, nil
+}
+
+func unixUTC(t uint64) string {
+	return time.Unix(int64(t), 0).UTC().Format(time.RFC3339)
+}
+
+func (r replayReport) writeText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "slice\tscheduled\texecuted\tblock\tdelay\ttx\t")
+	byID := append([]sliceExecution(nil), r.Executed...)
+	sort.Slice(byID, func(i, j int) bool { return byID[i].Slice < byID[j].Slice })
+	for _, e := range byID {
+		note := ""
+		if e.OutOfOrder {
+			note = " (out of order)"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%ds%s\t%s\t\n", e.Slice, unixUTC(e.Scheduled), unixUTC(e.Executed), e.Block, e.Delay, note, e.TxHash.Hex())
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Executed %d/%d slices: worst delay %ds, average %.1fs, %d out of order\n", len(r.Executed), r.TotalSlices, r.WorstDelay, r.AverageDelay, r.OutOfOrder)
+	for _, m := range r.Missed {
+		state := "not due yet"
+		if m.Due {
+			state = "never executed"
+		}
+		fmt.Fprintf(w, "- slice %d (scheduled %s): %s\n", m.Slice, unixUTC(m.Scheduled), state)
+	}
+	return nil
+}
+
+func (r replayReport) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"slice", "scheduled_at", "block", "executed_at", "delay_seconds", "out_of_order", "tx_hash"})
+	for _, e := range r.Executed {
+		cw.Write([]string{strconv.FormatInt(e.Slice, 10), strconv.FormatUint(e.Scheduled, 10), strconv.FormatUint(e.Block, 10),
+			strconv.FormatUint(e.Executed, 10), strconv.FormatUint(e.Delay, 10), strconv.FormatBool(e.OutOfOrder), e.TxHash.Hex()})
+	}
+	// Slices without a Fill have no block, time or tx.
+	for _, m := range r.Missed {
+		cw.Write([]string{strconv.FormatInt(m.Slice, 10), strconv.FormatUint(m.Scheduled, 10), "", "", "", "", ""})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (r replayReport) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// replay is replay mode: it reads the vault's Fill events since the block at
+// startTime and reports each slice's delay against its schedule, the worst
+// and average delay, fills out of slice order and slices never executed.
+func replay(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, chunkBlocks uint64, format, outPath string) error {
+	N, err := readTotalSlices(ctx, addr, cABI, client)
+	if err != nil {
+		return fmt.Errorf("read totalSlices: %w", err)
+	}
+	n, err := sliceCount(N)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errNotInitialized
+	}
+	s, err := readStrategy(ctx, addr, cABI, client)
+	if err != nil {
+		return fmt.Errorf("read strategy: %w", err)
+	}
+	fills, times, head, err := readFills(ctx, addr, cABI, client, s, chunkBlocks)
+	if err != nil {
+		return err
+	}
+	r, err := buildReplay(s, n, fills, head.Time, func(b uint64) (uint64, error) { return times.get(ctx, b) })
+	if err != nil {
+		return err
+	}
+
+	out, err := createOutput(ctx, outPath)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case formatCSV:
+		err = r.writeCSV(out)
+	case formatJSON:
+		err = r.writeJSON(out)
+	default:
+		err = r.writeText(out)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
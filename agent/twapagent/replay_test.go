@@ -0,0 +1,77 @@
+package twapagent
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestBuildReplay(t *testing.T) {
+	// 4 slices from t=1000 to 2000: scheduled at 1000, 1250, 1500, 1750.
+	s := Strategy{StartTime: big.NewInt(1000), EndTime: big.NewInt(2000)}
+	fills := []fillRecord{
+		{Slice: 0, Block: 10},
+		{Slice: 2, Block: 20},
+		{Slice: 1, Block: 21},
+		{Slice: 1, Block: 22}, // a duplicate is ignored
+	}
+	blockTime := func(b uint64) (uint64, error) { return 1000 + (b-10)*50, nil }
+	r, err := buildReplay(s, 4, fills, 1700, blockTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Executed) != 3 {
+		t.Fatalf("executed %+v", r.Executed)
+	}
+	// Slice 2 ran at 1500 (on time), slice 1 at 1550: 300s late and after slice 2.
+	if e := r.Executed[2]; e.Slice != 1 || e.Delay != 300 || !e.OutOfOrder {
+		t.Errorf("slice 1: %+v", e)
+	}
+	if r.WorstDelay != 300 || r.AverageDelay != 100 || r.OutOfOrder != 1 {
+		t.Errorf("worst=%d average=%v outOfOrder=%d", r.WorstDelay, r.AverageDelay, r.OutOfOrder)
+	}
+	if len(r.Missed) != 1 || r.Missed[0].Slice != 3 || r.Missed[0].Due {
+		t.Errorf("missed %+v, want slice 3 not due yet", r.Missed)
+	}
+
+	var text, csv bytes.Buffer
+	if err := r.writeText(&text); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"300s (out of order)", "Executed 3/4 slices: worst delay 300s, average 100.0s, 1 out of order", "slice 3 (scheduled 1970-01-01T00:29:10Z): not due yet"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text lacks %q:\n%s", want, text.String())
+		}
+	}
+	if err := r.writeCSV(&csv); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(csv.String()), "\n"); len(lines) != 5 || lines[4] != "3,1750,,,,," {
+		t.Errorf("csv:\n%s", csv.String())
+	}
+}
+
+func TestFillsFromLogs(t *testing.T) {
+	cABI, _, err := loadTwapABI("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fill := cABI.Events["Fill"]
+	data, err := fill.Inputs.Pack(big.NewInt(4), big.NewInt(10), big.NewInt(20), big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := []types.Log{
+		{Topics: []common.Hash{fill.ID}, Data: data, BlockNumber: 7},
+		{Topics: []common.Hash{cABI.Events["Paused"].ID}, Data: make([]byte, 32), BlockNumber: 8},
+		{Topics: []common.Hash{fill.ID}, Data: data, BlockNumber: 9, Removed: true},
+	}
+	got := fillsFromLogs(cABI, logs)
+	if len(got) != 1 || got[0].Slice != 4 || got[0].Block != 7 {
+		t.Fatalf("fills = %+v", got)
+	}
+}
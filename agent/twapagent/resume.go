@@ -0,0 +1,56 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// resumeVault loads what the last run saved for v into its state: the
+// failure counters first, then each tx it left pending, checked against the
+// chain. One that mined while the agent was down is booked like any receipt
+// (counting a revert as a failure). One whose nonce has been used since was
+// replaced, and the slice's sliceDone tells the rest. The others are pending
+// again, with their broadcast time, so the bot waits for them instead of
+// sending the slice twice and --resubmit-after still applies.
+func resumeVault(ctx context.Context, v *vaultBot, from common.Address, cp *checkpoint) {
+	st := v.st
+	if r := cp.retryState(v.addr); r != nil {
+		st.failures.restore(r)
+		if st.failures.Tripped() {
+			warnf(ctx, "circuit breaker still open from the last run (send SIGHUP to reset)")
+		}
+	}
+	for _, rec := range cp.submissions(v.addr) {
+		var receipt *types.Receipt
+		err := rpcRead(ctx, "eth_getTransactionReceipt", func(ctx context.Context) (err error) {
+			receipt, err = st.txClient.TransactionReceipt(ctx, rec.Hash)
+			return err
+		})
+		switch {
+		case err == nil:
+			logf(ctx, "slice %d tx %s mined in block %d while the agent was down", rec.Slice, rec.Hash.Hex(), receipt.BlockNumber.Uint64())
+			finishSlice(ctx, v.addr, from, st, rec.Slice, receipt, nil)
+			continue
+		case !errors.Is(err, ethereum.NotFound):
+			// Not knowing, the tx may still mine: keep waiting for it.
+			warnf(ctx, "receipt of slice %d tx %s: %v", rec.Slice, rec.Hash.Hex(), err)
+		case rec.Nonce != nil && from != (common.Address{}) && nonceUsed(ctx, st.txClient, from, *rec.Nonce):
+			logf(ctx, "slice %d: nonce %d of tx %s has been used by a replacement", rec.Slice, *rec.Nonce, rec.Hash.Hex())
+			continue
+		}
+		logf(ctx, "slice %d tx %s from the last run is still pending", rec.Slice, rec.Hash.Hex())
+		st.submitted.mark(rec.Slice, submission{Hash: rec.Hash, Nonce: rec.Nonce, At: rec.At})
+	}
+}
+
+// saveVault records v's status history, pending txs and failure counters in
+// cp, for its next save.
+func saveVault(v *vaultBot, cp *checkpoint) {
+	cp.setTransitions(v.addr, v.st.status.Transitions())
+	cp.setSubmissions(v.addr, v.st.submitted.records())
+	cp.setRetryState(v.addr, v.st.failures.record())
+}
@@ -0,0 +1,161 @@
+package twapagent
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RetryConfig controls how failed executeSlice txs affect later attempts.
+type RetryConfig struct {
+	MaxFailuresPerSlice int
+	BaseBackoff         time.Duration
+	MaxBackoff          time.Duration
+	// Trip the breaker after this many consecutive failures across all slices (0 disables).
+	BreakerThreshold int
+	// Auto-reset the breaker after this long; 0 waits for Agent.ResetBreaker (SIGHUP in the CLI).
+	BreakerCooldown time.Duration
+}
+
+type sliceFailures struct {
+	count       int
+	nextAttempt time.Time
+}
+
+// failureTracker applies per-slice exponential backoff, gives up on slices
+// that keep failing, and pauses all submissions when failures pile up.
+type failureTracker struct {
+	mu          sync.Mutex
+	cfg         RetryConfig
+	slices      map[int64]*sliceFailures
+	consecutive int
+	tripped     bool
+	trippedAt   time.Time
+}
+
+func newFailureTracker(cfg RetryConfig) *failureTracker {
+	return &failureTracker{cfg: cfg, slices: make(map[int64]*sliceFailures)}
+}
+
+// Allow reports whether slice may be attempted at now, and why not otherwise.
+func (t *failureTracker) Allow(slice int64, now time.Time) (bool, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tripped {
+		if t.cfg.BreakerCooldown > 0 && now.Sub(t.trippedAt) >= t.cfg.BreakerCooldown {
+			t.tripped = false
+			t.consecutive = 0
+		} else {
+			return false, fmt.Sprintf("circuit breaker open after %d consecutive failures", t.consecutive)
+		}
+	}
+	f, ok := t.slices[slice]
+	if !ok {
+		return true, ""
+	}
+	if t.givenUpLocked(slice) {
+		return false, fmt.Sprintf("gave up after %d failures", f.count)
+	}
+	if now.Before(f.nextAttempt) {
+		return false, fmt.Sprintf("backing off until %s after %d failures", f.nextAttempt.UTC().Format(time.RFC3339), f.count)
+	}
+	return true, ""
+}
+
+// GaveUp reports whether slice exceeded --max-failures-per-slice.
+func (t *failureTracker) GaveUp(slice int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.givenUpLocked(slice)
+}
+
+func (t *failureTracker) givenUpLocked(slice int64) bool {
+	f, ok := t.slices[slice]
+	return ok && t.cfg.MaxFailuresPerSlice > 0 && f.count >= t.cfg.MaxFailuresPerSlice
+}
+
+// RecordFailure counts a failed attempt. It reports whether the slice has now
+// been given up on and whether this failure tripped the breaker.
+func (t *failureTracker) RecordFailure(slice int64, now time.Time) (gaveUp, tripped bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, ok := t.slices[slice]
+	if !ok {
+		f = &sliceFailures{}
+		t.slices[slice] = f
+	}
+	f.count++
+	backoff := t.cfg.BaseBackoff << (f.count - 1)
+	if backoff <= 0 || (t.cfg.MaxBackoff > 0 && backoff > t.cfg.MaxBackoff) {
+		backoff = t.cfg.MaxBackoff
+	}
+	f.nextAttempt = now.Add(backoff)
+
+	t.consecutive++
+	if !t.tripped && t.cfg.BreakerThreshold > 0 && t.consecutive >= t.cfg.BreakerThreshold {
+		t.tripped, t.trippedAt = true, now
+		tripped = true
+	}
+	return t.givenUpLocked(slice), tripped
+}
+
+// RecordSuccess resets the slice's and the global failure counters.
+func (t *failureTracker) RecordSuccess(slice int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.slices, slice)
+	t.consecutive = 0
+}
+
+// Tripped reports whether the circuit breaker is open, without applying
+// the cooldown; Allow does that.
+func (t *failureTracker) Tripped() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tripped
+}
+
+// record returns the tracker's state for the state file, nil when it has
+// nothing to remember.
+func (t *failureTracker) record() *retryRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.slices) == 0 && t.consecutive == 0 && !t.tripped {
+		return nil
+	}
+	r := &retryRecord{Consecutive: t.consecutive}
+	for id, f := range t.slices {
+		r.Slices = append(r.Slices, sliceFailureRecord{Slice: id, Count: f.count, NextAttempt: f.nextAttempt})
+	}
+	sort.Slice(r.Slices, func(i, j int) bool { return r.Slices[i].Slice < r.Slices[j].Slice })
+	if t.tripped {
+		at := t.trippedAt
+		r.TrippedAt = &at
+	}
+	return r
+}
+
+// restore loads a previous run's state, as record returned it.
+func (t *failureTracker) restore(r *retryRecord) {
+	if r == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, f := range r.Slices {
+		t.slices[f.Slice] = &sliceFailures{count: f.Count, nextAttempt: f.NextAttempt}
+	}
+	t.consecutive = r.Consecutive
+	if r.TrippedAt != nil {
+		t.tripped, t.trippedAt = true, *r.TrippedAt
+	}
+}
+
+// ResetBreaker closes the circuit breaker (operator action).
+func (t *failureTracker) ResetBreaker() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tripped = false
+	t.consecutive = 0
+}
@@ -0,0 +1,65 @@
+package twapagent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFailureTrackerBackoffAndGiveUp(t *testing.T) {
+	tr := newFailureTracker(RetryConfig{MaxFailuresPerSlice: 3, BaseBackoff: 10 * time.Second, MaxBackoff: 15 * time.Second})
+	now := time.Unix(1_700_000_000, 0)
+
+	tr.RecordFailure(2, now)
+	if ok, _ := tr.Allow(2, now.Add(9*time.Second)); ok {
+		t.Fatal("allowed during first backoff")
+	}
+	if ok, _ := tr.Allow(2, now.Add(10*time.Second)); !ok {
+		t.Fatal("not allowed after first backoff")
+	}
+	if ok, _ := tr.Allow(3, now); !ok {
+		t.Fatal("backoff leaked to another slice")
+	}
+
+	// Second failure doubles to 20s, capped at 15s.
+	tr.RecordFailure(2, now)
+	if ok, _ := tr.Allow(2, now.Add(15*time.Second)); !ok {
+		t.Fatal("max backoff not applied")
+	}
+
+	gaveUp, _ := tr.RecordFailure(2, now)
+	if !gaveUp || !tr.GaveUp(2) {
+		t.Fatal("expected give-up after 3 failures")
+	}
+	if ok, _ := tr.Allow(2, now.Add(time.Hour)); ok {
+		t.Fatal("given-up slice allowed")
+	}
+}
+
+func TestFailureTrackerBreaker(t *testing.T) {
+	tr := newFailureTracker(RetryConfig{BreakerThreshold: 2, BreakerCooldown: time.Minute})
+	now := time.Unix(1_700_000_000, 0)
+
+	if _, tripped := tr.RecordFailure(1, now); tripped {
+		t.Fatal("tripped too early")
+	}
+	if _, tripped := tr.RecordFailure(2, now); !tripped {
+		t.Fatal("breaker not tripped")
+	}
+	if ok, _ := tr.Allow(5, now.Add(30*time.Second)); ok {
+		t.Fatal("allowed while breaker open")
+	}
+	if ok, _ := tr.Allow(5, now.Add(time.Minute)); !ok {
+		t.Fatal("breaker did not reset after cooldown")
+	}
+
+	tr.RecordFailure(1, now)
+	tr.RecordSuccess(1)
+	if _, tripped := tr.RecordFailure(2, now); tripped {
+		t.Fatal("success did not reset consecutive failures")
+	}
+	tr.RecordFailure(3, now)
+	tr.ResetBreaker()
+	if ok, _ := tr.Allow(6, now); !ok {
+		t.Fatal("manual reset did not close the breaker")
+	}
+}
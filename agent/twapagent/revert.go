@@ -0,0 +1,125 @@
+package twapagent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Selectors of the revert payloads the EVM itself produces.
+var (
+	errorStringSelector = crypto.Keccak256([]byte("Error(string)"))[:4]
+	panicSelector       = crypto.Keccak256([]byte("Panic(uint256)"))[:4]
+)
+
+// revertData extracts the raw revert payload carried by an eth_call or
+// eth_estimateGas error, if the node returned one.
+func revertData(err error) ([]byte, bool) {
+	var de rpc.DataError
+	if !errors.As(err, &de) {
+		return nil, false
+	}
+	s, ok := de.ErrorData().(string)
+	if !ok {
+		return nil, false
+	}
+	data, decErr := hexutil.Decode(s)
+	if decErr != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// decodeRevert renders a revert payload as Name(args...), matching the
+// selector against Error(string), Panic(uint256) and the ABI's custom errors.
+func decodeRevert(cABI abi.ABI, data []byte) string {
+	if len(data) < 4 {
+		return "revert (no data)"
+	}
+	sel := data[:4]
+	switch {
+	case bytes.Equal(sel, errorStringSelector):
+		if msg, err := abi.UnpackRevert(data); err == nil {
+			return fmt.Sprintf("Error(%q)", msg)
+		}
+	case bytes.Equal(sel, panicSelector):
+		if len(data) >= 36 {
+			return fmt.Sprintf("Panic(0x%x)", new(big.Int).SetBytes(data[4:36]))
+		}
+	}
+	for _, e := range cABI.Errors {
+		if !bytes.Equal(sel, e.ID[:4]) {
+			continue
+		}
+		vals, err := e.Unpack(data)
+		if err != nil {
+			return fmt.Sprintf("%s(<undecodable: %v>)", e.Name, err)
+		}
+		args, _ := vals.([]interface{})
+		parts := make([]string, 0, len(args))
+		for i, v := range args {
+			if i < len(e.Inputs) && e.Inputs[i].Name != "" {
+				parts = append(parts, fmt.Sprintf("%s=%s", e.Inputs[i].Name, formatValue(v)))
+			} else {
+				parts = append(parts, formatValue(v))
+			}
+		}
+		return fmt.Sprintf("%s(%s)", e.Name, strings.Join(parts, ", "))
+	}
+	return fmt.Sprintf("unknown error %s", hexutil.Encode(data))
+}
+
+// viewRevertError is returned by callView when a view call reverts.
+type viewRevertError struct {
+	Method string
+	Reason string
+	Err    error
+}
+
+func (e *viewRevertError) Error() string {
+	return fmt.Sprintf("%s(): revert %s", e.Method, e.Reason)
+}
+
+func (e *viewRevertError) Unwrap() error { return e.Err }
+
+// wrapCallError turns a failed eth_call into a viewRevertError when the node
+// returned revert data, and into a plain wrapped error otherwise.
+func wrapCallError(cABI abi.ABI, method string, err error) error {
+	if reason, ok := describeRevert(cABI, err); ok {
+		return &viewRevertError{Method: method, Reason: reason, Err: err}
+	}
+	return fmt.Errorf("call %s: %w", method, err)
+}
+
+// describeRevert returns the decoded revert reason carried by err, or false
+// if err has no revert payload.
+func describeRevert(cABI abi.ABI, err error) (string, bool) {
+	data, ok := revertData(err)
+	if !ok {
+		return "", false
+	}
+	return decodeRevert(cABI, data), true
+}
+
+func formatValue(v interface{}) string {
+	switch x := v.(type) {
+	case *big.Int:
+		return x.String()
+	case common.Address:
+		return x.Hex()
+	case []byte:
+		return hexutil.Encode(x)
+	case [32]byte:
+		return hexutil.Encode(x[:])
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
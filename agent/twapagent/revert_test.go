@@ -0,0 +1,109 @@
+package twapagent
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const testErrorsABI = `[
+	{"type":"error","name":"NotInitialized","inputs":[]},
+	{"type":"error","name":"SlippageExceeded","inputs":[
+		{"name":"minOut","type":"uint256"},
+		{"name":"amountOut","type":"uint256"}
+	]}
+]`
+
+// rpcDataError mimics the error ethclient returns for a reverted eth_call.
+type rpcDataError struct{ data string }
+
+func (e rpcDataError) Error() string          { return "execution reverted" }
+func (e rpcDataError) ErrorData() interface{} { return e.data }
+
+func mustABI(t *testing.T, s string) abi.ABI {
+	t.Helper()
+	parsed, err := abi.JSON(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("parse abi: %v", err)
+	}
+	return parsed
+}
+
+func customErrorPayload(t *testing.T, cABI abi.ABI, name string, args ...interface{}) []byte {
+	t.Helper()
+	e := cABI.Errors[name]
+	packed, err := e.Inputs.Pack(args...)
+	if err != nil {
+		t.Fatalf("pack %s: %v", name, err)
+	}
+	return append(append([]byte{}, e.ID[:4]...), packed...)
+}
+
+func errorStringPayload(t *testing.T, msg string) []byte {
+	t.Helper()
+	typ, _ := abi.NewType("string", "", nil)
+	packed, err := abi.Arguments{{Type: typ}}.Pack(msg)
+	if err != nil {
+		t.Fatalf("pack Error(string): %v", err)
+	}
+	return append(append([]byte{}, errorStringSelector...), packed...)
+}
+
+func TestDecodeRevert(t *testing.T) {
+	cABI := mustABI(t, testErrorsABI)
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"custom no args", customErrorPayload(t, cABI, "NotInitialized"), "NotInitialized()"},
+		{"custom with args", customErrorPayload(t, cABI, "SlippageExceeded", big.NewInt(100), big.NewInt(99)), "SlippageExceeded(minOut=100, amountOut=99)"},
+		{"error string", errorStringPayload(t, "TOO_EARLY"), `Error("TOO_EARLY")`},
+		{"panic", append(append([]byte{}, panicSelector...), uint256Word(0x11)...), "Panic(0x11)"},
+		{"unknown selector", []byte{0xde, 0xad, 0xbe, 0xef}, "unknown error 0xdeadbeef"},
+		{"empty", nil, "revert (no data)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeRevert(cABI, tt.data); got != tt.want {
+				t.Fatalf("decodeRevert = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrapCallError(t *testing.T) {
+	cABI := mustABI(t, testErrorsABI)
+
+	rpcErr := rpcDataError{data: hexutil.Encode(customErrorPayload(t, cABI, "NotInitialized"))}
+	err := wrapCallError(cABI, "strategy", fmt.Errorf("wrapped: %w", rpcErr))
+	if got, want := err.Error(), "strategy(): revert NotInitialized()"; got != want {
+		t.Fatalf("error = %q, want %q", got, want)
+	}
+	var vr *viewRevertError
+	if !errors.As(err, &vr) || vr.Method != "strategy" {
+		t.Fatalf("expected viewRevertError for strategy, got %#v", err)
+	}
+
+	rpcErr = rpcDataError{data: hexutil.Encode(errorStringPayload(t, "AGENT"))}
+	if got, want := wrapCallError(cABI, "status", rpcErr).Error(), `status(): revert Error("AGENT")`; got != want {
+		t.Fatalf("error = %q, want %q", got, want)
+	}
+
+	plain := errors.New("connection refused")
+	err = wrapCallError(cABI, "status", plain)
+	if !errors.Is(err, plain) || errors.As(err, &vr) {
+		t.Fatalf("plain errors must be wrapped as-is, got %v", err)
+	}
+}
+
+func uint256Word(v int64) []byte {
+	out := make([]byte, 32)
+	big.NewInt(v).FillBytes(out)
+	return out
+}
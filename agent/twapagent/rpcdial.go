@@ -0,0 +1,72 @@
+package twapagent
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// RPCAuthConfig is the extra request headers sent to --rpc and --tx-rpc, for
+// nodes behind an authenticating proxy. Values may be secrets: errors and
+// logs only ever name the header.
+type RPCAuthConfig struct {
+	Headers     []string // key=value
+	BearerToken string
+	BasicAuth   string // user:password
+}
+
+// headers validates the config and builds the header set.
+func (c RPCAuthConfig) headers() (http.Header, error) {
+	h := make(http.Header)
+	for i, kv := range c.Headers {
+		k, v, ok := strings.Cut(kv, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			// Not even the key: a malformed entry may be a pasted secret.
+			return nil, fmt.Errorf("--rpc-header #%d is not key=value", i+1)
+		}
+		h.Set(k, v)
+	}
+	if c.BearerToken != "" && c.BasicAuth != "" {
+		return nil, fmt.Errorf("--rpc-bearer-token and --rpc-basic-auth are mutually exclusive")
+	}
+	if (c.BearerToken != "" || c.BasicAuth != "") && h.Get("Authorization") != "" {
+		return nil, fmt.Errorf("--rpc-header Authorization conflicts with --rpc-bearer-token / --rpc-basic-auth")
+	}
+	if c.BearerToken != "" {
+		h.Set("Authorization", "Bearer "+c.BearerToken)
+	}
+	if c.BasicAuth != "" {
+		if !strings.Contains(c.BasicAuth, ":") {
+			return nil, fmt.Errorf("--rpc-basic-auth must be user:password")
+		}
+		h.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(c.BasicAuth)))
+	}
+	return h, nil
+}
+
+// headerNames lists h's keys for logging.
+func headerNames(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for k := range h {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// dialRPC connects to rawurl sending headers with every HTTP request and the
+// websocket handshake. The raw client is returned too, for batched reads.
+func dialRPC(ctx context.Context, rawurl string, headers http.Header) (*ethclient.Client, *rpc.Client, error) {
+	rc, err := rpc.DialOptions(ctx, rawurl, rpc.WithHeaders(headers))
+	if err != nil {
+		return nil, nil, err
+	}
+	return ethclient.NewClient(rc), rc, nil
+}
@@ -0,0 +1,71 @@
+package twapagent
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRPCAuthHeadersReachServer(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":"0x7a69"}`)
+	}))
+	defer srv.Close()
+
+	h, err := RPCAuthConfig{Headers: []string{"X-Api-Key=k3y"}, BasicAuth: "agent:s3cret"}.headers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _, err := dialRPC(context.Background(), srv.URL, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.ChainID(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("X-Api-Key") != "k3y" {
+		t.Errorf("X-Api-Key = %q", got.Get("X-Api-Key"))
+	}
+	if got.Get("Authorization") != "Basic YWdlbnQ6czNjcmV0" {
+		t.Errorf("Authorization = %q", got.Get("Authorization"))
+	}
+	if names := headerNames(h); names != "Authorization, X-Api-Key" {
+		t.Errorf("headerNames = %q", names)
+	}
+}
+
+func TestRPCAuthErrorsHideValues(t *testing.T) {
+	for _, cfg := range []RPCAuthConfig{
+		{Headers: []string{"supersecret"}},
+		{BearerToken: "supersecret", BasicAuth: "u:supersecret"},
+		{BasicAuth: "supersecret"},
+		{Headers: []string{"Authorization=supersecret"}, BearerToken: "supersecret"},
+	} {
+		_, err := cfg.headers()
+		if err == nil {
+			t.Errorf("%d headers: no error", len(cfg.Headers))
+			continue
+		}
+		if strings.Contains(err.Error(), "supersecret") {
+			t.Errorf("error leaks the secret: %v", err)
+		}
+	}
+}
+
+func TestRPCAuthBearer(t *testing.T) {
+	h, err := RPCAuthConfig{BearerToken: "tok"}.headers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Get("Authorization") != "Bearer tok" {
+		t.Fatalf("Authorization = %q", h.Get("Authorization"))
+	}
+}
@@ -0,0 +1,276 @@
+package twapagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// SafeConfig holds the flags for propose mode.
+type SafeConfig struct {
+	Address    string // --safe-address
+	ServiceURL string // --safe-service-url
+	Call       string // --propose-call: executeSlice or cancel
+}
+
+// EIP-712 type hashes used by Safe >= 1.3.
+var (
+	safeDomainTypeHash = crypto.Keccak256Hash([]byte("EIP712Domain(uint256 chainId,address verifyingContract)"))
+	safeTxTypeHash     = crypto.Keccak256Hash([]byte("SafeTx(address to,uint256 value,bytes data,uint8 operation,uint256 safeTxGas,uint256 baseGas,uint256 gasPrice,address gasToken,address refundReceiver,uint256 nonce)"))
+)
+
+// safeTxHash is the hash Safe owners sign for a plain CALL with no gas
+// refund, matching Safe.getTransactionHash.
+func safeTxHash(chainID uint64, safe, to common.Address, data []byte, nonce uint64) common.Hash {
+	word := func(v *big.Int) []byte { return common.LeftPadBytes(v.Bytes(), 32) }
+	zero := new(big.Int)
+	domain := crypto.Keccak256(
+		safeDomainTypeHash.Bytes(),
+		word(new(big.Int).SetUint64(chainID)),
+		common.LeftPadBytes(safe.Bytes(), 32),
+	)
+	structHash := crypto.Keccak256(
+		safeTxTypeHash.Bytes(),
+		common.LeftPadBytes(to.Bytes(), 32),
+		word(zero),                   // value
+		crypto.Keccak256(data),       // keccak(data)
+		word(zero),                   // operation: CALL
+		word(zero),                   // safeTxGas
+		word(zero),                   // baseGas
+		word(zero),                   // gasPrice
+		common.LeftPadBytes(nil, 32), // gasToken
+		common.LeftPadBytes(nil, 32), // refundReceiver
+		word(new(big.Int).SetUint64(nonce)),
+	)
+	return crypto.Keccak256Hash([]byte{0x19, 0x01}, domain, structHash)
+}
+
+// safeNumber accepts the service's nonces whether encoded as numbers or strings.
+type safeNumber uint64
+
+func (n *safeNumber) UnmarshalJSON(b []byte) error {
+	v, err := strconv.ParseUint(strings.Trim(string(b), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("safe nonce %s: %w", b, err)
+	}
+	*n = safeNumber(v)
+	return nil
+}
+
+type safeInfo struct {
+	Nonce     safeNumber       `json:"nonce"`
+	Threshold int              `json:"threshold"`
+	Owners    []common.Address `json:"owners"`
+}
+
+type safeMultisigTx struct {
+	To         common.Address `json:"to"`
+	Data       *hexutil.Bytes `json:"data"`
+	Nonce      safeNumber     `json:"nonce"`
+	SafeTxHash common.Hash    `json:"safeTxHash"`
+}
+
+// safeService is a minimal Safe Transaction Service API client.
+type safeService struct {
+	base string
+	safe common.Address
+	http *http.Client
+}
+
+func newSafeService(base string, safe common.Address) *safeService {
+	return &safeService{base: strings.TrimSuffix(base, "/"), safe: safe, http: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (s *safeService) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.base+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("safe service %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("safe service %s %s: %w", method, path, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("safe service %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (s *safeService) info(ctx context.Context) (safeInfo, error) {
+	var out safeInfo
+	err := s.do(ctx, http.MethodGet, "/api/v1/safes/"+s.safe.Hex()+"/", nil, &out)
+	return out, err
+}
+
+// queued lists proposals that are not executed yet and not made stale by the
+// on-chain nonce.
+func (s *safeService) queued(ctx context.Context, nonce uint64) ([]safeMultisigTx, error) {
+	var out struct {
+		Results []safeMultisigTx `json:"results"`
+	}
+	path := fmt.Sprintf("/api/v1/safes/%s/multisig-transactions/?executed=false&nonce__gte=%d&limit=100", s.safe.Hex(), nonce)
+	err := s.do(ctx, http.MethodGet, path, nil, &out)
+	return out.Results, err
+}
+
+func (s *safeService) propose(ctx context.Context, to common.Address, data []byte, nonce uint64, hash common.Hash, sender common.Address, sig []byte) error {
+	zero := common.Address{}.Hex()
+	body := map[string]interface{}{
+		"to":                      to.Hex(),
+		"value":                   "0",
+		"data":                    hexutil.Encode(data),
+		"operation":               0,
+		"safeTxGas":               "0",
+		"baseGas":                 "0",
+		"gasPrice":                "0",
+		"gasToken":                zero,
+		"refundReceiver":          zero,
+		"nonce":                   nonce,
+		"contractTransactionHash": hash.Hex(),
+		"sender":                  sender.Hex(),
+		"signature":               hexutil.Encode(sig),
+		"origin":                  "twap-agent",
+	}
+	return s.do(ctx, http.MethodPost, "/api/v1/safes/"+s.safe.Hex()+"/multisig-transactions/", body, nil)
+}
+
+// safeChainPrefixes maps chain ids to the short names used in Safe{Wallet} URLs.
+var safeChainPrefixes = map[uint64]string{
+	1: "eth", 10: "oeth", 56: "bnb", 100: "gno", 137: "matic",
+	8453: "base", 42161: "arb1", 43114: "avax", 11155111: "sep",
+}
+
+func safeProposalURL(serviceURL string, chainID uint64, safe common.Address, hash common.Hash) string {
+	if prefix, ok := safeChainPrefixes[chainID]; ok {
+		return fmt.Sprintf("https://app.safe.global/transactions/tx?safe=%s:%s&id=multisig_%s_%s", prefix, safe.Hex(), safe.Hex(), hash.Hex())
+	}
+	return fmt.Sprintf("%s/api/v1/multisig-transactions/%s/", strings.TrimSuffix(serviceURL, "/"), hash.Hex())
+}
+
+// propose builds the executeSlice (next due slice) or cancel call, signs its
+// SafeTx hash with the agent key as a Safe owner, and posts it to the Safe
+// Transaction Service for the other owners to confirm.
+func propose(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, signer Signer, chainID uint64, cfg SafeConfig, txCfg TxConfig) error {
+	if cfg.Address == "" || cfg.ServiceURL == "" {
+		return errors.New("propose mode needs --safe-address and --safe-service-url")
+	}
+	if !common.IsHexAddress(cfg.Address) {
+		return fmt.Errorf("invalid --safe-address %q", cfg.Address)
+	}
+	if signer == nil {
+		return errors.New("propose mode needs the agent key of a Safe owner")
+	}
+	hs, ok := signer.(hashSigner)
+	if !ok {
+		return errors.New("the configured signer cannot sign Safe transaction hashes; use a local key or --kms-key-id")
+	}
+	safe := common.HexToAddress(cfg.Address)
+	if chainID == 0 {
+		id, err := client.ChainID(ctx)
+		if err != nil {
+			return fmt.Errorf("chain id: %w", err)
+		}
+		chainID = id.Uint64()
+	}
+
+	var data []byte
+	var what string
+	switch cfg.Call {
+	case "executeSlice":
+		next, err := findNextSlice(ctx, addr, cABI, client, txCfg.MaxScanSlices)
+		if err != nil {
+			return err
+		}
+		if err := next.checkDue(txCfg.Force); err != nil {
+			return err
+		}
+		data, err = cABI.Pack("executeSlice", big.NewInt(next.ID))
+		if err != nil {
+			return fmt.Errorf("pack executeSlice: %w", err)
+		}
+		what = fmt.Sprintf("executeSlice(%d)", next.ID)
+	case "cancel":
+		var err error
+		if data, err = cABI.Pack("cancel"); err != nil {
+			return fmt.Errorf("pack cancel: %w", err)
+		}
+		what = "cancel()"
+	default:
+		return fmt.Errorf("unknown --propose-call %q (want executeSlice or cancel)", cfg.Call)
+	}
+
+	svc := newSafeService(cfg.ServiceURL, safe)
+	info, err := svc.info(ctx)
+	if err != nil {
+		return err
+	}
+	sender := signer.Address()
+	isOwner := false
+	for _, o := range info.Owners {
+		isOwner = isOwner || o == sender
+	}
+	if !isOwner {
+		return fmt.Errorf("%s is not an owner of Safe %s", sender.Hex(), safe.Hex())
+	}
+
+	queued, err := svc.queued(ctx, uint64(info.Nonce))
+	if err != nil {
+		return err
+	}
+	nonce := uint64(info.Nonce)
+	for _, q := range queued {
+		if q.To == addr && q.Data != nil && bytes.Equal(*q.Data, data) {
+			fmt.Fprintf(outputFrom(ctx), "%s already proposed at Safe nonce %d: %s\n", what, q.Nonce, safeProposalURL(cfg.ServiceURL, chainID, safe, q.SafeTxHash))
+			return nil
+		}
+		if uint64(q.Nonce) >= nonce {
+			nonce = uint64(q.Nonce) + 1
+		}
+	}
+
+	hash := safeTxHash(chainID, safe, addr, data, nonce)
+	sig, err := hs.SignHash(ctx, hash.Bytes())
+	if err != nil {
+		return fmt.Errorf("sign safe tx: %w", err)
+	}
+	sig[64] += 27 // Safe expects v in {27, 28} for ECDSA owner signatures
+	if err := svc.propose(ctx, addr, data, nonce, hash, sender, sig); err != nil {
+		return err
+	}
+	fmt.Fprintf(outputFrom(ctx), "Proposed %s to Safe %s at nonce %d (%d/%d confirmations)\n", what, safe.Hex(), nonce, 1, info.Threshold)
+	fmt.Fprintf(outputFrom(ctx), "Proposal: %s\n", safeProposalURL(cfg.ServiceURL, chainID, safe, hash))
+	return nil
+}
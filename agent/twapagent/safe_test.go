@@ -0,0 +1,100 @@
+package twapagent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestSafeTypeHashes(t *testing.T) {
+	// Constants from Safe.sol (v1.3+).
+	if got := safeDomainTypeHash.Hex(); got != "0x47e79534a245952e8b16893a336b85a3d9ea9fa8c573f3d803afb92a79469218" {
+		t.Errorf("domain type hash = %s", got)
+	}
+	if got := safeTxTypeHash.Hex(); got != "0xbb8310d486368db6bd6f849402fdd73ad53d316b5a4b2644ad6efe0f941286d8" {
+		t.Errorf("SafeTx type hash = %s", got)
+	}
+}
+
+type fakeSafeService struct {
+	owner    common.Address
+	queued   string
+	proposed map[string]interface{}
+}
+
+func (f *fakeSafeService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/multisig-transactions/"):
+		w.Write([]byte(`{"results":` + f.queued + `}`))
+	case r.Method == http.MethodGet:
+		w.Write([]byte(`{"nonce":7,"threshold":2,"owners":["` + f.owner.Hex() + `"]}`))
+	case r.Method == http.MethodPost:
+		json.NewDecoder(r.Body).Decode(&f.proposed)
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+const cancelABI = `[{"type":"function","name":"cancel","stateMutability":"nonpayable","inputs":[],"outputs":[]}]`
+
+func TestProposeCancel(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	signer := newKeySigner(key)
+	vault := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	safe := common.HexToAddress("0x00000000000000000000000000000000000005af")
+	// Another proposal already queued at nonce 7, so ours goes to 8.
+	svc := &fakeSafeService{owner: signer.Address(), queued: `[{"to":"` + vault.Hex() + `","data":"0x1234","nonce":"7","safeTxHash":"0x` + strings.Repeat("11", 32) + `"}]`}
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	cABI := mustABI(t, cancelABI)
+	cfg := SafeConfig{Address: safe.Hex(), ServiceURL: srv.URL, Call: "cancel"}
+	if err := propose(context.Background(), vault, cABI, nil, signer, 1, cfg, TxConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if svc.proposed == nil {
+		t.Fatal("nothing proposed")
+	}
+	if n := svc.proposed["nonce"].(float64); n != 8 {
+		t.Fatalf("nonce = %v, want 8", n)
+	}
+	data, _ := cABI.Pack("cancel")
+	hash := safeTxHash(1, safe, vault, data, 8)
+	if got := svc.proposed["contractTransactionHash"]; got != hash.Hex() {
+		t.Fatalf("hash = %v, want %s", got, hash.Hex())
+	}
+	sig := hexutil.MustDecode(svc.proposed["signature"].(string))
+	if sig[64] != 27 && sig[64] != 28 {
+		t.Fatalf("v = %d, want 27 or 28", sig[64])
+	}
+	sig[64] -= 27
+	pub, err := crypto.SigToPub(hash.Bytes(), sig)
+	if err != nil || crypto.PubkeyToAddress(*pub) != signer.Address() {
+		t.Fatalf("signature does not recover to the owner (err %v)", err)
+	}
+}
+
+func TestProposeSkipsExistingProposal(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	signer := newKeySigner(key)
+	vault := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	cABI := mustABI(t, cancelABI)
+	data, _ := cABI.Pack("cancel")
+	svc := &fakeSafeService{owner: signer.Address(), queued: `[{"to":"` + vault.Hex() + `","data":"` + hexutil.Encode(data) + `","nonce":7,"safeTxHash":"0x` + strings.Repeat("22", 32) + `"}]`}
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	cfg := SafeConfig{Address: "0x00000000000000000000000000000000000005af", ServiceURL: srv.URL, Call: "cancel"}
+	if err := propose(context.Background(), vault, cABI, nil, signer, 1, cfg, TxConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if svc.proposed != nil {
+		t.Fatalf("proposed a duplicate: %v", svc.proposed)
+	}
+}
@@ -0,0 +1,67 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// sliceCount converts totalSlices to an int64, the range the agent indexes
+// slices with.
+func sliceCount(n *big.Int) (int64, error) {
+	if n == nil || n.Sign() < 0 || !n.IsInt64() {
+		return 0, fmt.Errorf("totalSlices %s is out of range", n)
+	}
+	return n.Int64(), nil
+}
+
+// expectedFirstUndone is the number of executed slices implied by filled:
+// every execution but the last fills exactly sliceAmountIn, so it is
+// ceil(filled / sliceAmountIn). If slices ran in order it is also the index
+// of the first open one.
+func expectedFirstUndone(s Strategy, filled *big.Int, n int64) int64 {
+	if filled == nil || s.SliceAmountIn == nil || s.SliceAmountIn.Sign() <= 0 || filled.Sign() <= 0 {
+		return 0
+	}
+	g := new(big.Int).Add(filled, s.SliceAmountIn)
+	g.Sub(g, big.NewInt(1)).Div(g, s.SliceAmountIn)
+	if !g.IsInt64() || g.Int64() > n {
+		return n
+	}
+	return g.Int64()
+}
+
+// sliceScan is the outcome of scanFirstUndone.
+type sliceScan struct {
+	First   int64 // lowest open slice found, -1 if none
+	Checked int64 // sliceDone reads made
+	Limited bool  // the read limit stopped the scan before it was conclusive
+}
+
+// scanFirstUndone finds the lowest slice below n that sliceDone() reports
+// open, reading at most max slices (0 = no limit). It reads from slice 0 up:
+// the filled amount says how many slices ran but not which, and --slice or
+// fills out of order leave open slices below that count.
+func scanFirstUndone(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, n, max int64) (sliceScan, error) {
+	r := sliceScan{First: -1}
+	for i := int64(0); i < n; i++ {
+		if max > 0 && r.Checked >= max {
+			r.Limited = true
+			return r, nil
+		}
+		done, err := readSliceDone(ctx, addr, cABI, client, big.NewInt(i))
+		if err != nil {
+			return r, fmt.Errorf("sliceDone(%d): %w", i, err)
+		}
+		r.Checked++
+		if !done {
+			r.First = i
+			return r, nil
+		}
+	}
+	return r, nil
+}
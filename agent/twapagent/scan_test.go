@@ -0,0 +1,52 @@
+package twapagent
+
+import (
+	"context"
+	"math/big"
+	"testing"
+)
+
+func TestScanFirstUndone(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name         string
+		total, done  int64
+		doneSet      map[int64]bool
+		max          int64
+		first, reads int64
+		limited      bool
+	}{
+		{"in order", 24, 20, nil, 0, 20, 21, false},
+		{"nothing done", 24, 0, nil, 0, 0, 1, false},
+		{"all done", 24, 24, nil, 0, -1, 24, false},
+		{"limit", 24, 20, nil, 5, -1, 5, true},
+		// Slices 1 and 3 done: the filled amount says two ran, but slice
+		// 0 is the first open one.
+		{"out of order", 4, 0, map[int64]bool{1: true, 3: true}, 0, 0, 1, false},
+		// Every slice but 0: the order isn't done while slice 0 is open.
+		{"all but the first", 4, 0, map[int64]bool{1: true, 2: true, 3: true}, 0, 0, 1, false},
+		{"gap", 4, 0, map[int64]bool{0: true, 1: true, 3: true}, 0, 2, 3, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v, twap, cABI, client, _ := newVaultRPC(t, tc.total, tc.done)
+			v.doneSet = tc.doneSet
+			got, err := scanFirstUndone(ctx, twap.Address(), cABI, client, tc.total, tc.max)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.First != tc.first || got.Checked != tc.reads || got.Limited != tc.limited {
+				t.Fatalf("scan = %+v, want first %d after %d reads (limited %v)", got, tc.first, tc.reads, tc.limited)
+			}
+		})
+	}
+}
+
+func TestSliceCount(t *testing.T) {
+	if n, err := sliceCount(big.NewInt(288)); err != nil || n != 288 {
+		t.Fatalf("sliceCount(288) = %d, %v", n, err)
+	}
+	huge := new(big.Int).Lsh(big.NewInt(1), 70)
+	if _, err := sliceCount(huge); err == nil {
+		t.Fatal("sliceCount accepted 2^70")
+	}
+}
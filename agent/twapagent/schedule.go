@@ -0,0 +1,27 @@
+package twapagent
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// errNotInitialized means the vault has no strategy yet: totalSlices() is 0
+// until the owner calls configureStrategy.
+var errNotInitialized = errors.New("order not initialized yet, waiting for configureStrategy")
+
+// sliceScheduledAt is slice id's earliest execution time as executeSlice
+// computes it: startTime + id * ((endTime - startTime) / n). When the window
+// is shorter than n seconds the interval is 0 and every slice is due at
+// startTime.
+func sliceScheduledAt(s Strategy, n, id int64) (*big.Int, error) {
+	if n <= 0 || s.StartTime == nil || s.EndTime == nil {
+		return nil, errNotInitialized
+	}
+	window := new(big.Int).Sub(s.EndTime, s.StartTime)
+	if window.Sign() < 0 {
+		return nil, fmt.Errorf("strategy window ends (%s) before it starts (%s)", s.EndTime, s.StartTime)
+	}
+	interval := window.Div(window, big.NewInt(n))
+	return interval.Mul(interval, big.NewInt(id)).Add(interval, s.StartTime), nil
+}
@@ -0,0 +1,45 @@
+package twapagent
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestSliceScheduledAt(t *testing.T) {
+	s := Strategy{StartTime: big.NewInt(1_000), EndTime: big.NewInt(2_000)}
+	for _, tc := range []struct {
+		n, id int64
+		want  int64
+	}{
+		{4, 0, 1_000},
+		{4, 3, 1_750},
+		{3, 2, 1_666}, // interval floors to 333
+		{5_000, 4_999, 1_000},
+	} {
+		got, err := sliceScheduledAt(s, tc.n, tc.id)
+		if err != nil || got.Int64() != tc.want {
+			t.Errorf("slice %d of %d: %v, %v; want %d", tc.id, tc.n, got, err, tc.want)
+		}
+	}
+}
+
+func TestSliceScheduledAtStartEqualsEnd(t *testing.T) {
+	s := Strategy{StartTime: big.NewInt(1_000), EndTime: big.NewInt(1_000)}
+	for id := int64(0); id < 3; id++ {
+		got, err := sliceScheduledAt(s, 3, id)
+		if err != nil || got.Int64() != 1_000 {
+			t.Fatalf("slice %d: %v, %v; want every slice at start", id, got, err)
+		}
+	}
+}
+
+func TestSliceScheduledAtNotInitialized(t *testing.T) {
+	s := Strategy{StartTime: big.NewInt(1_000), EndTime: big.NewInt(2_000)}
+	if _, err := sliceScheduledAt(s, 0, 0); !errors.Is(err, errNotInitialized) {
+		t.Fatalf("N=0: err = %v, want errNotInitialized", err)
+	}
+	if _, err := sliceScheduledAt(Strategy{}, 4, 0); !errors.Is(err, errNotInitialized) {
+		t.Fatalf("zero strategy: err = %v, want errNotInitialized", err)
+	}
+}
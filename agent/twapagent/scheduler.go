@@ -0,0 +1,137 @@
+package twapagent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// --driver values.
+const (
+	driverTimer  = "timer"
+	driverBlocks = "blocks"
+)
+
+// timerRecheck is how soon the timer driver looks again at a slice that is
+// already due but wasn't submitted: in flight, backing off, or deferred for gas.
+const timerRecheck = 15 * time.Second
+
+// DriverConfig picks what makes bot mode evaluate the vault.
+type DriverConfig struct {
+	// driverTimer wakes up when the next slice is due, driverBlocks on every head.
+	Driver string
+	// The timer driver wakes this long before a slice is scheduled.
+	LeadTime time.Duration
+}
+
+func (c DriverConfig) validate() error {
+	switch c.Driver {
+	case driverTimer, driverBlocks:
+	default:
+		return fmt.Errorf("invalid --driver %q (want %s or %s)", c.Driver, driverTimer, driverBlocks)
+	}
+	if c.LeadTime < 0 {
+		return fmt.Errorf("--lead-time must not be negative")
+	}
+	return nil
+}
+
+// slotTimer is the timer driver's alarm. Slice times are fixed by the
+// strategy, so it sleeps until the next open slice is due rather than
+// evaluating every head. Heads only move its estimate of chain time, which
+// can run ahead of or behind the wall clock.
+type slotTimer struct {
+	lead     time.Duration
+	timer    *time.Timer
+	headTime uint64    // latest block timestamp seen
+	headAt   time.Time // wall clock when it was seen
+	lastEval time.Time
+}
+
+func newSlotTimer(lead time.Duration) *slotTimer {
+	// Fires at once: the first evaluation happens at startup.
+	return &slotTimer{lead: lead, timer: time.NewTimer(0)}
+}
+
+// C fires when the bot should evaluate the vault.
+func (t *slotTimer) C() <-chan time.Time { return t.timer.C }
+
+// noteHead records the chain time of h.
+func (t *slotTimer) noteHead(h *types.Header, now time.Time) {
+	if h == nil || h.Time < t.headTime {
+		return
+	}
+	t.headTime, t.headAt = h.Time, now
+}
+
+// evaluated records that the vault was just evaluated.
+func (t *slotTimer) evaluated(now time.Time) { t.lastEval = now }
+
+// chainNow estimates the current block time: the latest head's timestamp
+// plus the wall-clock time since it arrived.
+func (t *slotTimer) chainNow(now time.Time) int64 {
+	if t.headAt.IsZero() {
+		return now.Unix()
+	}
+	return int64(t.headTime) + int64(now.Sub(t.headAt)/time.Second)
+}
+
+// wait is how long to sleep before the next evaluation; ok is false when no
+// slice is left to wait for (a reconfiguration event resets the timer).
+func (t *slotTimer) wait(st *botState, now time.Time) (d time.Duration, ok bool) {
+	s, N := st.strategy.Cached()
+	n, err := sliceCount(N)
+	if err != nil || n == 0 {
+		return 0, false
+	}
+	if !st.done.Loaded(n) {
+		return timerRecheck, true // the next evaluation reloads it
+	}
+	first := st.done.FirstUndone(st.failures.GaveUp)
+	if first < 0 {
+		return 0, false
+	}
+	scheduled, err := sliceScheduledAt(s, n, first)
+	if err != nil || !scheduled.IsInt64() {
+		return 0, false
+	}
+	due := time.Duration(scheduled.Int64()-t.chainNow(now)) * time.Second
+	switch {
+	case due > t.lead:
+		return due - t.lead, true
+	case due > 0:
+		// Inside the lead window but the chain isn't there yet.
+		if due < time.Second {
+			due = time.Second
+		}
+		return due, true
+	}
+	if d = timerRecheck - now.Sub(t.lastEval); d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+// reschedule arms the timer for the next evaluation, or disarms it.
+func (t *slotTimer) reschedule(st *botState, now time.Time) {
+	t.stop()
+	if d, ok := t.wait(st, now); ok {
+		t.timer.Reset(d)
+	}
+}
+
+// fireNow makes the next evaluation happen immediately.
+func (t *slotTimer) fireNow() {
+	t.stop()
+	t.timer.Reset(0)
+}
+
+func (t *slotTimer) stop() {
+	if !t.timer.Stop() {
+		select {
+		case <-t.timer.C:
+		default:
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package twapagent
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func timerState(done []bool) *botState {
+	st := &botState{
+		strategy: &strategyCache{
+			s:     Strategy{StartTime: big.NewInt(1_000), EndTime: big.NewInt(2_000)},
+			total: big.NewInt(int64(len(done))),
+		},
+		failures: newFailureTracker(RetryConfig{}),
+	}
+	st.done.Load(done)
+	return st
+}
+
+func TestSlotTimerWait(t *testing.T) {
+	now := time.Unix(50_000, 0)
+	// Four slices 250s apart; slice 1 is next, scheduled at 1250.
+	st := timerState([]bool{true, false, false, false})
+	for _, tc := range []struct {
+		name     string
+		headTime uint64
+		headAge  time.Duration
+		lastEval time.Duration // ago; 0 = never
+		want     time.Duration
+	}{
+		{"sleep until the lead window", 1_000, 0, 0, 240 * time.Second},
+		{"wall clock ahead of the last head", 1_000, 30 * time.Second, 0, 210 * time.Second},
+		{"inside the lead window", 1_245, 0, 0, 5 * time.Second},
+		{"due, never evaluated", 1_300, 0, 0, 0},
+		{"due, evaluated recently", 1_300, 0, 5 * time.Second, timerRecheck - 5*time.Second},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tm := &slotTimer{lead: 10 * time.Second}
+			tm.noteHead(&types.Header{Time: tc.headTime}, now.Add(-tc.headAge))
+			if tc.lastEval > 0 {
+				tm.evaluated(now.Add(-tc.lastEval))
+			}
+			got, ok := tm.wait(st, now)
+			if !ok || got != tc.want {
+				t.Fatalf("wait = %s, %v; want %s", got, ok, tc.want)
+			}
+		})
+	}
+}
+
+func TestSlotTimerNothingToWaitFor(t *testing.T) {
+	tm := &slotTimer{}
+	if _, ok := tm.wait(timerState([]bool{true, true}), time.Now()); ok {
+		t.Fatal("armed with every slice done")
+	}
+	if _, ok := tm.wait(timerState(nil), time.Now()); ok {
+		t.Fatal("armed for an uninitialized order")
+	}
+}
+
+func TestDriverConfigValidate(t *testing.T) {
+	for _, c := range []DriverConfig{{Driver: driverTimer}, {Driver: driverBlocks, LeadTime: time.Second}} {
+		if err := c.validate(); err != nil {
+			t.Errorf("%+v: %v", c, err)
+		}
+	}
+	for _, c := range []DriverConfig{{Driver: "cron"}, {Driver: driverTimer, LeadTime: -time.Second}} {
+		if err := c.validate(); err == nil {
+			t.Errorf("%+v accepted", c)
+		}
+	}
+}
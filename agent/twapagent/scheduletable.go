@@ -0,0 +1,185 @@
+package twapagent
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// scheduledSlice is one row of schedule mode's table.
+type scheduledSlice struct {
+	Slice     int64  `json:"slice"`
+	Scheduled uint64 `json:"scheduledAt"`
+	AmountIn  string `json:"amountIn"`
+	Done      bool   `json:"done"`
+	// Seconds past its schedule for a pending slice that is due, else 0.
+	Overdue uint64 `json:"overdueSeconds"`
+	// Scheduled after endTime, which the contract allows but the window
+	// doesn't intend.
+	AfterEnd bool `json:"afterEnd"`
+}
+
+// scheduleTable is schedule mode's output.
+type scheduleTable struct {
+	Now         uint64           `json:"now"`
+	TotalSlices int64            `json:"totalSlices"`
+	Interval    string           `json:"intervalSeconds"`
+	Slices      []scheduledSlice `json:"slices"`
+}
+
+// sliceAmountAt is what slice id swaps when the slices before it filled in
+// full: sliceAmountIn, or the remainder for the last one.
+func sliceAmountAt(s Strategy, id int64) *big.Int {
+	if s.TotalAmountIn == nil || s.SliceAmountIn == nil {
+		return new(big.Int)
+	}
+	left := new(big.Int).Mul(s.SliceAmountIn, big.NewInt(id))
+	left.Sub(s.TotalAmountIn, left)
+	switch {
+	case left.Sign() < 0:
+		return new(big.Int)
+	case left.Cmp(s.SliceAmountIn) > 0:
+		return new(big.Int).Set(s.SliceAmountIn)
+	}
+	return left
+}
+
+// buildSchedule lists count slices of s from slice first on (count 0 means
+// all), as of block time now. done reports executed slices.
+func buildSchedule(s Strategy, n, first, count int64, now uint64, done func(int64) bool) (scheduleTable, error) {
+	t := scheduleTable{Now: now, TotalSlices: n, Slices: []scheduledSlice{}}
+	if n > 1 {
+		second, err := sliceScheduledAt(s, n, 1)
+		if err != nil {
+			return t, err
+		}
+		t.Interval = new(big.Int).Sub(second, s.StartTime).String()
+	} else {
+		t.Interval = "0"
+	}
+	last := n
+	if count > 0 && first+count < n {
+		last = first + count
+	}
+	for id := first; id < last; id++ {
+		at, err := sliceScheduledAt(s, n, id)
+		if err != nil {
+			return t, err
+		}
+		row := scheduledSlice{
+			Slice:     id,
+			Scheduled: at.Uint64(),
+			AmountIn:  sliceAmountAt(s, id).String(),
+			Done:      done(id),
+			AfterEnd:  at.Cmp(s.EndTime) > 0,
+		}
+		if !row.Done && now >= row.Scheduled {
+			row.Overdue = now - row.Scheduled
+		}
+		t.Slices = append(t.Slices, row)
+	}
+	return t, nil
+}
+
+func (t scheduleTable) writeText(w io.Writer) error {
+	fmt.Fprintf(w, "%d slices, one every %ss; as of %s\n", t.TotalSlices, t.Interval, unixUTC(t.Now))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "slice\tscheduled\ttime\tamountIn\tstate\t")
+	for _, r := range t.Slices {
+		state := "pending"
+		switch {
+		case r.Done:
+			state = "done"
+		case r.Overdue > 0:
+			state = "overdue by " + (time.Duration(r.Overdue) * time.Second).String()
+		case t.Now >= r.Scheduled:
+			state = "due"
+		}
+		if r.AfterEnd {
+			state += " (after endTime)"
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t\n", r.Slice, r.Scheduled, unixUTC(r.Scheduled), r.AmountIn, state)
+	}
+	return tw.Flush()
+}
+
+func (t scheduleTable) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"slice", "scheduled_at", "scheduled_time", "amount_in", "done", "overdue_seconds", "after_end"})
+	for _, r := range t.Slices {
+		cw.Write([]string{strconv.FormatInt(r.Slice, 10), strconv.FormatUint(r.Scheduled, 10), unixUTC(r.Scheduled), r.AmountIn,
+			strconv.FormatBool(r.Done), strconv.FormatUint(r.Overdue, 10), strconv.FormatBool(r.AfterEnd)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (t scheduleTable) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(t)
+}
+
+// schedule is schedule mode: a table of the next count slices from the first
+// one not done, with their schedule, amount and state, read from the chain.
+func schedule(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, rc *rpc.Client, count int64, format, outPath string) error {
+	N, err := readTotalSlices(ctx, addr, cABI, client)
+	if err != nil {
+		return fmt.Errorf("read totalSlices: %w", err)
+	}
+	n, err := sliceCount(N)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errNotInitialized
+	}
+	s, err := readStrategy(ctx, addr, cABI, client)
+	if err != nil {
+		return fmt.Errorf("read strategy: %w", err)
+	}
+	var done sliceBitmap
+	if err := loadSliceBitmap(ctx, &done, addr, cABI, client, rc, false, n); err != nil {
+		return fmt.Errorf("load sliceDone: %w", err)
+	}
+	head, err := headerByNumber(ctx, client, nil)
+	if err != nil {
+		return fmt.Errorf("latest header: %w", err)
+	}
+	first := done.FirstUndone(func(int64) bool { return false })
+	if first < 0 {
+		first = n // every slice is done: an empty table
+	}
+	t, err := buildSchedule(s, n, first, count, head.Time, done.Done)
+	if err != nil {
+		return err
+	}
+
+	out, err := createOutput(ctx, outPath)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case formatCSV:
+		err = t.writeCSV(out)
+	case formatJSON:
+		err = t.writeJSON(out)
+	default:
+		err = t.writeText(out)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
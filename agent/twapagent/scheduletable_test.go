@@ -0,0 +1,61 @@
+package twapagent
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestSliceAmountAt(t *testing.T) {
+	s := Strategy{TotalAmountIn: big.NewInt(1050), SliceAmountIn: big.NewInt(100)}
+	for id, want := range map[int64]int64{0: 100, 9: 100, 10: 50, 11: 0} {
+		if got := sliceAmountAt(s, id); got.Int64() != want {
+			t.Errorf("slice %d: got %s, want %d", id, got, want)
+		}
+	}
+}
+
+func TestBuildSchedule(t *testing.T) {
+	// 11 slices over 1000s: the interval rounds down to 90s.
+	s := Strategy{
+		TotalAmountIn: big.NewInt(1050), SliceAmountIn: big.NewInt(100),
+		StartTime: big.NewInt(1000), EndTime: big.NewInt(2000),
+	}
+	done := func(id int64) bool { return id == 3 }
+	tbl, err := buildSchedule(s, 11, 2, 3, 1200, done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tbl.Interval != "90" || len(tbl.Slices) != 3 {
+		t.Fatalf("got %+v", tbl)
+	}
+	// Slice 2 is due at 1180, 20s ago; 3 is done; 4 is at 1360.
+	if r := tbl.Slices[0]; r.Slice != 2 || r.Scheduled != 1180 || r.Overdue != 20 || r.Done {
+		t.Errorf("slice 2: %+v", r)
+	}
+	if r := tbl.Slices[1]; !r.Done || r.Overdue != 0 {
+		t.Errorf("slice 3: %+v", r)
+	}
+	if r := tbl.Slices[2]; r.Scheduled != 1360 || r.Overdue != 0 || r.AfterEnd {
+		t.Errorf("slice 4: %+v", r)
+	}
+
+	all, err := buildSchedule(s, 11, 0, 0, 1200, done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Slices) != 11 || all.Slices[10].AmountIn != "50" || all.Slices[10].Scheduled != 1900 {
+		t.Errorf("last slice: %+v", all.Slices[len(all.Slices)-1])
+	}
+
+	var buf bytes.Buffer
+	if err := tbl.writeText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"one every 90s", "overdue by 20s", "done"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("text lacks %q:\n%s", want, buf.String())
+		}
+	}
+}
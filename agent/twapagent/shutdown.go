@@ -0,0 +1,178 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// Exit codes after SIGINT or SIGTERM: nothing left behind, or an
+// executeSlice tx still unmined when the grace period ran out.
+const (
+	ExitInterrupted = 6
+	ExitPendingTx   = 7
+)
+
+// errInterrupted is returned by the long-running modes once SIGINT or
+// SIGTERM has stopped them.
+var errInterrupted = errors.New("interrupted")
+
+// pendingTx is an executeSlice tx left unmined at shutdown.
+type pendingTx struct {
+	Slice int64
+	Hash  common.Hash
+	// Nonce and From are unset when the node no longer knows the tx.
+	Nonce *uint64
+	From  common.Address
+}
+
+func (p pendingTx) String() string {
+	nonce := "unknown"
+	if p.Nonce != nil {
+		nonce = fmt.Sprint(*p.Nonce)
+	}
+	return fmt.Sprintf("slice %d tx %s nonce %s", p.Slice, p.Hash.Hex(), nonce)
+}
+
+// pendingTxError lists what an interrupted run left in the mempool.
+type pendingTxError struct {
+	Txs []pendingTx
+}
+
+func (e *pendingTxError) Error() string {
+	parts := make([]string, len(e.Txs))
+	for i, p := range e.Txs {
+		parts[i] = p.String()
+	}
+	return "interrupted with pending txs: " + strings.Join(parts, ", ")
+}
+
+// detachedContext keeps a context's values, the rpc limiter and the event
+// log, without its cancellation, like Go 1.21's context.WithoutCancel.
+type detachedContext struct{ context.Context }
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// shutdownLookupTimeout bounds each read drainSubmitted makes past the
+// grace period, so the hash and nonce can be printed with no grace at all.
+const shutdownLookupTimeout = 10 * time.Second
+
+// drainSubmitted runs once ctx is canceled. It waits for the slice
+// goroutines, then for up to txCfg.ShutdownGrace for the receipts of the
+// txs st still tracks, booking those that mine. It returns errInterrupted
+// when nothing is left pending, else a *pendingTxError after logging each
+// tx's hash and nonce for the operator.
+func drainSubmitted(ctx context.Context, addr common.Address, txCfg TxConfig, st *botState) error {
+	st.workers.Wait()
+	subs := st.submitted.All()
+	if len(subs) == 0 {
+		return errInterrupted
+	}
+	ids := make([]int64, 0, len(subs))
+	for id := range subs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	base, client := detachedContext{ctx}, st.txClient
+	deadline := time.Now().Add(txCfg.ShutdownGrace)
+	if txCfg.ShutdownGrace > 0 {
+		logf(ctx, "waiting up to %s for %d submitted slice tx(s)", txCfg.ShutdownGrace, len(ids))
+	}
+	poll := txCfg.ReceiptPollInterval
+	if poll <= 0 {
+		poll = time.Second
+	}
+	var left []pendingTx
+	for _, id := range ids {
+		p := pendingTx{Slice: id, Hash: subs[id].Hash}
+		tx := lookupTx(base, client, p.Hash)
+		if tx != nil {
+			nonce := tx.Nonce()
+			p.Nonce = &nonce
+			p.From, _ = types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+		}
+		if receipt := waitShutdownReceipt(base, client, p.Hash, deadline, poll); receipt != nil {
+			logf(ctx, "Slice %d tx %s mined during shutdown", id, p.Hash.Hex())
+			st.submitted.Clear(id)
+			var price *big.Int
+			if tx != nil {
+				price = tx.GasPrice()
+			}
+			finishSlice(base, addr, p.From, st, id, receipt, price)
+			continue
+		}
+		if p.Nonce != nil && nonceUsed(base, client, p.From, *p.Nonce) {
+			// A fee bump or cancel of it mined instead.
+			logf(ctx, "slice %d: nonce %d of tx %s has been used by a replacement", id, *p.Nonce, p.Hash.Hex())
+			continue
+		}
+		left = append(left, p)
+	}
+	if len(left) == 0 {
+		return errInterrupted
+	}
+	for _, p := range left {
+		errorf(ctx, "PENDING at shutdown: %s from %s", p, p.From.Hex())
+		emitTxFailed(base, p.Slice, p.Hash, "shutdown")
+	}
+	return &pendingTxError{Txs: left}
+}
+
+// lookupTx returns the tx with hash, or nil when the node doesn't know it.
+func lookupTx(ctx context.Context, client *ethclient.Client, hash common.Hash) *types.Transaction {
+	ctx, cancel := context.WithTimeout(ctx, shutdownLookupTimeout)
+	defer cancel()
+	var tx *types.Transaction
+	err := rpcRead(ctx, "eth_getTransactionByHash", func(ctx context.Context) (err error) {
+		tx, _, err = client.TransactionByHash(ctx, hash)
+		return err
+	})
+	if err != nil {
+		return nil
+	}
+	return tx
+}
+
+// nonceUsed reports whether from's latest nonce is past nonce.
+func nonceUsed(ctx context.Context, client *ethclient.Client, from common.Address, nonce uint64) bool {
+	ctx, cancel := context.WithTimeout(ctx, shutdownLookupTimeout)
+	defer cancel()
+	var latest uint64
+	err := rpcRead(ctx, "eth_getTransactionCount", func(ctx context.Context) (err error) {
+		latest, err = client.NonceAt(ctx, from, nil)
+		return err
+	})
+	return err == nil && latest > nonce
+}
+
+// waitShutdownReceipt polls for hash's receipt until deadline. It checks at
+// least once, so a tx that has already mined is found with no grace period.
+func waitShutdownReceipt(ctx context.Context, client *ethclient.Client, hash common.Hash, deadline time.Time, poll time.Duration) *types.Receipt {
+	for {
+		rctx, cancel := context.WithTimeout(ctx, shutdownLookupTimeout)
+		var receipt *types.Receipt
+		err := rpcRead(rctx, "eth_getTransactionReceipt", func(ctx context.Context) (err error) {
+			receipt, err = client.TransactionReceipt(ctx, hash)
+			return err
+		})
+		cancel()
+		if err == nil {
+			return receipt
+		}
+		if time.Until(deadline) < poll {
+			return nil
+		}
+		time.Sleep(poll)
+	}
+}
@@ -0,0 +1,45 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestDrainSubmittedNothingPending(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := drainSubmitted(ctx, common.Address{}, TxConfig{}, &botState{}); !errors.Is(err, errInterrupted) {
+		t.Fatalf("got %v, want errInterrupted", err)
+	}
+}
+
+func TestPendingTxError(t *testing.T) {
+	nonce := uint64(42)
+	err := &pendingTxError{Txs: []pendingTx{
+		{Slice: 3, Hash: common.HexToHash("0xaa"), Nonce: &nonce},
+		{Slice: 4, Hash: common.HexToHash("0xbb")},
+	}}
+	msg := err.Error()
+	for _, want := range []string{"slice 3", "nonce 42", "slice 4", "nonce unknown"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("%q lacks %q", msg, want)
+		}
+	}
+}
+
+func TestDetachedContext(t *testing.T) {
+	type key struct{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, 1))
+	cancel()
+	d := detachedContext{ctx}
+	if d.Err() != nil || d.Done() != nil {
+		t.Error("detached context should not be canceled")
+	}
+	if d.Value(key{}) != 1 {
+		t.Error("detached context should keep its values")
+	}
+}
@@ -0,0 +1,139 @@
+package twapagent
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// Signer is the agent's transaction signing backend. It is built once in
+// main() so a misconfigured key fails at startup rather than mid-run.
+type Signer interface {
+	Address() common.Address
+	TransactOpts(ctx context.Context, chainID uint64) (*bind.TransactOpts, error)
+}
+
+// SignerConfig collects the signer-related flags.
+type SignerConfig struct {
+	Keys      KeySource
+	KMSKeyID  string
+	KMSRegion string
+	RemoteURL string
+	From      string // --from; account to use with a remote signer
+}
+
+// buildSigners picks the signing backend from the flags: KMS or a remote
+// signer when configured, otherwise one signer per local key. It returns none
+// if nothing is configured.
+func buildSigners(ctx context.Context, cfg SignerConfig) ([]Signer, error) {
+	keys := cfg.Keys
+	var remote []string
+	if cfg.KMSKeyID != "" {
+		remote = append(remote, "--kms-key-id")
+	}
+	if cfg.RemoteURL != "" {
+		remote = append(remote, "--remote-signer-url")
+	}
+	local := keys.local()
+	switch {
+	case len(remote) > 1:
+		return nil, fmt.Errorf("%s are mutually exclusive; use one", strings.Join(remote, " and "))
+	case len(remote) == 1 && local:
+		return nil, fmt.Errorf("%s cannot be combined with a local key (--private-key, AGENT_PK, --private-key-file, --keystore or --mnemonic-file)", remote[0])
+	case cfg.KMSKeyID != "":
+		s, err := newKMSSigner(ctx, cfg.KMSKeyID, cfg.KMSRegion)
+		if err != nil {
+			return nil, err
+		}
+		return []Signer{s}, nil
+	case cfg.RemoteURL != "":
+		s, err := newRemoteSigner(ctx, cfg.RemoteURL, cfg.From)
+		if err != nil {
+			return nil, err
+		}
+		return []Signer{s}, nil
+	}
+	privs, err := loadAgentKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+	signers := make([]Signer, len(privs))
+	for i, k := range privs {
+		signers[i] = newKeySigner(k)
+	}
+	return signers, nil
+}
+
+// agentSigner picks the signer for a vault. executeSlice is onlyAgent, so with
+// several keys the one matching the contract's agent() is used; a single key
+// is returned as is and a mismatch shows up in simulation as before.
+func agentSigner(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, signers []Signer) (Signer, error) {
+	switch len(signers) {
+	case 0:
+		return nil, nil
+	case 1:
+		return signers[0], nil
+	}
+	return matchAgentSigner(ctx, addr, cABI, client, signers)
+}
+
+// matchAgentSigner is the one of signers that is addr's agent(), even if it
+// is the only one. Bot mode picks each vault's key with it when it runs
+// several, so a vault no key can execute fails at startup rather than
+// reverting AGENT on every block.
+func matchAgentSigner(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, signers []Signer) (Signer, error) {
+	outs, err := callView(ctx, addr, cABI, client, "agent")
+	if err != nil {
+		return nil, fmt.Errorf("read agent: %w", err)
+	}
+	agent := outs[0].(common.Address)
+	for _, s := range signers {
+		if s.Address() == agent {
+			return s, nil
+		}
+	}
+	if len(signers) == 1 {
+		return nil, fmt.Errorf("the agent key %s is not %s's agent %s", signers[0].Address().Hex(), addr.Hex(), agent.Hex())
+	}
+	return nil, fmt.Errorf("none of the %d agent keys is %s's agent %s", len(signers), addr.Hex(), agent.Hex())
+}
+
+// hashSigner is implemented by signers that can sign a raw 32-byte digest,
+// returning r||s||v with v in {0, 1}. Safe proposals need this.
+type hashSigner interface {
+	SignHash(ctx context.Context, hash []byte) ([]byte, error)
+}
+
+// keySigner signs with an in-memory private key (hex key, key file or keystore).
+type keySigner struct {
+	key *ecdsa.PrivateKey
+}
+
+func newKeySigner(key *ecdsa.PrivateKey) *keySigner {
+	return &keySigner{key: key}
+}
+
+func (s *keySigner) Address() common.Address {
+	return crypto.PubkeyToAddress(s.key.PublicKey)
+}
+
+func (s *keySigner) TransactOpts(ctx context.Context, chainID uint64) (*bind.TransactOpts, error) {
+	auth, err := bind.NewKeyedTransactorWithChainID(s.key, new(big.Int).SetUint64(chainID))
+	if err != nil {
+		return nil, err
+	}
+	auth.Context = ctx
+	return auth, nil
+}
+
+func (s *keySigner) SignHash(_ context.Context, hash []byte) ([]byte, error) {
+	return crypto.Sign(hash, s.key)
+}
@@ -0,0 +1,144 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// simOutcome sorts a simulated executeSlice by what stopped it.
+type simOutcome int
+
+const (
+	simOK     simOutcome = iota
+	simNotDue            // TOO_EARLY: only time will fix it
+	simPrice             // the oracle or slippage guards
+	simFailed            // anything else
+)
+
+// simOutcomeOf classifies the error of an executeSlice eth_call.
+func simOutcomeOf(cABI abi.ABI, err error) (simOutcome, string) {
+	if err == nil {
+		return simOK, ""
+	}
+	reason, ok := describeRevert(cABI, err)
+	if !ok {
+		return simFailed, err.Error()
+	}
+	switch reason {
+	case `Error("TOO_EARLY")`:
+		return simNotDue, reason
+	case `Error("SLIPPAGE")`, `Error("PRICE_DEVIATION")`, `Error("MIN_OUT_ZERO")`, `Error("INVALID_PRICE")`:
+		return simPrice, reason
+	}
+	return simFailed, reason
+}
+
+// joinSlices renders ids as "1, 2, 5".
+func joinSlices(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprint(id)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// simulateAll is simulate mode: it eth_calls executeSlice from from for
+// every slice not yet done, each against the latest state as if it were the
+// only one sent, and sorts them into those that would succeed, revert on the
+// price guards, revert for being early, or fail otherwise. Due slices also
+// get the oracle arithmetic executeSlice would do.
+func simulateAll(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, rc *rpc.Client, from common.Address, oracle OracleShape) error {
+	N, err := readTotalSlices(ctx, addr, cABI, client)
+	if err != nil {
+		return fmt.Errorf("read totalSlices: %w", err)
+	}
+	n, err := sliceCount(N)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errNotInitialized
+	}
+	s, err := readStrategy(ctx, addr, cABI, client)
+	if err != nil {
+		return fmt.Errorf("read strategy: %w", err)
+	}
+	filled, err := readFilled(ctx, addr, cABI, client)
+	if err != nil {
+		return fmt.Errorf("read filledAmountIn: %w", err)
+	}
+	var done sliceBitmap
+	if err := loadSliceBitmap(ctx, &done, addr, cABI, client, rc, false, n); err != nil {
+		return fmt.Errorf("load sliceDone: %w", err)
+	}
+	head, err := headerByNumber(ctx, client, nil)
+	if err != nil {
+		return fmt.Errorf("latest header: %w", err)
+	}
+	fmt.Fprintf(outputFrom(ctx), "Simulating executeSlice from %s at block %s (time %d)\n", from.Hex(), head.Number, head.Time)
+
+	// Every slice would swap the same amount: the next one's.
+	amountIn := new(big.Int).Sub(s.TotalAmountIn, filled)
+	if amountIn.Cmp(s.SliceAmountIn) > 0 {
+		amountIn.Set(s.SliceAmountIn)
+	}
+	var check *priceCheck
+	if amountIn.Sign() > 0 {
+		c, err := readPriceCheck(ctx, addr, cABI, client, oracle, s, amountIn)
+		if err != nil {
+			fmt.Fprintf(outputFrom(ctx), "Price checks unavailable: %v\n", err)
+		} else {
+			check = &c
+			verdict := "ok"
+			if !c.DeviationOK() {
+				verdict = "exceeds the maximum, every slice reverts"
+			}
+			fmt.Fprintf(outputFrom(ctx), "Oracle price %s, reference %s: deviation %s bps, max %d (%s)\n", c.Price, c.Reference, c.DeviationBps, c.MaxDeviation, verdict)
+			fmt.Fprintf(outputFrom(ctx), "A slice of %s expects %s at the oracle price and needs at least %s (maxSlippageBps %d)\n", c.AmountIn, c.OracleOut, c.MinOut, s.MaxSlippageBps)
+		}
+	}
+
+	var ok, price, notDue, failed []int64
+	remaining := 0
+	for id := int64(0); id < n; id++ {
+		if done.Done(id) {
+			continue
+		}
+		remaining++
+		outcome, reason := simOutcomeOf(cABI, callExecuteSlice(ctx, addr, cABI, client, from, id, false))
+		switch outcome {
+		case simOK:
+			ok = append(ok, id)
+			fmt.Fprintf(outputFrom(ctx), "- slice %d: would succeed\n", id)
+		case simNotDue:
+			notDue = append(notDue, id) // summarized below
+		case simPrice:
+			price = append(price, id)
+			fmt.Fprintf(outputFrom(ctx), "- slice %d: would revert on the price guards: %s\n", id, reason)
+		default:
+			failed = append(failed, id)
+			fmt.Fprintf(outputFrom(ctx), "- slice %d: would fail: %s\n", id, reason)
+		}
+	}
+	if len(notDue) > 0 {
+		next, _ := sliceScheduledAt(s, n, notDue[0])
+		fmt.Fprintf(outputFrom(ctx), "- %d slices not due yet, the first (slice %d) at %s\n", len(notDue), notDue[0], next)
+	}
+
+	fmt.Fprintf(outputFrom(ctx), "Summary: %d slices remaining\n", remaining)
+	fmt.Fprintf(outputFrom(ctx), "- would succeed: %d [%s]\n", len(ok), joinSlices(ok))
+	fmt.Fprintf(outputFrom(ctx), "- would revert on slippage/deviation: %d [%s]\n", len(price), joinSlices(price))
+	fmt.Fprintf(outputFrom(ctx), "- not due yet: %d\n", len(notDue))
+	fmt.Fprintf(outputFrom(ctx), "- other failures: %d [%s]\n", len(failed), joinSlices(failed))
+	if check != nil && len(price) > 0 && check.DeviationOK() {
+		fmt.Fprintln(outputFrom(ctx), "The oracle is within its deviation bound, so the adapter likely returns less than minOut.")
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package twapagent
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestSimOutcomeOf(t *testing.T) {
+	cABI := mustABI(t, testErrorsABI)
+	revert := func(msg string) error { return rpcDataError{data: hexutil.Encode(errorStringPayload(t, msg))} }
+	for _, tc := range []struct {
+		err  error
+		want simOutcome
+	}{
+		{nil, simOK},
+		{revert("TOO_EARLY"), simNotDue},
+		{revert("SLIPPAGE"), simPrice},
+		{revert("PRICE_DEVIATION"), simPrice},
+		{revert("SLICE_DONE"), simFailed},
+		{errors.New("connection refused"), simFailed},
+	} {
+		if got, reason := simOutcomeOf(cABI, tc.err); got != tc.want {
+			t.Errorf("%v: outcome %d (%s), want %d", tc.err, got, reason, tc.want)
+		}
+	}
+}
+
+func TestNewPriceCheck(t *testing.T) {
+	s := Strategy{MaxSlippageBps: 100, MaxPriceDeviationBps: 250}
+	e18 := big.NewInt(1e18)
+	// Price 2.1 against a reference of 2.0: 500 bps off.
+	p := new(big.Int).Mul(big.NewInt(21), big.NewInt(1e17))
+	ref := new(big.Int).Mul(big.NewInt(2), e18)
+	c := newPriceCheck(s, p, ref, new(big.Int).Mul(big.NewInt(10), e18))
+	if c.DeviationBps.Int64() != 500 || c.DeviationOK() {
+		t.Errorf("deviation %s bps, ok=%v; want 500, false", c.DeviationBps, c.DeviationOK())
+	}
+	// 10 tokens at 2.1 is 21; less 1% is 20.79.
+	if want, _ := new(big.Int).SetString("21000000000000000000", 10); c.OracleOut.Cmp(want) != 0 {
+		t.Errorf("oracleOut %s, want %s", c.OracleOut, want)
+	}
+	if want, _ := new(big.Int).SetString("20790000000000000000", 10); c.MinOut.Cmp(want) != 0 {
+		t.Errorf("minOut %s, want %s", c.MinOut, want)
+	}
+	if c := newPriceCheck(s, ref, ref, e18); c.DeviationBps.Sign() != 0 || !c.DeviationOK() {
+		t.Errorf("price at the reference: deviation %s", c.DeviationBps)
+	}
+}
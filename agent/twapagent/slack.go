@@ -0,0 +1,122 @@
+package twapagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SlackConfig sends bot mode's notifications to Slack incoming webhooks.
+type SlackConfig struct {
+	// Gets everything, or the routine fills and status changes when
+	// AlertsWebhookURL is set.
+	WebhookURL string
+	// Gets failures, halts and an order that didn't fill.
+	AlertsWebhookURL string
+}
+
+func (c SlackConfig) validate() error {
+	if c.AlertsWebhookURL != "" && c.WebhookURL == "" {
+		return errors.New("--slack-alerts-webhook-url requires --slack-webhook-url")
+	}
+	for _, u := range []string{c.WebhookURL, c.AlertsWebhookURL} {
+		if u == "" {
+			continue
+		}
+		if p, err := url.Parse(u); err != nil || p.Scheme != "https" || p.Host == "" {
+			return fmt.Errorf("invalid Slack webhook URL %q (want https://hooks.slack.com/services/...)", webhookHost(u))
+		}
+	}
+	return nil
+}
+
+// Tries per message, with the wait doubling from 1s between them.
+const slackAttempts = 3
+
+// slackNotifier posts each notification as a Block Kit message to the
+// channel its severity routes it to.
+type slackNotifier struct {
+	cfg    SlackConfig
+	format *notifyFormatter
+	poster jsonPoster
+	q      *notifyQueue
+	ctx    context.Context
+}
+
+func newSlackNotifier(cfg SlackConfig, f *notifyFormatter) *slackNotifier {
+	return &slackNotifier{cfg: cfg, format: f, poster: newJSONPoster("slack", slackAttempts), q: newNotifyQueue("slack")}
+}
+
+func (n *slackNotifier) start(ctx context.Context) {
+	n.ctx = ctx
+	n.q.start(ctx, n.send)
+	if n.cfg.AlertsWebhookURL != "" {
+		logf(ctx, "sending fills to Slack, and failures and halts to the Slack alerts channel")
+	} else {
+		logf(ctx, "sending fills, failures and halts to Slack")
+	}
+}
+
+func (n *slackNotifier) notify(note notification) { n.q.push(note) }
+
+func (n *slackNotifier) close() { n.q.close() }
+
+func (n *slackNotifier) send(ctx context.Context, note notification) {
+	body, err := json.Marshal(n.blocks(note, n.format.message(ctx, note)))
+	if err != nil {
+		warnf(n.ctx, "slack %s: %v", note.kind, err)
+		return
+	}
+	n.poster.deliver(ctx, n.ctx, routeBySeverity(note, n.cfg.WebhookURL, n.cfg.AlertsWebhookURL), note.kind, body, nil)
+}
+
+// blocks is m as an incoming webhook's body: a section with the title,
+// text and code, and a context line with the contract and the tx link.
+// text is the fallback that notifications show.
+func (n *slackNotifier) blocks(note notification, m notifyMessage) map[string]interface{} {
+	title := "*" + slackEscape(m.Title) + "*"
+	if note.severity == severityAlert {
+		title = ":rotating_light: " + title
+	}
+	section := title
+	if m.Text != "" {
+		section += "\n" + slackEscape(m.Text)
+	}
+	if m.Code != "" {
+		section += "\n```" + slackEscape(m.Code) + "```"
+	}
+	var footer []string
+	if m.Contract != (common.Address{}) {
+		footer = append(footer, "Contract `"+m.Contract.Hex()+"`")
+	}
+	switch {
+	case m.TxURL != "":
+		footer = append(footer, fmt.Sprintf("<%s|%s>", m.TxURL, m.Tx))
+	case m.Tx != "":
+		footer = append(footer, "Tx `"+m.Tx+"`")
+	}
+	blocks := []interface{}{
+		map[string]interface{}{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": section}},
+	}
+	if len(footer) > 0 {
+		blocks = append(blocks, map[string]interface{}{
+			"type":     "context",
+			"elements": []interface{}{map[string]string{"type": "mrkdwn", "text": strings.Join(footer, " · ")}},
+		})
+	}
+	fallback := m.Title
+	if m.Text != "" {
+		fallback += ": " + m.Text
+	}
+	return map[string]interface{}{"text": slackEscape(fallback), "blocks": blocks}
+}
+
+// slackEscape escapes the three characters Slack's mrkdwn reserves.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
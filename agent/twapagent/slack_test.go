@@ -0,0 +1,87 @@
+package twapagent
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slackSink is an incoming webhook keeping each message's path and text.
+type slackSink struct {
+	mu  sync.Mutex
+	got []string
+}
+
+func (s *slackSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var msg struct {
+		Text   string `json:"text"`
+		Blocks []struct {
+			Type string `json:"type"`
+			Text struct {
+				Text string `json:"text"`
+			} `json:"text"`
+			Elements []struct {
+				Text string `json:"text"`
+			} `json:"elements"`
+		} `json:"blocks"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil || msg.Text == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	parts := []string{r.URL.Path}
+	for _, b := range msg.Blocks {
+		if b.Text.Text != "" {
+			parts = append(parts, b.Text.Text)
+		}
+		for _, e := range b.Elements {
+			parts = append(parts, e.Text)
+		}
+	}
+	s.mu.Lock()
+	s.got = append(s.got, strings.Join(parts, "|"))
+	s.mu.Unlock()
+	w.Write([]byte("ok"))
+}
+
+func TestSlackConfigValidate(t *testing.T) {
+	for _, c := range []SlackConfig{
+		{AlertsWebhookURL: "https://hooks.slack.com/services/a"},
+		{WebhookURL: "http://hooks.slack.com/services/a"},
+		{WebhookURL: "hooks.slack.com/services/a"},
+	} {
+		if err := c.validate(); err == nil {
+			t.Errorf("%+v: want an error", c)
+		}
+	}
+}
+
+// Fills go to the routine channel and failures to the alerts one, as
+// blocks with the contract and the tx's link.
+func TestSlackRoutesBySeverity(t *testing.T) {
+	sink := &slackSink{}
+	srv := httptest.NewServer(sink)
+	defer srv.Close()
+	f := &notifyFormatter{chainID: 1, tokens: newTokenCache(nil)}
+	f.tokens.m[tgIn] = tokenInfo{Symbol: "WETH", Decimals: 18, Known: true}
+	f.tokens.m[tgOut] = tokenInfo{Symbol: "USDC", Decimals: 6, Known: true}
+	n := newSlackNotifier(SlackConfig{WebhookURL: srv.URL + "/fills", AlertsWebhookURL: srv.URL + "/alerts"}, f)
+	n.poster.backoff = time.Millisecond
+	h := testHub(1, n, testVault(tgVault, Strategy{TokenIn: tgIn, TokenOut: tgOut}, big.NewInt(5)))
+	h.tap(tgFill(2, "0xab"))
+	h.tap(eventRecord{Type: evHalted, Data: map[string]interface{}{"reason": "oracle <stale>"}})
+	h.close()
+
+	want := []string{
+		"/fills|*Slice 3/5 filled*\n1.5 WETH → 3000 USDC at 2000 USDC/WETH|Contract `" + tgVault.Hex() + "` · <https://etherscan.io/tx/0xab|0xab>",
+		"/alerts|:rotating_light: *Halted*\noracle &lt;stale&gt;|Contract `" + tgVault.Hex() + "`",
+	}
+	if strings.Join(sink.got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("posted\n%q\nwant\n%q", sink.got, want)
+	}
+}
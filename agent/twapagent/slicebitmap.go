@@ -0,0 +1,114 @@
+package twapagent
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// sliceBitmap mirrors sliceDone() for every slice, so finding the next slice
+// costs no RPC calls. It is loaded from the chain once, then kept current from
+// Fill events: a Fill sets its slice's bit and the same log removed by a reorg
+// clears it again. configureStrategy clears sliceDone on chain, so the bitmap
+// is reloaded after a reconfiguration.
+type sliceBitmap struct {
+	mu     sync.Mutex
+	loaded bool
+	n      int64 // totalSlices when loaded
+	words  []uint64
+}
+
+// Loaded reports whether the bitmap holds n slices read from the chain.
+func (b *sliceBitmap) Loaded(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.loaded && b.n == n
+}
+
+// Invalidate drops the bitmap so the next block reloads it.
+func (b *sliceBitmap) Invalidate() {
+	b.mu.Lock()
+	b.loaded = false
+	b.mu.Unlock()
+}
+
+// Load replaces the bitmap with done, as read for slices 0..len(done)-1.
+func (b *sliceBitmap) Load(done []bool) {
+	words := make([]uint64, (len(done)+63)/64)
+	for i, d := range done {
+		if d {
+			words[i/64] |= 1 << (i % 64)
+		}
+	}
+	b.mu.Lock()
+	b.loaded, b.n, b.words = true, int64(len(done)), words
+	b.mu.Unlock()
+}
+
+// Set records slice id as done or not. It is a no-op before Load or for an
+// id outside the loaded range.
+func (b *sliceBitmap) Set(id int64, done bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.loaded || id < 0 || id >= b.n {
+		return
+	}
+	if done {
+		b.words[id/64] |= 1 << (id % 64)
+	} else {
+		b.words[id/64] &^= 1 << (id % 64)
+	}
+}
+
+// Done reports whether slice id is marked done; false before Load.
+func (b *sliceBitmap) Done(id int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.loaded && id >= 0 && id < b.n && b.words[id/64]&(1<<(id%64)) != 0
+}
+
+// FirstUndone returns the lowest open slice that skip doesn't exclude, or -1.
+func (b *sliceBitmap) FirstUndone(skip func(int64) bool) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := int64(0); i < b.n; i++ {
+		if b.words[i/64]&(1<<(i%64)) == 0 && !skip(i) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Undone counts the open slices; 0 before Load.
+func (b *sliceBitmap) Undone() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var left int64
+	for i := int64(0); i < b.n; i++ {
+		if b.words[i/64]&(1<<(i%64)) == 0 {
+			left++
+		}
+	}
+	return left
+}
+
+// loadSliceBitmap reads sliceDone() for all n slices into b, in batches of
+// maxBatchCalls per round trip, through Multicall3 when useMulticall is set.
+func loadSliceBitmap(ctx context.Context, b *sliceBitmap, addr common.Address, cABI abi.ABI, client *ethclient.Client, rc *rpc.Client, useMulticall bool, n int64) error {
+	var done []bool
+	var err error
+	if useMulticall {
+		done, err = sliceDoneRangeMulticall(ctx, addr, cABI, client, 0, n)
+	} else {
+		done, err = sliceDoneRange(ctx, addr, cABI, rc, 0, n)
+	}
+	if err != nil {
+		return err
+	}
+	b.Load(done)
+	return nil
+}
@@ -0,0 +1,47 @@
+package twapagent
+
+import "testing"
+
+func TestSliceBitmap(t *testing.T) {
+	var b sliceBitmap
+	none := func(int64) bool { return false }
+	b.Set(0, true) // before Load: ignored
+	if b.Loaded(3) || b.FirstUndone(none) != -1 {
+		t.Fatal("empty bitmap reports slices")
+	}
+
+	done := make([]bool, 130)
+	for i := 0; i < 70; i++ {
+		done[i] = true
+	}
+	b.Load(done)
+	if !b.Loaded(130) || b.Loaded(131) {
+		t.Fatal("Loaded doesn't match the loaded size")
+	}
+	if got := b.FirstUndone(none); got != 70 {
+		t.Fatalf("first undone = %d, want 70", got)
+	}
+
+	// Fill events, then a reorg removing one of them.
+	b.Set(70, true)
+	b.Set(71, true)
+	if got := b.FirstUndone(none); got != 72 {
+		t.Fatalf("after fills: first undone = %d, want 72", got)
+	}
+	b.Set(70, false)
+	if got := b.FirstUndone(none); got != 70 {
+		t.Fatalf("after removed fill: first undone = %d, want 70", got)
+	}
+	if got := b.FirstUndone(func(i int64) bool { return i == 70 }); got != 72 {
+		t.Fatalf("skipping 70: first undone = %d, want 72", got)
+	}
+	if got := b.Undone(); got != 130-71 {
+		t.Fatalf("undone = %d, want %d", got, 130-71)
+	}
+
+	b.Set(500, true) // out of range: ignored
+	b.Invalidate()
+	if b.Loaded(130) {
+		t.Fatal("still loaded after Invalidate")
+	}
+}
@@ -0,0 +1,132 @@
+package twapagent
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status is the vault's order status, Twap.Status on chain. The ABI carries it
+// as a uint8, so values added to the enum later still decode.
+type Status uint8
+
+// The values of Twap.Status, in declaration order.
+const (
+	StatusOpen Status = iota
+	StatusPartialFilled
+	StatusFilled
+	StatusCancelled
+)
+
+var statusNames = [...]string{"Open", "PartialFilled", "Filled", "Cancelled"}
+
+func (s Status) String() string {
+	if int(s) < len(statusNames) {
+		return statusNames[s]
+	}
+	return fmt.Sprintf("Unknown(%d)", uint8(s))
+}
+
+// Terminal reports whether the order can take no more slices.
+func (s Status) Terminal() bool { return s == StatusFilled || s == StatusCancelled }
+
+// describe renders s for logs as its name and number, e.g. "Filled (2)".
+func (s Status) describe() string { return fmt.Sprintf("%s (%d)", s, uint8(s)) }
+
+// parseStatus reads a status name (any case, "Canceled" accepted) or number.
+// Numbers past the known values are kept rather than rejected.
+func parseStatus(v string) (Status, error) {
+	v = strings.TrimSpace(v)
+	if n, err := strconv.ParseUint(v, 10, 8); err == nil {
+		return Status(n), nil
+	}
+	if strings.EqualFold(v, "Canceled") {
+		return StatusCancelled, nil
+	}
+	for i, name := range statusNames {
+		if strings.EqualFold(v, name) {
+			return Status(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown order status %q", v)
+}
+
+// statusTransition is a change of the order's status, or the first status
+// seen, which has no From.
+type statusTransition struct {
+	From  string    `json:"from,omitempty"`
+	To    string    `json:"to"`
+	Block uint64    `json:"block"`
+	Time  time.Time `json:"time"`
+	// What showed it: an OrderStatus "event" or the block's "read".
+	Source string `json:"source"`
+}
+
+// statusHistory follows the order's status through OrderStatus events and
+// the status read at each block. A status seen at an earlier block than the
+// last one is stale, say from a backfill overlapping the live logs, and
+// makes no transition. The bot loop writes it and the status API reads it.
+type statusHistory struct {
+	mu          sync.Mutex
+	known       bool
+	current     Status
+	block       uint64
+	transitions []statusTransition
+}
+
+// observe records status as seen at block, returning the transition it
+// makes, if any.
+func (h *statusHistory) observe(status Status, block uint64, at time.Time, source string) (statusTransition, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.known && block < h.block {
+		return statusTransition{}, false
+	}
+	h.block = block
+	if h.known && status == h.current {
+		return statusTransition{}, false
+	}
+	t := statusTransition{To: status.String(), Block: block, Time: at, Source: source}
+	if h.known {
+		t.From = h.current.String()
+	}
+	h.known, h.current = true, status
+	h.transitions = append(h.transitions, t)
+	return t, true
+}
+
+// Transitions returns a copy of the transitions so far, oldest first.
+func (h *statusHistory) Transitions() []statusTransition {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]statusTransition(nil), h.transitions...)
+}
+
+// restore starts the history from transitions saved by an earlier run.
+func (h *statusHistory) restore(transitions []statusTransition) {
+	if len(transitions) == 0 {
+		return
+	}
+	last := transitions[len(transitions)-1]
+	status, err := parseStatus(last.To)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.known, h.current, h.block = true, status, last.Block
+	h.transitions = append([]statusTransition(nil), transitions...)
+}
+
+// FillEvent is the payload of the vault's Fill event, one per executed slice.
+type FillEvent struct{ SliceId, AmountIn, AmountOut, Fee *big.Int }
+
+// OrderStatusEvent is the payload of OrderStatus: the order's running totals
+// and its status, emitted with every fill and status change.
+type OrderStatusEvent struct {
+	FilledAmountIn, ReceivedAmountOut, Fee *big.Int
+	Status                                 uint8
+}
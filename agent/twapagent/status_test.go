@@ -0,0 +1,122 @@
+package twapagent
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStatusMatchesContract pins the Status constants to the enum in
+// src/Twap.sol and to the uint8 the ABI carries it as.
+func TestStatusMatchesContract(t *testing.T) {
+	src, err := os.ReadFile("../../src/Twap.sol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := regexp.MustCompile(`enum Status\s*{([^}]*)}`).FindSubmatch(src)
+	if m == nil {
+		t.Fatal("enum Status not found in Twap.sol")
+	}
+	var members []string
+	for _, f := range strings.Split(string(m[1]), ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			members = append(members, f)
+		}
+	}
+	if len(members) != len(statusNames) {
+		t.Fatalf("Twap.Status has %d members %v, the agent knows %d", len(members), members, len(statusNames))
+	}
+	for i, name := range members {
+		if got := Status(i).String(); got != name {
+			t.Errorf("Status(%d) = %s, contract has %s", i, got, name)
+		}
+	}
+
+	cABI, _, err := loadTwapABI("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := cABI.Methods["status"].Outputs; len(out) != 1 || out[0].Type.String() != "uint8" {
+		t.Errorf("status() outputs %v, want one uint8", out)
+	}
+	ev := cABI.Events["OrderStatus"].Inputs
+	if ev[len(ev)-1].Name != "status" || ev[len(ev)-1].Type.String() != "uint8" {
+		t.Errorf("OrderStatus ends with %s %s, want uint8 status", ev[len(ev)-1].Type, ev[len(ev)-1].Name)
+	}
+}
+
+func TestStatusString(t *testing.T) {
+	for s, want := range map[Status]string{
+		StatusOpen:      "Open",
+		StatusFilled:    "Filled",
+		StatusCancelled: "Cancelled",
+		Status(9):       "Unknown(9)",
+	} {
+		if got := s.String(); got != want {
+			t.Errorf("Status(%d).String() = %q, want %q", uint8(s), got, want)
+		}
+	}
+	if got := StatusFilled.describe(); got != "Filled (2)" {
+		t.Errorf("describe = %q", got)
+	}
+	if StatusPartialFilled.Terminal() || !StatusCancelled.Terminal() {
+		t.Error("Terminal() disagrees with the contract's terminal statuses")
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	for in, want := range map[string]Status{
+		"filled":        StatusFilled,
+		"PartialFilled": StatusPartialFilled,
+		"Canceled":      StatusCancelled,
+		"3":             StatusCancelled,
+		"7":             Status(7), // a value added to the contract later
+	} {
+		if got, err := parseStatus(in); err != nil || got != want {
+			t.Errorf("parseStatus(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := parseStatus("Expired"); err == nil {
+		t.Error("parseStatus accepted an unknown name")
+	}
+}
+
+func TestStatusHistory(t *testing.T) {
+	var h statusHistory
+	at := time.Unix(1700000000, 0).UTC()
+	for _, o := range []struct {
+		status Status
+		block  uint64
+		want   bool
+	}{
+		{StatusOpen, 10, true},
+		{StatusOpen, 11, false}, // no change
+		{StatusPartialFilled, 20, true},
+		{StatusOpen, 15, false},          // a backfilled event behind the live ones
+		{StatusPartialFilled, 21, false}, // the read after the event
+		{StatusFilled, 30, true},
+	} {
+		if _, ok := h.observe(o.status, o.block, at, "event"); ok != o.want {
+			t.Errorf("observe(%s at %d) made a transition: %v, want %v", o.status, o.block, ok, o.want)
+		}
+	}
+	got := h.Transitions()
+	if len(got) != 3 || got[0].From != "" || got[1].From != "Open" || got[2].From != "PartialFilled" || got[2].To != "Filled" || got[2].Block != 30 {
+		t.Fatalf("transitions %+v", got)
+	}
+
+	// A restarted bot carries on from the saved history.
+	var again statusHistory
+	again.restore(got)
+	if _, ok := again.observe(StatusFilled, 31, at, "read"); ok {
+		t.Error("restored history made a transition to the same status")
+	}
+	if _, ok := again.observe(StatusOpen, 29, at, "event"); ok {
+		t.Error("restored history took a status from before its last block")
+	}
+	if len(again.Transitions()) != 3 {
+		t.Errorf("restored %d transitions, want 3", len(again.Transitions()))
+	}
+}
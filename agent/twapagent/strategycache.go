@@ -0,0 +1,100 @@
+package twapagent
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// strategyCache keeps strategy() and totalSlices() between blocks. They only
+// change when the owner reconfigures the vault (which emits OrderStatus with
+// status Open and unpauses it), so the bot reads them at startup, again after
+// such an event, and every refresh interval as a safety net.
+type strategyCache struct {
+	addr    common.Address
+	cABI    abi.ABI
+	client  *ethclient.Client
+	refresh time.Duration // 0 = only on events
+
+	mu     sync.Mutex
+	s      Strategy
+	total  *big.Int
+	readAt time.Time
+	stale  bool
+}
+
+func newStrategyCache(addr common.Address, cABI abi.ABI, client *ethclient.Client, refresh time.Duration) *strategyCache {
+	return &strategyCache{addr: addr, cABI: cABI, client: client, refresh: refresh}
+}
+
+// Load reads the strategy, retrying with backoff until it succeeds or ctx ends.
+func (c *strategyCache) Load(ctx context.Context) error {
+	wait := time.Second
+	for {
+		err := c.read(ctx)
+		if err == nil {
+			return nil
+		}
+		warnf(ctx, "read strategy: %v (retrying in %s)", err, wait)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > 30*time.Second {
+			wait = 30 * time.Second
+		}
+	}
+}
+
+// Get returns the strategy and slice count, first re-reading them if they
+// were invalidated or the refresh interval passed. A failed re-read keeps
+// the previous values so the block is still handled.
+func (c *strategyCache) Get(ctx context.Context, now time.Time) (Strategy, *big.Int) {
+	c.mu.Lock()
+	due := c.stale || (c.refresh > 0 && now.Sub(c.readAt) >= c.refresh)
+	c.mu.Unlock()
+	if due {
+		if err := c.read(ctx); err != nil {
+			warnf(ctx, "refresh strategy: %v (using the cached one)", err)
+		}
+	}
+	return c.Cached()
+}
+
+// Cached returns the values from the last successful read without any RPC.
+func (c *strategyCache) Cached() (Strategy, *big.Int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.s, c.total
+}
+
+// Invalidate makes the next Get re-read the strategy.
+func (c *strategyCache) Invalidate() {
+	c.mu.Lock()
+	c.stale = true
+	c.mu.Unlock()
+}
+
+func (c *strategyCache) read(ctx context.Context) error {
+	s, err := readStrategy(ctx, c.addr, c.cABI, c.client)
+	if err != nil {
+		return err
+	}
+	total, err := readTotalSlices(ctx, c.addr, c.cABI, c.client)
+	if err != nil {
+		return err
+	}
+	if _, err := sliceCount(total); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.s, c.total, c.readAt, c.stale = s, total, time.Now(), false
+	return nil
+}
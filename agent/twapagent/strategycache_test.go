@@ -0,0 +1,83 @@
+package twapagent
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStrategyCacheReadsOnlyWhenDue(t *testing.T) {
+	ctx := context.Background()
+	v, twap, cABI, client, _ := newVaultRPC(t, 24, 20)
+	c := newStrategyCache(twap.Address(), cABI, client, time.Minute)
+	if err := c.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	loaded := v.roundTrips.Swap(0)
+	if loaded != 2 {
+		t.Fatalf("load took %d round trips, want 2", loaded)
+	}
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		s, n := c.Get(ctx, now)
+		if s.EndTime.Int64() != 3_000 || n.Int64() != 24 {
+			t.Fatalf("Get = %+v, %s", s, n)
+		}
+	}
+	if n := v.roundTrips.Load(); n != 0 {
+		t.Fatalf("%d round trips for a fresh cache, want 0", n)
+	}
+
+	c.Invalidate()
+	c.Get(ctx, now)
+	c.Get(ctx, now)
+	if n := v.roundTrips.Swap(0); n != 2 {
+		t.Fatalf("%d round trips after Invalidate, want one re-read (2)", n)
+	}
+
+	c.Get(ctx, now.Add(2*time.Minute))
+	if n := v.roundTrips.Swap(0); n != 2 {
+		t.Fatalf("%d round trips after the refresh interval, want 2", n)
+	}
+}
+
+func TestStrategyCacheKeepsValuesWhenRefreshFails(t *testing.T) {
+	ctx := context.Background()
+	v, twap, cABI, client, _ := newVaultRPC(t, 24, 20)
+	c := newStrategyCache(twap.Address(), cABI, client, 0)
+	if err := c.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	v.down.Store(true)
+	c.Invalidate()
+	s, n := c.Get(ctx, time.Now())
+	if s.EndTime == nil || s.EndTime.Int64() != 3_000 || n.Int64() != 24 {
+		t.Fatalf("Get after a failed refresh = %+v, %v; want the cached strategy", s, n)
+	}
+
+	// Still stale, so the next block tries again.
+	v.down.Store(false)
+	v.roundTrips.Store(0)
+	c.Get(ctx, time.Now())
+	if n := v.roundTrips.Load(); n != 2 {
+		t.Fatalf("%d round trips on the retry, want 2", n)
+	}
+}
+
+func TestStrategyCacheLoadRetries(t *testing.T) {
+	v, twap, cABI, client, _ := newVaultRPC(t, 24, 20)
+	v.down.Store(true)
+	time.AfterFunc(200*time.Millisecond, func() { v.down.Store(false) })
+	c := newStrategyCache(twap.Address(), cABI, client, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Load(ctx); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if _, n := c.Cached(); n == nil || n.Int64() != 24 {
+		t.Fatalf("total slices = %v, want 24", n)
+	}
+}
@@ -0,0 +1,204 @@
+package twapagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// TelegramConfig sends bot mode's fills, its order's end and alerts to a
+// Telegram chat.
+type TelegramConfig struct {
+	// From @BotFather.
+	BotToken string
+	// A numeric chat or group id, or @channelname.
+	ChatID string
+}
+
+func (c TelegramConfig) validate() error {
+	if (c.BotToken == "") != (c.ChatID == "") {
+		return errors.New("--telegram-bot-token and --telegram-chat-id go together")
+	}
+	return nil
+}
+
+const (
+	telegramAPI = "https://api.telegram.org"
+	// Telegram takes about 20 messages a minute in a group. What comes in
+	// while a message waits its turn goes out with it, so a catch-up's
+	// fills make one message.
+	telegramInterval = 3 * time.Second
+	telegramMaxLen   = 4096
+	// Tries per message when Telegram says to slow down.
+	telegramAttempts = 3
+)
+
+// telegramNotifier sends fills, the order's end and alerts to a chat, from
+// a worker that keeps to telegramInterval.
+type telegramNotifier struct {
+	cfg      TelegramConfig
+	format   *notifyFormatter
+	http     *http.Client
+	apiURL   string
+	interval time.Duration
+	q        *notifyQueue
+	ctx      context.Context
+	// The worker's.
+	lastSent time.Time
+}
+
+func newTelegramNotifier(cfg TelegramConfig, f *notifyFormatter) *telegramNotifier {
+	return &telegramNotifier{
+		cfg: cfg, format: f,
+		http:     &http.Client{Timeout: 10 * time.Second},
+		apiURL:   telegramAPI,
+		interval: telegramInterval,
+		q:        newNotifyQueue("telegram"),
+	}
+}
+
+func (n *telegramNotifier) start(ctx context.Context) {
+	n.ctx = ctx
+	n.q.start(ctx, n.batch)
+	logf(ctx, "sending fills and alerts to Telegram chat %s", n.cfg.ChatID)
+}
+
+// notify takes fills, the order's end and alerts.
+func (n *telegramNotifier) notify(note notification) {
+	if note.kind == notifySliceExecuted || note.kind == evOrderEnded || note.severity == severityAlert {
+		n.q.push(note)
+	}
+}
+
+func (n *telegramNotifier) close() { n.q.close() }
+
+// batch sends note once its turn comes, with whatever came in while it
+// waited, so that a catch-up's fills make one message.
+func (n *telegramNotifier) batch(ctx context.Context, note notification) {
+	if n.waitTurn(ctx) != nil {
+		return
+	}
+	notes := append([]notification{note}, n.q.more()...)
+	for _, msg := range n.render(ctx, notes) {
+		if err := n.send(ctx, msg); err != nil {
+			warnf(n.ctx, "telegram: %v", err)
+		}
+	}
+}
+
+// render turns notes into messages: one part each, joined into as few
+// messages as Telegram's length limit allows.
+func (n *telegramNotifier) render(ctx context.Context, notes []notification) []string {
+	var msgs []string
+	var cur strings.Builder
+	for _, note := range notes {
+		part := n.markup(note, n.format.message(ctx, note))
+		if cur.Len() > 0 && cur.Len()+2+len(part) > telegramMaxLen {
+			msgs = append(msgs, cur.String())
+			cur.Reset()
+		}
+		if cur.Len() > 0 {
+			cur.WriteString("\n\n")
+		}
+		cur.WriteString(part)
+	}
+	return append(msgs, cur.String())
+}
+
+// markup renders m as Telegram HTML.
+func (n *telegramNotifier) markup(note notification, m notifyMessage) string {
+	var b strings.Builder
+	b.WriteString("<b>" + html.EscapeString(m.Title) + "</b>")
+	if m.Text != "" {
+		b.WriteString(": " + html.EscapeString(m.Text))
+	}
+	if m.Code != "" {
+		b.WriteString("\n<code>" + html.EscapeString(m.Code) + "</code>")
+	}
+	switch {
+	case m.TxURL != "":
+		fmt.Fprintf(&b, "\n<a href=\"%s\">%s</a>", html.EscapeString(m.TxURL), m.Tx)
+	case m.Tx != "":
+		b.WriteString("\n<code>" + m.Tx + "</code>")
+	}
+	if note.vaults > 1 && m.Contract != (common.Address{}) {
+		fmt.Fprintf(&b, "\n<code>%s</code>", m.Contract.Hex())
+	}
+	return b.String()
+}
+
+// telegramResponse is the Bot API's reply.
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// send posts text once its turn comes, waiting out a 429's retry_after up
+// to telegramAttempts times.
+func (n *telegramNotifier) send(ctx context.Context, text string) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"chat_id": n.cfg.ChatID, "text": text, "parse_mode": "HTML", "disable_web_page_preview": true,
+	})
+	for attempt := 1; ; attempt++ {
+		if err := n.waitTurn(ctx); err != nil {
+			return err
+		}
+		n.lastSent = time.Now()
+		res, err := n.post(ctx, body)
+		if err != nil {
+			return err
+		}
+		if res.OK {
+			return nil
+		}
+		if res.ErrorCode != http.StatusTooManyRequests || attempt >= telegramAttempts {
+			return fmt.Errorf("sendMessage: %s", res.Description)
+		}
+		n.lastSent = time.Now().Add(time.Duration(res.Parameters.RetryAfter)*time.Second - n.interval)
+	}
+}
+
+// waitTurn waits until interval has passed since the last message.
+func (n *telegramNotifier) waitTurn(ctx context.Context) error {
+	select {
+	case <-time.After(time.Until(n.lastSent.Add(n.interval))):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (n *telegramNotifier) post(ctx context.Context, body []byte) (telegramResponse, error) {
+	var res telegramResponse
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.apiURL+"/bot"+n.cfg.BotToken+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return res, n.redact(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.http.Do(req)
+	if err != nil {
+		return res, n.redact(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return res, fmt.Errorf("sendMessage: %s", resp.Status)
+	}
+	return res, nil
+}
+
+// redact keeps the bot token, which is in the request's URL, out of errors.
+func (n *telegramNotifier) redact(err error) error {
+	return errors.New(strings.ReplaceAll(err.Error(), n.cfg.BotToken, "<token>"))
+}
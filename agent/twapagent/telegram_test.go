@@ -0,0 +1,142 @@
+package twapagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// telegramSink is the Bot API's sendMessage, answering the first limited
+// calls with a 429.
+type telegramSink struct {
+	mu      sync.Mutex
+	limited int
+	calls   int
+	texts   []string
+}
+
+func (s *telegramSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if r.URL.Path != "/botTOKEN/sendMessage" {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"ok":false,"description":"Not Found"}`)
+		return
+	}
+	if s.limited > 0 {
+		s.limited--
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 0","parameters":{"retry_after":0}}`)
+		return
+	}
+	var msg struct {
+		ChatID    string `json:"chat_id"`
+		Text      string `json:"text"`
+		ParseMode string `json:"parse_mode"`
+	}
+	json.NewDecoder(r.Body).Decode(&msg)
+	if msg.ChatID != "-100" || msg.ParseMode != "HTML" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"ok":false,"description":"Bad Request"}`)
+		return
+	}
+	s.texts = append(s.texts, msg.Text)
+	fmt.Fprint(w, `{"ok":true}`)
+}
+
+var (
+	tgVault = common.HexToAddress("0x01")
+	tgIn    = common.HexToAddress("0xa1")
+	tgOut   = common.HexToAddress("0xa2")
+)
+
+// testTelegram sends to sink for a 5-slice WETH to USDC vault, with the
+// tokens already known.
+func testTelegram(t *testing.T, sink *telegramSink, interval time.Duration) *notifyHub {
+	srv := httptest.NewServer(sink)
+	t.Cleanup(srv.Close)
+	f := &notifyFormatter{chainID: 1, tokens: newTokenCache(nil)}
+	f.tokens.m[tgIn] = tokenInfo{Symbol: "WETH", Decimals: 18, Known: true}
+	f.tokens.m[tgOut] = tokenInfo{Symbol: "USDC", Decimals: 6, Known: true}
+	n := newTelegramNotifier(TelegramConfig{BotToken: "TOKEN", ChatID: "-100"}, f)
+	n.apiURL, n.interval = srv.URL, interval
+	return testHub(1, n, testVault(tgVault, Strategy{TokenIn: tgIn, TokenOut: tgOut}, big.NewInt(5)))
+}
+
+func tgFill(slice int64, tx string) eventRecord {
+	return eventRecord{Type: evFill, Data: map[string]interface{}{
+		"slice": slice, "amountIn": "1500000000000000000", "amountOut": "3000000000", "fee": "0", "tx": tx,
+	}}
+}
+
+func TestTelegramConfigValidate(t *testing.T) {
+	if err := (TelegramConfig{BotToken: "x"}).validate(); err == nil {
+		t.Error("a token without a chat: want an error")
+	}
+	if err := (TelegramConfig{ChatID: "x"}).validate(); err == nil {
+		t.Error("a chat without a token: want an error")
+	}
+}
+
+func TestTelegramFormatsFills(t *testing.T) {
+	sink := &telegramSink{}
+	n := testTelegram(t, sink, time.Millisecond)
+	n.tap(tgFill(2, "0xab"))
+	n.tap(eventRecord{Type: evHead})
+	n.tap(eventRecord{Type: evGaveUp, Data: map[string]interface{}{"slice": int64(3), "failures": 3}})
+	n.tap(eventRecord{Type: evOrderEnded, Data: map[string]interface{}{"outcome": "filled", "summary": "TWAP Summary: order filled, filled=5/5"}})
+	n.close()
+
+	all := strings.Join(sink.texts, "\n\n")
+	for _, want := range []string{
+		"<b>Slice 3/5 filled</b>: 1.5 WETH → 3000 USDC at 2000 USDC/WETH",
+		`<a href="https://etherscan.io/tx/0xab">0xab</a>`,
+		"<b>Order filled</b>\n<code>TWAP Summary: order filled, filled=5/5</code>",
+		"<b>Gave up on slice 4/5</b>: 3 failures; it will not be attempted again",
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("messages %q lack %q", sink.texts, want)
+		}
+	}
+}
+
+// Fills that come in while a message waits its turn go out together.
+func TestTelegramBatchesBursts(t *testing.T) {
+	sink := &telegramSink{limited: 1}
+	n := testTelegram(t, sink, 100*time.Millisecond)
+	n.tap(tgFill(0, "0x01"))
+	time.Sleep(20 * time.Millisecond)
+	for i := int64(1); i < 5; i++ {
+		n.tap(tgFill(i, fmt.Sprintf("0x0%d", i+1)))
+	}
+	n.close()
+
+	if len(sink.texts) != 2 {
+		t.Fatalf("sent %d messages, want 2: %q", len(sink.texts), sink.texts)
+	}
+	if strings.Count(sink.texts[1], "filled</b>") != 4 {
+		t.Errorf("second message = %q, want the 4 later fills", sink.texts[1])
+	}
+	if sink.calls != 3 {
+		t.Errorf("%d calls, want one 429 retried", sink.calls)
+	}
+}
+
+func TestTelegramRedactsToken(t *testing.T) {
+	n := newTelegramNotifier(TelegramConfig{BotToken: "123:secret", ChatID: "1"}, nil)
+	n.apiURL = "http://127.0.0.1:0"
+	_, err := n.post(context.Background(), []byte("{}"))
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("post = %v, want an error without the token", err)
+	}
+}
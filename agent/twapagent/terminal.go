@@ -0,0 +1,122 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// Exit codes of bot mode with --exit-on-complete. 1 is log.Fatal's and 2 the
+// flag package's usage error.
+const (
+	ExitFilled    = 0
+	ExitCancelled = 3
+	ExitExpired   = 4
+)
+
+// orderEnd is how an order finished. Filled and cancelled are on-chain
+// statuses; the vault has no expiry, so expired is the agent's call: endTime
+// has passed by more than the grace period with slices still open.
+type orderEnd struct {
+	Outcome string
+	Code    int
+	// Slices not executed, when known, for an order that didn't fill.
+	SlicesLeft int64
+}
+
+func (e *orderEnd) Error() string { return "order " + e.Outcome }
+
+// terminalEnd maps a terminal order status to its outcome; nil otherwise.
+func terminalEnd(status Status) *orderEnd {
+	switch status {
+	case StatusFilled:
+		return &orderEnd{Outcome: "filled", Code: ExitFilled}
+	case StatusCancelled:
+		return &orderEnd{Outcome: "cancelled", Code: ExitCancelled}
+	}
+	return nil
+}
+
+// orderExpired reports whether blockTime is past s.EndTime plus grace.
+// Slices stay executable after endTime, so the grace gives late ones a chance.
+func orderExpired(s Strategy, blockTime uint64, grace time.Duration) bool {
+	if s.EndTime == nil || s.EndTime.Sign() == 0 {
+		return false
+	}
+	deadline := new(big.Int).Add(s.EndTime, big.NewInt(int64(grace/time.Second)))
+	return new(big.Int).SetUint64(blockTime).Cmp(deadline) > 0
+}
+
+// orderTotals are the order's cumulative figures, as in OrderStatus.
+type orderTotals struct {
+	Filled, Received, Fee *big.Int
+}
+
+// readOrderTotals reads filledAmountIn, receivedAmountOut and accruedFee.
+func readOrderTotals(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client) (orderTotals, error) {
+	var t orderTotals
+	for _, v := range []struct {
+		method string
+		dst    **big.Int
+	}{{"filledAmountIn", &t.Filled}, {"receivedAmountOut", &t.Received}, {"accruedFee", &t.Fee}} {
+		outs, err := callView(ctx, addr, cABI, client, v.method)
+		if err != nil {
+			return t, err
+		}
+		*v.dst = outs[0].(*big.Int)
+	}
+	return t, nil
+}
+
+// printTerminalSummary prints the final order figures and the agent's gas
+// spend. The vault pays the agent nothing (the fee in Fill is the venue's),
+// so the agent's net result is that spend, as a loss.
+func printTerminalSummary(w io.Writer, end *orderEnd, s Strategy, t orderTotals, gas gasSummary, a orderAmounts) {
+	fmt.Fprintln(w, terminalSummaryLine(end, s, t, a))
+	if left := unspentIn(s, t); left != nil {
+		// The vault refunds nothing by itself.
+		fmt.Fprintf(w, "- unspent tokenIn: %s, still in the vault until withdraw mode sweeps it\n", a.In(left))
+	}
+	printGasSummary(w, gas, t.Fee)
+	fmt.Fprintf(w, "- agentNet: -%s wei (-%s ETH), the vault pays no executor fee\n", gas.TotalFee, weiToEth(gas.TotalFee))
+}
+
+// terminalSummaryLine is the summary's first line, which order_ended
+// records carry too, with a's amounts; the fee is in tokenIn.
+func terminalSummaryLine(end *orderEnd, s Strategy, t orderTotals, a orderAmounts) string {
+	outcome := end.Outcome
+	switch {
+	case end.SlicesLeft == 1:
+		outcome += " with 1 slice remaining"
+	case end.SlicesLeft > 1:
+		outcome += fmt.Sprintf(" with %d slices remaining", end.SlicesLeft)
+	}
+	line := fmt.Sprintf("TWAP Summary: order %s, filled=%s/%s, received=%s, fee=%s", outcome, a.In(t.Filled), a.In(s.TotalAmountIn), a.Out(t.Received), a.In(t.Fee))
+	if left := unspentIn(s, t); left != nil {
+		line += fmt.Sprintf(", unspent=%s", a.In(left))
+	}
+	return line
+}
+
+// unspentIn is the tokenIn an order didn't swap, or nil when it swapped it
+// all or the figures are unknown.
+func unspentIn(s Strategy, t orderTotals) *big.Int {
+	if s.TotalAmountIn == nil || t.Filled == nil || t.Filled.Cmp(s.TotalAmountIn) >= 0 {
+		return nil
+	}
+	return new(big.Int).Sub(s.TotalAmountIn, t.Filled)
+}
+
+// EndConfig controls what bot mode does once the order is over.
+type EndConfig struct {
+	// Exit with the outcome's code instead of watching on.
+	ExitOnComplete bool
+	// How long past endTime an order with open slices counts as expired.
+	ExpiryGrace time.Duration
+}
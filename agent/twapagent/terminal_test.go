@@ -0,0 +1,80 @@
+package twapagent
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestTerminalEnd(t *testing.T) {
+	for status, want := range map[Status]*orderEnd{
+		0: nil,
+		1: nil,
+		2: {Outcome: "filled", Code: ExitFilled},
+		3: {Outcome: "cancelled", Code: ExitCancelled},
+	} {
+		got := terminalEnd(status)
+		if (got == nil) != (want == nil) || got != nil && *got != *want {
+			t.Errorf("terminalEnd(%d) = %+v, want %+v", status, got, want)
+		}
+	}
+}
+
+func TestOrderEndExitCode(t *testing.T) {
+	// main finds the exit code through whatever bot() wraps it in.
+	err := fmt.Errorf("bot: %w", terminalEnd(3))
+	var end *orderEnd
+	if !errors.As(err, &end) || end.Code != ExitCancelled {
+		t.Fatalf("errors.As(%v) = %+v, want code %d", err, end, ExitCancelled)
+	}
+}
+
+func TestOrderExpired(t *testing.T) {
+	s := Strategy{EndTime: big.NewInt(1000)}
+	for _, tc := range []struct {
+		blockTime uint64
+		grace     time.Duration
+		want      bool
+	}{
+		{999, 0, false},
+		{1000, 0, false},
+		{1001, 0, true},
+		{1500, 10 * time.Minute, false},
+		{1601, 10 * time.Minute, true},
+	} {
+		if got := orderExpired(s, tc.blockTime, tc.grace); got != tc.want {
+			t.Errorf("orderExpired(t=%d, grace=%s) = %v, want %v", tc.blockTime, tc.grace, got, tc.want)
+		}
+	}
+	if orderExpired(Strategy{}, 5000, 0) {
+		t.Error("an unconfigured strategy expired")
+	}
+}
+
+func TestTerminalSummaryLine(t *testing.T) {
+	s := Strategy{TotalAmountIn: big.NewInt(1000)}
+	for _, c := range []struct {
+		end    orderEnd
+		filled int64
+		want   string
+	}{
+		{orderEnd{Outcome: "filled"}, 1000, "TWAP Summary: order filled, filled=1000/1000, received=2000, fee=3"},
+		{orderEnd{Outcome: "cancelled", Code: ExitCancelled, SlicesLeft: 2}, 600, "TWAP Summary: order cancelled with 2 slices remaining, filled=600/1000, received=2000, fee=3, unspent=400"},
+		{orderEnd{Outcome: "expired", Code: ExitExpired, SlicesLeft: 1}, 900, "TWAP Summary: order expired with 1 slice remaining, filled=900/1000, received=2000, fee=3, unspent=100"},
+	} {
+		totals := orderTotals{Filled: big.NewInt(c.filled), Received: big.NewInt(2000), Fee: big.NewInt(3)}
+		if got := terminalSummaryLine(&c.end, s, totals, orderAmounts{}); got != c.want {
+			t.Errorf("got  %q\nwant %q", got, c.want)
+		}
+	}
+
+	a := orderAmounts{in: tokenInfo{Symbol: "USDC", Decimals: 6, Known: true}, out: tokenInfo{Symbol: "WETH", Decimals: 18, Known: true}}
+	s = Strategy{TotalAmountIn: big.NewInt(250_000_000)}
+	totals := orderTotals{Filled: big.NewInt(200_000_000), Received: big.NewInt(1e17), Fee: big.NewInt(600_000)}
+	want := "TWAP Summary: order cancelled, filled=200 USDC/250 USDC, received=0.1 WETH, fee=0.6 USDC, unspent=50 USDC"
+	if got := terminalSummaryLine(&orderEnd{Outcome: "cancelled"}, s, totals, a); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+package twapagent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+
+	"twap-agent/twapbind"
+)
+
+// requiredMethods are the contract methods the agent calls.
+var requiredMethods = []string{"agent", "strategy", "filledAmountIn", "totalSlices", "sliceDone", "status", "executeSlice"}
+
+// loadTwapABI returns the embedded ABI, or the one at path when set. The file
+// may be a Foundry artifact ({"abi": [...]}) or a bare ABI array. The second
+// result describes where the ABI came from.
+func loadTwapABI(path string) (abi.ABI, string, error) {
+	raw, source := twapbind.ABIJSON, "embedded Twap ABI"
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return abi.ABI{}, "", fmt.Errorf("read abi: %w", err)
+		}
+		raw, source = data, path
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+			var artifact struct{ ABI json.RawMessage }
+			if err := json.Unmarshal(data, &artifact); err != nil {
+				return abi.ABI{}, "", fmt.Errorf("unmarshal abi artifact %s: %w", path, err)
+			}
+			if artifact.ABI == nil {
+				return abi.ABI{}, "", fmt.Errorf("abi artifact %s has no \"abi\" field", path)
+			}
+			raw = artifact.ABI
+		}
+	}
+	cABI, err := abi.JSON(bytes.NewReader(raw))
+	if err != nil {
+		return abi.ABI{}, "", fmt.Errorf("parse abi from %s: %w", source, err)
+	}
+	if err := checkTwapABI(cABI); err != nil {
+		return abi.ABI{}, "", fmt.Errorf("abi from %s: %w", source, err)
+	}
+	return cABI, source, nil
+}
+
+// checkTwapABI fails if any method the agent depends on is missing.
+func checkTwapABI(cABI abi.ABI) error {
+	var missing []string
+	for _, m := range requiredMethods {
+		if _, ok := cABI.Methods[m]; !ok {
+			missing = append(missing, m)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing methods the agent needs: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
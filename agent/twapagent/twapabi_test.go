@@ -0,0 +1,51 @@
+package twapagent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"twap-agent/twapbind"
+)
+
+func TestEmbeddedTwapABI(t *testing.T) {
+	cABI, source, err := loadTwapABI("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source != "embedded Twap ABI" {
+		t.Errorf("source = %q", source)
+	}
+	for _, ev := range []string{"Fill", "OrderStatus"} {
+		if _, ok := cABI.Events[ev]; !ok {
+			t.Errorf("embedded ABI lacks event %s", ev)
+		}
+	}
+	if got := cABI.Methods["executeSlice"].Sig; got != "executeSlice(uint256)" {
+		t.Errorf("executeSlice signature = %s", got)
+	}
+	if n := len(cABI.Methods["strategy"].Outputs); n != 10 {
+		t.Errorf("strategy() has %d outputs, readStrategy expects 10", n)
+	}
+}
+
+func TestLoadTwapABIOverride(t *testing.T) {
+	dir := t.TempDir()
+	artifact := filepath.Join(dir, "Twap.json")
+	if err := os.WriteFile(artifact, append(append([]byte(`{"abi":`), twapbind.ABIJSON...), '}'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, source, err := loadTwapABI(artifact); err != nil || source != artifact {
+		t.Fatalf("artifact: source=%q err=%v", source, err)
+	}
+
+	partial := filepath.Join(dir, "partial.json")
+	if err := os.WriteFile(partial, []byte(executeTestABI), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := loadTwapABI(partial)
+	if err == nil || !strings.Contains(err.Error(), "missing methods the agent needs: agent, strategy, filledAmountIn, totalSlices, status") {
+		t.Fatalf("err = %v, want missing methods error", err)
+	}
+}
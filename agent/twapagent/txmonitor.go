@@ -0,0 +1,317 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+
+	"twap-agent/twapbind"
+)
+
+var (
+	// errWaitTimeout means the tx is still pending after --wait-timeout.
+	errWaitTimeout = errors.New("timed out waiting for receipt")
+	// errShutdown means the wait was abandoned because the agent is stopping.
+	errShutdown = errors.New("shutting down")
+	// errTxCanceled means the tx passed --tx-deadline and a cancel tx took its nonce.
+	errTxCanceled = errors.New("transaction canceled")
+	// errAtGasCeiling means a replacement can't pay more than the tx it
+	// replaces without crossing --max-fee-per-gas/--max-gas-price.
+	errAtGasCeiling = errors.New("fees already at the gas ceiling")
+)
+
+// minReplacementPercent is how much a replacement must raise every fee
+// field by for nodes to accept it instead of rejecting it as underpriced.
+const minReplacementPercent = 10
+
+// waitWithBumps waits for tx to be mined, giving up after WaitTimeout. While
+// it stays pending longer than BumpAfter, the same call is re-signed at the
+// same nonce with fees raised by BumpPercent and resubmitted, up to MaxBumps
+// times. Bumps never cross the gas ceiling: once one is clamped to it, or
+// there is no room left under it, bumping stops. Any of the submitted
+// versions may end up mined, so receipts are checked for all of them.
+//
+// Receipts are always polled on the read RPC by hash, since private relays
+// don't expose pending state. If the tx went through a private relay and is
+// still not included after the configured number of blocks, the latest
+// version is re-broadcast publicly once.
+//
+// If nothing is mined by TxDeadline, a 0-value self-transfer is sent at the
+// same nonce to cancel the call, and errTxCanceled is returned once it mines.
+func waitWithBumps(ctx context.Context, client *ethclient.Client, b *txBroadcaster, twap *twapbind.Twap, auth *bind.TransactOpts, txCfg TxConfig, tx *types.Transaction, sliceId int64) (*types.Receipt, error) {
+	waitCtx := ctx
+	if txCfg.WaitTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, txCfg.WaitTimeout)
+		defer cancel()
+	}
+	poll := txCfg.ReceiptPollInterval
+	if poll <= 0 {
+		poll = time.Second
+	}
+
+	sent := []*types.Transaction{tx}
+	bumps := 0
+	firstSent := time.Now()
+	lastSent := firstSent
+	var cancelTx *types.Transaction
+	cancelAttempted := false
+	var sentBlock uint64
+	fellBack := !b.isPrivate() || b.fallbackBlocks == 0
+	if !fellBack {
+		if n, err := blockNumber(waitCtx, client); err == nil {
+			sentBlock = n
+		} else {
+			warnf(ctx, "block number for private fallback: %v", err)
+			fellBack = true
+		}
+	}
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	bumpWindow := time.Duration(txCfg.BumpAfter) * time.Second
+
+	for {
+		if cancelTx != nil {
+			if receipt, _ := findReceipt(waitCtx, client, []*types.Transaction{cancelTx}); receipt != nil {
+				logf(ctx, "Cancel tx %s mined in block %d for slice %d", cancelTx.Hash().Hex(), receipt.BlockNumber.Uint64(), sliceId)
+				return nil, errTxCanceled
+			}
+		}
+		if receipt, i := findReceipt(waitCtx, client, sent); receipt != nil {
+			if confirmed(waitCtx, client, receipt, txCfg.Confirmations) {
+				if i > 0 {
+					logf(ctx, "Replacement tx %s mined for slice %d", sent[i].Hash().Hex(), sliceId)
+				}
+				return receipt, nil
+			}
+			// Mined: no bumps or cancel while it gathers confirmations. If a
+			// reorg drops it, its receipt goes and the wait carries on.
+			select {
+			case <-waitCtx.Done():
+				if ctx.Err() != nil {
+					return nil, errShutdown
+				}
+				return nil, errWaitTimeout
+			case <-ticker.C:
+			}
+			continue
+		}
+
+		if cancelTx == nil && txCfg.BumpAfter > 0 && bumps < txCfg.MaxBumps && time.Since(lastSent) >= bumpWindow {
+			last := sent[len(sent)-1]
+			next, capped, err := resendBumped(waitCtx, b, twap, auth, txCfg, last, sliceId)
+			switch {
+			case err == nil:
+				bumps++
+				sent = append(sent, next)
+				logf(ctx, "Bumped slice %d tx %s -> %s (bump %d/%d)", sliceId, last.Hash().Hex(), next.Hash().Hex(), bumps, txCfg.MaxBumps)
+				if capped {
+					logf(ctx, "slice %d tx %s hit the gas ceiling, no more bumps", sliceId, next.Hash().Hex())
+					bumps = txCfg.MaxBumps
+				}
+			case errors.Is(err, errAtGasCeiling):
+				logf(ctx, "slice %d tx %s is at the gas ceiling, no more bumps", sliceId, last.Hash().Hex())
+				bumps = txCfg.MaxBumps
+			case isNonceTooLow(err):
+				// One of the earlier versions was mined while we were re-signing; stop
+				// bumping and keep polling the hashes we already know about.
+				logf(ctx, "nonce %d already used while bumping slice %d, waiting for receipt of %s", last.Nonce(), sliceId, sent[0].Hash().Hex())
+				bumps = txCfg.MaxBumps
+			default:
+				// A rejected replacement still uses up a bump, so a node that
+				// keeps refusing it can't hold the loop here.
+				bumps++
+				warnf(ctx, "bump slice %d (bump %d/%d): %v", sliceId, bumps, txCfg.MaxBumps, err)
+			}
+			lastSent = time.Now()
+		}
+
+		// Don't race a bump that was just sent: give it one bump window first.
+		bumpInFlight := bumps > 0 && time.Since(lastSent) < bumpWindow
+		if txCfg.TxDeadline > 0 && !cancelAttempted && !bumpInFlight && time.Since(firstSent) >= txCfg.TxDeadline {
+			cancelAttempted = true
+			last := sent[len(sent)-1]
+			// Make sure nothing mined since the check at the top of the loop.
+			if receipt, _ := findReceipt(waitCtx, client, sent); receipt != nil {
+				return receipt, nil
+			}
+			c, capped, err := sendCancel(waitCtx, b, auth, txCfg, last)
+			switch {
+			case err == nil:
+				cancelTx = c
+				logf(ctx, "slice %d tx %s passed its %s deadline, sent cancel tx %s at nonce %d", sliceId, last.Hash().Hex(), txCfg.TxDeadline, c.Hash().Hex(), c.Nonce())
+				if capped {
+					logf(ctx, "cancel tx %s for slice %d hit the gas ceiling", c.Hash().Hex(), sliceId)
+				}
+			case isNonceTooLow(err):
+				logf(ctx, "nonce %d already used while canceling slice %d, waiting for receipt", last.Nonce(), sliceId)
+			default:
+				warnf(ctx, "cancel slice %d: %v", sliceId, err)
+			}
+		}
+
+		if !fellBack {
+			if n, err := blockNumber(waitCtx, client); err == nil && n >= sentBlock+b.fallbackBlocks {
+				last := sent[len(sent)-1]
+				logf(ctx, "private tx %s for slice %d not included after %d blocks, re-broadcasting publicly", last.Hash().Hex(), sliceId, b.fallbackBlocks)
+				if err := b.SendPublic(waitCtx, last); err != nil {
+					warnf(ctx, "public re-broadcast: %v", err)
+				}
+				fellBack = true
+			}
+		}
+
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return nil, errShutdown
+			}
+			return nil, errWaitTimeout
+		case <-ticker.C:
+		}
+	}
+}
+
+// confirmed reports whether depth blocks have built on receipt's block.
+func confirmed(ctx context.Context, client *ethclient.Client, receipt *types.Receipt, depth uint64) bool {
+	if depth == 0 {
+		return true
+	}
+	n, err := blockNumber(ctx, client)
+	return err == nil && n >= receipt.BlockNumber.Uint64()+depth
+}
+
+// findReceipt returns the receipt of whichever of txs was mined, newest first,
+// along with its index.
+func findReceipt(ctx context.Context, client *ethclient.Client, txs []*types.Transaction) (*types.Receipt, int) {
+	for i := len(txs) - 1; i >= 0; i-- {
+		var receipt *types.Receipt
+		err := rpcRead(ctx, "eth_getTransactionReceipt", func(ctx context.Context) (err error) {
+			receipt, err = client.TransactionReceipt(ctx, txs[i].Hash())
+			return err
+		})
+		if err == nil {
+			return receipt, i
+		}
+		if !errors.Is(err, ethereum.NotFound) && ctx.Err() == nil {
+			warnf(ctx, "receipt lookup %s: %v", txs[i].Hash().Hex(), err)
+		}
+	}
+	return nil, -1
+}
+
+// sendCancel replaces prev with a 0-value self-transfer at the same nonce and
+// bumped fees, so the stuck executeSlice can no longer land. capped reports
+// that the bump was clamped to the gas ceiling.
+func sendCancel(ctx context.Context, b *txBroadcaster, auth *bind.TransactOpts, txCfg TxConfig, prev *types.Transaction) (*types.Transaction, bool, error) {
+	fees, capped, err := txCfg.bumpFees(prev)
+	if err != nil {
+		return nil, false, err
+	}
+	self := auth.From
+	var inner types.TxData
+	if prev.Type() == types.DynamicFeeTxType {
+		inner = &types.DynamicFeeTx{
+			ChainID:   prev.ChainId(),
+			Nonce:     prev.Nonce(),
+			GasTipCap: fees.TipCap,
+			GasFeeCap: fees.FeeCap,
+			Gas:       params.TxGas,
+			To:        &self,
+			Value:     new(big.Int),
+		}
+	} else {
+		inner = &types.LegacyTx{
+			Nonce:    prev.Nonce(),
+			GasPrice: fees.GasPrice,
+			Gas:      params.TxGas,
+			To:       &self,
+			Value:    new(big.Int),
+		}
+	}
+	signed, err := auth.Signer(auth.From, types.NewTx(inner))
+	if err != nil {
+		return nil, false, fmt.Errorf("sign cancel: %w", err)
+	}
+	if err := b.Send(ctx, signed); err != nil {
+		return nil, false, err
+	}
+	return signed, capped, nil
+}
+
+// resendBumped re-signs the executeSlice call at prev's nonce with fees
+// raised by --bump-percent. capped reports that the bump was clamped to the
+// gas ceiling.
+func resendBumped(ctx context.Context, b *txBroadcaster, twap *twapbind.Twap, auth *bind.TransactOpts, txCfg TxConfig, prev *types.Transaction, sliceId int64) (*types.Transaction, bool, error) {
+	fees, capped, err := txCfg.bumpFees(prev)
+	if err != nil {
+		return nil, false, err
+	}
+	opts := *auth
+	opts.Nonce = new(big.Int).SetUint64(prev.Nonce())
+	// Reuse the original gas limit: re-estimating would fail if the slice has just executed.
+	opts.GasLimit = prev.Gas()
+	opts.GasPrice, opts.GasFeeCap, opts.GasTipCap = nil, nil, nil
+	fees.apply(&opts)
+	tx, err := signAndSend(ctx, b, twap, &opts, sliceId)
+	return tx, capped, err
+}
+
+// bumpFees raises prev's fees by BumpPercent for a replacement, clamped to
+// the ceiling checkCeiling applies. Without a base fee to price it by, a
+// dynamic tx's fee cap is held to --max-gas-price. capped reports that the
+// ceiling cut the bump short; errAtGasCeiling that it left too little room
+// for a replacement nodes would accept.
+func (c TxConfig) bumpFees(prev *types.Transaction) (q gasQuote, capped bool, err error) {
+	if prev.Type() == types.DynamicFeeTxType {
+		q = gasQuote{Mode: txTypeDynamic, FeeCap: bumpByPercent(prev.GasFeeCap(), c.BumpPercent), TipCap: bumpByPercent(prev.GasTipCap(), c.BumpPercent)}
+		if _, ceiling, _ := c.checkCeiling(q); ceiling != nil && q.FeeCap.Cmp(ceiling) > 0 {
+			q.FeeCap, capped = new(big.Int).Set(ceiling), true
+		}
+		if q.TipCap.Cmp(q.FeeCap) > 0 {
+			q.TipCap = new(big.Int).Set(q.FeeCap)
+		}
+		if q.FeeCap.Cmp(minReplacement(prev.GasFeeCap())) < 0 || q.TipCap.Cmp(minReplacement(prev.GasTipCap())) < 0 {
+			return gasQuote{}, false, errAtGasCeiling
+		}
+		return q, capped, nil
+	}
+	q = gasQuote{Mode: txTypeLegacy, GasPrice: bumpByPercent(prev.GasPrice(), c.BumpPercent)}
+	if _, ceiling, over := c.checkCeiling(q); over {
+		q.GasPrice, capped = new(big.Int).Set(ceiling), true
+	}
+	if q.GasPrice.Cmp(minReplacement(prev.GasPrice())) < 0 {
+		return gasQuote{}, false, errAtGasCeiling
+	}
+	return q, capped, nil
+}
+
+// minReplacement is the lowest fee a replacement can offer in place of v.
+func minReplacement(v *big.Int) *big.Int {
+	return bumpByPercent(v, minReplacementPercent)
+}
+
+// bumpByPercent returns v increased by percent, always by at least 1 wei.
+func bumpByPercent(v *big.Int, percent float64) *big.Int {
+	bps := big.NewInt(int64(percent * 100))
+	out := new(big.Int).Mul(v, new(big.Int).Add(big.NewInt(10_000), bps))
+	out.Div(out, big.NewInt(10_000))
+	if out.Cmp(v) <= 0 {
+		out.Add(v, big.NewInt(1))
+	}
+	return out
+}
+
+func isNonceTooLow(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "nonce too low")
+}
@@ -0,0 +1,65 @@
+package twapagent
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// A bump that would cross the ceiling is clamped to it, and one the ceiling
+// leaves less than a 10% raise for is refused.
+func TestBumpFeesStopsAtTheCeiling(t *testing.T) {
+	gwei := func(n int64) *big.Int { return new(big.Int).Mul(big.NewInt(n), big.NewInt(1e9)) }
+	legacy := func(price int64) *types.Transaction {
+		return types.NewTx(&types.LegacyTx{GasPrice: gwei(price)})
+	}
+	dynamic := func(tip, feeCap int64) *types.Transaction {
+		return types.NewTx(&types.DynamicFeeTx{GasTipCap: gwei(tip), GasFeeCap: gwei(feeCap)})
+	}
+	for _, tc := range []struct {
+		name         string
+		cfg          TxConfig
+		prev         *types.Transaction
+		price, tip   *big.Int // GasPrice for legacy, FeeCap for dynamic
+		capped, full bool
+	}{
+		{"legacy under", TxConfig{MaxGasPrice: gwei(200)}, legacy(100), gwei(150), nil, false, false},
+		{"legacy crossing", TxConfig{MaxGasPrice: gwei(120)}, legacy(100), gwei(120), nil, true, false},
+		{"legacy at", TxConfig{MaxGasPrice: gwei(100)}, legacy(100), nil, nil, false, true},
+		{"legacy under 10% room", TxConfig{MaxGasPrice: gwei(109)}, legacy(100), nil, nil, false, true},
+		{"legacy no ceiling", TxConfig{}, legacy(100), gwei(150), nil, false, false},
+		{"dynamic crossing", TxConfig{MaxFeePerGas: gwei(110)}, dynamic(2, 100), gwei(110), gwei(3), true, false},
+		{"dynamic by gas price", TxConfig{MaxGasPrice: gwei(110)}, dynamic(2, 100), gwei(110), gwei(3), true, false},
+		{"dynamic tip held to fee cap", TxConfig{MaxFeePerGas: gwei(110)}, dynamic(100, 100), gwei(110), gwei(110), true, false},
+		{"dynamic at", TxConfig{MaxFeePerGas: gwei(100)}, dynamic(2, 100), nil, nil, false, true},
+		{"dynamic under 10% room", TxConfig{MaxFeePerGas: gwei(105)}, dynamic(2, 100), nil, nil, false, true},
+	} {
+		tc.cfg.BumpPercent = 50
+		q, capped, err := tc.cfg.bumpFees(tc.prev)
+		if tc.full {
+			if !errors.Is(err, errAtGasCeiling) {
+				t.Errorf("%s: %v, want %v", tc.name, err, errAtGasCeiling)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if capped != tc.capped {
+			t.Errorf("%s: capped %v, want %v", tc.name, capped, tc.capped)
+		}
+		got := q.GasPrice
+		if q.Mode == txTypeDynamic {
+			got = q.FeeCap
+			if q.TipCap.Cmp(tc.tip) != 0 {
+				t.Errorf("%s: tip %s, want %s", tc.name, q.TipCap, tc.tip)
+			}
+		}
+		if got.Cmp(tc.price) != 0 {
+			t.Errorf("%s: price %s, want %s", tc.name, got, tc.price)
+		}
+	}
+}
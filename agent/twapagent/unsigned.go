@@ -0,0 +1,194 @@
+package twapagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// unsignedTx is an executeSlice call for someone else to sign and send, e.g.
+// a Safe transaction builder or an offline signer.
+type unsignedTx struct {
+	ChainID     string `json:"chainId"`
+	To          string `json:"to"`
+	Value       string `json:"value"`
+	Data        string `json:"data"`
+	Gas         string `json:"gas,omitempty"`
+	GasError    string `json:"gasEstimateError,omitempty"`
+	Method      string `json:"method"`
+	SliceID     int64  `json:"sliceId"`
+	ScheduledAt uint64 `json:"scheduledAt"`
+	Eligible    bool   `json:"eligible"`
+}
+
+// buildUnsigned packs executeSlice(sliceId) and suggests a gas limit,
+// estimated from `from` (the contract's agent when zero).
+func buildUnsigned(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, chainID uint64, txCfg TxConfig, from common.Address, sliceId int64, scheduled uint64, eligible bool) (unsignedTx, error) {
+	data, err := cABI.Pack("executeSlice", big.NewInt(sliceId))
+	if err != nil {
+		return unsignedTx{}, fmt.Errorf("pack executeSlice: %w", err)
+	}
+	if chainID == 0 {
+		id, err := client.ChainID(ctx)
+		if err != nil {
+			return unsignedTx{}, fmt.Errorf("chain id: %w", err)
+		}
+		chainID = id.Uint64()
+	}
+	out := unsignedTx{
+		ChainID:     fmt.Sprint(chainID),
+		To:          addr.Hex(),
+		Value:       "0",
+		Data:        hexutil.Encode(data),
+		Method:      "executeSlice(uint256)",
+		SliceID:     sliceId,
+		ScheduledAt: scheduled,
+		Eligible:    eligible,
+	}
+	if txCfg.GasLimit > 0 {
+		out.Gas = fmt.Sprint(txCfg.GasLimit)
+		return out, nil
+	}
+	if from == (common.Address{}) {
+		outs, err := callView(ctx, addr, cABI, client, "agent")
+		if err != nil {
+			return unsignedTx{}, fmt.Errorf("read agent: %w", err)
+		}
+		from = outs[0].(common.Address)
+	}
+	gas, err := estimateGas(ctx, client, ethereum.CallMsg{From: from, To: &addr, Data: data})
+	if err != nil {
+		if reason, ok := describeRevert(cABI, err); ok {
+			out.GasError = "revert " + reason
+		} else {
+			out.GasError = err.Error()
+		}
+		return out, nil
+	}
+	out.Gas = fmt.Sprint(gas * (100 + txCfg.GasBufferPercent) / 100)
+	return out, nil
+}
+
+// writeUnsigned writes tx as JSON to path, or to ctx's output for "-".
+func writeUnsigned(ctx context.Context, path string, tx unsignedTx) error {
+	data, err := json.MarshalIndent(tx, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = outputFrom(ctx).Write(data)
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("write unsigned tx: %w", err)
+	}
+	fmt.Fprintf(outputFrom(ctx), "Wrote unsigned executeSlice(%d) to %s\n", tx.SliceID, path)
+	return nil
+}
+
+// nextSlice is the first slice not yet executed and when it becomes due.
+type nextSlice struct {
+	ID        int64
+	Scheduled *big.Int
+	Now       *big.Int // latest block time
+}
+
+func (n nextSlice) Eligible() bool { return n.Now.Cmp(n.Scheduled) >= 0 }
+
+// checkDue refuses a slice that isn't due yet unless force is set.
+func (n nextSlice) checkDue(force bool) error {
+	if n.Eligible() || force {
+		return nil
+	}
+	return fmt.Errorf("slice %d is not eligible until %s (in ~%ss); pass --force to use it anyway", n.ID, n.Scheduled, new(big.Int).Sub(n.Scheduled, n.Now))
+}
+
+// findNextSlice returns the first unexecuted slice as of the latest block,
+// reading sliceDone for at most maxScan slices (0 = no limit).
+func findNextSlice(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, maxScan int64) (nextSlice, error) {
+	s, err := readStrategy(ctx, addr, cABI, client)
+	if err != nil {
+		return nextSlice{}, fmt.Errorf("read strategy: %w", err)
+	}
+	N, err := readTotalSlices(ctx, addr, cABI, client)
+	if err != nil {
+		return nextSlice{}, fmt.Errorf("read totalSlices: %w", err)
+	}
+	n, err := sliceCount(N)
+	if err != nil {
+		return nextSlice{}, err
+	}
+	if n == 0 {
+		return nextSlice{}, errNotInitialized
+	}
+	header, err := headerByNumber(ctx, client, nil)
+	if err != nil {
+		return nextSlice{}, fmt.Errorf("header: %w", err)
+	}
+	now := new(big.Int).SetUint64(header.Time)
+	scan, err := scanFirstUndone(ctx, addr, cABI, client, n, maxScan)
+	if err != nil {
+		return nextSlice{}, err
+	}
+	switch {
+	case scan.First >= 0:
+		scheduled, err := sliceScheduledAt(s, n, scan.First)
+		if err != nil {
+			return nextSlice{}, err
+		}
+		return nextSlice{ID: scan.First, Scheduled: scheduled, Now: now}, nil
+	case scan.Limited:
+		return nextSlice{}, fmt.Errorf("no open slice among the %d checked; raise --max-scan-slices", scan.Checked)
+	}
+	return nextSlice{}, fmt.Errorf("all slices are executed")
+}
+
+// emitNextUnsigned writes the unsigned call for the first unexecuted slice.
+// A slice that isn't due yet is refused unless txCfg.Force is set.
+func emitNextUnsigned(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, chainID uint64, txCfg TxConfig, from common.Address) error {
+	next, err := findNextSlice(ctx, addr, cABI, client, txCfg.MaxScanSlices)
+	if err != nil {
+		return err
+	}
+	if err := next.checkDue(txCfg.Force); err != nil {
+		return err
+	}
+	tx, err := buildUnsigned(ctx, addr, cABI, client, chainID, txCfg, from, next.ID, next.Scheduled.Uint64(), next.Eligible())
+	if err != nil {
+		return err
+	}
+	return writeUnsigned(ctx, txCfg.UnsignedOut, tx)
+}
+
+// emitUnsignedSlice is bot mode's stand-in for execute() with --unsigned-out:
+// it writes the call for an eligible slice once, then waits --resubmit-after
+// before writing it again if nobody has executed it.
+func (e *executor) emitUnsignedSlice(ctx context.Context, sliceId int64, scheduled uint64) {
+	if _, ok := e.st.submitted.Pending(sliceId, e.txCfg.ResubmitAfter, time.Now()); ok {
+		return
+	}
+	var from common.Address
+	if e.signer != nil {
+		from = e.signer.Address()
+	}
+	tx, err := buildUnsigned(ctx, e.addr, e.cABI, e.client, e.chainID, e.txCfg, from, sliceId, scheduled, true)
+	if err != nil {
+		logf(ctx, "unsigned executeSlice(%d): %v", sliceId, err)
+		return
+	}
+	if err := writeUnsigned(ctx, e.txCfg.UnsignedOut, tx); err != nil {
+		logf(ctx, "unsigned executeSlice(%d): %v", sliceId, err)
+		return
+	}
+	e.st.submitted.Mark(sliceId, common.Hash{}, time.Now())
+}
@@ -0,0 +1,241 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// USDConfig values slices, fills and gas in dollars in the output, from
+// Chainlink feeds. The figures are informational: nothing the agent decides
+// reads them.
+type USDConfig struct {
+	// ETH/USD, for gas.
+	EthFeed common.Address
+	// USD feeds by token, for tokenIn and tokenOut amounts.
+	TokenFeeds map[common.Address]common.Address
+	// A Chainlink Feed Registry, asked for the X/USD feed of ETH or a token
+	// without one of its own.
+	Registry common.Address
+	// An answer older than this is shown marked "(stale)"; 0 never is.
+	MaxAge time.Duration
+}
+
+func (c USDConfig) enabled() bool {
+	return c.EthFeed != (common.Address{}) || len(c.TokenFeeds) > 0 || c.Registry != (common.Address{})
+}
+
+// The Feed Registry's denominations for USD and ETH.
+var (
+	registryUSD = common.HexToAddress("0x0000000000000000000000000000000000000348")
+	registryETH = common.HexToAddress("0xEeeeeEeeeEeEeeEeEeEeeEEEeeeeEeeeeeeeEEeE")
+)
+
+// feedRegistryABIJSON is the part of FeedRegistryInterface the agent reads.
+const feedRegistryABIJSON = `[
+{"type":"function","name":"decimals","stateMutability":"view","inputs":[{"name":"base","type":"address"},{"name":"quote","type":"address"}],"outputs":[{"name":"","type":"uint8"}]},
+{"type":"function","name":"latestRoundData","stateMutability":"view","inputs":[{"name":"base","type":"address"},{"name":"quote","type":"address"}],"outputs":[{"name":"roundId","type":"uint80"},{"name":"answer","type":"int256"},{"name":"startedAt","type":"uint256"},{"name":"updatedAt","type":"uint256"},{"name":"answeredInRound","type":"uint80"}]}
+]`
+
+var feedRegistryABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(feedRegistryABIJSON))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// usdAmount is a dollar figure, to the cent, and whether the answer behind
+// it was stale.
+type usdAmount struct {
+	USD   string `json:"usd"`
+	Stale bool   `json:"stale,omitempty"`
+}
+
+func (u usdAmount) String() string {
+	if u.Stale {
+		return "$" + u.USD + " (stale)"
+	}
+	return "$" + u.USD
+}
+
+// usdPrice is a feed's latest answer.
+type usdPrice struct {
+	answer    *big.Int
+	decimals  uint8
+	updatedAt time.Time
+}
+
+// usdPricer reads the USDConfig's feeds each time it values something, so
+// a long bot run follows the price.
+type usdPricer struct {
+	client *ethclient.Client
+	cfg    USDConfig
+	// For the tokens' decimals.
+	tokens *tokenCache
+	now    func() time.Time
+}
+
+func newUSDPricer(client *ethclient.Client, cfg USDConfig, tokens *tokenCache) *usdPricer {
+	return &usdPricer{client: client, cfg: cfg, tokens: tokens, now: time.Now}
+}
+
+type usdPricerKey struct{}
+
+func withUSDPricer(ctx context.Context, p *usdPricer) context.Context {
+	return context.WithValue(ctx, usdPricerKey{}, p)
+}
+
+// usdPricerFrom is ctx's pricer; nil without any USD feed.
+func usdPricerFrom(ctx context.Context) *usdPricer {
+	p, _ := ctx.Value(usdPricerKey{}).(*usdPricer)
+	return p
+}
+
+// price reads base's USD price: base's own feed (EthFeed for registryETH),
+// else the registry's. ok is false when neither is configured.
+func (p *usdPricer) price(ctx context.Context, base common.Address) (price usdPrice, ok bool, err error) {
+	feed := p.cfg.TokenFeeds[base]
+	if base == registryETH {
+		feed = p.cfg.EthFeed
+	}
+	var outs, dec []interface{}
+	switch {
+	case feed != (common.Address{}):
+		if outs, err = callView(ctx, feed, chainlinkABI, p.client, "latestRoundData"); err == nil {
+			dec, err = callView(ctx, feed, chainlinkABI, p.client, "decimals")
+		}
+	case p.cfg.Registry != (common.Address{}):
+		feed = p.cfg.Registry
+		if outs, err = callView(ctx, feed, feedRegistryABI, p.client, "latestRoundData", base, registryUSD); err == nil {
+			dec, err = callView(ctx, feed, feedRegistryABI, p.client, "decimals", base, registryUSD)
+		}
+	default:
+		return usdPrice{}, false, nil
+	}
+	if err != nil {
+		return usdPrice{}, true, fmt.Errorf("USD feed %s: %w", feed.Hex(), err)
+	}
+	answer := outs[1].(*big.Int)
+	if answer.Sign() <= 0 {
+		return usdPrice{}, true, fmt.Errorf("USD feed %s answered %s", feed.Hex(), answer)
+	}
+	return usdPrice{answer: answer, decimals: dec[0].(uint8), updatedAt: time.Unix(outs[3].(*big.Int).Int64(), 0)}, true, nil
+}
+
+// value is amount, in units of 10^-dec, in dollars at price.
+func (p *usdPricer) value(price usdPrice, amount *big.Int, dec uint8) usdAmount {
+	return usdAmount{
+		USD:   unitsToUSD(amount, dec, price.answer, price.decimals),
+		Stale: p.cfg.MaxAge > 0 && p.now().Sub(price.updatedAt) > p.cfg.MaxAge,
+	}
+}
+
+// tokenUSD values amount of token; nil when the token has no feed or no
+// decimals, or its feed can't be read.
+func (p *usdPricer) tokenUSD(ctx context.Context, token common.Address, amount *big.Int) *usdAmount {
+	if amount == nil {
+		return nil
+	}
+	info := p.tokens.get(ctx, token)
+	if !info.Known {
+		return nil
+	}
+	price, ok, err := p.price(ctx, token)
+	if err != nil {
+		warnf(ctx, "value %s in USD: %v", info.Symbol, err)
+	}
+	if !ok || err != nil {
+		return nil
+	}
+	v := p.value(price, amount, info.Decimals)
+	return &v
+}
+
+// ethPrice reads ETH/USD, for gas; ok is false without a feed for it or
+// when it can't be read.
+func (p *usdPricer) ethPrice(ctx context.Context) (usdPrice, bool) {
+	price, ok, err := p.price(ctx, registryETH)
+	if err != nil {
+		warnf(ctx, "value gas in USD: %v", err)
+	}
+	return price, ok && err == nil
+}
+
+// weiUSD values wei of ETH, as tokenUSD does.
+func (p *usdPricer) weiUSD(ctx context.Context, wei *big.Int) *usdAmount {
+	if wei == nil {
+		return nil
+	}
+	price, ok := p.ethPrice(ctx)
+	if !ok {
+		return nil
+	}
+	v := p.value(price, wei, 18)
+	return &v
+}
+
+// unitsToUSD values amount, in units of 10^-dec, at answer USD with
+// feedDec decimals, to the cent.
+func unitsToUSD(amount *big.Int, dec uint8, answer *big.Int, feedDec uint8) string {
+	num := new(big.Int).Mul(amount, answer)
+	den := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(dec)+int64(feedDec)), nil)
+	return new(big.Rat).SetFrac(num, den).FloatString(2)
+}
+
+// fillUSD is a fill's amounts in dollars for the bot's log, e.g.
+// "$250.00 in, $249.10 out"; "" when neither token can be valued.
+func (p *usdPricer) fillUSD(ctx context.Context, s Strategy, amountIn, amountOut *big.Int) string {
+	var parts []string
+	if v := p.tokenUSD(ctx, s.TokenIn, amountIn); v != nil {
+		parts = append(parts, v.String()+" in")
+	}
+	if v := p.tokenUSD(ctx, s.TokenOut, amountOut); v != nil {
+		parts = append(parts, v.String()+" out")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// receiptGasUSD is receipt's gas cost in dollars, at its effective price
+// or fallbackPrice.
+func (p *usdPricer) receiptGasUSD(ctx context.Context, receipt *types.Receipt, fallbackPrice *big.Int) *usdAmount {
+	price := receipt.EffectiveGasPrice
+	if price == nil || price.Sign() == 0 {
+		price = fallbackPrice
+	}
+	if price == nil {
+		return nil
+	}
+	return p.weiUSD(ctx, new(big.Int).Mul(new(big.Int).SetUint64(receipt.GasUsed), price))
+}
+
+// printUSDSummary adds the order's figures in dollars to the terminal
+// summary: what was sold and received, and the agent's gas in total and
+// per slice. Nothing is printed when none can be valued.
+func (p *usdPricer) printUSDSummary(ctx context.Context, s Strategy, t orderTotals, gas gasSummary) {
+	var parts []string
+	if v := p.tokenUSD(ctx, s.TokenIn, t.Filled); v != nil {
+		parts = append(parts, "filled "+v.String())
+	}
+	if v := p.tokenUSD(ctx, s.TokenOut, t.Received); v != nil {
+		parts = append(parts, "received "+v.String())
+	}
+	if price, ok := p.ethPrice(ctx); ok && gas.TotalFee != nil {
+		line := "gas " + p.value(price, gas.TotalFee, 18).String()
+		if gas.Slices > 0 {
+			per := new(big.Int).Div(gas.TotalFee, big.NewInt(int64(gas.Slices)))
+			line += " (" + p.value(price, per, 18).String() + " per slice)"
+		}
+		parts = append(parts, line)
+	}
+	if len(parts) > 0 {
+		fmt.Fprintf(outputFrom(ctx), "- usd: %s\n", strings.Join(parts, ", "))
+	}
+}
@@ -0,0 +1,119 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+var (
+	usdToken    = common.Address{0x11} // 6 decimals, with its own feed
+	usdRegToken = common.Address{0x12} // 18 decimals, priced by the registry
+	usdFeed     = common.Address{0x21}
+	usdEthFeed  = common.Address{0x22}
+	usdRegistry = common.Address{0x23}
+)
+
+// usdEth serves two tokens, their feeds and a Feed Registry. Answers have 8
+// decimals and were updated at updatedAt.
+type usdEth struct{ updatedAt int64 }
+
+func (f *usdEth) Call(args fakeCallArgs, _ string) (hexutil.Bytes, error) {
+	round := func(answer int64) []interface{} {
+		return []interface{}{big.NewInt(1), big.NewInt(answer), big.NewInt(f.updatedAt), big.NewInt(f.updatedAt), big.NewInt(1)}
+	}
+	switch to := *args.To; to {
+	case usdToken, usdRegToken:
+		m, err := erc20ABI.MethodById(args.Data)
+		if err != nil || m.Name != "decimals" {
+			return nil, errors.New("execution reverted")
+		}
+		return m.Outputs.Pack(map[common.Address]uint8{usdToken: 6, usdRegToken: 18}[to])
+	case usdFeed, usdEthFeed:
+		m, err := chainlinkABI.MethodById(args.Data)
+		if err != nil {
+			return nil, err
+		}
+		if m.Name == "decimals" {
+			return m.Outputs.Pack(uint8(8))
+		}
+		answer := int64(100_000_000) // $1
+		if to == usdEthFeed {
+			answer = 2000_00000000
+		}
+		return m.Outputs.Pack(round(answer)...)
+	case usdRegistry:
+		m, err := feedRegistryABI.MethodById(args.Data)
+		if err != nil {
+			return nil, err
+		}
+		in, err := m.Inputs.Unpack(args.Data[4:])
+		if err != nil {
+			return nil, err
+		}
+		if in[0].(common.Address) != usdRegToken || in[1].(common.Address) != registryUSD {
+			return nil, errors.New("Feed not found")
+		}
+		if m.Name == "decimals" {
+			return m.Outputs.Pack(uint8(8))
+		}
+		return m.Outputs.Pack(round(3_50000000)...) // $3.50
+	}
+	return nil, errors.New("execution reverted")
+}
+
+func TestUnitsToUSD(t *testing.T) {
+	// 0.0021 ETH at $2500.12345678 (8 decimals) is $5.25.
+	wei := big.NewInt(2_100_000_000_000_000)
+	if got := unitsToUSD(wei, 18, big.NewInt(250012345678), 8); got != "5.25" {
+		t.Errorf("got %s, want 5.25", got)
+	}
+	// 250 USDC at $0.9998.
+	if got := unitsToUSD(big.NewInt(250_000_000), 6, big.NewInt(99_980_000), 8); got != "249.95" {
+		t.Errorf("got %s, want 249.95", got)
+	}
+}
+
+func TestUSDPricer(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	eth := &usdEth{updatedAt: now.Add(-time.Hour).Unix()}
+	client := dialFakeEth(t, eth)
+	cfg := USDConfig{
+		EthFeed:    usdEthFeed,
+		TokenFeeds: map[common.Address]common.Address{usdToken: usdFeed},
+		Registry:   usdRegistry,
+		MaxAge:     2 * time.Hour,
+	}
+	p := newUSDPricer(client, cfg, newTokenCache(client))
+	p.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if v := p.tokenUSD(ctx, usdToken, big.NewInt(250_000_000)); v == nil || v.String() != "$250.00" {
+		t.Errorf("250 tokens at $1 = %v", v)
+	}
+	if v := p.tokenUSD(ctx, usdRegToken, big.NewInt(2e18)); v == nil || v.String() != "$7.00" {
+		t.Errorf("2 tokens at the registry's $3.50 = %v", v)
+	}
+	if v := p.weiUSD(ctx, big.NewInt(1e15)); v == nil || v.String() != "$2.00" {
+		t.Errorf("0.001 ETH at $2000 = %v", v)
+	}
+	// Neither a feed nor a registry answer.
+	if v := p.tokenUSD(ctx, common.Address{0x99}, big.NewInt(1)); v != nil {
+		t.Errorf("valued a token without a feed: %v", v)
+	}
+	s := Strategy{TokenIn: usdToken, TokenOut: usdRegToken}
+	if got := p.fillUSD(ctx, s, big.NewInt(10_000_000), big.NewInt(1e18)); got != "$10.00 in, $3.50 out" {
+		t.Errorf("fillUSD = %q", got)
+	}
+
+	// An answer older than MaxAge is still shown, marked.
+	p.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if v := p.tokenUSD(ctx, usdToken, big.NewInt(1_000_000)); v == nil || v.String() != "$1.00 (stale)" {
+		t.Errorf("stale answer = %v", v)
+	}
+}
@@ -0,0 +1,281 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// Bounds configureStrategy enforces on the basis points.
+const (
+	SlippageBpsLimit  = 1500
+	DeviationBpsLimit = 2500
+)
+
+// blockTimeSpan is how many blocks back validate mode looks to estimate the
+// chain's block time.
+const blockTimeSpan = 100
+
+type severity int
+
+const (
+	sevOK severity = iota
+	sevWarn
+	sevError
+)
+
+func (s severity) String() string {
+	switch s {
+	case sevOK:
+		return "OK"
+	case sevWarn:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+// finding is one result of validate mode's checks.
+type finding struct {
+	Severity severity
+	Check    string
+	Detail   string
+}
+
+type findings []finding
+
+func (fs *findings) add(sev severity, check, format string, args ...interface{}) {
+	*fs = append(*fs, finding{Severity: sev, Check: check, Detail: fmt.Sprintf(format, args...)})
+}
+
+// firstError returns the first ERROR finding, or nil.
+func (fs findings) firstError() *finding {
+	for i := range fs {
+		if fs[i].Severity == sevError {
+			return &fs[i]
+		}
+	}
+	return nil
+}
+
+func (fs findings) count(sev severity) int {
+	n := 0
+	for _, f := range fs {
+		if f.Severity == sev {
+			n++
+		}
+	}
+	return n
+}
+
+func (fs findings) print(w io.Writer) {
+	for _, f := range fs {
+		fmt.Fprintf(w, "%-5s %-10s %s\n", f.Severity, f.Check, f.Detail)
+	}
+}
+
+// strategyRules checks s against configureStrategy's requirements and the
+// settings that pass them but make a poor order. now is the latest block
+// time. A deployed strategy was accepted when it was configured, so a start
+// in the past is expected; before deployment it would revert. agent, when
+// non-zero, must differ from the adapter.
+func strategyRules(s Strategy, now uint64, deployed bool, agent common.Address) findings {
+	var fs findings
+	zero := common.Address{}
+	switch {
+	case s.TokenIn == zero || s.TokenOut == zero:
+		fs.add(sevError, "tokens", "tokenIn and tokenOut must be non-zero ERC20 addresses")
+	case s.TokenIn == s.TokenOut:
+		fs.add(sevError, "tokens", "tokenIn and tokenOut are both %s", s.TokenIn.Hex())
+	default:
+		fs.add(sevOK, "tokens", "%s -> %s", s.TokenIn.Hex(), s.TokenOut.Hex())
+	}
+	if s.Adapter == zero || s.PriceOracle == zero {
+		fs.add(sevError, "venues", "adapter and oracle must be non-zero")
+	}
+	if agent != zero && agent == s.Adapter {
+		fs.add(sevError, "agent", "the agent %s is also the adapter, which the vault rejects", agent.Hex())
+	}
+
+	total, slice := s.TotalAmountIn, s.SliceAmountIn
+	switch {
+	case total == nil || slice == nil || total.Sign() <= 0 || slice.Sign() <= 0:
+		fs.add(sevError, "amounts", "totalAmountIn and sliceAmountIn must be positive")
+	case slice.Cmp(total) > 0:
+		fs.add(sevError, "amounts", "sliceAmountIn %s exceeds totalAmountIn %s", slice, total)
+	default:
+		N := sliceTotal(s)
+		if rem := new(big.Int).Mod(total, slice); rem.Sign() > 0 {
+			fs.add(sevWarn, "amounts", "sliceAmountIn %s doesn't divide totalAmountIn %s: %s slices, the last one swaps only %s", slice, total, N, rem)
+		} else {
+			fs.add(sevOK, "amounts", "%s slices of %s", N, slice)
+		}
+	}
+
+	at := new(big.Int).SetUint64(now)
+	switch {
+	case s.StartTime == nil || s.EndTime == nil || s.EndTime.Cmp(s.StartTime) <= 0:
+		fs.add(sevError, "window", "endTime must be after startTime")
+	default:
+		fs.add(sevOK, "window", "%ss from %s to %s", new(big.Int).Sub(s.EndTime, s.StartTime), s.StartTime, s.EndTime)
+		switch {
+		case s.StartTime.Cmp(at) > 0:
+			fs.add(sevOK, "start", "starts in %ss", new(big.Int).Sub(s.StartTime, at))
+		case !deployed:
+			fs.add(sevError, "start", "startTime %s is not after the latest block time %d; configureStrategy would revert", s.StartTime, now)
+		default:
+			fs.add(sevOK, "start", "started %ss ago", new(big.Int).Sub(at, s.StartTime))
+		}
+		if s.EndTime.Cmp(at) < 0 {
+			fs.add(sevWarn, "end", "endTime passed %ss ago; open slices can still be executed, but late", new(big.Int).Sub(at, s.EndTime))
+		}
+	}
+
+	switch {
+	case s.MaxSlippageBps > SlippageBpsLimit:
+		fs.add(sevError, "slippage", "maxSlippageBps %d is above the vault's limit of %d", s.MaxSlippageBps, SlippageBpsLimit)
+	case s.MaxSlippageBps == 0:
+		fs.add(sevWarn, "slippage", "maxSlippageBps is 0: any fill below the oracle quote reverts")
+	default:
+		fs.add(sevOK, "slippage", "%d bps", s.MaxSlippageBps)
+	}
+	switch {
+	case s.MaxPriceDeviationBps > DeviationBpsLimit:
+		fs.add(sevError, "deviation", "maxPriceDeviationBps %d is above the vault's limit of %d", s.MaxPriceDeviationBps, DeviationBpsLimit)
+	case s.MaxPriceDeviationBps == 0:
+		fs.add(sevWarn, "deviation", "maxPriceDeviationBps is 0: any oracle move from the reference price blocks execution")
+	default:
+		fs.add(sevOK, "deviation", "%d bps", s.MaxPriceDeviationBps)
+	}
+	return fs
+}
+
+// sliceTotal is totalSlices() for s: ceil(totalAmountIn / sliceAmountIn).
+func sliceTotal(s Strategy) *big.Int {
+	N := new(big.Int).Add(s.TotalAmountIn, s.SliceAmountIn)
+	return N.Sub(N, big.NewInt(1)).Div(N, s.SliceAmountIn)
+}
+
+// intervalRule compares the slice interval with the chain's block time
+// (0 when unknown).
+func intervalRule(s Strategy, blockTime uint64) finding {
+	N := sliceTotal(s)
+	interval := new(big.Int).Sub(s.EndTime, s.StartTime)
+	interval.Div(interval, N)
+	switch {
+	case interval.Sign() == 0:
+		return finding{sevWarn, "interval", fmt.Sprintf("the window is shorter than %s seconds: the interval is 0 and every slice is due at startTime", N)}
+	case blockTime > 0 && interval.Cmp(new(big.Int).SetUint64(blockTime)) < 0:
+		return finding{sevWarn, "interval", fmt.Sprintf("one slice every %ss but a block only every ~%ds: several slices come due in the same block", interval, blockTime)}
+	case blockTime > 0:
+		return finding{sevOK, "interval", fmt.Sprintf("one slice every %ss, ~%d blocks apart", interval, new(big.Int).Div(interval, new(big.Int).SetUint64(blockTime)))}
+	}
+	return finding{sevOK, "interval", fmt.Sprintf("one slice every %ss (block time unknown)", interval)}
+}
+
+// estimateBlockTime averages the block time over up to span blocks before
+// head, rounded up; 0 if there is nothing to average.
+func estimateBlockTime(ctx context.Context, client *ethclient.Client, head *types.Header, span uint64) (uint64, error) {
+	if head.Number.Uint64() < span {
+		span = head.Number.Uint64()
+	}
+	if span == 0 {
+		return 0, nil
+	}
+	past, err := headerByNumber(ctx, client, new(big.Int).Sub(head.Number, new(big.Int).SetUint64(span)))
+	if err != nil {
+		return 0, err
+	}
+	if head.Time <= past.Time {
+		return 0, nil
+	}
+	return (head.Time - past.Time + span - 1) / span, nil
+}
+
+// chainRules checks the addresses in s against the chain: the adapter and
+// oracle must be contracts, and the tokens should answer decimals().
+func chainRules(ctx context.Context, client *ethclient.Client, s Strategy) (findings, error) {
+	var fs findings
+	for _, c := range []struct {
+		check string
+		addr  common.Address
+	}{{"adapter", s.Adapter}, {"oracle", s.PriceOracle}, {"tokenIn", s.TokenIn}, {"tokenOut", s.TokenOut}} {
+		if c.addr == (common.Address{}) {
+			continue
+		}
+		code, err := client.CodeAt(ctx, c.addr, nil)
+		if err != nil {
+			return fs, fmt.Errorf("code at %s: %w", c.addr.Hex(), err)
+		}
+		if len(code) == 0 {
+			fs.add(sevError, c.check, "no contract code at %s", c.addr.Hex())
+			continue
+		}
+		if c.check == "adapter" || c.check == "oracle" {
+			fs.add(sevOK, c.check, "contract at %s", c.addr.Hex())
+			continue
+		}
+		info := readTokenInfo(ctx, client, c.addr)
+		switch {
+		case !info.Known:
+			fs.add(sevWarn, c.check, "%s doesn't answer decimals(); amounts are shown raw", c.addr.Hex())
+		case info.Decimals > 36:
+			fs.add(sevWarn, c.check, "%s reports %d decimals, which is unusual", info.Symbol, info.Decimals)
+		case c.check == "tokenIn" && s.TotalAmountIn != nil && s.SliceAmountIn != nil && s.SliceAmountIn.Sign() > 0:
+			fs.add(sevOK, c.check, "%s, %d decimals: %s in slices of %s", info.Symbol, info.Decimals, info.format(s.TotalAmountIn), info.format(s.SliceAmountIn))
+		default:
+			fs.add(sevOK, c.check, "%s, %d decimals", info.Symbol, info.Decimals)
+		}
+	}
+	return fs, nil
+}
+
+// validateStrategy is validate mode. It runs every check on s, prints the
+// findings and fails if any of them is an ERROR.
+func validateStrategy(ctx context.Context, client *ethclient.Client, s Strategy, deployed bool, agent common.Address) error {
+	head, err := headerByNumber(ctx, client, nil)
+	if err != nil {
+		return fmt.Errorf("header: %w", err)
+	}
+	fs := strategyRules(s, head.Time, deployed, agent)
+	if s.StartTime != nil && s.EndTime != nil && s.EndTime.Cmp(s.StartTime) > 0 && fs.firstError() == nil {
+		bt, err := estimateBlockTime(ctx, client, head, blockTimeSpan)
+		if err != nil {
+			return fmt.Errorf("estimate block time: %w", err)
+		}
+		fs = append(fs, intervalRule(s, bt))
+	}
+	onChain, err := chainRules(ctx, client, s)
+	if err != nil {
+		return err
+	}
+	fs = append(fs, onChain...)
+	fs.print(outputFrom(ctx))
+	fmt.Fprintf(outputFrom(ctx), "%d ok, %d warnings, %d errors\n", fs.count(sevOK), fs.count(sevWarn), fs.count(sevError))
+	if n := fs.count(sevError); n > 0 {
+		return fmt.Errorf("strategy has %d error(s)", n)
+	}
+	return nil
+}
+
+// validateDeployed runs validate mode on the vault's configured strategy.
+func validateDeployed(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client) error {
+	N, err := readTotalSlices(ctx, addr, cABI, client)
+	if err != nil {
+		return fmt.Errorf("read totalSlices: %w", err)
+	}
+	if N.Sign() == 0 {
+		return errNotInitialized
+	}
+	s, err := readStrategy(ctx, addr, cABI, client)
+	if err != nil {
+		return fmt.Errorf("read strategy: %w", err)
+	}
+	fmt.Fprintf(outputFrom(ctx), "Validating the strategy of %s\n", addr.Hex())
+	return validateStrategy(ctx, client, s, true, common.Address{})
+}
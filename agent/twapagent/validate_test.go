@@ -0,0 +1,80 @@
+package twapagent
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// severities maps each check to its worst finding.
+func severities(fs findings) map[string]severity {
+	m := map[string]severity{}
+	for _, f := range fs {
+		if f.Severity >= m[f.Check] {
+			m[f.Check] = f.Severity
+		}
+	}
+	return m
+}
+
+func TestStrategyRules(t *testing.T) {
+	base, err := validDeployConfig().parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs := strategyRules(base, 1500, false, common.Address{}); fs.count(sevWarn)+fs.count(sevError) != 0 {
+		t.Fatalf("valid strategy has findings: %+v", fs)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		now      uint64
+		deployed bool
+		mutate   func(*Strategy)
+		check    string
+		want     severity
+	}{
+		{"residual", 1500, false, func(s *Strategy) { s.SliceAmountIn = big.NewInt(300) }, "amounts", sevWarn},
+		{"zero slippage", 1500, false, func(s *Strategy) { s.MaxSlippageBps = 0 }, "slippage", sevWarn},
+		{"zero deviation", 1500, false, func(s *Strategy) { s.MaxPriceDeviationBps = 0 }, "deviation", sevWarn},
+		{"slippage bound", 1500, false, func(s *Strategy) { s.MaxSlippageBps = SlippageBpsLimit + 1 }, "slippage", sevError},
+		{"start passed before deploy", 2500, false, func(*Strategy) {}, "start", sevError},
+		{"start passed once deployed", 2500, true, func(*Strategy) {}, "start", sevOK},
+		{"end passed", 3500, true, func(*Strategy) {}, "end", sevWarn},
+		{"reversed window", 1500, false, func(s *Strategy) { s.EndTime = big.NewInt(1000) }, "window", sevError},
+		{"same tokens", 1500, false, func(s *Strategy) { s.TokenOut = s.TokenIn }, "tokens", sevError},
+		{"zero oracle", 1500, false, func(s *Strategy) { s.PriceOracle = common.Address{} }, "venues", sevError},
+	} {
+		s := base
+		s.SliceAmountIn = new(big.Int).Set(base.SliceAmountIn)
+		s.EndTime = new(big.Int).Set(base.EndTime)
+		tc.mutate(&s)
+		if got := severities(strategyRules(s, tc.now, tc.deployed, common.Address{}))[tc.check]; got != tc.want {
+			t.Errorf("%s: %s is %s, want %s", tc.name, tc.check, got, tc.want)
+		}
+	}
+
+	if got := severities(strategyRules(base, 1500, false, base.Adapter))["agent"]; got != sevError {
+		t.Errorf("agent as adapter: %s, want ERROR", got)
+	}
+}
+
+func TestIntervalRule(t *testing.T) {
+	s, err := validDeployConfig().parse() // 10 slices over 1000s
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		blockTime uint64
+		want      severity
+	}{{12, sevOK}, {100, sevOK}, {120, sevWarn}, {0, sevOK}} {
+		if got := intervalRule(s, tc.blockTime).Severity; got != tc.want {
+			t.Errorf("block time %d: %s, want %s", tc.blockTime, got, tc.want)
+		}
+	}
+	s.EndTime = big.NewInt(2005)
+	if got := intervalRule(s, 0).Severity; got != sevWarn {
+		t.Errorf("window shorter than the slice count: %s, want WARN", got)
+	}
+}
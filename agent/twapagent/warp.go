@@ -0,0 +1,87 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// devChainIDs are the chain ids of local dev nodes: anvil and hardhat
+// (31337), ganache and geth --dev (1337). Time warps are refused elsewhere
+// without --i-know-what-im-doing.
+var devChainIDs = map[uint64]bool{1337: true, 31337: true}
+
+// warpAllowed is the guard on --anvil-control.
+func warpAllowed(chainID uint64, force bool) error {
+	if devChainIDs[chainID] || force {
+		return nil
+	}
+	return fmt.Errorf("--anvil-control warps the clock of chain %d, which is not a known dev chain (1337, 31337); "+
+		"start anvil with --chain-id 31337, or pass --i-know-what-im-doing", chainID)
+}
+
+// timeWarp moves a dev node's clock to the next slice instead of waiting
+// for it (--anvil-control): evm_setNextBlockTimestamp, then evm_mine.
+type timeWarp struct {
+	client *rpc.Client
+	mu     sync.Mutex
+	// The latest time warped to; a head that hasn't caught up yet must not
+	// warp again.
+	last uint64
+}
+
+// dialTimeWarp connects to the control URL and checks that it is the node
+// chainID came from, and that chainID may be warped.
+func dialTimeWarp(ctx context.Context, url string, headers http.Header, chainID uint64, force bool) (*timeWarp, error) {
+	if err := warpAllowed(chainID, force); err != nil {
+		return nil, err
+	}
+	client, raw, err := dialRPC(ctx, url, headers)
+	if err != nil {
+		return nil, fmt.Errorf("dial anvil control: %w", err)
+	}
+	id, err := client.ChainID(ctx)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("anvil control chain id: %w", err)
+	}
+	if id.Uint64() != chainID {
+		client.Close()
+		return nil, fmt.Errorf("rpc is on chain %d but --anvil-control is on chain %s", chainID, id)
+	}
+	return &timeWarp{client: raw}, nil
+}
+
+func (w *timeWarp) Close() { w.client.Close() }
+
+// to mines a block at ts, unless a warp there was already made.
+func (w *timeWarp) to(ctx context.Context, ts uint64) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if ts <= w.last {
+		return false, nil
+	}
+	if err := w.client.CallContext(ctx, nil, "evm_setNextBlockTimestamp", ts); err != nil {
+		return false, fmt.Errorf("evm_setNextBlockTimestamp: %w", err)
+	}
+	if err := w.client.CallContext(ctx, nil, "evm_mine"); err != nil {
+		return false, fmt.Errorf("evm_mine: %w", err)
+	}
+	w.last = ts
+	return true, nil
+}
+
+// warpTo jumps to ts, logging rather than failing: without the warp the
+// bot just waits for the slice.
+func warpTo(ctx context.Context, w *timeWarp, ts uint64) {
+	warped, err := w.to(ctx, ts)
+	switch {
+	case err != nil:
+		logf(ctx, "time warp to %d: %v", ts, err)
+	case warped:
+		logf(ctx, "Warped chain time to %d", ts)
+	}
+}
@@ -0,0 +1,70 @@
+package twapagent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWarpAllowed(t *testing.T) {
+	for _, id := range []uint64{1337, 31337} {
+		if err := warpAllowed(id, false); err != nil {
+			t.Errorf("chain %d: %v", id, err)
+		}
+	}
+	if err := warpAllowed(1, false); err == nil {
+		t.Error("warping mainnet's chain id: want an error")
+	}
+	if err := warpAllowed(1, true); err != nil {
+		t.Errorf("chain 1 with --i-know-what-im-doing: %v", err)
+	}
+}
+
+// Without a block miner the chain only moves when the bot warps it or
+// sends a slice, so the order fills through warps alone.
+func TestRunWarpsToEachSlice(t *testing.T) {
+	for _, driver := range []string{driverBlocks, driverTimer} {
+		t.Run(driver, func(t *testing.T) {
+			cfg := devnetConfig(t, 0)
+			d := startTestDevnet(t, cfg)
+			bcfg := d.botConfig(cfg)
+			bcfg.Driver.Driver = driver
+			bcfg.Feed.PollInterval = 10 * time.Millisecond
+			bcfg.AnvilControl = d.url
+			a, err := New(bcfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer a.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			err = a.Run(ctx)
+			var end *orderEnd
+			if !errors.As(err, &end) || end.Code != ExitFilled {
+				t.Fatalf("Run = %v, want the order filled", err)
+			}
+			if a.warp.last < d.chain.vault(d.vault).s.StartTime.Uint64() {
+				t.Errorf("last warp to %d, before the order's start", a.warp.last)
+			}
+		})
+	}
+}
+
+func TestDevEVMRefusesThePast(t *testing.T) {
+	cfg := devnetConfig(t, 0)
+	d := startTestDevnet(t, cfg)
+	if err := (devEVM{d.chain}).SetNextBlockTimestamp(d.chain.headTime()); err == nil {
+		t.Error("setting the next block to the head's time: want an error")
+	}
+}
+
+func TestNewRejectsWarpOutsideBot(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Mode, cfg.RPC, cfg.Contracts = "once", "http://127.0.0.1:0", []string{"0x01"}
+	cfg.AnvilControl = cfg.RPC
+	if _, err := New(cfg); err == nil {
+		t.Error("--anvil-control in once mode: want an error")
+	}
+}
@@ -0,0 +1,171 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// orderWatch is watch mode's view of the order, kept current from events.
+type orderWatch struct {
+	s      Strategy
+	n      int64
+	totals orderTotals
+	done   sliceBitmap
+	// How s's token amounts are printed.
+	amounts orderAmounts
+	// The schedule line last printed, so each head doesn't repeat it.
+	shownNext int64
+	shownDue  bool
+}
+
+// load reads the strategy, the order totals and the sliceDone bitmap.
+func (w *orderWatch) load(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, rc *rpc.Client) error {
+	s, err := readStrategy(ctx, addr, cABI, client)
+	if err != nil {
+		return fmt.Errorf("read strategy: %w", err)
+	}
+	N, err := readTotalSlices(ctx, addr, cABI, client)
+	if err != nil {
+		return fmt.Errorf("read totalSlices: %w", err)
+	}
+	n, err := sliceCount(N)
+	if err != nil {
+		return err
+	}
+	totals, err := readOrderTotals(ctx, addr, cABI, client)
+	if err != nil {
+		return err
+	}
+	if err := loadSliceBitmap(ctx, &w.done, addr, cABI, client, rc, false, n); err != nil {
+		return fmt.Errorf("load sliceDone: %w", err)
+	}
+	w.s, w.n, w.totals = s, n, totals
+	w.amounts = orderAmountsFor(ctx, s)
+	w.shownNext, w.shownDue = -1, false
+	return nil
+}
+
+// progress is the running filled/total line.
+func (w *orderWatch) progress() string {
+	pct := "0.0"
+	if w.totals.Filled != nil && w.s.TotalAmountIn != nil && w.s.TotalAmountIn.Sign() > 0 {
+		r := new(big.Rat).SetFrac(new(big.Int).Mul(w.totals.Filled, big.NewInt(100)), w.s.TotalAmountIn)
+		pct = r.FloatString(1)
+	}
+	return fmt.Sprintf("Progress: filled=%s/%s (%s%%), slices=%d/%d, received=%s, fee=%s",
+		w.amounts.In(w.totals.Filled), w.amounts.In(w.s.TotalAmountIn), pct, expectedFirstUndone(w.s, w.totals.Filled, w.n), w.n, w.amounts.Out(w.totals.Received), w.amounts.In(w.totals.Fee))
+}
+
+// schedule describes the next open slice as of block time now, or returns ""
+// if that is what was printed last.
+func (w *orderWatch) schedule(now uint64) string {
+	if w.n == 0 {
+		return ""
+	}
+	first := w.done.FirstUndone(func(int64) bool { return false })
+	if first < 0 {
+		return ""
+	}
+	scheduled, err := sliceScheduledAt(w.s, w.n, first)
+	if err != nil {
+		return ""
+	}
+	at := new(big.Int).SetUint64(now)
+	due := at.Cmp(scheduled) >= 0
+	if first == w.shownNext && due == w.shownDue {
+		return ""
+	}
+	w.shownNext, w.shownDue = first, due
+	if due {
+		return fmt.Sprintf("Slice %d is due (scheduled at %s)", first, scheduled)
+	}
+	return fmt.Sprintf("Next slice %d scheduled at %s (in ~%ss)", first, scheduled, new(big.Int).Sub(scheduled, at))
+}
+
+// watch follows the order live without a key: it prints Fill and OrderStatus
+// events, a progress line after each fill and when the next slice comes due.
+// It never submits anything, and polls over an http(s) RPC like bot mode.
+func watch(ctx context.Context, addr common.Address, cABI abi.ABI, client *ethclient.Client, rc *rpc.Client, feedCfg FeedConfig) error {
+	w := &orderWatch{}
+	if err := w.load(ctx, addr, cABI, client, rc); err != nil {
+		return err
+	}
+	feedCfg.topics = eventTopics(cABI, feedEvents)
+	feed, err := openFeed(ctx, client, []common.Address{addr}, feedCfg, 0)
+	if err != nil {
+		return err
+	}
+	defer feed.Close()
+	fmt.Fprintf(outputFrom(ctx), "Watching %s\n", addr.Hex())
+	if w.n == 0 {
+		fmt.Fprintln(outputFrom(ctx), errNotInitialized)
+	} else {
+		fmt.Fprintln(outputFrom(ctx), w.progress())
+	}
+
+	// reload follows a reconfiguration; the next head reprints the schedule.
+	reload := func() {
+		if err := w.load(ctx, addr, cABI, client, rc); err != nil {
+			logf(ctx, "reload order: %v", err)
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return errInterrupted
+		case h := <-feed.Heads():
+			if h == nil {
+				continue
+			}
+			if line := w.schedule(h.Time); line != "" {
+				fmt.Fprintln(outputFrom(ctx), line)
+			}
+		case lg := <-feed.Logs():
+			if len(lg.Topics) == 0 {
+				continue
+			}
+			ev, err := cABI.EventByID(lg.Topics[0])
+			if err != nil {
+				continue
+			}
+			switch ev.Name {
+			case "Fill":
+				var out FillEvent
+				if err := unpackLog(cABI, &out, "Fill", lg); err != nil {
+					continue
+				}
+				if lg.Removed {
+					fmt.Fprintf(outputFrom(ctx), "[Event] Fill removed by reorg: slice=%s\n", out.SliceId)
+					w.done.Set(out.SliceId.Int64(), false)
+					continue
+				}
+				fmt.Fprintf(outputFrom(ctx), "[Event] Fill: slice=%s in=%s out=%s fee=%s\n", out.SliceId, w.amounts.In(out.AmountIn), w.amounts.Out(out.AmountOut), w.amounts.In(out.Fee))
+				w.done.Set(out.SliceId.Int64(), true)
+			case "Unpaused":
+				reload()
+			case "OrderStatus":
+				var out OrderStatusEvent
+				if err := unpackLog(cABI, &out, "OrderStatus", lg); err != nil || lg.Removed {
+					continue
+				}
+				status := Status(out.Status)
+				fmt.Fprintf(outputFrom(ctx), "[Event] OrderStatus: filled=%s received=%s fee=%s status=%s\n", w.amounts.In(out.FilledAmountIn), w.amounts.Out(out.ReceivedAmountOut), w.amounts.In(out.Fee), status.describe())
+				if status == StatusOpen { // configureStrategy reset the order
+					reload()
+					continue
+				}
+				w.totals = orderTotals{Filled: out.FilledAmountIn, Received: out.ReceivedAmountOut, Fee: out.Fee}
+				fmt.Fprintln(outputFrom(ctx), w.progress())
+				if end := terminalEnd(status); end != nil {
+					fmt.Fprintf(outputFrom(ctx), "Order %s\n", end.Outcome)
+				}
+			}
+		}
+	}
+}
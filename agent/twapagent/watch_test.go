@@ -0,0 +1,33 @@
+package twapagent
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestOrderWatchProgressAndSchedule(t *testing.T) {
+	// 24 slices from t=1000 to 3000 (every 83s), 5 done, 50 of 1000 filled.
+	_, twap, cABI, client, rc := newVaultRPC(t, 24, 5)
+	w := &orderWatch{}
+	if err := w.load(context.Background(), twap.Address(), cABI, client, rc); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := w.progress(), "filled=50/1000 (5.0%), slices=5/24"; !strings.Contains(got, want) {
+		t.Fatalf("progress = %q, want it to contain %q", got, want)
+	}
+
+	if got := w.schedule(1000); got != "Next slice 5 scheduled at 1415 (in ~415s)" {
+		t.Fatalf("schedule = %q", got)
+	}
+	if got := w.schedule(1001); got != "" {
+		t.Fatalf("unchanged schedule printed again: %q", got)
+	}
+	if got := w.schedule(1415); got != "Slice 5 is due (scheduled at 1415)" {
+		t.Fatalf("schedule once due = %q", got)
+	}
+	w.done.Set(5, true)
+	if got := w.schedule(1420); got != "Next slice 6 scheduled at 1498 (in ~78s)" {
+		t.Fatalf("schedule after a fill = %q", got)
+	}
+}
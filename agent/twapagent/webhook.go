@@ -0,0 +1,134 @@
+package twapagent
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// WebhookConfig is where bot mode POSTs its significant events.
+type WebhookConfig struct {
+	URLs []string
+	// Signs each body with HMAC-SHA256, sent as X-Twap-Signature.
+	Secret string
+	// Tries per delivery and URL, with the wait doubling from 1s between them.
+	MaxAttempts int
+}
+
+func (c WebhookConfig) validate() error {
+	for _, u := range c.URLs {
+		p, err := url.Parse(u)
+		if err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+			return fmt.Errorf("invalid --webhook-url %q (want an http or https URL)", u)
+		}
+	}
+	if c.Secret != "" && len(c.URLs) == 0 {
+		return errors.New("--webhook-secret requires --webhook-url")
+	}
+	if len(c.URLs) > 0 && c.MaxAttempts < 1 {
+		return fmt.Errorf("webhook-max-attempts must be at least 1, got %d", c.MaxAttempts)
+	}
+	return nil
+}
+
+// webhookPayload is the JSON body of each POST.
+type webhookPayload struct {
+	Event    string    `json:"event"`
+	Severity string    `json:"severity"`
+	Time     time.Time `json:"time"`
+	ChainID  uint64    `json:"chainId"`
+	// Empty for chain-wide events with several vaults.
+	Contract string                 `json:"contract,omitempty"`
+	Block    uint64                 `json:"block,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+// webhookNotifier POSTs every notification to each of its URLs as a
+// webhookPayload, fills priced and with their tx's gas.
+type webhookNotifier struct {
+	cfg    WebhookConfig
+	client *ethclient.Client
+	format *notifyFormatter
+	poster jsonPoster
+	q      *notifyQueue
+	ctx    context.Context
+}
+
+func newWebhookNotifier(cfg WebhookConfig, client *ethclient.Client, f *notifyFormatter) *webhookNotifier {
+	return &webhookNotifier{cfg: cfg, client: client, format: f, poster: newJSONPoster("webhook", cfg.MaxAttempts), q: newNotifyQueue("webhooks")}
+}
+
+func (n *webhookNotifier) start(ctx context.Context) {
+	n.ctx = ctx
+	n.q.start(ctx, n.send)
+	logf(ctx, "notifying %d webhook(s) of fills, failures, halts and the order's end", len(n.cfg.URLs))
+}
+
+func (n *webhookNotifier) notify(note notification) { n.q.push(note) }
+
+func (n *webhookNotifier) close() { n.q.close() }
+
+func (n *webhookNotifier) send(ctx context.Context, note notification) {
+	p := webhookPayload{Event: note.kind, Severity: note.severity.String(), Time: note.time, ChainID: note.chainID, Block: note.block, Data: note.data}
+	if note.contract != (common.Address{}) {
+		p.Contract = note.contract.Hex()
+	}
+	if note.kind == notifySliceExecuted {
+		p.Data = n.priceFill(ctx, note)
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		warnf(n.ctx, "webhook %s: %v", p.Event, err)
+		return
+	}
+	header := http.Header{"X-Twap-Event": {p.Event}}
+	if n.cfg.Secret != "" {
+		header.Set("X-Twap-Signature", webhookSignature(n.cfg.Secret, body))
+	}
+	for _, u := range n.cfg.URLs {
+		n.poster.deliver(ctx, n.ctx, u, p.Event, body, header)
+	}
+}
+
+// priceFill adds a fill's price, in whole tokenOut per tokenIn, and its
+// tx's gas to a copy of its data. Either is left out when it can't be read.
+func (n *webhookNotifier) priceFill(ctx context.Context, note notification) map[string]interface{} {
+	data := make(map[string]interface{}, len(note.data)+4)
+	for k, v := range note.data {
+		data[k] = v
+	}
+	if p, ok := n.format.price(ctx, note); ok {
+		data["price"] = p
+		data["tokenIn"] = n.format.tokens.get(ctx, note.tokenIn).Symbol
+		data["tokenOut"] = n.format.tokens.get(ctx, note.tokenOut).Symbol
+	}
+	if tx, ok := data["tx"].(string); ok && n.client != nil {
+		if r, err := n.client.TransactionReceipt(ctx, common.HexToHash(tx)); err == nil {
+			data["gasUsed"] = r.GasUsed
+			if r.EffectiveGasPrice != nil {
+				data["effectiveGasPrice"] = r.EffectiveGasPrice.String()
+				data["gasCostWei"] = new(big.Int).Mul(r.EffectiveGasPrice, new(big.Int).SetUint64(r.GasUsed)).String()
+			}
+		}
+	}
+	return data
+}
+
+// webhookSignature is X-Twap-Signature: sha256= and the hex HMAC-SHA256 of
+// body keyed with secret.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
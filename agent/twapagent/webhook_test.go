@@ -0,0 +1,178 @@
+package twapagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// webhookSink records the payloads POSTed to it, failing the first fail
+// requests with a 500.
+type webhookSink struct {
+	t      *testing.T
+	secret string
+	mu     sync.Mutex
+	fail   int
+	calls  int
+	got    []webhookPayload
+}
+
+func (s *webhookSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.fail > 0 {
+		s.fail--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if s.secret != "" && r.Header.Get("X-Twap-Signature") != webhookSignature(s.secret, body) {
+		s.t.Errorf("bad signature %q", r.Header.Get("X-Twap-Signature"))
+	}
+	var p webhookPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		s.t.Error(err)
+	}
+	if r.Header.Get("X-Twap-Event") != p.Event {
+		s.t.Errorf("X-Twap-Event %q for a %s", r.Header.Get("X-Twap-Event"), p.Event)
+	}
+	s.got = append(s.got, p)
+}
+
+func (s *webhookSink) events() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for _, p := range s.got {
+		out = append(out, p.Event)
+	}
+	return out
+}
+
+// testNotifier POSTs vaults' notifications, which only have a strategy
+// cache, retrying without waiting.
+func testNotifier(cfg WebhookConfig, addrs ...common.Address) *notifyHub {
+	n := newWebhookNotifier(cfg, nil, &notifyFormatter{chainID: 31337, tokens: newTokenCache(nil)})
+	n.poster.backoff = time.Millisecond
+	var vaults []*vaultBot
+	for _, addr := range addrs {
+		vaults = append(vaults, testVault(addr, Strategy{}, nil))
+	}
+	return testHub(31337, n, vaults...)
+}
+
+func TestWebhookConfigValidate(t *testing.T) {
+	for _, c := range []WebhookConfig{
+		{URLs: []string{"ftp://example.com/hook"}, MaxAttempts: 1},
+		{URLs: []string{"/hook"}, MaxAttempts: 1},
+		{URLs: []string{"https://example.com/hook"}},
+		{Secret: "s"},
+	} {
+		if err := c.validate(); err == nil {
+			t.Errorf("%+v: want an error", c)
+		}
+	}
+	if err := (WebhookConfig{URLs: []string{"https://example.com/hook"}, Secret: "s", MaxAttempts: 1}).validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWebhookSignsAndRetries(t *testing.T) {
+	sink := &webhookSink{t: t, secret: "k", fail: 2}
+	srv := httptest.NewServer(sink)
+	defer srv.Close()
+	vault := common.HexToAddress("0x01")
+	n := testNotifier(WebhookConfig{URLs: []string{srv.URL}, Secret: "k", MaxAttempts: 3}, vault)
+
+	n.tap(eventRecord{Type: evFill, Block: 7, Data: map[string]interface{}{"slice": int64(2), "amountIn": "10", "amountOut": "20", "fee": "0", "tx": "0xab"}})
+	n.tap(eventRecord{Type: evHalted, Data: map[string]interface{}{"reason": "stale oracle"}})
+	n.close()
+
+	if got := sink.events(); len(got) != 2 || got[0] != notifySliceExecuted || got[1] != evHalted {
+		t.Fatalf("delivered %v", got)
+	}
+	if sink.calls != 4 {
+		t.Errorf("%d requests, want 2 failed and 2 delivered", sink.calls)
+	}
+	p := sink.got[0]
+	if p.Severity != "info" || sink.got[1].Severity != "alert" {
+		t.Errorf("severities %q and %q", p.Severity, sink.got[1].Severity)
+	}
+	if p.Contract != vault.Hex() || p.ChainID != 31337 || p.Block != 7 || p.Data["amountOut"] != "20" || p.Data["tx"] != "0xab" {
+		t.Errorf("payload = %+v", p)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	for _, c := range []struct {
+		code, want int
+	}{
+		{http.StatusInternalServerError, 3},
+		{http.StatusTooManyRequests, 3},
+		// Retrying won't fix a bad request.
+		{http.StatusBadRequest, 1},
+	} {
+		calls := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(c.code)
+		}))
+		n := testNotifier(WebhookConfig{URLs: []string{srv.URL}, MaxAttempts: 3}, common.HexToAddress("0x01"))
+		n.tap(eventRecord{Type: evPaused})
+		n.close()
+		srv.Close()
+		if calls != c.want {
+			t.Errorf("HTTP %d: %d attempts, want %d", c.code, calls, c.want)
+		}
+	}
+}
+
+// A devnet order notifies its slices, priced and with their gas, and its end.
+func TestRunNotifiesWebhooks(t *testing.T) {
+	sink := &webhookSink{t: t, secret: "k"}
+	srv := httptest.NewServer(sink)
+	defer srv.Close()
+	cfg := devnetConfig(t, 5*time.Millisecond)
+	d := startTestDevnet(t, cfg)
+	bcfg := d.botConfig(cfg)
+	bcfg.Notify.Webhooks = WebhookConfig{URLs: []string{srv.URL}, Secret: "k", MaxAttempts: 1}
+	a, err := New(bcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var end *orderEnd
+	if err := a.Run(ctx); !errors.As(err, &end) || end.Code != ExitFilled {
+		t.Fatalf("Run = %v, want the order filled", err)
+	}
+
+	fills := 0
+	for _, p := range sink.got {
+		switch p.Event {
+		case notifySliceExecuted:
+			fills++
+			if p.Data["price"] == nil || p.Data["gasUsed"] == nil || p.Data["tx"] == nil {
+				t.Errorf("slice_executed = %+v", p.Data)
+			}
+		case evOrderEnded:
+			if p.Data["outcome"] != "filled" || p.Data["filledAmountIn"] == nil {
+				t.Errorf("order_ended = %+v", p.Data)
+			}
+		}
+	}
+	// The bot can see the order filled at a head before the last Fill logs.
+	if evs := sink.events(); fills == 0 || evs[len(evs)-1] != evOrderEnded {
+		t.Errorf("delivered %v, want slice_executed and then order_ended", evs)
+	}
+}
@@ -0,0 +1,67 @@
+package twapagent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// withdraw is withdraw mode: as the owner, sweep the vault's tokenOut and
+// any tokenIn left over from a partial fill to `to` (the owner when zero).
+// An order that can still fill is refused unless Tx.Force is set, since
+// sweeping takes the tokenIn its remaining slices would swap.
+func (e *executor) withdraw(ctx context.Context, to common.Address) error {
+	if err := e.checkOwner(ctx); err != nil {
+		return err
+	}
+	status, err := readStatus(ctx, e.addr, e.cABI, e.client)
+	if err != nil {
+		return err
+	}
+	if !status.Terminal() && !e.txCfg.Force {
+		return fmt.Errorf("order is still %s; sweeping now would take the tokenIn its remaining slices need (pass --force to withdraw anyway)", status)
+	}
+	s, err := readStrategy(ctx, e.addr, e.cABI, e.client)
+	if err != nil {
+		return fmt.Errorf("read strategy: %w", err)
+	}
+	if to == (common.Address{}) {
+		to = e.signer.Address()
+	}
+
+	swept := 0
+	for _, token := range []common.Address{s.TokenOut, s.TokenIn} {
+		info := readTokenInfo(ctx, e.client, token)
+		bal, err := readTokenBalance(ctx, e.client, token, e.addr)
+		if err != nil {
+			return err
+		}
+		if bal.Sign() == 0 {
+			fmt.Fprintf(outputFrom(ctx), "No %s in the vault\n", info.Symbol)
+			continue
+		}
+		fmt.Fprintf(outputFrom(ctx), "Sweeping %s to %s\n", info.format(bal), to.Hex())
+		data, err := e.cABI.Pack("sweep", token, to)
+		if err != nil {
+			return fmt.Errorf("pack sweep: %w", err)
+		}
+		token := token
+		receipt, err := e.sendOwnerTx(ctx, e.addr, e.cABI, "sweep", data, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+			return e.twap.Sweep(auth, token, to)
+		})
+		if err != nil {
+			return err
+		}
+		for _, tr := range transfersFromLogs(receipt.Logs) {
+			fmt.Fprintf(outputFrom(ctx), "[Event] Transfer: %s from %s to %s\n", readTokenInfo(ctx, e.client, tr.Token).format(tr.Value), tr.From.Hex(), tr.To.Hex())
+		}
+		swept++
+	}
+	if swept == 0 {
+		fmt.Fprintln(outputFrom(ctx), "Nothing to withdraw")
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package twapagent
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestWithdrawRefusesActiveOrder(t *testing.T) {
+	// The mock vault is PartialFilled.
+	_, twap, cABI, client, _ := newVaultRPC(t, 24, 5)
+	e := &executor{addr: twap.Address(), cABI: cABI, twap: twap, client: client, signer: dryRunSigner{vaultOwner}, chainID: fakeChainID, st: &botState{txClient: client}}
+	err := e.withdraw(context.Background(), common.Address{})
+	if err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Fatalf("withdraw on an active order: err = %v, want a refusal mentioning --force", err)
+	}
+}
+
+func TestFormatUnits(t *testing.T) {
+	for _, tc := range []struct {
+		v        string
+		decimals uint8
+		want     string
+	}{
+		{"1500000000000000000", 18, "1.5"},
+		{"1000000", 6, "1"},
+		{"42", 6, "0.000042"},
+		{"0", 18, "0"},
+		{"-2500", 3, "-2.5"},
+		{"123", 0, "123"},
+	} {
+		v, _ := new(big.Int).SetString(tc.v, 10)
+		if got := formatUnits(v, tc.decimals); got != tc.want {
+			t.Errorf("formatUnits(%s, %d) = %s, want %s", tc.v, tc.decimals, got, tc.want)
+		}
+	}
+	if got := (tokenInfo{Symbol: "USDC", Decimals: 6, Known: true}).format(big.NewInt(2_500_000)); got != "2.5 USDC" {
+		t.Errorf("format = %q", got)
+	}
+}
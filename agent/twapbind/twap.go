@@ -0,0 +1,182 @@
+// Package twapbind is a typed Go binding for the Twap vault (src/Twap.sol),
+// in the shape abigen produces, limited to what the agent uses.
+package twapbind
+
+import (
+	_ "embed"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ABIJSON is the contract ABI. Regenerate it with
+// `forge inspect Twap abi > agent/twapbind/twap_abi.json` after changing the contract.
+//
+//go:embed twap_abi.json
+var ABIJSON []byte
+
+// ParseABI parses ABIJSON.
+func ParseABI() (abi.ABI, error) {
+	return abi.JSON(strings.NewReader(string(ABIJSON)))
+}
+
+// Strategy mirrors Twap.Strategy as returned by the strategy() getter.
+type Strategy struct {
+	TokenIn              common.Address
+	TokenOut             common.Address
+	Adapter              common.Address
+	PriceOracle          common.Address
+	TotalAmountIn        *big.Int
+	SliceAmountIn        *big.Int
+	StartTime            *big.Int
+	EndTime              *big.Int
+	MaxSlippageBps       uint16
+	MaxPriceDeviationBps uint16
+}
+
+// Twap is a Twap vault at a fixed address.
+type Twap struct {
+	address  common.Address
+	abi      abi.ABI
+	contract *bind.BoundContract
+}
+
+// NewTwap binds the vault at address using parsed, which may be the embedded
+// ABI or an override for a modified contract.
+func NewTwap(address common.Address, parsed abi.ABI, caller bind.ContractCaller, transactor bind.ContractTransactor, filterer bind.ContractFilterer) *Twap {
+	return &Twap{
+		address:  address,
+		abi:      parsed,
+		contract: bind.NewBoundContract(address, parsed, caller, transactor, filterer),
+	}
+}
+
+// Address returns the vault address.
+func (t *Twap) Address() common.Address { return t.address }
+
+// Strategy calls strategy(). Outputs are matched to fields by name, so a
+// contract that grows the struct fails with an error instead of misreading.
+func (t *Twap) Strategy(opts *bind.CallOpts) (Strategy, error) {
+	var out []interface{}
+	if err := t.contract.Call(opts, &out, "strategy"); err != nil {
+		return Strategy{}, err
+	}
+	return t.strategyFrom(out)
+}
+
+// UnpackStrategy decodes strategy() return data fetched some other way, such
+// as in a JSON-RPC batch.
+func (t *Twap) UnpackStrategy(data []byte) (Strategy, error) {
+	out, err := t.abi.Unpack("strategy", data)
+	if err != nil {
+		return Strategy{}, fmt.Errorf("decode strategy(): %w", err)
+	}
+	return t.strategyFrom(out)
+}
+
+func (t *Twap) strategyFrom(out []interface{}) (Strategy, error) {
+	var s Strategy
+	if err := t.abi.Methods["strategy"].Outputs.Copy(&s, out); err != nil {
+		return Strategy{}, fmt.Errorf("decode strategy(): %w", err)
+	}
+	return s, nil
+}
+
+// FilledAmountIn calls filledAmountIn().
+func (t *Twap) FilledAmountIn(opts *bind.CallOpts) (*big.Int, error) {
+	return callUint(t, opts, "filledAmountIn")
+}
+
+// TotalSlices calls totalSlices().
+func (t *Twap) TotalSlices(opts *bind.CallOpts) (*big.Int, error) {
+	return callUint(t, opts, "totalSlices")
+}
+
+// AccruedFee calls accruedFee().
+func (t *Twap) AccruedFee(opts *bind.CallOpts) (*big.Int, error) {
+	return callUint(t, opts, "accruedFee")
+}
+
+// SliceDone calls sliceDone(sliceId).
+func (t *Twap) SliceDone(opts *bind.CallOpts, sliceId *big.Int) (bool, error) {
+	var out []interface{}
+	if err := t.contract.Call(opts, &out, "sliceDone", sliceId); err != nil {
+		return false, err
+	}
+	return *abi.ConvertType(out[0], new(bool)).(*bool), nil
+}
+
+// Status calls status(): 0 Open, 1 PartialFilled, 2 Filled, 3 Cancelled.
+func (t *Twap) Status(opts *bind.CallOpts) (uint8, error) {
+	var out []interface{}
+	if err := t.contract.Call(opts, &out, "status"); err != nil {
+		return 0, err
+	}
+	return *abi.ConvertType(out[0], new(uint8)).(*uint8), nil
+}
+
+// Agent calls agent().
+func (t *Twap) Agent(opts *bind.CallOpts) (common.Address, error) {
+	var out []interface{}
+	if err := t.contract.Call(opts, &out, "agent"); err != nil {
+		return common.Address{}, err
+	}
+	return *abi.ConvertType(out[0], new(common.Address)).(*common.Address), nil
+}
+
+// Owner calls owner().
+func (t *Twap) Owner(opts *bind.CallOpts) (common.Address, error) {
+	var out []interface{}
+	if err := t.contract.Call(opts, &out, "owner"); err != nil {
+		return common.Address{}, err
+	}
+	return *abi.ConvertType(out[0], new(common.Address)).(*common.Address), nil
+}
+
+// Cancel sends cancel().
+func (t *Twap) Cancel(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return t.contract.Transact(opts, "cancel")
+}
+
+// Sweep sends sweep(token, to).
+func (t *Twap) Sweep(opts *bind.TransactOpts, token, to common.Address) (*types.Transaction, error) {
+	return t.contract.Transact(opts, "sweep", token, to)
+}
+
+// SetAgent sends setAgent(newAgent).
+func (t *Twap) SetAgent(opts *bind.TransactOpts, newAgent common.Address) (*types.Transaction, error) {
+	return t.contract.Transact(opts, "setAgent", newAgent)
+}
+
+// Pause sends pause().
+func (t *Twap) Pause(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return t.contract.Transact(opts, "pause")
+}
+
+// Unpause sends unpause().
+func (t *Twap) Unpause(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return t.contract.Transact(opts, "unpause")
+}
+
+// ConfigureStrategy sends configureStrategy(s).
+func (t *Twap) ConfigureStrategy(opts *bind.TransactOpts, s Strategy) (*types.Transaction, error) {
+	return t.contract.Transact(opts, "configureStrategy", s)
+}
+
+// ExecuteSlice sends executeSlice(sliceId).
+func (t *Twap) ExecuteSlice(opts *bind.TransactOpts, sliceId *big.Int) (*types.Transaction, error) {
+	return t.contract.Transact(opts, "executeSlice", sliceId)
+}
+
+func callUint(t *Twap, opts *bind.CallOpts, method string) (*big.Int, error) {
+	var out []interface{}
+	if err := t.contract.Call(opts, &out, method); err != nil {
+		return nil, err
+	}
+	return *abi.ConvertType(out[0], new(*big.Int)).(**big.Int), nil
+}
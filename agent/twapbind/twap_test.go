@@ -0,0 +1,122 @@
+package twapbind
+
+import (
+	"context"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// recordedStrategy is an eth_call result for strategy() on a USDC -> WETH vault.
+const recordedStrategy = "" +
+	"000000000000000000000000a0b86991c6218b36c1d19d4a2e9eb0ce3606eb48" +
+	"000000000000000000000000c02aaa39b223fe8d0a0e5c4f27ead9083c756cc2" +
+	"0000000000000000000000001111111111111111111111111111111111111111" +
+	"0000000000000000000000002222222222222222222222222222222222222222" +
+	"000000000000000000000000000000000000000000000000000000e8d4a51000" +
+	"000000000000000000000000000000000000000000000000000000174876e800" +
+	"000000000000000000000000000000000000000000000000000000006553f100" +
+	"0000000000000000000000000000000000000000000000000000000065554280" +
+	"0000000000000000000000000000000000000000000000000000000000000032" +
+	"00000000000000000000000000000000000000000000000000000000000000c8"
+
+// replayCaller answers every eth_call with the same return data.
+type replayCaller struct{ ret []byte }
+
+func (c replayCaller) CodeAt(context.Context, common.Address, *big.Int) ([]byte, error) {
+	return []byte{0x60, 0x80}, nil
+}
+
+func (c replayCaller) CallContract(context.Context, ethereum.CallMsg, *big.Int) ([]byte, error) {
+	return c.ret, nil
+}
+
+func TestStrategyDecodesRecordedPayload(t *testing.T) {
+	parsed, err := ParseABI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, _ := hex.DecodeString(recordedStrategy)
+	twap := NewTwap(common.Address{0xaa}, parsed, replayCaller{ret}, nil, nil)
+	s, err := twap.Strategy(&bind.CallOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Strategy{
+		TokenIn:              common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"),
+		TokenOut:             common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"),
+		Adapter:              common.HexToAddress("0x1111111111111111111111111111111111111111"),
+		PriceOracle:          common.HexToAddress("0x2222222222222222222222222222222222222222"),
+		TotalAmountIn:        big.NewInt(1_000_000_000_000),
+		SliceAmountIn:        big.NewInt(100_000_000_000),
+		StartTime:            big.NewInt(1_700_000_000),
+		EndTime:              big.NewInt(1_700_086_400),
+		MaxSlippageBps:       50,
+		MaxPriceDeviationBps: 200,
+	}
+	if s.TokenIn != want.TokenIn || s.TokenOut != want.TokenOut || s.Adapter != want.Adapter || s.PriceOracle != want.PriceOracle {
+		t.Errorf("addresses = %+v, want %+v", s, want)
+	}
+	for name, pair := range map[string][2]*big.Int{
+		"TotalAmountIn": {s.TotalAmountIn, want.TotalAmountIn},
+		"SliceAmountIn": {s.SliceAmountIn, want.SliceAmountIn},
+		"StartTime":     {s.StartTime, want.StartTime},
+		"EndTime":       {s.EndTime, want.EndTime},
+	} {
+		if pair[0] == nil || pair[0].Cmp(pair[1]) != 0 {
+			t.Errorf("%s = %v, want %v", name, pair[0], pair[1])
+		}
+	}
+	if s.MaxSlippageBps != want.MaxSlippageBps || s.MaxPriceDeviationBps != want.MaxPriceDeviationBps {
+		t.Errorf("bps = %d/%d, want %d/%d", s.MaxSlippageBps, s.MaxPriceDeviationBps, want.MaxSlippageBps, want.MaxPriceDeviationBps)
+	}
+}
+
+// An ABI whose strategy() has an output Strategy doesn't know about must fail
+// rather than silently drop it.
+func TestStrategyRejectsUnknownOutput(t *testing.T) {
+	const grown = `[{"type":"function","name":"strategy","stateMutability":"view","inputs":[],"outputs":[
+		{"name":"tokenIn","type":"address"},{"name":"tokenOut","type":"address"},
+		{"name":"adapter","type":"address"},{"name":"priceOracle","type":"address"},
+		{"name":"totalAmountIn","type":"uint256"},{"name":"sliceAmountIn","type":"uint256"},
+		{"name":"startTime","type":"uint256"},{"name":"endTime","type":"uint256"},
+		{"name":"maxSlippageBps","type":"uint16"},{"name":"maxPriceDeviationBps","type":"uint16"},
+		{"name":"minAmountOut","type":"uint256"}]}]`
+	parsed, err := abi.JSON(strings.NewReader(grown))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, _ := hex.DecodeString(recordedStrategy + strings.Repeat("0", 64))
+	if _, err := NewTwap(common.Address{0xaa}, parsed, replayCaller{ret}, nil, nil).Strategy(&bind.CallOpts{}); err == nil {
+		t.Fatal("decoded a strategy() with an unknown output")
+	}
+}
+
+func TestUnpackStrategyMatchesCall(t *testing.T) {
+	parsed, err := ParseABI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, _ := hex.DecodeString(recordedStrategy)
+	twap := NewTwap(common.Address{0xaa}, parsed, replayCaller{ret}, nil, nil)
+	called, err := twap.Strategy(&bind.CallOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unpacked, err := twap.UnpackStrategy(ret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unpacked.TokenOut != called.TokenOut || unpacked.EndTime.Cmp(called.EndTime) != 0 || unpacked.MaxPriceDeviationBps != called.MaxPriceDeviationBps {
+		t.Fatalf("UnpackStrategy = %+v, Strategy() = %+v", unpacked, called)
+	}
+	if _, err := twap.UnpackStrategy(ret[:64]); err == nil {
+		t.Fatal("decoded a truncated payload")
+	}
+}